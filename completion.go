@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// completionShells lists the shells which the completion command prints scripts for
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completionScripts are printed by the completion command. Each script runs the words
+// typed so far with --generate-bash-completion appended, so that dbmate itself lists the
+// completions, including the versions of the migration files.
+var completionScripts = map[string]string{
+	"bash": `# dbmate completion for bash, load with: source <(dbmate completion bash)
+_dbmate_completion() {
+  local cur words cword
+  COMPREPLY=()
+  if declare -F _init_completion >/dev/null 2>&1; then
+    _init_completion -n "=:" || return
+  else
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    words=("${COMP_WORDS[@]}")
+    cword=$COMP_CWORD
+  fi
+
+  local args=("${words[@]:0:$cword}")
+  if [[ "$cur" == "-"* ]]; then
+    args+=("$cur")
+  fi
+
+  local IFS=$'\n'
+  COMPREPLY=($(compgen -W "$("${args[@]}" --generate-bash-completion 2>/dev/null)" -- "$cur"))
+}
+
+complete -o bashdefault -o default -F _dbmate_completion dbmate
+`,
+	"zsh": `#compdef dbmate
+# dbmate completion for zsh, load with: source <(dbmate completion zsh)
+_dbmate() {
+  local -a opts
+  local cur=${words[-1]}
+  if [[ "$cur" == "-"* ]]; then
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} ${cur} --generate-bash-completion 2>/dev/null)}")
+  else
+    opts=("${(@f)$(${words[@]:0:#words[@]-1} --generate-bash-completion 2>/dev/null)}")
+  fi
+
+  if [[ "${opts[1]}" != "" ]]; then
+    _describe 'values' opts
+  else
+    _files
+  fi
+}
+
+compdef _dbmate dbmate
+`,
+	"fish": `# dbmate completion for fish, load with: dbmate completion fish | source
+function __dbmate_complete
+    set -l args (commandline -opc)
+    set -l cur (commandline -ct)
+    if string match -q -- '-*' $cur
+        set -a args $cur
+    end
+
+    set -l opts ($args --generate-bash-completion 2>/dev/null)
+    if test (count $opts) -gt 0
+        printf '%s\n' $opts
+    else
+        __fish_complete_path $cur
+    end
+end
+
+complete -c dbmate -f -a '(__dbmate_complete)'
+`,
+	"powershell": `# dbmate completion for PowerShell, load with:
+# dbmate completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName dbmate -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements |
+        Where-Object { $_.Extent.StartOffset -lt $cursorPosition } |
+        ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '' -and -not $wordToComplete.StartsWith('-')) {
+        $words = @($words | Select-Object -SkipLast 1)
+    }
+
+    $arguments = @($words | Select-Object -Skip 1) + '--generate-bash-completion'
+    & $words[0] @arguments 2>$null |
+        Where-Object { $_ -like "$wordToComplete*" } |
+        ForEach-Object { [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_) }
+}
+`,
+}
+
+// printCompletionScript prints the completion script for a shell
+func printCompletionScript(c *cli.Context) error {
+	shell := c.Args().First()
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell: %q (expected %s)", shell, strings.Join(completionShells, ", "))
+	}
+
+	_, err := fmt.Fprint(c.App.Writer, script)
+	return err
+}
+
+// completeShells completes the argument of the completion command
+func completeShells(c *cli.Context) {
+	if c.NArg() == 0 {
+		for _, shell := range completionShells {
+			fmt.Fprintln(c.App.Writer, shell)
+		}
+	}
+}
+
+// completeVersions returns a completion function which completes migration versions as
+// the value of the named flags, and as the first argument of the command if arg is true.
+// Otherwise, the flags of the command are completed after a dash.
+func completeVersions(arg bool, flags ...string) cli.BashCompleteFunc {
+	return func(c *cli.Context) {
+		// the word before the cursor is read from the arguments of the app, since flags
+		// without a value are not parsed
+		args := globalContext(c).Args().Slice()
+		lastArg := ""
+		if len(args) > 0 {
+			lastArg = args[len(args)-1]
+		}
+
+		if !strings.HasPrefix(lastArg, "-") {
+			if arg && c.NArg() == 0 {
+				printVersionCompletions(c)
+			}
+			return
+		}
+
+		for _, name := range flags {
+			if lastArg == "-"+name || lastArg == "--"+name {
+				printVersionCompletions(c)
+				return
+			}
+		}
+		printFlagCompletions(c, args, lastArg)
+	}
+}
+
+// printFlagCompletions prints the visible flags of the command which start with lastArg,
+// except those which were already given, like the default completion
+func printFlagCompletions(c *cli.Context, args []string, lastArg string) {
+	given := map[string]bool{}
+	for _, arg := range args {
+		given[arg] = true
+	}
+
+	cur := strings.TrimLeft(lastArg, "-")
+	for _, flag := range c.Command.Flags {
+		if visible, ok := flag.(cli.VisibleFlag); ok && !visible.IsVisible() {
+			continue
+		}
+		for _, name := range flag.Names() {
+			option := "--" + name
+			if len(name) == 1 {
+				if strings.HasPrefix(lastArg, "--") {
+					continue
+				}
+				option = "-" + name
+			}
+			if strings.HasPrefix(name, cur) && name != cur && !given[option] {
+				fmt.Fprintln(c.App.Writer, option)
+			}
+		}
+	}
+}
+
+// printVersionCompletions prints the version of each migration file, from the migrations
+// directory or the remote source, described by its file name in zsh
+func printVersionCompletions(c *cli.Context) {
+	db := dbmate.New(nil)
+	if err := configureMigrationFiles(c, db); err != nil {
+		return
+	}
+	migrations, err := db.MigrationFiles()
+	if err != nil {
+		return
+	}
+
+	describe := strings.HasSuffix(os.Getenv("SHELL"), "zsh")
+	for _, migration := range migrations {
+		if describe {
+			fmt.Fprintf(c.App.Writer, "%s:%s\n", migration.Version, migration.FileName)
+		} else {
+			fmt.Fprintln(c.App.Writer, migration.Version)
+		}
+	}
+}
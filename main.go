@@ -1,32 +1,96 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"os/exec"
 	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/bigquery"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/clickhouse"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/databricks"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/libsql"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/mysql"
 	_ "github.com/amacneil/dbmate/v2/pkg/driver/postgres"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/redshift"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/spanner"
+	"github.com/amacneil/dbmate/v2/pkg/envfile"
+	"github.com/amacneil/dbmate/v2/pkg/lock"
+	"github.com/amacneil/dbmate/v2/pkg/remotefs"
+	"github.com/amacneil/dbmate/v2/pkg/server"
+	"github.com/amacneil/dbmate/v2/pkg/sshtunnel"
+	"github.com/amacneil/dbmate/v2/pkg/tui"
 )
 
 func main() {
-	loadDotEnv()
-
 	app := NewApp()
+	loadEnvFiles(app, os.Args[1:])
+
 	err := app.Run(os.Args)
 
 	if err != nil {
-		errText := redactLogString(fmt.Sprintf("Error: %s\n", err))
+		errText := dbutil.RedactPasswords(fmt.Sprintf("Error: %s\n", err))
 		_, _ = fmt.Fprint(os.Stderr, errText)
-		os.Exit(2)
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes, which are stable so that scripts can tell the cause of a failure apart
+// without parsing error messages
+const (
+	// exitPending means there are pending migrations (status --exit-code), or the
+	// migrations table needs repairing (repair)
+	exitPending = 1
+	// exitError means the command failed for any reason not listed below
+	exitError = 2
+	// exitCantConnect means the database could not be connected to
+	exitCantConnect = 3
+	// exitMigrationFailed means a migration could not be applied or rolled back, or
+	// failed validation (validate, verify-down)
+	exitMigrationFailed = 4
+	// exitDrift means the database schema differs from the schema file (drift), or an
+	// applied migration file was modified (status --fail-on-modified)
+	exitDrift = 5
+	// exitLockHeld means a migration timed out waiting for a lock held by another session,
+	// or for the --lock-url lock held by another run
+	exitLockHeld = 6
+)
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	var exitErr cli.ExitCoder
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	case errors.Is(err, dbmate.ErrLockHeld):
+		return exitLockHeld
+	case errors.Is(err, dbmate.ErrCantConnect):
+		return exitCantConnect
+	case errors.Is(err, dbmate.ErrMigrationFailed):
+		return exitMigrationFailed
+	case errors.Is(err, dbmate.ErrMigrationModified):
+		return exitDrift
 	}
+
+	return exitError
 }
 
 // NewApp creates a new command line app
@@ -35,19 +99,43 @@ func NewApp() *cli.App {
 	app.Name = "dbmate"
 	app.Usage = "A lightweight, framework-independent database migration tool."
 	app.Version = dbmate.Version
+	app.EnableBashCompletion = true
+	// the built in version flag is aliased to -v, which is short for --verbose, so the
+	// app defines its own --version flag instead
+	app.HideVersion = true
 
 	defaultDB := dbmate.New(nil)
 	app.Flags = []cli.Flag{
-		&cli.StringFlag{
+		&cli.GenericFlag{
 			Name:    "url",
 			Aliases: []string{"u"},
-			Usage:   "specify the database URL",
+			Value:   &stringList{},
+			Usage:   "specify the database URL (repeat to apply migrations to several databases with migrate and up)",
+		},
+		&cli.IntFlag{
+			Name:    "parallel",
+			EnvVars: []string{"DBMATE_PARALLEL"},
+			Usage:   "number of shards or databases to migrate at once",
+		},
+		&cli.StringFlag{
+			Name:    "status-url",
+			EnvVars: []string{"DBMATE_STATUS_URL"},
+			Usage:   "run status and drift against this read replica of the database (postgres only)",
 		},
 		&cli.StringFlag{
 			Name:    "env",
 			Aliases: []string{"e"},
 			Value:   "DATABASE_URL",
-			Usage:   "specify an environment variable containing the database URL",
+			Usage:   "specify an environment variable containing the database URL, or an environment name to read DATABASE_URL_<NAME>",
+		},
+		&cli.StringFlag{
+			Name:    "environment",
+			EnvVars: []string{"DBMATE_ENVIRONMENT"},
+			Usage:   "the environment being migrated, which migrations annotated with env:<names> must list (defaults to the --env environment name)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "env-file",
+			Usage: "load environment variables from this file instead of .env and .env.local (may be repeated)",
 		},
 		&cli.StringSliceFlag{
 			Name:    "migrations-dir",
@@ -56,12 +144,28 @@ func NewApp() *cli.App {
 			Value:   cli.NewStringSlice(defaultDB.MigrationsDir[0]),
 			Usage:   "specify the directory containing migration files",
 		},
+		&cli.StringFlag{
+			Name:    "migrations-url",
+			EnvVars: []string{"DBMATE_MIGRATIONS_URL"},
+			Usage:   "read migration files from s3://bucket/prefix, gs://bucket/prefix, or an https:// URL instead of --migrations-dir",
+		},
 		&cli.StringFlag{
 			Name:    "migrations-table",
 			EnvVars: []string{"DBMATE_MIGRATIONS_TABLE"},
 			Value:   defaultDB.MigrationsTableName,
 			Usage:   "specify the database table to record migrations in",
 		},
+		&cli.BoolFlag{
+			Name:    "create-missing-schema",
+			EnvVars: []string{"DBMATE_CREATE_MISSING_SCHEMA"},
+			Value:   defaultDB.CreateMissingSchema,
+			Usage:   "create the schema containing the migrations table if it does not exist",
+		},
+		&cli.BoolFlag{
+			Name:    "audit",
+			EnvVars: []string{"DBMATE_AUDIT"},
+			Usage:   "record each migration run in the <migrations table>_audit table",
+		},
 		&cli.StringFlag{
 			Name:    "schema-file",
 			Aliases: []string{"s"},
@@ -69,11 +173,72 @@ func NewApp() *cli.App {
 			Value:   defaultDB.SchemaFile,
 			Usage:   "specify the schema file location",
 		},
+		&cli.StringFlag{
+			Name:    "schema-format",
+			EnvVars: []string{"DBMATE_SCHEMA_FORMAT"},
+			Value:   defaultDB.SchemaFormat,
+			Usage:   "write the schema to a single file, or a directory with one file per object (file or directory)",
+		},
+		&cli.StringFlag{
+			Name:    "schema-header",
+			EnvVars: []string{"DBMATE_SCHEMA_HEADER"},
+			Usage:   "add a comment with the dbmate and server versions to the schema file (full, or reproducible to omit the dump time)",
+		},
+		&cli.StringFlag{
+			Name:    "schema-migrations",
+			EnvVars: []string{"DBMATE_SCHEMA_MIGRATIONS"},
+			Value:   defaultDB.SchemaMigrations,
+			Usage:   "where to write the schema migrations section of the schema file (include, omit, or separate)",
+		},
+		&cli.StringFlag{
+			Name:    "schema-migrations-file",
+			EnvVars: []string{"DBMATE_SCHEMA_MIGRATIONS_FILE"},
+			Value:   defaultDB.SchemaMigrationsFile,
+			Usage:   "specify the schema migrations file location, used with --schema-migrations separate",
+		},
+		&cli.BoolFlag{
+			Name:    "format-schema",
+			EnvVars: []string{"DBMATE_FORMAT_SCHEMA"},
+			Usage:   "canonicalize keyword case, indentation, and trailing semicolons of the schema file",
+		},
+		&cli.StringFlag{
+			Name:    "version-format",
+			EnvVars: []string{"DBMATE_VERSION_FORMAT"},
+			Value:   defaultDB.VersionFormat,
+			Usage:   "format of new migration versions, which existing versions must match (timestamp, unix, sequential, or uuidv7)",
+		},
 		&cli.BoolFlag{
 			Name:    "no-dump-schema",
 			EnvVars: []string{"DBMATE_NO_DUMP_SCHEMA"},
 			Usage:   "don't update the schema file on migrate/rollback",
 		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			EnvVars: []string{"DBMATE_QUIET"},
+			Usage:   "only print warnings and errors (same as --log-level warn)",
+		},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "print the result of each statement, or with -vv, also each statement and its duration (same as --log-level debug)",
+		},
+		veryVerboseFlag(),
+		&cli.BoolFlag{
+			Name:    "no-progress",
+			EnvVars: []string{"DBMATE_NO_PROGRESS"},
+			Usage:   "don't report progress when applying many migrations",
+		},
+		&cli.StringFlag{
+			Name:    "log-level",
+			EnvVars: []string{"DBMATE_LOG_LEVEL"},
+			Usage:   "most verbose messages to print (" + strings.Join(dbmate.LogLevels, ", ") + ")",
+		},
+		&cli.DurationFlag{
+			Name:    "log-slow",
+			EnvVars: []string{"DBMATE_LOG_SLOW"},
+			Usage:   "log each statement which takes longer than this to execute, e.g. 5s (0 to disable)",
+		},
 		&cli.BoolFlag{
 			Name:    "wait",
 			EnvVars: []string{"DBMATE_WAIT"},
@@ -85,6 +250,111 @@ func NewApp() *cli.App {
 			Usage:   "timeout for --wait flag",
 			Value:   defaultDB.WaitTimeout,
 		},
+		&cli.StringSliceFlag{
+			Name:    "wait-fail-on",
+			EnvVars: []string{"DBMATE_WAIT_FAIL_ON"},
+			Usage:   "connection failures which stop --wait instead of being retried (" + strings.Join(dbmate.WaitFailures, ", ") + ", or none)",
+			Value:   cli.NewStringSlice(defaultDB.WaitFailOn...),
+		},
+		&cli.StringFlag{
+			Name:    "health-addr",
+			EnvVars: []string{"DBMATE_HEALTH_ADDR"},
+			Usage:   "serve /healthz and /readyz on this address while the command runs (e.g. :8089)",
+		},
+		&cli.DurationFlag{
+			Name:    "statement-timeout",
+			EnvVars: []string{"DBMATE_STATEMENT_TIMEOUT"},
+			Usage:   "maximum time a single statement may run (postgres and mysql only)",
+		},
+		&cli.DurationFlag{
+			Name:    "lock-timeout",
+			EnvVars: []string{"DBMATE_LOCK_TIMEOUT"},
+			Usage:   "maximum time a statement may wait to acquire a lock (postgres and mysql only)",
+		},
+		&cli.StringFlag{
+			Name:    "lock-url",
+			EnvVars: []string{"DBMATE_LOCK_URL"},
+			Usage:   "hold a lock in redis, etcd, or dynamodb while migrating, so concurrent runs wait (e.g. redis://host:6379/0?key=app)",
+		},
+		&cli.GenericFlag{
+			Name:  "session-setup",
+			Value: &stringList{},
+			Usage: "SQL statement to execute on each database connection before running commands (repeatable)",
+		},
+		&cli.DurationFlag{
+			Name:    "operation-timeout",
+			EnvVars: []string{"DBMATE_OPERATION_TIMEOUT"},
+			Usage:   "maximum time to wait for a long running schema change or query job (spanner, bigquery, and tidb)",
+		},
+		&cli.DurationFlag{
+			Name:    "operation-poll-interval",
+			EnvVars: []string{"DBMATE_OPERATION_POLL_INTERVAL"},
+			Usage:   "how often to report the progress of a long running schema change",
+			Value:   defaultDB.OperationPollInterval,
+		},
+		&cli.IntFlag{
+			Name:    "retries",
+			EnvVars: []string{"DBMATE_RETRIES"},
+			Usage:   "retry migrations which fail with a transient error up to this many times",
+		},
+		&cli.DurationFlag{
+			Name:    "retry-interval",
+			EnvVars: []string{"DBMATE_RETRY_INTERVAL"},
+			Usage:   "initial delay between retries, doubled after each attempt",
+			Value:   defaultDB.MigrationRetryInterval,
+		},
+		&cli.StringFlag{
+			Name:    "golang-migrate-table",
+			EnvVars: []string{"DBMATE_GOLANG_MIGRATE_TABLE"},
+			Usage:   "keep a golang-migrate table in sync with applied migrations",
+		},
+		&cli.Int64Flag{
+			Name:    "stream-threshold",
+			EnvVars: []string{"DBMATE_STREAM_THRESHOLD"},
+			Usage:   "stream migration files larger than this many bytes one statement at a time (0 to disable)",
+		},
+		&cli.BoolFlag{
+			Name:    "savepoints",
+			EnvVars: []string{"DBMATE_SAVEPOINTS"},
+			Usage:   "run each statement of a transactional migration in its own savepoint, to report which statement failed (postgres only)",
+		},
+		&cli.BoolFlag{
+			Name:    "replication-safe",
+			EnvVars: []string{"DBMATE_REPLICATION_SAFE"},
+			Usage:   "check that logical replication slots and subscriptions are healthy before each migration (postgres only)",
+		},
+		&cli.Int64Flag{
+			Name:    "replication-max-lag",
+			EnvVars: []string{"DBMATE_REPLICATION_MAX_LAG"},
+			Usage:   "maximum bytes a logical replication slot may lag behind with --replication-safe (0 for no limit)",
+		},
+		&cli.StringFlag{
+			Name:    "replication-role",
+			EnvVars: []string{"DBMATE_REPLICATION_ROLE"},
+			Usage:   "set session_replication_role while migrating, e.g. replica (postgres only)",
+		},
+		&cli.StringFlag{
+			Name:    "ssh-tunnel",
+			EnvVars: []string{"DBMATE_SSH_TUNNEL"},
+			Usage:   "connect to the database through an ssh bastion host (ssh://user@host:port)",
+		},
+		&cli.BoolFlag{
+			Name:  "version",
+			Usage: "print the version",
+		},
+	}
+
+	// the app only runs when no command is given
+	app.Action = func(c *cli.Context) error {
+		if c.Bool("version") {
+			cli.ShowVersion(c)
+			return nil
+		}
+		if c.Args().Present() {
+			return cli.ShowCommandHelp(c, c.Args().First())
+		}
+
+		return cli.ShowAppHelp(c)
 	}
 
 	app.Commands = []*cli.Command{
@@ -92,9 +362,55 @@ func NewApp() *cli.App {
 			Name:    "new",
 			Aliases: []string{"n"},
 			Usage:   "Generate a new migration file",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "up-sql",
+					Aliases: []string{"up"},
+					Usage:   "SQL for the up block (or - to read from stdin), used to generate a down block",
+				},
+				&cli.StringFlag{
+					Name:  "down-sql",
+					Usage: "SQL for the down block, instead of generating it from the up block",
+				},
+				&cli.BoolFlag{
+					Name:  "stdin",
+					Usage: "read the migration (or its up block) from stdin",
+				},
+				&cli.BoolFlag{
+					Name:  "from-clipboard",
+					Usage: "read the migration (or its up block) from the clipboard",
+				},
+				&cli.BoolFlag{
+					Name:  "edit",
+					Usage: "open the new migration with $VISUAL or $EDITOR",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				path, err := newMigration(db, c, os.Stdin)
+				if err != nil {
+					return err
+				}
+				if c.Bool("edit") {
+					return openEditor(path)
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:  "generate",
+			Usage: "Generate a new migration from declarative table definitions",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "schema-dir",
+					EnvVars: []string{"DBMATE_SCHEMA_DIR"},
+					Usage:   "directory containing create table statements",
+					Value:   defaultDB.SchemaDir,
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
-				name := c.Args().First()
-				return db.NewMigration(name)
+				db.SchemaDir = c.String("schema-dir")
+				return db.Generate(c.Args().First())
 			}),
 		},
 		{
@@ -106,18 +422,54 @@ func NewApp() *cli.App {
 					EnvVars: []string{"DBMATE_STRICT"},
 					Usage:   "fail if migrations would be applied out of order",
 				},
+				&cli.BoolFlag{
+					Name:    "strict-ddl",
+					EnvVars: []string{"DBMATE_STRICT_DDL"},
+					Usage:   "fail if migrations contain DDL which the database cannot roll back",
+				},
+				&cli.BoolFlag{
+					Name:    "require-down",
+					EnvVars: []string{"DBMATE_REQUIRE_DOWN"},
+					Usage:   "fail if a pending migration has an empty down block which is not marked irreversible",
+				},
+				&cli.BoolFlag{
+					Name:    "adopt",
+					EnvVars: []string{"DBMATE_ADOPT"},
+					Usage:   "convert a migrations table created by golang-migrate or flyway, importing its history",
+				},
+				&cli.StringSliceFlag{
+					Name:    "skip",
+					EnvVars: []string{"DBMATE_SKIP"},
+					Usage:   "record the pending migration with this version as skipped instead of applying it (may be repeated)",
+				},
+				&cli.StringFlag{
+					Name:    "phase",
+					EnvVars: []string{"DBMATE_PHASE"},
+					Usage:   "apply only pending migrations in this phase (expand or contract)",
+				},
+				&cli.StringSliceFlag{
+					Name:    "tags",
+					EnvVars: []string{"DBMATE_TAGS"},
+					Usage:   "apply only pending migrations with one of these tags, and none prefixed with ! (e.g. data or !slow)",
+				},
 				&cli.BoolFlag{
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					EnvVars: []string{"DBMATE_VERBOSE"},
-					Usage:   "print the result of each statement execution",
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
 				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.SkipVersions = c.StringSlice("skip")
 				db.Strict = c.Bool("strict")
-				db.Verbose = c.Bool("verbose")
+				db.StrictDDL = c.Bool("strict-ddl")
+				db.RequireDown = c.Bool("require-down")
+				db.Adopt = c.Bool("adopt")
+				db.Phase = c.String("phase")
+				db.Tags = c.StringSlice("tags")
 				return db.CreateAndMigrate()
 			}),
+			BashComplete: completeVersions(false, "skip"),
 		},
 		{
 			Name:  "create",
@@ -142,18 +494,117 @@ func NewApp() *cli.App {
 					EnvVars: []string{"DBMATE_STRICT"},
 					Usage:   "fail if migrations would be applied out of order",
 				},
+				&cli.BoolFlag{
+					Name:    "strict-ddl",
+					EnvVars: []string{"DBMATE_STRICT_DDL"},
+					Usage:   "fail if migrations contain DDL which the database cannot roll back",
+				},
+				&cli.BoolFlag{
+					Name:    "require-down",
+					EnvVars: []string{"DBMATE_REQUIRE_DOWN"},
+					Usage:   "fail if a pending migration has an empty down block which is not marked irreversible",
+				},
+				&cli.BoolFlag{
+					Name:    "adopt",
+					EnvVars: []string{"DBMATE_ADOPT"},
+					Usage:   "convert a migrations table created by golang-migrate or flyway, importing its history",
+				},
+				&cli.StringSliceFlag{
+					Name:    "skip",
+					EnvVars: []string{"DBMATE_SKIP"},
+					Usage:   "record the pending migration with this version as skipped instead of applying it (may be repeated)",
+				},
 				&cli.BoolFlag{
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					EnvVars: []string{"DBMATE_VERBOSE"},
-					Usage:   "print the result of each statement execution",
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+				&cli.StringFlag{
+					Name:  "single",
+					Usage: "apply only the pending migration with this version",
+				},
+				&cli.StringFlag{
+					Name:    "phase",
+					EnvVars: []string{"DBMATE_PHASE"},
+					Usage:   "apply only pending migrations in this phase (expand or contract)",
+				},
+				&cli.StringSliceFlag{
+					Name:    "tags",
+					EnvVars: []string{"DBMATE_TAGS"},
+					Usage:   "apply only pending migrations with one of these tags, and none prefixed with ! (e.g. data or !slow)",
 				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.SkipVersions = c.StringSlice("skip")
 				db.Strict = c.Bool("strict")
-				db.Verbose = c.Bool("verbose")
+				db.StrictDDL = c.Bool("strict-ddl")
+				db.RequireDown = c.Bool("require-down")
+				db.Adopt = c.Bool("adopt")
+				db.Phase = c.String("phase")
+				db.Tags = c.StringSlice("tags")
+				if version := c.String("single"); version != "" {
+					return db.MigrateVersion(version)
+				}
 				return db.Migrate()
 			}),
+			BashComplete: completeVersions(false, "single", "skip"),
+		},
+		{
+			Name:  "plan",
+			Usage: "Record the pending migrations and their checksums in a plan, to be approved and applied with apply",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "write the plan to this file instead of stdout",
+				},
+				&cli.StringFlag{
+					Name:    "plan-key",
+					EnvVars: []string{"DBMATE_PLAN_KEY"},
+					Usage:   "secret key used to sign the plan",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.PlanKey = []byte(c.String("plan-key"))
+				plan, err := db.Plan()
+				if err != nil {
+					return err
+				}
+
+				return writePlan(db.Log, c.String("output"), plan)
+			}),
+		},
+		{
+			Name:  "apply",
+			Usage: "Apply the migrations of a plan, if the migrations and database have not changed since it was created",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "plan",
+					Usage:    "plan file written by the plan command",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "plan-key",
+					EnvVars: []string{"DBMATE_PLAN_KEY"},
+					Usage:   "secret key used to verify the plan's signature",
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.PlanKey = []byte(c.String("plan-key"))
+				plan, err := readPlan(c.String("plan"))
+				if err != nil {
+					return err
+				}
+
+				return db.ApplyPlan(plan)
+			}),
 		},
 		{
 			Name:    "rollback",
@@ -164,14 +615,43 @@ func NewApp() *cli.App {
 					Name:    "verbose",
 					Aliases: []string{"v"},
 					EnvVars: []string{"DBMATE_VERBOSE"},
-					Usage:   "print the result of each statement execution",
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "rollback every applied migration, most recent first",
+				},
+				&cli.BoolFlag{
+					Name:    "strict-ddl",
+					EnvVars: []string{"DBMATE_STRICT_DDL"},
+					Usage:   "fail if migrations contain DDL which the database cannot roll back",
 				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
-				db.Verbose = c.Bool("verbose")
+				db.StrictDDL = c.Bool("strict-ddl")
+				if c.Bool("all") {
+					return db.RollbackAll()
+				}
 				return db.Rollback()
 			}),
 		},
+		{
+			Name:      "redo",
+			Usage:     "Rollback the most recent (or specified) migration, then apply it again",
+			ArgsUsage: "[VERSION]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Redo(c.Args().First())
+			}),
+			BashComplete: completeVersions(true),
+		},
 		{
 			Name:  "status",
 			Usage: "List applied and pending migrations",
@@ -184,8 +664,32 @@ func NewApp() *cli.App {
 					Name:  "quiet",
 					Usage: "don't output any text (implies --exit-code)",
 				},
+				&cli.BoolFlag{
+					Name:  "all-envs",
+					Usage: "show the status of every environment configured with DATABASE_URL_<NAME>",
+				},
+				&cli.BoolFlag{
+					Name:  "pending-files",
+					Usage: "print only the paths of pending migration files, one per line",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print pending migration files as a JSON array (requires --pending-files)",
+				},
+				&cli.StringSliceFlag{
+					Name:    "skip",
+					EnvVars: []string{"DBMATE_SKIP"},
+					Usage:   "show as skipped the pending migration with this version (may be repeated)",
+				},
+				&cli.BoolFlag{
+					Name:    "fail-on-modified",
+					EnvVars: []string{"DBMATE_FAIL_ON_MODIFIED"},
+					Usage:   "fail if an applied migration file no longer matches the checksum recorded by --audit",
+				},
 			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				db.SkipVersions = c.StringSlice("skip")
+				db.FailOnModified = c.Bool("fail-on-modified")
 				db.Strict = c.Bool("strict")
 				setExitCode := c.Bool("exit-code")
 				quiet := c.Bool("quiet")
@@ -193,86 +697,1243 @@ func NewApp() *cli.App {
 					setExitCode = true
 				}
 
-				pending, err := db.Status(quiet)
+				if c.Bool("json") && !c.Bool("pending-files") {
+					return errors.New("--json requires --pending-files")
+				}
+
+				if c.Bool("all-envs") {
+					if c.Bool("pending-files") {
+						return errors.New("--pending-files cannot be combined with --all-envs")
+					}
+					return statusAllEnvironments(db, quiet, setExitCode)
+				}
+
+				var pending int
+				var err error
+				if c.Bool("pending-files") {
+					pending, err = statusPendingFiles(db, c.Bool("json"))
+				} else {
+					pending, err = db.Status(quiet)
+				}
 				if err != nil {
 					return err
 				}
 
 				if pending > 0 && setExitCode {
-					return cli.Exit("", 1)
+					return cli.Exit("", exitPending)
 				}
 
 				return nil
 			}),
+			BashComplete: completeVersions(false, "skip"),
 		},
 		{
-			Name:  "dump",
-			Usage: "Write the database schema to disk",
+			Name:  "history",
+			Usage: "List applied migrations with when they were applied, how long they took, and their checksums",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "limit",
+					Usage: "list only the latest N applied migrations",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the applied migrations as a JSON array",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
-				return db.DumpSchema()
+				entries, err := db.History(c.Int("limit"))
+				if err != nil {
+					return err
+				}
+
+				return printHistory(db.Log, entries, c.Bool("json"))
 			}),
 		},
 		{
-			Name:  "wait",
-			Usage: "Wait for the database to become available",
+			Name:      "import-history",
+			Usage:     "Mark migrations as applied using the history of another migration tool",
+			ArgsUsage: " ",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "from",
+					Usage:    "migration tool to import history from (flyway, golang-migrate)",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "table",
+					Usage: "history table name (defaults to the tool's default table)",
+				},
+			},
 			Action: action(func(db *dbmate.DB, c *cli.Context) error {
-				return db.Wait()
+				return db.ImportHistory(c.String("from"), c.String("table"))
 			}),
 		},
-	}
+		{
+			Name:  "fix-order",
+			Usage: "Renumber pending migrations which would be applied out of order",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the files which would be renamed without renaming them",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				_, err := db.FixOrder(c.Bool("dry-run"))
+				return err
+			}),
+		},
+		{
+			Name:  "archive",
+			Usage: "Move applied migration files created before a date out of the migrations directory",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "before",
+					Usage:    "archive migrations created before this date (YYYY-MM-DD, UTC)",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory to move archived files to (defaults to an archive subdirectory of each migrations directory)",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the files which would be archived without moving them",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				before, err := time.Parse(time.DateOnly, c.String("before"))
+				if err != nil {
+					return fmt.Errorf("invalid --before date, expected YYYY-MM-DD: %s", c.String("before"))
+				}
 
-	return app
-}
+				_, err = db.Archive(before, c.String("dir"), c.Bool("dry-run"))
+				return err
+			}),
+		},
+		{
+			Name:  "repair",
+			Usage: "Check the migrations table against objects declared with migrate:expect annotations",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "fix",
+					Usage: "record migrations whose objects exist, and remove records of migrations whose objects do not",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the result of each checked migration as a JSON array",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				out := db.Log
+				if c.Bool("json") {
+					// keep progress messages out of the JSON output
+					db.Log = io.Discard
+				}
 
-// load environment variables from .env file
-func loadDotEnv() {
-	if _, err := os.Stat(".env"); err != nil {
-		return
-	}
+				results, err := db.Repair(c.Bool("fix"))
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					if err := json.NewEncoder(out).Encode(results); err != nil {
+						return err
+					}
+				}
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatalf("Error loading .env file: %s", err.Error())
-	}
-}
+				for _, result := range results {
+					if result.Action == dbmate.RepairActionPartial ||
+						(!c.Bool("fix") && result.Action != dbmate.RepairActionNone) {
+						return cli.Exit("", exitPending)
+					}
+				}
 
-// action wraps a cli.ActionFunc with dbmate initialization logic
-func action(f func(*dbmate.DB, *cli.Context) error) cli.ActionFunc {
-	return func(c *cli.Context) error {
-		u, err := getDatabaseURL(c)
-		if err != nil {
-			return err
-		}
-		db := dbmate.New(u)
-		db.AutoDumpSchema = !c.Bool("no-dump-schema")
-		db.MigrationsDir = c.StringSlice("migrations-dir")
-		db.MigrationsTableName = c.String("migrations-table")
-		db.SchemaFile = c.String("schema-file")
-		db.WaitBefore = c.Bool("wait")
-		waitTimeout := c.Duration("wait-timeout")
-		if waitTimeout != 0 {
+				return nil
+			}),
+		},
+		{
+			Name:  "explain-locks",
+			Usage: "Show the locks taken by each statement in pending migrations (postgres only)",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.ExplainLocks()
+			}),
+		},
+		{
+			Name:    "truncate",
+			Aliases: []string{"reset-data"},
+			Usage:   "Delete all data, keeping the schema and applied migrations",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Truncate()
+			}),
+		},
+		{
+			Name:  "snapshot",
+			Usage: "Save the database as a named snapshot, or restore it (postgres and sqlite)",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "save",
+					Usage:     "Save a copy of the database as a snapshot, replacing any snapshot with the same name",
+					ArgsUsage: "NAME",
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						return db.SaveSnapshot(c.Args().First())
+					}),
+				},
+				{
+					Name:      "restore",
+					Usage:     "Replace the database with a saved snapshot",
+					ArgsUsage: "NAME",
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						return db.RestoreSnapshot(c.Args().First())
+					}),
+				},
+			},
+		},
+		{
+			Name:  "dump",
+			Usage: "Write the database schema to disk",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.DumpSchema()
+			}),
+		},
+		{
+			Name:  "load",
+			Usage: "Load the schema file into the database",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.LoadSchema()
+			}),
+		},
+		{
+			Name:  "clone-schema",
+			Usage: "Copy the schema of the database into a new database",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "target-url",
+					Usage:    "URL of the database to create and load the schema into",
+					Required: true,
+				},
+				&cli.BoolFlag{
+					Name:  "with-history",
+					Usage: "also copy the applied migrations",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				target, err := url.Parse(c.String("target-url"))
+				if err != nil {
+					return err
+				}
+
+				return db.CloneSchema(target, c.Bool("with-history"))
+			}),
+		},
+		{
+			Name:  "drift",
+			Usage: "Check whether the database schema differs from the schema file",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				drifted, err := db.Drift()
+				if err != nil {
+					return err
+				}
+
+				if drifted {
+					return cli.Exit("", exitDrift)
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:  "validate",
+			Usage: "Run pending migrations in a transaction which is rolled back, reporting every failing statement (postgres only)",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the failing statements as a JSON array",
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				out := db.Log
+				if c.Bool("json") {
+					// keep progress messages out of the JSON output
+					db.Log = io.Discard
+				}
+
+				failures, err := db.Validate()
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					if err := json.NewEncoder(out).Encode(failures); err != nil {
+						return err
+					}
+				}
+
+				if len(failures) > 0 {
+					return cli.Exit("", exitMigrationFailed)
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:  "verify-down",
+			Usage: "Apply, roll back, and reapply each pending migration to check its down block (use a throwaway database)",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the result of each migration as a JSON array",
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				out := db.Log
+				if c.Bool("json") {
+					// keep progress messages out of the JSON output
+					db.Log = io.Discard
+				}
+
+				results, err := db.VerifyDown()
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					if err := json.NewEncoder(out).Encode(results); err != nil {
+						return err
+					}
+				}
+
+				for _, result := range results {
+					if !result.Passed {
+						return cli.Exit("", exitMigrationFailed)
+					}
+				}
+
+				return nil
+			}),
+		},
+		{
+			Name:      "exec",
+			Usage:     "Run SQL statements from a file (or - for stdin) using the database connection",
+			ArgsUsage: "[FILE]",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "command",
+					Aliases: []string{"c"},
+					Usage:   "run the SQL statements in this string instead of a file",
+				},
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "print the result of each statement (-vv also prints each statement and its duration)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				command, path := c.String("command"), c.Args().First()
+
+				switch {
+				case command != "" && path != "":
+					return errors.New("specify either a file or --command, not both")
+				case command != "":
+					return db.Exec(strings.NewReader(command), os.Stdout)
+				case path == "-":
+					return db.Exec(os.Stdin, os.Stdout)
+				case path != "":
+					file, err := os.Open(path)
+					if err != nil {
+						return err
+					}
+					defer file.Close()
+					return db.Exec(file, os.Stdout)
+				default:
+					return errors.New("please specify a file or --command")
+				}
+			}),
+		},
+		{
+			Name:  "serve",
+			Usage: "Serve an HTTP API to run status, up, rollback, and dump",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "listen",
+					Value:   ":8080",
+					EnvVars: []string{"DBMATE_SERVE_LISTEN"},
+					Usage:   "address to listen on",
+				},
+				&cli.StringFlag{
+					Name:    "token",
+					EnvVars: []string{"DBMATE_SERVE_TOKEN"},
+					Usage:   "bearer token required to call the API",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				handler, err := server.New(db, c.String("token"))
+				if err != nil {
+					return err
+				}
+
+				srv := &http.Server{
+					Addr:              c.String("listen"),
+					Handler:           handler,
+					ReadHeaderTimeout: 10 * time.Second,
+				}
+				fmt.Fprintf(db.Log, "Listening on %s\n", srv.Addr)
+				return srv.ListenAndServe()
+			}),
+		},
+		{
+			Name:  "tui",
+			Usage: "Browse migrations in an interactive terminal interface, and apply or rollback them",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:    "verbose",
+					Aliases: []string{"v"},
+					EnvVars: []string{"DBMATE_VERBOSE"},
+					Usage:   "show the result of each statement (-vv also shows each statement and its duration)",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return tui.Run(db, os.Stdin, os.Stdout)
+			}),
+		},
+		{
+			Name:  "auth",
+			Usage: "Manage the database password saved in the OS credential store",
+			Subcommands: []*cli.Command{
+				{
+					Name:  "set",
+					Usage: "Save the password for the database URL (read from stdin, or prompted for)",
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						password, err := readPassword()
+						if err != nil {
+							return err
+						}
+						if password == "" {
+							return errors.New("password is empty")
+						}
+						if err := db.SetKeyringPassword(password); err != nil {
+							return err
+						}
+
+						account, _ := db.KeyringAccount()
+						fmt.Fprintf(db.Log, "Saved password for %s\n", account)
+						fmt.Fprintf(db.Log, "Add ?%s to the database URL to use it\n", dbmate.PasswordKeyringParam)
+						return nil
+					}),
+				},
+				{
+					Name:  "get",
+					Usage: "Print the password saved for the database URL",
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						password, err := db.KeyringPassword()
+						if err != nil {
+							return err
+						}
+
+						fmt.Fprintln(db.Log, password)
+						return nil
+					}),
+				},
+				{
+					Name:  "delete",
+					Usage: "Remove the password saved for the database URL",
+					Action: action(func(db *dbmate.DB, c *cli.Context) error {
+						return db.DeleteKeyringPassword()
+					}),
+				},
+			},
+		},
+		{
+			Name:  "info",
+			Usage: "Print the configuration resolved from options, environment variables, and .env files",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the configuration as a JSON object",
+				},
+			},
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				info, err := db.Info()
+				if err != nil {
+					return err
+				}
+
+				return printInfo(db.Log, configInfo{
+					Info:              info,
+					DatabaseURLSource: databaseURLSource(c),
+					EnvFiles:          loadedEnvFiles(c),
+				}, c.Bool("json"))
+			}),
+		},
+		{
+			Name:  "wait",
+			Usage: "Wait for the database to become available",
+			Action: action(func(db *dbmate.DB, c *cli.Context) error {
+				return db.Wait()
+			}),
+		},
+		{
+			Name:         "completion",
+			Usage:        "Print a shell completion script (" + strings.Join(completionShells, ", ") + ")",
+			ArgsUsage:    "SHELL",
+			Action:       printCompletionScript,
+			BashComplete: completeShells,
+		},
+	}
+	for _, cmd := range app.Commands {
+		if hasFlag(cmd.Flags, "verbose") {
+			cmd.Flags = append(cmd.Flags, veryVerboseFlag())
+		}
+	}
+
+	return app
+}
+
+// loadEnvFiles loads environment variables from the files given with --env-file, or
+// from .env and .env.local. Files are loaded before the app runs, so that variables
+// they define can configure flags.
+func loadEnvFiles(app *cli.App, args []string) {
+	if err := envfile.Load(envFileArgs(app, args)...); err != nil {
+		log.Fatalf("Error loading environment file: %s", err.Error())
+	}
+}
+
+// envFileArgs returns the values of --env-file in the global options of args
+func envFileArgs(app *cli.App, args []string) []string {
+	boolFlags := map[string]bool{}
+	for _, f := range app.Flags {
+		if _, ok := f.(*cli.BoolFlag); ok {
+			for _, name := range f.Names() {
+				boolFlags[name] = true
+			}
+		}
+	}
+
+	files := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "--" {
+			// the command ends the global options
+			break
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !hasValue && !boolFlags[name] && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if name == "env-file" {
+			files = append(files, value)
+		}
+	}
+
+	return files
+}
+
+// veryVerboseFlag returns the -vv flag, which is the same as passing -v twice. It is a
+// flag of its own, since urfave/cli does not combine short options while completing.
+func veryVerboseFlag() cli.Flag {
+	return &cli.BoolFlag{Name: "vv", Hidden: true}
+}
+
+// hasFlag returns true if flags contains a flag with this name
+func hasFlag(flags []cli.Flag, name string) bool {
+	for _, flag := range flags {
+		if flag.Names()[0] == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globalContext returns the context of the app, whose flags may be shadowed by a flag of
+// the same name on a command (such as status --quiet)
+func globalContext(c *cli.Context) *cli.Context {
+	lineage := c.Lineage()
+	for i := len(lineage) - 1; i >= 0; i-- {
+		if lineage[i].Command != nil {
+			return lineage[i]
+		}
+	}
+
+	return c
+}
+
+// verbosity returns the number of times --verbose (-v) was passed to the app or the
+// command, e.g. 2 for -vv
+func verbosity(c *cli.Context) int {
+	n := 0
+	for _, ctx := range c.Lineage() {
+		if ctx.Command == nil {
+			continue
+		}
+		if hasFlag(ctx.Command.Flags, "verbose") {
+			n += ctx.Count("verbose") + 2*ctx.Count("vv")
+		}
+	}
+
+	return n
+}
+
+// configureMigrationFiles sets where db reads migration files from: the migrations
+// directory, or the remote source given with --migrations-url
+func configureMigrationFiles(c *cli.Context, db *dbmate.DB) error {
+	db.MigrationsDir = c.StringSlice("migrations-dir")
+	db.VersionFormat = c.String("version-format")
+
+	if value := c.String("migrations-url"); value != "" {
+		migrationsURL, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		fsys, err := remotefs.Open(c.Context, migrationsURL, remotefs.DefaultCacheDir())
+		if err != nil {
+			return err
+		}
+		db.FS = fsys
+		db.MigrationsDir = []string{"."}
+	}
+
+	return nil
+}
+
+// action wraps a cli.ActionFunc with dbmate initialization logic
+func action(f func(*dbmate.DB, *cli.Context) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		u, err := getDatabaseURL(c)
+		if err != nil {
+			return err
+		}
+		db := dbmate.New(u)
+		db.AdditionalURLs, err = getAdditionalURLs(c)
+		if err != nil {
+			return err
+		}
+		db.Parallel = c.Int("parallel")
+		db.Environment = activeEnvironment(c)
+		db.AutoDumpSchema = !c.Bool("no-dump-schema")
+		if err := configureMigrationFiles(c, db); err != nil {
+			return err
+		}
+		db.MigrationsTableName = c.String("migrations-table")
+		db.CreateMissingSchema = c.Bool("create-missing-schema")
+		db.Audit = c.Bool("audit")
+		db.SchemaFile = c.String("schema-file")
+		db.SchemaFormat = c.String("schema-format")
+		db.SchemaHeader = c.String("schema-header")
+		db.SchemaMigrations = c.String("schema-migrations")
+		db.SchemaMigrationsFile = c.String("schema-migrations-file")
+		db.FormatSchema = c.Bool("format-schema")
+		db.LogLevel = c.String("log-level")
+		quiet := globalContext(c).Bool("quiet")
+		verbosity := verbosity(c)
+		if quiet && verbosity > 0 {
+			return errors.New("--quiet cannot be combined with --verbose")
+		}
+		if quiet {
+			db.LogLevel = dbmate.LogLevelWarn
+		}
+		db.Verbose = verbosity > 0
+		if verbosity > 1 {
+			db.LogLevel = dbmate.LogLevelDebug
+		}
+		if err := dbmate.ValidateLogLevel(db.LogLevel); err != nil {
+			return err
+		}
+		db.LogSlow = c.Duration("log-slow")
+		db.StreamThreshold = c.Int64("stream-threshold")
+		db.Savepoints = c.Bool("savepoints")
+		db.ReplicationSafe = c.Bool("replication-safe")
+		db.ReplicationMaxLag = c.Int64("replication-max-lag")
+		db.ReplicationRole = c.String("replication-role")
+		db.StatementTimeout = c.Duration("statement-timeout")
+		db.LockTimeout = c.Duration("lock-timeout")
+		db.SessionSetup = stringListFlag(c, "session-setup")
+		db.GolangMigrateTable = c.String("golang-migrate-table")
+		db.OperationTimeout = c.Duration("operation-timeout")
+		if pollInterval := c.Duration("operation-poll-interval"); pollInterval != 0 {
+			db.OperationPollInterval = pollInterval
+		}
+		db.MigrationRetries = c.Int("retries")
+		retryInterval := c.Duration("retry-interval")
+		if retryInterval != 0 {
+			db.MigrationRetryInterval = retryInterval
+		}
+		db.WaitBefore = c.Bool("wait")
+		db.WaitFailOn = waitFailOn(c.StringSlice("wait-fail-on"))
+		waitTimeout := c.Duration("wait-timeout")
+		if waitTimeout != 0 {
 			db.WaitTimeout = waitTimeout
 		}
 
+		if value := c.String("status-url"); value != "" {
+			db.StatusURL, err = url.Parse(value)
+			if err != nil {
+				return err
+			}
+		}
+
+		if value := c.String("lock-url"); value != "" {
+			lockURL, err := url.Parse(value)
+			if err != nil {
+				return err
+			}
+			db.Locker, err = lock.Open(lockURL)
+			if err != nil {
+				return err
+			}
+		}
+
+		if value := c.String("ssh-tunnel"); value != "" {
+			tunnelURL, err := url.Parse(value)
+			if err != nil {
+				return err
+			}
+			tunnel, err := sshtunnel.Open(tunnelURL)
+			if err != nil {
+				return err
+			}
+			defer tunnel.Close()
+			db.DialContext = tunnel.DialContext
+		}
+
+		// progress is not reported when several databases are migrated at once, since
+		// their migrations are interleaved
+		if !c.Bool("no-progress") && !quiet && db.Parallel <= 1 {
+			db.EventHandler = dbmate.NewProgress(os.Stderr).Event
+		}
+
+		if addr := c.String("health-addr"); addr != "" {
+			return runWithHealth(db, c, addr, f)
+		}
+
 		return f(db, c)
 	}
 }
 
+// healthShutdownTimeout is how long requests to the health endpoints may take to complete
+// once the command has returned
+const healthShutdownTimeout = 5 * time.Second
+
+// runWithHealth runs a command while serving its progress on addr, for Kubernetes probes.
+// The server is shut down when the command returns, after completing requests in
+// progress, so that dbmate exits with the result of the command.
+func runWithHealth(db *dbmate.DB, c *cli.Context, addr string, f func(*dbmate.DB, *cli.Context) error) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	health := server.NewHealth()
+	srv := &http.Server{
+		Handler:           health,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = srv.Serve(listener)
+	}()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+		defer cancel()
+		if srv.Shutdown(ctx) != nil {
+			_ = srv.Close()
+		}
+		<-served
+	}()
+	fmt.Fprintf(db.Log, "Serving health checks on %s\n", listener.Addr())
+
+	handler := db.EventHandler
+	db.EventHandler = func(e dbmate.Event) {
+		health.Event(e)
+		if handler != nil {
+			handler(e)
+		}
+	}
+
+	err = f(db, c)
+	health.Done(err)
+
+	return err
+}
+
+// readPassword prompts for a password without echoing it if stdin is a terminal, and
+// otherwise reads the first line of stdin
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(password), err
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimRight(password, "\r\n"), nil
+}
+
+// migrateUpRegExp matches the up directive of a complete migration
+var migrateUpRegExp = regexp.MustCompile(`(?m)^--\s*migrate:up`)
+
+// newMigration creates a migration file from the SQL passed to dbmate new, and returns
+// its path. Input read from stdin or the clipboard is written as is if it is a complete
+// migration, and is otherwise used as the up block.
+func newMigration(db *dbmate.DB, c *cli.Context, stdin io.Reader) (string, error) {
+	name := c.Args().First()
+	up, down := c.String("up-sql"), c.String("down-sql")
+
+	var input []byte
+	var err error
+	switch {
+	case c.Bool("stdin") && c.Bool("from-clipboard"):
+		return "", errors.New("specify either --stdin or --from-clipboard, not both")
+	case (c.Bool("stdin") || c.Bool("from-clipboard")) && (up != "" || down != ""):
+		return "", errors.New("--stdin and --from-clipboard cannot be combined with --up-sql or --down-sql")
+	case c.Bool("stdin") || up == "-":
+		input, err = io.ReadAll(stdin)
+	case c.Bool("from-clipboard"):
+		input, err = readClipboard()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if up == "-" {
+		up = string(input)
+	} else if input != nil {
+		if migrateUpRegExp.Match(input) {
+			return db.NewMigrationWithContents(name, string(input))
+		}
+		up = string(input)
+	}
+
+	return db.NewMigrationWithUpDown(name, up, down)
+}
+
+// clipboardCommands print the contents of the clipboard, in order of preference
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbpaste"}},
+	"windows": {{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}},
+	"linux":   {{"wl-paste", "--no-newline"}, {"xclip", "-selection", "clipboard", "-o"}, {"xsel", "--clipboard", "--output"}},
+}
+
+// readClipboard returns the contents of the clipboard, using the first clipboard command
+// which is installed
+func readClipboard() ([]byte, error) {
+	for _, args := range clipboardCommands[runtime.GOOS] {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		return cmd.Output()
+	}
+
+	return nil, fmt.Errorf("no clipboard command found on %s, use --stdin instead", runtime.GOOS)
+}
+
+// openEditor opens a file with the editor set by $VISUAL or $EDITOR, which may include
+// arguments (e.g. "code --wait"), and waits for it to exit
+func openEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		return errors.New("--edit requires the VISUAL or EDITOR environment variable")
+	}
+
+	cmd := exec.Command(args[0], append(args[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
 // getDatabaseURL returns the current database url from cli flag or environment variable
 func getDatabaseURL(c *cli.Context) (u *url.URL, err error) {
 	// check --url flag first
-	value := c.String("url")
-	if value == "" {
+	value := ""
+	if urls := urlFlags(c); len(urls) > 0 {
+		value = urls[0]
+	} else {
 		// if empty, default to --env or DATABASE_URL
 		env := c.String("env")
 		value = os.Getenv(env)
+
+		// otherwise treat --env as an environment name, e.g. staging => DATABASE_URL_STAGING
+		if value == "" {
+			value = os.Getenv(environmentVariable(env))
+		}
 	}
 
 	return url.Parse(value)
 }
 
-// redactLogString attempts to redact passwords from errors
-func redactLogString(in string) string {
-	re := regexp.MustCompile("([a-zA-Z]+://[^:]+:)[^@]+@")
+// getAdditionalURLs returns the database URLs given by every --url flag after the first
+func getAdditionalURLs(c *cli.Context) ([]*url.URL, error) {
+	urls := []*url.URL{}
+	values := urlFlags(c)
+	if len(values) < 2 {
+		return urls, nil
+	}
+	for _, value := range values[1:] {
+		u, err := url.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+
+	return urls, nil
+}
+
+// waitFailOn returns the failures listed by --wait-fail-on, where "none" retries every
+// failure
+func waitFailOn(values []string) []string {
+	if len(values) == 1 && values[0] == "none" {
+		return []string{}
+	}
+
+	return values
+}
+
+// writePlan writes a plan to the file at path, or to out if path is empty
+func writePlan(out io.Writer, path string, plan *dbmate.Plan) error {
+	if path == "" {
+		return plan.Write(out)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := plan.Write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// readPlan reads the plan file at path
+func readPlan(path string) (*dbmate.Plan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(file)
+
+	return dbmate.ReadPlan(file)
+}
+
+// stringList collects repeated flags such as --url. Unlike cli.StringSlice, values are
+// not split on commas, which database URLs and SQL statements may contain.
+type stringList []string
+
+// stringListPrefix marks the serialized value of a stringList. After parsing a flag,
+// urfave/cli sets the serialized value on each of its aliases, which share the list, so
+// it replaces the list rather than appending to it again.
+const stringListPrefix = "sl:::"
+
+func (l *stringList) Set(value string) error {
+	if strings.HasPrefix(value, stringListPrefix) {
+		return json.Unmarshal([]byte(strings.TrimPrefix(value, stringListPrefix)), (*[]string)(l))
+	}
+
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *stringList) Serialize() string {
+	data, _ := json.Marshal([]string(*l))
+	return stringListPrefix + string(data)
+}
+
+// stringListFlag returns the values of a repeated stringList flag
+func stringListFlag(c *cli.Context, name string) []string {
+	if l, ok := c.Generic(name).(*stringList); ok && l != nil {
+		return *l
+	}
+
+	return nil
+}
+
+// urlFlags returns the values of the --url flag, without duplicates, so that a database
+// given twice is only migrated once
+func urlFlags(c *cli.Context) []string {
+	urls := []string{}
+	seen := map[string]bool{}
+	for _, value := range stringListFlag(c, "url") {
+		if !seen[value] {
+			seen[value] = true
+			urls = append(urls, value)
+		}
+	}
+
+	return urls
+}
+
+// databaseURLSource returns the option or environment variable which getDatabaseURL
+// reads the database URL from, or an empty string if it is not set
+func databaseURLSource(c *cli.Context) string {
+	if len(urlFlags(c)) > 0 {
+		return "--url"
+	}
+
+	env := c.String("env")
+	if os.Getenv(env) != "" {
+		return "$" + env
+	}
+	if os.Getenv(environmentVariable(env)) != "" {
+		return "$" + environmentVariable(env)
+	}
+
+	return ""
+}
+
+const environmentVariablePrefix = "DATABASE_URL_"
+
+// environmentVariable returns the variable containing the database URL for an environment
+func environmentVariable(env string) string {
+	return environmentVariablePrefix + strings.ToUpper(env)
+}
+
+// activeEnvironment returns the --environment flag, or otherwise the environment name
+// if --env selects a DATABASE_URL_<NAME> variable
+func activeEnvironment(c *cli.Context) string {
+	if env := c.String("environment"); env != "" {
+		return env
+	}
+
+	env := c.String("env")
+	if len(urlFlags(c)) == 0 && os.Getenv(env) == "" && os.Getenv(environmentVariable(env)) != "" {
+		return strings.ToLower(env)
+	}
+
+	return ""
+}
+
+// configuredEnvironments returns the names of all environments with a DATABASE_URL_<NAME> variable
+func configuredEnvironments() []string {
+	envs := []string{}
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, environmentVariablePrefix) && len(name) > len(environmentVariablePrefix) && value != "" {
+			envs = append(envs, strings.ToLower(strings.TrimPrefix(name, environmentVariablePrefix)))
+		}
+	}
+	sort.Strings(envs)
+
+	return envs
+}
+
+// statusPendingFiles prints the path of each pending migration file, so that deployment
+// scripts can bundle exactly those files, and returns the number of pending migrations
+func statusPendingFiles(db *dbmate.DB, asJSON bool) (int, error) {
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return -1, err
+	}
+
+	files := []string{}
+	for _, migration := range migrations {
+		if !migration.Applied && !migration.Skipped {
+			files = append(files, migration.FilePath)
+		}
+	}
+
+	return len(files), printPendingFiles(db.Log, files, asJSON)
+}
+
+// printPendingFiles writes files one per line, or as a JSON array
+func printPendingFiles(w io.Writer, files []string, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(files)
+	}
+
+	for _, file := range files {
+		if _, err := fmt.Fprintln(w, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statusAllEnvironments shows the migration status of every configured environment
+func statusAllEnvironments(db *dbmate.DB, quiet, setExitCode bool) error {
+	envs := configuredEnvironments()
+	if len(envs) == 0 {
+		return errors.New("no environments found, set DATABASE_URL_<NAME> environment variables")
+	}
+
+	totalPending := 0
+	failed := []string{}
+	modified := []string{}
+	for _, env := range envs {
+		u, err := url.Parse(os.Getenv(environmentVariable(env)))
+		if err != nil {
+			return err
+		}
+
+		envDB := *db
+		envDB.DatabaseURL = u
+		envDB.Environment = env
+
+		pending, err := envDB.Status(quiet)
+		if errors.Is(err, dbmate.ErrMigrationModified) {
+			// the status was shown, so only the exit code is affected
+			modified = append(modified, env)
+			err = nil
+		}
+		if err != nil {
+			// keep going, so that one unavailable environment doesn't hide the others
+			failed = append(failed, env)
+			if !quiet {
+				fmt.Fprintf(db.Log, "%s\n", dbutil.RedactPasswords(fmt.Sprintf("Error: %s", err)))
+			}
+		} else {
+			totalPending += pending
+		}
+		if !quiet {
+			fmt.Fprintln(db.Log)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("unable to read status for environments: %s", strings.Join(failed, ", "))
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("%w in environments: %s", dbmate.ErrMigrationModified, strings.Join(modified, ", "))
+	}
+
+	if totalPending > 0 && setExitCode {
+		return cli.Exit("", exitPending)
+	}
+
+	return nil
+}
+
+// printHistory writes one applied migration per line, or a JSON array. Columns which
+// are unknown (for migrations applied without auditing) are shown as "-".
+func printHistory(w io.Writer, entries []dbmate.HistoryEntry, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(entries)
+	}
+
+	for _, entry := range entries {
+		name, appliedAt, duration, checksum := entry.FileName, "-", "-", "-"
+		if name == "" {
+			name = entry.Version + " (file not found)"
+		} else if entry.Archived {
+			name += " (archived)"
+		}
+		if !entry.AppliedAt.IsZero() {
+			appliedAt = entry.AppliedAt.UTC().Format(time.RFC3339)
+			duration = entry.Duration.Round(time.Millisecond).String()
+		}
+		if entry.Checksum != "" {
+			checksum = entry.Checksum
+			if len(checksum) > 12 {
+				checksum = checksum[:12]
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s  %s  %s  %s\n", appliedAt, duration, checksum, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadedEnvFiles returns the environment files loaded by loadEnvFiles
+func loadedEnvFiles(c *cli.Context) []string {
+	if files := c.StringSlice("env-file"); len(files) > 0 {
+		return files
+	}
+
+	files := []string{}
+	for _, file := range envfile.DefaultFiles {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// configInfo adds where options were read from to the configuration printed by info
+type configInfo struct {
+	dbmate.Info
+	DatabaseURLSource string   `json:"database_url_source"`
+	EnvFiles          []string `json:"env_files"`
+}
+
+// printInfo writes one setting per line, or a JSON object. Settings which are not
+// configured are shown as "-".
+func printInfo(w io.Writer, info configInfo, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(info)
+	}
+
+	value := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	databaseURL := value(info.DatabaseURL)
+	if info.DatabaseURLSource != "" {
+		databaseURL += " (from " + info.DatabaseURLSource + ")"
+	}
+
+	lines := [][2]string{
+		{"Database URL", databaseURL},
+		{"Driver", value(info.Driver)},
+		{"Host", value(info.Host)},
+		{"Database", value(info.Database)},
+		{"Environment", value(info.Environment)},
+		{"Env files", value(strings.Join(info.EnvFiles, ", "))},
+		{"Migrations dir", value(strings.Join(info.MigrationsDir, ", "))},
+		{"Migrations table", value(info.MigrationsTable)},
+		{"Schema file", value(info.SchemaFile)},
+	}
+	for _, tool := range info.Tools {
+		path := tool.Path
+		if path == "" {
+			path = "not found in PATH"
+		}
+		lines = append(lines, [2]string{tool.Name, path})
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "%-18s%s\n", line[0]+":", line[1]); err != nil {
+			return err
+		}
+	}
 
-	return re.ReplaceAllString(in, "${1}********@")
+	return nil
 }
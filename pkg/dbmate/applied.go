@@ -0,0 +1,121 @@
+package dbmate
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// HistoryEntry describes an applied migration
+type HistoryEntry struct {
+	Version string
+	// FileName is empty if the migration file no longer exists
+	FileName string
+	// Archived is true if the migration file has been moved out of the migrations
+	// directory by Archive, in which case FileName is its archived file name
+	Archived bool
+	// AppliedAt, Duration, and Checksum describe the latest run of the migration, and are
+	// only known if it was applied with auditing enabled
+	AppliedAt time.Time
+	Duration  time.Duration
+	Checksum  string
+}
+
+// MarshalJSON encodes the entry with the duration in milliseconds, leaving out unknown
+// fields
+func (e HistoryEntry) MarshalJSON() ([]byte, error) {
+	out := struct {
+		Version    string     `json:"version"`
+		FileName   string     `json:"file,omitempty"`
+		Archived   bool       `json:"archived,omitempty"`
+		AppliedAt  *time.Time `json:"applied_at,omitempty"`
+		DurationMS *int64     `json:"duration_ms,omitempty"`
+		Checksum   string     `json:"checksum,omitempty"`
+	}{
+		Version:  e.Version,
+		FileName: e.FileName,
+		Archived: e.Archived,
+		Checksum: e.Checksum,
+	}
+	if !e.AppliedAt.IsZero() {
+		duration := e.Duration.Milliseconds()
+		out.AppliedAt = &e.AppliedAt
+		out.DurationMS = &duration
+	}
+
+	return json.Marshal(out)
+}
+
+// History returns the applied migrations in version order. If limit is greater than
+// zero, only the latest limit migrations are returned.
+func (db *DB) History(limit int) ([]HistoryEntry, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	entries := []HistoryEntry{}
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil || !exists {
+		return entries, err
+	}
+
+	applied, err := drv.SelectMigrations(sqlDB, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := db.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	archived, err := db.archivedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	fileNames := map[string]string{}
+	for _, file := range files {
+		fileNames[file.Version] = file.FileName
+	}
+
+	runs := map[string]AuditRecord{}
+	if reader, ok := drv.(auditReader); ok {
+		records, err := reader.SelectAudit(sqlDB)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if record.Direction == AuditUp {
+				runs[record.Version] = record
+			}
+		}
+	}
+
+	for version := range applied {
+		entry := HistoryEntry{Version: version, FileName: fileNames[version]}
+		if entry.FileName == "" && archived[version] != "" {
+			entry.FileName = archived[version]
+			entry.Archived = true
+		}
+		if run, ok := runs[version]; ok {
+			entry.AppliedAt = run.FinishedAt
+			entry.Duration = run.Duration()
+			entry.Checksum = run.Checksum
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Version < entries[j].Version
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,168 @@
+package dbmate
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrVersionTimeUnknown is returned when archiving migrations whose versions do not
+// record when they were created, such as sequential versions
+var ErrVersionTimeUnknown = errors.New("can't tell when migration was created from its version")
+
+const (
+	// archiveDir is the subdirectory of a migrations directory which archived migration
+	// files are moved to, unless another directory is given
+	archiveDir = "archive"
+	// archivedMigrationsFile lists the migration files archived from a migrations
+	// directory, one per line
+	archivedMigrationsFile = "archived.txt"
+)
+
+// Archive moves the files of applied migrations created before the cutoff out of the
+// migrations directories, into dir (or an archive subdirectory of their migrations
+// directory if dir is empty). Each archived file is listed in archived.txt in its
+// migrations directory, so that the versions remaining in the migrations table are
+// known to be archived. Pending and skipped migrations are never archived. If dryRun is
+// true, the files are not moved.
+func (db *DB) Archive(before time.Time, dir string, dryRun bool) ([]Migration, error) {
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	archive := []Migration{}
+	for _, migration := range migrations {
+		if !migration.Applied {
+			continue
+		}
+
+		created, ok := versionTime(migration.Version)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrVersionTimeUnknown, migration.FileName)
+		}
+		if created.Before(before) {
+			archive = append(archive, migration)
+		}
+	}
+
+	if len(archive) == 0 {
+		db.logger().Infof("No applied migrations created before %s", before.Format(time.DateOnly))
+		return archive, nil
+	}
+
+	for i, migration := range archive {
+		migrationsDir := filepath.Dir(migration.FilePath)
+		targetDir := dir
+		if targetDir == "" {
+			targetDir = filepath.Join(migrationsDir, archiveDir)
+		}
+		newPath := filepath.Join(targetDir, migration.FileName)
+
+		if dryRun {
+			db.logger().Infof("Would archive: %s -> %s", migration.FileName, newPath)
+			continue
+		}
+
+		db.logger().Infof("Archiving: %s -> %s", migration.FileName, newPath)
+		if err := os.MkdirAll(targetDir, 0o755); err != nil {
+			return archive[:i], fmt.Errorf("%w `%s`", ErrCreateDirectory, targetDir)
+		}
+		if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+			return archive[:i], fmt.Errorf("%w: %s", ErrMigrationAlreadyExist, newPath)
+		}
+		// record the file first, so that an interrupted archive lists a file which
+		// still exists, rather than losing track of a moved file
+		if err := appendArchivedMigration(migrationsDir, migration.FileName); err != nil {
+			return archive[:i], err
+		}
+		if err := os.Rename(migration.FilePath, newPath); err != nil {
+			return archive[:i], err
+		}
+	}
+
+	return archive, nil
+}
+
+// appendArchivedMigration adds a file name to the archived migrations file in dir
+func appendArchivedMigration(dir, fileName string) error {
+	file, err := os.OpenFile(filepath.Join(dir, archivedMigrationsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(file, fileName)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// archivedMigrations returns the file names of the archived migrations listed in each
+// migrations directory, by version
+func (db *DB) archivedMigrations() (map[string]string, error) {
+	archived := map[string]string{}
+	for _, dir := range db.MigrationsDir {
+		path := filepath.Join(filepath.Clean(dir), archivedMigrationsFile)
+		var contents []byte
+		var err error
+		if db.FS == nil {
+			contents, err = os.ReadFile(path)
+		} else {
+			contents, err = fs.ReadFile(db.FS, path)
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range strings.Split(string(contents), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			matches := migrationFileRegexp.FindStringSubmatch(line)
+			if len(matches) < 2 {
+				return nil, fmt.Errorf("%w: invalid file name in %s: %s", ErrInvalidVersion, path, line)
+			}
+			archived[matches[1]] = matches[0]
+		}
+	}
+
+	return archived, nil
+}
+
+// versionTime returns when a migration was created, for timestamp, unix, and uuidv7
+// versions
+func versionTime(version string) (time.Time, bool) {
+	switch {
+	case len(version) == 14 && digitsRegexp.MatchString(version):
+		t, err := time.Parse("20060102150405", version)
+		return t, err == nil
+	case len(version) == 10 && digitsRegexp.MatchString(version):
+		seconds, err := strconv.ParseInt(version, 10, 64)
+		return time.Unix(seconds, 0).UTC(), err == nil
+	case uuidv7Regexp.MatchString(version):
+		// the first 48 bits are milliseconds since the unix epoch
+		ms, err := hex.DecodeString(strings.ReplaceAll(version[:13], "-", ""))
+		if err != nil {
+			return time.Time{}, false
+		}
+		var millis int64
+		for _, b := range ms {
+			millis = millis<<8 | int64(b)
+		}
+		return time.UnixMilli(millis).UTC(), true
+	}
+
+	return time.Time{}, false
+}
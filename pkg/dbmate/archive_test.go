@@ -0,0 +1,49 @@
+package dbmate
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionTime(t *testing.T) {
+	created, ok := versionTime("20240102150405")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), created)
+
+	created, ok = versionTime("1704207845")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), created)
+
+	created, ok = versionTime("018ccab4-0688-7c3e-9b1a-2f6d8e0c4b5a")
+	require.True(t, ok)
+	require.Equal(t, time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), created)
+
+	_, ok = versionTime("000042")
+	require.False(t, ok)
+	_, ok = versionTime("20241399000000")
+	require.False(t, ok)
+}
+
+func TestArchivedMigrations(t *testing.T) {
+	db := New(nil)
+	db.MigrationsDir = []string{"db/migrations", "db/seeds"}
+	db.FS = fstest.MapFS{
+		"db/migrations/archived.txt": {Data: []byte("# archived\n20210101000000_users.sql\n\n20211231235959_posts.sql\n")},
+	}
+
+	archived, err := db.archivedMigrations()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"20210101000000": "20210101000000_users.sql",
+		"20211231235959": "20211231235959_posts.sql",
+	}, archived)
+
+	db.FS = fstest.MapFS{
+		"db/seeds/archived.txt": {Data: []byte("users.sql\n")},
+	}
+	_, err = db.archivedMigrations()
+	require.ErrorIs(t, err, ErrInvalidVersion)
+}
@@ -0,0 +1,141 @@
+package dbmate
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrAuditUnsupported is returned if auditing is enabled for a driver which does not
+// support the audit table
+var ErrAuditUnsupported = errors.New("the audit table is not supported by this driver")
+
+// AuditTableSuffix is appended to the migrations table name to name the audit table
+const AuditTableSuffix = "_audit"
+
+// Audit directions
+const (
+	AuditUp   = "up"
+	AuditDown = "down"
+)
+
+// AuditRecord describes a migration which was applied or rolled back, and who ran it
+type AuditRecord struct {
+	Version       string
+	Direction     string
+	Checksum      string
+	StartedAt     time.Time
+	FinishedAt    time.Time
+	Hostname      string
+	User          string
+	DbmateVersion string
+}
+
+// Duration returns how long the migration took to run
+func (r AuditRecord) Duration() time.Duration {
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// auditor is implemented by drivers which can record migration runs in an audit table,
+// named after the migrations table with AuditTableSuffix appended
+type auditor interface {
+	CreateAuditTable(db *sql.DB) error
+	InsertAudit(db dbutil.Transaction, record AuditRecord) error
+}
+
+// auditReader is implemented by drivers which can read the audit table
+type auditReader interface {
+	// SelectAudit returns the records in the order they were inserted, or nil if the
+	// audit table does not exist
+	SelectAudit(db *sql.DB) ([]AuditRecord, error)
+}
+
+// createAuditTable creates the audit table if auditing is enabled
+func (db *DB) createAuditTable(drv Driver, sqlDB *sql.DB) error {
+	if !db.Audit {
+		return nil
+	}
+
+	a, ok := drv.(auditor)
+	if !ok {
+		return ErrAuditUnsupported
+	}
+
+	return a.CreateAuditTable(sqlDB)
+}
+
+// recordAudit records a migration run in the audit table if auditing is enabled
+func (db *DB) recordAudit(drv Driver, tx dbutil.Transaction, migration Migration, direction string, startedAt time.Time) error {
+	if !db.Audit {
+		return nil
+	}
+
+	a, ok := drv.(auditor)
+	if !ok {
+		return ErrAuditUnsupported
+	}
+
+	checksum, err := migration.checksum()
+	if err != nil {
+		return err
+	}
+
+	return a.InsertAudit(tx, AuditRecord{
+		Version:       migration.Version,
+		Direction:     direction,
+		Checksum:      checksum,
+		StartedAt:     startedAt.UTC(),
+		FinishedAt:    time.Now().UTC(),
+		Hostname:      auditHostname(),
+		User:          auditUser(),
+		DbmateVersion: Version,
+	})
+}
+
+// auditTableName returns the unqualified name of the audit table
+func (db *DB) auditTableName() string {
+	parts := strings.Split(db.MigrationsTableName, ".")
+	return parts[len(parts)-1] + AuditTableSuffix
+}
+
+// checksum returns the hex encoded SHA-256 hash of the migration file
+func (m *Migration) checksum() (string, error) {
+	file, err := m.open()
+	if err != nil {
+		return "", err
+	}
+	defer dbutil.MustClose(file)
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func auditHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+
+	return hostname
+}
+
+func auditUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	// user.Current can fail in containers without an /etc/passwd entry
+	return os.Getenv("USER")
+}
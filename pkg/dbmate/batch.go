@@ -0,0 +1,86 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrInvalidBatchOption = errors.New("invalid batch option")
+	ErrBatchTooLarge      = errors.New("batch affected more rows than the batch size, is the statement missing a limit?")
+	ErrBatchRowsUnknown   = errors.New("batched migrations require a driver which reports affected rows")
+)
+
+// validateBatchOptions returns an error if the batch or sleep options of a migration
+// block are present but invalid, so that typos are not silently ignored
+func validateBatchOptions(m migrationOptions) error {
+	if value, ok := m["batch"]; ok {
+		if size, err := strconv.Atoi(value); err != nil || size <= 0 {
+			return fmt.Errorf("%w: batch must be a positive integer: %s", ErrInvalidBatchOption, value)
+		}
+	}
+
+	if value, ok := m["sleep"]; ok {
+		if _, ok := m["batch"]; !ok {
+			return fmt.Errorf("%w: sleep requires batch", ErrInvalidBatchOption)
+		}
+		if sleep, err := time.ParseDuration(value); err != nil || sleep < 0 {
+			return fmt.Errorf("%w: sleep must be a duration such as 50ms: %s", ErrInvalidBatchOption, value)
+		}
+	}
+
+	return nil
+}
+
+// execBatches executes a migration block repeatedly until it affects no rows, sleeping
+// between executions. Each execution runs in its own transaction (unless disabled), so
+// locks are only held for a single batch. The block is expected to be an update or
+// delete statement which affects at most options.BatchSize() rows.
+func (db *DB) execBatches(drv Driver, sqlDB *sql.DB, options migrationOptions, execBlock func(dbutil.Transaction) (int64, error)) error {
+	size := int64(options.BatchSize())
+
+	for batch := 1; ; batch++ {
+		var rows int64
+		execBatch := func(tx dbutil.Transaction) error {
+			var err error
+			rows, err = execBlock(tx)
+			if err != nil {
+				return err
+			}
+
+			if rows < 0 {
+				return ErrBatchRowsUnknown
+			}
+
+			// a transaction is rolled back, protecting against a statement without a limit
+			if rows > size {
+				return fmt.Errorf("%w: %d rows affected, batch size is %d", ErrBatchTooLarge, rows, size)
+			}
+
+			return nil
+		}
+
+		var err error
+		if options.Transaction() {
+			err = doTransaction(drv, sqlDB, execBatch)
+		} else {
+			err = execBatch(sqlDB)
+		}
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", batch, err)
+		}
+
+		db.logger().Infof("Batch %d: %d rows affected", batch, rows)
+		if rows == 0 {
+			return nil
+		}
+
+		time.Sleep(options.BatchSleep())
+	}
+}
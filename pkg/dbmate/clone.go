@@ -0,0 +1,192 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrCloneSchemeMismatch  = errors.New("the target database must use the same driver as the source database")
+	ErrCloneTargetNotEmpty  = errors.New("the target database already has a migrations table")
+	ErrCloneTargetIsCurrent = errors.New("the target database is the source database")
+)
+
+// CloneSchema copies the schema of the current database into the database at target,
+// which is created if it does not exist. This is useful to create per-developer or
+// per-branch databases with the same structure as production. The migrations table is
+// created empty, unless withHistory is true, in which case the applied migrations are
+// copied too. Statements which create the source database are skipped, and names
+// qualified by the source database (as in ClickHouse dumps) are rewritten to refer to
+// the target.
+func (db *DB) CloneSchema(target *url.URL, withHistory bool) (err error) {
+	defer db.emitError(&err)
+
+	if target == nil || target.Scheme == "" {
+		return ErrInvalidURL
+	}
+	if db.DatabaseURL == nil {
+		return ErrInvalidURL
+	}
+	if target.Scheme != db.DatabaseURL.Scheme {
+		return fmt.Errorf("%w: the target uses %s, but the source uses %s",
+			ErrCloneSchemeMismatch, target.Scheme, db.DatabaseURL.Scheme)
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	clone := *db
+	clone.DatabaseURL = target
+	clone.Connection = nil
+	clone.StatusURL = nil
+
+	targetDrv, err := clone.Driver()
+	if err != nil {
+		return err
+	}
+	source := databaseLocation(db.DatabaseURL, drv)
+	dest := databaseLocation(target, targetDrv)
+	if source.same(dest) {
+		return ErrCloneTargetIsCurrent
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	db.logger().Infof("Dumping: %s", db.DatabaseURL.Redacted())
+	schema, err := drv.DumpSchema(sqlDB)
+	if err != nil {
+		return err
+	}
+	if !withHistory {
+		objects, _, _ := strings.Cut(string(schema), schemaMigrationsMarker)
+		schema = []byte(objects)
+	}
+
+	exists, err := targetDrv.DatabaseExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := targetDrv.CreateDatabase(); err != nil {
+			return err
+		}
+	}
+
+	targetDB, err := clone.openDatabase(targetDrv)
+	if err != nil {
+		return err
+	}
+	defer clone.closeDatabase(targetDB)
+
+	exists, err = targetDrv.MigrationsTableExists(targetDB)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrCloneTargetNotEmpty, target.Redacted())
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+	stmts := []string{}
+	err = splitSchemaStatements(string(schema), backslashEscapes, func(stmt string, _ int) error {
+		stmts = append(stmts, stmt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	stmts, err = retargetStatements(stmts, target.Scheme, source.name, dest.name)
+	if err != nil {
+		return err
+	}
+
+	db.logger().Infof("Loading: %s", target.Redacted())
+
+	return clone.loadStatements(targetDrv, targetDB, stmts)
+}
+
+// location identifies a database independently of the other options in its URL
+type location struct {
+	host string
+	port string
+	name string
+}
+
+// databaseLocation returns the normalized location of the database at u. Hosts are
+// compared case insensitively, and the paths of database files are made absolute.
+func databaseLocation(u *url.URL, drv Driver) location {
+	host, name := u.Host, dbutil.DatabaseName(u)
+	if locator, ok := drv.(databaseLocator); ok {
+		host, name = locator.DatabaseLocation()
+	}
+
+	loc := location{host: host, name: name}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		loc.host, loc.port = h, p
+	}
+	loc.host = strings.ToLower(strings.Trim(loc.host, "[]"))
+	if loc.host == "" && name != "" {
+		if abs, err := filepath.Abs(name); err == nil {
+			loc.name = abs
+		}
+	}
+
+	return loc
+}
+
+// same returns true if loc and other may be the same database. A missing port matches
+// any port, since it is the default port of the driver.
+func (loc location) same(other location) bool {
+	return loc.host == other.host && loc.name == other.name &&
+		(loc.port == "" || other.port == "" || loc.port == other.port)
+}
+
+// createDatabaseRegexp matches statements which create a database
+var createDatabaseRegexp = regexp.MustCompile(`(?is)^\s*(?:--[^\n]*\n\s*)*create\s+database\b`)
+
+// retargetStatements removes the statements of a schema dump which create the source
+// database, since the target has already been created, and rewrites names qualified by
+// the source database (quoted or not) to be qualified by the target database instead
+func retargetStatements(stmts []string, scheme, source, target string) ([]string, error) {
+	var qualified *regexp.Regexp
+	var replacement string
+	if source != "" && source != target {
+		name := regexp.QuoteMeta(source)
+		qualified = regexp.MustCompile("(^|[^\\w$.\"`])(?:" + name + "|\"" + name + "\"|`" + name + "`)\\.")
+		quoted, err := dbutil.QuoteIdentifier(scheme, target)
+		if err != nil {
+			return nil, err
+		}
+		replacement = "${1}" + strings.ReplaceAll(quoted, "$", "$$") + "."
+	}
+
+	result := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		if createDatabaseRegexp.MatchString(stmt) {
+			continue
+		}
+		if qualified != nil {
+			stmt = qualified.ReplaceAllString(stmt, replacement)
+		}
+		result = append(result, stmt)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package dbmate
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+)
+
+// ConcurrentIndex is an index built by a CREATE INDEX CONCURRENTLY statement, which leaves
+// an invalid index behind if it fails
+type ConcurrentIndex struct {
+	// Name is the unquoted name of the index
+	Name string
+	// Table is the table the index is built on, as written in the statement (it may be
+	// quoted or schema qualified)
+	Table string
+}
+
+// identifierPattern matches a quoted or unquoted SQL identifier
+const identifierPattern = `(?:"(?:[^"]|"")+"|[A-Za-z_][\w$]*)`
+
+// concurrentIndexRegexp matches a named concurrent index build, capturing the index name
+// and the table name
+var concurrentIndexRegexp = regexp.MustCompile(`(?is)\bcreate\s+(?:unique\s+)?index\s+concurrently\s+` +
+	`(?:if\s+not\s+exists\s+)?(` + identifierPattern + `)\s+on\s+(?:only\s+)?(` +
+	identifierPattern + `(?:\s*\.\s*` + identifierPattern + `)?)`)
+
+// concurrentIndexes returns the named indexes built concurrently by a statement
+func concurrentIndexes(stmt string) []ConcurrentIndex {
+	indexes := []ConcurrentIndex{}
+	for _, match := range concurrentIndexRegexp.FindAllStringSubmatch(stmt, -1) {
+		indexes = append(indexes, ConcurrentIndex{Name: unquoteIdentifier(match[1]), Table: match[2]})
+	}
+
+	return indexes
+}
+
+// unquoteIdentifier returns the name of a quoted identifier, or the lower case name of an
+// unquoted identifier, in the same way as postgres
+func unquoteIdentifier(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return strings.ReplaceAll(s[1:len(s)-1], `""`, `"`)
+	}
+
+	return strings.ToLower(s)
+}
+
+// dropInvalidIndexes drops the indexes left invalid by failed concurrent index builds in
+// the up block of a migration, if the driver supports it, so that the indexes are built
+// again when the migration is retried (rather than being skipped by IF NOT EXISTS)
+func (db *DB) dropInvalidIndexes(drv Driver, sqlDB *sql.DB, migration Migration) error {
+	dropper, ok := drv.(invalidIndexDropper)
+	if !ok {
+		return nil
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	indexes := []ConcurrentIndex{}
+	_, err := migration.streamBlock(true, backslashEscapes, func(stmt string, _ int) error {
+		indexes = append(indexes, concurrentIndexes(stmt)...)
+		return nil
+	})
+	if err != nil || len(indexes) == 0 {
+		return err
+	}
+
+	dropped, err := dropper.DropInvalidIndexes(sqlDB, indexes)
+	for _, name := range dropped {
+		db.logger().Warnf("Dropped invalid index: %s", name)
+	}
+
+	return err
+}
@@ -0,0 +1,32 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentIndexes(t *testing.T) {
+	cases := []struct {
+		stmt     string
+		expected []ConcurrentIndex
+	}{
+		{"create index concurrently users_email on users (email)",
+			[]ConcurrentIndex{{Name: "users_email", Table: "users"}}},
+		{"CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS Users_Email ON ONLY app.users USING btree (email)",
+			[]ConcurrentIndex{{Name: "users_email", Table: "app.users"}}},
+		{`create index concurrently "Users ""Email""" on "App"."Users" (email)`,
+			[]ConcurrentIndex{{Name: `Users "Email"`, Table: `"App"."Users"`}}},
+		{"-- build the index\ncreate index\n  concurrently users_email\n  on users (email);",
+			[]ConcurrentIndex{{Name: "users_email", Table: "users"}}},
+		// unnamed and non-concurrent indexes are ignored
+		{"create index concurrently on users (email)", []ConcurrentIndex{}},
+		{"create index users_email on users (email)", []ConcurrentIndex{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.stmt, func(t *testing.T) {
+			require.Equal(t, c.expected, concurrentIndexes(c.stmt))
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidConnectionParam is returned if a connection tuning URL parameter is invalid
+var ErrInvalidConnectionParam = errors.New("invalid connection parameter")
+
+// URL query parameters which tune connections for every driver. They are removed from
+// the URL before it is passed to the driver. Timeouts are durations such as 10s, or a
+// number of seconds.
+const (
+	// ConnectTimeoutParam limits how long establishing a connection may take
+	ConnectTimeoutParam = "connect_timeout"
+	// ReadTimeoutParam limits how long a single read from the server may take
+	ReadTimeoutParam = "read_timeout"
+	// WriteTimeoutParam limits how long a single write to the server may take
+	WriteTimeoutParam = "write_timeout"
+	// MaxOpenConnsParam limits the number of connections dbmate opens to the server
+	MaxOpenConnsParam = "max_open_conns"
+	// SessionSetupParam is a SQL statement executed on each connection before it is used
+	// (see DB.SessionSetup), and may be repeated
+	SessionSetupParam = "session_setup"
+	// CreateMissingSchemaParam overrides DB.CreateMissingSchema for this database
+	CreateMissingSchemaParam = "create_missing_schema"
+)
+
+// connectionParamNames lists the connection tuning URL parameters
+var connectionParamNames = []string{
+	ConnectTimeoutParam,
+	ReadTimeoutParam,
+	WriteTimeoutParam,
+	MaxOpenConnsParam,
+	SessionSetupParam,
+	CreateMissingSchemaParam,
+}
+
+// connectionParams holds the connection tuning URL parameters
+type connectionParams struct {
+	connectTimeout time.Duration
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	maxOpenConns   int
+	sessionSetup   []string
+	// createMissingSchema is nil unless the parameter is set
+	createMissingSchema *bool
+}
+
+// parseConnectionParams returns the connection tuning parameters of u, and a copy of u
+// with them removed. Parameters listed in native are understood by the driver itself, so
+// they are neither parsed nor removed.
+func parseConnectionParams(u *url.URL, native []string) (connectionParams, *url.URL, error) {
+	params := connectionParams{}
+	query := u.Query()
+	for _, name := range native {
+		query.Del(name)
+	}
+
+	var err error
+	if params.connectTimeout, err = timeoutParam(query, ConnectTimeoutParam); err != nil {
+		return params, nil, err
+	}
+	if params.readTimeout, err = timeoutParam(query, ReadTimeoutParam); err != nil {
+		return params, nil, err
+	}
+	if params.writeTimeout, err = timeoutParam(query, WriteTimeoutParam); err != nil {
+		return params, nil, err
+	}
+	if value := query.Get(MaxOpenConnsParam); value != "" {
+		params.maxOpenConns, err = strconv.Atoi(value)
+		if err != nil || params.maxOpenConns <= 0 {
+			return params, nil, fmt.Errorf("%w: %s must be a positive integer: %s",
+				ErrInvalidConnectionParam, MaxOpenConnsParam, value)
+		}
+	}
+
+	params.sessionSetup = query[SessionSetupParam]
+
+	if query.Has(CreateMissingSchemaParam) {
+		value := query.Get(CreateMissingSchemaParam)
+		create, err := strconv.ParseBool(value)
+		if err != nil {
+			return params, nil, fmt.Errorf("%w: %s must be true or false: %s",
+				ErrInvalidConnectionParam, CreateMissingSchemaParam, value)
+		}
+		params.createMissingSchema = &create
+	}
+
+	stripped := u.Query()
+	changed := false
+	for _, name := range connectionParamNames {
+		if query.Has(name) {
+			stripped.Del(name)
+			changed = true
+		}
+	}
+	if !changed {
+		return params, u, nil
+	}
+
+	clone := *u
+	clone.RawQuery = stripped.Encode()
+
+	return params, &clone, nil
+}
+
+// nativeConnectionParams returns the connection tuning parameters which the driver
+// understands itself
+func nativeConnectionParams(drv Driver) []string {
+	if n, ok := drv.(nativeConnectionParamer); ok {
+		return n.NativeConnectionParams()
+	}
+
+	return nil
+}
+
+// timeoutParam parses a duration, or a number of seconds for compatibility with libpq
+func timeoutParam(query url.Values, name string) (time.Duration, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, nil
+	}
+
+	return 0, fmt.Errorf("%w: %s must be a duration such as 10s: %s", ErrInvalidConnectionParam, name, value)
+}
+
+// dialContext returns a dialer which applies the connect, read, and write timeouts to
+// connections made by dial (or directly, if dial is nil). If no timeouts are set, dial
+// is returned unchanged.
+func (p connectionParams) dialContext(dial DialContextFunc) DialContextFunc {
+	if p.connectTimeout == 0 && p.readTimeout == 0 && p.writeTimeout == 0 {
+		return dial
+	}
+
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if p.connectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.connectTimeout)
+			defer cancel()
+		}
+
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		if p.readTimeout == 0 && p.writeTimeout == 0 {
+			return conn, nil
+		}
+
+		return &deadlineConn{Conn: conn, readTimeout: p.readTimeout, writeTimeout: p.writeTimeout}, nil
+	}
+}
+
+// deadlineConn extends the deadline of a connection before each read and write, so
+// that an unresponsive server results in an error rather than hanging indefinitely
+type deadlineConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Write(b)
+}
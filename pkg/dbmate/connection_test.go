@@ -0,0 +1,110 @@
+package dbmate
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConnectionParams(t *testing.T) {
+	u, err := url.Parse("postgres://host/db?sslmode=disable&connect_timeout=10&read_timeout=1m&write_timeout=500ms&max_open_conns=2")
+	require.NoError(t, err)
+
+	params, stripped, err := parseConnectionParams(u, nil)
+	require.NoError(t, err)
+	require.Equal(t, connectionParams{
+		connectTimeout: 10 * time.Second,
+		readTimeout:    time.Minute,
+		writeTimeout:   500 * time.Millisecond,
+		maxOpenConns:   2,
+	}, params)
+	require.Equal(t, "postgres://host/db?sslmode=disable", stripped.String())
+	// the original url is not modified
+	require.Contains(t, u.String(), "connect_timeout=10")
+
+	// session setup statements may be repeated, and keep their order
+	u, err = url.Parse("postgres://host/db?session_setup=SET+ROLE+deployer&sslmode=disable&session_setup=SET+lock_timeout+%3D+%275s%27")
+	require.NoError(t, err)
+	params, stripped, err = parseConnectionParams(u, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"SET ROLE deployer", "SET lock_timeout = '5s'"}, params.sessionSetup)
+	require.Equal(t, "postgres://host/db?sslmode=disable", stripped.String())
+
+	// create_missing_schema overrides DB.CreateMissingSchema
+	u, err = url.Parse("postgres://host/db?create_missing_schema=false&sslmode=disable")
+	require.NoError(t, err)
+	params, stripped, err = parseConnectionParams(u, nil)
+	require.NoError(t, err)
+	require.NotNil(t, params.createMissingSchema)
+	require.False(t, *params.createMissingSchema)
+	require.Equal(t, "postgres://host/db?sslmode=disable", stripped.String())
+
+	// parameters which the driver understands itself are left in the url
+	u, err = url.Parse("postgres://host/db?connect_timeout=10&read_timeout=1m")
+	require.NoError(t, err)
+	params, stripped, err = parseConnectionParams(u, []string{ConnectTimeoutParam})
+	require.NoError(t, err)
+	require.Equal(t, connectionParams{readTimeout: time.Minute}, params)
+	require.Equal(t, "postgres://host/db?connect_timeout=10", stripped.String())
+
+	// urls without parameters are returned unchanged
+	u, err = url.Parse("postgres://host/db?sslmode=disable")
+	require.NoError(t, err)
+	params, stripped, err = parseConnectionParams(u, nil)
+	require.NoError(t, err)
+	require.Equal(t, connectionParams{}, params)
+	require.Same(t, u, stripped)
+
+	for _, s := range []string{
+		"postgres://host/db?connect_timeout=soon",
+		"postgres://host/db?read_timeout=-1s",
+		"postgres://host/db?max_open_conns=0",
+		"postgres://host/db?create_missing_schema=maybe",
+	} {
+		u, err := url.Parse(s)
+		require.NoError(t, err)
+		_, _, err = parseConnectionParams(u, nil)
+		require.ErrorIs(t, err, ErrInvalidConnectionParam, s)
+	}
+}
+
+func TestConnectionParamsDialContext(t *testing.T) {
+	// no timeouts leaves the dialer unchanged
+	require.Nil(t, connectionParams{maxOpenConns: 1}.dialContext(nil))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// accept a connection, but never respond
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	dial := connectionParams{readTimeout: 10 * time.Millisecond}.dialContext(nil)
+	conn, err := dial(context.Background(), "tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+
+	// the connect timeout is applied to the context passed to the dialer
+	var deadline time.Time
+	dial = connectionParams{connectTimeout: time.Minute}.dialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		deadline, _ = ctx.Deadline()
+		return nil, context.Canceled
+	})
+	_, err = dial(context.Background(), "tcp", "127.0.0.1:1")
+	require.ErrorIs(t, err, context.Canceled)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+}
@@ -0,0 +1,92 @@
+package dbmate
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrCopyUnsupported = errors.New("COPY ... FROM STDIN is not supported by this driver")
+	ErrParseCopyData   = errors.New(`dbmate requires the data of each COPY ... FROM STDIN statement to end with a line containing only '\.'`)
+)
+
+// copyDataTerminator ends the data of a COPY ... FROM STDIN statement, as in psql
+const copyDataTerminator = `\.`
+
+var (
+	// copyFromStdinRegExp matches a COPY ... FROM STDIN statement, after any comments
+	copyFromStdinRegExp = regexp.MustCompile(`(?is)^(?:\s+|--[^\n]*\n|/\*.*?\*/)*(copy\s.*\bfrom\s+stdin\b.*)$`)
+	// copyFromStdinLineRegExp matches a line which may start a COPY ... FROM STDIN statement
+	copyFromStdinLineRegExp = regexp.MustCompile(`(?im)^\s*copy\s[^;]*\bfrom\s+stdin\b`)
+)
+
+// isCopyFromStdin returns true if stmt is a COPY ... FROM STDIN statement, which is
+// followed by rows of data, as in psql scripts and pg_dump output
+func isCopyFromStdin(stmt string) bool {
+	return copyFromStdinRegExp.MatchString(stmt)
+}
+
+// hasCopyData returns true if SQL text may contain a COPY ... FROM STDIN statement, and
+// must therefore be executed one statement at a time
+func hasCopyData(text string) bool {
+	return copyFromStdinLineRegExp.MatchString(text)
+}
+
+// joinCopyData returns a COPY ... FROM STDIN statement followed by its data, as passed to
+// a statementFunc
+func joinCopyData(stmt, data string) string {
+	return stmt + ";\n" + data + copyDataTerminator
+}
+
+// cutCopyData splits a statement returned by joinCopyData into the COPY ... FROM STDIN
+// statement (without leading comments) and its data, which ends with a newline unless
+// it is empty
+func cutCopyData(stmt string) (query, data string, ok bool) {
+	query, data, found := strings.Cut(stmt, ";\n")
+	if !found || !strings.HasSuffix(data, copyDataTerminator) {
+		return "", "", false
+	}
+
+	match := copyFromStdinRegExp.FindStringSubmatch(query)
+	if match == nil {
+		return "", "", false
+	}
+
+	return match[1], strings.TrimSuffix(data, copyDataTerminator), true
+}
+
+// execCopy loads the data of a COPY ... FROM STDIN statement using the driver, logging
+// its result as a debug message, and the statement and how long it took if statement
+// tracing is enabled
+func (db *DB) execCopy(tx dbutil.Transaction, query, data string) (int64, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return -1, err
+	}
+	loader, ok := drv.(copyLoader)
+	if !ok {
+		return -1, ErrCopyUnsupported
+	}
+
+	db.tracef("Executing:\n%s", query)
+
+	start := time.Now()
+	rows, err := loader.CopyFrom(tx, query, data)
+	duration := time.Since(start)
+	db.logSlow(query, duration, err)
+	if err != nil {
+		return -1, err
+	}
+
+	db.logger().Debugf("Rows affected: %d", rows)
+	db.tracef("Duration: %s", duration.Round(time.Microsecond))
+
+	db.emit(StatementExecuted{SQL: query, RowsAffected: rows, Duration: duration})
+
+	return rows, nil
+}
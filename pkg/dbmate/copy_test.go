@@ -0,0 +1,35 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCutCopyData(t *testing.T) {
+	query, data, ok := cutCopyData("-- load\nCOPY t (a, b) FROM stdin;\n1\tx\n2\ty\n\\.")
+	require.True(t, ok)
+	require.Equal(t, "COPY t (a, b) FROM stdin", query)
+	require.Equal(t, "1\tx\n2\ty\n", data)
+
+	query, data, ok = cutCopyData(joinCopyData("copy t from stdin", ""))
+	require.True(t, ok)
+	require.Equal(t, "copy t from stdin", query)
+	require.Equal(t, "", data)
+
+	for _, stmt := range []string{
+		"select 1",
+		"copy t from stdin",
+		"copy t to stdout;\n\\.",
+		"select ';\n\\.'",
+	} {
+		_, _, ok := cutCopyData(stmt)
+		require.False(t, ok, stmt)
+	}
+}
+
+func TestHasCopyData(t *testing.T) {
+	require.True(t, hasCopyData("-- migrate:up\ncopy users (id) from stdin;\n1\n\\.\n"))
+	require.True(t, hasCopyData("select 1;\n  COPY users\n  FROM STDIN;\n"))
+	require.False(t, hasCopyData("copy users to stdout;\ncopy users from '/tmp/users.csv';\n"))
+}
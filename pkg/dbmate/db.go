@@ -1,6 +1,7 @@
 package dbmate
 
 import (
+	"bytes"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,54 +12,230 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/pmezard/go-difflib/difflib"
 )
 
 // Error codes
 var (
-	ErrNoMigrationFiles      = errors.New("no migration files found")
-	ErrInvalidURL            = errors.New("invalid url, have you set your --url flag or DATABASE_URL environment variable?")
-	ErrNoRollback            = errors.New("can't rollback: no migrations have been applied")
-	ErrCantConnect           = errors.New("unable to connect to database")
-	ErrUnsupportedDriver     = errors.New("unsupported driver")
-	ErrNoMigrationName       = errors.New("please specify a name for the new migration")
-	ErrMigrationAlreadyExist = errors.New("file already exists")
-	ErrMigrationDirNotFound  = errors.New("could not find migrations directory")
-	ErrMigrationNotFound     = errors.New("can't find migration file")
-	ErrCreateDirectory       = errors.New("unable to create directory")
+	ErrNoMigrationFiles        = errors.New("no migration files found")
+	ErrInvalidURL              = errors.New("invalid url, have you set your --url flag or DATABASE_URL environment variable?")
+	ErrNoRollback              = errors.New("can't rollback: no migrations have been applied")
+	ErrCantConnect             = errors.New("unable to connect to database")
+	ErrUnsupportedDriver       = errors.New("unsupported driver")
+	ErrNoMigrationName         = errors.New("please specify a name for the new migration")
+	ErrMigrationAlreadyExist   = errors.New("file already exists")
+	ErrMigrationDirNotFound    = errors.New("could not find migrations directory")
+	ErrMigrationNotFound       = errors.New("can't find migration file")
+	ErrMigrationAlreadyApplied = errors.New("migration has already been applied")
+	ErrMigrationSkipped        = errors.New("migration is skipped")
+	ErrNoMigrationVersion      = errors.New("please specify a migration version")
+	ErrCreateDirectory         = errors.New("unable to create directory")
+	ErrLocksUnsupported        = errors.New("lock analysis is not supported by this driver")
+	ErrTruncateUnsupported     = errors.New("truncating tables is not supported by this driver")
+	ErrSchemaNotFound          = errors.New("schema does not exist")
+	// ErrCreateMissingSchemaUnsupported is returned if CreateMissingSchema is false, or
+	// the create_missing_schema URL parameter is set, for a driver which never creates
+	// the schema of the migrations table
+	ErrCreateMissingSchemaUnsupported = errors.New("create_missing_schema is only supported by drivers whose migrations table may be in a schema (postgres and redshift)")
+	ErrMigrationIrreversible          = errors.New("can't rollback: migration is irreversible")
+	// ErrSkipUnsupported is returned if SkipVersions lists a pending migration, for a
+	// driver which can't record skipped migrations in the migrations table
+	ErrSkipUnsupported = errors.New("skipping migrations is not supported by this driver")
 )
 
 // migrationFileRegexp pattern for valid migration files
-var migrationFileRegexp = regexp.MustCompile(`^(\d+).*\.sql$`)
+var migrationFileRegexp = regexp.MustCompile(`^(` + versionPattern + `).*\.sql$`)
 
 // DB allows dbmate actions to be performed on a specified database
 type DB struct {
+	// AdditionalURLs specifies further databases which Migrate and CreateAndMigrate apply
+	// the same migrations to, after DatabaseURL. They are migrated in the same way as the
+	// shards of a shard range, and other actions return ErrShardsUnsupported.
+	AdditionalURLs []*url.URL
+	// Adopt converts a migrations table created by another migration tool which
+	// ImportHistory supports (e.g. golang-migrate, whose default table name is the same)
+	// before migrating, instead of returning ErrIncompatibleMigrationsTable
+	Adopt bool
+	// Audit records each migration run, along with the host and user which ran it, in
+	// a table named after the migrations table with AuditTableSuffix appended
+	Audit bool
 	// AutoDumpSchema generates schema.sql after each action
 	AutoDumpSchema bool
+	// Connection specifies an existing database connection to use, or nil to open
+	// connections from DatabaseURL. DatabaseURL is still required to select the driver,
+	// and is used for actions which need their own connection (create, drop, dump).
+	// Shard ranges and AdditionalURLs can't be migrated through it.
+	Connection *sql.DB
+	// CreateMissingSchema creates the schema containing the migrations table if it does
+	// not exist, instead of returning ErrSchemaNotFound. It is overridden by the
+	// create_missing_schema URL parameter. Setting either to false for a driver which
+	// never creates schemas returns ErrCreateMissingSchemaUnsupported.
+	CreateMissingSchema bool
 	// DatabaseURL is the database connection string
 	DatabaseURL *url.URL
+	// DialContext specifies a custom dialer for network drivers, or nil to dial directly
+	DialContext DialContextFunc
+	// Environment is the environment being migrated, e.g. staging. Migrations with an
+	// env option in their up block are skipped unless it lists this environment.
+	Environment string
+	// EventHandler receives events as migrations are applied and rolled back, which
+	// can be used to report progress or record metrics (nil to disable)
+	EventHandler func(Event)
+	// FailOnModified makes Status return ErrMigrationModified if the file of an applied
+	// migration no longer matches the checksum recorded in the audit table
+	FailOnModified bool
+	// FormatSchema canonicalizes schema dumps (keyword case, indentation, and trailing
+	// semicolons), so that they do not depend on the dump tool or server version
+	FormatSchema bool
 	// FS specifies the filesystem, or nil for OS filesystem
 	FS fs.FS
-	// Log is the interface to write stdout
+	// GolangMigrateTable specifies a golang-migrate table to keep in sync with applied
+	// migrations, so both tools can be used during a transition (empty to disable)
+	GolangMigrateTable string
+	// LockTimeout limits how long a statement waits to acquire a lock (0 for no limit)
+	LockTimeout time.Duration
+	// Locker is held while migrations are applied or rolled back, so that concurrent runs
+	// wait for each other (nil to disable)
+	Locker Locker
+	// Log is the interface to write stdout, used if Logger is nil
 	Log io.Writer
+	// LogLevel discards messages more verbose than this level (one of LogLevels). If
+	// empty, messages written to Log are limited to LogLevelInfo, and every message is
+	// passed to Logger, which applies its own level.
+	LogLevel string
+	// LogSlow logs a warning with the text and duration of each statement which takes
+	// longer than this to execute, or to fail (0 to disable). It does not change how
+	// migrations are executed, so a migration which is not split into statements (see
+	// StreamThreshold) is timed as a whole.
+	LogSlow time.Duration
+	// Logger receives dbmate's output, or nil to write to Log
+	Logger Logger
+	// MigrationRetries specifies how many times to retry a migration which fails with a
+	// transient error, such as a dropped connection or deadlock (0 to disable)
+	MigrationRetries int
+	// MigrationRetryInterval specifies the initial delay between retries, which doubles
+	// after each attempt
+	MigrationRetryInterval time.Duration
 	// MigrationsDir specifies the directory or directories to find migration files
 	MigrationsDir []string
 	// MigrationsTableName specifies the database table to record migrations in
 	MigrationsTableName string
-	// SchemaFile specifies the location for schema.sql file
+	// OperationPollInterval specifies how often long running operations, such as Spanner
+	// schema updates, are polled for progress
+	OperationPollInterval time.Duration
+	// OperationTimeout specifies the maximum time to wait for a long running operation
+	// (0 for no limit)
+	OperationTimeout time.Duration
+	// Parallel specifies how many shards or databases are migrated at once (0 or 1 to
+	// migrate them in turn)
+	Parallel int
+	// Phase restricts pending migrations to those in this phase (PhaseExpand or
+	// PhaseContract), so that backward compatible changes can be applied before a new
+	// version of an application is rolled out, and destructive changes after (empty to
+	// apply migrations of every phase)
+	Phase string
+	// PlanKey is the secret key used to sign plans created by Plan, and to verify the
+	// plans given to ApplyPlan (empty for unsigned plans)
+	PlanKey []byte
+	// ReplicationMaxLag is the number of bytes a logical replication slot may lag behind
+	// when ReplicationSafe is set (0 for no limit)
+	ReplicationMaxLag int64
+	// ReplicationRole sets session_replication_role for each session, for example
+	// replica to skip triggers while applying migrations to a subscriber (empty to leave
+	// the server default). Only used by postgres.
+	ReplicationRole string
+	// ReplicationSafe checks that logical replication is healthy before each migration is
+	// applied or rolled back, and fails with ErrReplicationUnhealthy otherwise. Requires a
+	// driver which supports replication checks (postgres).
+	ReplicationSafe bool
+	// RequireDown fails before applying any migration if a pending migration has an
+	// empty down block which is not marked irreversible
+	RequireDown bool
+	// Savepoints executes each statement of a transactional migration separately, within
+	// its own savepoint, so that errors identify the statement which failed. Only used by
+	// drivers which support savepoints (postgres).
+	Savepoints bool
+	// SchemaDir specifies the directory containing declarative table definitions, used
+	// to generate migrations
+	SchemaDir string
+	// SchemaFile specifies the location for schema.sql file (or directory, if
+	// SchemaFormat is SchemaFormatDirectory)
 	SchemaFile string
+	// SchemaFormat specifies whether the schema is written to a single file
+	// (SchemaFormatFile) or one file per object (SchemaFormatDirectory)
+	SchemaFormat string
+	// SchemaHeader adds a comment to the schema file recording the dbmate and database
+	// server versions (SchemaHeaderReproducible), and the dump time (SchemaHeaderFull).
+	// If empty, no header is written. Not used by the directory format.
+	SchemaHeader string
+	// SchemaMigrations specifies where the dbmate schema migrations section of the dump is
+	// written: at the end of the schema file (SchemaMigrationsInclude), nowhere
+	// (SchemaMigrationsOmit), or to SchemaMigrationsFile (SchemaMigrationsSeparate)
+	SchemaMigrations string
+	// SchemaMigrationsFile specifies the location of the schema migrations file, used if
+	// SchemaMigrations is SchemaMigrationsSeparate
+	SchemaMigrationsFile string
+	// SessionSetup specifies SQL statements executed on each connection before it is used,
+	// such as SET ROLE or SET search_path, followed by any session_setup URL parameters.
+	// Since session settings only apply to the connection they are made on, the driver
+	// executes them on every connection it opens, and drivers which can't return
+	// ErrSessionSetupUnsupported. They are not executed on Connection, which the caller is
+	// responsible for setting up. Drivers which dump the schema with an external tool apply
+	// them to the dump where possible (pg_dump supports SET statements, mysqldump fails).
+	SessionSetup []string
+	// SkipVersions specifies pending migration versions which are not applied, for
+	// example to temporarily exclude a broken migration in one environment
+	SkipVersions []string
+	// StatementTimeout limits how long a single statement may run (0 for no limit)
+	StatementTimeout time.Duration
+	// StatusURL specifies a read replica of the database to run Status and Drift against,
+	// instead of DatabaseURL (nil to use DatabaseURL). The replica must have the same
+	// fingerprint as the primary database, which requires driver support (postgres).
+	StatusURL *url.URL
+	// StreamThreshold specifies a file size in bytes above which migrations are streamed
+	// from disk one statement at a time, instead of being loaded into memory (0 to disable)
+	StreamThreshold int64
 	// Fail if migrations would be applied out of order
 	Strict bool
-	// Verbose prints the result of each statement execution
+	// StrictDDL returns ErrNonTransactionalDDL instead of printing a warning if a
+	// migration which runs in a transaction contains DDL, and the database commits DDL
+	// immediately
+	StrictDDL bool
+	// Tags restricts pending migrations to those with one of the listed tags, and
+	// excludes those with a tag listed with a "!" prefix, e.g. []string{"!slow"} (empty
+	// to apply migrations regardless of their tags)
+	Tags []string
+	// Verbose prints the result of each statement execution, overriding LogLevel with
+	// LogLevelDebug. The text and duration of each statement are only printed if LogLevel
+	// is LogLevelDebug.
 	Verbose bool
+	// VersionFormat specifies the format of new migration versions (one of the
+	// VersionFormat constants). If set, existing migration versions are validated
+	// against it. If empty, timestamps are generated without validation.
+	VersionFormat string
+	// VersionGenerator generates new migration versions instead of VersionFormat
+	// (nil to use VersionFormat)
+	VersionGenerator VersionGenerator
 	// WaitBefore will wait for database to become available before running any actions
 	WaitBefore bool
+	// WaitFailOn lists the connection failures (e.g. WaitFailureAuth) which stop Wait and
+	// WaitBefore immediately, instead of retrying until WaitTimeout
+	WaitFailOn []string
 	// WaitInterval specifies length of time between connection attempts
 	WaitInterval time.Duration
 	// WaitTimeout specifies maximum time for connection attempts
 	WaitTimeout time.Duration
+
+	// locked is set while Locker is held by an action
+	locked bool
+	// applyingPlan is the plan being applied by ApplyPlan, which migrate checks while
+	// holding the lock
+	applyingPlan *Plan
 }
 
 // StatusResult represents an available migration status
@@ -70,18 +247,61 @@ type StatusResult struct {
 // New initializes a new dbmate database
 func New(databaseURL *url.URL) *DB {
 	return &DB{
-		AutoDumpSchema:      true,
-		DatabaseURL:         databaseURL,
-		FS:                  nil,
-		Log:                 os.Stdout,
-		MigrationsDir:       []string{"./db/migrations"},
-		MigrationsTableName: "schema_migrations",
-		SchemaFile:          "./db/schema.sql",
-		Strict:              false,
-		Verbose:             false,
-		WaitBefore:          false,
-		WaitInterval:        time.Second,
-		WaitTimeout:         60 * time.Second,
+		AdditionalURLs:         nil,
+		Adopt:                  false,
+		Audit:                  false,
+		AutoDumpSchema:         true,
+		Connection:             nil,
+		CreateMissingSchema:    true,
+		DatabaseURL:            databaseURL,
+		DialContext:            nil,
+		Environment:            "",
+		EventHandler:           nil,
+		FailOnModified:         false,
+		FormatSchema:           false,
+		FS:                     nil,
+		GolangMigrateTable:     "",
+		LockTimeout:            0,
+		Locker:                 nil,
+		Log:                    os.Stdout,
+		LogLevel:               "",
+		LogSlow:                0,
+		Logger:                 nil,
+		MigrationRetries:       0,
+		MigrationRetryInterval: time.Second,
+		MigrationsDir:          []string{"./db/migrations"},
+		MigrationsTableName:    "schema_migrations",
+		OperationPollInterval:  10 * time.Second,
+		OperationTimeout:       0,
+		Parallel:               0,
+		Phase:                  "",
+		PlanKey:                nil,
+		ReplicationMaxLag:      0,
+		ReplicationRole:        "",
+		ReplicationSafe:        false,
+		RequireDown:            false,
+		Savepoints:             false,
+		SchemaDir:              "./db/schema",
+		SchemaFile:             "./db/schema.sql",
+		SchemaFormat:           SchemaFormatFile,
+		SchemaHeader:           "",
+		SchemaMigrations:       SchemaMigrationsInclude,
+		SchemaMigrationsFile:   "./db/schema_migrations.sql",
+		SessionSetup:           nil,
+		SkipVersions:           nil,
+		StatementTimeout:       0,
+		StatusURL:              nil,
+		StreamThreshold:        0,
+		Strict:                 false,
+		StrictDDL:              false,
+		Tags:                   nil,
+		Verbose:                false,
+		VersionFormat:          "",
+		VersionGenerator:       nil,
+		WaitBefore:             false,
+		WaitFailOn:             []string{WaitFailureAuth, WaitFailureTLS},
+		WaitInterval:           time.Second,
+		WaitTimeout:            60 * time.Second,
 	}
 }
 
@@ -95,14 +315,52 @@ func (db *DB) Driver() (Driver, error) {
 	if driverFunc == nil {
 		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDriver, db.DatabaseURL.Scheme)
 	}
+	if db.sharded() {
+		return nil, ErrShardsUnsupported
+	}
+
+	databaseURL, err := resolvePasswordFile(db.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+	databaseURL, err = resolvePasswordKeyring(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	// the driver is asked which parameters it understands itself before it is created
+	// with the url they are removed from
+	native := nativeConnectionParams(driverFunc(DriverConfig{DatabaseURL: databaseURL}))
+	params, databaseURL, err := parseConnectionParams(databaseURL, native)
+	if err != nil {
+		return nil, err
+	}
+
+	createMissingSchema := db.CreateMissingSchema
+	if params.createMissingSchema != nil {
+		createMissingSchema = *params.createMissingSchema
+	}
 
 	config := DriverConfig{
-		DatabaseURL:         db.DatabaseURL,
-		Log:                 db.Log,
+		CreateMissingSchema: createMissingSchema,
+		DatabaseURL:         databaseURL,
+		DialContext:         params.dialContext(db.DialContext),
+		LockTimeout:         db.LockTimeout,
+		Log:                 logWriter{db: db},
 		MigrationsTableName: db.MigrationsTableName,
+		ReplicationRole:     db.ReplicationRole,
+		SessionSetup:        db.sessionSetup(params),
+		StatementTimeout:    db.StatementTimeout,
+		WaitOperation:       db.waitOperation,
 	}
 	drv := driverFunc(config)
 
+	if _, ok := drv.(missingSchemaCreator); !ok && (!createMissingSchema || params.createMissingSchema != nil) {
+		return nil, fmt.Errorf("%w: %s", ErrCreateMissingSchemaUnsupported, db.DatabaseURL.Scheme)
+	}
+	if len(config.SessionSetup) > 0 && !supportsSessionSetup(drv) {
+		return nil, fmt.Errorf("%w: %s", ErrSessionSetupUnsupported, db.DatabaseURL.Scheme)
+	}
+
 	if db.WaitBefore {
 		if err := db.wait(drv); err != nil {
 			return nil, err
@@ -113,32 +371,51 @@ func (db *DB) Driver() (Driver, error) {
 }
 
 func (db *DB) wait(drv Driver) error {
+	if err := validateWaitFailOn(db.WaitFailOn); err != nil {
+		return err
+	}
+
 	// attempt connection to database server
-	err := drv.Ping()
+	err := db.ping(drv)
 	if err == nil {
 		// connection successful
+		db.emit(Connected{})
 		return nil
 	}
+	if err := db.checkWaitFailure(drv, err); err != nil {
+		return err
+	}
 
-	fmt.Fprint(db.Log, "Waiting for database")
+	db.logger().Infof("Waiting for database")
 	for i := 0 * time.Second; i < db.WaitTimeout; i += db.WaitInterval {
-		fmt.Fprint(db.Log, ".")
+		db.logger().Debugf("Unable to connect: %s", err)
 		time.Sleep(db.WaitInterval)
 
 		// attempt connection to database server
-		err = drv.Ping()
+		err = db.ping(drv)
 		if err == nil {
 			// connection successful
-			fmt.Fprint(db.Log, "\n")
+			db.emit(Connected{})
 			return nil
 		}
+		if err := db.checkWaitFailure(drv, err); err != nil {
+			return err
+		}
 	}
 
 	// if we find outselves here, we could not connect within the timeout
-	fmt.Fprint(db.Log, "\n")
 	return fmt.Errorf("%w: %s", ErrCantConnect, err)
 }
 
+// ping verifies the supplied connection if available, otherwise the driver connection
+func (db *DB) ping(drv Driver) error {
+	if db.Connection != nil {
+		return db.Connection.Ping()
+	}
+
+	return drv.Ping()
+}
+
 // Wait blocks until the database server is available. It does not verify that
 // the specified database exists, only that the host is ready to accept connections.
 func (db *DB) Wait() error {
@@ -151,8 +428,15 @@ func (db *DB) Wait() error {
 	return db.wait(drv)
 }
 
-// CreateAndMigrate creates the database (if necessary) and runs migrations
+// CreateAndMigrate creates the database (if necessary) and runs migrations. If the
+// database URL contains a shard range, each shard is created and migrated in turn.
 func (db *DB) CreateAndMigrate() error {
+	if db.sharded() {
+		return db.migrateShards(func(shard *DB) error {
+			return shard.CreateAndMigrate()
+		})
+	}
+
 	drv, err := db.Driver()
 	if err != nil {
 		return err
@@ -172,7 +456,7 @@ func (db *DB) CreateAndMigrate() error {
 	return db.Migrate()
 }
 
-// Create creates the current database
+// Create creates the current database (if it does not already exist)
 func (db *DB) Create() error {
 	drv, err := db.Driver()
 	if err != nil {
@@ -192,33 +476,192 @@ func (db *DB) Drop() error {
 	return drv.DropDatabase()
 }
 
+// Truncate deletes all data from the current database, while preserving the schema
+// and the list of applied migrations
+func (db *DB) Truncate() error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	truncater, ok := drv.(tableTruncater)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTruncateUnsupported, db.DatabaseURL.Scheme)
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	db.logger().Infof("Truncating tables")
+
+	return truncater.TruncateTables(sqlDB)
+}
+
 // DumpSchema writes the current database schema to a file
-func (db *DB) DumpSchema() error {
+func (db *DB) DumpSchema() (err error) {
+	defer db.emitError(&err)
+
+	return db.dumpSchemaFile()
+}
+
+// autoDumpSchema writes the schema file after migrations are applied or rolled back, if
+// AutoDumpSchema is set. A failure is not returned, since the migrations have been
+// applied, but is emitted as a single Error event.
+func (db *DB) autoDumpSchema() {
+	if !db.AutoDumpSchema {
+		return
+	}
+
+	if err := db.dumpSchemaFile(); err != nil {
+		db.emit(Error{Err: err})
+	}
+}
+
+// dumpSchemaFile writes the current database schema to a file, without emitting an
+// Error event if it fails
+func (db *DB) dumpSchemaFile() error {
 	drv, err := db.Driver()
 	if err != nil {
 		return err
 	}
 
-	sqlDB, err := db.openDatabaseForMigration(drv)
+	sqlDB, err := db.openDatabaseForDump(drv)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer db.closeDatabase(sqlDB)
 
-	schema, err := drv.DumpSchema(sqlDB)
+	schema, err := db.dumpSchema(drv, sqlDB)
+	if err != nil {
+		return err
+	}
+
+	mode, err := db.schemaMigrations()
 	if err != nil {
 		return err
 	}
+	var migrations []byte
+	if mode != SchemaMigrationsInclude {
+		schema, migrations = cutSchemaMigrations(schema)
+	}
 
-	fmt.Fprintf(db.Log, "Writing: %s\n", db.SchemaFile)
+	directory, err := db.schemaDirectory()
+	if err != nil {
+		return err
+	}
+	if !directory {
+		header, err := db.schemaHeader(drv, sqlDB)
+		if err != nil {
+			return err
+		}
+		schema = append([]byte(header), schema...)
+	}
 
-	// ensure schema directory exists
-	if err = ensureDir(filepath.Dir(db.SchemaFile)); err != nil {
+	if err := db.writeSchema(drv, schema); err != nil {
 		return err
 	}
+	if mode == SchemaMigrationsSeparate {
+		if err := db.writeSchemaMigrations(migrations); err != nil {
+			return err
+		}
+	}
+
+	db.emit(SchemaDumped{Path: db.SchemaFile})
+
+	return nil
+}
+
+// dumpSchema returns the current database schema, formatted if db.FormatSchema is set
+func (db *DB) dumpSchema(drv Driver, sqlDB *sql.DB) ([]byte, error) {
+	schema, err := drv.DumpSchema(sqlDB)
+	if err != nil || !db.FormatSchema {
+		return schema, err
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	return formatSchema(schema, backslashEscapes), nil
+}
+
+// Drift compares the current database schema with the schema file, printing a unified
+// diff if they differ. Returns true if the database has drifted from the schema file.
+func (db *DB) Drift() (bool, error) {
+	if db.StatusURL != nil {
+		replica, err := db.statusDB()
+		if err != nil {
+			return false, err
+		}
+		return replica.Drift()
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return false, err
+	}
+
+	expected, err := db.expectedSchema(drv)
+	if err != nil {
+		return false, err
+	}
+	mode, err := db.schemaMigrations()
+	if err != nil {
+		return false, err
+	}
 
-	// write schema to file
-	return os.WriteFile(db.SchemaFile, schema, 0o644)
+	// the check is read only, so the migrations table is not created if it is missing
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return false, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil {
+		return false, classifyError(drv, err, nil)
+	}
+	if !exists {
+		db.logger().Warnf("Database has no migrations table, so it has drifted from %s", db.SchemaFile)
+		return true, nil
+	}
+
+	actual, err := db.dumpSchema(drv, sqlDB)
+	if err != nil {
+		return false, err
+	}
+	// the migrations section is not compared if it is not written to the schema file
+	if mode != SchemaMigrationsInclude {
+		actual, _ = cutSchemaMigrations(actual)
+	}
+	if actual, err = db.normalizeSchema(drv, actual); err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(expected, actual) {
+		db.logger().Infof("No schema drift detected")
+		return false, nil
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(expected)),
+		B:        difflib.SplitLines(string(actual)),
+		FromFile: db.SchemaFile,
+		ToFile:   "database",
+		Context:  3,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	db.logger().Infof("%s", strings.TrimSuffix(diff, "\n"))
+	db.logger().Warnf("Database schema has drifted from %s", db.SchemaFile)
+
+	return true, nil
 }
 
 // ensureDir creates a directory if it does not already exist
@@ -234,38 +677,131 @@ const migrationTemplate = "-- migrate:up\n\n\n-- migrate:down\n\n"
 
 // NewMigration creates a new migration file
 func (db *DB) NewMigration(name string) error {
-	// new migration name
-	timestamp := time.Now().UTC().Format("20060102150405")
+	return db.NewMigrationWithUp(name, "")
+}
+
+// NewMigrationWithUp creates a new migration file containing the given up block, and
+// a best-effort down block generated from it. Statements which can't be reversed
+// automatically are marked with a TODO comment in the down block.
+func (db *DB) NewMigrationWithUp(name, up string) error {
+	_, err := db.NewMigrationWithUpDown(name, up, "")
+	return err
+}
+
+// NewMigrationWithUpDown creates a new migration file containing the given up and down
+// blocks, and returns its path. If down is empty, it is generated from up as in
+// NewMigrationWithUp.
+func (db *DB) NewMigrationWithUpDown(name, up, down string) (string, error) {
+	up = strings.TrimSpace(up)
+	down = strings.TrimSpace(down)
+	if down == "" && up != "" {
+		down = reverseMigration(up, db.dropIndexRequiresTable())
+	}
+
+	contents := migrationTemplate
+	if up != "" || down != "" {
+		contents = fmt.Sprintf("-- migrate:up\n%s\n\n-- migrate:down\n%s\n", up, down)
+	}
+
+	return db.newMigrationFile(name, contents)
+}
+
+// NewMigrationWithContents creates a new migration file containing a complete migration,
+// which must define up and down blocks, and returns its path
+func (db *DB) NewMigrationWithContents(name, contents string) (string, error) {
+	if _, err := parseMigrationContents(contents); err != nil {
+		return "", err
+	}
+
+	return db.newMigrationFile(name, strings.TrimRight(contents, "\n")+"\n")
+}
+
+// newMigrationFile writes the contents of a new migration, and returns its path
+func (db *DB) newMigrationFile(name, contents string) (string, error) {
 	if name == "" {
-		return ErrNoMigrationName
+		return "", ErrNoMigrationName
 	}
-	name = fmt.Sprintf("%s_%s.sql", timestamp, name)
 
 	// create migrations dir if missing
 	if err := ensureDir(db.MigrationsDir[0]); err != nil {
-		return err
+		return "", err
+	}
+
+	// new migration name
+	version, err := db.nextVersion(time.Now())
+	if err != nil {
+		return "", err
 	}
+	name = fmt.Sprintf("%s_%s.sql", version, name)
 
 	// check file does not already exist
 	path := filepath.Join(db.MigrationsDir[0], name)
-	fmt.Fprintf(db.Log, "Creating migration: %s\n", path)
+	db.logger().Infof("Creating migration: %s", path)
 
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
-		return ErrMigrationAlreadyExist
+		return "", ErrMigrationAlreadyExist
 	}
 
 	// write new migration
 	file, err := os.Create(path)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	defer dbutil.MustClose(file)
-	_, err = file.WriteString(migrationTemplate)
-	return err
+
+	if _, err := file.WriteString(contents); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// dropIndexRequiresTable returns true if the database uses DROP INDEX name ON table syntax
+func (db *DB) dropIndexRequiresTable() bool {
+	if db.DatabaseURL == nil {
+		return false
+	}
+
+	switch db.DatabaseURL.Scheme {
+	case "mysql", "mariadb":
+		return true
+	}
+
+	return false
+}
+
+// supportsTransactions returns false if the driver reports that the database does not
+// support transactions
+func supportsTransactions(drv Driver) bool {
+	ts, ok := drv.(transactionSupporter)
+	return !ok || ts.Transactions()
+}
+
+// withoutTransaction returns options with transaction:false if the driver does not
+// support transactions, so that migrations are executed as if they set it
+func withoutTransaction(drv Driver, options migrationOptions) migrationOptions {
+	if supportsTransactions(drv) || !options.Transaction() {
+		return options
+	}
+
+	result := make(migrationOptions, len(options)+1)
+	for key, value := range options {
+		result[key] = value
+	}
+	result["transaction"] = "false"
+
+	return result
 }
 
-func doTransaction(sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
+// doTransaction runs txFunc in a transaction, which is committed if it succeeds, and
+// rolled back otherwise. If the driver does not support transactions, txFunc is run
+// outside of one.
+func doTransaction(drv Driver, sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
+	if !supportsTransactions(drv) {
+		return txFunc(sqlDB)
+	}
+
 	tx, err := sqlDB.Begin()
 	if err != nil {
 		return err
@@ -282,108 +818,431 @@ func doTransaction(sqlDB *sql.DB, txFunc func(dbutil.Transaction) error) error {
 	return tx.Commit()
 }
 
-func (db *DB) openDatabaseForMigration(drv Driver) (*sql.DB, error) {
+// openDatabase returns the connection supplied in db.Connection if available,
+// otherwise it opens a new connection using the driver
+func (db *DB) openDatabase(drv Driver) (*sql.DB, error) {
+	if db.Connection != nil {
+		return db.Connection, nil
+	}
+
 	sqlDB, err := drv.Open()
 	if err != nil {
 		return nil, err
 	}
 
-	if err := drv.CreateMigrationsTable(sqlDB); err != nil {
+	// the url was validated when the driver was created
+	params, _, _ := parseConnectionParams(db.DatabaseURL, nativeConnectionParams(drv))
+	if params.maxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(params.maxOpenConns)
+	}
+
+	return sqlDB, nil
+}
+
+// closeDatabase closes a connection returned by openDatabase, unless it was
+// supplied by the caller (who remains responsible for closing it)
+func (db *DB) closeDatabase(sqlDB *sql.DB) {
+	if sqlDB != db.Connection {
 		dbutil.MustClose(sqlDB)
+	}
+}
+
+// openDatabaseForMigration opens the database, checks that it can be migrated, and
+// creates the migrations table if necessary
+func (db *DB) openDatabaseForMigration(drv Driver) (*sql.DB, error) {
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+
+	if checker, ok := drv.(writableChecker); ok {
+		if err := checker.CheckWritable(sqlDB); err != nil {
+			db.closeDatabase(sqlDB)
+			return nil, err
+		}
+	}
+
+	if err := db.createMigrationsTable(drv, sqlDB); err != nil {
+		db.closeDatabase(sqlDB)
+		return nil, err
+	}
+
+	return sqlDB, nil
+}
+
+// openDatabaseForDump opens the database, and creates the migrations table if it does
+// not exist, so that it is part of the schema. Unlike openDatabaseForMigration, the
+// database may be read-only (e.g. a replica) if the table already exists.
+func (db *DB) openDatabaseForDump(drv Driver) (*sql.DB, error) {
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err == nil && !exists {
+		err = db.createMigrationsTable(drv, sqlDB)
+	}
+	if err != nil {
+		db.closeDatabase(sqlDB)
 		return nil, err
 	}
 
 	return sqlDB, nil
 }
 
-// Migrate migrates database to the latest version
+// createMigrationsTable creates the migrations table, and the audit table if auditing
+// is enabled
+func (db *DB) createMigrationsTable(drv Driver, sqlDB *sql.DB) error {
+	if err := drv.CreateMigrationsTable(sqlDB); err != nil {
+		return classifyError(drv, err, nil)
+	}
+
+	return db.createAuditTable(drv, sqlDB)
+}
+
+// Migrate migrates database to the latest version. If the database URL contains a shard
+// range, each shard is migrated in turn.
 func (db *DB) Migrate() error {
+	if db.sharded() {
+		return db.migrateShards(func(shard *DB) error {
+			return shard.Migrate()
+		})
+	}
+
+	return db.migrate("")
+}
+
+// MigrateVersion applies a single pending migration, without applying any other
+// pending migrations
+func (db *DB) MigrateVersion(version string) error {
+	if version == "" {
+		return ErrNoMigrationVersion
+	}
+
+	return db.migrate(version)
+}
+
+// migrate applies pending migrations, or only the specified version if not empty
+func (db *DB) migrate(version string) (err error) {
+	defer db.emitError(&err)
+
+	if db.Strict && db.Phase != "" {
+		return ErrPhaseStrict
+	}
+
 	drv, err := db.Driver()
 	if err != nil {
 		return err
 	}
 
-	migrations, err := db.FindMigrations()
+	unlock, err := db.lock()
 	if err != nil {
 		return err
 	}
+	defer unlock(&err)
 
-	if len(migrations) == 0 {
-		return ErrNoMigrationFiles
+	if db.Adopt {
+		if err := db.adoptMigrationsTable(drv); err != nil {
+			return err
+		}
 	}
 
-	highestAppliedMigrationVersion := ""
-	pendingMigrations := []Migration{}
-	for _, migration := range migrations {
-		if migration.Applied {
-			if db.Strict && highestAppliedMigrationVersion <= migration.Version {
-				highestAppliedMigrationVersion = migration.Version
-			}
-		} else {
-			pendingMigrations = append(pendingMigrations, migration)
+	if db.applyingPlan != nil {
+		if err := db.checkPlan(db.applyingPlan); err != nil {
+			return err
 		}
 	}
 
-	if len(pendingMigrations) > 0 && db.Strict && pendingMigrations[0].Version <= highestAppliedMigrationVersion {
-		return fmt.Errorf("migration `%s` is out of order with already applied migrations, the version number has to be higher than the applied migration `%s` in --strict mode", pendingMigrations[0].Version, highestAppliedMigrationVersion)
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) == 0 {
+		return ErrNoMigrationFiles
+	}
+
+	pendingMigrations, err := db.pendingMigrations(migrations, version)
+	if err != nil {
+		return err
 	}
 
 	sqlDB, err := db.openDatabaseForMigration(drv)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer db.closeDatabase(sqlDB)
 
-	for _, migration := range pendingMigrations {
-		fmt.Fprintf(db.Log, "Applying: %s\n", migration.FileName)
+	if version == "" {
+		if err := db.recordSkipped(drv, sqlDB, migrations); err != nil {
+			return err
+		}
+	}
+
+	for i, migration := range pendingMigrations {
+		if err := db.checkLock(); err != nil {
+			return err
+		}
+		if err := db.checkReplication(drv, sqlDB); err != nil {
+			return err
+		}
+
+		// emitted before logging, so that event handlers (e.g. Progress) can clear the
+		// terminal line first
+		startedAt := time.Now()
+		db.emit(MigrationStarted{Migration: migration, Position: i + 1, Total: len(pendingMigrations)})
+		db.logger().Infof("Applying: %s", migration.FileName)
 
-		parsed, err := migration.Parse()
+		if db.applyingPlan != nil {
+			if err := checkPlanChecksum(db.applyingPlan, migration); err != nil {
+				return err
+			}
+		}
+		options, execBlock, err := db.loadBlock(drv, migration, true)
 		if err != nil {
+			return classifyError(drv, err, ErrMigrationFailed)
+		}
+		if err := db.checkTransactionalDDL(drv, migration, true, options); err != nil {
 			return err
 		}
+		options = withoutTransaction(drv, options)
 
 		execMigration := func(tx dbutil.Transaction) error {
 			// run actual migration
-			result, err := tx.Exec(parsed.Up)
-			if err != nil {
-				return drv.QueryError(parsed.Up, err)
-			} else if db.Verbose {
-				db.printVerbose(result)
+			if _, err := execBlock(tx); err != nil {
+				return err
 			}
 
 			// record migration
-			return drv.InsertMigration(tx, migration.Version)
+			return insertMigration(drv, tx, migration)
 		}
 
-		if parsed.UpOptions.Transaction() {
-			// begin transaction
-			err = doTransaction(sqlDB, execMigration)
+		// the audit record is inserted in the same transaction as the migration record
+		// where possible, so that neither is committed without the other
+		recordMigration := func(tx dbutil.Transaction) error {
+			if err := insertMigration(drv, tx, migration); err != nil {
+				return err
+			}
+
+			return db.recordAudit(drv, tx, migration, AuditUp, startedAt)
+		}
+
+		if options.BatchSize() > 0 {
+			// each batch is committed separately, and the migration is only recorded once
+			// there are no rows left to process
+			err = db.execBatches(drv, sqlDB, options, execBlock)
+			if err == nil {
+				err = doTransaction(drv, sqlDB, recordMigration)
+			}
+		} else if options.Transaction() {
+			// begin transaction, a failed transaction is rolled back so it is safe to retry
+			err = db.applyWithRetry(drv, sqlDB, migration, options, func() error {
+				return doTransaction(drv, sqlDB, func(tx dbutil.Transaction) error {
+					if _, err := execBlock(tx); err != nil {
+						return err
+					}
+
+					return recordMigration(tx)
+				})
+			})
 		} else {
-			// run outside of transaction
-			err = execMigration(sqlDB)
+			// run outside of transaction, which is only retried on the conditions listed by
+			// the retry_on option
+			err = db.applyWithRetry(drv, sqlDB, migration, options, func() error {
+				return execMigration(sqlDB)
+			})
+			if err == nil {
+				err = db.recordAudit(drv, sqlDB, migration, AuditUp, startedAt)
+			}
 		}
 
 		if err != nil {
-			return err
+			return classifyError(drv, err, ErrMigrationFailed)
 		}
+
+		db.emit(MigrationFinished{Migration: migration, Position: i + 1, Total: len(pendingMigrations),
+			Duration: time.Since(startedAt)})
 	}
 
-	// automatically update schema file, silence errors
-	if db.AutoDumpSchema {
-		_ = db.DumpSchema()
+	if db.GolangMigrateTable != "" {
+		if err := db.syncGolangMigrateTable(drv, sqlDB); err != nil {
+			return err
+		}
 	}
 
+	// automatically update schema file, silence errors
+	db.autoDumpSchema()
+
 	return nil
 }
 
-func (db *DB) printVerbose(result sql.Result) {
-	lastInsertID, err := result.LastInsertId()
-	if err == nil {
-		fmt.Fprintf(db.Log, "Last insert ID: %d\n", lastInsertID)
+// loadBlock returns the options of the up or down block of a migration, and a function
+// which executes its contents and returns the number of rows affected (-1 if unknown).
+// Migrations larger than db.StreamThreshold are streamed from disk one statement at a
+// time.
+func (db *DB) loadBlock(drv Driver, migration Migration, up bool) (migrationOptions, func(dbutil.Transaction) (int64, error), error) {
+	stream := false
+	if db.StreamThreshold > 0 {
+		size, err := migration.size()
+		if err != nil {
+			return nil, nil, err
+		}
+		stream = size > db.StreamThreshold
+	}
+	if single, ok := drv.(singleStatementer); ok && single.SingleStatements() {
+		stream = true
+	}
+	savepoints := db.Savepoints && supportsSavepoints(drv)
+	if savepoints {
+		stream = true
+	}
+
+	if stream {
+		backslashEscapes := false
+		if escaper, ok := drv.(backslashEscaper); ok {
+			backslashEscapes = escaper.BackslashEscapes()
+		}
+
+		// validate the migration and read options without executing anything
+		options, err := migration.streamBlock(up, backslashEscapes, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := validateBatchOptions(options); err != nil {
+			return nil, nil, err
+		}
+		if err := validateRetryOptions(options); err != nil {
+			return nil, nil, err
+		}
+
+		return options, func(tx dbutil.Transaction) (int64, error) {
+			var total int64
+			_, err := migration.streamBlock(up, backslashEscapes, func(stmt string, line int) error {
+				var rows int64
+				var err error
+				if savepoints && options.Transaction() {
+					rows, err = db.execSavepoint(tx, stmt)
+				} else {
+					rows, err = db.exec(tx, stmt)
+				}
+				if err != nil {
+					return fmt.Errorf("statement starting at line %d: %w", line, drv.QueryError(stmt, err))
+				}
+				if rows < 0 || total < 0 {
+					total = -1
+				} else {
+					total += rows
+				}
+
+				return nil
+			})
+
+			return total, err
+		}, nil
+	}
+
+	parsed, err := migration.Parse()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contents := parsed.Up
+	if !up {
+		contents = parsed.Down
+	}
+	options := parseMigrationOptions(contents)
+	if err := validateBatchOptions(options); err != nil {
+		return nil, nil, err
+	}
+	if err := validateRetryOptions(options); err != nil {
+		return nil, nil, err
+	}
+
+	if hasCopyData(contents) {
+		// the data of COPY ... FROM STDIN statements is loaded separately
+		backslashEscapes := false
+		if escaper, ok := drv.(backslashEscaper); ok {
+			backslashEscapes = escaper.BackslashEscapes()
+		}
+		stmts, err := splitStatements(contents, backslashEscapes)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return options, func(tx dbutil.Transaction) (int64, error) {
+			var total int64
+			for _, stmt := range stmts {
+				rows, err := db.exec(tx, stmt)
+				if err != nil {
+					return -1, drv.QueryError(stmt, err)
+				}
+				if rows < 0 || total < 0 {
+					total = -1
+				} else {
+					total += rows
+				}
+			}
+
+			return total, nil
+		}, nil
+	}
+
+	return options, func(tx dbutil.Transaction) (int64, error) {
+		rows, err := db.exec(tx, contents)
+		if err != nil {
+			return rows, drv.QueryError(contents, err)
+		}
+
+		return rows, nil
+	}, nil
+}
+
+// exec executes SQL from a migration, logging its result as a debug message, and the
+// statement and how long it took if statement tracing is enabled. It returns the number
+// of rows affected, or -1 if the driver does not report it.
+func (db *DB) exec(tx dbutil.Transaction, query string) (int64, error) {
+	if copyQuery, data, ok := cutCopyData(query); ok {
+		return db.execCopy(tx, copyQuery, data)
+	}
+
+	db.tracef("Executing:\n%s", strings.TrimSpace(query))
+
+	start := time.Now()
+	result, err := tx.Exec(query)
+	duration := time.Since(start)
+	db.logSlow(query, duration, err)
+	if err != nil {
+		return -1, err
+	}
+
+	if lastInsertID, err := result.LastInsertId(); err == nil {
+		db.logger().Debugf("Last insert ID: %d", lastInsertID)
 	}
 	rowsAffected, err := result.RowsAffected()
 	if err == nil {
-		fmt.Fprintf(db.Log, "Rows affected: %d\n", rowsAffected)
+		db.logger().Debugf("Rows affected: %d", rowsAffected)
+	} else {
+		rowsAffected = -1
+	}
+	db.tracef("Duration: %s", duration.Round(time.Microsecond))
+
+	db.emit(StatementExecuted{SQL: query, RowsAffected: rowsAffected, Duration: duration})
+
+	return rowsAffected, nil
+}
+
+// logSlow logs a warning if a statement took longer than db.LogSlow, whether or not it
+// succeeded
+func (db *DB) logSlow(query string, duration time.Duration, err error) {
+	if db.LogSlow <= 0 || duration <= db.LogSlow {
+		return
+	}
+
+	if err != nil {
+		db.logger().Warnf("Slow statement (failed after %s):\n%s", duration.Round(time.Millisecond), strings.TrimSpace(query))
+	} else {
+		db.logger().Warnf("Slow statement (%s):\n%s", duration.Round(time.Millisecond), strings.TrimSpace(query))
 	}
 }
 
@@ -407,26 +1266,105 @@ func (db *DB) FindMigrations() ([]Migration, error) {
 		return nil, err
 	}
 
-	sqlDB, err := drv.Open()
+	sqlDB, err := db.openDatabase(drv)
 	if err != nil {
 		return nil, err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer db.closeDatabase(sqlDB)
 
 	// find applied migrations
 	appliedMigrations := map[string]bool{}
+	skippedMigrations := map[string]bool{}
 	migrationsTableExists, err := drv.MigrationsTableExists(sqlDB)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(drv, err, nil)
 	}
 
 	if migrationsTableExists {
+		if err := db.checkMigrationsTable(drv, sqlDB, false); err != nil {
+			return nil, err
+		}
 		appliedMigrations, err = drv.SelectMigrations(sqlDB, -1)
 		if err != nil {
+			return nil, classifyError(drv, err, nil)
+		}
+		if recorder, ok := drv.(skipRecorder); ok {
+			skippedMigrations, err = recorder.SelectSkippedMigrations(sqlDB)
+			if err != nil {
+				return nil, classifyError(drv, err, nil)
+			}
+		}
+	}
+
+	migrations, err := db.migrationFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	archived, err := db.archivedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if db.Phase != "" {
+		if err := validatePhase(db.Phase); err != nil {
 			return nil, err
 		}
 	}
+	if err := validateTags(db.Tags, true); err != nil {
+		return nil, err
+	}
+
+	for i := range migrations {
+		options, downOptions, requires, tags, err := migrations[i].directives()
+		if err != nil {
+			return nil, err
+		}
+		if err := validateTags(tags, false); err != nil {
+			return nil, fmt.Errorf("%s: %w", migrations[i].FileName, err)
+		}
+		migrations[i].Tags = tags
+		migrations[i].Irreversible = downOptions.Irreversible()
+		for _, version := range requires {
+			// requirements on applied migrations which have been archived are satisfied
+			if archived[version] == "" || !appliedMigrations[version] {
+				migrations[i].Requires = append(migrations[i].Requires, version)
+			}
+		}
+		migrations[i].Phase = options.Phase()
+		if err := validatePhase(migrations[i].Phase); err != nil {
+			return nil, fmt.Errorf("%s: %w", migrations[i].FileName, err)
+		}
+
+		if ok := appliedMigrations[migrations[i].Version]; ok {
+			migrations[i].Applied = true
+			continue
+		}
 
+		migrations[i].Environments = options.Environments()
+		migrations[i].skipRecorded = skippedMigrations[migrations[i].Version]
+		if migrations[i].skipRecorded || db.excludes(migrations[i]) {
+			migrations[i].Skipped = true
+		}
+	}
+
+	if err := validateRequires(migrations); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// MigrationFiles returns the migration files in each migrations directory, sorted by
+// file name, without connecting to the database. Applied is always false; use
+// FindMigrations to find out which migrations have been applied.
+func (db *DB) MigrationFiles() ([]Migration, error) {
+	return db.migrationFiles()
+}
+
+// migrationFiles lists the migration files in each migrations directory, sorted by file
+// name, and validates their versions against the configured version format
+func (db *DB) migrationFiles() ([]Migration, error) {
 	migrations := []Migration{}
 	for _, dir := range db.MigrationsDir {
 		// find filesystem migrations
@@ -444,19 +1382,17 @@ func (db *DB) FindMigrations() ([]Migration, error) {
 			if len(matches) < 2 {
 				continue
 			}
+			if err := db.validateVersion(matches[0], matches[1]); err != nil {
+				return nil, err
+			}
 
-			migration := Migration{
+			migrations = append(migrations, Migration{
 				Applied:  false,
 				FileName: matches[0],
 				FilePath: filepath.Join(dir, matches[0]),
 				FS:       db.FS,
 				Version:  matches[1],
-			}
-			if ok := appliedMigrations[migration.Version]; ok {
-				migration.Applied = true
-			}
-
-			migrations = append(migrations, migration)
+			})
 		}
 	}
 
@@ -467,20 +1403,368 @@ func (db *DB) FindMigrations() ([]Migration, error) {
 	return migrations, nil
 }
 
+// ExplainLocks prints the lock each statement in pending migrations will acquire, and
+// the existing relations it touches. Migrations are not applied.
+func (db *DB) ExplainLocks() error {
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	analyzer, ok := drv.(lockAnalyzer)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrLocksUnsupported, db.DatabaseURL.Scheme)
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	pending := 0
+	for _, migration := range migrations {
+		if migration.Applied || migration.Skipped {
+			continue
+		}
+		pending++
+
+		db.logger().Infof("%s", migration.FileName)
+		_, err := migration.streamBlock(true, false, func(stmt string, line int) error {
+			lock, relations, err := analyzer.AnalyzeLocks(sqlDB, stmt)
+			if err != nil {
+				return err
+			}
+			if lock == "" {
+				lock = "UNKNOWN"
+			}
+
+			if len(relations) > 0 {
+				lock += " on " + strings.Join(relations, ", ")
+			}
+			db.logger().Infof("  line %d: %s\n    %s", line, lock, firstLine(stmt))
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if pending == 0 {
+		db.logger().Infof("No pending migrations")
+	}
+
+	return nil
+}
+
+// pendingMigrations returns the migrations which migrate applies, in order: the
+// specified version, or every pending migration which is not skipped if version is empty.
+// It returns an error if they can't be applied in strict or require-down mode.
+func (db *DB) pendingMigrations(migrations []Migration, version string) ([]Migration, error) {
+	highestAppliedMigrationVersion := ""
+	pendingMigrations := []Migration{}
+	for _, migration := range migrations {
+		if migration.Applied {
+			if db.Strict && highestAppliedMigrationVersion <= migration.Version {
+				highestAppliedMigrationVersion = migration.Version
+			}
+		} else if !migration.Skipped {
+			pendingMigrations = append(pendingMigrations, migration)
+		}
+	}
+
+	if version != "" {
+		var err error
+		pendingMigrations, err = db.selectVersion(migrations, pendingMigrations, version)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, migration := range migrations {
+			switch {
+			case migration.Skipped && !db.inPhase(migration.Phase):
+				db.logger().Infof("Skipping: %s (phase: %s)", migration.FileName, migration.Phase)
+			case migration.Skipped && !db.matchesTags(migration.Tags):
+				db.logger().Infof("Skipping: %s (tags: %s)", migration.FileName, formatTags(migration.Tags))
+			case migration.Skipped:
+				db.logger().Warnf("Skipping: %s", migration.FileName)
+			}
+		}
+	}
+
+	pendingMigrations, err := orderByRequires(migrations, pendingMigrations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pendingMigrations) > 0 && db.Strict && pendingMigrations[0].Version <= highestAppliedMigrationVersion {
+		return nil, fmt.Errorf("migration `%s` is out of order with already applied migrations, the version number has to be higher than the applied migration `%s` in --strict mode", pendingMigrations[0].Version, highestAppliedMigrationVersion)
+	}
+	if db.RequireDown {
+		if err := checkDownBlocks(pendingMigrations); err != nil {
+			return nil, err
+		}
+	}
+
+	return pendingMigrations, nil
+}
+
+// selectVersion returns the pending migration with the given version, which may have been
+// recorded as skipped by an earlier run
+func (db *DB) selectVersion(migrations, pendingMigrations []Migration, version string) ([]Migration, error) {
+	for _, migration := range pendingMigrations {
+		if migration.Version == version {
+			return []Migration{migration}, nil
+		}
+	}
+
+	for _, migration := range migrations {
+		if migration.Version == version && migration.skipRecorded && !db.excludes(migration) {
+			return []Migration{migration}, nil
+		}
+		if migration.Version == version && migration.Skipped {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationSkipped, version)
+		}
+		if migration.Version == version {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationAlreadyApplied, version)
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrMigrationNotFound, version)
+}
+
+// recordSkipped records the pending migrations listed in DB.SkipVersions as skipped, so
+// that later runs leave them out until they are applied with MigrateVersion
+func (db *DB) recordSkipped(drv Driver, sqlDB *sql.DB, migrations []Migration) error {
+	for _, migration := range migrations {
+		if migration.Applied || migration.skipRecorded || !db.skipsVersion(migration.Version) {
+			continue
+		}
+
+		recorder, ok := drv.(skipRecorder)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrSkipUnsupported, db.DatabaseURL.Scheme)
+		}
+		if err := recorder.InsertSkippedMigration(sqlDB, migration.Version); err != nil {
+			return classifyError(drv, err, nil)
+		}
+	}
+
+	return nil
+}
+
+// insertMigration records a migration as applied, replacing the record of a migration
+// which an earlier run skipped
+func insertMigration(drv Driver, tx dbutil.Transaction, migration Migration) error {
+	if migration.skipRecorded {
+		if err := drv.DeleteMigration(tx, migration.Version); err != nil {
+			return err
+		}
+	}
+
+	return drv.InsertMigration(tx, migration.Version)
+}
+
+// excludes returns true if a pending migration is left out of this run by DB.SkipVersions,
+// DB.Environment, DB.Phase or DB.Tags
+func (db *DB) excludes(migration Migration) bool {
+	return db.skipsVersion(migration.Version) || !db.inEnvironment(migration.Environments) ||
+		!db.inPhase(migration.Phase) || !db.matchesTags(migration.Tags)
+}
+
+// skipsVersion returns true if the version is listed in db.SkipVersions
+func (db *DB) skipsVersion(version string) bool {
+	for _, v := range db.SkipVersions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inEnvironment returns true if the active environment is one of envs, or envs is empty
+func (db *DB) inEnvironment(envs []string) bool {
+	if len(envs) == 0 {
+		return true
+	}
+
+	for _, env := range envs {
+		if strings.EqualFold(env, db.Environment) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inPhase returns true if phase is db.Phase, or db.Phase is empty
+func (db *DB) inPhase(phase string) bool {
+	return db.Phase == "" || db.Phase == phase
+}
+
 // Rollback rolls back the most recent migration
 func (db *DB) Rollback() error {
+	return db.rollback("")
+}
+
+// RollbackVersion rolls back a single applied migration, which need not be the most
+// recent migration
+func (db *DB) RollbackVersion(version string) error {
+	if version == "" {
+		return ErrNoMigrationVersion
+	}
+
+	return db.rollback(version)
+}
+
+// RollbackAll rolls back every applied migration, most recent first, stopping at the first
+// migration which fails or is irreversible. The schema file is written once at the end.
+func (db *DB) RollbackAll() (err error) {
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, migration := range migrations {
+		if migration.Applied {
+			applied++
+		}
+	}
+	if applied == 0 {
+		return ErrNoRollback
+	}
+
+	// the schema file would otherwise be rewritten after every migration
+	autoDumpSchema := db.AutoDumpSchema
+	db.AutoDumpSchema = false
+	defer func() { db.AutoDumpSchema = autoDumpSchema }()
+
+	for i := 0; i < applied; i++ {
+		if err := db.rollback(""); err != nil {
+			return err
+		}
+	}
+
+	// automatically update schema file, silence errors
+	db.AutoDumpSchema = autoDumpSchema
+	db.autoDumpSchema()
+
+	return nil
+}
+
+// Redo rolls back the specified applied migration, or the most recent migration if version
+// is empty, then applies it again
+func (db *DB) Redo(version string) (err error) {
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	if version == "" {
+		migrations, err := db.FindMigrations()
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			if migration.Applied {
+				version = migration.Version
+			}
+		}
+		if version == "" {
+			return ErrNoRollback
+		}
+	}
+
+	// migrate would otherwise fail after the down block has already run
+	if err := db.checkRedo(version); err != nil {
+		return err
+	}
+
+	// the schema file only needs to be written once the migration is reapplied
+	autoDumpSchema := db.AutoDumpSchema
+	db.AutoDumpSchema = false
+	defer func() { db.AutoDumpSchema = autoDumpSchema }()
+
+	if err := db.rollback(version); err != nil {
+		return err
+	}
+
+	db.AutoDumpSchema = autoDumpSchema
+	return db.migrate(version)
+}
+
+// checkRedo returns an error if migrate would not apply version again once it is rolled
+// back, e.g. because DB.Tags exclude it, or it is out of order in strict mode
+func (db *DB) checkRedo(version string) error {
+	if db.Strict && db.Phase != "" {
+		return ErrPhaseStrict
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return err
+	}
+
+	for i, migration := range migrations {
+		if migration.Version != version || !migration.Applied {
+			continue
+		}
+
+		// the migration as FindMigrations returns it once it is rolled back
+		options, _, _, _, err := migration.directives()
+		if err != nil {
+			return err
+		}
+		migrations[i].Applied = false
+		migrations[i].Environments = options.Environments()
+		migrations[i].Skipped = db.excludes(migrations[i])
+
+		_, err = db.pendingMigrations(migrations, version)
+		return err
+	}
+
+	// rollback reports versions which are not applied
+	return nil
+}
+
+// rollback rolls back the specified version, or the most recent migration if version is
+// empty
+func (db *DB) rollback(version string) (err error) {
+	defer db.emitError(&err)
+
 	drv, err := db.Driver()
 	if err != nil {
 		return err
 	}
 
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
 	sqlDB, err := db.openDatabaseForMigration(drv)
 	if err != nil {
 		return err
 	}
-	defer dbutil.MustClose(sqlDB)
+	defer db.closeDatabase(sqlDB)
 
-	// find last applied migration
+	// find the last applied migration, or the requested version
 	var latest *Migration
 	migrations, err := db.FindMigrations()
 	if err != nil {
@@ -488,82 +1772,210 @@ func (db *DB) Rollback() error {
 	}
 
 	for i, migration := range migrations {
-		if migration.Applied {
+		if migration.Applied && (version == "" || migration.Version == version) {
 			latest = &migrations[i]
 		}
 	}
 
+	if latest == nil && version != "" {
+		return fmt.Errorf("%w: %s", ErrMigrationNotFound, version)
+	}
 	if latest == nil {
 		return ErrNoRollback
 	}
+	if latest.Irreversible {
+		return fmt.Errorf("%w: %s", ErrMigrationIrreversible, latest.FileName)
+	}
+
+	if err := db.checkLock(); err != nil {
+		return err
+	}
+	if err := db.checkReplication(drv, sqlDB); err != nil {
+		return err
+	}
 
-	fmt.Fprintf(db.Log, "Rolling back: %s\n", latest.FileName)
+	startedAt := time.Now()
+	db.emit(MigrationStarted{Migration: *latest, Rollback: true, Position: 1, Total: 1})
+	db.logger().Infof("Rolling back: %s", latest.FileName)
 
-	parsed, err := latest.Parse()
+	options, execBlock, err := db.loadBlock(drv, *latest, false)
 	if err != nil {
+		return classifyError(drv, err, ErrMigrationFailed)
+	}
+	if err := db.checkTransactionalDDL(drv, *latest, false, options); err != nil {
 		return err
 	}
+	options = withoutTransaction(drv, options)
 
 	execMigration := func(tx dbutil.Transaction) error {
 		// rollback migration
-		result, err := tx.Exec(parsed.Down)
-		if err != nil {
-			return drv.QueryError(parsed.Down, err)
-		} else if db.Verbose {
-			db.printVerbose(result)
+		if _, err := execBlock(tx); err != nil {
+			return err
 		}
 
 		// remove migration record
 		return drv.DeleteMigration(tx, latest.Version)
 	}
 
-	if parsed.DownOptions.Transaction() {
+	// as when applying, the audit record is inserted in the same transaction as the
+	// migration record is deleted where possible
+	recordRollback := func(tx dbutil.Transaction) error {
+		if err := drv.DeleteMigration(tx, latest.Version); err != nil {
+			return err
+		}
+
+		return db.recordAudit(drv, tx, *latest, AuditDown, startedAt)
+	}
+
+	if options.BatchSize() > 0 {
+		err = db.execBatches(drv, sqlDB, options, execBlock)
+		if err == nil {
+			err = doTransaction(drv, sqlDB, recordRollback)
+		}
+	} else if options.Transaction() {
 		// begin transaction
-		err = doTransaction(sqlDB, execMigration)
+		err = doTransaction(drv, sqlDB, func(tx dbutil.Transaction) error {
+			if _, err := execBlock(tx); err != nil {
+				return err
+			}
+
+			return recordRollback(tx)
+		})
 	} else {
 		// run outside of transaction
 		err = execMigration(sqlDB)
+		if err == nil {
+			err = db.recordAudit(drv, sqlDB, *latest, AuditDown, startedAt)
+		}
 	}
 
 	if err != nil {
-		return err
+		return classifyError(drv, err, ErrMigrationFailed)
 	}
 
-	// automatically update schema file, silence errors
-	if db.AutoDumpSchema {
-		_ = db.DumpSchema()
+	db.emit(MigrationFinished{Migration: *latest, Rollback: true, Position: 1, Total: 1,
+		Duration: time.Since(startedAt)})
+
+	if db.GolangMigrateTable != "" {
+		if err := db.syncGolangMigrateTable(drv, sqlDB); err != nil {
+			return err
+		}
 	}
 
+	// automatically update schema file, silence errors
+	db.autoDumpSchema()
+
 	return nil
 }
 
 // Status shows the status of all migrations
 func (db *DB) Status(quiet bool) (int, error) {
+	if db.StatusURL != nil {
+		replica, err := db.statusDB()
+		if err != nil {
+			return -1, err
+		}
+		return replica.Status(quiet)
+	}
+
+	if !quiet && db.Environment != "" {
+		db.logger().Infof("Environment: %s", db.Environment)
+	}
+
 	results, err := db.FindMigrations()
 	if err != nil {
 		return -1, err
 	}
+	modified, err := db.modifiedMigrations(results)
+	if err != nil {
+		return -1, err
+	}
+
+	// migrations are grouped by phase once any migration is in the contract phase
+	phases := []string{""}
+	for _, res := range results {
+		if res.Phase == PhaseContract {
+			phases = []string{PhaseExpand, PhaseContract}
+			break
+		}
+	}
 
-	var totalApplied int
+	var totalApplied, totalModified, totalSkipped int
+	phasePending := map[string]int{}
 	var line string
 
-	for _, res := range results {
-		if res.Applied {
-			line = fmt.Sprintf("[X] %s", res.FileName)
-			totalApplied++
-		} else {
-			line = fmt.Sprintf("[ ] %s", res.FileName)
+	for _, phase := range phases {
+		if !quiet && phase != "" {
+			if phase != phases[0] {
+				db.logger().Infof("")
+			}
+			db.logger().Infof("%s%s:", strings.ToUpper(phase[:1]), phase[1:])
 		}
-		if !quiet {
-			fmt.Fprintln(db.Log, line)
+
+		for _, res := range results {
+			if phase != "" && res.Phase != phase {
+				continue
+			}
+
+			switch {
+			case res.Applied && modified[res.Version] && res.Irreversible:
+				line = fmt.Sprintf("[X] %s (irreversible, modified)", res.FileName)
+				totalApplied++
+				totalModified++
+			case res.Applied && modified[res.Version]:
+				line = fmt.Sprintf("[X] %s (modified)", res.FileName)
+				totalApplied++
+				totalModified++
+			case res.Applied && res.Irreversible:
+				line = fmt.Sprintf("[X] %s (irreversible)", res.FileName)
+				totalApplied++
+			case res.Applied:
+				line = fmt.Sprintf("[X] %s", res.FileName)
+				totalApplied++
+			case res.Skipped && !db.inEnvironment(res.Environments):
+				line = fmt.Sprintf("[-] %s (skipped, env: %s)", res.FileName, strings.Join(res.Environments, ","))
+				totalSkipped++
+			case res.Skipped && !db.inPhase(res.Phase):
+				line = fmt.Sprintf("[-] %s (skipped, phase: %s)", res.FileName, res.Phase)
+				totalSkipped++
+			case res.Skipped && !db.matchesTags(res.Tags):
+				line = fmt.Sprintf("[-] %s (skipped, tags: %s)", res.FileName, formatTags(res.Tags))
+				totalSkipped++
+			case res.Skipped:
+				line = fmt.Sprintf("[-] %s (skipped)", res.FileName)
+				totalSkipped++
+			default:
+				line = fmt.Sprintf("[ ] %s", res.FileName)
+				phasePending[phase]++
+			}
+			if !quiet {
+				db.logger().Infof("%s", line)
+			}
 		}
 	}
 
-	totalPending := len(results) - totalApplied
+	totalPending := len(results) - totalApplied - totalSkipped
 	if !quiet {
-		fmt.Fprintln(db.Log)
-		fmt.Fprintf(db.Log, "Applied: %d\n", totalApplied)
-		fmt.Fprintf(db.Log, "Pending: %d\n", totalPending)
+		db.logger().Infof("")
+		db.logger().Infof("Applied: %d", totalApplied)
+		if totalModified > 0 {
+			db.logger().Infof("Modified: %d", totalModified)
+		}
+		if totalSkipped > 0 {
+			db.logger().Infof("Skipped: %d", totalSkipped)
+		}
+		if len(phases) > 1 {
+			db.logger().Infof("Pending: %d (%s: %d, %s: %d)", totalPending,
+				PhaseExpand, phasePending[PhaseExpand], PhaseContract, phasePending[PhaseContract])
+		} else {
+			db.logger().Infof("Pending: %d", totalPending)
+		}
+	}
+
+	if db.FailOnModified {
+		if err := checkModified(results, modified); err != nil {
+			return totalPending, err
+		}
 	}
 
 	return totalPending, nil
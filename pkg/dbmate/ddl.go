@@ -0,0 +1,60 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrNonTransactionalDDL is returned if DB.StrictDDL is set, and a migration which runs in a
+// transaction contains DDL, and the driver commits DDL statements immediately
+var ErrNonTransactionalDDL = errors.New("migration contains DDL, which this database commits immediately, so a failure cannot be rolled back")
+
+// ddlTransactor is implemented by drivers which report whether DDL statements (create,
+// alter, drop, etc.) can be rolled back as part of a transaction. Drivers which do not
+// implement it are assumed to support transactional DDL.
+type ddlTransactor interface {
+	TransactionalDDL() bool
+}
+
+var ddlRegexp = regexp.MustCompile(`(?i)^(create|alter|drop|rename|truncate)\b`)
+
+// isDDL returns true if a statement changes the schema
+func isDDL(stmt string) bool {
+	return ddlRegexp.MatchString(trimLeadingComments(stmt))
+}
+
+// checkTransactionalDDL warns (or returns an error if DB.StrictDDL is set) if a migration block
+// which runs in a transaction contains DDL, and the driver commits DDL immediately. If
+// the migration fails, statements before the failure remain applied even though the
+// migration is not recorded.
+func (db *DB) checkTransactionalDDL(drv Driver, migration Migration, up bool, options migrationOptions) error {
+	if t, ok := drv.(ddlTransactor); !ok || t.TransactionalDDL() || !options.Transaction() {
+		return nil
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	ddlLine := 0
+	_, err := migration.streamBlock(up, backslashEscapes, func(stmt string, line int) error {
+		if ddlLine == 0 && isDDL(stmt) {
+			ddlLine = line
+		}
+		return nil
+	})
+	if err != nil || ddlLine == 0 {
+		return err
+	}
+
+	if db.StrictDDL {
+		return fmt.Errorf("%w: %s line %d", ErrNonTransactionalDDL, migration.FileName, ddlLine)
+	}
+
+	db.logger().Warnf("%s contains DDL at line %d, which this database commits immediately. "+
+		"If the migration fails, it may be partially applied.", migration.FileName, ddlLine)
+
+	return nil
+}
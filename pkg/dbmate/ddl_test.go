@@ -0,0 +1,74 @@
+package dbmate
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// ddlTestDriver reports whether DDL is transactional
+type ddlTestDriver struct {
+	Driver
+	transactional bool
+}
+
+func (drv *ddlTestDriver) TransactionalDDL() bool {
+	return drv.transactional
+}
+
+func TestIsDDL(t *testing.T) {
+	require.True(t, isDDL("create table users (id integer)"))
+	require.True(t, isDDL("-- add a column\nALTER TABLE users ADD COLUMN name text"))
+	require.True(t, isDDL("/* cleanup */ drop index users_name"))
+	require.False(t, isDDL("insert into users (id) values (1)"))
+	require.False(t, isDDL("update creates set id = 1"))
+}
+
+func TestCheckTransactionalDDL(t *testing.T) {
+	migration := Migration{
+		FileName: "001_test.sql",
+		FilePath: "001_test.sql",
+		FS: fstest.MapFS{"001_test.sql": {Data: []byte(
+			"-- migrate:up\ninsert into users (id) values (1);\ncreate table posts (id integer);\n" +
+				"-- migrate:down\ndelete from users;\n")}},
+		Version: "001",
+	}
+
+	t.Run("warns", func(t *testing.T) {
+		var log bytes.Buffer
+		db := &DB{Log: &log}
+
+		err := db.checkTransactionalDDL(&ddlTestDriver{}, migration, true, migrationOptions{})
+		require.NoError(t, err)
+		require.Contains(t, log.String(), "001_test.sql contains DDL at line 3")
+	})
+
+	t.Run("strict", func(t *testing.T) {
+		// out of order migrations are unrelated
+		db := &DB{Log: &bytes.Buffer{}, Strict: true}
+		require.NoError(t, db.checkTransactionalDDL(&ddlTestDriver{}, migration, true, migrationOptions{}))
+
+		db = &DB{Log: &bytes.Buffer{}, StrictDDL: true}
+
+		err := db.checkTransactionalDDL(&ddlTestDriver{}, migration, true, migrationOptions{})
+		require.ErrorIs(t, err, ErrNonTransactionalDDL)
+		require.EqualError(t, err, ErrNonTransactionalDDL.Error()+": 001_test.sql line 3")
+	})
+
+	t.Run("ignored", func(t *testing.T) {
+		var log bytes.Buffer
+		db := &DB{Log: &log, StrictDDL: true}
+
+		// transactional DDL
+		require.NoError(t, db.checkTransactionalDDL(&ddlTestDriver{transactional: true}, migration, true, migrationOptions{}))
+		// driver doesn't report transactional DDL
+		require.NoError(t, db.checkTransactionalDDL(&retryTestDriver{}, migration, true, migrationOptions{}))
+		// migration doesn't run in a transaction
+		require.NoError(t, db.checkTransactionalDDL(&ddlTestDriver{}, migration, true, migrationOptions{"transaction": "false"}))
+		// down block has no DDL
+		require.NoError(t, db.checkTransactionalDDL(&ddlTestDriver{}, migration, false, migrationOptions{}))
+		require.Empty(t, log.String())
+	})
+}
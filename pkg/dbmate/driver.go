@@ -1,10 +1,12 @@
 package dbmate
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
 	"io"
+	"net"
 	"net/url"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 )
@@ -25,49 +27,207 @@ type Driver interface {
 	QueryError(string, error) error
 }
 
+// backslashEscaper is implemented by drivers whose quoted strings treat backslash
+// as an escape character, which affects how migrations are split into statements
+type backslashEscaper interface {
+	BackslashEscapes() bool
+}
+
+// missingSchemaCreator is implemented by drivers whose migrations table may be in a
+// schema, which they create if it does not exist unless DriverConfig.CreateMissingSchema
+// is false
+type missingSchemaCreator interface {
+	CreatesMissingSchema() bool
+}
+
+// singleStatementer is implemented by drivers which can only execute one statement
+// at a time, in which case migrations are always split into individual statements
+type singleStatementer interface {
+	SingleStatements() bool
+}
+
+// savepointer is implemented by drivers which support savepoints, in which case each
+// statement of a transactional migration can be executed within its own savepoint
+type savepointer interface {
+	Savepoints() bool
+}
+
+// transactionSupporter is implemented by drivers which may not support transactions. If
+// Transactions returns false, statements are executed outside of a transaction, as if
+// each migration set transaction:false.
+type transactionSupporter interface {
+	Transactions() bool
+}
+
+// sessionSetupSupporter is implemented by drivers which execute DriverConfig.SessionSetup
+// on each connection opened by Open, before it is used. Session setup statements are
+// rejected for other drivers.
+type sessionSetupSupporter interface {
+	SessionSetup() bool
+}
+
+// skipRecorder is implemented by drivers which can record skipped migrations in the
+// migrations table, so that they are shown as skipped and left out of later runs
+type skipRecorder interface {
+	// SelectSkippedMigrations returns the versions of the migrations recorded as
+	// skipped, which SelectMigrations does not return
+	SelectSkippedMigrations(db *sql.DB) (map[string]bool, error)
+	// InsertSkippedMigration records a pending migration as skipped
+	InsertSkippedMigration(db *sql.DB, version string) error
+}
+
+// nativeConnectionParamer is implemented by drivers which understand some of the
+// connection tuning URL parameters themselves, such as connect_timeout with PostgreSQL.
+// These parameters are left in the URL for the driver, instead of being applied by dbmate.
+type nativeConnectionParamer interface {
+	NativeConnectionParams() []string
+}
+
+// tableTruncater is implemented by drivers which can delete all data from the database,
+// while preserving the schema and the migrations table
+type tableTruncater interface {
+	TruncateTables(db *sql.DB) error
+}
+
+// lockAnalyzer is implemented by drivers which can report the locks a statement will
+// acquire without executing it
+type lockAnalyzer interface {
+	AnalyzeLocks(db *sql.DB, stmt string) (lock string, relations []string, err error)
+}
+
+// replicationChecker is implemented by drivers which can check the health of logical
+// replication, so that migrations are not applied while a replication pipeline is broken
+// or lagging behind
+type replicationChecker interface {
+	// ReplicationIssues returns a description of each problem found, for example an
+	// inactive replication slot, or a slot lagging by more than maxLag bytes (0 for no
+	// limit)
+	ReplicationIssues(db *sql.DB, maxLag int64) ([]string, error)
+}
+
+// transientErrorClassifier is implemented by drivers which can identify errors caused by
+// temporary conditions (e.g. deadlocks or serialization failures), where retrying the
+// failed migration may succeed
+type transientErrorClassifier interface {
+	IsTransientError(err error) bool
+}
+
+// connectionErrorClassifier is implemented by drivers which can identify errors caused by
+// failing to connect to the database (e.g. authentication failures), in addition to
+// network failures
+type connectionErrorClassifier interface {
+	IsConnectionError(err error) bool
+}
+
+// waitFailureClassifier is implemented by drivers which can identify the kind of
+// connection failure (e.g. WaitFailureAuth) caused by their own errors, returning an
+// empty string if it is unknown
+type waitFailureClassifier interface {
+	WaitFailure(err error) string
+}
+
+// lockErrorClassifier is implemented by drivers which can identify errors caused by a
+// statement timing out while waiting for a lock held by another session
+type lockErrorClassifier interface {
+	IsLockError(err error) bool
+}
+
+// deadlockClassifier is implemented by drivers which can identify errors caused by a
+// deadlock, so that migrations with the retry_on:deadlock option can be retried
+type deadlockClassifier interface {
+	IsDeadlock(err error) bool
+}
+
+// invalidIndexDropper is implemented by drivers which can drop indexes left invalid by a
+// failed concurrent index build, so that a migration building them can be retried
+type invalidIndexDropper interface {
+	// DropInvalidIndexes drops each index which exists on its table but is invalid,
+	// returning the names of the indexes dropped
+	DropInvalidIndexes(db *sql.DB, indexes []ConcurrentIndex) ([]string, error)
+}
+
+// schemaInspector is implemented by drivers which can list the columns of each table,
+// which is used to generate migrations from declarative schema definitions
+type schemaInspector interface {
+	// TableColumns returns the columns of each table (excluding the migrations table),
+	// in the order they are defined
+	TableColumns(db *sql.DB) (map[string][]string, error)
+}
+
+// migrationsTableInspector is implemented by drivers which can list the columns of the
+// migrations table, so that a table created by another migration tool is detected
+// before it is used
+type migrationsTableInspector interface {
+	// MigrationsTableColumns returns the lower case names of the columns of the
+	// migrations table, which must exist
+	MigrationsTableColumns(db *sql.DB) ([]string, error)
+}
+
+// serverVersioner is implemented by drivers which can report the version of the database
+// server, which is recorded in the schema file header
+type serverVersioner interface {
+	ServerVersion(db *sql.DB) (string, error)
+}
+
+// writableChecker is implemented by drivers which can detect a server which must not be
+// migrated, such as a read-only replica, before anything is written to it
+type writableChecker interface {
+	CheckWritable(db *sql.DB) error
+}
+
+// databaseFingerprinter is implemented by drivers which can identify a database in a way
+// which is shared by its read replicas, so that status checks can safely be run against
+// a replica
+type databaseFingerprinter interface {
+	DatabaseFingerprint(db *sql.DB) (string, error)
+}
+
+// copyLoader is implemented by drivers which can load the rows of data following a
+// COPY ... FROM STDIN statement in a migration
+type copyLoader interface {
+	// CopyFrom executes the statement, sending the data (each row followed by a newline),
+	// and returns the number of rows copied
+	CopyFrom(db dbutil.Transaction, query, data string) (int64, error)
+}
+
+// dumpTooler is implemented by drivers which run external tools (e.g. pg_dump) to dump
+// the schema
+type dumpTooler interface {
+	// DumpTools returns the name of each executable run to dump the schema
+	DumpTools() ([]string, error)
+}
+
+// databaseLocator is implemented by drivers whose database is not named by the host and
+// path of the URL, such as sqlite, where the URL contains a file path
+type databaseLocator interface {
+	DatabaseLocation() (host, database string)
+}
+
+// DialContextFunc establishes a network connection to the database server
+type DialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
 // DriverConfig holds configuration passed to driver constructors
 type DriverConfig struct {
+	CreateMissingSchema bool
 	DatabaseURL         *url.URL
+	DialContext         DialContextFunc
+	LockTimeout         time.Duration
 	Log                 io.Writer
 	MigrationsTableName string
+	ReplicationRole     string
+	// SessionSetup lists SQL statements which dbmate executes on each connection it
+	// opens. Drivers which dump the schema with an external tool should apply them to
+	// the dump where possible.
+	SessionSetup     []string
+	StatementTimeout time.Duration
+	// WaitOperation waits for a long running operation started by the driver, reporting
+	// its progress
+	WaitOperation WaitOperationFunc
 }
 
 // DriverFunc represents a driver constructor
 type DriverFunc func(DriverConfig) Driver
 
-type QueryError struct {
-	Err      error
-	Query    string
-	Position int
-}
-
-func (e *QueryError) Error() string {
-	if e.Position > 0 {
-		line := 1
-		column := 1
-		offset := 0
-		for _, ch := range e.Query {
-			offset++
-			if offset >= e.Position {
-				break
-			}
-			// don't count CR as a column in CR/LF sequences
-			if ch == '\r' {
-				continue
-			}
-			if ch == '\n' {
-				line++
-				column = 1
-				continue
-			}
-			column++
-		}
-		return fmt.Sprintf("line: %d, column: %d, position: %d: %s", line, column, e.Position, e.Err.Error())
-	}
-
-	return e.Err.Error()
-}
-
 var drivers = map[string]DriverFunc{}
 
 // RegisterDriver registers a driver constructor for a given URL scheme
@@ -0,0 +1,92 @@
+package dbmate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Error kinds, which are attached to errors returned by DB methods so that callers can
+// tell the cause of a failure apart using errors.Is (ErrCantConnect is also attached to
+// connection failures)
+var (
+	ErrLockHeld        = errors.New("a lock is held by another session")
+	ErrMigrationFailed = errors.New("migration failed")
+)
+
+// kindError attaches an error kind to an error, without changing its message
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// classifyError attaches ErrCantConnect to err if it was caused by a connection failure,
+// or ErrLockHeld if it was caused by waiting for a lock held by another session.
+// Otherwise kind is attached, unless it is nil. Errors which already have a kind are
+// returned unchanged.
+func classifyError(drv Driver, err, kind error) error {
+	if err == nil || errors.Is(err, ErrCantConnect) || errors.Is(err, ErrLockHeld) ||
+		errors.Is(err, ErrMigrationFailed) {
+		return err
+	}
+
+	switch {
+	case isConnectionError(drv, err):
+		kind = ErrCantConnect
+	case isLockError(drv, err):
+		kind = ErrLockHeld
+	case kind == nil:
+		return err
+	}
+
+	return &kindError{err: err, kind: kind}
+}
+
+// isConnectionError determines whether an error was caused by failing to connect to the
+// database server, or losing the connection. Network failures are recognized for all
+// drivers, and drivers may classify their own errors (e.g. authentication failures) by
+// implementing connectionErrorClassifier.
+func isConnectionError(drv Driver, err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	if classifier, ok := drv.(connectionErrorClassifier); ok {
+		return classifier.IsConnectionError(err)
+	}
+
+	return false
+}
+
+// isLockError determines whether an error was caused by a statement timing out while
+// waiting for a lock, as reported by drivers implementing lockErrorClassifier
+func isLockError(drv Driver, err error) bool {
+	if classifier, ok := drv.(lockErrorClassifier); ok {
+		return classifier.IsLockError(err)
+	}
+
+	return false
+}
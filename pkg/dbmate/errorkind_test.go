@@ -0,0 +1,70 @@
+package dbmate
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errTestAuth        = errors.New("password authentication failed")
+	errTestLockTimeout = errors.New("canceling statement due to lock timeout")
+)
+
+// errorKindTestDriver classifies errTestAuth as a connection error, and
+// errTestLockTimeout as a lock error
+type errorKindTestDriver struct {
+	Driver
+}
+
+func (drv *errorKindTestDriver) IsConnectionError(err error) bool {
+	return errors.Is(err, errTestAuth)
+}
+
+func (drv *errorKindTestDriver) IsLockError(err error) bool {
+	return errors.Is(err, errTestLockTimeout)
+}
+
+func TestClassifyError(t *testing.T) {
+	drv := &errorKindTestDriver{}
+
+	connection := []error{
+		driver.ErrBadConn,
+		fmt.Errorf("dial: %w", syscall.ECONNREFUSED),
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("no route to host")},
+		&net.DNSError{Err: "no such host", Name: "db", IsNotFound: true},
+		&QueryError{Err: errTestAuth, Query: "select 1"},
+	}
+	for _, err := range connection {
+		classified := classifyError(drv, err, ErrMigrationFailed)
+		require.ErrorIs(t, classified, ErrCantConnect, err.Error())
+		require.ErrorIs(t, classified, err)
+		require.NotErrorIs(t, classified, ErrMigrationFailed)
+		require.Equal(t, err.Error(), classified.Error())
+	}
+
+	lockErr := &QueryError{Err: errTestLockTimeout, Query: "alter table users add column name text"}
+	classified := classifyError(drv, lockErr, ErrMigrationFailed)
+	require.ErrorIs(t, classified, ErrLockHeld)
+	var queryErr *QueryError
+	require.ErrorAs(t, classified, &queryErr)
+	require.Equal(t, lockErr.Error(), classified.Error())
+
+	// otherwise the kind is attached, if any
+	syntaxErr := errors.New("syntax error")
+	require.ErrorIs(t, classifyError(drv, syntaxErr, ErrMigrationFailed), ErrMigrationFailed)
+	require.Equal(t, syntaxErr, classifyError(drv, syntaxErr, nil))
+	require.Equal(t, syntaxErr, classifyError(nil, syntaxErr, nil))
+	require.NoError(t, classifyError(drv, nil, ErrMigrationFailed))
+
+	// errors which already have a kind are unchanged
+	classified = classifyError(drv, syntaxErr, ErrMigrationFailed)
+	require.Equal(t, classified, classifyError(drv, classified, nil))
+	waitErr := fmt.Errorf("%w: %s", ErrCantConnect, syntaxErr)
+	require.Equal(t, waitErr, classifyError(drv, waitErr, ErrMigrationFailed))
+}
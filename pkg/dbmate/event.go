@@ -0,0 +1,78 @@
+package dbmate
+
+import "time"
+
+// Event is passed to DB.EventHandler as dbmate runs. Use a type switch to handle each
+// type of event: Connected, MigrationStarted, MigrationFinished, StatementExecuted,
+// SchemaDumped, and Error.
+type Event interface {
+	event()
+}
+
+// Connected is emitted when the database server is available, after waiting for it
+// (see DB.Wait and DB.WaitBefore)
+type Connected struct{}
+
+// MigrationStarted is emitted before a migration is applied or rolled back
+type MigrationStarted struct {
+	Migration Migration
+	// Rollback is true if the down block of the migration is being run
+	Rollback bool
+	// Position is the 1-based position of the migration among the Total migrations
+	// being applied or rolled back
+	Position int
+	Total    int
+}
+
+// MigrationFinished is emitted after a migration has been applied or rolled back
+type MigrationFinished struct {
+	Migration Migration
+	// Rollback is true if the down block of the migration was run
+	Rollback bool
+	// Position is the 1-based position of the migration among the Total migrations
+	// being applied or rolled back
+	Position int
+	Total    int
+	Duration time.Duration
+}
+
+// StatementExecuted is emitted after SQL from a migration is executed. Depending on
+// the driver and migration size, SQL may contain a single statement or an entire
+// migration block.
+type StatementExecuted struct {
+	SQL string
+	// RowsAffected is -1 if the driver does not report affected rows
+	RowsAffected int64
+	Duration     time.Duration
+}
+
+// SchemaDumped is emitted after the schema file has been written
+type SchemaDumped struct {
+	Path string
+}
+
+// Error is emitted when a migration, rollback, or schema dump fails
+type Error struct {
+	Err error
+}
+
+func (Connected) event()         {}
+func (MigrationStarted) event()  {}
+func (MigrationFinished) event() {}
+func (StatementExecuted) event() {}
+func (SchemaDumped) event()      {}
+func (Error) event()             {}
+
+// emit passes an event to the event handler, if one is set
+func (db *DB) emit(e Event) {
+	if db.EventHandler != nil {
+		db.EventHandler(e)
+	}
+}
+
+// emitError emits an Error event if *err is not nil, and is intended to be deferred
+func (db *DB) emitError(err *error) {
+	if *err != nil {
+		db.emit(Error{Err: *err})
+	}
+}
@@ -0,0 +1,159 @@
+package dbmate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// queryRegexp matches statements which return rows, whose results are printed
+var queryRegexp = regexp.MustCompile(`(?i)^(select|with|show|explain|describe|desc|values|table|pragma)\b`)
+
+// Exec runs the SQL statements read from r, such as an operational script which should
+// share dbmate's connection settings. Statements are executed in order on a single
+// connection (so session settings and explicit transactions carry over between them),
+// and execution stops at the first error. The rows returned by queries are written to w,
+// unlike log messages, so that their values are neither redacted nor mixed with the log.
+func (db *DB) Exec(r io.Reader, w io.Writer) (err error) {
+	defer db.emitError(&err)
+
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx := connTransaction{conn}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	execStmt := func(stmt string, line int) error {
+		var err error
+		if queryRegexp.MatchString(trimLeadingComments(stmt)) {
+			err = db.query(tx, w, stmt)
+		} else {
+			_, err = db.exec(tx, stmt)
+		}
+		if err != nil {
+			return fmt.Errorf("statement starting at line %d: %w", line, drv.QueryError(stmt, err))
+		}
+
+		return nil
+	}
+
+	splitter := statementSplitter{backslashEscapes: backslashEscapes}
+	reader := bufio.NewReader(r)
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line != "" {
+			if err := splitter.feed(line, lineNo, execStmt); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return splitter.flush(execStmt)
+}
+
+// query executes a statement which returns rows, and writes the column names followed
+// by each row to w, with values separated by tabs
+func (db *DB) query(tx connTransaction, w io.Writer, query string) error {
+	db.tracef("Executing:\n%s", strings.TrimSpace(query))
+
+	start := time.Now()
+	rows, err := tx.Query(query)
+	if err != nil {
+		db.logSlow(query, time.Since(start), err)
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, strings.Join(columns, "\t")); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		fields := make([]string, len(values))
+		for i, value := range values {
+			fields[i] = formatValue(value)
+		}
+		if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+			return err
+		}
+	}
+	err = rows.Err()
+	db.logSlow(query, time.Since(start), err)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// formatValue formats a value scanned from a query result
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// connTransaction adapts a single connection to the dbutil.Transaction interface
+type connTransaction struct {
+	conn *sql.Conn
+}
+
+func (c connTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c connTransaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c connTransaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
@@ -0,0 +1,119 @@
+package dbmate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxVersionAttempts limits how many times a time based version is regenerated (one
+// second later each time) while looking for a version after the highest existing one
+const maxVersionAttempts = 1000
+
+// RenamedMigration is a migration file renumbered by FixOrder
+type RenamedMigration struct {
+	FileName    string `json:"file"`
+	NewFileName string `json:"new_file"`
+}
+
+// FixOrder renumbers pending migrations which would be applied out of order: those with
+// a version lower than or equal to the highest applied version, or the same version as
+// an earlier migration file. The first such migration and every pending migration after
+// it are given new versions after the highest existing version, in their current
+// order, using the configured version format. Applied and skipped migrations are never
+// renamed. If dryRun is true, the files are not renamed.
+func (db *DB) FixOrder(dryRun bool) ([]RenamedMigration, error) {
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	highestApplied := ""
+	highest := ""
+	versions := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		if migration.Applied && migration.Version > highestApplied {
+			highestApplied = migration.Version
+		}
+		if migration.Version > highest {
+			highest = migration.Version
+		}
+		versions = append(versions, migration.Version)
+	}
+
+	seen := map[string]string{}
+	renumber := []Migration{}
+	for _, migration := range migrations {
+		previous, duplicate := seen[migration.Version]
+		seen[migration.Version] = migration.FileName
+
+		if migration.Applied {
+			// applied status is recorded by version, so duplicates can't be told apart
+			if duplicate {
+				db.logger().Warnf("Cannot renumber `%s`: version %s has already been applied (also used by `%s`)",
+					migration.FileName, migration.Version, previous)
+			}
+			continue
+		}
+		if migration.Skipped {
+			continue
+		}
+
+		if len(renumber) > 0 || duplicate || migration.Version <= highestApplied {
+			renumber = append(renumber, migration)
+		}
+	}
+
+	if len(renumber) == 0 {
+		db.logger().Infof("Migrations are in order")
+		return []RenamedMigration{}, nil
+	}
+
+	now := time.Now()
+	results := []RenamedMigration{}
+	for _, migration := range renumber {
+		version, err := db.versionAfter(now, versions, highest)
+		if err != nil {
+			return results, err
+		}
+		highest = version
+		versions = append(versions, version)
+
+		newFileName := version + strings.TrimPrefix(migration.FileName, migration.Version)
+		newPath := filepath.Join(filepath.Dir(migration.FilePath), newFileName)
+		if dryRun {
+			db.logger().Infof("Would rename: %s -> %s", migration.FileName, newFileName)
+		} else {
+			db.logger().Infof("Renaming: %s -> %s", migration.FileName, newFileName)
+			if _, err := os.Stat(newPath); !os.IsNotExist(err) {
+				return results, fmt.Errorf("%w: %s", ErrMigrationAlreadyExist, newPath)
+			}
+			if err := os.Rename(migration.FilePath, newPath); err != nil {
+				return results, err
+			}
+		}
+
+		results = append(results, RenamedMigration{FileName: migration.FileName, NewFileName: newFileName})
+	}
+
+	return results, nil
+}
+
+// versionAfter generates a version which sorts after highest. Time based versions are
+// regenerated one second later until they do, since several migrations may be
+// renumbered within the same second.
+func (db *DB) versionAfter(now time.Time, versions []string, highest string) (string, error) {
+	for i := 0; i < maxVersionAttempts; i++ {
+		version, err := db.generateVersion(now.Add(time.Duration(i)*time.Second), versions)
+		if err != nil {
+			return "", err
+		}
+		if version > highest {
+			return version, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: unable to generate a version after `%s`", ErrInvalidVersion, highest)
+}
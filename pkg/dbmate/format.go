@@ -0,0 +1,224 @@
+package dbmate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// formatKeywords are uppercased when formatting the schema. Only keywords which are
+// case insensitive in every supported database are included, so that formatting does not
+// change the meaning of the schema (data types and function names are left unchanged).
+var formatKeywords = map[string]bool{}
+
+func init() {
+	for _, keyword := range strings.Fields(`
+		add after all alter and as asc begin between by cascade case check collate column
+		comment commit constraint create cross database default deferrable deferred delete
+		desc distinct drop each else end exists extension for foreign from full function
+		grant group having if immediate in index initially inner insert into is join key
+		left like limit materialized not null on only or order outer owned partition primary
+		procedure references replace restrict returns right schema select sequence set
+		table then to trigger union unique update using values view when where with`) {
+		formatKeywords[keyword] = true
+	}
+}
+
+// sqlSegment is part of a SQL text which is either code, or a quoted string, quoted
+// identifier, or comment which must be preserved exactly
+type sqlSegment struct {
+	text    string
+	code    bool
+	comment bool
+}
+
+// splitSegments splits SQL text into code and quoted segments, using the same quoting
+// rules as statementSplitter
+func splitSegments(text string, backslashEscapes bool) []sqlSegment {
+	segments := []sqlSegment{}
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		end := -1
+		comment := false
+
+		switch {
+		case c == '-' && strings.HasPrefix(text[i:], "--"):
+			end = strings.IndexByte(text[i:], '\n')
+			comment = true
+		case c == '/' && strings.HasPrefix(text[i:], "/*"):
+			end = strings.Index(text[i+2:], "*/")
+			if end >= 0 {
+				end += 4
+			}
+			comment = true
+		case c == '\'':
+			end = quotedStringEnd(text[i:], backslashEscapes || isEscapeStringPrefix(text, i))
+		case c == '"' || c == '`':
+			end = strings.IndexByte(text[i+1:], c)
+			if end >= 0 {
+				end += 2
+			}
+		case c == '$' && (i == 0 || !isIdentifierChar(text[i-1])):
+			tag, ok := dollarQuoteTag(text[i:])
+			if !ok {
+				continue
+			}
+			end = strings.Index(text[i+len(tag):], tag)
+			if end >= 0 {
+				end += 2 * len(tag)
+			}
+		default:
+			continue
+		}
+
+		if start < i {
+			segments = append(segments, sqlSegment{text: text[start:i], code: true})
+		}
+		if end < 0 {
+			// unterminated, preserve the remaining text
+			end = len(text) - i
+		}
+		segments = append(segments, sqlSegment{text: text[i : i+end], comment: comment})
+		i += end - 1
+		start = i + 1
+	}
+	if start < len(text) {
+		segments = append(segments, sqlSegment{text: text[start:], code: true})
+	}
+
+	return segments
+}
+
+// quotedStringEnd returns the length of the single quoted string at the start of s, or -1
+// if it is not terminated
+func quotedStringEnd(s string, escapes bool) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' && escapes {
+			i++
+			continue
+		}
+		if s[i] == '\'' {
+			return i + 1
+		}
+	}
+
+	return -1
+}
+
+var (
+	trailingSpaceRegexp = regexp.MustCompile(`[ \t]+(\r?\n)`)
+	indentRegexp        = regexp.MustCompile(`\n[ \t]*\t[ \t]*`)
+	blankLinesRegexp    = regexp.MustCompile(`\n{3,}`)
+)
+
+// formatSchema canonicalizes a schema dump, so that it does not depend on the client
+// tool or server version which generated it. Keywords are uppercased, trailing
+// whitespace and repeated blank lines are removed, tab indentation is replaced with four
+// spaces, and the final statement is terminated with a semicolon. Quoted strings,
+// identifiers, comments, and dollar-quoted function bodies are not changed.
+func formatSchema(schema []byte, backslashEscapes bool) []byte {
+	var out strings.Builder
+	segments := splitSegments(string(schema), backslashEscapes)
+
+	// terminated is false if the last statement has content but no semicolon, and
+	// contentEnd is the position after its last character (excluding comments)
+	terminated := true
+	contentEnd := 0
+	for i, seg := range segments {
+		if !seg.code {
+			out.WriteString(seg.text)
+			if !seg.comment {
+				terminated = false
+				contentEnd = out.Len()
+			}
+			continue
+		}
+
+		// words adjacent to quoted segments are part of qualified names (or prefixes
+		// such as E'...'), which are not changed
+		var prev, next byte
+		if i > 0 {
+			prev = lastByte(segments[i-1].text)
+		}
+		if i+1 < len(segments) {
+			next = segments[i+1].text[0]
+		}
+
+		text := strings.ReplaceAll(seg.text, "\r\n", "\n")
+		text = trailingSpaceRegexp.ReplaceAllString(text, "$1")
+		text = indentRegexp.ReplaceAllStringFunc(text, func(indent string) string {
+			return strings.ReplaceAll(indent, "\t", "    ")
+		})
+		text = blankLinesRegexp.ReplaceAllString(text, "\n\n")
+		text = uppercaseKeywords(text, prev, next)
+
+		for j := 0; j < len(text); j++ {
+			switch c := text[j]; {
+			case c == ';':
+				terminated = true
+			case !isSpace(c):
+				terminated = false
+				contentEnd = out.Len() + j + 1
+			}
+		}
+		out.WriteString(text)
+	}
+
+	formatted := out.String()
+	if !terminated {
+		formatted = formatted[:contentEnd] + ";" + formatted[contentEnd:]
+	}
+	formatted = strings.TrimRight(formatted, " \t\r\n")
+	if formatted == "" {
+		return []byte{}
+	}
+
+	return []byte(formatted + "\n")
+}
+
+// uppercaseKeywords uppercases keywords in a code segment. Words which are qualified
+// (preceded or followed by a dot), or called as a function, are not changed. prev and
+// next are the characters surrounding the segment.
+func uppercaseKeywords(text string, prev, next byte) string {
+	b := []byte(text)
+	for i := 0; i < len(b); {
+		if !isLetter(b[i]) && b[i] != '_' {
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(b) && isIdentifierChar(b[j]) {
+			j++
+		}
+
+		before, after := prev, next
+		if i > 0 {
+			before = b[i-1]
+		}
+		if j < len(b) {
+			after = b[j]
+		}
+
+		word := strings.ToLower(string(b[i:j]))
+		if formatKeywords[word] && !isIdentifierChar(before) && before != '.' &&
+			after != '.' && after != '(' && !isQuote(after) {
+			copy(b[i:j], strings.ToUpper(word))
+		}
+		i = j
+	}
+
+	return string(b)
+}
+
+func isQuote(c byte) bool {
+	return c == '\'' || c == '"' || c == '`'
+}
+
+func lastByte(s string) byte {
+	if s == "" {
+		return 0
+	}
+
+	return s[len(s)-1]
+}
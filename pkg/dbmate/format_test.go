@@ -0,0 +1,47 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSchema(t *testing.T) {
+	cases := []struct {
+		name             string
+		input            string
+		backslashEscapes bool
+		expected         string
+	}{
+		{"keywords", "create table if not exists users (id integer primary key, name text not null);\n",
+			false, "CREATE TABLE IF NOT EXISTS users (id integer PRIMARY KEY, name text NOT NULL);\n"},
+		{"already formatted", "CREATE TABLE users (\n    id integer\n);\n",
+			false, "CREATE TABLE users (\n    id integer\n);\n"},
+		{"qualified names and function calls", "create index on public.index (lower(email)) where replace(email, 'a', 'b') is not null;",
+			false, "CREATE INDEX ON public.index (lower(email)) WHERE replace(email, 'a', 'b') IS NOT NULL;\n"},
+		{"quoted strings and identifiers", "insert into \"table\" values ('create table', `select`);",
+			false, "INSERT INTO \"table\" VALUES ('create table', `select`);\n"},
+		{"comments", "-- create table\n/* drop table */ drop table t;",
+			false, "-- create table\n/* drop table */ DROP TABLE t;\n"},
+		{"dollar quoted body", "create function f() returns int as $$ begin return 1; end; $$ language sql;",
+			false, "CREATE FUNCTION f() RETURNS int AS $$ begin return 1; end; $$ language sql;\n"},
+		{"whitespace", "create table t (\n\tid int,  \r\n\t\tname text\n);\n\n\n\ncreate view v as select 1;   \n\n",
+			false, "CREATE TABLE t (\n    id int,\n        name text\n);\n\nCREATE VIEW v AS SELECT 1;\n"},
+		{"whitespace in strings", "insert into t values ('a  \n\tb');",
+			false, "INSERT INTO t VALUES ('a  \n\tb');\n"},
+		{"missing final semicolon", "create table t (id int);\ncreate view v as select 1 -- comment\n",
+			false, "CREATE TABLE t (id int);\nCREATE VIEW v AS SELECT 1; -- comment\n"},
+		{"backslash escapes", "insert into t values ('it\\'s from here');",
+			true, "INSERT INTO t VALUES ('it\\'s from here');\n"},
+		{"unterminated string", "insert into t values ('create",
+			false, "INSERT INTO t VALUES ('create;\n"},
+		{"empty", "\n\n", false, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := formatSchema([]byte(tc.input), tc.backslashEscapes)
+			require.Equal(t, tc.expected, string(actual))
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Error codes
+var (
+	ErrGenerateUnsupported = errors.New("generating migrations is not supported by this driver")
+	ErrSchemaStatement     = errors.New("schema definitions may only contain create table statements")
+	ErrPendingMigrations   = errors.New("there are pending migrations, apply them before generating a new migration")
+)
+
+// schemaTable is a table declared in the schema directory
+type schemaTable struct {
+	name    string
+	columns []schemaColumn
+	stmt    string
+}
+
+// schemaColumn is a column declared in a create table statement
+type schemaColumn struct {
+	name       string
+	definition string
+}
+
+// Generate compares the tables declared in db.SchemaDir with the current database, and
+// creates a new migration which applies the difference. Tables and columns which are
+// missing from the database are created, and tables and columns which are no longer
+// declared are dropped. Column types and constraints of existing columns are not
+// compared.
+func (db *DB) Generate(name string) error {
+	if name == "" {
+		return ErrNoMigrationName
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	inspector, ok := drv.(schemaInspector)
+	if !ok {
+		return ErrGenerateUnsupported
+	}
+
+	declared, err := db.readSchemaDir(db.SchemaDir)
+	if err != nil {
+		return err
+	}
+
+	// the database must reflect every existing migration, otherwise the generated
+	// migration would repeat changes made by pending migrations
+	migrations, err := db.FindMigrations()
+	if err != nil && !errors.Is(err, ErrMigrationDirNotFound) {
+		return err
+	}
+	for _, migration := range migrations {
+		if !migration.Applied {
+			return fmt.Errorf("%w: %s", ErrPendingMigrations, migration.FileName)
+		}
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	actual, err := inspector.TableColumns(sqlDB)
+	if err != nil {
+		return err
+	}
+	delete(actual, db.auditTableName())
+	if db.GolangMigrateTable != "" {
+		delete(actual, db.GolangMigrateTable)
+	}
+
+	stmts := db.diffSchema(declared, actual)
+	if len(stmts) == 0 {
+		db.logger().Infof("No schema changes detected")
+		return nil
+	}
+
+	return db.NewMigrationWithUp(name, strings.Join(stmts, "\n\n"))
+}
+
+// diffSchema returns the statements which change the actual tables (and their columns)
+// to match the declared tables
+func (db *DB) diffSchema(declared []schemaTable, actual map[string][]string) []string {
+	actualTables := map[string][]string{}
+	for table, columns := range actual {
+		actualTables[normalizeIdentifier(table)] = columns
+	}
+
+	stmts := []string{}
+	declaredTables := map[string]bool{}
+	for _, table := range declared {
+		key := normalizeIdentifier(table.name)
+		declaredTables[key] = true
+
+		columns, exists := actualTables[key]
+		if !exists {
+			stmts = append(stmts, table.stmt+";")
+			continue
+		}
+
+		actualColumns := map[string]bool{}
+		for _, column := range columns {
+			actualColumns[normalizeIdentifier(column)] = true
+		}
+
+		declaredColumns := map[string]bool{}
+		for _, column := range table.columns {
+			declaredColumns[normalizeIdentifier(column.name)] = true
+			if !actualColumns[normalizeIdentifier(column.name)] {
+				stmts = append(stmts, fmt.Sprintf("alter table %s add column %s %s;",
+					table.name, column.name, column.definition))
+			}
+		}
+
+		for _, column := range columns {
+			if !declaredColumns[normalizeIdentifier(column)] {
+				db.logger().Warnf("Dropping column: %s.%s", table.name, column)
+				stmts = append(stmts, fmt.Sprintf("alter table %s drop column %s;", table.name, column))
+			}
+		}
+	}
+
+	dropped := []string{}
+	for table := range actual {
+		if !declaredTables[normalizeIdentifier(table)] {
+			dropped = append(dropped, table)
+		}
+	}
+	sort.Strings(dropped)
+	for _, table := range dropped {
+		db.logger().Warnf("Dropping table: %s", table)
+		stmts = append(stmts, fmt.Sprintf("drop table %s;", table))
+	}
+
+	return stmts
+}
+
+// readSchemaDir parses the create table statements in each .sql file in dir, and the
+// table definitions in each .hcl, .yaml and .yml file
+func (db *DB) readSchemaDir(dir string) ([]schemaTable, error) {
+	files, err := db.readMigrationsDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w `%s`", ErrMigrationDirNotFound, dir)
+	}
+
+	tables := []schemaTable{}
+	for _, file := range files {
+		if file.IsDir() || (filepath.Ext(file.Name()) != ".sql" && !isTableDefinitionFile(file.Name())) {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		var contents []byte
+		if db.FS == nil {
+			contents, err = os.ReadFile(path)
+		} else {
+			contents, err = fs.ReadFile(db.FS, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if isTableDefinitionFile(path) {
+			defined, err := parseTableDefinitions(path, contents)
+			if err != nil {
+				return nil, err
+			}
+			tables = append(tables, defined...)
+			continue
+		}
+
+		stmts, err := splitStatements(string(contents), false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, stmt := range stmts {
+			table, err := parseCreateTable(stmt)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", err, path, firstLine(stmt))
+			}
+			tables = append(tables, table)
+		}
+	}
+
+	return tables, nil
+}
+
+// parseCreateTable parses the table name and column definitions of a create table
+// statement. Table constraints (primary key, foreign key, etc.) are ignored.
+func parseCreateTable(stmt string) (schemaTable, error) {
+	stmt = strings.TrimSuffix(strings.TrimSpace(trimLeadingComments(stmt)), ";")
+
+	loc := createTableRegexp.FindStringSubmatchIndex(stmt)
+	if loc == nil {
+		return schemaTable{}, ErrSchemaStatement
+	}
+
+	table := schemaTable{name: stmt[loc[4]:loc[5]], stmt: stmt}
+	rest := strings.TrimSpace(stmt[loc[1]:])
+	if !strings.HasPrefix(rest, "(") {
+		return schemaTable{}, ErrSchemaStatement
+	}
+
+	for _, element := range splitTopLevel(stripComments(rest[1:])) {
+		name, definition := splitIdentifier(element)
+		if name == "" || isConstraintKeyword(name) {
+			continue
+		}
+		table.columns = append(table.columns, schemaColumn{name: name, definition: definition})
+	}
+
+	return table, nil
+}
+
+// stripComments replaces comments outside of quotes with whitespace
+func stripComments(s string) string {
+	var b strings.Builder
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return b.String()
+			}
+			i += end
+			c = '\n'
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			i += end + 1
+			c = ' '
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// splitTopLevel splits a parenthesized list on commas which are not nested inside
+// parentheses or quotes, stopping at the closing parenthesis of the list
+func splitTopLevel(s string) []string {
+	elements := []string{}
+	depth := 0
+	start := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == ')':
+			return append(elements, strings.TrimSpace(s[start:i]))
+		case c == ',' && depth == 0:
+			elements = append(elements, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(elements, strings.TrimSpace(s[start:]))
+}
+
+// splitIdentifier splits a column definition into the (possibly quoted) column name
+// and the rest of the definition
+func splitIdentifier(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", ""
+	}
+
+	if c := s[0]; c == '"' || c == '`' || c == '[' {
+		end := byte(c)
+		if c == '[' {
+			end = ']'
+		}
+		if i := strings.IndexByte(s[1:], end); i >= 0 {
+			return s[:i+2], strings.TrimSpace(s[i+2:])
+		}
+	}
+
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// normalizeIdentifier returns an unquoted, unqualified, lowercase identifier which can
+// be compared with the names reported by the database
+func normalizeIdentifier(s string) string {
+	parts := strings.Split(s, ".")
+	s = parts[len(parts)-1]
+	s = strings.Trim(s, "\"`[]")
+
+	return strings.ToLower(s)
+}
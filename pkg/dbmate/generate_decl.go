@@ -0,0 +1,116 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsimple"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrTableDefinition is returned for HCL or YAML table definitions which are incomplete
+var ErrTableDefinition = errors.New("invalid table definition")
+
+// tableDefinitions is the contents of an HCL or YAML schema file, for example:
+//
+//	table "users" {
+//	  column "id" {
+//	    type     = "integer"
+//	    nullable = false
+//	  }
+//	  column "email" {
+//	    type    = "text"
+//	    default = "''"
+//	  }
+//	  primary_key = ["id"]
+//	}
+type tableDefinitions struct {
+	Tables []tableDefinition `hcl:"table,block" yaml:"tables"`
+}
+
+// tableDefinition declares a table and its columns
+type tableDefinition struct {
+	Name       string             `hcl:"name,label" yaml:"name"`
+	Columns    []columnDefinition `hcl:"column,block" yaml:"columns"`
+	PrimaryKey []string           `hcl:"primary_key,optional" yaml:"primary_key"`
+}
+
+// columnDefinition declares a column. Columns are nullable unless nullable is false, and
+// default is an SQL expression.
+type columnDefinition struct {
+	Name     string  `hcl:"name,label" yaml:"name"`
+	Type     string  `hcl:"type" yaml:"type"`
+	Nullable *bool   `hcl:"nullable,optional" yaml:"nullable"`
+	Default  *string `hcl:"default,optional" yaml:"default"`
+}
+
+// isTableDefinitionFile returns true for the HCL and YAML files in the schema directory
+func isTableDefinitionFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".hcl", ".yaml", ".yml":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseTableDefinitions parses an HCL or YAML schema file into create table statements
+func parseTableDefinitions(path string, contents []byte) ([]schemaTable, error) {
+	var defs tableDefinitions
+	if filepath.Ext(path) == ".hcl" {
+		if err := hclsimple.Decode(path, contents, nil, &defs); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(contents, &defs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	tables := []schemaTable{}
+	for _, def := range defs.Tables {
+		table, err := def.schemaTable()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrTableDefinition, path, err)
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+// schemaTable returns the create table statement of a table definition
+func (def tableDefinition) schemaTable() (schemaTable, error) {
+	if def.Name == "" {
+		return schemaTable{}, errors.New("table has no name")
+	}
+	if len(def.Columns) == 0 {
+		return schemaTable{}, fmt.Errorf("table %s has no columns", def.Name)
+	}
+
+	table := schemaTable{name: def.Name}
+	elements := []string{}
+	for _, column := range def.Columns {
+		if column.Name == "" || column.Type == "" {
+			return schemaTable{}, fmt.Errorf("column of table %s has no name or type", def.Name)
+		}
+
+		definition := column.Type
+		if column.Nullable != nil && !*column.Nullable {
+			definition += " not null"
+		}
+		if column.Default != nil {
+			definition += " default " + *column.Default
+		}
+
+		table.columns = append(table.columns, schemaColumn{name: column.Name, definition: definition})
+		elements = append(elements, column.Name+" "+definition)
+	}
+	if len(def.PrimaryKey) > 0 {
+		elements = append(elements, "primary key ("+strings.Join(def.PrimaryKey, ", ")+")")
+	}
+
+	table.stmt = fmt.Sprintf("create table %s (\n  %s\n)", def.Name, strings.Join(elements, ",\n  "))
+
+	return table, nil
+}
@@ -0,0 +1,134 @@
+package dbmate
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCreateTable(t *testing.T) {
+	table, err := parseCreateTable(`-- users of the application
+create table if not exists "Users" (
+  id integer primary key, -- the id
+  "full name" varchar(255) not null default 'a, b',
+  score numeric(10, 2),
+  /* a comment, with a comma */
+  email	text,
+  constraint users_email unique (email),
+  primary key (id)
+);`)
+	require.NoError(t, err)
+	require.Equal(t, `"Users"`, table.name)
+	require.Equal(t, []schemaColumn{
+		{name: "id", definition: "integer primary key"},
+		{name: `"full name"`, definition: "varchar(255) not null default 'a, b'"},
+		{name: "score", definition: "numeric(10, 2)"},
+		{name: "email", definition: "text"},
+	}, table.columns)
+
+	_, err = parseCreateTable("create index users_email on users (email)")
+	require.ErrorIs(t, err, ErrSchemaStatement)
+
+	_, err = parseCreateTable("create table users_copy as select * from users")
+	require.ErrorIs(t, err, ErrSchemaStatement)
+}
+
+func TestDiffSchema(t *testing.T) {
+	db := &DB{Log: io.Discard}
+
+	declared := []schemaTable{
+		{
+			name: "users",
+			columns: []schemaColumn{
+				{name: "id", definition: "integer"},
+				{name: "Email", definition: "text not null default ''"},
+			},
+			stmt: "create table users (id integer, Email text not null default '')",
+		},
+		{
+			name:    "posts",
+			columns: []schemaColumn{{name: "id", definition: "integer"}},
+			stmt:    "create table posts (id integer)",
+		},
+	}
+	actual := map[string][]string{
+		"users":    {"id", "name"},
+		"comments": {"id"},
+	}
+
+	require.Equal(t, []string{
+		"alter table users add column Email text not null default '';",
+		"alter table users drop column name;",
+		"create table posts (id integer);",
+		"drop table comments;",
+	}, db.diffSchema(declared, actual))
+
+	// no changes
+	require.Empty(t, db.diffSchema(declared[:1], map[string][]string{"USERS": {"ID", "email"}}))
+}
+
+func TestNormalizeIdentifier(t *testing.T) {
+	require.Equal(t, "users", normalizeIdentifier("users"))
+	require.Equal(t, "users", normalizeIdentifier(`public."Users"`))
+	require.Equal(t, "users", normalizeIdentifier("`Users`"))
+	require.Equal(t, "users", normalizeIdentifier("[dbo].[Users]"))
+}
+
+func TestParseTableDefinitions(t *testing.T) {
+	expected := []schemaTable{{
+		name: "users",
+		columns: []schemaColumn{
+			{name: "id", definition: "integer not null"},
+			{name: "email", definition: "text default ''"},
+			{name: "score", definition: "integer default 0"},
+		},
+		stmt: "create table users (\n  id integer not null,\n  email text default '',\n  score integer default 0,\n  primary key (id)\n)",
+	}}
+
+	tables, err := parseTableDefinitions("users.hcl", []byte(`
+table "users" {
+  column "id" {
+    type = "integer"
+    nullable = false
+  }
+  column "email" {
+    type    = "text"
+    default = "''"
+  }
+  column "score" {
+    type    = "integer"
+    default = 0
+  }
+  primary_key = ["id"]
+}
+`))
+	require.NoError(t, err)
+	require.Equal(t, expected, tables)
+
+	tables, err = parseTableDefinitions("users.yml", []byte(`
+tables:
+  - name: users
+    columns:
+      - name: id
+        type: integer
+        nullable: false
+      - name: email
+        type: text
+        default: "''"
+      - name: score
+        type: integer
+        default: 0
+    primary_key: [id]
+`))
+	require.NoError(t, err)
+	require.Equal(t, expected, tables)
+
+	_, err = parseTableDefinitions("users.yaml", []byte("tables:\n  - name: users\n"))
+	require.ErrorIs(t, err, ErrTableDefinition)
+
+	_, err = parseTableDefinitions("users.hcl", []byte(`table "users" {
+  column "id" {}
+}`))
+	require.Error(t, err)
+}
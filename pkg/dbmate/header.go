@@ -0,0 +1,67 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schema headers
+const (
+	// SchemaHeaderFull records the dbmate version, database server version, and the time
+	// the schema was dumped
+	SchemaHeaderFull = "full"
+	// SchemaHeaderReproducible records the dbmate and database server versions, but not
+	// the time, so that dumping an unchanged database does not change the schema file
+	SchemaHeaderReproducible = "reproducible"
+)
+
+// ErrInvalidSchemaHeader is returned when DB.SchemaHeader is not a valid header mode
+var ErrInvalidSchemaHeader = errors.New("invalid schema header")
+
+// schemaHeaderPrefix starts the first line of the schema file header
+const schemaHeaderPrefix = "-- Generated by dbmate "
+
+// schemaHeader returns the header comment for a schema dump, or an empty string if
+// db.SchemaHeader is not set. The header ends with a blank line.
+func (db *DB) schemaHeader(drv Driver, sqlDB *sql.DB) (string, error) {
+	switch db.SchemaHeader {
+	case "":
+		return "", nil
+	case SchemaHeaderFull, SchemaHeaderReproducible:
+	default:
+		return "", fmt.Errorf("%w: %s (expected %s or %s)",
+			ErrInvalidSchemaHeader, db.SchemaHeader, SchemaHeaderFull, SchemaHeaderReproducible)
+	}
+
+	lines := []string{schemaHeaderPrefix + Version}
+	if versioner, ok := drv.(serverVersioner); ok {
+		version, err := versioner.ServerVersion(sqlDB)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, "-- Server version: "+strings.Join(strings.Fields(version), " "))
+	}
+	if db.SchemaHeader == SchemaHeaderFull {
+		lines = append(lines, "-- Dumped at: "+time.Now().UTC().Format(time.RFC3339))
+	}
+
+	return strings.Join(lines, "\n") + "\n\n", nil
+}
+
+// stripSchemaHeader removes the header written by schemaHeader from a schema file, so
+// that it is not compared when checking for drift
+func stripSchemaHeader(schema []byte) []byte {
+	if !strings.HasPrefix(string(schema), schemaHeaderPrefix) {
+		return schema
+	}
+
+	_, rest, found := strings.Cut(string(schema), "\n\n")
+	if !found {
+		return []byte{}
+	}
+
+	return []byte(rest)
+}
@@ -0,0 +1,277 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrUnknownHistorySource = errors.New("unknown migration history source")
+	ErrInvalidTableName     = errors.New("invalid table name")
+	ErrHistoryDirty         = errors.New("migration history is dirty")
+)
+
+// historyTableRegexp matches table names which are safe to use without quoting
+var historyTableRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// historyImporter reads migration history recorded by another migration tool
+type historyImporter struct {
+	// defaultTable is the table the tool records its history in
+	defaultTable string
+	// columns identify the tool's history table, which has all of them
+	columns []string
+	// load reads the history, and returns a function reporting whether a dbmate
+	// migration was applied according to that history
+	load func(sqlDB *sql.DB, table string) (func(Migration) bool, error)
+}
+
+var historyImporters = map[string]historyImporter{
+	"flyway": {
+		defaultTable: "flyway_schema_history",
+		columns:      []string{"installed_rank", "version", "description", "success"},
+		load:         loadFlywayHistory,
+	},
+	"golang-migrate": {
+		defaultTable: "schema_migrations",
+		columns:      []string{"version", "dirty"},
+		load:         loadGolangMigrateHistory,
+	},
+}
+
+// ImportHistory marks migrations as applied based on the history table of another
+// migration tool, such as golang-migrate. If the history table has the same name as
+// the dbmate migrations table, it is renamed to <table>_<tool> first, and renamed back
+// if the import fails.
+func (db *DB) ImportHistory(from, table string) (err error) {
+	importer, ok := historyImporters[from]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownHistorySource, from)
+	}
+	if table == "" {
+		table = importer.defaultTable
+	}
+	if !historyTableRegexp.MatchString(table) {
+		return fmt.Errorf("%w: %s", ErrInvalidTableName, table)
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	isApplied, err := importer.load(sqlDB, table)
+	if err != nil {
+		return err
+	}
+
+	if table == db.MigrationsTableName {
+		// move the history table out of the way of the dbmate migrations table
+		parts := strings.Split(table, ".")
+		newName := parts[len(parts)-1] + "_" + strings.ReplaceAll(from, "-", "_")
+		db.logger().Infof("Renaming: %s to %s", table, newName)
+		if _, err := sqlDB.Exec(fmt.Sprintf("alter table %s rename to %s", table, newName)); err != nil {
+			return err
+		}
+
+		// DDL is not transactional in every database, so a failed import is undone by
+		// dropping the new migrations table and renaming the history table back
+		defer func() {
+			if err != nil {
+				err = db.undoHistoryRename(sqlDB, table, newName, err)
+			}
+		}()
+	}
+
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := drv.CreateMigrationsTable(sqlDB); err != nil {
+			return err
+		}
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return err
+	}
+
+	imported := 0
+	err = doTransaction(drv, sqlDB, func(tx dbutil.Transaction) error {
+		for _, migration := range migrations {
+			if migration.Applied || !isApplied(migration) {
+				continue
+			}
+
+			db.logger().Infof("Importing: %s", migration.FileName)
+			if err := insertMigration(drv, tx, migration); err != nil {
+				return err
+			}
+			imported++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	db.logger().Infof("Imported %d migrations from %s", imported, from)
+
+	return nil
+}
+
+// undoHistoryRename drops the dbmate migrations table if it was created, and renames the
+// history table back to its original name, returning err with any failure to do so
+func (db *DB) undoHistoryRename(sqlDB *sql.DB, table, newName string, err error) error {
+	db.logger().Infof("Renaming: %s back to %s", newName, table)
+	if _, dropErr := sqlDB.Exec(fmt.Sprintf("drop table if exists %s", table)); dropErr != nil {
+		return fmt.Errorf("%w (and %s could not be restored: %s)", err, table, dropErr)
+	}
+
+	// the table is renamed within its schema
+	parts := strings.Split(table, ".")
+	if len(parts) > 1 {
+		newName = parts[0] + "." + newName
+	}
+	_, renameErr := sqlDB.Exec(fmt.Sprintf("alter table %s rename to %s", newName, parts[len(parts)-1]))
+	if renameErr != nil {
+		return fmt.Errorf("%w (and %s could not be restored: %s)", err, table, renameErr)
+	}
+
+	return err
+}
+
+// loadGolangMigrateHistory reads a golang-migrate table, which records only the
+// current version. Every migration up to and including that version is applied.
+func loadGolangMigrateHistory(sqlDB *sql.DB, table string) (func(Migration) bool, error) {
+	var version uint64
+	var dirty bool
+	err := sqlDB.QueryRow(fmt.Sprintf("select version, dirty from %s", table)).Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		// no migrations applied
+		return func(Migration) bool { return false }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dirty {
+		return nil, fmt.Errorf("%w: golang-migrate failed while applying version %d, "+
+			"fix the database and clear the dirty flag before importing", ErrHistoryDirty, version)
+	}
+
+	return func(m Migration) bool {
+		v, err := strconv.ParseUint(m.Version, 10, 64)
+		return err == nil && v <= version
+	}, nil
+}
+
+// loadFlywayHistory reads a flyway history table. A migration is applied if flyway
+// successfully applied a versioned migration with the same version or description.
+func loadFlywayHistory(sqlDB *sql.DB, table string) (func(Migration) bool, error) {
+	rows, err := sqlDB.Query(fmt.Sprintf("select version, description, success from %s "+
+		"where version is not null order by installed_rank", table))
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	versions := map[string]bool{}
+	descriptions := map[string]bool{}
+	for rows.Next() {
+		var version, description string
+		var success bool
+		if err := rows.Scan(&version, &description, &success); err != nil {
+			return nil, err
+		}
+		if !success {
+			return nil, fmt.Errorf("%w: flyway failed while applying version %s, "+
+				"repair the flyway history before importing", ErrHistoryDirty, version)
+		}
+
+		// flyway versions may contain dots or underscores, e.g. V1_1__foo.sql is version 1.1
+		versions[strings.NewReplacer(".", "", "_", "").Replace(version)] = true
+		if d := normalizeDescription(description); d != "" {
+			descriptions[d] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return func(m Migration) bool {
+		description := normalizeDescription(migrationDescription(m.FileName))
+		return versions[m.Version] || (description != "" && descriptions[description])
+	}, nil
+}
+
+// migrationDescription returns the description part of a migration file name,
+// e.g. 20151129054053_create_users.sql => create_users
+func migrationDescription(fileName string) string {
+	name := strings.TrimSuffix(fileName, ".sql")
+	name = strings.TrimPrefix(name, migrationVersionRegexp.FindString(name))
+	return strings.TrimLeft(name, "_-")
+}
+
+// normalizeDescription lowercases a description and treats underscores as spaces,
+// since flyway replaces underscores in file names with spaces
+func normalizeDescription(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(strings.ReplaceAll(s, "_", " "))), " ")
+}
+
+// syncGolangMigrateTable records the latest applied migration in a golang-migrate table,
+// so that golang-migrate and dbmate can be used side by side
+func (db *DB) syncGolangMigrateTable(drv Driver, sqlDB *sql.DB) error {
+	table := db.GolangMigrateTable
+	if !historyTableRegexp.MatchString(table) || table == db.MigrationsTableName {
+		return fmt.Errorf("%w: %s", ErrInvalidTableName, table)
+	}
+
+	applied, err := drv.SelectMigrations(sqlDB, -1)
+	if err != nil {
+		return err
+	}
+
+	versions := []uint64{}
+	for version := range applied {
+		if v, err := strconv.ParseUint(version, 10, 64); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	_, err = sqlDB.Exec(fmt.Sprintf("create table if not exists %s "+
+		"(version bigint not null primary key, dirty boolean not null)", table))
+	if err != nil {
+		return err
+	}
+
+	return doTransaction(drv, sqlDB, func(tx dbutil.Transaction) error {
+		if _, err := tx.Exec(fmt.Sprintf("delete from %s", table)); err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return nil
+		}
+
+		// golang-migrate records only the current version
+		_, err := tx.Exec(fmt.Sprintf("insert into %s (version, dirty) values (%d, false)",
+			table, versions[len(versions)-1]))
+		return err
+	})
+}
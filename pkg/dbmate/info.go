@@ -0,0 +1,80 @@
+package dbmate
+
+import (
+	"os/exec"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Info describes the configuration resolved from options and environment variables,
+// to debug which database and files dbmate will use
+type Info struct {
+	// DatabaseURL is the database URL with its passwords redacted, including those in query
+	// parameters
+	DatabaseURL     string   `json:"database_url"`
+	Driver          string   `json:"driver"`
+	Host            string   `json:"host"`
+	Database        string   `json:"database"`
+	Environment     string   `json:"environment,omitempty"`
+	MigrationsDir   []string `json:"migrations_dir"`
+	MigrationsTable string   `json:"migrations_table"`
+	SchemaFile      string   `json:"schema_file"`
+	Tools           []Tool   `json:"tools"`
+}
+
+// Tool is an external executable run by the driver
+type Tool struct {
+	Name string `json:"name"`
+	// Path is the location of the executable in the PATH, or empty if it was not found
+	Path string `json:"path"`
+}
+
+// Info returns the resolved configuration. It does not connect to the database, and
+// passwords read from files or the keyring are not resolved.
+func (db *DB) Info() (Info, error) {
+	info := Info{
+		Environment:     db.Environment,
+		MigrationsDir:   db.MigrationsDir,
+		MigrationsTable: db.MigrationsTableName,
+		SchemaFile:      db.SchemaFile,
+		Tools:           []Tool{},
+	}
+
+	if db.DatabaseURL == nil || db.DatabaseURL.Scheme == "" {
+		return info, nil
+	}
+
+	info.DatabaseURL = dbutil.RedactPasswords(db.DatabaseURL.String())
+	info.Driver = db.DatabaseURL.Scheme
+	info.Host = db.DatabaseURL.Host
+	info.Database = dbutil.DatabaseName(db.DatabaseURL)
+
+	driverFunc := drivers[db.DatabaseURL.Scheme]
+	if driverFunc == nil {
+		return info, nil
+	}
+
+	drv := driverFunc(DriverConfig{
+		DatabaseURL:         db.DatabaseURL,
+		MigrationsTableName: db.MigrationsTableName,
+	})
+	if locator, ok := drv.(databaseLocator); ok {
+		info.Host, info.Database = locator.DatabaseLocation()
+	}
+
+	tooler, ok := drv.(dumpTooler)
+	if !ok {
+		return info, nil
+	}
+
+	names, err := tooler.DumpTools()
+	if err != nil {
+		return info, err
+	}
+	for _, name := range names {
+		path, _ := exec.LookPath(name)
+		info.Tools = append(info.Tools, Tool{Name: name, Path: path})
+	}
+
+	return info, nil
+}
@@ -0,0 +1,131 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/zalando/go-keyring"
+)
+
+// PasswordKeyringParam is the database URL query parameter which reads the database
+// password from the OS credential store (macOS Keychain, Windows Credential Manager, or
+// Secret Service), where it is saved with SetKeyringPassword
+const PasswordKeyringParam = "passwordkeyring"
+
+// keyringService is the service name passwords are stored under in the credential store
+const keyringService = "dbmate"
+
+// Error codes
+var (
+	ErrKeyring                = errors.New("unable to use OS credential store")
+	ErrKeyringPasswordMissing = errors.New("no password saved in OS credential store")
+)
+
+// keyringAccount returns the name a password is stored under in the credential store,
+// which is the database URL without its password and query parameters,
+// e.g. postgres://user@localhost:5432/app
+func keyringAccount(u *url.URL) string {
+	account := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   u.Path,
+		Opaque: u.Opaque,
+	}
+	if u.User != nil {
+		account.User = url.User(u.User.Username())
+	}
+
+	return account.String()
+}
+
+// KeyringAccount returns the name the password of the database URL is stored under in
+// the OS credential store
+func (db *DB) KeyringAccount() (string, error) {
+	if db.DatabaseURL == nil || db.DatabaseURL.Scheme == "" {
+		return "", ErrInvalidURL
+	}
+
+	return keyringAccount(db.DatabaseURL), nil
+}
+
+// SetKeyringPassword saves the password for the database URL in the OS credential
+// store, so that it can be read by adding the passwordkeyring parameter to the URL
+func (db *DB) SetKeyringPassword(password string) error {
+	account, err := db.KeyringAccount()
+	if err != nil {
+		return err
+	}
+
+	if err := keyring.Set(keyringService, account, password); err != nil {
+		return fmt.Errorf("%w: %s", ErrKeyring, err)
+	}
+
+	return nil
+}
+
+// KeyringPassword returns the password saved for the database URL in the OS credential
+// store
+func (db *DB) KeyringPassword() (string, error) {
+	if _, err := db.KeyringAccount(); err != nil {
+		return "", err
+	}
+
+	return keyringPassword(db.DatabaseURL)
+}
+
+// DeleteKeyringPassword removes the password saved for the database URL from the OS
+// credential store
+func (db *DB) DeleteKeyringPassword() error {
+	account, err := db.KeyringAccount()
+	if err != nil {
+		return err
+	}
+
+	err = keyring.Delete(keyringService, account)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("%w: %s", ErrKeyringPasswordMissing, account)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrKeyring, err)
+	}
+
+	return nil
+}
+
+func keyringPassword(u *url.URL) (string, error) {
+	password, err := keyring.Get(keyringService, keyringAccount(u))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", fmt.Errorf("%w: %s (save it with `dbmate auth set`)", ErrKeyringPasswordMissing, keyringAccount(u))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrKeyring, err)
+	}
+
+	return password, nil
+}
+
+// resolvePasswordKeyring returns u with the password read from the OS credential store,
+// and the passwordkeyring query parameter removed. If the parameter is not present, u is
+// returned unchanged.
+func resolvePasswordKeyring(u *url.URL) (*url.URL, error) {
+	query := u.Query()
+	if _, ok := query[PasswordKeyringParam]; !ok {
+		return u, nil
+	}
+	if _, set := u.User.Password(); set {
+		return nil, fmt.Errorf("%w: the URL already contains a password", ErrKeyring)
+	}
+
+	password, err := keyringPassword(u)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := *u
+	resolved.User = url.UserPassword(u.User.Username(), password)
+	query.Del(PasswordKeyringParam)
+	resolved.RawQuery = query.Encode()
+
+	return &resolved, nil
+}
@@ -0,0 +1,63 @@
+package dbmate
+
+import (
+	"context"
+	"fmt"
+)
+
+// Locker is a lock held while migrations are applied or rolled back, so that concurrent
+// dbmate runs against the same database wait for each other. It is needed for databases
+// without locks of their own, such as ClickHouse and BigQuery, and may be backed by any
+// external store (see the lock package).
+type Locker interface {
+	// Lock blocks until the lock is acquired, and returns an error wrapping ErrLockHeld
+	// if it gives up waiting
+	Lock(ctx context.Context) error
+	// Unlock releases the lock, and returns an error if it was lost while it was held
+	Unlock(ctx context.Context) error
+}
+
+// lockChecker is implemented by lockers which can tell whether the lock is still held,
+// e.g. because it expires unless it is refreshed
+type lockChecker interface {
+	// Check returns an error if the lock was lost since it was acquired
+	Check() error
+}
+
+// checkLock returns an error if db.Locker was lost while it was held, so that no further
+// migrations are applied or rolled back by a run which may no longer be the only one
+func (db *DB) checkLock() error {
+	checker, ok := db.Locker.(lockChecker)
+	if !ok || !db.locked {
+		return nil
+	}
+
+	if err := checker.Check(); err != nil {
+		return fmt.Errorf("unable to continue without lock: %w", err)
+	}
+
+	return nil
+}
+
+// lock acquires db.Locker, if set, and returns a function which releases it and records
+// the first error in err. Actions called while the lock is held (e.g. rollback by
+// RollbackAll, or migrate by each shard) do not acquire it again.
+func (db *DB) lock() (func(err *error), error) {
+	if db.Locker == nil || db.locked {
+		return func(*error) {}, nil
+	}
+
+	db.logger().Debugf("Acquiring lock")
+	if err := db.Locker.Lock(context.Background()); err != nil {
+		return nil, err
+	}
+	db.locked = true
+
+	return func(err *error) {
+		db.locked = false
+		db.logger().Debugf("Releasing lock")
+		if unlockErr := db.Locker.Unlock(context.Background()); unlockErr != nil && *err == nil {
+			*err = fmt.Errorf("unable to release lock: %w", unlockErr)
+		}
+	}, nil
+}
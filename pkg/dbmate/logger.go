@@ -0,0 +1,208 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Logger receives dbmate's output. Messages are formatted using fmt.Sprintf, and do
+// not include a trailing newline. Debug messages include the statements executed by
+// migrations and their duration.
+//
+// *logrus.Logger and *logrus.Entry implement this interface, and NewSlogLogger adapts
+// a *slog.Logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Log levels, in increasing order of verbosity. Each level includes the messages of the
+// levels before it.
+const (
+	LogLevelError = "error"
+	LogLevelWarn  = "warn"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// LogLevels lists the valid log levels, in increasing order of verbosity
+var LogLevels = []string{LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelDebug}
+
+// ErrInvalidLogLevel is returned when a log level is not one of LogLevels
+var ErrInvalidLogLevel = errors.New("invalid log level")
+
+// ValidateLogLevel returns ErrInvalidLogLevel if level is not empty or one of LogLevels
+func ValidateLogLevel(level string) error {
+	if level == "" || logLevelRank(level) >= 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s (expected %s)", ErrInvalidLogLevel, level, strings.Join(LogLevels, ", "))
+}
+
+// logLevelRank returns the position of level in LogLevels, or -1 if it is invalid
+func logLevelRank(level string) int {
+	for i, l := range LogLevels {
+		if l == level {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// levelLogger discards messages which are more verbose than a log level
+type levelLogger struct {
+	l     Logger
+	level int
+}
+
+// NewLevelLogger returns a Logger which passes messages to l, discarding those which
+// are more verbose than level (one of LogLevels). An invalid level discards nothing.
+func NewLevelLogger(l Logger, level string) Logger {
+	rank := logLevelRank(level)
+	if rank < 0 {
+		return l
+	}
+
+	return levelLogger{l: l, level: rank}
+}
+
+// enabled returns true if messages at level are passed on
+func (l levelLogger) enabled(level string) bool {
+	return logLevelRank(level) <= l.level
+}
+
+func (l levelLogger) Debugf(format string, args ...interface{}) {
+	if l.enabled(LogLevelDebug) {
+		l.l.Debugf(format, args...)
+	}
+}
+
+func (l levelLogger) Infof(format string, args ...interface{}) {
+	if l.enabled(LogLevelInfo) {
+		l.l.Infof(format, args...)
+	}
+}
+
+func (l levelLogger) Warnf(format string, args ...interface{}) {
+	if l.enabled(LogLevelWarn) {
+		l.l.Warnf(format, args...)
+	}
+}
+
+func (l levelLogger) Errorf(format string, args ...interface{}) {
+	l.l.Errorf(format, args...)
+}
+
+// writerLogger writes each message to an io.Writer on its own line
+type writerLogger struct {
+	w     io.Writer
+	debug bool
+}
+
+// NewWriterLogger returns a Logger which writes messages to w, one per line. Debug
+// messages are discarded unless debug is true.
+func NewWriterLogger(w io.Writer, debug bool) Logger {
+	return writerLogger{w: w, debug: debug}
+}
+
+func (l writerLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		l.write(format, args)
+	}
+}
+
+func (l writerLogger) Infof(format string, args ...interface{}) {
+	l.write(format, args)
+}
+
+func (l writerLogger) Warnf(format string, args ...interface{}) {
+	l.write(format, args)
+}
+
+func (l writerLogger) Errorf(format string, args ...interface{}) {
+	l.write(format, args)
+}
+
+func (l writerLogger) write(format string, args []interface{}) {
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+// redactingLogger removes passwords from messages before passing them to a logger
+type redactingLogger struct {
+	l Logger
+}
+
+func (l redactingLogger) Debugf(format string, args ...interface{}) {
+	l.l.Debugf("%s", dbutil.RedactPasswords(fmt.Sprintf(format, args...)))
+}
+
+func (l redactingLogger) Infof(format string, args ...interface{}) {
+	l.l.Infof("%s", dbutil.RedactPasswords(fmt.Sprintf(format, args...)))
+}
+
+func (l redactingLogger) Warnf(format string, args ...interface{}) {
+	l.l.Warnf("%s", dbutil.RedactPasswords(fmt.Sprintf(format, args...)))
+}
+
+func (l redactingLogger) Errorf(format string, args ...interface{}) {
+	l.l.Errorf("%s", dbutil.RedactPasswords(fmt.Sprintf(format, args...)))
+}
+
+// logger returns db.Logger if set, otherwise a logger which writes to db.Log (and
+// includes debug messages if db.Verbose is set). Messages more verbose than db.LogLevel
+// are discarded, and passwords are redacted from all messages.
+func (db *DB) logger() Logger {
+	level := db.LogLevel
+	if db.Verbose {
+		level = LogLevelDebug
+	}
+
+	if db.Logger != nil {
+		return redactingLogger{l: NewLevelLogger(db.Logger, level)}
+	}
+
+	if level == "" {
+		level = LogLevelInfo
+	}
+
+	return redactingLogger{l: NewLevelLogger(NewWriterLogger(db.Log, true), level)}
+}
+
+// traceStatements returns true if the text and duration of each statement are logged.
+// Verbose only prints the results of statements, so they are logged if LogLevel is
+// LogLevelDebug, or if it is empty and Logger applies its own level.
+func (db *DB) traceStatements() bool {
+	if db.LogLevel == "" {
+		return db.Logger != nil
+	}
+
+	return db.LogLevel == LogLevelDebug
+}
+
+// tracef logs a debug message about a statement if statement tracing is enabled
+func (db *DB) tracef(format string, args ...interface{}) {
+	if db.traceStatements() {
+		db.logger().Debugf(format, args...)
+	}
+}
+
+// logWriter forwards output written by drivers to the logger, one message per line
+type logWriter struct {
+	db *DB
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.db.logger().Infof("%s", line)
+	}
+
+	return len(p), nil
+}
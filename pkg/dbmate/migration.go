@@ -1,20 +1,77 @@
 package dbmate
 
 import (
+	"bufio"
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Migration represents an available migration and status
 type Migration struct {
-	Applied  bool
-	FileName string
-	FilePath string
-	FS       fs.FS
-	Version  string
+	Applied bool
+	// Environments lists the environments a pending migration is restricted to by the
+	// env option of its up block, or is empty if it runs in every environment
+	Environments []string
+	FileName     string
+	FilePath     string
+	FS           fs.FS
+	// Irreversible is true if the down block is marked irreversible, in which case the
+	// migration can't be rolled back
+	Irreversible bool
+	// Phase is PhaseExpand or PhaseContract, set by the phase option of the up block.
+	// Migrations without a phase option are part of the expand phase.
+	Phase string
+	// Requires lists the versions of the migrations which must be applied before this
+	// one, declared with -- migrate:requires annotations
+	Requires []string
+	// Skipped is true if the migration is pending, but recorded as skipped by an earlier
+	// run, excluded by DB.SkipVersions, restricted to environments which do not include
+	// DB.Environment, not part of DB.Phase, or not selected by DB.Tags
+	Skipped bool
+	// Tags lists the lower case tags declared with -- migrate:tags annotations
+	Tags    []string
+	Version string
+
+	// skipRecorded is true if the migration is recorded as skipped in the migrations
+	// table, which must be replaced when it is applied
+	skipRecorded bool
+}
+
+// directives returns the options of the up and down blocks, and the versions and tags
+// listed by requires and tags annotations, reading the file only as far as the down
+// directive. A missing block is reported when the migration is parsed.
+func (m *Migration) directives() (up, down migrationOptions, requires, tags []string, err error) {
+	file, err := m.open()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	defer file.Close()
+
+	up, down = migrationOptions{}, migrationOptions{}
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, nil, nil, err
+		}
+		if upRegExp.MatchString(line) {
+			up = parseMigrationOptions(line)
+		}
+		requires = append(requires, parseRequires(line)...)
+		tags = append(tags, parseTags(line)...)
+		if downRegExp.MatchString(line) {
+			return up, parseMigrationOptions(line), requires, tags, nil
+		}
+		if err == io.EOF {
+			return up, down, requires, tags, nil
+		}
+	}
 }
 
 func (m *Migration) readFile() (string, error) {
@@ -27,6 +84,29 @@ func (m *Migration) readFile() (string, error) {
 	return string(bytes), err
 }
 
+func (m *Migration) open() (io.ReadCloser, error) {
+	if m.FS == nil {
+		return os.Open(m.FilePath)
+	}
+
+	return m.FS.Open(m.FilePath)
+}
+
+func (m *Migration) size() (int64, error) {
+	var info fs.FileInfo
+	var err error
+	if m.FS == nil {
+		info, err = os.Stat(m.FilePath)
+	} else {
+		info, err = fs.Stat(m.FS, m.FilePath)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size(), nil
+}
+
 // Parse a migration
 func (m *Migration) Parse() (*ParsedMigration, error) {
 	contents, err := m.readFile()
@@ -50,6 +130,8 @@ type ParsedMigrationOptions interface {
 	Transaction() bool
 }
 
+// migrationOptions implements ParsedMigrationOptions, and the options which dbmate
+// supports beyond it
 type migrationOptions map[string]string
 
 // Transaction returns whether or not this migration should run in a transaction
@@ -58,8 +140,77 @@ func (m migrationOptions) Transaction() bool {
 	return m["transaction"] != "false"
 }
 
+// BatchSize returns the maximum number of rows each execution of a batched migration
+// may affect. Defaults to 0, which means the migration is not batched.
+func (m migrationOptions) BatchSize() int {
+	size, err := strconv.Atoi(m["batch"])
+	if err != nil || size < 0 {
+		return 0
+	}
+
+	return size
+}
+
+// BatchSleep returns how long to wait between executions of a batched migration.
+// Defaults to 0.
+func (m migrationOptions) BatchSleep() time.Duration {
+	sleep, err := time.ParseDuration(m["sleep"])
+	if err != nil {
+		return 0
+	}
+
+	return sleep
+}
+
+// Environments returns the lower case names of the environments the migration may be
+// applied in, e.g. env:staging,production. Defaults to nil, which means every
+// environment.
+func (m migrationOptions) Environments() []string {
+	if m["env"] == "" {
+		return nil
+	}
+
+	envs := []string{}
+	for _, env := range strings.Split(m["env"], ",") {
+		if env = strings.ToLower(strings.TrimSpace(env)); env != "" {
+			envs = append(envs, env)
+		}
+	}
+
+	return envs
+}
+
+// Phase returns the lower case phase of the migration, e.g. phase:contract. Defaults to
+// PhaseExpand.
+func (m migrationOptions) Phase() string {
+	if m["phase"] == "" {
+		return PhaseExpand
+	}
+
+	return strings.ToLower(m["phase"])
+}
+
+// RetryOn returns the lower case conditions which a failed migration is retried on, e.g.
+// retry_on:deadlock,lock_timeout. Defaults to nil, which means migrations using
+// transaction:false are never retried.
+func (m migrationOptions) RetryOn() []string {
+	if m["retry_on"] == "" {
+		return nil
+	}
+
+	conditions := []string{}
+	for _, condition := range strings.Split(m["retry_on"], ",") {
+		if condition = strings.ToLower(strings.TrimSpace(condition)); condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	return conditions
+}
+
 var (
 	upRegExp              = regexp.MustCompile(`(?m)^--\s*migrate:up(\s*$|\s+\S+)`)
+	statementRegExp       = regexp.MustCompile(`^--\s*migrate:statement\s*$`)
 	downRegExp            = regexp.MustCompile(`(?m)^--\s*migrate:down(\s*$|\s+\S+)`)
 	emptyLineRegExp       = regexp.MustCompile(`^\s*$`)
 	commentLineRegExp     = regexp.MustCompile(`^\s*--`)
@@ -74,6 +225,7 @@ var (
 	ErrParseMissingDown    = errors.New("dbmate requires each migration to define a down block with '-- migrate:down'")
 	ErrParseWrongOrder     = errors.New("dbmate requires '-- migrate:up' to appear before '-- migrate:down'")
 	ErrParseUnexpectedStmt = errors.New("dbmate does not support statements preceding the '-- migrate:up' block")
+	ErrParseStatementOrder = errors.New("dbmate requires '-- migrate:statement' to precede the first statement of a block, if it is used")
 )
 
 // parseMigrationContents parses the string contents of a migration.
@@ -110,6 +262,108 @@ func parseMigrationContents(contents string) (*ParsedMigration, error) {
 	return &parsed, nil
 }
 
+// streamBlock reads the up or down block of a migration line by line, without loading
+// the whole file into memory. It returns the options of the block directive, and if fn
+// is not nil, calls it for each statement in the block.
+func (m *Migration) streamBlock(up bool, backslashEscapes bool, fn statementFunc) (migrationOptions, error) {
+	file, err := m.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var options migrationOptions
+	splitter := statementSplitter{backslashEscapes: backslashEscapes}
+	reader := bufio.NewReader(file)
+	hasUp, hasDown, inBlock := false, false, false
+	// explicit is set if the block separates statements with -- migrate:statement
+	// directives, which must then precede the first statement
+	explicit, hasStatements := false, false
+
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+
+		switch {
+		case !hasUp && upRegExp.MatchString(line):
+			hasUp = true
+			if up {
+				options = parseMigrationOptions(line)
+				inBlock = true
+			}
+			continue
+		case hasUp && !hasDown && downRegExp.MatchString(line):
+			hasDown = true
+			if up {
+				// end of up block
+				if fn == nil {
+					return options, nil
+				}
+				return options, splitter.flush(fn)
+			}
+			options = parseMigrationOptions(line)
+			if fn == nil {
+				return options, nil
+			}
+			inBlock = true
+			continue
+		case !hasUp && downRegExp.MatchString(line):
+			return nil, ErrParseWrongOrder
+		case !hasUp && !isEmptyLine(line) && !isCommentLine(line):
+			return nil, ErrParseUnexpectedStmt
+		}
+
+		if inBlock && statementRegExp.MatchString(line) {
+			if hasStatements && !explicit {
+				return nil, ErrParseStatementOrder
+			}
+			explicit = true
+			if fn != nil {
+				if err := splitter.startStatement(fn); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if inBlock && !isEmptyLine(line) && !isCommentLine(line) {
+			hasStatements = true
+		}
+
+		if inBlock && fn != nil {
+			if err := splitter.feed(line, lineNo, fn); err != nil {
+				return nil, err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if !hasUp {
+		return nil, ErrParseMissingUp
+	}
+	if !hasDown {
+		return nil, ErrParseMissingDown
+	}
+	if fn == nil {
+		return options, nil
+	}
+
+	return options, splitter.flush(fn)
+}
+
+// Irreversible returns whether the block is marked irreversible, e.g.
+// -- migrate:down irreversible. Defaults to false.
+func (m migrationOptions) Irreversible() bool {
+	return m["irreversible"] == "true"
+}
+
 // parseMigrationOptions parses the migration options out of a block
 // directive into an object that implements the MigrationOptions interface.
 //
@@ -117,7 +371,7 @@ func parseMigrationContents(contents string) (*ParsedMigration, error) {
 //
 //	fmt.Printf("%#v", parseMigrationOptions("-- migrate:up transaction:false"))
 //	// migrationOptions{"transaction": "false"}
-func parseMigrationOptions(contents string) ParsedMigrationOptions {
+func parseMigrationOptions(contents string) migrationOptions {
 	options := make(migrationOptions)
 
 	// remove everything after first newline
@@ -145,6 +399,11 @@ func parseMigrationOptions(contents string) ParsedMigrationOptions {
 		if len(pair) == 2 {
 			options[pair[0]] = pair[1]
 		}
+
+		// a key without a value is a flag, e.g. "irreversible" -> {"irreversible": "true"}
+		if len(pair) == 1 {
+			options[pair[0]] = "true"
+		}
 	}
 
 	return options
@@ -3,6 +3,7 @@ package dbmate
 import (
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -70,6 +71,20 @@ drop table users;
 		require.Equal(t, true, parsed.DownOptions.Transaction())
 	})
 
+	t.Run("irreversible down block", func(t *testing.T) {
+		migration := `-- migrate:up
+update users set name = lower(name);
+-- migrate:down irreversible
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, false, parsed.UpOptions.(migrationOptions).Irreversible())
+		require.Equal(t, true, parsed.DownOptions.(migrationOptions).Irreversible())
+		require.Equal(t, true, parsed.DownOptions.Transaction())
+	})
+
 	t.Run("require up before down", func(t *testing.T) {
 		migration := `-- migrate:down
 drop table users;
@@ -100,6 +115,52 @@ ALTER TYPE colors ADD VALUE 'orange' AFTER 'red';
 		require.Equal(t, false, parsed.DownOptions.Transaction())
 	})
 
+	t.Run("support batched migrations", func(t *testing.T) {
+		migration := `-- migrate:up batch:1000 sleep:50ms
+UPDATE users SET status = 'active' WHERE id IN (SELECT id FROM users WHERE status IS NULL LIMIT 1000);
+-- migrate:down
+`
+
+		parsed, err := parseMigrationContents(migration)
+		require.Nil(t, err)
+
+		require.Equal(t, 1000, parsed.UpOptions.(migrationOptions).BatchSize())
+		require.Equal(t, 50*time.Millisecond, parsed.UpOptions.(migrationOptions).BatchSleep())
+		require.True(t, parsed.UpOptions.Transaction())
+		require.NoError(t, validateBatchOptions(parsed.UpOptions.(migrationOptions)))
+
+		require.Equal(t, 0, parsed.DownOptions.(migrationOptions).BatchSize())
+		require.Equal(t, time.Duration(0), parsed.DownOptions.(migrationOptions).BatchSleep())
+		require.NoError(t, validateBatchOptions(parsed.DownOptions.(migrationOptions)))
+
+		for _, directive := range []string{
+			"-- migrate:up batch:0",
+			"-- migrate:up batch:-1",
+			"-- migrate:up batch:ten",
+			"-- migrate:up batch:10 sleep:soon",
+			"-- migrate:up sleep:50ms",
+		} {
+			err := validateBatchOptions(parseMigrationOptions(directive))
+			require.ErrorIs(t, err, ErrInvalidBatchOption, directive)
+		}
+	})
+
+	t.Run("support environments", func(t *testing.T) {
+		require.Equal(t, []string{"staging", "production"},
+			parseMigrationOptions("-- migrate:up env:Staging,,production").Environments())
+		require.Nil(t, parseMigrationOptions("-- migrate:up transaction:false").Environments())
+	})
+
+	t.Run("support retry conditions", func(t *testing.T) {
+		options := parseMigrationOptions("-- migrate:up transaction:false retry_on:Deadlock,,lock_timeout")
+		require.Equal(t, []string{"deadlock", "lock_timeout"}, options.RetryOn())
+		require.NoError(t, validateRetryOptions(options))
+		require.Nil(t, parseMigrationOptions("-- migrate:up transaction:false").RetryOn())
+
+		err := validateRetryOptions(parseMigrationOptions("-- migrate:up retry_on:deadlocks"))
+		require.ErrorIs(t, err, ErrInvalidRetryOption)
+	})
+
 	t.Run("require migrate blocks", func(t *testing.T) {
 		migration := `
 ALTER TABLE users
@@ -200,3 +261,118 @@ DROP COLUMN status;
 		})
 	})
 }
+
+func TestStreamBlock(t *testing.T) {
+	fs := fstest.MapFS{
+		"valid.sql": {
+			Data: []byte(`-- a leading comment
+-- migrate:up transaction:false
+insert into users (name) values ('a');
+insert into users (name)
+  values ('b;c');
+
+-- migrate:down
+delete from users;
+`),
+		},
+		"directives.sql": {
+			Data: []byte(`-- migrate:up
+-- migrate:statement
+create function f() returns int as 'select 1;';
+-- migrate:statement
+-- a comment
+select f(); select 2;
+
+-- migrate:down
+drop function f;
+`),
+		},
+		"copy.sql": {Data: []byte("-- migrate:up\ncopy users (id, name) from stdin;\n1\ta;b\n2\t\\N\n\\.\n" +
+			"select 1;\n-- migrate:down\ncopy users from stdin;\n1\tc\n")},
+		"late_directive.sql": {Data: []byte("-- migrate:up\nselect 1;\n-- migrate:statement\nselect 2;\n-- migrate:down\n")},
+		"missing_down.sql":   {Data: []byte("-- migrate:up\nselect 1;\n")},
+		"wrong_order.sql":    {Data: []byte("-- migrate:down\nselect 1;\n-- migrate:up\n")},
+		"unexpected.sql":     {Data: []byte("select 1;\n-- migrate:up\n-- migrate:down\n")},
+	}
+
+	type stmt struct {
+		sql  string
+		line int
+	}
+
+	stream := func(path string, up bool) (ParsedMigrationOptions, []stmt, error) {
+		migration := &Migration{FilePath: path, FS: fs}
+		stmts := []stmt{}
+		options, err := migration.streamBlock(up, false, func(sql string, line int) error {
+			stmts = append(stmts, stmt{sql, line})
+			return nil
+		})
+		return options, stmts, err
+	}
+
+	t.Run("up block", func(t *testing.T) {
+		options, stmts, err := stream("valid.sql", true)
+		require.NoError(t, err)
+		require.False(t, options.Transaction())
+		require.Equal(t, []stmt{
+			{"insert into users (name) values ('a')", 3},
+			{"insert into users (name)\n  values ('b;c')", 4},
+		}, stmts)
+	})
+
+	t.Run("down block", func(t *testing.T) {
+		options, stmts, err := stream("valid.sql", false)
+		require.NoError(t, err)
+		require.True(t, options.Transaction())
+		require.Equal(t, []stmt{{"delete from users", 8}}, stmts)
+	})
+
+	t.Run("statement directives", func(t *testing.T) {
+		_, stmts, err := stream("directives.sql", true)
+		require.NoError(t, err)
+		require.Equal(t, []stmt{
+			{"create function f() returns int as 'select 1;';", 3},
+			{"-- a comment\nselect f(); select 2;", 6},
+		}, stmts)
+
+		// directives only apply to the block they are in
+		_, stmts, err = stream("directives.sql", false)
+		require.NoError(t, err)
+		require.Equal(t, []stmt{{"drop function f", 9}}, stmts)
+	})
+
+	t.Run("copy data", func(t *testing.T) {
+		_, stmts, err := stream("copy.sql", true)
+		require.NoError(t, err)
+		require.Equal(t, []stmt{
+			{"copy users (id, name) from stdin;\n1\ta;b\n2\t\\N\n\\.", 2},
+			{"select 1", 6},
+		}, stmts)
+
+		_, _, err = stream("copy.sql", false)
+		require.ErrorIs(t, err, ErrParseCopyData)
+		require.EqualError(t, err, `dbmate requires the data of each COPY ... FROM STDIN statement to end with a line `+
+			`containing only '\.': statement starting at line 8`)
+	})
+
+	t.Run("options only", func(t *testing.T) {
+		migration := &Migration{FilePath: "valid.sql", FS: fs}
+		options, err := migration.streamBlock(true, false, nil)
+		require.NoError(t, err)
+		require.False(t, options.Transaction())
+	})
+
+	t.Run("invalid migrations", func(t *testing.T) {
+		_, _, err := stream("missing_down.sql", true)
+		require.ErrorIs(t, err, ErrParseMissingDown)
+
+		_, _, err = stream("wrong_order.sql", true)
+		require.ErrorIs(t, err, ErrParseWrongOrder)
+
+		_, _, err = stream("unexpected.sql", true)
+		require.ErrorIs(t, err, ErrParseUnexpectedStmt)
+
+		_, _, err = stream("late_directive.sql", true)
+		require.ErrorIs(t, err, ErrParseStatementOrder)
+	})
+}
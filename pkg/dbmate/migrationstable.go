@@ -0,0 +1,97 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrIncompatibleMigrationsTable is returned if the migrations table exists, but does not
+// have the layout dbmate expects, for example because it was created by another migration
+// tool
+var ErrIncompatibleMigrationsTable = errors.New("incompatible migrations table")
+
+// checkMigrationsTable returns ErrIncompatibleMigrationsTable if the migrations table
+// exists, but has no version column, or was created by another migration tool. If adopt
+// is set, the table of a tool which ImportHistory supports is converted instead. Tables
+// are only checked if the driver can list their columns.
+func (db *DB) checkMigrationsTable(drv Driver, sqlDB *sql.DB, adopt bool) error {
+	inspector, ok := drv.(migrationsTableInspector)
+	if !ok {
+		return nil
+	}
+
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil || !exists {
+		return classifyError(drv, err, nil)
+	}
+
+	columns, err := inspector.MigrationsTableColumns(sqlDB)
+	if err != nil {
+		return classifyError(drv, err, nil)
+	}
+
+	tool := historyTool(columns)
+	if tool == "" {
+		if hasColumn(columns, "version") {
+			return nil
+		}
+		return fmt.Errorf("%w: %s has columns (%s), but dbmate requires a version column",
+			ErrIncompatibleMigrationsTable, db.MigrationsTableName, strings.Join(columns, ", "))
+	}
+
+	if !adopt {
+		return fmt.Errorf("%w: %s was created by %s, use --adopt to convert it (the original table is kept)",
+			ErrIncompatibleMigrationsTable, db.MigrationsTableName, tool)
+	}
+
+	db.logger().Infof("Adopting: %s from %s", db.MigrationsTableName, tool)
+
+	return db.ImportHistory(tool, db.MigrationsTableName)
+}
+
+// adoptMigrationsTable converts the migrations table if it was created by another
+// migration tool which ImportHistory supports
+func (db *DB) adoptMigrationsTable(drv Driver) error {
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	return db.checkMigrationsTable(drv, sqlDB, true)
+}
+
+// historyTool returns the migration tool which created a table with these columns, or an
+// empty string if it is not recognized
+func historyTool(columns []string) string {
+	tools := make([]string, 0, len(historyImporters))
+	for tool := range historyImporters {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+
+	for _, tool := range tools {
+		matches := true
+		for _, column := range historyImporters[tool].columns {
+			matches = matches && hasColumn(columns, column)
+		}
+		if matches {
+			return tool
+		}
+	}
+
+	return ""
+}
+
+func hasColumn(columns []string, name string) bool {
+	for _, column := range columns {
+		if column == name {
+			return true
+		}
+	}
+
+	return false
+}
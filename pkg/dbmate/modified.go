@@ -0,0 +1,108 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMigrationModified is returned by Status if DB.FailOnModified is set, and the file
+// of an applied migration has changed since it was applied
+var ErrMigrationModified = errors.New("applied migration file has been modified")
+
+// appliedChecksums returns the checksum of the latest run of each migration, read from
+// the audit table. It is empty if the driver can't read the audit table, or the table
+// does not exist.
+func appliedChecksums(drv Driver, sqlDB *sql.DB) (map[string]string, error) {
+	checksums := map[string]string{}
+	reader, ok := drv.(auditReader)
+	if !ok {
+		return checksums, nil
+	}
+
+	records, err := reader.SelectAudit(sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Direction == AuditUp {
+			checksums[record.Version] = record.Checksum
+		}
+	}
+
+	return checksums, nil
+}
+
+// isModified returns true if the migration file no longer matches the checksum recorded
+// when it was applied. Migrations applied without auditing have no recorded checksum,
+// and are never reported as modified.
+func (m *Migration) isModified(checksums map[string]string) (bool, error) {
+	recorded := checksums[m.Version]
+	if recorded == "" {
+		return false, nil
+	}
+
+	checksum, err := m.checksum()
+	if err != nil {
+		return false, err
+	}
+
+	return checksum != recorded, nil
+}
+
+// modifiedMigrations returns the versions of the applied migrations whose files no
+// longer match the checksum recorded when they were applied. Only Status compares
+// checksums, since reading every applied migration file would slow down other commands.
+func (db *DB) modifiedMigrations(migrations []Migration) (map[string]bool, error) {
+	modified := map[string]bool{}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := drv.(auditReader); !ok {
+		return modified, nil
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil || !exists {
+		return modified, classifyError(drv, err, nil)
+	}
+	checksums, err := appliedChecksums(drv, sqlDB)
+	if err != nil {
+		return nil, classifyError(drv, err, nil)
+	}
+
+	for i := range migrations {
+		if !migrations[i].Applied {
+			continue
+		}
+		if modified[migrations[i].Version], err = migrations[i].isModified(checksums); err != nil {
+			return nil, fmt.Errorf("%s: %w", migrations[i].FileName, err)
+		}
+	}
+
+	return modified, nil
+}
+
+// checkModified returns ErrMigrationModified listing the modified migrations
+func checkModified(migrations []Migration, modified map[string]bool) error {
+	files := []string{}
+	for _, m := range migrations {
+		if modified[m.Version] {
+			files = append(files, m.FileName)
+		}
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationModified, strings.Join(files, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package dbmate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOperationTimeout is returned if a long running operation does not complete within
+// DB.OperationTimeout
+var ErrOperationTimeout = errors.New("timed out waiting for operation")
+
+// Operation is a long running operation started by a driver, such as a Spanner schema
+// update or a BigQuery query job, which can take minutes to complete. Drivers pass operations to
+// DriverConfig.WaitOperation, which polls them and reports their progress.
+type Operation interface {
+	// Name identifies the operation in log messages
+	Name() string
+	// Poll refreshes the state of the operation, and returns true once it has completed,
+	// along with its error if it failed
+	Poll(ctx context.Context) (done bool, err error)
+	// Progress returns the percentage of the operation which has completed, or -1 if it
+	// is not known
+	Progress() int
+}
+
+// WaitOperationFunc waits for a long running operation to complete
+type WaitOperationFunc func(ctx context.Context, op Operation) error
+
+// waitOperation polls op every OperationPollInterval until it completes, logging its
+// progress, and gives up after OperationTimeout. The operation is not cancelled on
+// timeout, since most servers cannot roll back a partially applied schema change.
+func (db *DB) waitOperation(ctx context.Context, op Operation) error {
+	if db.OperationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.OperationTimeout)
+		defer cancel()
+	}
+
+	interval := db.OperationPollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	startedAt := time.Now()
+	for {
+		done, err := op.Poll(ctx)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %s did not complete within %s, and continues to run on the server",
+				ErrOperationTimeout, op.Name(), db.OperationTimeout)
+		}
+		if done || err != nil {
+			return err
+		}
+
+		elapsed := time.Since(startedAt).Round(time.Second)
+		if progress := op.Progress(); progress >= 0 {
+			db.logger().Infof("Waiting for %s: %d%% complete (%s elapsed)", op.Name(), progress, elapsed)
+		} else {
+			db.logger().Infof("Waiting for %s (%s elapsed)", op.Name(), elapsed)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package dbmate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testOperation completes after a number of polls
+type testOperation struct {
+	polls int
+	err   error
+}
+
+func (o *testOperation) Name() string {
+	return "test operation"
+}
+
+func (o *testOperation) Poll(context.Context) (bool, error) {
+	o.polls--
+	if o.polls > 0 {
+		return false, nil
+	}
+
+	return true, o.err
+}
+
+func (o *testOperation) Progress() int {
+	return 100 / (o.polls + 1)
+}
+
+func TestWaitOperation(t *testing.T) {
+	var buf bytes.Buffer
+	db := &DB{Log: &buf, OperationPollInterval: time.Millisecond}
+
+	err := db.waitOperation(context.Background(), &testOperation{polls: 3})
+	require.NoError(t, err)
+	require.Equal(t, "Waiting for test operation: 33% complete (0s elapsed)\n"+
+		"Waiting for test operation: 50% complete (0s elapsed)\n", buf.String())
+
+	// operation errors are returned
+	opErr := errors.New("invalid statement")
+	err = db.waitOperation(context.Background(), &testOperation{polls: 1, err: opErr})
+	require.ErrorIs(t, err, opErr)
+
+	// give up after the timeout
+	db.OperationTimeout = 10 * time.Millisecond
+	err = db.waitOperation(context.Background(), &testOperation{polls: 1000})
+	require.ErrorIs(t, err, ErrOperationTimeout)
+}
@@ -0,0 +1,49 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// PasswordFileParam is the database URL query parameter which names a file containing
+// the database password, so the password does not need to be included in the URL
+const PasswordFileParam = "passwordfile"
+
+// ErrPasswordFile is returned if the password file cannot be used
+var ErrPasswordFile = errors.New("invalid password file")
+
+// resolvePasswordFile returns u with the password read from the file named by the
+// passwordfile query parameter, and the parameter removed. If the parameter is not
+// present, u is returned unchanged.
+func resolvePasswordFile(u *url.URL) (*url.URL, error) {
+	query := u.Query()
+	if _, ok := query[PasswordFileParam]; !ok {
+		return u, nil
+	}
+
+	path := query.Get(PasswordFileParam)
+	if path == "" {
+		return nil, fmt.Errorf("%w: %s parameter is empty", ErrPasswordFile, PasswordFileParam)
+	}
+	if _, set := u.User.Password(); set {
+		return nil, fmt.Errorf("%w: the URL already contains a password", ErrPasswordFile)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrPasswordFile, err)
+	}
+
+	// files written by editors and secret managers often end with a newline
+	password := strings.TrimRight(string(contents), "\r\n")
+
+	resolved := *u
+	resolved.User = url.UserPassword(u.User.Username(), password)
+	query.Del(PasswordFileParam)
+	resolved.RawQuery = query.Encode()
+
+	return &resolved, nil
+}
@@ -0,0 +1,36 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Migration phases, for deployments which apply backward compatible changes before a
+// new version of an application is rolled out, and destructive changes after
+const (
+	// PhaseExpand migrations make backward compatible changes, such as adding a table or
+	// a nullable column, which can be applied while the previous version is running
+	PhaseExpand = "expand"
+	// PhaseContract migrations make destructive changes, such as dropping a column, which
+	// can only be applied once the previous version is no longer running
+	PhaseContract = "contract"
+)
+
+// ErrInvalidPhase is returned when a migration or DB.Phase specifies an unknown phase
+var ErrInvalidPhase = errors.New("invalid phase")
+
+// ErrPhaseStrict is returned when migrating with both DB.Phase and DB.Strict set. Each
+// phase skips the pending migrations of the other, so contract migrations are applied
+// after expand migrations with later versions, which strict mode rejects as out of
+// order.
+var ErrPhaseStrict = errors.New("--phase cannot be combined with --strict, since phases apply migrations out of version order")
+
+// validatePhase returns ErrInvalidPhase if phase is not PhaseExpand or PhaseContract
+func validatePhase(phase string) error {
+	switch phase {
+	case PhaseExpand, PhaseContract:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s (expected %s or %s)", ErrInvalidPhase, phase, PhaseExpand, PhaseContract)
+	}
+}
@@ -0,0 +1,324 @@
+package dbmate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Error codes
+var (
+	ErrInvalidPlan           = errors.New("invalid plan")
+	ErrPlanSignature         = errors.New("plan signature does not match")
+	ErrPlanUnsigned          = errors.New("plan is not signed")
+	ErrPlanTargetChanged     = errors.New("database has changed since the plan was created")
+	ErrPlanMigrationsChanged = errors.New("migrations have changed since the plan was created")
+)
+
+// PlanFormat is the version of the plan file format
+const PlanFormat = 1
+
+// planSignaturePrefix identifies the algorithm used to sign plans
+const planSignaturePrefix = "hmac-sha256:"
+
+// Plan records the migrations which are pending on a database, so that they can be
+// reviewed and approved before ApplyPlan applies exactly those migrations
+type Plan struct {
+	Format        int       `json:"format"`
+	CreatedAt     time.Time `json:"created_at"`
+	DbmateVersion string    `json:"dbmate_version"`
+	// Database identifies the target database, without credentials
+	Database string `json:"database"`
+	// Fingerprint is the hex encoded SHA-256 hash of the target database and the
+	// migrations applied to it
+	Fingerprint string          `json:"fingerprint"`
+	Migrations  []PlanMigration `json:"migrations"`
+	// Signature is an HMAC of the other fields, if the plan was created with DB.PlanKey
+	Signature string `json:"signature,omitempty"`
+}
+
+// PlanMigration is a pending migration recorded in a plan
+type PlanMigration struct {
+	Version  string `json:"version"`
+	FileName string `json:"file"`
+	// Checksum is the hex encoded SHA-256 hash of the migration file
+	Checksum string `json:"checksum"`
+}
+
+// ReadPlan reads a plan written by Plan.Write
+func ReadPlan(r io.Reader) (*Plan, error) {
+	plan := &Plan{}
+	if err := json.NewDecoder(r).Decode(plan); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPlan, err)
+	}
+	if plan.Format != PlanFormat {
+		return nil, fmt.Errorf("%w: unsupported format %d (expected %d)", ErrInvalidPlan,
+			plan.Format, PlanFormat)
+	}
+
+	return plan, nil
+}
+
+// Write writes the plan as indented JSON
+func (p *Plan) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// sign returns the signature of the plan with the key, which covers every field except
+// the signature itself
+func (p Plan) sign(key []byte) (string, error) {
+	p.Signature = ""
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return planSignaturePrefix + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify returns an error unless the plan was signed with the key. Signed plans can't
+// be applied without a key, so that a missing key is not mistaken for approval.
+func (p *Plan) verify(key []byte) error {
+	if len(key) == 0 {
+		if p.Signature != "" {
+			return fmt.Errorf("%w: the plan is signed, but no key was given to verify it", ErrPlanSignature)
+		}
+		return nil
+	}
+	if p.Signature == "" {
+		return ErrPlanUnsigned
+	}
+
+	expected, err := p.sign(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(p.Signature)) {
+		return ErrPlanSignature
+	}
+
+	return nil
+}
+
+// Plan returns the pending migrations of the database with their checksums, and a
+// fingerprint of the database. The plan is signed if DB.PlanKey is set.
+func (db *DB) Plan() (*Plan, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	applied := map[string]bool{}
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil {
+		return nil, classifyError(drv, err, nil)
+	}
+	if exists {
+		if applied, err = drv.SelectMigrations(sqlDB, -1); err != nil {
+			return nil, classifyError(drv, err, nil)
+		}
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := db.planFingerprint(drv, sqlDB, exists, applied)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Format:        PlanFormat,
+		CreatedAt:     time.Now().UTC().Truncate(time.Second),
+		DbmateVersion: Version,
+		Database:      planDatabase(db.DatabaseURL),
+		Fingerprint:   fingerprint,
+		Migrations:    []PlanMigration{},
+	}
+	for _, migration := range migrations {
+		if migration.Applied || migration.Skipped {
+			continue
+		}
+
+		checksum, err := migration.checksum()
+		if err != nil {
+			return nil, err
+		}
+		plan.Migrations = append(plan.Migrations, PlanMigration{
+			Version:  migration.Version,
+			FileName: migration.FileName,
+			Checksum: checksum,
+		})
+	}
+
+	if len(db.PlanKey) > 0 {
+		if plan.Signature, err = plan.sign(db.PlanKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return plan, nil
+}
+
+// ApplyPlan applies the migrations of a plan created by Plan. It returns an error,
+// without applying any migrations, if the plan's signature does not match DB.PlanKey,
+// if the database, its schema, or its applied migrations have changed, or if the pending
+// migrations are not exactly those in the plan, with the same checksums. The plan is
+// checked while holding the lock, and the checksum of each migration file is checked
+// again just before it is applied.
+func (db *DB) ApplyPlan(plan *Plan) error {
+	if err := plan.verify(db.PlanKey); err != nil {
+		return err
+	}
+
+	db.applyingPlan = plan
+	defer func() { db.applyingPlan = nil }()
+
+	return db.migrate("")
+}
+
+// checkPlan returns an error unless the database and its pending migrations are those
+// the plan was created for
+func (db *DB) checkPlan(plan *Plan) error {
+	// the current plan is compared without a signature
+	key := db.PlanKey
+	db.PlanKey = nil
+	current, err := db.Plan()
+	db.PlanKey = key
+	if err != nil {
+		return err
+	}
+
+	if current.Fingerprint != plan.Fingerprint {
+		return fmt.Errorf("%w: %s (planned for %s)", ErrPlanTargetChanged, current.Database, plan.Database)
+	}
+	if err := comparePlanMigrations(plan.Migrations, current.Migrations); err != nil {
+		return err
+	}
+
+	db.logger().Infof("Applying plan created at %s: %d migrations",
+		plan.CreatedAt.Format(time.RFC3339), len(plan.Migrations))
+
+	return nil
+}
+
+// checkPlanChecksum returns an error unless the migration file still has the checksum
+// recorded in the plan
+func checkPlanChecksum(plan *Plan, migration Migration) error {
+	checksum, err := migration.checksum()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range plan.Migrations {
+		if p.Version == migration.Version {
+			if p.Checksum != checksum {
+				return fmt.Errorf("%w: %s has been modified", ErrPlanMigrationsChanged, migration.FileName)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s not in the plan", ErrPlanMigrationsChanged, migration.FileName)
+}
+
+// comparePlanMigrations returns an error describing the first difference between the
+// planned and pending migrations
+func comparePlanMigrations(planned, pending []PlanMigration) error {
+	pendingByVersion := map[string]PlanMigration{}
+	for _, m := range pending {
+		pendingByVersion[m.Version] = m
+	}
+
+	for _, p := range planned {
+		m, ok := pendingByVersion[p.Version]
+		switch {
+		case !ok:
+			return fmt.Errorf("%w: %s is no longer pending", ErrPlanMigrationsChanged, p.FileName)
+		case m.Checksum != p.Checksum:
+			return fmt.Errorf("%w: %s has been modified", ErrPlanMigrationsChanged, p.FileName)
+		}
+		delete(pendingByVersion, p.Version)
+	}
+
+	if len(pendingByVersion) > 0 {
+		files := []string{}
+		for _, m := range pendingByVersion {
+			files = append(files, m.FileName)
+		}
+		sort.Strings(files)
+		return fmt.Errorf("%w: %s not in the plan", ErrPlanMigrationsChanged, strings.Join(files, ", "))
+	}
+
+	return nil
+}
+
+// planDatabase returns the database URL without credentials or query parameters
+func planDatabase(u *url.URL) string {
+	clone := *u
+	clone.User = nil
+	clone.RawQuery = ""
+	clone.Fragment = ""
+
+	return clone.String()
+}
+
+// planFingerprint returns the hex encoded SHA-256 hash of the database URL (without
+// credentials or query parameters), the driver's fingerprint of the database if it has
+// one, the applied migration versions, and the schema of the database, so that a plan
+// is refused if the database was replaced or changed outside of dbmate
+func (db *DB) planFingerprint(drv Driver, sqlDB *sql.DB, migrationsTable bool, applied map[string]bool) (string, error) {
+	hash := sha256.New()
+	fmt.Fprintln(hash, planDatabase(db.DatabaseURL))
+
+	if fingerprinter, ok := drv.(databaseFingerprinter); ok {
+		fingerprint, err := fingerprinter.DatabaseFingerprint(sqlDB)
+		if err != nil {
+			return "", classifyError(drv, err, nil)
+		}
+		fmt.Fprintln(hash, fingerprint)
+	}
+
+	versions := []string{}
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		fmt.Fprintln(hash, version)
+	}
+
+	// drivers dump the migrations table along with the schema, so a database which has
+	// never been migrated is identified by its applied migrations (none) alone
+	if migrationsTable {
+		schema, err := drv.DumpSchema(sqlDB)
+		if err != nil {
+			return "", err
+		}
+		hash.Write(schema)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,130 @@
+package dbmate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Default Progress settings
+const (
+	DefaultProgressMinMigrations = 10
+	DefaultProgressInterval      = 10 * time.Second
+)
+
+// progressBarWidth is the number of characters in the progress bar
+const progressBarWidth = 30
+
+// Progress reports how many migrations have been applied, and estimates the time
+// remaining, when many migrations are applied at once (e.g. bootstrapping a new
+// environment). It is intended to be used as (or called from) DB.EventHandler:
+//
+//	db.EventHandler = dbmate.NewProgress(os.Stderr).Event
+//
+// If the output is a terminal, a progress bar is redrawn after each migration, and
+// cleared before the next one starts, so that it stays below dbmate's log messages.
+// Otherwise, a line is written every Interval.
+type Progress struct {
+	// MinMigrations is the number of migrations which must be pending for progress to be
+	// reported
+	MinMigrations int
+	// Interval is how often progress lines are written, if the output is not a terminal
+	Interval time.Duration
+
+	out       io.Writer
+	terminal  bool
+	now       func() time.Time
+	startedAt time.Time
+	writtenAt time.Time
+	drawn     bool
+}
+
+// NewProgress returns a Progress writing to out
+func NewProgress(out io.Writer) *Progress {
+	terminal := false
+	if f, ok := out.(*os.File); ok {
+		terminal = term.IsTerminal(int(f.Fd()))
+	}
+
+	return &Progress{
+		MinMigrations: DefaultProgressMinMigrations,
+		Interval:      DefaultProgressInterval,
+		out:           out,
+		terminal:      terminal,
+		now:           time.Now,
+	}
+}
+
+// Event updates the progress
+func (p *Progress) Event(e Event) {
+	switch e := e.(type) {
+	case MigrationStarted:
+		if e.Total < p.MinMigrations {
+			return
+		}
+		if e.Position == 1 {
+			p.startedAt = p.now()
+			p.writtenAt = p.startedAt
+		}
+		p.clear()
+	case MigrationFinished:
+		if e.Total < p.MinMigrations {
+			return
+		}
+		p.finished(e.Position, e.Total)
+	case Error:
+		if p.drawn {
+			fmt.Fprintln(p.out)
+			p.drawn = false
+		}
+	}
+}
+
+// finished reports that done of total migrations have been applied
+func (p *Progress) finished(done, total int) {
+	now := p.now()
+	elapsed := now.Sub(p.startedAt)
+	remaining := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	percent := done * 100 / total
+
+	if p.terminal {
+		filled := done * progressBarWidth / total
+		fmt.Fprintf(p.out, "\r[%s%s] %d/%d (%d%%) ETA %s", strings.Repeat("#", filled),
+			strings.Repeat(".", progressBarWidth-filled), done, total, percent, formatETA(remaining))
+		p.drawn = true
+		if done == total {
+			fmt.Fprintln(p.out)
+			p.drawn = false
+		}
+		return
+	}
+
+	if done < total && now.Sub(p.writtenAt) < p.Interval {
+		return
+	}
+	p.writtenAt = now
+	if done == total {
+		fmt.Fprintf(p.out, "Progress: %d/%d migrations applied in %s\n", done, total,
+			elapsed.Round(time.Second))
+		return
+	}
+	fmt.Fprintf(p.out, "Progress: %d/%d migrations applied (%d%%), about %s remaining\n", done, total,
+		percent, formatETA(remaining))
+}
+
+// clear removes the progress bar from the terminal
+func (p *Progress) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// formatETA rounds an estimate to whole seconds
+func formatETA(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
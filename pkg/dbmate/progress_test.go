@@ -0,0 +1,69 @@
+package dbmate
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runProgress simulates applying total migrations which each take a minute
+func runProgress(p *Progress, total int) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+
+	for i := 1; i <= total; i++ {
+		p.Event(MigrationStarted{Position: i, Total: total})
+		now = now.Add(time.Minute)
+		p.Event(MigrationFinished{Position: i, Total: total})
+	}
+}
+
+func TestProgress(t *testing.T) {
+	t.Run("log lines", func(t *testing.T) {
+		var out bytes.Buffer
+		p := NewProgress(&out)
+		p.MinMigrations = 4
+		p.Interval = 2 * time.Minute
+		runProgress(p, 4)
+
+		require.Equal(t, "Progress: 2/4 migrations applied (50%), about 2m0s remaining\n"+
+			"Progress: 4/4 migrations applied in 4m0s\n", out.String())
+	})
+
+	t.Run("terminal", func(t *testing.T) {
+		var out bytes.Buffer
+		p := NewProgress(&out)
+		p.MinMigrations = 2
+		p.terminal = true
+		runProgress(p, 2)
+
+		require.Equal(t, "\r[###############...............] 1/2 (50%) ETA 1m0s"+
+			"\r\033[K"+
+			"\r[##############################] 2/2 (100%) ETA 0s\n", out.String())
+	})
+
+	t.Run("error", func(t *testing.T) {
+		var out bytes.Buffer
+		p := NewProgress(&out)
+		p.MinMigrations = 2
+		p.terminal = true
+		p.now = time.Now
+		p.Event(MigrationStarted{Position: 1, Total: 2})
+		p.Event(MigrationFinished{Position: 1, Total: 2})
+		p.Event(Error{})
+
+		require.True(t, bytes.HasSuffix(out.Bytes(), []byte("\n")))
+		require.False(t, p.drawn)
+	})
+
+	t.Run("few migrations", func(t *testing.T) {
+		var out bytes.Buffer
+		p := NewProgress(&out)
+		p.terminal = true
+		runProgress(p, DefaultProgressMinMigrations-1)
+
+		require.Empty(t, out.String())
+	})
+}
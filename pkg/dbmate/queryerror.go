@@ -0,0 +1,158 @@
+package dbmate
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// queryErrorContext is the number of lines before the error shown in the excerpt
+const queryErrorContext = 2
+
+// QueryError is returned when a query fails. Drivers set Position, or Line and Column,
+// when the database reports where in the query the error occurred.
+type QueryError struct {
+	Err   error
+	Query string
+	// Position is the 1-based character offset of the error in Query (0 if unknown)
+	Position int
+	// Line is the 1-based line of the error in Query (0 if unknown), and Column is the
+	// 1-based character offset of the error in that line (0 if unknown). If Position is
+	// set, they are computed from it.
+	Line   int
+	Column int
+}
+
+// Unwrap returns the underlying driver error
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+func (e *QueryError) Error() string {
+	line, column := e.Location()
+	if line == 0 {
+		return e.Err.Error()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "line: %d", line)
+	if column > 0 {
+		fmt.Fprintf(&b, ", column: %d", column)
+	}
+	if e.Position > 0 {
+		fmt.Fprintf(&b, ", position: %d", e.Position)
+	}
+	fmt.Fprintf(&b, ": %s", e.Err.Error())
+
+	if excerpt := e.Excerpt(); excerpt != "" {
+		b.WriteString("\n")
+		b.WriteString(excerpt)
+	}
+
+	return b.String()
+}
+
+// Location returns the line and column of the error in the query, or zero if unknown
+func (e *QueryError) Location() (line, column int) {
+	if e.Position <= 0 {
+		return e.Line, e.Column
+	}
+
+	line = 1
+	column = 1
+	offset := 0
+	for _, ch := range e.Query {
+		offset++
+		if offset >= e.Position {
+			break
+		}
+		// don't count CR as a column in CR/LF sequences
+		if ch == '\r' {
+			continue
+		}
+		if ch == '\n' {
+			line++
+			column = 1
+			continue
+		}
+		column++
+	}
+
+	return line, column
+}
+
+// Excerpt returns the line containing the error and the lines before it, prefixed with
+// line numbers. If the column is known, a caret marks it on the following line:
+//
+//	  2 | -- create users
+//	> 3 | crate table users (id integer);
+//	    | ^
+func (e *QueryError) Excerpt() string {
+	line, column := e.Location()
+	lines := strings.Split(e.Query, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	first := line - queryErrorContext
+	if first < 1 {
+		first = 1
+	}
+	width := len(fmt.Sprint(line))
+
+	var b strings.Builder
+	for i := first; i <= line; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		text := strings.TrimRight(lines[i-1], "\r")
+		fmt.Fprintf(&b, "%s %*d | %s", marker, width, i, text)
+		if i < line || column > 0 {
+			b.WriteString("\n")
+		}
+	}
+
+	if column > 0 {
+		// align the caret with the error, preserving tabs in the text before it
+		var indent strings.Builder
+		n := 0
+		for _, ch := range lines[line-1] {
+			if n >= column-1 {
+				break
+			}
+			if ch == '\t' {
+				indent.WriteRune('\t')
+			} else {
+				indent.WriteRune(' ')
+			}
+			n++
+		}
+		fmt.Fprintf(&b, "  %*s | %s^", width, "", indent.String())
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// TextPosition returns the 1-based character offset of text in query, for drivers whose
+// errors quote the text near the error rather than its position. Returns 0 if text is
+// empty, or does not occur exactly once in query (since the error cannot be located).
+func TextPosition(query, text string) int {
+	i := strings.Index(query, text)
+	if text == "" || i < 0 || strings.Contains(query[i+1:], text) {
+		return 0
+	}
+
+	return utf8.RuneCountInString(query[:i]) + 1
+}
+
+// BytePosition converts a 1-based byte offset in query to a character offset, for
+// drivers which report the position of errors in bytes. Returns 0 if the offset is
+// outside of query.
+func BytePosition(query string, offset int) int {
+	if offset < 1 || offset > len(query)+1 {
+		return 0
+	}
+
+	return utf8.RuneCountInString(query[:offset-1]) + 1
+}
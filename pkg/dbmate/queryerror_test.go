@@ -0,0 +1,76 @@
+package dbmate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryError(t *testing.T) {
+	errSyntax := errors.New("syntax error")
+
+	t.Run("unknown location", func(t *testing.T) {
+		err := &QueryError{Err: errSyntax, Query: "select 1"}
+		require.Equal(t, "syntax error", err.Error())
+		require.Equal(t, "", err.Excerpt())
+		require.ErrorIs(t, err, errSyntax)
+	})
+
+	t.Run("position", func(t *testing.T) {
+		query := "-- one\n-- two\n-- three\ncreate table t (id integer);\n\tcrate table u (id integer);\n"
+		err := &QueryError{Err: errSyntax, Query: query, Position: 54}
+		line, column := err.Location()
+		require.Equal(t, 5, line)
+		require.Equal(t, 2, column)
+		require.Equal(t, "line: 5, column: 2, position: 54: syntax error\n"+
+			"  3 | -- three\n"+
+			"  4 | create table t (id integer);\n"+
+			"> 5 | \tcrate table u (id integer);\n"+
+			"    | \t^", err.Error())
+	})
+
+	t.Run("line and column", func(t *testing.T) {
+		err := &QueryError{Err: errSyntax, Query: "select\n  fro", Line: 2, Column: 3}
+		require.Equal(t, "line: 2, column: 3: syntax error\n"+
+			"  1 | select\n"+
+			"> 2 |   fro\n"+
+			"    |   ^", err.Error())
+	})
+
+	t.Run("line only", func(t *testing.T) {
+		err := &QueryError{Err: errSyntax, Query: "select\r\nfro\r\n", Line: 2}
+		require.Equal(t, "line: 2: syntax error\n"+
+			"  1 | select\n"+
+			"> 2 | fro", err.Error())
+	})
+
+	t.Run("line outside of query", func(t *testing.T) {
+		err := &QueryError{Err: errSyntax, Query: "select 1", Line: 3}
+		require.Equal(t, "line: 3: syntax error", err.Error())
+	})
+
+	t.Run("many lines", func(t *testing.T) {
+		query := "1\n2\n3\n4\n5\n6\n7\n8\n9\nsyntax error"
+		err := &QueryError{Err: errSyntax, Query: query, Line: 10, Column: 8}
+		require.Equal(t, "   8 | 8\n"+
+			"   9 | 9\n"+
+			"> 10 | syntax error\n"+
+			"     |        ^", err.Excerpt())
+	})
+}
+
+func TestTextPosition(t *testing.T) {
+	require.Equal(t, 8, TextPosition("select fro", "fro"))
+	require.Equal(t, 14, TextPosition("/* สวัสดี */ fro", "fro"))
+	require.Equal(t, 0, TextPosition("select fro", "from"))
+	require.Equal(t, 0, TextPosition("select fro, fro", "fro"))
+	require.Equal(t, 0, TextPosition("select fro", ""))
+}
+
+func TestBytePosition(t *testing.T) {
+	require.Equal(t, 1, BytePosition("fro", 1))
+	require.Equal(t, 14, BytePosition("/* สวัสดี */ fro", 26))
+	require.Equal(t, 0, BytePosition("fro", 0))
+	require.Equal(t, 0, BytePosition("fro", 5))
+}
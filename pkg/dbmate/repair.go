@@ -0,0 +1,223 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrRepairUnsupported  = errors.New("repair is not supported by this driver")
+	ErrInvalidExpectation = errors.New("invalid migrate:expect annotation")
+)
+
+// Repair actions
+const (
+	RepairActionNone    = "none"
+	RepairActionRecord  = "record"
+	RepairActionRemove  = "remove"
+	RepairActionPartial = "partial"
+)
+
+// expectRegExp matches expect annotations, e.g. -- migrate:expect table:users column:users.email
+var expectRegExp = regexp.MustCompile(`(?m)^--\s*migrate:expect\s+(.*)$`)
+
+// Expectation is a database object which should exist once a migration is applied,
+// declared in the migration with an annotation such as -- migrate:expect table:users
+type Expectation struct {
+	// Kind is the type of object (table or column)
+	Kind string `json:"kind"`
+	// Name is the table name, or table.column for columns
+	Name string `json:"name"`
+}
+
+func (e Expectation) String() string {
+	return e.Kind + ":" + e.Name
+}
+
+// RepairResult describes whether the migrations table agrees with the objects a
+// migration expects
+type RepairResult struct {
+	Version  string `json:"version"`
+	FileName string `json:"file"`
+	Applied  bool   `json:"applied"`
+	// Missing lists the expected objects which do not exist
+	Missing []string `json:"missing,omitempty"`
+	// Action is record if the objects exist but the migration is not recorded, remove if
+	// the migration is recorded but none of its objects exist, partial if only some of
+	// its objects exist (which must be fixed by hand), or none
+	Action string `json:"action"`
+}
+
+// expectations returns the objects declared with migrate:expect annotations
+func (m *Migration) expectations() ([]Expectation, error) {
+	contents, err := m.readFile()
+	if err != nil {
+		return nil, err
+	}
+
+	expectations := []Expectation{}
+	for _, match := range expectRegExp.FindAllStringSubmatch(contents, -1) {
+		for _, field := range strings.Fields(match[1]) {
+			kind, name, _ := strings.Cut(field, ":")
+			valid := false
+			switch kind {
+			case "table":
+				valid = name != "" && !strings.Contains(name, ".")
+			case "column":
+				table, column, _ := strings.Cut(name, ".")
+				valid = table != "" && column != "" && !strings.Contains(column, ".")
+			}
+			if !valid {
+				return nil, fmt.Errorf("%w in %s: %s (expected table:NAME or column:TABLE.COLUMN)",
+					ErrInvalidExpectation, m.FileName, field)
+			}
+			expectations = append(expectations, Expectation{Kind: kind, Name: strings.ToLower(name)})
+		}
+	}
+
+	return expectations, nil
+}
+
+// Repair cross-checks the migrations table against the objects each migration expects
+// to exist, as declared with migrate:expect annotations, to detect migrations which were
+// applied without being recorded (or recorded without being applied), for example after
+// a non-transactional migration failed part way through. Migrations without annotations
+// are not checked.
+//
+// If fix is true, missing records are inserted, and records of migrations whose objects
+// do not exist are deleted. Partially applied migrations are only reported.
+func (db *DB) Repair(fix bool) ([]RepairResult, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	inspector, ok := drv.(schemaInspector)
+	if !ok {
+		return nil, ErrRepairUnsupported
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	tables, err := inspector.TableColumns(sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	// objects are matched case insensitively, since unquoted names are folded
+	objects := map[string]bool{}
+	for table, columns := range tables {
+		objects["table:"+strings.ToLower(table)] = true
+		for _, column := range columns {
+			objects["column:"+strings.ToLower(table+"."+column)] = true
+		}
+	}
+
+	results := []RepairResult{}
+	for _, migration := range migrations {
+		if migration.Skipped {
+			continue
+		}
+
+		expectations, err := migration.expectations()
+		if err != nil {
+			return nil, err
+		}
+		if len(expectations) == 0 {
+			continue
+		}
+
+		result := RepairResult{
+			Version:  migration.Version,
+			FileName: migration.FileName,
+			Applied:  migration.Applied,
+			Action:   RepairActionNone,
+		}
+		for _, expectation := range expectations {
+			if !objects[expectation.String()] {
+				result.Missing = append(result.Missing, expectation.String())
+			}
+		}
+		sort.Strings(result.Missing)
+
+		switch {
+		case len(result.Missing) == 0 && !migration.Applied:
+			result.Action = RepairActionRecord
+			db.logger().Warnf("Applied but not recorded: %s", migration.FileName)
+		case len(result.Missing) == len(expectations) && migration.Applied:
+			result.Action = RepairActionRemove
+			db.logger().Warnf("Recorded but not applied: %s (missing %s)",
+				migration.FileName, strings.Join(result.Missing, ", "))
+		case len(result.Missing) > 0 && len(result.Missing) < len(expectations):
+			result.Action = RepairActionPartial
+			db.logger().Errorf("Partially applied: %s (missing %s), fix the database by hand",
+				migration.FileName, strings.Join(result.Missing, ", "))
+		}
+		results = append(results, result)
+	}
+
+	actions, partial := 0, 0
+	for _, result := range results {
+		switch result.Action {
+		case RepairActionRecord, RepairActionRemove:
+			actions++
+		case RepairActionPartial:
+			partial++
+		}
+	}
+	if actions == 0 {
+		if partial == 0 {
+			db.logger().Infof("Migrations table is consistent with the database")
+		}
+		return results, nil
+	}
+	if !fix {
+		db.logger().Infof("Run with --fix to update %d migration records", actions)
+		return results, nil
+	}
+
+	exists, err := drv.MigrationsTableExists(sqlDB)
+	if err != nil {
+		return results, err
+	}
+	if !exists {
+		if err := drv.CreateMigrationsTable(sqlDB); err != nil {
+			return results, err
+		}
+	}
+
+	err = doTransaction(drv, sqlDB, func(tx dbutil.Transaction) error {
+		for _, result := range results {
+			switch result.Action {
+			case RepairActionRecord:
+				db.logger().Infof("Recording: %s", result.FileName)
+				if err := drv.InsertMigration(tx, result.Version); err != nil {
+					return err
+				}
+			case RepairActionRemove:
+				db.logger().Infof("Removing: %s", result.FileName)
+				if err := drv.DeleteMigration(tx, result.Version); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
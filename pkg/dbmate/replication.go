@@ -0,0 +1,39 @@
+package dbmate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error codes
+var (
+	ErrReplicationUnsupported = errors.New("replication checks are not supported by this driver")
+	ErrReplicationUnhealthy   = errors.New("logical replication is unhealthy")
+)
+
+// checkReplication returns ErrReplicationUnhealthy if db.ReplicationSafe is set and the
+// driver reports a problem with logical replication. Since a migration which breaks
+// replication is usually only detected once it has been applied, the check runs before
+// each migration, so that no further migrations are applied.
+func (db *DB) checkReplication(drv Driver, sqlDB *sql.DB) error {
+	if !db.ReplicationSafe {
+		return nil
+	}
+
+	checker, ok := drv.(replicationChecker)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrReplicationUnsupported, db.DatabaseURL.Scheme)
+	}
+
+	issues, err := checker.ReplicationIssues(sqlDB, db.ReplicationMaxLag)
+	if err != nil {
+		return err
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%w: %s", ErrReplicationUnhealthy, strings.Join(issues, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package dbmate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrMissingDown is returned if DB.RequireDown is set, and a pending migration has an
+// empty down block which is not marked irreversible
+var ErrMissingDown = errors.New("migration has an empty down block, add statements to it or mark it '-- migrate:down irreversible'")
+
+// hasDownStatements returns true if the down block of the migration contains anything
+// other than empty lines, comments, and statement directives. The file is read line by
+// line, so that large migrations are not loaded into memory.
+func (m *Migration) hasDownStatements() (bool, error) {
+	file, err := m.open()
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	inDown := false
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		switch {
+		case !inDown && downRegExp.MatchString(line):
+			inDown = true
+		case inDown && !isEmptyLine(line) && !isCommentLine(line) && !statementRegExp.MatchString(line):
+			return true, nil
+		}
+
+		if err == io.EOF {
+			return false, nil
+		}
+	}
+}
+
+// checkDownBlocks returns ErrMissingDown listing the pending migrations which could not
+// be rolled back, before any of them are applied
+func checkDownBlocks(migrations []Migration) error {
+	missing := []string{}
+	for i := range migrations {
+		if migrations[i].Irreversible {
+			continue
+		}
+
+		ok, err := migrations[i].hasDownStatements()
+		if err != nil {
+			return fmt.Errorf("%s: %w", migrations[i].FileName, err)
+		}
+		if !ok {
+			missing = append(missing, migrations[i].FileName)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingDown, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package dbmate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasDownStatements(t *testing.T) {
+	cases := map[string]bool{
+		"-- migrate:up\ncreate table users (id integer);\n-- migrate:down\ndrop table users;":      true,
+		"-- migrate:up\ncreate table users (id integer);\n-- migrate:down\n":                       false,
+		"-- migrate:up\ncreate table users (id integer);\n-- migrate:down":                         false,
+		"-- migrate:up\ncreate table users (id integer);\n-- migrate:down\n  \n-- nothing to do\n": false,
+		"-- migrate:up\n-- migrate:down\n-- migrate:statement\n":                                   false,
+		"-- migrate:up\n-- migrate:down\n-- migrate:statement\ndrop table users;\n":                true,
+	}
+	for contents, expected := range cases {
+		m := Migration{FileName: "001_test.sql", FilePath: "001_test.sql",
+			FS: fstest.MapFS{"001_test.sql": {Data: []byte(contents)}}}
+		ok, err := m.hasDownStatements()
+		require.NoError(t, err)
+		require.Equal(t, expected, ok, contents)
+	}
+}
+
+func TestCheckDownBlocks(t *testing.T) {
+	fs := fstest.MapFS{
+		"001_empty.sql":        {Data: []byte("-- migrate:up\nselect 1;\n-- migrate:down\n")},
+		"002_irreversible.sql": {Data: []byte("-- migrate:up\nselect 1;\n-- migrate:down irreversible\n")},
+		"003_full.sql":         {Data: []byte("-- migrate:up\nselect 1;\n-- migrate:down\nselect 2;\n")},
+		"004_empty.sql":        {Data: []byte("-- migrate:up\nselect 1;\n-- migrate:down\n")},
+	}
+	migrations := []Migration{
+		{FileName: "001_empty.sql", FilePath: "001_empty.sql", FS: fs},
+		{FileName: "002_irreversible.sql", FilePath: "002_irreversible.sql", FS: fs, Irreversible: true},
+		{FileName: "003_full.sql", FilePath: "003_full.sql", FS: fs},
+		{FileName: "004_empty.sql", FilePath: "004_empty.sql", FS: fs},
+	}
+
+	// every migration with an empty down block is listed
+	err := checkDownBlocks(migrations)
+	require.ErrorIs(t, err, ErrMissingDown)
+	require.Contains(t, err.Error(), ": 001_empty.sql, 004_empty.sql")
+
+	require.NoError(t, checkDownBlocks(migrations[1:3]))
+}
@@ -0,0 +1,152 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Error codes
+var (
+	ErrRequiredMigrationNotFound   = errors.New("required migration not found")
+	ErrRequiredMigrationNotApplied = errors.New("required migration would not be applied first")
+	ErrRequiresCycle               = errors.New("migration requirements form a cycle")
+)
+
+// requiresRegExp matches an annotation declaring the versions of the migrations which
+// must be applied first, e.g. -- migrate:requires 20230101120000, 20230102120000
+var requiresRegExp = regexp.MustCompile(`^--\s*migrate:requires\s+(.*)$`)
+
+// parseRequires returns the versions listed by a requires annotation, or nil if the line
+// is not a requires annotation
+func parseRequires(line string) []string {
+	match := requiresRegExp.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return nil
+	}
+
+	return strings.FieldsFunc(match[1], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// validateRequires checks that every required migration exists, and that no migration
+// requires itself, directly or indirectly
+func validateRequires(migrations []Migration) error {
+	byVersion := map[string]*Migration{}
+	for i := range migrations {
+		byVersion[migrations[i].Version] = &migrations[i]
+	}
+
+	for _, migration := range migrations {
+		for _, version := range migration.Requires {
+			if byVersion[version] == nil {
+				return fmt.Errorf("%w: %s requires %s", ErrRequiredMigrationNotFound, migration.FileName, version)
+			}
+		}
+	}
+
+	// depth first search, where visiting is the path from the migration being checked
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var visit func(m *Migration, path []string) error
+	visit = func(m *Migration, path []string) error {
+		path = append(path, m.Version)
+		switch state[m.Version] {
+		case visiting:
+			return fmt.Errorf("%w: %s", ErrRequiresCycle, strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[m.Version] = visiting
+		for _, version := range m.Requires {
+			if err := visit(byVersion[version], path); err != nil {
+				return err
+			}
+		}
+		state[m.Version] = visited
+
+		return nil
+	}
+
+	for i := range migrations {
+		if err := visit(&migrations[i], nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// orderByRequires returns the pending migrations in the order they should be applied:
+// by version, except that each migration is moved after the migrations it requires. It
+// returns ErrRequiredMigrationNotApplied if a required migration is neither applied nor
+// pending, e.g. because it is skipped.
+func orderByRequires(migrations, pending []Migration) ([]Migration, error) {
+	applied := map[string]bool{}
+	for _, migration := range migrations {
+		if migration.Applied {
+			applied[migration.Version] = true
+		}
+	}
+	isPending := map[string]bool{}
+	for _, migration := range pending {
+		isPending[migration.Version] = true
+	}
+
+	for _, migration := range pending {
+		for _, version := range migration.Requires {
+			if !applied[version] && !isPending[version] {
+				return nil, fmt.Errorf("%w: %s requires %s", ErrRequiredMigrationNotApplied,
+					migration.FileName, version)
+			}
+		}
+	}
+
+	// repeatedly take the first migration whose requirements have all been applied
+	ordered := make([]Migration, 0, len(pending))
+	remaining := append([]Migration{}, pending...)
+	for len(remaining) > 0 {
+		next := -1
+		for i, migration := range remaining {
+			ready := true
+			for _, version := range migration.Requires {
+				if !applied[version] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrRequiresCycle, remaining[0].FileName)
+		}
+
+		applied[remaining[next].Version] = true
+		ordered = append(ordered, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return ordered, nil
+}
+
+// orderedPending returns the migrations which are neither applied nor skipped, in the
+// order they should be applied
+func orderedPending(migrations []Migration) ([]Migration, error) {
+	pending := []Migration{}
+	for _, migration := range migrations {
+		if !migration.Applied && !migration.Skipped {
+			pending = append(pending, migration)
+		}
+	}
+
+	return orderByRequires(migrations, pending)
+}
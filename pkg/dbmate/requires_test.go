@@ -0,0 +1,66 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRequires(t *testing.T) {
+	require.Equal(t, []string{"001"}, parseRequires("-- migrate:requires 001\n"))
+	require.Equal(t, []string{"001", "002", "003"}, parseRequires("--migrate:requires 001, 002 003"))
+	require.Nil(t, parseRequires("-- migrate:requires"))
+	require.Nil(t, parseRequires("-- migrate:up"))
+	require.Nil(t, parseRequires("select 1; -- migrate:requires 001"))
+}
+
+func TestValidateRequires(t *testing.T) {
+	migrations := []Migration{
+		{Version: "001", FileName: "001_users.sql"},
+		{Version: "002", FileName: "002_posts.sql", Requires: []string{"001"}},
+		{Version: "003", FileName: "003_comments.sql", Requires: []string{"001", "002"}},
+	}
+	require.NoError(t, validateRequires(migrations))
+
+	migrations[0].Requires = []string{"004"}
+	err := validateRequires(migrations)
+	require.ErrorIs(t, err, ErrRequiredMigrationNotFound)
+	require.EqualError(t, err, "required migration not found: 001_users.sql requires 004")
+
+	migrations[0].Requires = []string{"003"}
+	err = validateRequires(migrations)
+	require.ErrorIs(t, err, ErrRequiresCycle)
+	require.EqualError(t, err, "migration requirements form a cycle: 001 -> 003 -> 001")
+}
+
+func TestOrderByRequires(t *testing.T) {
+	versions := func(migrations []Migration) []string {
+		result := []string{}
+		for _, migration := range migrations {
+			result = append(result, migration.Version)
+		}
+		return result
+	}
+
+	migrations := []Migration{
+		{Version: "001", Applied: true},
+		{Version: "002", FileName: "002_posts.sql", Requires: []string{"004"}},
+		{Version: "003"},
+		{Version: "004", Requires: []string{"001"}},
+		{Version: "005", FileName: "005_tags.sql", Requires: []string{"006"}, Skipped: true},
+		{Version: "006", Skipped: true},
+	}
+
+	// migrations are moved after the migrations they require, and otherwise keep their order
+	ordered, err := orderedPending(migrations)
+	require.NoError(t, err)
+	require.Equal(t, []string{"003", "004", "002"}, versions(ordered))
+
+	// required migrations must be applied or pending
+	_, err = orderByRequires(migrations, migrations[1:2])
+	require.ErrorIs(t, err, ErrRequiredMigrationNotApplied)
+	require.EqualError(t, err, "required migration would not be applied first: 002_posts.sql requires 004")
+
+	_, err = orderByRequires(migrations, migrations[4:5])
+	require.ErrorIs(t, err, ErrRequiredMigrationNotApplied)
+}
@@ -0,0 +1,146 @@
+package dbmate
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// maxRetryInterval caps the exponential backoff between migration attempts
+const maxRetryInterval = 30 * time.Second
+
+// isTransientError determines whether an error is likely to be caused by a temporary
+// condition, such that retrying the same operation may succeed. Network failures are
+// recognized for all drivers, and drivers may classify their own errors (e.g. deadlocks
+// or serialization failures) by implementing transientErrorClassifier.
+func isTransientError(drv Driver, err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	if classifier, ok := drv.(transientErrorClassifier); ok {
+		return classifier.IsTransientError(err)
+	}
+
+	return false
+}
+
+// Conditions accepted by the retry_on migration option
+const (
+	RetryOnDeadlock    = "deadlock"
+	RetryOnLockTimeout = "lock_timeout"
+	RetryOnTransient   = "transient"
+)
+
+// defaultRetryOnRetries is how many times a migration with the retry_on option is retried
+// if db.MigrationRetries is not set
+const defaultRetryOnRetries = 3
+
+// ErrInvalidRetryOption is returned if the retry_on option of a migration is invalid
+var ErrInvalidRetryOption = errors.New("invalid retry_on option")
+
+// validateRetryOptions returns an error if the retry_on option of a migration block lists
+// an unknown condition, so that typos are not silently ignored
+func validateRetryOptions(options migrationOptions) error {
+	for _, condition := range options.RetryOn() {
+		switch condition {
+		case RetryOnDeadlock, RetryOnLockTimeout, RetryOnTransient:
+		default:
+			return fmt.Errorf("%w: unknown condition %s (expected %s, %s, or %s)", ErrInvalidRetryOption,
+				condition, RetryOnDeadlock, RetryOnLockTimeout, RetryOnTransient)
+		}
+	}
+
+	return nil
+}
+
+// isDeadlock determines whether an error was caused by a deadlock, as reported by drivers
+// implementing deadlockClassifier
+func isDeadlock(drv Driver, err error) bool {
+	if classifier, ok := drv.(deadlockClassifier); ok {
+		return classifier.IsDeadlock(err)
+	}
+
+	return false
+}
+
+// isRetryable determines whether a failed migration may be retried. Migrations which run
+// inside a transaction are retried on any transient error, since the transaction is rolled
+// back. Other migrations are only retried on the conditions listed by their retry_on
+// option, which asserts that their statements are safe to run again.
+func isRetryable(drv Driver, options migrationOptions, err error) bool {
+	if options.Transaction() && isTransientError(drv, err) {
+		return true
+	}
+
+	for _, condition := range options.RetryOn() {
+		switch {
+		case condition == RetryOnDeadlock && isDeadlock(drv, err),
+			condition == RetryOnLockTimeout && isLockError(drv, err),
+			condition == RetryOnTransient && isTransientError(drv, err):
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyWithRetry applies a migration, retrying up to db.MigrationRetries times (or
+// defaultRetryOnRetries for migrations with the retry_on option, if not set) if it fails
+// with a retryable error. The interval between attempts starts at
+// db.MigrationRetryInterval and doubles after each attempt. Before a migration which does
+// not run inside a transaction is retried, indexes left invalid by its failed concurrent
+// index builds are dropped, so that they are built again.
+func (db *DB) applyWithRetry(drv Driver, sqlDB *sql.DB, migration Migration, options migrationOptions, apply func() error) error {
+	retries := db.MigrationRetries
+	if retries == 0 && len(options.RetryOn()) > 0 {
+		retries = defaultRetryOnRetries
+	}
+
+	interval := db.MigrationRetryInterval
+	for attempt := 1; ; attempt++ {
+		err := apply()
+		if err == nil || attempt > retries || !isRetryable(drv, options, err) {
+			return err
+		}
+
+		db.logger().Warnf("Retrying: %s (attempt %d of %d) in %s: %s",
+			migration.FileName, attempt, retries, interval, err)
+		time.Sleep(interval)
+		interval *= 2
+		if interval > maxRetryInterval {
+			interval = maxRetryInterval
+		}
+
+		// the connection may have failed after the migration was committed, in which
+		// case applying it again would fail
+		applied, selectErr := drv.SelectMigrations(sqlDB, -1)
+		if selectErr == nil && applied[migration.Version] {
+			return nil
+		}
+
+		if !options.Transaction() {
+			if err := db.dropInvalidIndexes(drv, sqlDB, migration); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package dbmate
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var errTestDeadlock = errors.New("deadlock detected")
+
+// retryTestDriver classifies errTestDeadlock as transient and a deadlock, reports the
+// migrations in applied as already applied, and records the indexes it is asked to drop
+type retryTestDriver struct {
+	Driver
+	applied map[string]bool
+	dropped []ConcurrentIndex
+}
+
+func (drv *retryTestDriver) IsTransientError(err error) bool {
+	return errors.Is(err, errTestDeadlock)
+}
+
+func (drv *retryTestDriver) IsDeadlock(err error) bool {
+	return errors.Is(err, errTestDeadlock)
+}
+
+func (drv *retryTestDriver) DropInvalidIndexes(_ *sql.DB, indexes []ConcurrentIndex) ([]string, error) {
+	drv.dropped = append(drv.dropped, indexes...)
+	return []string{"public." + indexes[0].Name}, nil
+}
+
+func (drv *retryTestDriver) SelectMigrations(*sql.DB, int) (map[string]bool, error) {
+	return drv.applied, nil
+}
+
+func TestIsTransientError(t *testing.T) {
+	drv := &retryTestDriver{}
+
+	transient := []error{
+		driver.ErrBadConn,
+		fmt.Errorf("dial: %w", syscall.ECONNREFUSED),
+		&net.OpError{Op: "read", Err: syscall.ECONNRESET},
+		&net.DNSError{Err: "no such host", Name: "db", IsNotFound: true},
+		&QueryError{Err: errTestDeadlock, Query: "select 1"},
+	}
+	for _, err := range transient {
+		require.True(t, isTransientError(drv, err), err.Error())
+	}
+
+	require.False(t, isTransientError(drv, errors.New("syntax error")))
+	require.False(t, isTransientError(nil, errTestDeadlock))
+}
+
+func TestApplyWithRetry(t *testing.T) {
+	migration := Migration{FileName: "001_test.sql", Version: "001"}
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		var log bytes.Buffer
+		db := &DB{Log: &log, MigrationRetries: 3, MigrationRetryInterval: time.Millisecond}
+		drv := &retryTestDriver{applied: map[string]bool{}}
+
+		attempts := 0
+		err := db.applyWithRetry(drv, nil, migration, migrationOptions{}, func() error {
+			attempts++
+			if attempts < 3 {
+				return errTestDeadlock
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, attempts)
+		require.Contains(t, log.String(), "Retrying: 001_test.sql (attempt 1 of 3) in 1ms: deadlock detected\n")
+		require.Contains(t, log.String(), "Retrying: 001_test.sql (attempt 2 of 3) in 2ms: deadlock detected\n")
+	})
+
+	t.Run("gives up after retries", func(t *testing.T) {
+		db := &DB{Log: &bytes.Buffer{}, MigrationRetries: 2, MigrationRetryInterval: time.Millisecond}
+		drv := &retryTestDriver{applied: map[string]bool{}}
+
+		attempts := 0
+		err := db.applyWithRetry(drv, nil, migration, migrationOptions{}, func() error {
+			attempts++
+			return errTestDeadlock
+		})
+		require.ErrorIs(t, err, errTestDeadlock)
+		require.Equal(t, 3, attempts)
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		db := &DB{Log: &bytes.Buffer{}, MigrationRetries: 2, MigrationRetryInterval: time.Millisecond}
+		drv := &retryTestDriver{applied: map[string]bool{}}
+
+		attempts := 0
+		err := db.applyWithRetry(drv, nil, migration, migrationOptions{}, func() error {
+			attempts++
+			return errors.New("syntax error")
+		})
+		require.EqualError(t, err, "syntax error")
+		require.Equal(t, 1, attempts)
+	})
+
+	t.Run("stops if migration was applied", func(t *testing.T) {
+		db := &DB{Log: &bytes.Buffer{}, MigrationRetries: 2, MigrationRetryInterval: time.Millisecond}
+		drv := &retryTestDriver{applied: map[string]bool{"001": true}}
+
+		attempts := 0
+		err := db.applyWithRetry(drv, nil, migration, migrationOptions{}, func() error {
+			attempts++
+			return driver.ErrBadConn
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, attempts)
+	})
+	t.Run("retries migrations outside of a transaction with retry_on", func(t *testing.T) {
+		var log bytes.Buffer
+		db := &DB{Log: &log, MigrationRetryInterval: time.Millisecond}
+		drv := &retryTestDriver{applied: map[string]bool{}}
+		migration := Migration{
+			FileName: "001_index.sql",
+			FilePath: "001_index.sql",
+			FS: fstest.MapFS{"001_index.sql": {Data: []byte("-- migrate:up transaction:false retry_on:deadlock\n" +
+				"create index concurrently if not exists users_email on users (email);\n-- migrate:down\n")}},
+			Version: "001",
+		}
+
+		// not retried without retry_on
+		attempts := 0
+		err := db.applyWithRetry(drv, nil, migration, parseMigrationOptions("-- migrate:up transaction:false"), func() error {
+			attempts++
+			return errTestDeadlock
+		})
+		require.ErrorIs(t, err, errTestDeadlock)
+		require.Equal(t, 1, attempts)
+
+		// retried up to 3 times by default, dropping invalid indexes before each attempt
+		attempts = 0
+		options := parseMigrationOptions("-- migrate:up transaction:false retry_on:deadlock")
+		err = db.applyWithRetry(drv, nil, migration, options, func() error {
+			attempts++
+			return errTestDeadlock
+		})
+		require.ErrorIs(t, err, errTestDeadlock)
+		require.Equal(t, 4, attempts)
+		require.Len(t, drv.dropped, 3)
+		require.Equal(t, ConcurrentIndex{Name: "users_email", Table: "users"}, drv.dropped[0])
+		require.Contains(t, log.String(), "Retrying: 001_index.sql (attempt 1 of 3) in 1ms: deadlock detected\n")
+		require.Contains(t, log.String(), "Dropped invalid index: public.users_email\n")
+
+		// other conditions are not retried
+		attempts = 0
+		options = parseMigrationOptions("-- migrate:up transaction:false retry_on:lock_timeout")
+		err = db.applyWithRetry(drv, nil, migration, options, func() error {
+			attempts++
+			return errTestDeadlock
+		})
+		require.ErrorIs(t, err, errTestDeadlock)
+		require.Equal(t, 1, attempts)
+	})
+}
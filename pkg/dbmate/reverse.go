@@ -0,0 +1,122 @@
+package dbmate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// identifier matches a possibly quoted and schema qualified identifier
+const identifier = "((?:[\\w$]+|\"[^\"]+\"|`[^`]+`)(?:\\.(?:[\\w$]+|\"[^\"]+\"|`[^`]+`))*)"
+
+var (
+	createTableRegexp   = regexp.MustCompile(`(?is)^create\s+(?:(?:global\s+|local\s+)?(?:temporary|temp|unlogged)\s+)?table\s+(if\s+not\s+exists\s+)?` + identifier)
+	createIndexRegexp   = regexp.MustCompile(`(?is)^create\s+(?:unique\s+)?index\s+(?:concurrently\s+)?(if\s+not\s+exists\s+)?` + identifier + `\s+on\s+(?:only\s+)?` + identifier)
+	alterTableRegexp    = regexp.MustCompile(`(?is)^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?` + identifier + `\s+(.*)$`)
+	addColumnRegexp     = regexp.MustCompile(`(?is)^add\s+(?:column\s+)?(if\s+not\s+exists\s+)?` + identifier + `\s`)
+	addConstraintRegexp = regexp.MustCompile(`(?is)^add\s+constraint\s+` + identifier + `\s`)
+	addKeywordRegexp    = regexp.MustCompile(`(?i)\badd\b`)
+)
+
+// reverseMigration generates a best-effort down block for the statements in an up block.
+// Statements which cannot be reversed are included as comments, prefixed with TODO.
+// Drivers which require the table name when dropping an index (mysql) set indexOnTable.
+func reverseMigration(up string, indexOnTable bool) string {
+	stmts, err := splitStatements(up, false)
+	if err != nil {
+		return ""
+	}
+
+	lines := []string{}
+	for i := len(stmts) - 1; i >= 0; i-- {
+		if down, ok := reverseStatement(stmts[i], indexOnTable); ok {
+			lines = append(lines, down+";")
+		} else {
+			lines = append(lines, "-- TODO: unable to reverse: "+firstLine(stmts[i]))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// reverseStatement returns the statement which undoes stmt, if known
+func reverseStatement(stmt string, indexOnTable bool) (string, bool) {
+	stmt = trimLeadingComments(stmt)
+
+	if m := createTableRegexp.FindStringSubmatch(stmt); m != nil {
+		return "drop table " + ifExists(m[1]) + m[2], true
+	}
+
+	if m := createIndexRegexp.FindStringSubmatch(stmt); m != nil {
+		if indexOnTable {
+			return fmt.Sprintf("drop index %s on %s", m[2], m[3]), true
+		}
+		return "drop index " + ifExists(m[1]) + m[2], true
+	}
+
+	if m := alterTableRegexp.FindStringSubmatch(stmt); m != nil {
+		table, action := m[1], m[2]
+
+		// only a single action per statement is supported
+		if len(addKeywordRegexp.FindAllString(action, -1)) != 1 {
+			return "", false
+		}
+
+		if c := addConstraintRegexp.FindStringSubmatch(action); c != nil {
+			return fmt.Sprintf("alter table %s drop constraint %s", table, c[1]), true
+		}
+
+		if c := addColumnRegexp.FindStringSubmatch(action); c != nil {
+			// "add primary key", "add unique", etc. are constraints, not columns
+			if isConstraintKeyword(c[2]) {
+				return "", false
+			}
+			return fmt.Sprintf("alter table %s drop column %s%s", table, ifExists(c[1]), c[2]), true
+		}
+	}
+
+	return "", false
+}
+
+func ifExists(ifNotExists string) string {
+	if ifNotExists == "" {
+		return ""
+	}
+
+	return "if exists "
+}
+
+func isConstraintKeyword(s string) bool {
+	switch strings.ToLower(s) {
+	case "primary", "unique", "foreign", "check", "index", "key", "constraint", "fulltext", "spatial", "exclude":
+		return true
+	}
+
+	return false
+}
+
+// firstLine returns the first line of a statement, ignoring leading comments
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(trimLeadingComments(s), "\n")
+	return strings.TrimSpace(line)
+}
+
+// trimLeadingComments removes comments and whitespace from the start of a statement
+func trimLeadingComments(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			_, rest, _ := strings.Cut(s, "\n")
+			s = rest
+		case strings.HasPrefix(s, "/*"):
+			_, rest, found := strings.Cut(s, "*/")
+			if !found {
+				return ""
+			}
+			s = rest
+		default:
+			return s
+		}
+	}
+}
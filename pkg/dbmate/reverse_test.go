@@ -0,0 +1,44 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseMigration(t *testing.T) {
+	cases := []struct {
+		name         string
+		up           string
+		indexOnTable bool
+		expected     string
+	}{
+		{"create table", "create table users (id integer, name text);",
+			false, "drop table users;"},
+		{"create table if not exists", "CREATE TABLE IF NOT EXISTS public.\"Users\" (id int);",
+			false, "drop table if exists public.\"Users\";"},
+		{"create index", "create unique index concurrently users_name on users (name);",
+			false, "drop index users_name;"},
+		{"create index on table", "create index users_name on `users` (name);",
+			true, "drop index users_name on `users`;"},
+		{"add column", "alter table users add column email text not null;",
+			false, "alter table users drop column email;"},
+		{"add column without keyword", "ALTER TABLE users ADD IF NOT EXISTS email text;",
+			false, "alter table users drop column if exists email;"},
+		{"add constraint", "alter table posts add constraint posts_user_fk foreign key (user_id) references users (id);",
+			false, "alter table posts drop constraint posts_user_fk;"},
+		{"reverse order", "-- users\ncreate table users (id int);\ncreate index users_id on users (id);",
+			false, "drop index users_id;\ndrop table users;"},
+		{"unknown statements", "insert into users (id) values (1);\nalter table users add primary key (id);\n" +
+			"alter table users add a int, add b int;",
+			false, "-- TODO: unable to reverse: alter table users add a int, add b int\n" +
+				"-- TODO: unable to reverse: alter table users add primary key (id)\n" +
+				"-- TODO: unable to reverse: insert into users (id) values (1)"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			require.Equal(t, c.expected, reverseMigration(c.up, c.indexOnTable))
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrSavepointsUnsupported = errors.New("savepoints are not supported by this driver")
+	ErrSavepoint             = errors.New("savepoint failed")
+)
+
+// savepointName is the savepoint each statement is executed within
+const savepointName = "dbmate_statement"
+
+// StatementError is a statement which failed while validating migrations
+type StatementError struct {
+	FileName string `json:"file"`
+	// Line is the line of the migration file the statement starts on
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// supportsSavepoints returns true if the driver can execute statements within savepoints
+func supportsSavepoints(drv Driver) bool {
+	sp, ok := drv.(savepointer)
+	return ok && sp.Savepoints()
+}
+
+// execSavepoint executes a statement within a savepoint. If the statement fails, the
+// transaction is rolled back to the savepoint, so that it remains usable. Errors
+// creating, releasing, or rolling back to the savepoint wrap ErrSavepoint.
+func (db *DB) execSavepoint(tx dbutil.Transaction, stmt string) (int64, error) {
+	if _, err := tx.Exec("savepoint " + savepointName); err != nil {
+		return -1, fmt.Errorf("%w: %w", ErrSavepoint, err)
+	}
+
+	rows, err := db.exec(tx, stmt)
+	if err != nil {
+		if _, rbErr := tx.Exec("rollback to savepoint " + savepointName); rbErr != nil {
+			return -1, fmt.Errorf("%w: %w (after %w)", ErrSavepoint, rbErr, err)
+		}
+		return -1, err
+	}
+
+	if _, err := tx.Exec("release savepoint " + savepointName); err != nil {
+		return -1, fmt.Errorf("%w: %w", ErrSavepoint, err)
+	}
+
+	return rows, nil
+}
+
+// Validate executes the up block of each pending migration within a single transaction,
+// which is always rolled back, and returns every statement which failed. Each statement
+// runs within a savepoint, so a failing statement does not prevent later statements (and
+// migrations) from being checked. Statements after a failure may fail as a consequence,
+// for example if they depend on a table which could not be created.
+//
+// Migrations which are not run in a transaction are skipped, since their changes could
+// not be rolled back. Validation requires a driver which supports savepoints (postgres).
+func (db *DB) Validate() ([]StatementError, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+	if !supportsSavepoints(drv) {
+		return nil, ErrSavepointsUnsupported
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := orderedPending(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	tx, err := sqlDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	failures := []StatementError{}
+	for _, migration := range pending {
+		options, err := migration.streamBlock(true, backslashEscapes, nil)
+		if err != nil {
+			return failures, err
+		}
+		if !options.Transaction() {
+			db.logger().Warnf("Skipping (transaction:false): %s", migration.FileName)
+			continue
+		}
+
+		db.logger().Infof("Validating: %s", migration.FileName)
+		_, err = migration.streamBlock(true, backslashEscapes, func(stmt string, line int) error {
+			_, err := db.execSavepoint(tx, stmt)
+			if errors.Is(err, ErrSavepoint) {
+				return err
+			}
+			if err != nil {
+				err = drv.QueryError(stmt, err)
+				db.logger().Errorf("%s: statement starting at line %d: %s", migration.FileName, line, err)
+				failures = append(failures, StatementError{
+					FileName: migration.FileName,
+					Line:     line,
+					Error:    err.Error(),
+				})
+			}
+
+			return nil
+		})
+		if err != nil {
+			return failures, err
+		}
+	}
+
+	return failures, nil
+}
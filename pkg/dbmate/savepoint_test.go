@@ -0,0 +1,131 @@
+package dbmate
+
+import (
+	"bytes"
+	"database/sql"
+	sqldriver "database/sql/driver"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// savepointTestDriver supports savepoints
+type savepointTestDriver struct {
+	Driver
+}
+
+func (drv *savepointTestDriver) Savepoints() bool {
+	return true
+}
+
+// savepointTestTx records executed statements, and fails those listed in fail
+type savepointTestTx struct {
+	executed []string
+	fail     map[string]bool
+}
+
+func (tx *savepointTestTx) Exec(query string, _ ...interface{}) (sql.Result, error) {
+	tx.executed = append(tx.executed, query)
+	if tx.fail[query] {
+		return nil, errors.New("failed: " + query)
+	}
+
+	return sqldriver.RowsAffected(1), nil
+}
+
+func (tx *savepointTestTx) Query(string, ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (tx *savepointTestTx) QueryRow(string, ...interface{}) *sql.Row {
+	return nil
+}
+
+func TestExecSavepoint(t *testing.T) {
+	db := &DB{Log: &bytes.Buffer{}}
+
+	t.Run("success", func(t *testing.T) {
+		tx := &savepointTestTx{}
+		rows, err := db.execSavepoint(tx, "insert into users (id) values (1)")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), rows)
+		require.Equal(t, []string{
+			"savepoint dbmate_statement",
+			"insert into users (id) values (1)",
+			"release savepoint dbmate_statement",
+		}, tx.executed)
+	})
+
+	t.Run("statement fails", func(t *testing.T) {
+		tx := &savepointTestTx{fail: map[string]bool{"bad": true}}
+		_, err := db.execSavepoint(tx, "bad")
+		require.EqualError(t, err, "failed: bad")
+		require.NotErrorIs(t, err, ErrSavepoint)
+		require.Equal(t, []string{
+			"savepoint dbmate_statement",
+			"bad",
+			"rollback to savepoint dbmate_statement",
+		}, tx.executed)
+	})
+
+	t.Run("rollback fails", func(t *testing.T) {
+		tx := &savepointTestTx{fail: map[string]bool{"bad": true, "rollback to savepoint dbmate_statement": true}}
+		_, err := db.execSavepoint(tx, "bad")
+		require.ErrorIs(t, err, ErrSavepoint)
+		require.EqualError(t, err, "savepoint failed: failed: rollback to savepoint dbmate_statement (after failed: bad)")
+	})
+}
+
+func TestLoadBlockSavepoints(t *testing.T) {
+	migration := Migration{
+		FileName: "001_test.sql",
+		FilePath: "001_test.sql",
+		FS: fstest.MapFS{"001_test.sql": {Data: []byte(
+			"-- migrate:up\ncreate table users (id integer);\ninsert into users (id) values (1);\n" +
+				"-- migrate:down transaction:false\ndrop table users;\n")}},
+		Version: "001",
+	}
+
+	t.Run("transaction", func(t *testing.T) {
+		db := &DB{Log: &bytes.Buffer{}, Savepoints: true}
+		_, execBlock, err := db.loadBlock(&savepointTestDriver{}, migration, true)
+		require.NoError(t, err)
+
+		tx := &savepointTestTx{}
+		rows, err := execBlock(tx)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), rows)
+		require.Equal(t, []string{
+			"savepoint dbmate_statement",
+			"create table users (id integer)",
+			"release savepoint dbmate_statement",
+			"savepoint dbmate_statement",
+			"insert into users (id) values (1)",
+			"release savepoint dbmate_statement",
+		}, tx.executed)
+	})
+
+	t.Run("no transaction", func(t *testing.T) {
+		db := &DB{Log: &bytes.Buffer{}, Savepoints: true}
+		_, execBlock, err := db.loadBlock(&savepointTestDriver{}, migration, false)
+		require.NoError(t, err)
+
+		tx := &savepointTestTx{}
+		_, err = execBlock(tx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"drop table users"}, tx.executed)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db := &DB{Log: &bytes.Buffer{}}
+		_, execBlock, err := db.loadBlock(&savepointTestDriver{}, migration, true)
+		require.NoError(t, err)
+
+		tx := &savepointTestTx{}
+		_, err = execBlock(tx)
+		require.NoError(t, err)
+		require.Len(t, tx.executed, 1)
+	})
+}
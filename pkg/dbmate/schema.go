@@ -0,0 +1,533 @@
+package dbmate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema formats
+const (
+	// SchemaFormatFile writes the schema dump to a single file
+	SchemaFormatFile = "file"
+	// SchemaFormatDirectory writes the schema dump to a directory with one file per
+	// object (e.g. tables/users.sql), so that changes to the schema are easier to review
+	SchemaFormatDirectory = "directory"
+)
+
+// Error codes
+var (
+	ErrInvalidSchemaFormat    = errors.New("invalid schema format")
+	ErrInvalidSchemaDirectory = errors.New("invalid schema directory")
+)
+
+const (
+	// schemaLoadOrderFile lists the file containing each statement of the dump, in the
+	// order they must be executed
+	schemaLoadOrderFile = "load_order.txt"
+	// schemaMigrationsFile contains the dbmate schema migrations section of the dump
+	schemaMigrationsFile = "migrations.sql"
+	// schemaOtherFile contains statements which do not belong to an object, such as
+	// session settings
+	schemaOtherFile = "other.sql"
+)
+
+// schemaMigrationsMarker is the comment written by every driver before the dbmate
+// schema migrations section of the dump
+const schemaMigrationsMarker = "-- Dbmate schema migrations"
+
+// an optionally quoted and qualified identifier, e.g. public.users or `users`
+const schemaIdentifier = "(?:\"[^\"]+\"|`[^`]+`|\\[[^\\]]+\\]|[\\w$]+)"
+const schemaName = "(" + schemaIdentifier + "(?:\\s*\\.\\s*" + schemaIdentifier + ")*)"
+
+// schemaObjectPatterns classify statements by the object they define. The first
+// submatch is the kind of object (for patterns which match several kinds), and the last
+// submatch is its name.
+var schemaObjectPatterns = []struct {
+	dir     string
+	pattern *regexp.Regexp
+}{
+	{"tables", regexp.MustCompile(`(?is)^create\s+(?:or\s+replace\s+)?(?:(?:global\s+|local\s+)?temp(?:orary)?\s+|unlogged\s+|virtual\s+)?table\s+(?:if\s+not\s+exists\s+)?` + schemaName)},
+	// indexes are stored with the table they belong to
+	{"tables", regexp.MustCompile(`(?is)^create\s+(?:unique\s+|fulltext\s+|spatial\s+)*index\s+(?:concurrently\s+)?(?:if\s+not\s+exists\s+)?(?:` + schemaIdentifier + `\s+)?on\s+(?:only\s+)?` + schemaName)},
+	{"views", regexp.MustCompile(`(?is)^create\s+(?:or\s+replace\s+)?(?:\S+\s+){0,6}?(?:materialized\s+)?view\s+(?:if\s+not\s+exists\s+)?` + schemaName)},
+	{"triggers", regexp.MustCompile(`(?is)^create\s+(?:or\s+replace\s+)?(?:\S+\s+){0,2}?trigger\s+(?:if\s+not\s+exists\s+)?` + schemaName)},
+	{"functions", regexp.MustCompile(`(?is)^create\s+(?:or\s+replace\s+)?(?:definer\s*=\s*\S+\s+)?function\s+(?:if\s+not\s+exists\s+)?` + schemaName)},
+	{"procedures", regexp.MustCompile(`(?is)^create\s+(?:or\s+replace\s+)?(?:definer\s*=\s*\S+\s+)?procedure\s+(?:if\s+not\s+exists\s+)?` + schemaName)},
+	{"", regexp.MustCompile(`(?is)^create\s+(sequence|type|domain|extension|schema)\s+(?:if\s+not\s+exists\s+)?` + schemaName)},
+	{"", regexp.MustCompile(`(?is)^alter\s+(table|sequence|view|materialized\s+view|function|procedure|type|domain|extension|schema)\s+(?:if\s+exists\s+)?(?:only\s+)?` + schemaName)},
+	{"", regexp.MustCompile(`(?is)^comment\s+on\s+(table|column|sequence|view|materialized\s+view|function|procedure|type|domain|extension|schema)\s+` + schemaName)},
+}
+
+// schemaKindDirs maps the kind of object matched by a pattern to its directory
+var schemaKindDirs = map[string]string{
+	"column":            "tables",
+	"domain":            "types",
+	"extension":         "extensions",
+	"function":          "functions",
+	"materialized view": "views",
+	"procedure":         "procedures",
+	"schema":            "schemas",
+	"sequence":          "sequences",
+	"table":             "tables",
+	"type":              "types",
+	"view":              "views",
+}
+
+// executableCommentRegexp matches mysql executable comments, e.g. /*!50001 CREATE VIEW */
+var executableCommentRegexp = regexp.MustCompile(`(?s)/\*!\d*\s*(.*?)\*/`)
+
+var identifierRegexp = regexp.MustCompile(schemaIdentifier)
+
+var unsafeFileNameRegexp = regexp.MustCompile(`[^\w.$-]+`)
+
+// schemaLayout is a schema dump split into one file per object
+type schemaLayout struct {
+	// files maps each slash separated path to its statements
+	files map[string][]string
+	// order lists the path of each statement, in the order of the dump
+	order []string
+}
+
+// splitSchema splits a schema dump into files by object. The dbmate schema migrations
+// section is written to migrations.sql.
+func splitSchema(schema []byte, backslashEscapes bool) (*schemaLayout, error) {
+	objects, migrations, _ := strings.Cut(string(schema), schemaMigrationsMarker)
+	migrations = trimLeadingComments(migrations)
+
+	layout := &schemaLayout{files: map[string][]string{}}
+	add := func(file string) statementFunc {
+		return func(stmt string, _ int) error {
+			name := file
+			if name == "" {
+				name = schemaObjectFile(stmt)
+			}
+			layout.files[name] = append(layout.files[name], stmt)
+			layout.order = append(layout.order, name)
+			return nil
+		}
+	}
+
+	if err := splitSchemaStatements(objects, backslashEscapes, add("")); err != nil {
+		return nil, err
+	}
+	if err := splitSchemaStatements(migrations, backslashEscapes, add(schemaMigrationsFile)); err != nil {
+		return nil, err
+	}
+
+	return layout, nil
+}
+
+// splitSchemaStatements calls fn with each statement in text. Unlike migrations, a
+// statement consisting of a mysql executable comment is not discarded.
+func splitSchemaStatements(text string, backslashEscapes bool, fn statementFunc) error {
+	splitter := statementSplitter{backslashEscapes: backslashEscapes, executableComments: true}
+	if err := splitter.feed(text, 1, fn); err != nil {
+		return err
+	}
+
+	return splitter.flush(fn)
+}
+
+// SplitSchema splits the text of a schema file into statements, for tools which read
+// schema files. Set backslashEscapes for mysql, where a backslash escapes the next
+// character of a string.
+func SplitSchema(text string, backslashEscapes bool) ([]string, error) {
+	stmts := []string{}
+	err := splitSchemaStatements(text, backslashEscapes, func(stmt string, _ int) error {
+		stmts = append(stmts, stmt)
+		return nil
+	})
+
+	return stmts, err
+}
+
+// schemaObjectFile returns the file a statement belongs in
+func schemaObjectFile(stmt string) string {
+	text := trimLeadingComments(executableCommentRegexp.ReplaceAllString(stmt, "$1 "))
+
+	for _, p := range schemaObjectPatterns {
+		match := p.pattern.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+
+		dir := p.dir
+		if dir == "" {
+			kind := strings.ToLower(strings.Join(strings.Fields(match[1]), " "))
+			dir = schemaKindDirs[kind]
+		}
+		parts := identifierParts(match[len(match)-1])
+		if len(match) == 3 && strings.EqualFold(match[1], "column") {
+			// comments on columns belong to the table
+			parts = parts[:len(parts)-1]
+		}
+		if len(parts) == 0 {
+			break
+		}
+
+		return path.Join(dir, schemaFileName(strings.Join(parts, "."))+".sql")
+	}
+
+	return schemaOtherFile
+}
+
+// identifierParts splits a qualified identifier into its unquoted parts
+func identifierParts(name string) []string {
+	parts := []string{}
+	for _, part := range identifierRegexp.FindAllString(name, -1) {
+		parts = append(parts, strings.Trim(part, "\"`[]"))
+	}
+
+	return parts
+}
+
+// schemaFileName replaces characters which are unsafe in file names
+func schemaFileName(name string) string {
+	return strings.Trim(unsafeFileNameRegexp.ReplaceAllString(name, "_"), ".")
+}
+
+// statements returns the statements of the layout, in the order they must be executed
+func (l *schemaLayout) statements() []string {
+	next := map[string]int{}
+	stmts := make([]string, 0, len(l.order))
+	for _, file := range l.order {
+		stmts = append(stmts, l.files[file][next[file]])
+		next[file]++
+	}
+
+	return stmts
+}
+
+// write replaces the contents of dir with the layout. Files which are not part of the
+// layout (such as those of dropped objects) are removed.
+func (l *schemaLayout) write(dir string) error {
+	if err := removeSchemaDir(dir); err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(l.files))
+	for file := range l.files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		name := filepath.Join(dir, filepath.FromSlash(file))
+		if err := ensureDir(filepath.Dir(name)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, []byte(joinStatements(l.files[file])), 0o644); err != nil {
+			return err
+		}
+	}
+
+	order := strings.Join(l.order, "\n") + "\n"
+	return os.WriteFile(filepath.Join(dir, schemaLoadOrderFile), []byte(order), 0o644)
+}
+
+// removeSchemaDir removes the .sql files and load order written to dir by a previous
+// dump, along with any directories left empty
+func removeSchemaDir(dir string) error {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	dirs := []string{}
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != dir {
+				dirs = append(dirs, name)
+			}
+			return nil
+		}
+		if filepath.Ext(name) == ".sql" || name == filepath.Join(dir, schemaLoadOrderFile) {
+			return os.Remove(name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// remove nested directories first, ignoring those which still contain other files
+	for i := len(dirs) - 1; i >= 0; i-- {
+		_ = os.Remove(dirs[i])
+	}
+
+	return nil
+}
+
+// readSchemaDir reads a layout written by a previous dump
+func readSchemaDir(dir string, backslashEscapes bool) (*schemaLayout, error) {
+	order, err := os.ReadFile(filepath.Join(dir, schemaLoadOrderFile))
+	if err != nil {
+		return nil, err
+	}
+
+	layout := &schemaLayout{files: map[string][]string{}}
+	for _, file := range strings.Split(string(order), "\n") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		layout.order = append(layout.order, file)
+
+		if _, ok := layout.files[file]; ok {
+			continue
+		}
+		if !fs.ValidPath(file) {
+			return nil, fmt.Errorf("%w: invalid path in %s: %s", ErrInvalidSchemaDirectory, schemaLoadOrderFile, file)
+		}
+		contents, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		layout.files[file] = []string{}
+		err = splitSchemaStatements(string(contents), backslashEscapes, func(stmt string, _ int) error {
+			layout.files[file] = append(layout.files[file], stmt)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// every statement must be listed exactly once
+	counts := map[string]int{}
+	for _, file := range layout.order {
+		counts[file]++
+	}
+	for file, stmts := range layout.files {
+		if counts[file] != len(stmts) {
+			return nil, fmt.Errorf("%w: %s contains %d statements, but is listed %d times in %s",
+				ErrInvalidSchemaDirectory, file, len(stmts), counts[file], schemaLoadOrderFile)
+		}
+	}
+
+	return layout, nil
+}
+
+// joinStatements formats statements as SQL text
+func joinStatements(stmts []string) string {
+	var b strings.Builder
+	for i, stmt := range stmts {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(stmt)
+		b.WriteString(";\n")
+	}
+
+	return b.String()
+}
+
+// schemaDirectory returns true if the schema is written to a directory, rather than a
+// single file
+func (db *DB) schemaDirectory() (bool, error) {
+	switch db.SchemaFormat {
+	case "", SchemaFormatFile:
+		return false, nil
+	case SchemaFormatDirectory:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: %s (expected %s or %s)",
+			ErrInvalidSchemaFormat, db.SchemaFormat, SchemaFormatFile, SchemaFormatDirectory)
+	}
+}
+
+// writeSchema writes a schema dump to the schema file, or directory
+func (db *DB) writeSchema(drv Driver, schema []byte) error {
+	directory, err := db.schemaDirectory()
+	if err != nil {
+		return err
+	}
+
+	db.logger().Infof("Writing: %s", db.SchemaFile)
+
+	if !directory {
+		// ensure schema directory exists
+		if err = ensureDir(filepath.Dir(db.SchemaFile)); err != nil {
+			return err
+		}
+
+		// write schema to file
+		return os.WriteFile(db.SchemaFile, schema, 0o644)
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	layout, err := splitSchema(schema, backslashEscapes)
+	if err != nil {
+		return err
+	}
+	if err = ensureDir(db.SchemaFile); err != nil {
+		return err
+	}
+
+	return layout.write(db.SchemaFile)
+}
+
+// readSchema returns the statements of the schema file, or directory
+func (db *DB) readSchema(drv Driver) ([]string, error) {
+	directory, err := db.schemaDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	if !directory {
+		schema, err := os.ReadFile(db.SchemaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		stmts := []string{}
+		err = splitSchemaStatements(string(schema), backslashEscapes, func(stmt string, _ int) error {
+			stmts = append(stmts, stmt)
+			return nil
+		})
+
+		return stmts, err
+	}
+
+	layout, err := readSchemaDir(db.SchemaFile, backslashEscapes)
+	if err != nil {
+		return nil, err
+	}
+
+	return layout.statements(), nil
+}
+
+// LoadSchema loads the schema file (or directory) into the current database, which is
+// faster than applying each migration when setting up a new database, for example in
+// a test harness. Statements are executed in order on a single connection. If the schema
+// migrations section is written to a separate file, it is loaded after the schema.
+func (db *DB) LoadSchema() (err error) {
+	defer db.emitError(&err)
+
+	drv, err := db.Driver()
+	if err != nil {
+		return err
+	}
+
+	mode, err := db.schemaMigrations()
+	if err != nil {
+		return err
+	}
+
+	db.logger().Infof("Reading: %s", db.SchemaFile)
+	stmts, err := db.readSchema(drv)
+	if err != nil {
+		return err
+	}
+	if mode == SchemaMigrationsSeparate {
+		migrations, err := db.readSchemaMigrations(drv)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, migrations...)
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	return db.loadStatements(drv, sqlDB, stmts)
+}
+
+// loadStatements executes schema statements in order on a single connection
+func (db *DB) loadStatements(drv Driver, sqlDB *sql.DB, stmts []string) error {
+	conn, err := sqlDB.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx := connTransaction{conn}
+
+	for _, stmt := range stmts {
+		if _, err := db.exec(tx, trimTrailingComment(stmt)); err != nil {
+			return drv.QueryError(stmt, err)
+		}
+	}
+
+	return nil
+}
+
+// expectedSchema returns the contents of the schema file, excluding its header. For the
+// directory format, this is the statements of every file in load order, for comparison
+// with the output of normalizeSchema.
+func (db *DB) expectedSchema(drv Driver) ([]byte, error) {
+	directory, err := db.schemaDirectory()
+	if err != nil {
+		return nil, err
+	}
+	if !directory {
+		schema, err := os.ReadFile(db.SchemaFile)
+		return stripSchemaHeader(schema), err
+	}
+
+	stmts, err := db.readSchema(drv)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(joinStatements(stmts)), nil
+}
+
+// normalizeSchema formats a schema dump in the same way as expectedSchema
+func (db *DB) normalizeSchema(drv Driver, schema []byte) ([]byte, error) {
+	directory, err := db.schemaDirectory()
+	if err != nil || !directory {
+		return schema, err
+	}
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	layout, err := splitSchema(schema, backslashEscapes)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(joinStatements(layout.statements())), nil
+}
+
+// trimTrailingComment removes a block comment from the end of a statement, such as the
+// column names which sqlite appends to views. Otherwise the comment would be stored
+// with the view, and appear twice in the next dump.
+func trimTrailingComment(stmt string) string {
+	stmt = strings.TrimSpace(stmt)
+	if !strings.HasSuffix(stmt, "*/") {
+		return stmt
+	}
+
+	i := strings.LastIndex(stmt, "/*")
+	if i <= 0 || strings.HasPrefix(stmt[i:], "/*!") || strings.Contains(stmt[i+2:len(stmt)-2], "*/") {
+		return stmt
+	}
+
+	return strings.TrimSpace(stmt[:i])
+}
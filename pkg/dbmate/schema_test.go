@@ -0,0 +1,176 @@
+package dbmate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const postgresSchemaDump = `SET statement_timeout = 0;
+SELECT pg_catalog.set_config('search_path', '', false);
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.users (
+    id integer NOT NULL,
+    name text DEFAULT 'a;b'::text
+);
+
+CREATE FUNCTION public.touch() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$ BEGIN NEW.name := 'x'; RETURN NEW; END; $$;
+
+CREATE SEQUENCE public.users_id_seq AS integer START WITH 1;
+
+ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;
+
+CREATE VIEW public.user_names AS
+ SELECT users.name FROM public.users;
+
+ALTER TABLE ONLY public.users ALTER COLUMN id SET DEFAULT nextval('public.users_id_seq'::regclass);
+
+CREATE UNIQUE INDEX users_name ON public.users USING btree (name);
+
+COMMENT ON COLUMN public."users".name IS 'display name';
+
+CREATE TRIGGER users_touch BEFORE UPDATE ON public.users FOR EACH ROW EXECUTE FUNCTION public.touch();
+
+--
+-- Dbmate schema migrations
+--
+
+INSERT INTO public.schema_migrations (version) VALUES
+    ('20200101000000');
+`
+
+func TestSplitSchema(t *testing.T) {
+	layout, err := splitSchema([]byte(postgresSchemaDump), false)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"other.sql",
+		"other.sql",
+		"tables/public.users.sql",
+		"functions/public.touch.sql",
+		"sequences/public.users_id_seq.sql",
+		"sequences/public.users_id_seq.sql",
+		"views/public.user_names.sql",
+		"tables/public.users.sql",
+		"tables/public.users.sql",
+		"tables/public.users.sql",
+		"triggers/users_touch.sql",
+		"migrations.sql",
+	}, layout.order)
+	require.Equal(t, "INSERT INTO public.schema_migrations (version) VALUES\n    ('20200101000000')",
+		layout.files["migrations.sql"][0])
+	require.Equal(t, "CREATE FUNCTION public.touch() RETURNS trigger\n    LANGUAGE plpgsql\n"+
+		"    AS $$ BEGIN NEW.name := 'x'; RETURN NEW; END; $$", layout.files["functions/public.touch.sql"][0])
+}
+
+func TestSplitSchemaMySQL(t *testing.T) {
+	dump := "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;\n" +
+		"CREATE TABLE `users` (\n  `id` int NOT NULL,\n  `name` varchar(255) DEFAULT 'it\\'s'\n);\n" +
+		"/*!50001 DROP VIEW IF EXISTS `user_names`*/;\n" +
+		"/*!50001 CREATE ALGORITHM=UNDEFINED */\n/*!50013 DEFINER=`root`@`%` SQL SECURITY DEFINER */\n" +
+		"/*!50001 VIEW `user_names` AS select `users`.`name` AS `name` from `users` */;\n" +
+		"\n--\n-- Dbmate schema migrations\n--\n\n" +
+		"LOCK TABLES `schema_migrations` WRITE;\nUNLOCK TABLES;\n"
+
+	layout, err := splitSchema([]byte(dump), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"other.sql",
+		"tables/users.sql",
+		"other.sql",
+		"views/user_names.sql",
+		"migrations.sql",
+		"migrations.sql",
+	}, layout.order)
+
+	// routines are dumped between DELIMITER commands
+	layout, err = splitSchema([]byte("DELIMITER ;;\nCREATE PROCEDURE p() BEGIN SELECT 1; END ;;\nDELIMITER ;\n"), true)
+	require.NoError(t, err)
+	require.Equal(t, []string{"procedures/p.sql"}, layout.order)
+	require.Equal(t, []string{"CREATE PROCEDURE p() BEGIN SELECT 1; END"}, layout.files["procedures/p.sql"])
+}
+
+func TestSplitSchemaSQLiteTrigger(t *testing.T) {
+	dump := "CREATE TABLE a (id int);\n" +
+		"CREATE TRIGGER a_insert AFTER INSERT ON a BEGIN\n" +
+		"  UPDATE a SET id = CASE WHEN id > 1 THEN 1 ELSE 2 END;\n  DELETE FROM a;\nEND;\n"
+
+	layout, err := splitSchema([]byte(dump), false)
+	require.NoError(t, err)
+	require.Equal(t, []string{"tables/a.sql", "triggers/a_insert.sql"}, layout.order)
+	require.Equal(t, "CREATE TRIGGER a_insert AFTER INSERT ON a BEGIN\n"+
+		"  UPDATE a SET id = CASE WHEN id > 1 THEN 1 ELSE 2 END;\n  DELETE FROM a;\nEND",
+		layout.files["triggers/a_insert.sql"][0])
+}
+
+func TestCutSchemaMigrations(t *testing.T) {
+	objects, migrations := cutSchemaMigrations([]byte(postgresSchemaDump))
+	require.True(t, strings.HasSuffix(string(objects),
+		"EXECUTE FUNCTION public.touch();\n"), string(objects))
+	require.Equal(t, "--\n-- Dbmate schema migrations\n--\n\n"+
+		"INSERT INTO public.schema_migrations (version) VALUES\n    ('20200101000000');\n", string(migrations))
+
+	sqliteDump := "CREATE TABLE users (id integer);\n-- Dbmate schema migrations\n" +
+		"INSERT INTO \"schema_migrations\" (version) VALUES\n  ('1');\n"
+	objects, migrations = cutSchemaMigrations([]byte(sqliteDump))
+	require.Equal(t, "CREATE TABLE users (id integer);\n", string(objects))
+	require.Equal(t, "-- Dbmate schema migrations\n"+
+		"INSERT INTO \"schema_migrations\" (version) VALUES\n  ('1');\n", string(migrations))
+
+	objects, migrations = cutSchemaMigrations([]byte("CREATE TABLE users (id integer);\n"))
+	require.Equal(t, "CREATE TABLE users (id integer);\n", string(objects))
+	require.Empty(t, migrations)
+}
+
+func TestSchemaLayoutWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	// files of objects which no longer exist are removed, other files are kept
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "views"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "views", "old.sql"), []byte("CREATE VIEW old;\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("schema\n"), 0o644))
+
+	layout, err := splitSchema([]byte(postgresSchemaDump), false)
+	require.NoError(t, err)
+	require.NoError(t, layout.write(dir))
+
+	require.NoFileExists(t, filepath.Join(dir, "views", "old.sql"))
+	require.FileExists(t, filepath.Join(dir, "README.md"))
+	contents, err := os.ReadFile(filepath.Join(dir, "sequences", "public.users_id_seq.sql"))
+	require.NoError(t, err)
+	require.Equal(t, "CREATE SEQUENCE public.users_id_seq AS integer START WITH 1;\n\n"+
+		"ALTER SEQUENCE public.users_id_seq OWNED BY public.users.id;\n", string(contents))
+
+	// reading the directory returns the statements in their original order
+	read, err := readSchemaDir(dir, false)
+	require.NoError(t, err)
+	require.Equal(t, layout.statements(), read.statements())
+
+	// statements added by hand must also be added to the load order
+	f, err := os.OpenFile(filepath.Join(dir, "migrations.sql"), os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString("INSERT INTO schema_migrations (version) VALUES ('1');\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = readSchemaDir(dir, false)
+	require.ErrorIs(t, err, ErrInvalidSchemaDirectory)
+	require.EqualError(t, err, "invalid schema directory: migrations.sql contains 2 statements, "+
+		"but is listed 1 times in load_order.txt")
+}
+
+func TestTrimTrailingComment(t *testing.T) {
+	require.Equal(t, "CREATE VIEW v AS SELECT 1", trimTrailingComment("CREATE VIEW v AS SELECT 1\n/* v(a) */"))
+	require.Equal(t, "SELECT 1", trimTrailingComment("SELECT 1"))
+	require.Equal(t, "/*!50001 DROP VIEW v*/", trimTrailingComment("/*!50001 DROP VIEW v*/"))
+	require.Equal(t, "/* a */", trimTrailingComment("/* a */"))
+}
@@ -0,0 +1,100 @@
+package dbmate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Schema migrations modes
+const (
+	// SchemaMigrationsInclude writes the dbmate schema migrations section at the end of
+	// the schema file
+	SchemaMigrationsInclude = "include"
+	// SchemaMigrationsOmit leaves the dbmate schema migrations section out of the schema
+	// file, so that it only contains DDL
+	SchemaMigrationsOmit = "omit"
+	// SchemaMigrationsSeparate writes the dbmate schema migrations section to
+	// DB.SchemaMigrationsFile instead of the schema file
+	SchemaMigrationsSeparate = "separate"
+)
+
+// ErrInvalidSchemaMigrations is returned when DB.SchemaMigrations is not a valid mode
+var ErrInvalidSchemaMigrations = errors.New("invalid schema migrations mode")
+
+// schemaMigrations returns the mode used to write the dbmate schema migrations section
+func (db *DB) schemaMigrations() (string, error) {
+	switch db.SchemaMigrations {
+	case "", SchemaMigrationsInclude:
+		return SchemaMigrationsInclude, nil
+	case SchemaMigrationsOmit, SchemaMigrationsSeparate:
+		return db.SchemaMigrations, nil
+	default:
+		return "", fmt.Errorf("%w: %s (expected %s, %s, or %s)", ErrInvalidSchemaMigrations,
+			db.SchemaMigrations, SchemaMigrationsInclude, SchemaMigrationsOmit, SchemaMigrationsSeparate)
+	}
+}
+
+// cutSchemaMigrations splits a schema dump before the dbmate schema migrations section,
+// including the comment lines around its heading. If the dump has no such section,
+// migrations is empty.
+func cutSchemaMigrations(schema []byte) (objects, migrations []byte) {
+	i := bytes.Index(schema, []byte(schemaMigrationsMarker))
+	if i < 0 {
+		return schema, nil
+	}
+
+	// postgres and mysql surround the heading with "--" lines
+	start := i
+	for bytes.HasSuffix(schema[:start], []byte("\n--\n")) {
+		start -= len("--\n")
+	}
+
+	objects = bytes.TrimRight(schema[:start], "\n")
+	if len(objects) > 0 {
+		objects = append(objects[:len(objects):len(objects)], '\n')
+	}
+
+	return objects, schema[start:]
+}
+
+// writeSchemaMigrations writes the dbmate schema migrations section to
+// db.SchemaMigrationsFile
+func (db *DB) writeSchemaMigrations(migrations []byte) error {
+	db.logger().Infof("Writing: %s", db.SchemaMigrationsFile)
+
+	if err := ensureDir(filepath.Dir(db.SchemaMigrationsFile)); err != nil {
+		return err
+	}
+
+	return os.WriteFile(db.SchemaMigrationsFile, migrations, 0o644)
+}
+
+// readSchemaMigrations returns the statements of db.SchemaMigrationsFile, or nothing if
+// it does not exist
+func (db *DB) readSchemaMigrations(drv Driver) ([]string, error) {
+	migrations, err := os.ReadFile(db.SchemaMigrationsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db.logger().Infof("Reading: %s", db.SchemaMigrationsFile)
+
+	backslashEscapes := false
+	if escaper, ok := drv.(backslashEscaper); ok {
+		backslashEscapes = escaper.BackslashEscapes()
+	}
+
+	stmts := []string{}
+	err = splitSchemaStatements(string(migrations), backslashEscapes, func(stmt string, _ int) error {
+		stmts = append(stmts, stmt)
+		return nil
+	})
+
+	return stmts, err
+}
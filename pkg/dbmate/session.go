@@ -0,0 +1,28 @@
+package dbmate
+
+import (
+	"errors"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+var (
+	// ErrSessionSetup is returned if a session setup statement fails
+	ErrSessionSetup = dbutil.ErrSessionSetup
+	// ErrSessionSetupUnsupported is returned if session setup statements are given for a
+	// driver which can't execute them on each connection it opens
+	ErrSessionSetupUnsupported = errors.New("session setup is only supported by postgres, redshift, mysql, and sqlite")
+)
+
+// sessionSetup returns the statements executed on each connection: DB.SessionSetup,
+// followed by the session_setup URL parameters
+func (db *DB) sessionSetup(params connectionParams) []string {
+	return append(append([]string{}, db.SessionSetup...), params.sessionSetup...)
+}
+
+// supportsSessionSetup checks whether the driver executes DriverConfig.SessionSetup on
+// each connection it opens
+func supportsSessionSetup(drv Driver) bool {
+	ss, ok := drv.(sessionSetupSupporter)
+	return ok && ss.SessionSetup()
+}
@@ -0,0 +1,277 @@
+package dbmate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Error codes
+var (
+	ErrInvalidShardRange  = errors.New("invalid shard range")
+	ErrShardsUnsupported  = errors.New("this command does not support shard ranges or multiple databases, only migrate and up do")
+	ErrShardsFailed       = errors.New("failed to migrate shards")
+	ErrShardsInconsistent = errors.New("shard schemas differ")
+)
+
+// shardRangeRegexp matches a shard range in a database URL, e.g. {0..15}, which may have
+// been escaped when the URL was parsed
+var shardRangeRegexp = regexp.MustCompile(`(?i)(?:\{|%7B)(\d+)\.\.(\d+)(?:\}|%7D)`)
+
+// ShardURLs expands a database URL containing a shard range, such as
+// postgres://host/db_{0..15}, into the URL of each shard in order. If the first number of
+// the range has leading zeros (e.g. {00..15}), shard numbers are padded to the same width.
+// A URL without a shard range is returned unchanged.
+func ShardURLs(u *url.URL) ([]*url.URL, error) {
+	s := u.String()
+	matches := shardRangeRegexp.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return []*url.URL{u}, nil
+	}
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("%w: only one range is supported", ErrInvalidShardRange)
+	}
+
+	match := matches[0]
+	first, last := s[match[2]:match[3]], s[match[4]:match[5]]
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidShardRange, first)
+	}
+	end, err := strconv.Atoi(last)
+	if err != nil || end < start {
+		return nil, fmt.Errorf("%w: %s..%s", ErrInvalidShardRange, first, last)
+	}
+
+	width := 0
+	if len(first) > 1 && strings.HasPrefix(first, "0") {
+		width = len(first)
+	}
+
+	urls := []*url.URL{}
+	for n := start; n <= end; n++ {
+		shard, err := url.Parse(s[:match[0]] + fmt.Sprintf("%0*d", width, n) + s[match[1]:])
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, shard)
+	}
+
+	return urls, nil
+}
+
+// sharded determines whether the database URL contains a shard range, or additional
+// database URLs are set
+func (db *DB) sharded() bool {
+	return len(db.AdditionalURLs) > 0 ||
+		db.DatabaseURL != nil && shardRangeRegexp.MatchString(db.DatabaseURL.String())
+}
+
+// shards returns a copy of db for each shard of the database URL and additional URLs
+func (db *DB) shards() ([]*DB, error) {
+	if db.DatabaseURL == nil {
+		return nil, ErrInvalidURL
+	}
+	// every shard would otherwise be migrated through the same connection
+	if db.Connection != nil {
+		return nil, fmt.Errorf("%w, and only without an existing Connection", ErrShardsUnsupported)
+	}
+
+	urls := []*url.URL{}
+	for _, u := range append([]*url.URL{db.DatabaseURL}, db.AdditionalURLs...) {
+		expanded, err := ShardURLs(u)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, expanded...)
+	}
+
+	shards := []*DB{}
+	for _, u := range urls {
+		shard := *db
+		shard.DatabaseURL = u
+		shard.AdditionalURLs = nil
+		// the schema file is written once every shard has been migrated
+		shard.AutoDumpSchema = false
+		shards = append(shards, &shard)
+	}
+
+	return shards, nil
+}
+
+// migrateShards calls migrate for each shard, db.Parallel at a time, continuing after a
+// shard fails so that every failure is reported. Once every shard has been migrated,
+// their schemas are compared, and the schema file is written from the first shard.
+func (db *DB) migrateShards(migrate func(shard *DB) error) (err error) {
+	unlock, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock(&err)
+
+	shards, err := db.shards()
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(shards))
+	mu := &sync.Mutex{}
+	run := func(i int, shard *DB) {
+		errs[i] = migrate(shard)
+		if errs[i] != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			db.logger().Errorf("Shard %s failed: %s", shardName(shard), errs[i])
+		}
+	}
+
+	if db.Parallel > 1 && len(shards) > 1 {
+		db.logger().Infof("Migrating %d shards, %d at a time", len(shards), db.Parallel)
+		db.synchronizeShards(shards, mu)
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, db.Parallel)
+		for i, shard := range shards {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, shard *DB) {
+				defer wg.Done()
+				run(i, shard)
+				<-sem
+			}(i, shard)
+		}
+		wg.Wait()
+	} else {
+		for i, shard := range shards {
+			db.logger().Infof("Shard %d of %d: %s", i+1, len(shards), shardName(shard))
+			run(i, shard)
+		}
+	}
+
+	failed := []string{}
+	var firstErr error
+	for i, shard := range shards {
+		if errs[i] != nil {
+			failed = append(failed, shardName(shard))
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %d of %d shards failed (%s), the first with: %w",
+			ErrShardsFailed, len(failed), len(shards), strings.Join(failed, ", "), firstErr)
+	}
+
+	if err := db.verifyShards(shards); err != nil {
+		return err
+	}
+
+	if db.AutoDumpSchema {
+		return shards[0].DumpSchema()
+	}
+
+	return nil
+}
+
+// synchronizeShards prepares shards to be migrated concurrently: messages are prefixed
+// with the shard they come from, and messages and events are passed on one at a time
+func (db *DB) synchronizeShards(shards []*DB, mu *sync.Mutex) {
+	logger := db.logger()
+	for _, shard := range shards {
+		shard.Logger = prefixLogger{l: logger, prefix: shardName(shard) + ": ", mu: mu}
+		if handler := db.EventHandler; handler != nil {
+			shard.EventHandler = func(e Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				handler(e)
+			}
+		}
+	}
+}
+
+// verifyShards compares the schema of each shard with the first shard, returning an
+// error listing the shards which differ
+func (db *DB) verifyShards(shards []*DB) error {
+	db.logger().Infof("Comparing schemas of %d shards", len(shards))
+
+	var expected []byte
+	differ := []string{}
+	for i, shard := range shards {
+		schema, err := shard.shardSchema()
+		if err != nil {
+			return fmt.Errorf("unable to dump shard %s: %w", shardName(shard), err)
+		}
+
+		if i == 0 {
+			expected = schema
+		} else if !bytes.Equal(schema, expected) {
+			differ = append(differ, shardName(shard))
+		}
+	}
+
+	if len(differ) > 0 {
+		return fmt.Errorf("%w: %s differ from %s", ErrShardsInconsistent,
+			strings.Join(differ, ", "), shardName(shards[0]))
+	}
+
+	return nil
+}
+
+// shardSchema dumps the schema of a shard
+func (db *DB) shardSchema() ([]byte, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	return db.dumpSchema(drv, sqlDB)
+}
+
+// shardName identifies a shard in messages by its URL, without the password
+func shardName(shard *DB) string {
+	return shard.DatabaseURL.Redacted()
+}
+
+// prefixLogger adds a prefix to messages, and passes them to a logger one at a time
+type prefixLogger struct {
+	l      Logger
+	prefix string
+	mu     *sync.Mutex
+}
+
+func (l prefixLogger) Debugf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.l.Debugf("%s%s", l.prefix, fmt.Sprintf(format, args...))
+}
+
+func (l prefixLogger) Infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.l.Infof("%s%s", l.prefix, fmt.Sprintf(format, args...))
+}
+
+func (l prefixLogger) Warnf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.l.Warnf("%s%s", l.prefix, fmt.Sprintf(format, args...))
+}
+
+func (l prefixLogger) Errorf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.l.Errorf("%s%s", l.prefix, fmt.Sprintf(format, args...))
+}
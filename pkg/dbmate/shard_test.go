@@ -0,0 +1,55 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func TestShardURLs(t *testing.T) {
+	cases := []struct {
+		url      string
+		expected []string
+	}{
+		{"postgres://localhost/db_{0..2}", []string{
+			"postgres://localhost/db_0",
+			"postgres://localhost/db_1",
+			"postgres://localhost/db_2",
+		}},
+		{"postgres://localhost/db_{08..10}?sslmode=disable", []string{
+			"postgres://localhost/db_08?sslmode=disable",
+			"postgres://localhost/db_09?sslmode=disable",
+			"postgres://localhost/db_10?sslmode=disable",
+		}},
+		{"sqlite:/tmp/shard_{3..3}.sqlite3", []string{
+			"sqlite:/tmp/shard_3.sqlite3",
+		}},
+		{"postgres://localhost/db", []string{
+			"postgres://localhost/db",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			urls, err := ShardURLs(dbutil.MustParseURL(c.url))
+			require.NoError(t, err)
+			actual := []string{}
+			for _, u := range urls {
+				actual = append(actual, u.String())
+			}
+			require.Equal(t, c.expected, actual)
+		})
+	}
+
+	for _, invalid := range []string{
+		"postgres://localhost/db_{2..1}",
+		"postgres://localhost/db_{0..1}_{0..1}",
+	} {
+		t.Run(invalid, func(t *testing.T) {
+			_, err := ShardURLs(dbutil.MustParseURL(invalid))
+			require.ErrorIs(t, err, ErrInvalidShardRange)
+		})
+	}
+}
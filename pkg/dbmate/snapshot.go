@@ -0,0 +1,70 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Error codes
+var (
+	ErrSnapshotUnsupported = errors.New("snapshots are not supported by this driver")
+	ErrSnapshotNotFound    = errors.New("snapshot not found")
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+)
+
+// snapshotNameRegexp matches snapshot names, which are used in database and file names
+var snapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// snapshotter is implemented by drivers which can save a copy of the database under a
+// name, and later replace the database with that copy. RestoreSnapshot returns
+// ErrSnapshotNotFound if no snapshot with the name was saved.
+type snapshotter interface {
+	SaveSnapshot(name string) error
+	RestoreSnapshot(name string) error
+}
+
+// SaveSnapshot saves a copy of the database as a named snapshot, replacing any snapshot
+// with the same name. This is much faster than migrating a new database, so a test
+// suite can save a snapshot of a migrated database and restore it between tests.
+func (db *DB) SaveSnapshot(name string) error {
+	s, err := db.snapshotter(name)
+	if err != nil {
+		return err
+	}
+
+	db.logger().Infof("Saving snapshot: %s", name)
+
+	return s.SaveSnapshot(name)
+}
+
+// RestoreSnapshot replaces the database with a snapshot saved by SaveSnapshot
+func (db *DB) RestoreSnapshot(name string) error {
+	s, err := db.snapshotter(name)
+	if err != nil {
+		return err
+	}
+
+	db.logger().Infof("Restoring snapshot: %s", name)
+
+	return s.RestoreSnapshot(name)
+}
+
+// snapshotter validates a snapshot name, and returns the driver if it supports snapshots
+func (db *DB) snapshotter(name string) (snapshotter, error) {
+	if !snapshotNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q (use letters, digits, and underscores)", ErrInvalidSnapshotName, name)
+	}
+
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := drv.(snapshotter)
+	if !ok {
+		return nil, ErrSnapshotUnsupported
+	}
+
+	return s, nil
+}
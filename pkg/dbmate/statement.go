@@ -0,0 +1,380 @@
+package dbmate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type splitterState int
+
+const (
+	stateNormal splitterState = iota
+	stateSingleQuote
+	stateDoubleQuote
+	stateBacktick
+	stateLineComment
+	stateBlockComment
+	stateDollarQuote
+	stateCopyData
+)
+
+// statementSplitter splits SQL text into individual statements. Semicolons inside
+// quoted strings, quoted identifiers, comments, postgres dollar-quoted strings, and the
+// BEGIN ... END body of a trigger, procedure, function, or event do not terminate a
+// statement. The rows of data following a COPY ... FROM STDIN statement, up to a line
+// containing only \., are passed to the statementFunc along with the statement (see
+// joinCopyData). As with the mysql client, a DELIMITER line between statements (e.g.
+// DELIMITER ;; in mysqldump output) changes the terminator, until DELIMITER ; restores
+// it, and is not passed to the statementFunc.
+type statementSplitter struct {
+	// backslashEscapes treats backslash as an escape character in quoted strings (mysql),
+	// otherwise backslash escapes are only recognized in E'...' strings (postgres)
+	backslashEscapes bool
+	// executableComments treats mysql executable comments (/*! ... */) as statement
+	// content, rather than ignoring statements which consist only of comments
+	executableComments bool
+
+	// explicit is set once a block starts with a -- migrate:statement directive, after
+	// which statements are only separated by directives
+	explicit bool
+
+	state   splitterState
+	escapes bool
+	// delimiter terminates statements instead of semicolons, if set by a DELIMITER line
+	delimiter  string
+	dollarTag  string
+	buf        strings.Builder
+	hasContent bool
+	startLine  int
+
+	// data of the current COPY ... FROM STDIN statement
+	copyStmt string
+	copyData strings.Builder
+
+	// keywords of the current statement, used to find the end of routine bodies
+	words    int
+	create   bool
+	routine  bool
+	depth    int
+	afterEnd bool
+}
+
+// statementFunc is called for each complete statement, with the line it starts on
+type statementFunc func(stmt string, line int) error
+
+// feed adds a line of SQL text, calling fn for each statement it completes
+func (s *statementSplitter) feed(text string, line int, fn statementFunc) error {
+	if s.state == stateCopyData {
+		return s.feedCopyData(text, line, fn)
+	}
+	if s.explicit {
+		if !isEmptyLine(text) && !isCommentLine(text) {
+			s.markContent(line)
+		}
+		s.buf.WriteString(text)
+		return nil
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch s.state {
+		case stateNormal:
+			if !s.hasContent && (i == 0 || text[i-1] == '\n') {
+				if delimiter, end, ok := delimiterCommand(text[i:]); ok {
+					s.delimiter = delimiter
+					i += end - 1
+					continue
+				}
+			}
+
+			switch {
+			case s.delimiter != "" && strings.HasPrefix(text[i:], s.delimiter):
+				if err := s.endStatement(fn); err != nil {
+					return err
+				}
+				i += len(s.delimiter) - 1
+				continue
+			case c == ';' && s.depth == 0 && s.delimiter == "":
+				if err := s.endStatement(fn); err != nil {
+					return err
+				}
+				if s.state == stateCopyData {
+					// the data starts on the next line
+					_, rest, _ := strings.Cut(text[i+1:], "\n")
+					return s.feedCopyData(rest, line+1, fn)
+				}
+				continue
+			case c == '-' && i+1 < len(text) && text[i+1] == '-':
+				s.state = stateLineComment
+			case c == '/' && i+1 < len(text) && text[i+1] == '*':
+				if s.executableComments && i+2 < len(text) && text[i+2] == '!' {
+					s.markContent(line)
+				}
+				s.state = stateBlockComment
+				s.buf.WriteString("/*")
+				i++
+				continue
+			case c == '\'':
+				s.state = stateSingleQuote
+				s.escapes = s.backslashEscapes || isEscapeStringPrefix(text, i)
+				s.markContent(line)
+			case c == '"':
+				s.state = stateDoubleQuote
+				s.markContent(line)
+			case c == '`':
+				s.state = stateBacktick
+				s.markContent(line)
+			case c == '$' && (i == 0 || !isIdentifierChar(text[i-1])):
+				s.markContent(line)
+				if tag, ok := dollarQuoteTag(text[i:]); ok {
+					s.state = stateDollarQuote
+					s.dollarTag = tag
+					s.buf.WriteString(tag)
+					i += len(tag) - 1
+					continue
+				}
+			case isLetter(c) && (i == 0 || !isIdentifierChar(text[i-1])):
+				s.markContent(line)
+				end := i + 1
+				for end < len(text) && isIdentifierChar(text[end]) {
+					end++
+				}
+				s.keyword(strings.ToUpper(text[i:end]), text[end:])
+				s.buf.WriteString(text[i:end])
+				i = end - 1
+				continue
+			case !isSpace(c):
+				s.markContent(line)
+			}
+		case stateLineComment:
+			if c == '\n' {
+				s.state = stateNormal
+			}
+		case stateBlockComment:
+			if c == '*' && i+1 < len(text) && text[i+1] == '/' {
+				s.state = stateNormal
+				s.buf.WriteString("*/")
+				i++
+				continue
+			}
+		case stateSingleQuote:
+			if c == '\\' && s.escapes && i+1 < len(text) {
+				s.buf.WriteByte(c)
+				s.buf.WriteByte(text[i+1])
+				i++
+				continue
+			}
+			if c == '\'' {
+				s.state = stateNormal
+			}
+		case stateDoubleQuote:
+			if c == '"' {
+				s.state = stateNormal
+			}
+		case stateBacktick:
+			if c == '`' {
+				s.state = stateNormal
+			}
+		case stateDollarQuote:
+			if c == '$' && strings.HasPrefix(text[i:], s.dollarTag) {
+				s.state = stateNormal
+				s.buf.WriteString(s.dollarTag)
+				i += len(s.dollarTag) - 1
+				continue
+			}
+		}
+
+		s.buf.WriteByte(c)
+	}
+
+	return nil
+}
+
+// feedCopyData adds lines of data following a COPY ... FROM STDIN statement, calling fn
+// with the statement once a \. line is reached, then splitting the rest of the text
+func (s *statementSplitter) feedCopyData(text string, line int, fn statementFunc) error {
+	for text != "" {
+		row, rest, _ := strings.Cut(text, "\n")
+		text = rest
+		if strings.TrimRight(row, "\r") == copyDataTerminator {
+			stmt := joinCopyData(s.copyStmt, s.copyData.String())
+			s.state = stateNormal
+			s.hasContent = false
+			s.copyStmt = ""
+			s.copyData.Reset()
+			if err := fn(stmt, s.startLine); err != nil {
+				return err
+			}
+
+			return s.feed(text, line+1, fn)
+		}
+
+		s.copyData.WriteString(row + "\n")
+		line++
+	}
+
+	return nil
+}
+
+// endStatement ends the current statement at a semicolon. A COPY ... FROM STDIN
+// statement is held until its data has been read.
+func (s *statementSplitter) endStatement(fn statementFunc) error {
+	if !s.hasContent || !isCopyFromStdin(s.buf.String()) {
+		return s.flush(fn)
+	}
+
+	s.copyStmt = strings.TrimSpace(s.buf.String())
+	s.state = stateCopyData
+	s.buf.Reset()
+	s.words, s.create, s.routine, s.depth, s.afterEnd = 0, false, false, 0, false
+
+	return nil
+}
+
+// flush calls fn with the current statement, unless it contains only whitespace and comments
+func (s *statementSplitter) flush(fn statementFunc) error {
+	if s.state == stateCopyData {
+		return fmt.Errorf("%w: statement starting at line %d", ErrParseCopyData, s.startLine)
+	}
+
+	stmt := strings.TrimSpace(s.buf.String())
+	hasContent := s.hasContent
+
+	s.buf.Reset()
+	s.hasContent = false
+	s.words, s.create, s.routine, s.depth, s.afterEnd = 0, false, false, 0, false
+
+	if !hasContent {
+		return nil
+	}
+
+	return fn(stmt, s.startLine)
+}
+
+// startStatement ends the current statement at a -- migrate:statement directive, and
+// switches to splitting statements only at directives
+func (s *statementSplitter) startStatement(fn statementFunc) error {
+	err := s.flush(fn)
+	s.explicit = true
+	s.state = stateNormal
+
+	return err
+}
+
+// keyword tracks the nesting of BEGIN ... END blocks in the body of a routine (a
+// trigger, procedure, function, or event), where semicolons separate the statements of
+// the body rather than ending the create statement. CASE is also closed by END, but
+// END IF, END LOOP, END WHILE, END REPEAT, and END FOR close blocks which do not start
+// with BEGIN or CASE.
+func (s *statementSplitter) keyword(word, rest string) {
+	s.words++
+	if s.afterEnd {
+		// the word following END names the block it closes, e.g. END CASE
+		s.afterEnd = false
+		return
+	}
+
+	switch {
+	case s.words == 1:
+		s.create = word == "CREATE"
+	case s.create && !s.routine &&
+		(word == "TRIGGER" || word == "PROCEDURE" || word == "FUNCTION" || word == "EVENT"):
+		s.routine = true
+	case s.routine && (word == "BEGIN" || word == "CASE"):
+		s.depth++
+	case s.routine && word == "END" && s.depth > 0:
+		next := strings.ToUpper(strings.TrimRight(strings.Fields(rest + " ;")[0], ";"))
+		s.afterEnd = next != "" && isLetter(next[0])
+		switch next {
+		case "IF", "LOOP", "WHILE", "REPEAT", "FOR":
+		default:
+			s.depth--
+		}
+	}
+}
+
+func (s *statementSplitter) markContent(line int) {
+	if !s.hasContent {
+		s.hasContent = true
+		s.startLine = line
+	}
+}
+
+// splitStatements splits SQL text into individual statements
+func splitStatements(text string, backslashEscapes bool) ([]string, error) {
+	stmts := []string{}
+	splitter := statementSplitter{backslashEscapes: backslashEscapes}
+	collect := func(stmt string, _ int) error {
+		stmts = append(stmts, stmt)
+		return nil
+	}
+
+	if err := splitter.feed(text, 1, collect); err != nil {
+		return nil, err
+	}
+	if err := splitter.flush(collect); err != nil {
+		return nil, err
+	}
+
+	return stmts, nil
+}
+
+// delimiterRegexp matches a mysql client DELIMITER command, which takes up a whole line
+var delimiterRegexp = regexp.MustCompile(`(?i)^[ \t]*DELIMITER[ \t]+(\S+)[ \t]*(?:\r?\n|$)`)
+
+// delimiterCommand returns the delimiter set by a DELIMITER line at the start of s, which
+// is empty for the default semicolon, and the length of the line
+func delimiterCommand(s string) (string, int, bool) {
+	match := delimiterRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return "", 0, false
+	}
+	if match[1] == ";" {
+		return "", len(match[0]), true
+	}
+
+	return match[1], len(match[0]), true
+}
+
+// dollarQuoteTag returns the postgres dollar quote tag (e.g. $$ or $body$) at the start of s
+func dollarQuoteTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		if !(c == '_' || isLetter(c) || (i > 1 && isDigit(c))) {
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+// isEscapeStringPrefix returns true if the quote at position i is preceded by an E prefix,
+// e.g. E'escaped\tstring'
+func isEscapeStringPrefix(text string, i int) bool {
+	if i < 1 || (text[i-1] != 'E' && text[i-1] != 'e') {
+		return false
+	}
+
+	return i < 2 || !isIdentifierChar(text[i-2])
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || isLetter(c) || isDigit(c) || c >= 0x80
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,70 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name             string
+		input            string
+		backslashEscapes bool
+		expected         []string
+	}{
+		{"simple", "create table a (id int);\ncreate table b (id int);",
+			false, []string{"create table a (id int)", "create table b (id int)"}},
+		{"missing final semicolon", "select 1;\nselect 2\n",
+			false, []string{"select 1", "select 2"}},
+		{"skip empty and comment only statements", "-- first\n;\n  ;\nselect 1; -- trailing\n/* block */",
+			false, []string{"select 1"}},
+		{"semicolons in strings", "insert into t values ('a;b', \"c;d\", `e;f`);\nselect 1;",
+			false, []string{"insert into t values ('a;b', \"c;d\", `e;f`)", "select 1"}},
+		{"escaped quotes", "insert into t values ('it''s; here');select 2;",
+			false, []string{"insert into t values ('it''s; here')", "select 2"}},
+		{"semicolons in comments", "select 1 -- comment; here\n;\nselect /* a; b */ 2;",
+			false, []string{"select 1 -- comment; here", "select /* a; b */ 2"}},
+		{"dollar quoted function body", "create function f() returns int as $$ begin return 1; end; $$ language plpgsql;\nselect 1;",
+			false, []string{"create function f() returns int as $$ begin return 1; end; $$ language plpgsql", "select 1"}},
+		{"tagged dollar quote", "do $body$ begin perform 1; end $body$;",
+			false, []string{"do $body$ begin perform 1; end $body$"}},
+		{"placeholders are not dollar quotes", "select $1; select $2;",
+			false, []string{"select $1", "select $2"}},
+		{"dollar signs in identifiers", "select a$b$c; select 2;",
+			false, []string{"select a$b$c", "select 2"}},
+		{"backslash is literal in standard strings", "select 'C:\\'; select 2;",
+			false, []string{"select 'C:\\'", "select 2"}},
+		{"backslash escapes in E strings", "select E'it\\'s;'; select 2;",
+			false, []string{"select E'it\\'s;'", "select 2"}},
+		{"backslash escapes", "select 'it\\'s;'; select 2;",
+			true, []string{"select 'it\\'s;'", "select 2"}},
+		{"sqlite trigger body", "create trigger t after insert on a begin update b set n = n + 1; delete from c; end;\nselect 1;",
+			false, []string{"create trigger t after insert on a begin update b set n = n + 1; delete from c; end", "select 1"}},
+		{"mysql procedure with nested blocks", "CREATE DEFINER=`root`@`%` PROCEDURE p()\nBEGIN\n  IF x THEN\n    BEGIN select 1; END;\n  END IF;\n" +
+			"  CASE y WHEN 1 THEN select 2; END CASE;\n  l: LOOP leave l; END LOOP;\nEND;\nselect 3;",
+			true, []string{"CREATE DEFINER=`root`@`%` PROCEDURE p()\nBEGIN\n  IF x THEN\n    BEGIN select 1; END;\n  END IF;\n" +
+				"  CASE y WHEN 1 THEN select 2; END CASE;\n  l: LOOP leave l; END LOOP;\nEND", "select 3"}},
+		{"case expression in trigger", "create trigger t before insert on a for each row set new.x = case when 1 then 2 end;\nselect 1;",
+			false, []string{"create trigger t before insert on a for each row set new.x = case when 1 then 2 end", "select 1"}},
+		{"begin outside routines", "begin; select 1; commit;",
+			false, []string{"begin", "select 1", "commit"}},
+		{"copy data", "-- load\nCOPY t (a, b) FROM stdin;\n1\t'x;\n\\.\nselect 1;\ncopy t from stdin;\n\\.\n",
+			false, []string{"-- load\nCOPY t (a, b) FROM stdin;\n1\t'x;\n\\.", "select 1", "copy t from stdin;\n\\."}},
+		{"delimiter commands", "DELIMITER ;;\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = ';;'; END ;;\n" +
+			"delimiter //\nselect 1//\nDELIMITER ;\nselect 2;",
+			true, []string{"CREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = ';;'; END", "select 1", "select 2"}},
+		{"delimiter only at the start of a statement", "select delimiter\n;\n",
+			false, []string{"select delimiter"}},
+		{"copy to stdout", "copy t to stdout; select 1;",
+			false, []string{"copy t to stdout", "select 1"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stmts, err := splitStatements(c.input, c.backslashEscapes)
+			require.NoError(t, err)
+			require.Equal(t, c.expected, stmts)
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error codes
+var (
+	ErrStatusURLUnsupported = errors.New("status URL is not supported by this driver")
+	ErrStatusURLMismatch    = errors.New("status URL does not point at a replica of the database")
+)
+
+// statusDB returns a copy of db which connects to db.StatusURL, so that read only
+// commands can run against a read replica. The replica must have the same fingerprint
+// as the primary database, which confirms it is a copy of the same database rather than,
+// for example, a replica of another environment.
+func (db *DB) statusDB() (*DB, error) {
+	if db.DatabaseURL == nil {
+		return nil, ErrInvalidURL
+	}
+	if db.StatusURL.Scheme != db.DatabaseURL.Scheme {
+		return nil, fmt.Errorf("%w: the status URL uses %s, but the database URL uses %s",
+			ErrStatusURLMismatch, db.StatusURL.Scheme, db.DatabaseURL.Scheme)
+	}
+
+	replica := *db
+	replica.DatabaseURL = db.StatusURL
+	replica.StatusURL = nil
+	replica.Connection = nil
+
+	primaryFingerprint, err := db.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	replicaFingerprint, err := replica.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if primaryFingerprint != replicaFingerprint {
+		return nil, fmt.Errorf("%w: fingerprint %s does not match %s",
+			ErrStatusURLMismatch, replicaFingerprint, primaryFingerprint)
+	}
+
+	return &replica, nil
+}
+
+// fingerprint returns the driver's fingerprint of the database
+func (db *DB) fingerprint() (string, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return "", err
+	}
+
+	fingerprinter, ok := drv.(databaseFingerprinter)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrStatusURLUnsupported, db.DatabaseURL.Scheme)
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return "", err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	return fingerprinter.DatabaseFingerprint(sqlDB)
+}
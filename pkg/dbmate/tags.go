@@ -0,0 +1,83 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidTag is returned when a migration or DB.Tags specifies an invalid tag
+var ErrInvalidTag = errors.New("invalid tag")
+
+// tagsRegExp matches an annotation listing the tags of a migration, e.g.
+// -- migrate:tags data, slow
+var tagsRegExp = regexp.MustCompile(`^--\s*migrate:tags\s+(.*)$`)
+
+// tagRegExp matches a valid tag
+var tagRegExp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// parseTags returns the lower case tags listed by a tags annotation, or nil if the line
+// is not a tags annotation
+func parseTags(line string) []string {
+	match := tagsRegExp.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return nil
+	}
+
+	return strings.FieldsFunc(strings.ToLower(match[1]), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// validateTags returns ErrInvalidTag if a tag contains characters other than lower case
+// letters, digits, dashes and underscores. Filters (see DB.Tags) may start with "!".
+func validateTags(tags []string, filters bool) error {
+	for _, tag := range tags {
+		name := tag
+		if filters {
+			name = strings.TrimPrefix(tag, "!")
+		}
+		if !tagRegExp.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+	}
+
+	return nil
+}
+
+// matchesTags returns true if a migration with the tags is selected by DB.Tags: it has
+// none of the excluded tags ("!tag"), and at least one of the included tags, if any
+// are listed
+func (db *DB) matchesTags(tags []string) bool {
+	has := map[string]bool{}
+	for _, tag := range tags {
+		has[tag] = true
+	}
+
+	included, anyIncluded := false, false
+	for _, filter := range db.Tags {
+		if excluded := strings.TrimPrefix(filter, "!"); excluded != filter {
+			if has[excluded] {
+				return false
+			}
+			continue
+		}
+
+		anyIncluded = true
+		if has[filter] {
+			included = true
+		}
+	}
+
+	return included || !anyIncluded
+}
+
+// formatTags returns the tags for log messages
+func formatTags(tags []string) string {
+	if len(tags) == 0 {
+		return "none"
+	}
+
+	return strings.Join(tags, ", ")
+}
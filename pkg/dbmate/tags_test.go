@@ -0,0 +1,44 @@
+package dbmate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTags(t *testing.T) {
+	require.Equal(t, []string{"data"}, parseTags("-- migrate:tags data\n"))
+	require.Equal(t, []string{"data", "slow", "backfill"}, parseTags("--migrate:tags Data, slow backfill"))
+	require.Nil(t, parseTags("-- migrate:tags"))
+	require.Nil(t, parseTags("-- migrate:up"))
+	require.Nil(t, parseTags("select 1; -- migrate:tags data"))
+}
+
+func TestValidateTags(t *testing.T) {
+	require.NoError(t, validateTags([]string{"data", "slow-2", "off_peak"}, false))
+	require.ErrorIs(t, validateTags([]string{"!slow"}, false), ErrInvalidTag)
+	require.NoError(t, validateTags([]string{"data", "!slow"}, true))
+	require.ErrorIs(t, validateTags([]string{"!"}, true), ErrInvalidTag)
+	require.ErrorIs(t, validateTags([]string{""}, true), ErrInvalidTag)
+	require.ErrorIs(t, validateTags([]string{"Data"}, true), ErrInvalidTag)
+}
+
+func TestMatchesTags(t *testing.T) {
+	db := New(nil)
+	require.True(t, db.matchesTags(nil))
+	require.True(t, db.matchesTags([]string{"slow"}))
+
+	db.Tags = []string{"!slow"}
+	require.True(t, db.matchesTags(nil))
+	require.True(t, db.matchesTags([]string{"data"}))
+	require.False(t, db.matchesTags([]string{"data", "slow"}))
+
+	db.Tags = []string{"data", "backfill"}
+	require.False(t, db.matchesTags(nil))
+	require.True(t, db.matchesTags([]string{"backfill"}))
+	require.False(t, db.matchesTags([]string{"slow"}))
+
+	db.Tags = []string{"data", "!slow"}
+	require.True(t, db.matchesTags([]string{"data"}))
+	require.False(t, db.matchesTags([]string{"data", "slow"}))
+}
@@ -0,0 +1,182 @@
+package dbmate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrDownIncomplete is reported when rolling back a migration leaves tables or columns
+// which did not exist before it was applied (or removes ones which did)
+var ErrDownIncomplete = errors.New("down block did not restore the schema")
+
+// Steps at which verifying a migration can fail
+const (
+	VerifyStepUp      = "up"
+	VerifyStepDown    = "down"
+	VerifyStepReapply = "reapply"
+)
+
+// VerifyDownResult is the outcome of verifying that a migration can be rolled back
+type VerifyDownResult struct {
+	Version  string `json:"version"`
+	FileName string `json:"file"`
+	Passed   bool   `json:"passed"`
+	// Irreversible is true if the down block is marked irreversible, in which case the
+	// migration is applied but not rolled back
+	Irreversible bool `json:"irreversible,omitempty"`
+	// Step is the step which failed (up, down, or reapply), if any
+	Step  string `json:"step,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// VerifyDown applies each pending migration, rolls it back, then applies it again, to
+// confirm that its down block reverses its up block. Migrations with an irreversible down
+// block are only applied, and are reported as irreversible. It is intended to be run in CI
+// against a throwaway database, which is created if it does not already exist.
+//
+// If the driver can list table columns, the tables and columns after rolling back must
+// match those before the migration was applied. Verification stops at the first failing
+// migration, since later migrations usually depend on it. The returned error is only set
+// if verification could not be run at all.
+func (db *DB) VerifyDown() ([]VerifyDownResult, error) {
+	drv, err := db.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	// create database if it does not already exist
+	exists, err := drv.DatabaseExists()
+	if err == nil && !exists {
+		if err := drv.CreateDatabase(); err != nil {
+			return nil, err
+		}
+	}
+
+	migrations, err := db.FindMigrations()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := orderedPending(migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	// the schema file would otherwise be rewritten after every step
+	autoDumpSchema := db.AutoDumpSchema
+	db.AutoDumpSchema = false
+	defer func() { db.AutoDumpSchema = autoDumpSchema }()
+
+	results := []VerifyDownResult{}
+	for _, migration := range pending {
+		result := VerifyDownResult{Version: migration.Version, FileName: migration.FileName,
+			Irreversible: migration.Irreversible}
+		step, err := db.verifyDown(drv, migration)
+		if err != nil {
+			result.Step = step
+			result.Error = err.Error()
+			results = append(results, result)
+			db.logger().Errorf("Failed (%s): %s: %s", step, migration.FileName, err)
+			break
+		}
+
+		result.Passed = true
+		results = append(results, result)
+		if migration.Irreversible {
+			db.logger().Infof("Applied (irreversible): %s", migration.FileName)
+		} else {
+			db.logger().Infof("Verified: %s", migration.FileName)
+		}
+	}
+
+	return results, nil
+}
+
+// verifyDown applies, rolls back, and reapplies a single migration, and returns the step
+// which failed
+func (db *DB) verifyDown(drv Driver, migration Migration) (string, error) {
+	before, err := db.verifyTableColumns(drv)
+	if err != nil {
+		return VerifyStepUp, err
+	}
+
+	if err := db.migrate(migration.Version); err != nil {
+		return VerifyStepUp, err
+	}
+
+	// later migrations are verified on top of migrations which can't be rolled back
+	if migration.Irreversible {
+		return "", nil
+	}
+
+	if err := db.rollback(migration.Version); err != nil {
+		return VerifyStepDown, err
+	}
+
+	after, err := db.verifyTableColumns(drv)
+	if err != nil {
+		return VerifyStepDown, err
+	}
+	if changes := diffTableColumns(before, after); len(changes) > 0 {
+		return VerifyStepDown, fmt.Errorf("%w: %s", ErrDownIncomplete, strings.Join(changes, ", "))
+	}
+
+	if err := db.migrate(migration.Version); err != nil {
+		return VerifyStepReapply, err
+	}
+
+	return "", nil
+}
+
+// verifyTableColumns returns the columns of each table managed by migrations, or nil if
+// the driver cannot list them
+func (db *DB) verifyTableColumns(drv Driver) (map[string][]string, error) {
+	inspector, ok := drv.(schemaInspector)
+	if !ok {
+		return nil, nil
+	}
+
+	sqlDB, err := db.openDatabase(drv)
+	if err != nil {
+		return nil, err
+	}
+	defer db.closeDatabase(sqlDB)
+
+	columns, err := inspector.TableColumns(sqlDB)
+	if err != nil {
+		return nil, err
+	}
+
+	// bookkeeping tables are created by the first migration
+	delete(columns, db.auditTableName())
+	if db.GolangMigrateTable != "" {
+		delete(columns, db.GolangMigrateTable)
+	}
+
+	return columns, nil
+}
+
+// diffTableColumns describes the tables and columns which differ between before and after
+func diffTableColumns(before, after map[string][]string) []string {
+	changes := []string{}
+	for table, columns := range after {
+		beforeColumns, exists := before[table]
+		if !exists {
+			changes = append(changes, fmt.Sprintf("table %s was not dropped", table))
+			continue
+		}
+		if strings.Join(columns, ",") != strings.Join(beforeColumns, ",") {
+			changes = append(changes, fmt.Sprintf("columns of table %s changed from (%s) to (%s)",
+				table, strings.Join(beforeColumns, ", "), strings.Join(columns, ", ")))
+		}
+	}
+	for table := range before {
+		if _, exists := after[table]; !exists {
+			changes = append(changes, fmt.Sprintf("table %s was not recreated", table))
+		}
+	}
+
+	sort.Strings(changes)
+	return changes
+}
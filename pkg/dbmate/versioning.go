@@ -0,0 +1,214 @@
+package dbmate
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version formats for new migrations
+const (
+	// VersionFormatTimestamp generates UTC timestamps, e.g. 20240102150405
+	VersionFormatTimestamp = "timestamp"
+	// VersionFormatUnix generates seconds since the unix epoch, e.g. 1704207845
+	VersionFormatUnix = "unix"
+	// VersionFormatSequential generates the next integer after the highest existing
+	// version, zero padded to the width of existing versions, e.g. 000042
+	VersionFormatSequential = "sequential"
+	// VersionFormatUUIDv7 generates time-ordered UUIDs (RFC 9562), which sort in the
+	// order they were created, e.g. 018cc8a1-7f28-7c3e-9b1a-2f6d8e0c4b5a
+	VersionFormatUUIDv7 = "uuidv7"
+)
+
+// Version format errors
+var (
+	ErrInvalidVersionFormat = errors.New("invalid version format")
+	ErrInvalidVersion       = errors.New("invalid migration version")
+)
+
+// VersionGenerator returns the version for a new migration, given the versions of the
+// existing migrations in ascending order. The version must be either a string of
+// digits or a UUID, and migrations are applied in lexical order of their versions.
+type VersionGenerator func(versions []string) (string, error)
+
+// sequentialVersionDigits is the width of the first sequential version
+const sequentialVersionDigits = 6
+
+// versionPattern matches the version prefix of migration file names. UUIDs are matched
+// first, since they may also begin with digits.
+const versionPattern = `[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|\d+`
+
+var (
+	versionRegexp          = regexp.MustCompile(`^(?:` + versionPattern + `)$`)
+	migrationVersionRegexp = regexp.MustCompile(`^(?:` + versionPattern + `)`)
+	digitsRegexp           = regexp.MustCompile(`^\d+$`)
+	uuidv7Regexp           = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+)
+
+// versionFormat generates and validates versions in a particular format
+type versionFormat struct {
+	generate func(now time.Time, versions []string) (string, error)
+	valid    func(version string) bool
+}
+
+var versionFormats = map[string]versionFormat{
+	VersionFormatTimestamp: {
+		generate: func(now time.Time, _ []string) (string, error) {
+			return now.UTC().Format("20060102150405"), nil
+		},
+		valid: func(version string) bool {
+			_, err := time.Parse("20060102150405", version)
+			return err == nil
+		},
+	},
+	VersionFormatUnix: {
+		generate: func(now time.Time, _ []string) (string, error) {
+			return strconv.FormatInt(now.Unix(), 10), nil
+		},
+		valid: func(version string) bool {
+			return len(version) == 10 && digitsRegexp.MatchString(version)
+		},
+	},
+	VersionFormatSequential: {
+		generate: func(_ time.Time, versions []string) (string, error) {
+			return nextSequentialVersion(versions)
+		},
+		valid: digitsRegexp.MatchString,
+	},
+	VersionFormatUUIDv7: {
+		generate: func(now time.Time, _ []string) (string, error) {
+			return newUUIDv7(now)
+		},
+		valid: uuidv7Regexp.MatchString,
+	},
+}
+
+// VersionFormats returns the names of the supported version formats
+func VersionFormats() []string {
+	return []string{VersionFormatTimestamp, VersionFormatUnix, VersionFormatSequential, VersionFormatUUIDv7}
+}
+
+// versionFormat returns the configured version format. If no format is configured,
+// timestamps are generated but existing versions are not validated.
+func (db *DB) versionFormat() (versionFormat, error) {
+	if db.VersionFormat == "" {
+		return versionFormats[VersionFormatTimestamp], nil
+	}
+
+	format, ok := versionFormats[db.VersionFormat]
+	if !ok {
+		return versionFormat{}, fmt.Errorf("%w: %s (expected one of: %s)", ErrInvalidVersionFormat,
+			db.VersionFormat, strings.Join(VersionFormats(), ", "))
+	}
+
+	return format, nil
+}
+
+// validateVersion returns an error if the version of a migration file does not match the
+// configured version format
+func (db *DB) validateVersion(fileName, version string) error {
+	if db.VersionFormat == "" || db.VersionGenerator != nil {
+		return nil
+	}
+
+	format, err := db.versionFormat()
+	if err != nil {
+		return err
+	}
+	if !format.valid(version) {
+		return fmt.Errorf("%w `%s`: expected %s version", ErrInvalidVersion, fileName, db.VersionFormat)
+	}
+
+	return nil
+}
+
+// nextVersion returns the version for a new migration, using the custom version
+// generator if one is set, or the configured version format. The new migration is
+// written to the first migrations directory, but the migrations of every directory are
+// applied and recorded in the same migrations table, so the versions of all of them are
+// considered (e.g. a sequential version follows the highest version in any directory).
+func (db *DB) nextVersion(now time.Time) (string, error) {
+	migrations, err := db.migrationFiles()
+	if err != nil {
+		return "", err
+	}
+	versions := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		versions = append(versions, migration.Version)
+	}
+
+	return db.generateVersion(now, versions)
+}
+
+// generateVersion returns the version for a new migration given the existing versions
+// in ascending order
+func (db *DB) generateVersion(now time.Time, versions []string) (string, error) {
+	format, err := db.versionFormat()
+	if err != nil {
+		return "", err
+	}
+
+	var version string
+	if db.VersionGenerator != nil {
+		version, err = db.VersionGenerator(versions)
+	} else {
+		version, err = format.generate(now, versions)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !versionRegexp.MatchString(version) {
+		return "", fmt.Errorf("%w `%s`: versions must be digits or a UUID", ErrInvalidVersion, version)
+	}
+
+	return version, nil
+}
+
+// nextSequentialVersion returns the integer after the highest existing version, padded
+// to the width of the existing versions
+func nextSequentialVersion(versions []string) (string, error) {
+	var highest uint64
+	width := 0
+	for _, version := range versions {
+		v, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w `%s`: expected %s version", ErrInvalidVersion, version,
+				VersionFormatSequential)
+		}
+		if v > highest {
+			highest = v
+		}
+		if len(version) > width {
+			width = len(version)
+		}
+	}
+
+	if width == 0 {
+		width = sequentialVersionDigits
+	}
+
+	return fmt.Sprintf("%0*d", width, highest+1), nil
+}
+
+// newUUIDv7 returns a UUID containing the millisecond timestamp followed by random bits
+func newUUIDv7(now time.Time) (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(b[:6], ms[2:])
+	b[6] = 0x70 | (b[6] & 0x0f) // version 7
+	b[8] = 0x80 | (b[8] & 0x3f) // variant 10
+
+	s := hex.EncodeToString(b[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
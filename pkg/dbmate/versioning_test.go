@@ -0,0 +1,171 @@
+package dbmate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersionFormats(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		format  string
+		version string
+		valid   []string
+		invalid []string
+	}{
+		{VersionFormatTimestamp, "20240102150405", []string{"20151129054053"}, []string{"001", "20241301000000"}},
+		{VersionFormatUnix, "1704207845", []string{"1448776853"}, []string{"001", "20240102150405"}},
+		{VersionFormatSequential, "000001", []string{"1", "000042"}, []string{"018cc8a1-7f28-7c3e-9b1a-2f6d8e0c4b5a"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.format, func(t *testing.T) {
+			format := versionFormats[c.format]
+			version, err := format.generate(now, nil)
+			require.NoError(t, err)
+			require.Equal(t, c.version, version)
+			require.True(t, format.valid(version))
+
+			for _, v := range c.valid {
+				require.True(t, format.valid(v), v)
+			}
+			for _, v := range c.invalid {
+				require.False(t, format.valid(v), v)
+			}
+		})
+	}
+}
+
+func TestNextSequentialVersion(t *testing.T) {
+	version, err := nextSequentialVersion(nil)
+	require.NoError(t, err)
+	require.Equal(t, "000001", version)
+
+	// existing versions are continued at the same width
+	version, err = nextSequentialVersion([]string{"001", "002", "009"})
+	require.NoError(t, err)
+	require.Equal(t, "010", version)
+
+	version, err = nextSequentialVersion([]string{"99"})
+	require.NoError(t, err)
+	require.Equal(t, "100", version)
+
+	_, err = nextSequentialVersion([]string{"018cc8a1-7f28-7c3e-9b1a-2f6d8e0c4b5a"})
+	require.ErrorIs(t, err, ErrInvalidVersion)
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	a, err := newUUIDv7(now)
+	require.NoError(t, err)
+	require.Regexp(t, uuidv7Regexp, a)
+	require.Equal(t, "018ccab4-0688", a[:13])
+	require.True(t, versionFormats[VersionFormatUUIDv7].valid(a))
+
+	// versions sort in the order they were created
+	b, err := newUUIDv7(now.Add(time.Millisecond))
+	require.NoError(t, err)
+	require.Less(t, a, b)
+
+	// the version is matched in its entirety in migration file names
+	matches := migrationFileRegexp.FindStringSubmatch(a + "_create_users.sql")
+	require.Equal(t, a, matches[1])
+	require.Equal(t, "create_users", migrationDescription(a+"_create_users.sql"))
+}
+
+func TestNextVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"000001_a.sql", "000002_b.sql", "README.md"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+
+	db := New(dbutil.MustParseURL("sqlite:"))
+	db.MigrationsDir = []string{dir}
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	t.Run("default", func(t *testing.T) {
+		// existing versions are not validated
+		version, err := db.nextVersion(now)
+		require.NoError(t, err)
+		require.Equal(t, "20240102150405", version)
+	})
+
+	t.Run("sequential", func(t *testing.T) {
+		db.VersionFormat = VersionFormatSequential
+		defer func() { db.VersionFormat = "" }()
+
+		version, err := db.nextVersion(now)
+		require.NoError(t, err)
+		require.Equal(t, "000003", version)
+	})
+
+	t.Run("multiple directories", func(t *testing.T) {
+		db.VersionFormat = VersionFormatSequential
+		other := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(other, "000007_c.sql"), nil, 0o644))
+		db.MigrationsDir = []string{dir, other}
+		defer func() {
+			db.VersionFormat = ""
+			db.MigrationsDir = []string{dir}
+		}()
+
+		// versions are unique across directories
+		version, err := db.nextVersion(now)
+		require.NoError(t, err)
+		require.Equal(t, "000008", version)
+	})
+
+	t.Run("invalid existing versions", func(t *testing.T) {
+		db.VersionFormat = VersionFormatTimestamp
+		defer func() { db.VersionFormat = "" }()
+
+		_, err := db.nextVersion(now)
+		require.ErrorIs(t, err, ErrInvalidVersion)
+		require.EqualError(t, err, "invalid migration version `000001_a.sql`: expected timestamp version")
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		db.VersionFormat = "semver"
+		defer func() { db.VersionFormat = "" }()
+
+		_, err := db.nextVersion(now)
+		require.ErrorIs(t, err, ErrInvalidVersionFormat)
+	})
+
+	t.Run("custom generator", func(t *testing.T) {
+		db.VersionGenerator = func(versions []string) (string, error) {
+			require.Equal(t, []string{"000001", "000002"}, versions)
+			return "100", nil
+		}
+		defer func() { db.VersionGenerator = nil }()
+
+		version, err := db.nextVersion(now)
+		require.NoError(t, err)
+		require.Equal(t, "100", version)
+	})
+
+	t.Run("custom generator errors", func(t *testing.T) {
+		db.VersionGenerator = func([]string) (string, error) {
+			return "", errors.New("no versions left")
+		}
+		defer func() { db.VersionGenerator = nil }()
+
+		_, err := db.nextVersion(now)
+		require.EqualError(t, err, "no versions left")
+
+		db.VersionGenerator = func([]string) (string, error) {
+			return "v1.0", nil
+		}
+		_, err = db.nextVersion(now)
+		require.ErrorIs(t, err, ErrInvalidVersion)
+	})
+}
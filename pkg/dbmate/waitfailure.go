@@ -0,0 +1,112 @@
+package dbmate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ErrInvalidWaitFailure is returned if DB.WaitFailOn contains an unknown failure
+var ErrInvalidWaitFailure = errors.New("invalid wait failure")
+
+// Connection failures, which DB.WaitFailOn may list to stop waiting for the database
+// instead of retrying
+const (
+	// WaitFailureAuth means the server rejected the credentials
+	WaitFailureAuth = "auth"
+	// WaitFailureTLS means the TLS handshake failed, e.g. the certificate could not be
+	// verified or the server does not support TLS
+	WaitFailureTLS = "tls"
+	// WaitFailureDNS means the host name could not be resolved
+	WaitFailureDNS = "dns"
+	// WaitFailureNotReady means the server refused the connection, or is starting up
+	WaitFailureNotReady = "not-ready"
+)
+
+// WaitFailures lists the connection failures recognized while waiting for the database
+var WaitFailures = []string{WaitFailureAuth, WaitFailureTLS, WaitFailureDNS, WaitFailureNotReady}
+
+// waitFailureDescriptions describes each failure in error messages
+var waitFailureDescriptions = map[string]string{
+	WaitFailureAuth:     "authentication failed",
+	WaitFailureTLS:      "TLS handshake failed",
+	WaitFailureDNS:      "host name could not be resolved",
+	WaitFailureNotReady: "database is not ready",
+}
+
+// validateWaitFailOn returns an error if failures contains an unknown failure
+func validateWaitFailOn(failures []string) error {
+	for _, failure := range failures {
+		if _, ok := waitFailureDescriptions[failure]; !ok {
+			return fmt.Errorf("%w: %s (expected one of %v)", ErrInvalidWaitFailure, failure, WaitFailures)
+		}
+	}
+
+	return nil
+}
+
+// failsWaitOn returns true if waiting for the database should stop on the failure
+func (db *DB) failsWaitOn(failure string) bool {
+	for _, f := range db.WaitFailOn {
+		if f == failure {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waitFailure returns the kind of connection failure which caused err, or an empty
+// string if it is unknown. Drivers may classify their own errors (e.g. authentication
+// failures) by implementing waitFailureClassifier.
+func waitFailure(drv Driver, err error) string {
+	if classifier, ok := drv.(waitFailureClassifier); ok {
+		if failure := classifier.WaitFailure(err); failure != "" {
+			return failure
+		}
+	}
+
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return WaitFailureTLS
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return WaitFailureDNS
+	}
+
+	var opErr *net.OpError
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		(errors.As(err, &opErr) && opErr.Op == "dial") {
+		return WaitFailureNotReady
+	}
+
+	return ""
+}
+
+// checkWaitFailure returns an error if the connection failure which caused err is
+// listed in DB.WaitFailOn, since failures such as rejected credentials won't go away
+// by retrying
+func (db *DB) checkWaitFailure(drv Driver, err error) error {
+	failure := waitFailure(drv, err)
+	if failure == "" || !db.failsWaitOn(failure) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s, not retrying (see --wait-fail-on): %w", ErrCantConnect,
+		waitFailureDescriptions[failure], err)
+}
@@ -0,0 +1,93 @@
+package dbmate
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// waitTestDriver classifies errTestAuth as an authentication failure, and returns the
+// next of pingErrs each time it is pinged
+type waitTestDriver struct {
+	Driver
+	pingErrs []error
+	pings    int
+}
+
+func (drv *waitTestDriver) WaitFailure(err error) string {
+	if errors.Is(err, errTestAuth) {
+		return WaitFailureAuth
+	}
+	return ""
+}
+
+func (drv *waitTestDriver) Ping() error {
+	drv.pings++
+	if len(drv.pingErrs) == 0 {
+		return nil
+	}
+	err := drv.pingErrs[0]
+	drv.pingErrs = drv.pingErrs[1:]
+	return err
+}
+
+func TestWaitFailure(t *testing.T) {
+	drv := &waitTestDriver{}
+
+	for err, failure := range map[error]string{
+		fmt.Errorf("connect: %w", errTestAuth):                             WaitFailureAuth,
+		fmt.Errorf("handshake: %w", x509.UnknownAuthorityError{}):          WaitFailureTLS,
+		&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host"}}:  WaitFailureDNS,
+		fmt.Errorf("dial: %w", syscall.ECONNREFUSED):                       WaitFailureNotReady,
+		&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("timed out")}: WaitFailureNotReady,
+		errors.New("something else"):                                       "",
+	} {
+		require.Equal(t, failure, waitFailure(drv, err), err.Error())
+	}
+
+	require.NoError(t, validateWaitFailOn(WaitFailures))
+	err := validateWaitFailOn([]string{"auth", "password"})
+	require.ErrorIs(t, err, ErrInvalidWaitFailure)
+	require.Contains(t, err.Error(), "password")
+}
+
+func TestWaitFailOn(t *testing.T) {
+	db := New(nil)
+	db.Log = io.Discard
+	db.WaitInterval = time.Millisecond
+	db.WaitTimeout = time.Second
+	refused := fmt.Errorf("dial: %w", syscall.ECONNREFUSED)
+
+	// authentication failures are not retried by default
+	drv := &waitTestDriver{pingErrs: []error{refused, errTestAuth, refused}}
+	err := db.wait(drv)
+	require.ErrorIs(t, err, ErrCantConnect)
+	require.ErrorIs(t, err, errTestAuth)
+	require.Contains(t, err.Error(), "authentication failed, not retrying")
+	require.Equal(t, 2, drv.pings)
+
+	// other failures are retried until the server is ready
+	db.WaitFailOn = []string{WaitFailureDNS}
+	drv = &waitTestDriver{pingErrs: []error{refused, errTestAuth, refused}}
+	err = db.wait(drv)
+	require.NoError(t, err)
+	require.Equal(t, 4, drv.pings)
+
+	db.WaitFailOn = []string{WaitFailureNotReady}
+	drv = &waitTestDriver{pingErrs: []error{refused}}
+	err = db.wait(drv)
+	require.ErrorIs(t, err, ErrCantConnect)
+	require.Contains(t, err.Error(), "database is not ready, not retrying")
+	require.Equal(t, 1, drv.pings)
+
+	db.WaitFailOn = []string{"never"}
+	err = db.wait(drv)
+	require.ErrorIs(t, err, ErrInvalidWaitFailure)
+}
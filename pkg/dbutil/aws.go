@@ -0,0 +1,414 @@
+package dbutil
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSCredentials sign requests to AWS services. Requests are not signed if the access key
+// is empty, which allows public S3 buckets to be read.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// AWSCredentialsFromEnv returns the credentials in the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables
+func AWSCredentialsFromEnv() AWSCredentials {
+	return AWSCredentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// ErrAWSProfile is returned if the AWS profile can't be read, or uses a source of
+// credentials which is not supported
+var ErrAWSProfile = errors.New("unable to read AWS profile")
+
+// awsMetadataClient requests credentials from the EC2 instance metadata service, which
+// responds quickly if it is available at all
+var awsMetadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// LoadAWSCredentials returns credentials from the first source which provides them, in
+// the same order as the AWS CLI:
+//
+//   - the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment
+//     variables
+//   - the AWS_PROFILE (or default) profile of the shared credentials file
+//     (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials) or config file (AWS_CONFIG_FILE
+//     or ~/.aws/config)
+//   - the role of the EC2 instance, from the instance metadata service (IMDSv2), unless
+//     AWS_EC2_METADATA_DISABLED is true
+//
+// If no source provides credentials, empty credentials are returned, so that public
+// buckets can be read.
+func LoadAWSCredentials(ctx context.Context) (AWSCredentials, error) {
+	if creds := AWSCredentialsFromEnv(); creds.AccessKeyID != "" {
+		return creds, nil
+	}
+
+	creds, err := awsProfileCredentials()
+	if err != nil || creds.AccessKeyID != "" {
+		return creds, err
+	}
+
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return AWSCredentials{}, nil
+	}
+
+	return awsInstanceCredentials(ctx)
+}
+
+// AWSRegion returns region if it is not empty, otherwise the AWS_REGION or
+// AWS_DEFAULT_REGION environment variable, the region of the AWS_PROFILE (or default)
+// profile in the shared config file, or us-east-1
+func AWSRegion(region string) string {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region == "" {
+			region = os.Getenv(name)
+		}
+	}
+	if region == "" {
+		if config, err := awsProfileConfig(); err == nil {
+			region = config["region"]
+		}
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return region
+}
+
+// awsProfile returns the name of the selected profile
+func awsProfile() string {
+	for _, name := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		if profile := os.Getenv(name); profile != "" {
+			return profile
+		}
+	}
+
+	return "default"
+}
+
+// awsSharedFile returns the path of a shared file, from an environment variable or in
+// the ~/.aws directory
+func awsSharedFile(envName, name string) (string, error) {
+	if file := os.Getenv(envName); file != "" {
+		return file, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".aws", name), nil
+}
+
+// awsProfileConfig returns the settings of the selected profile in the shared config
+// file, where profiles other than default are in [profile NAME] sections
+func awsProfileConfig() (map[string]string, error) {
+	file, err := awsSharedFile("AWS_CONFIG_FILE", "config")
+	if err != nil {
+		return nil, err
+	}
+
+	section := awsProfile()
+	if section != "default" {
+		section = "profile " + section
+	}
+
+	return readINISection(file, section)
+}
+
+// awsProfileCredentials returns the credentials of the selected profile, from the shared
+// credentials file or else the shared config file
+func awsProfileCredentials() (AWSCredentials, error) {
+	file, err := awsSharedFile("AWS_SHARED_CREDENTIALS_FILE", "credentials")
+	if err != nil {
+		return AWSCredentials{}, nil
+	}
+	settings, err := readINISection(file, awsProfile())
+	if err != nil {
+		return AWSCredentials{}, fmt.Errorf("%w: %w", ErrAWSProfile, err)
+	}
+	if settings["aws_access_key_id"] == "" {
+		if settings, err = awsProfileConfig(); err != nil {
+			return AWSCredentials{}, fmt.Errorf("%w: %w", ErrAWSProfile, err)
+		}
+	}
+
+	creds := AWSCredentials{
+		AccessKeyID:     settings["aws_access_key_id"],
+		SecretAccessKey: settings["aws_secret_access_key"],
+		SessionToken:    settings["aws_session_token"],
+	}
+	if creds.AccessKeyID == "" {
+		// other sources of credentials require the AWS SDK
+		for _, key := range []string{"role_arn", "credential_process", "sso_session", "sso_start_url"} {
+			if settings[key] != "" {
+				return creds, fmt.Errorf("%w: profile %s uses %s, which is not supported "+
+					"(export credentials instead, e.g. with `aws configure export-credentials --format env`)",
+					ErrAWSProfile, awsProfile(), key)
+			}
+		}
+	}
+
+	return creds, nil
+}
+
+// readINISection returns the keys of a section of an INI file, or nil if the file or
+// section does not exist
+func readINISection(file, section string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var settings map[string]string
+	current := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			if current == section && settings == nil {
+				settings = map[string]string{}
+			}
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			settings[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+
+	return settings, nil
+}
+
+// awsMetadataEndpoint returns the URL of the EC2 instance metadata service
+func awsMetadataEndpoint() string {
+	if endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/")
+	}
+
+	return "http://169.254.169.254"
+}
+
+// awsInstanceCredentials returns the credentials of the role of the EC2 instance, or
+// empty credentials if the instance metadata service is not available or the instance
+// has no role
+func awsInstanceCredentials(ctx context.Context) (AWSCredentials, error) {
+	endpoint := awsMetadataEndpoint()
+	token, err := awsMetadataRequest(ctx, http.MethodPut, endpoint+"/latest/api/token", "")
+	if err != nil {
+		// not running on EC2
+		return AWSCredentials{}, nil
+	}
+
+	roles, err := awsMetadataRequest(ctx, http.MethodGet,
+		endpoint+"/latest/meta-data/iam/security-credentials/", token)
+	if errors.Is(err, errAWSMetadataNotFound) {
+		return AWSCredentials{}, nil
+	}
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(roles), "\n")
+	if role == "" {
+		return AWSCredentials{}, nil
+	}
+
+	data, err := awsMetadataRequest(ctx, http.MethodGet,
+		endpoint+"/latest/meta-data/iam/security-credentials/"+url.PathEscape(role), token)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+
+	var result struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+	}
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		return AWSCredentials{}, fmt.Errorf("invalid instance metadata credentials: %w", err)
+	}
+
+	return AWSCredentials{
+		AccessKeyID:     result.AccessKeyID,
+		SecretAccessKey: result.SecretAccessKey,
+		SessionToken:    result.Token,
+	}, nil
+}
+
+var errAWSMetadataNotFound = errors.New("not found")
+
+// awsMetadataRequest sends a request to the instance metadata service, using token if
+// it is not empty, or else requesting a new token
+func awsMetadataRequest(ctx context.Context, method, u, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	} else {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+
+	resp, err := awsMetadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer MustClose(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errAWSMetadataNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata request failed: %s", resp.Status)
+	}
+
+	return string(body), nil
+}
+
+// AWSEndpoint returns the custom endpoint of a service from the AWS_ENDPOINT_URL_<SERVICE>
+// or AWS_ENDPOINT_URL environment variable, or an empty string
+func AWSEndpoint(service string) string {
+	for _, name := range []string{"AWS_ENDPOINT_URL_" + strings.ToUpper(service), "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(name); endpoint != "" {
+			return strings.TrimSuffix(endpoint, "/")
+		}
+	}
+
+	return ""
+}
+
+// Sign adds an AWS Signature Version 4 authorization header to a request with the given
+// body, which may be nil. Every header set on the request is signed.
+func (c AWSCredentials) Sign(req *http.Request, body []byte, region, service string, now time.Time) {
+	if c.AccessKeyID == "" {
+		return
+	}
+
+	payloadHash := sha256Hex(string(body))
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", amzDate[:8], region, service)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		AWSURIEscape(req.URL.Path, false),
+		AWSQueryEscape(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex(canonicalRequest)}, "\n")
+
+	key := []byte("AWS4" + c.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// AWSURIEscape percent-encodes every byte except unreserved characters, and slashes
+// unless encodeSlash is true
+func AWSURIEscape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// AWSQueryEscape returns the canonical query string: escaped parameters sorted by name
+// and then value
+func AWSQueryEscape(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	params := []string{}
+	for _, name := range names {
+		values := append([]string{}, query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, AWSURIEscape(name, true)+"="+AWSURIEscape(value, true))
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
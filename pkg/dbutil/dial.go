@@ -0,0 +1,26 @@
+package dbutil
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// PQDialer adapts a DialFunc to the pq.Dialer interface, for the drivers built on lib/pq
+// (postgres and redshift)
+type PQDialer DialFunc
+
+func (d PQDialer) Dial(network, address string) (net.Conn, error) {
+	return d(context.Background(), network, address)
+}
+
+func (d PQDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d(ctx, network, address)
+}
+
+func (d PQDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d(ctx, network, address)
+}
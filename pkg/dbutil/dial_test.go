@@ -0,0 +1,39 @@
+package dbutil_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPQDialer(t *testing.T) {
+	errDialed := errors.New("dialed")
+	var deadline time.Time
+	var hasDeadline bool
+	d := dbutil.PQDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		require.Equal(t, "tcp", network)
+		require.Equal(t, "db:5432", address)
+		deadline, hasDeadline = ctx.Deadline()
+		return nil, errDialed
+	})
+
+	_, err := d.Dial("tcp", "db:5432")
+	require.ErrorIs(t, err, errDialed)
+	require.False(t, hasDeadline)
+
+	// the timeout is applied to the context
+	_, err = d.DialTimeout("tcp", "db:5432", time.Minute)
+	require.ErrorIs(t, err, errDialed)
+	require.True(t, hasDeadline)
+	require.WithinDuration(t, time.Now().Add(time.Minute), deadline, 5*time.Second)
+
+	_, err = d.DialContext(context.Background(), "tcp", "db:5432")
+	require.ErrorIs(t, err, errDialed)
+	require.False(t, hasDeadline)
+}
@@ -0,0 +1,87 @@
+package dbutil
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DumpExtraArgsParam is a URL query parameter with arguments which are appended to the
+// schema dump tool's command line, e.g. dump_extra_args=--exclude-table-data=audit_log.
+// The value is split into arguments like a shell command line (see SplitArgs), but is
+// never passed to a shell.
+const DumpExtraArgsParam = "dump_extra_args"
+
+// ErrInvalidDumpExtraArgs is returned if the dump_extra_args URL parameter can't be split
+// into arguments
+var ErrInvalidDumpExtraArgs = errors.New("invalid dump_extra_args parameter")
+
+// DumpExtraArgs returns the arguments listed by the dump_extra_args URL parameter
+func DumpExtraArgs(query url.Values) ([]string, error) {
+	args, err := SplitArgs(query.Get(DumpExtraArgsParam))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDumpExtraArgs, err)
+	}
+
+	return args, nil
+}
+
+// SplitArgs splits a command line into arguments like a POSIX shell, without expanding
+// variables or globs. Arguments are separated by unquoted whitespace. Single quotes
+// preserve their contents, double quotes preserve their contents except that a backslash
+// escapes " and \, and a backslash outside of quotes escapes the next character.
+func SplitArgs(s string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			arg.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case '"':
+			closed := false
+			for i++; i < len(s); i++ {
+				if s[i] == '"' {
+					closed = true
+					break
+				}
+				if s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+					i++
+				}
+				arg.WriteByte(s[i])
+			}
+			if !closed {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+		case '\\':
+			if i+1 == len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			arg.WriteByte(s[i])
+			inArg = true
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,50 @@
+package dbutil_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	cases := map[string][]string{
+		"":                                   {},
+		"  ":                                 {},
+		"--no-comments":                      {"--no-comments"},
+		" --exclude-table-data=logs  -T tmp": {"--exclude-table-data=logs", "-T", "tmp"},
+		`--exclude-table='audit log'`:        {"--exclude-table=audit log"},
+		`--exclude-table="a \"b\" \\c"`:      {`--exclude-table=a "b" \c`},
+		`--exclude-table="$HOME\n"`:          {`--exclude-table=$HOME\n`},
+		`--exclude-table=a\ b ''`:            {"--exclude-table=a b", ""},
+		"--exclude-table='*';rm\t-rf":        {"--exclude-table=*;rm", "-rf"},
+	}
+	for input, expected := range cases {
+		args, err := dbutil.SplitArgs(input)
+		require.NoError(t, err, input)
+		require.Equal(t, expected, args, input)
+	}
+
+	_, err := dbutil.SplitArgs("--exclude-table='logs")
+	require.EqualError(t, err, "unterminated single quote")
+	_, err = dbutil.SplitArgs(`--exclude-table="logs`)
+	require.EqualError(t, err, "unterminated double quote")
+	_, err = dbutil.SplitArgs(`--exclude-table=logs\`)
+	require.EqualError(t, err, "trailing backslash")
+}
+
+func TestDumpExtraArgs(t *testing.T) {
+	args, err := dbutil.DumpExtraArgs(url.Values{})
+	require.NoError(t, err)
+	require.Empty(t, args)
+
+	args, err = dbutil.DumpExtraArgs(url.Values{"dump_extra_args": {"--exclude-table-data=logs --no-comments"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"--exclude-table-data=logs", "--no-comments"}, args)
+
+	_, err = dbutil.DumpExtraArgs(url.Values{"dump_extra_args": {"'"}})
+	require.ErrorIs(t, err, dbutil.ErrInvalidDumpExtraArgs)
+	require.EqualError(t, err, "invalid dump_extra_args parameter: unterminated single quote")
+}
@@ -0,0 +1,59 @@
+package dbutil
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DumpWorkers is the number of introspection queries which drivers generating the
+// schema natively run concurrently, limited further by the connection pool size
+const DumpWorkers = 8
+
+// MapParallel calls fn for each item using up to workers goroutines, and returns the
+// results in the same order as items, so that output does not depend on scheduling. If
+// any call fails, remaining items are not started and the error of the first failed item
+// is returned.
+func MapParallel[T, R any](items []T, workers int, fn func(T) (R, error)) ([]R, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+	var failed atomic.Bool
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if failed.Load() {
+					continue
+				}
+				results[i], errs[i] = fn(items[i])
+				if errs[i] != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+
+	for i := range items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
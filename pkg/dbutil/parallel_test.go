@@ -0,0 +1,59 @@
+package dbutil_test
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapParallel(t *testing.T) {
+	items := []int{}
+	for i := 0; i < 50; i++ {
+		items = append(items, i)
+	}
+
+	var running, maxRunning atomic.Int32
+	results, err := dbutil.MapParallel(items, 4, func(i int) (string, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+
+		// later items finish first, results must still be in order
+		time.Sleep(time.Duration(50-i) * 10 * time.Microsecond)
+		return fmt.Sprintf("item %d", i), nil
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 50)
+	for i, result := range results {
+		require.Equal(t, fmt.Sprintf("item %d", i), result)
+	}
+	require.LessOrEqual(t, maxRunning.Load(), int32(4))
+
+	// no items
+	results, err = dbutil.MapParallel([]int{}, 4, func(i int) (string, error) {
+		return "", nil
+	})
+	require.NoError(t, err)
+	require.Empty(t, results)
+
+	// errors are returned
+	errTest := errors.New("test error")
+	_, err = dbutil.MapParallel(items, 0, func(i int) (string, error) {
+		if i == 10 {
+			return "", errTest
+		}
+		return "", nil
+	})
+	require.ErrorIs(t, err, errTest)
+}
@@ -0,0 +1,81 @@
+package dbutil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnknownDialect is returned when quoting for a URL scheme which no driver has
+// registered quoting rules for
+var ErrUnknownDialect = errors.New("no quoting rules registered for URL scheme")
+
+// Quoter quotes identifiers and string literals in the SQL dialect of a database. Each
+// driver registers its rules, so that tooling built on dbmate can quote names without
+// depending on a particular driver.
+type Quoter interface {
+	// QuoteIdentifier quotes a name (e.g. of a table or column), so that it can be used in
+	// a statement whatever characters it contains
+	QuoteIdentifier(name string) string
+	// QuoteLiteral quotes a string literal
+	QuoteLiteral(value string) string
+}
+
+var quoters = map[string]Quoter{}
+
+// RegisterQuoter registers the quoting rules for a given URL scheme
+func RegisterQuoter(q Quoter, scheme string) {
+	quoters[scheme] = q
+}
+
+// QuoteIdentifier quotes a name using the rules of the driver registered for a URL
+// scheme. The driver must be imported (e.g. _ "github.com/amacneil/dbmate/v2/pkg/driver/postgres").
+func QuoteIdentifier(scheme, name string) (string, error) {
+	q, ok := quoters[scheme]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownDialect, scheme)
+	}
+
+	return q.QuoteIdentifier(name), nil
+}
+
+// QuoteLiteral quotes a string literal using the rules of the driver registered for a
+// URL scheme
+func QuoteLiteral(scheme, value string) (string, error) {
+	q, ok := quoters[scheme]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownDialect, scheme)
+	}
+
+	return q.QuoteLiteral(value), nil
+}
+
+// QuoteDoubleQuotedIdentifier quotes a name with double quotes, as in standard SQL
+// (PostgreSQL, SQLite). Any part of the name after a null byte is removed, since it
+// would end the statement.
+func QuoteDoubleQuotedIdentifier(name string) string {
+	if end := strings.IndexRune(name, 0); end >= 0 {
+		name = name[:end]
+	}
+
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteBacktickIdentifier quotes a name with backticks, which are escaped by doubling
+// them (MySQL, Databricks)
+func QuoteBacktickIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// QuoteSingleQuotedLiteral quotes a string literal with single quotes, which are escaped
+// by doubling them, as in standard SQL (SQLite)
+func QuoteSingleQuotedLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// QuoteBackslashEscapedLiteral quotes a string literal with single quotes, escaping
+// quotes and backslashes with a backslash, for dialects where backslash is an escape
+// character (MySQL, ClickHouse, Spanner, Databricks)
+func QuoteBackslashEscapedLiteral(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
@@ -0,0 +1,65 @@
+package dbutil_test
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testQuoter struct{}
+
+func (testQuoter) QuoteIdentifier(name string) string {
+	return "[" + name + "]"
+}
+
+func (testQuoter) QuoteLiteral(value string) string {
+	return "<" + value + ">"
+}
+
+func TestQuote(t *testing.T) {
+	dbutil.RegisterQuoter(testQuoter{}, "quotetest")
+
+	t.Run("registered", func(t *testing.T) {
+		name, err := dbutil.QuoteIdentifier("quotetest", "users")
+		require.NoError(t, err)
+		require.Equal(t, "[users]", name)
+
+		value, err := dbutil.QuoteLiteral("quotetest", "abc")
+		require.NoError(t, err)
+		require.Equal(t, "<abc>", value)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := dbutil.QuoteIdentifier("unknown", "users")
+		require.ErrorIs(t, err, dbutil.ErrUnknownDialect)
+		require.EqualError(t, err, "no quoting rules registered for URL scheme: unknown")
+
+		_, err = dbutil.QuoteLiteral("unknown", "abc")
+		require.ErrorIs(t, err, dbutil.ErrUnknownDialect)
+	})
+}
+
+func TestQuoteDoubleQuotedIdentifier(t *testing.T) {
+	require.Equal(t, `"users"`, dbutil.QuoteDoubleQuotedIdentifier("users"))
+	require.Equal(t, `"my ""quoted"" table"`, dbutil.QuoteDoubleQuotedIdentifier(`my "quoted" table`))
+	require.Equal(t, `"abc"`, dbutil.QuoteDoubleQuotedIdentifier("abc\x00def"))
+}
+
+func TestQuoteBacktickIdentifier(t *testing.T) {
+	require.Equal(t, "`users`", dbutil.QuoteBacktickIdentifier("users"))
+	require.Equal(t, "`my``table`", dbutil.QuoteBacktickIdentifier("my`table"))
+}
+
+func TestQuoteSingleQuotedLiteral(t *testing.T) {
+	require.Equal(t, `'abc'`, dbutil.QuoteSingleQuotedLiteral("abc"))
+	require.Equal(t, `'it''s'`, dbutil.QuoteSingleQuotedLiteral("it's"))
+	require.Equal(t, `'a\b'`, dbutil.QuoteSingleQuotedLiteral(`a\b`))
+}
+
+func TestQuoteBackslashEscapedLiteral(t *testing.T) {
+	require.Equal(t, `'abc'`, dbutil.QuoteBackslashEscapedLiteral("abc"))
+	require.Equal(t, `'it\'s'`, dbutil.QuoteBackslashEscapedLiteral("it's"))
+	require.Equal(t, `'a\\b'`, dbutil.QuoteBackslashEscapedLiteral(`a\b`))
+}
@@ -0,0 +1,99 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrSessionSetup is returned if a session setup statement fails
+var ErrSessionSetup = errors.New("session setup failed")
+
+// OpenDB opens a database using connector, executing the session setup statements on
+// each connection before it is used. Since database/sql may open several connections,
+// and replaces those which fail, settings made on a single connection would otherwise
+// be lost.
+func OpenDB(connector driver.Connector, statements []string) *sql.DB {
+	if len(statements) == 0 {
+		return sql.OpenDB(connector)
+	}
+
+	return sql.OpenDB(&sessionConnector{Connector: connector, statements: statements})
+}
+
+// NewDSNConnector returns a connector which opens dsn using drv, for drivers which do not
+// provide a connector of their own
+func NewDSNConnector(drv driver.Driver, dsn string) (driver.Connector, error) {
+	if dc, ok := drv.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+
+	return &dsnConnector{driver: drv, dsn: dsn}, nil
+}
+
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// sessionConnector executes the session setup statements on each new connection
+type sessionConnector struct {
+	driver.Connector
+	statements []string
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range c.statements {
+		if err := execConn(ctx, conn, statement); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s: %w", ErrSessionSetup, statement, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// execConn executes a statement without arguments on a driver connection
+func execConn(ctx context.Context, conn driver.Conn, statement string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, statement, nil)
+		if !errors.Is(err, driver.ErrSkip) {
+			return err
+		}
+	}
+
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, statement)
+	} else {
+		stmt, err = conn.Prepare(statement)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+
+	if execer, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = execer.ExecContext(ctx, nil)
+	} else {
+		_, err = stmt.Exec(nil)
+	}
+
+	return err
+}
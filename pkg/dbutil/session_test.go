@@ -0,0 +1,49 @@
+package dbutil_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenDBSessionSetup(t *testing.T) {
+	// each connection to a private in-memory database is a new database, so the table
+	// only exists if the statements ran on the connection which is used
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	connector, err := dbutil.NewDSNConnector(db.Driver(), ":memory:")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db = dbutil.OpenDB(connector, []string{
+		"create table session_marker (x int)",
+		"insert into session_marker values (1)",
+	})
+	defer dbutil.MustClose(db)
+
+	ctx := context.Background()
+	conns := make([]*sql.Conn, 3)
+	for i := range conns {
+		conns[i], err = db.Conn(ctx)
+		require.NoError(t, err)
+
+		var count int
+		err = conns[i].QueryRowContext(ctx, "select count(*) from session_marker").Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	}
+	for _, conn := range conns {
+		require.NoError(t, conn.Close())
+	}
+
+	// failing statements fail the connection
+	db = dbutil.OpenDB(connector, []string{"select * from missing_table"})
+	defer dbutil.MustClose(db)
+	err = db.Ping()
+	require.ErrorIs(t, err, dbutil.ErrSessionSetup)
+	require.Contains(t, err.Error(), "select * from missing_table")
+}
@@ -0,0 +1,108 @@
+package dbutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// URL query parameters which configure TLS in the same way for every network driver.
+// They are not passed to the database connection.
+const (
+	// TLSCAParam is the path of a PEM file containing the CA certificates which the
+	// server certificate must be signed by
+	TLSCAParam = "tls_ca"
+	// SSLRootCertParam is an alias of TLSCAParam, using the postgres parameter name
+	SSLRootCertParam = "sslrootcert"
+	// ServerNameParam overrides the host name which is sent using SNI and expected in
+	// the server certificate, for example when connecting to an IP address or tunnel
+	ServerNameParam = "servername"
+)
+
+// TLSParams lists the TLS URL query parameters
+var TLSParams = []string{TLSCAParam, SSLRootCertParam, ServerNameParam}
+
+// ErrInvalidTLSCA is returned if the CA file does not contain any PEM certificates
+var ErrInvalidTLSCA = errors.New("no certificates found in CA file")
+
+// TLSCAFile returns the CA file specified by the tls_ca or sslrootcert parameter
+func TLSCAFile(query url.Values) string {
+	if file := query.Get(TLSCAParam); file != "" {
+		return file
+	}
+
+	return query.Get(SSLRootCertParam)
+}
+
+// TLSConfig returns a TLS configuration which verifies the server using the CA file and
+// server name specified in the URL, or nil if neither is set. If no server name is set,
+// ServerName is left empty for the driver to fill in from the host it connects to.
+func TLSConfig(u *url.URL) (*tls.Config, error) {
+	query := u.Query()
+	caFile := TLSCAFile(query)
+	serverName := query.Get(ServerNameParam)
+	if caFile == "" && serverName == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{ServerName: serverName}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTLSCA, caFile)
+		}
+	}
+
+	return config, nil
+}
+
+// DialFunc connects to an address, like net.Dialer.DialContext
+type DialFunc = func(ctx context.Context, network, address string) (net.Conn, error)
+
+// WithServerName replaces the host of a postgres connection string with the TLS server
+// name, for drivers such as lib/pq which send and verify the host they connect to as
+// the server name. The returned dial function connects to the original host when the
+// server name is dialed. Unix socket connection strings are returned unchanged.
+func WithServerName(connStr, serverName string, dial DialFunc) (string, DialFunc, error) {
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := u.Query()
+	host := u.Hostname()
+	if query.Get("host") != "" {
+		host = query.Get("host")
+		query.Set("host", serverName)
+	} else {
+		u.Host = net.JoinHostPort(serverName, u.Port())
+	}
+	if strings.HasPrefix(host, "/") {
+		// unix sockets do not use TLS
+		return connStr, dial, nil
+	}
+	u.RawQuery = query.Encode()
+
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+
+	return u.String(), func(ctx context.Context, network, address string) (net.Conn, error) {
+		if h, port, err := net.SplitHostPort(address); err == nil && h == serverName {
+			address = net.JoinHostPort(host, port)
+		}
+		return dial(ctx, network, address)
+	}, nil
+}
@@ -0,0 +1,137 @@
+package dbutil_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate returns a self-signed certificate for dnsName, and its PEM encoding
+func testCertificate(t *testing.T, dnsName string) (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: dnsName},
+		DNSNames:              []string{dnsName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestTLSCAFile(t *testing.T) {
+	require.Equal(t, "", dbutil.TLSCAFile(url.Values{}))
+	require.Equal(t, "/a.pem", dbutil.TLSCAFile(url.Values{"sslrootcert": {"/a.pem"}}))
+	require.Equal(t, "/b.pem", dbutil.TLSCAFile(url.Values{"sslrootcert": {"/a.pem"}, "tls_ca": {"/b.pem"}}))
+}
+
+func TestTLSConfig(t *testing.T) {
+	cert, certPEM := testCertificate(t, "db.internal")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, certPEM, 0o600))
+
+	t.Run("not configured", func(t *testing.T) {
+		config, err := dbutil.TLSConfig(dbutil.MustParseURL("postgres://myhost/foo?sslmode=require"))
+		require.NoError(t, err)
+		require.Nil(t, config)
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		config, err := dbutil.TLSConfig(dbutil.MustParseURL("mysql://myhost/foo?servername=db.internal"))
+		require.NoError(t, err)
+		require.Equal(t, "db.internal", config.ServerName)
+		require.Nil(t, config.RootCAs)
+	})
+
+	t.Run("invalid CA file", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "invalid.pem")
+		require.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0o600))
+
+		_, err := dbutil.TLSConfig(dbutil.MustParseURL("mysql://myhost/foo?tls_ca=" + url.QueryEscape(invalid)))
+		require.ErrorIs(t, err, dbutil.ErrInvalidTLSCA)
+
+		_, err = dbutil.TLSConfig(dbutil.MustParseURL("mysql://myhost/foo?tls_ca=/missing.pem"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("handshake", func(t *testing.T) {
+		config, err := dbutil.TLSConfig(dbutil.MustParseURL("mysql://10.0.0.1/foo?servername=db.internal&sslrootcert=" +
+			url.QueryEscape(caFile)))
+		require.NoError(t, err)
+
+		client, server := net.Pipe()
+		defer client.Close()
+		serverName := make(chan string, 1)
+		go func() {
+			defer server.Close()
+			tlsServer := tls.Server(server, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					serverName <- hello.ServerName
+					return nil, nil
+				},
+			})
+			_ = tlsServer.Handshake()
+		}()
+
+		// the certificate is verified against the CA file and server name
+		require.NoError(t, tls.Client(client, config).Handshake())
+		require.Equal(t, "db.internal", <-serverName)
+	})
+}
+
+func TestWithServerName(t *testing.T) {
+	dialed := []string{}
+	dial := func(_ context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return nil, errors.New("custom dialer")
+	}
+
+	connStr, dial2, err := dbutil.WithServerName("postgres://myhost:1234/foo", "db.internal", dial)
+	require.NoError(t, err)
+	require.Equal(t, "postgres://db.internal:1234/foo", connStr)
+
+	// the server name is dialed at the original host
+	_, err = dial2(context.Background(), "tcp", "db.internal:1234")
+	require.ErrorContains(t, err, "custom dialer")
+	_, err = dial2(context.Background(), "tcp", "other:1234")
+	require.ErrorContains(t, err, "custom dialer")
+	require.Equal(t, []string{"myhost:1234", "other:1234"}, dialed)
+
+	// the host can be set as a parameter
+	connStr, _, err = dbutil.WithServerName("postgres://:1234/foo?host=myhost", "db.internal", nil)
+	require.NoError(t, err)
+	require.Equal(t, "postgres://:1234/foo?host=db.internal", connStr)
+
+	// unix sockets are unchanged
+	connStr, _, err = dbutil.WithServerName("postgres://:5432/foo?host=%2Ftmp", "db.internal", nil)
+	require.NoError(t, err)
+	require.Equal(t, "postgres://:5432/foo?host=%2Ftmp", connStr)
+}
@@ -0,0 +1,434 @@
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "bigquery")
+	dbutil.RegisterQuoter(&Driver{}, "bigquery")
+}
+
+// ErrInvalidURL is returned for URLs which are not in the form
+// bigquery://project/dataset?location=<location>
+var ErrInvalidURL = errors.New("invalid bigquery url, expected bigquery://project/dataset")
+
+// Driver provides top level database functions
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	log                 io.Writer
+	waitOperation       dbmate.WaitOperationFunc
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		log:                 config.Log,
+		waitOperation:       config.WaitOperation,
+	}
+}
+
+// dataset identifies the dataset of a bigquery URL, which is managed as the database
+type dataset struct {
+	project  string
+	id       string
+	location string
+}
+
+// parseURL returns the dataset of a URL in the form
+// bigquery://project/dataset?location=<location>
+func parseURL(u *url.URL) (dataset, error) {
+	ds := dataset{
+		project:  u.Host,
+		id:       strings.Trim(u.Path, "/"),
+		location: u.Query().Get("location"),
+	}
+	if ds.project == "" || ds.id == "" || strings.Contains(ds.id, "/") {
+		return dataset{}, ErrInvalidURL
+	}
+
+	return ds, nil
+}
+
+// newClient connects to the project of ds. Dbmate authenticates using Application
+// Default Credentials, or connects to the emulator given by BIGQUERY_EMULATOR_HOST.
+func (drv *Driver) newClient(ctx context.Context, ds dataset) (*bigquery.Client, error) {
+	var options []option.ClientOption
+	if host := os.Getenv("BIGQUERY_EMULATOR_HOST"); host != "" {
+		options = append(options,
+			option.WithEndpoint("http://"+host),
+			option.WithoutAuthentication(),
+		)
+	}
+
+	client, err := bigquery.NewClient(ctx, ds.project, options...)
+	if err != nil {
+		return nil, err
+	}
+	client.Location = ds.location
+
+	return client, nil
+}
+
+// Open creates a new database connection, using the dataset as the default dataset
+func (drv *Driver) Open() (*sql.DB, error) {
+	ds, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(&connector{drv: drv, ds: ds}), nil
+}
+
+// waitJob waits for a query job to complete, reporting its progress
+func (drv *Driver) waitJob(ctx context.Context, job *bigquery.Job) error {
+	if drv.waitOperation == nil {
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return status.Err()
+	}
+
+	return drv.waitOperation(ctx, &jobOperation{job: job})
+}
+
+// datasetHandle returns a client for the project, and a handle of the dataset
+func (drv *Driver) datasetHandle(ctx context.Context) (*bigquery.Client, *bigquery.Dataset, dataset, error) {
+	ds, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return nil, nil, ds, err
+	}
+
+	client, err := drv.newClient(ctx, ds)
+	if err != nil {
+		return nil, nil, ds, err
+	}
+
+	return client, client.Dataset(ds.id), ds, nil
+}
+
+// isNotFound returns true if err is a 404 response from the BigQuery API
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// CreateDatabase creates the dataset
+func (drv *Driver) CreateDatabase() error {
+	ctx := context.Background()
+	client, handle, ds, err := drv.datasetHandle(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(client)
+
+	fmt.Fprintf(drv.log, "Creating: %s.%s\n", ds.project, ds.id)
+
+	return handle.Create(ctx, &bigquery.DatasetMetadata{Location: ds.location})
+}
+
+// DropDatabase drops the dataset, and every table within it
+func (drv *Driver) DropDatabase() error {
+	ctx := context.Background()
+	client, handle, ds, err := drv.datasetHandle(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(client)
+
+	fmt.Fprintf(drv.log, "Dropping: %s.%s\n", ds.project, ds.id)
+
+	err = handle.DeleteWithContents(ctx)
+	if isNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
+	migrationsTable := drv.quotedMigrationsTableName()
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		fmt.Sprintf("select version from %s order by version asc", migrationsTable))
+	if err != nil {
+		return err
+	}
+
+	// build migrations table data
+	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n")
+
+	if len(migrations) > 0 {
+		for i, version := range migrations {
+			migrations[i] = drv.QuoteLiteral(version)
+		}
+		buf.WriteString("INSERT INTO " + migrationsTable + " (version) VALUES\n    (" +
+			strings.Join(migrations, "),\n    (") +
+			");\n")
+	}
+
+	return nil
+}
+
+// DumpSchema returns the DDL of each table and view in the dataset, as reported by
+// INFORMATION_SCHEMA.TABLES, with tables before views. Names are not qualified by the
+// project and dataset, so that dumps of different environments can be compared.
+func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
+	ds, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	statements, err := dbutil.QueryColumn(db, "select ddl from "+drv.informationSchema(ds, "tables")+
+		" order by case when table_type = 'BASE TABLE' then 0 else 1 end, table_name")
+	if err != nil {
+		return nil, err
+	}
+
+	unqualify := strings.NewReplacer(
+		"`"+ds.project+"."+ds.id+".", "`",
+		drv.QuoteIdentifier(ds.project)+"."+drv.QuoteIdentifier(ds.id)+".", "",
+	)
+
+	var buf bytes.Buffer
+	for _, stmt := range statements {
+		buf.WriteString(strings.TrimRight(unqualify.Replace(stmt), "\n;") + ";\n\n")
+	}
+
+	if err := drv.schemaMigrationsDump(db, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DatabaseExists determines whether the dataset exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	ctx := context.Background()
+	client, handle, _, err := drv.datasetHandle(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer dbutil.MustClose(client)
+
+	_, err = handle.Metadata(ctx)
+	if isNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	ds, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return false, err
+	}
+
+	count := 0
+	err = db.QueryRow("select count(*) from " + drv.informationSchema(ds, "tables") +
+		" where table_name = " + drv.QuoteLiteral(drv.migrationsTableName)).Scan(&count)
+
+	return count > 0, err
+}
+
+// CreateMigrationsTable creates the schema_migrations table
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s (version string not null)",
+		drv.quotedMigrationsTableName()))
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+
+	versions, err := dbutil.QueryColumn(db, query)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := map[string]bool{}
+	for _, version := range versions {
+		migrations[version] = true
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version) values (%s)",
+		drv.quotedMigrationsTableName(), drv.QuoteLiteral(version)))
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(fmt.Sprintf("delete from %s where version = %s",
+		drv.quotedMigrationsTableName(), drv.QuoteLiteral(version)))
+
+	return err
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s ("+
+		"version string not null, "+
+		"direction string not null, "+
+		"checksum string not null, "+
+		"started_at timestamp not null, "+
+		"finished_at timestamp not null, "+
+		"duration_ms int64 not null, "+
+		"hostname string not null, "+
+		"os_user string not null, "+
+		"dbmate_version string not null"+
+		")",
+		drv.quotedAuditTableName()))
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version, direction, checksum, started_at, "+
+		"finished_at, duration_ms, hostname, os_user, dbmate_version) "+
+		"values (%s, %s, %s, %s, %s, %d, %s, %s, %s)",
+		drv.quotedAuditTableName(),
+		drv.QuoteLiteral(record.Version), drv.QuoteLiteral(record.Direction), drv.QuoteLiteral(record.Checksum),
+		drv.timestampLiteral(record.StartedAt), drv.timestampLiteral(record.FinishedAt),
+		record.Duration().Milliseconds(), drv.QuoteLiteral(record.Hostname), drv.QuoteLiteral(record.User),
+		drv.QuoteLiteral(record.DbmateVersion)))
+
+	return err
+}
+
+// timestampLiteral returns a UTC timestamp literal with microsecond precision
+func (drv *Driver) timestampLiteral(t time.Time) string {
+	return "timestamp " + drv.QuoteLiteral(t.UTC().Format("2006-01-02 15:04:05.000000")+"+00")
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	ds, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return err
+	}
+
+	tables, err := dbutil.QueryColumn(db, "select table_name from "+drv.informationSchema(ds, "tables")+
+		" where table_type = 'BASE TABLE' and table_name not in ("+drv.QuoteLiteral(drv.migrationsTableName)+", "+
+		drv.QuoteLiteral(drv.migrationsTableName+dbmate.AuditTableSuffix)+")")
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := db.Exec("truncate table " + drv.QuoteIdentifier(table)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping verifies a connection to the project. It does not verify whether the specified
+// dataset exists.
+func (drv *Driver) Ping() error {
+	ds, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return err
+	}
+
+	// connect without a default dataset, which may not exist yet
+	ds.id = ""
+	db := sql.OpenDB(&connector{drv: drv, ds: ds})
+	defer dbutil.MustClose(db)
+
+	return db.Ping()
+}
+
+// BackslashEscapes returns true, since quoted strings use backslash as an escape character
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
+// Transactions returns false, since BigQuery only supports transactions within a single
+// job, so each statement is committed as soon as its job completes
+func (drv *Driver) Transactions() bool {
+	return false
+}
+
+// TransactionalDDL returns false, since each statement is committed as soon as its job
+// completes
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// errorLocationRegexp matches the location reported by syntax errors, e.g.
+// Syntax error: Unexpected identifier "not_valid_sql" at [2:1]
+var errorLocationRegexp = regexp.MustCompile(`at \[(\d+):(\d+)\]`)
+
+// Return a normalized version of the driver-specific error type.
+func (drv *Driver) QueryError(query string, err error) error {
+	queryErr := &dbmate.QueryError{Err: err, Query: query}
+	if match := errorLocationRegexp.FindStringSubmatch(err.Error()); match != nil {
+		queryErr.Line, _ = strconv.Atoi(match[1])
+		queryErr.Column, _ = strconv.Atoi(match[2])
+	}
+
+	return queryErr
+}
+
+// informationSchema returns the name of an INFORMATION_SCHEMA view of the dataset
+func (drv *Driver) informationSchema(ds dataset, view string) string {
+	return drv.QuoteIdentifier(ds.project) + "." + drv.QuoteIdentifier(ds.id) + ".INFORMATION_SCHEMA." + view
+}
+
+func (drv *Driver) quotedMigrationsTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
+}
+
+// QuoteIdentifier quotes a name with backticks
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteBacktickIdentifier(name)
+}
+
+// QuoteLiteral quotes a string literal, escaping quotes and backslashes with a backslash
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteBackslashEscapedLiteral(value)
+}
@@ -0,0 +1,166 @@
+package bigquery
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBigQueryDriver(t *testing.T) *Driver {
+	value := os.Getenv("BIGQUERY_TEST_URL")
+	if value == "" {
+		t.Skip("BIGQUERY_TEST_URL is not set")
+	}
+
+	drv, err := dbmate.New(dbutil.MustParseURL(value)).Driver()
+	require.NoError(t, err)
+
+	return drv.(*Driver)
+}
+
+func prepTestBigQueryDB(t *testing.T) (*Driver, *sql.DB) {
+	drv := testBigQueryDriver(t)
+
+	// drop any existing dataset
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create dataset
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// connect database
+	db, err := drv.Open()
+	require.NoError(t, err)
+
+	return drv, db
+}
+
+func TestGetDriver(t *testing.T) {
+	db := dbmate.New(dbutil.MustParseURL("bigquery://my-project/myapp?location=EU"))
+	drvInterface, err := db.Driver()
+	require.NoError(t, err)
+
+	// driver should have URL and default migrations table set
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.Equal(t, db.DatabaseURL.String(), drv.databaseURL.String())
+	require.Equal(t, "schema_migrations", drv.migrationsTableName)
+}
+
+func TestParseURL(t *testing.T) {
+	ds, err := parseURL(dbutil.MustParseURL("bigquery://my-project/myapp?location=EU"))
+	require.NoError(t, err)
+	require.Equal(t, dataset{project: "my-project", id: "myapp", location: "EU"}, ds)
+
+	ds, err = parseURL(dbutil.MustParseURL("bigquery://my-project/myapp/"))
+	require.NoError(t, err)
+	require.Equal(t, dataset{project: "my-project", id: "myapp"}, ds)
+
+	for _, invalid := range []string{
+		"bigquery://my-project",
+		"bigquery://my-project/",
+		"bigquery:///myapp",
+		"bigquery://my-project/myapp/extra",
+	} {
+		_, err := parseURL(dbutil.MustParseURL(invalid))
+		require.ErrorIs(t, err, ErrInvalidURL, invalid)
+	}
+}
+
+func TestQuotedMigrationsTableName(t *testing.T) {
+	drv := &Driver{migrationsTableName: "schema_migrations"}
+	require.Equal(t, "`schema_migrations`", drv.quotedMigrationsTableName())
+	require.Equal(t, "`schema_migrations_audit`", drv.quotedAuditTableName())
+	require.Equal(t, "`my-project`.`myapp`.INFORMATION_SCHEMA.tables",
+		drv.informationSchema(dataset{project: "my-project", id: "myapp"}, "tables"))
+	require.Equal(t, `'it\'s'`, drv.QuoteLiteral("it's"))
+}
+
+func TestTimestampLiteral(t *testing.T) {
+	drv := &Driver{}
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.FixedZone("EST", -5*60*60))
+	require.Equal(t, "timestamp '2024-01-02 08:04:05.000006+00'", drv.timestampLiteral(ts))
+}
+
+func TestQueryError(t *testing.T) {
+	drv := &Driver{}
+
+	err := drv.QueryError("select 1;\nnot_valid_sql", errors.New(
+		`googleapi: Error 400: Syntax error: Unexpected identifier "not_valid_sql" at [2:1], invalidQuery`))
+	line, column := err.(*dbmate.QueryError).Location()
+	require.Equal(t, 2, line)
+	require.Equal(t, 1, column)
+}
+
+func TestDriverValue(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.Equal(t, "abc", driverValue("abc"))
+	require.Equal(t, int64(1), driverValue(int64(1)))
+	require.Equal(t, ts, driverValue(ts))
+	require.Nil(t, driverValue(nil))
+	require.Equal(t, "2024-01-02", driverValue(civil.Date{Year: 2024, Month: 1, Day: 2}))
+}
+
+func TestJobOperationProgress(t *testing.T) {
+	op := &jobOperation{}
+	require.Equal(t, -1, op.Progress())
+
+	op.status = &bigquery.JobStatus{Statistics: &bigquery.JobStatistics{
+		Details: &bigquery.QueryStatistics{Timeline: []*bigquery.QueryTimelineSample{
+			{CompletedUnits: 1, ActiveUnits: 2, PendingUnits: 7},
+			{CompletedUnits: 3, ActiveUnits: 2, PendingUnits: 5},
+		}},
+	}}
+	require.Equal(t, 30, op.Progress())
+}
+
+func TestBigQueryMigrations(t *testing.T) {
+	drv, db := prepTestBigQueryDB(t)
+	defer dbutil.MustClose(db)
+
+	exists, err := drv.DatabaseExists()
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	exists, err = drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc2")
+	require.NoError(t, err)
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true, "abc2": true}, migrations)
+
+	err = drv.DeleteMigration(db, "abc2")
+	require.NoError(t, err)
+	migrations, err = drv.SelectMigrations(db, 1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE `schema_migrations`")
+	require.Contains(t, string(schema), "INSERT INTO `schema_migrations` (version) VALUES\n    ('abc1');\n")
+
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+	exists, err = drv.DatabaseExists()
+	require.NoError(t, err)
+	require.False(t, exists)
+}
@@ -0,0 +1,259 @@
+package bigquery
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// BigQuery has no database/sql driver, so dbmate provides a minimal one. Each statement
+// is submitted as a query job, which dbmate polls using DriverConfig.WaitOperation, so
+// that the progress of slow statements (such as backfilling a new column) is reported.
+// BigQuery only supports transactions within a single multi-statement job, so the driver
+// reports that it does not support them, and each job is committed as soon as it
+// completes.
+
+// connector opens connections to a project, using ds (if it has an id) as the default
+// dataset for unqualified table names
+type connector struct {
+	drv *Driver
+	ds  dataset
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	client, err := c.drv.newClient(ctx, c.ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{drv: c.drv, ds: c.ds, client: client}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return sqlDriver{}
+}
+
+// sqlDriver is only used by database/sql to describe the connector
+type sqlDriver struct{}
+
+func (sqlDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("bigquery connections must be opened with a connector")
+}
+
+// conn runs each statement as a query job
+type conn struct {
+	drv    *Driver
+	ds     dataset
+	client *bigquery.Client
+}
+
+var (
+	_ driver.ExecerContext  = (*conn)(nil)
+	_ driver.QueryerContext = (*conn)(nil)
+	_ driver.Pinger         = (*conn)(nil)
+)
+
+// run submits a query job, and waits for it to complete
+func (c *conn) run(ctx context.Context, query string, args []driver.NamedValue) (*bigquery.Job, error) {
+	q := c.client.Query(query)
+	if c.ds.id != "" {
+		q.DefaultProjectID = c.ds.project
+		q.DefaultDatasetID = c.ds.id
+	}
+	for _, arg := range args {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{Name: arg.Name, Value: arg.Value})
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, c.drv.waitJob(ctx, job)
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	job, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var affected int64
+	if status := job.LastStatus(); status != nil && status.Statistics != nil {
+		if stats, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+			affected = stats.NumDMLAffectedRows
+		}
+	}
+
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	job, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := job.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the schema of the results is only known once the first page has been read
+	r := &rows{it: it}
+	r.next, r.err = r.read()
+
+	return r, nil
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("bigquery does not support transactions across statements")
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	_, err := c.run(ctx, "select 1", nil)
+	return err
+}
+
+func (c *conn) Close() error {
+	return c.client.Close()
+}
+
+// stmt runs its query each time it is executed, since BigQuery does not prepare statements
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+
+	return named
+}
+
+// rows reads the results of a query job
+type rows struct {
+	it   *bigquery.RowIterator
+	next []bigquery.Value
+	err  error
+}
+
+func (r *rows) read() ([]bigquery.Value, error) {
+	var row []bigquery.Value
+	if err := r.it.Next(&row); err != nil {
+		if errors.Is(err, iterator.Done) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	return row, nil
+}
+
+func (r *rows) Columns() []string {
+	columns := make([]string, len(r.it.Schema))
+	for i, field := range r.it.Schema {
+		columns[i] = field.Name
+	}
+
+	return columns
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	for i, value := range r.next {
+		dest[i] = driverValue(value)
+	}
+	r.next, r.err = r.read()
+
+	return nil
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+// driverValue converts a BigQuery value to one of the types supported by database/sql.
+// Values without an equivalent type (such as dates, numerics, and records) are returned
+// as strings.
+func driverValue(value bigquery.Value) driver.Value {
+	switch v := value.(type) {
+	case nil, int64, float64, bool, string, []byte, time.Time:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jobOperation reports the progress of a query job, which can take minutes for
+// statements which rewrite a large table
+type jobOperation struct {
+	job    *bigquery.Job
+	status *bigquery.JobStatus
+}
+
+func (o *jobOperation) Name() string {
+	return "job " + o.job.ID()
+}
+
+func (o *jobOperation) Poll(ctx context.Context) (bool, error) {
+	status, err := o.job.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	o.status = status
+
+	return status.Done(), status.Err()
+}
+
+// Progress returns the proportion of the units of work of the query which have
+// completed, as of the latest sample of its timeline
+func (o *jobOperation) Progress() int {
+	if o.status == nil || o.status.Statistics == nil {
+		return -1
+	}
+	stats, ok := o.status.Statistics.Details.(*bigquery.QueryStatistics)
+	if !ok || len(stats.Timeline) == 0 {
+		return -1
+	}
+
+	sample := stats.Timeline[len(stats.Timeline)-1]
+	total := sample.CompletedUnits + sample.ActiveUnits + sample.PendingUnits
+	if total == 0 {
+		return -1
+	}
+
+	return int(sample.CompletedUnits * 100 / total)
+}
@@ -2,12 +2,17 @@ package clickhouse
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
@@ -18,14 +23,17 @@ import (
 
 func init() {
 	dbmate.RegisterDriver(NewDriver, "clickhouse")
+	dbutil.RegisterQuoter(&Driver{}, "clickhouse")
 }
 
 // Driver provides top level database functions
 type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
+	dialContext         dbmate.DialContextFunc
 	log                 io.Writer
 	clusterParameters   *ClusterParameters
+	tableParameters     *MigrationsTableParameters
 }
 
 // NewDriver initializes the driver
@@ -33,8 +41,10 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 	return &Driver{
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
+		dialContext:         config.DialContext,
 		log:                 config.Log,
 		clusterParameters:   ExtractClusterParametersFromURL(config.DatabaseURL),
+		tableParameters:     ExtractMigrationsTableParametersFromURL(config.DatabaseURL),
 	}
 }
 
@@ -74,16 +84,74 @@ func connectionString(initialURL *url.URL) string {
 		query.Del("database")
 	}
 
+	for _, param := range dbutil.TLSParams {
+		query.Del(param)
+	}
+
 	u.RawQuery = query.Encode()
 
 	u = ClearClusterParametersFromURL(u)
+	u = ClearMigrationsTableParametersFromURL(u)
 
 	return u.String()
 }
 
+// openDB opens a connection string, using the custom dialer and TLS configuration if
+// they were supplied
+func (drv *Driver) openDB(connStr string) (*sql.DB, error) {
+	tlsConfig, err := dbutil.TLSConfig(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if drv.dialContext == nil && tlsConfig == nil {
+		return sql.Open("clickhouse", connStr)
+	}
+
+	options, err := clickhouse.ParseDSN(connStr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		// a CA file or server name implies secure=true
+		options.TLS = tlsConfig
+	}
+	if drv.dialContext != nil {
+		tlsConfig := options.TLS
+		options.DialContext = func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, err := drv.dialContext(ctx, "tcp", addr)
+			if err != nil || tlsConfig == nil {
+				return conn, err
+			}
+
+			// clickhouse-go does not use TLS when a custom dialer is supplied
+			return clientTLS(ctx, conn, addr, tlsConfig)
+		}
+	}
+
+	return clickhouse.OpenDB(options), nil
+}
+
+// clientTLS performs a TLS handshake over conn, verifying the server name if set, or
+// otherwise the host of addr
+func clientTLS(ctx context.Context, conn net.Conn, addr string, config *tls.Config) (net.Conn, error) {
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(addr)
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("clickhouse", connectionString(drv.databaseURL))
+	return drv.openDB(connectionString(drv.databaseURL))
 }
 
 func (drv *Driver) openClickHouseDB() (*sql.DB, error) {
@@ -96,14 +164,13 @@ func (drv *Driver) openClickHouseDB() (*sql.DB, error) {
 	// connect to clickhouse database
 	clickhouseURL.Path = "/default"
 
-	return sql.Open("clickhouse", clickhouseURL.String())
+	return drv.openDB(clickhouseURL.String())
 }
 
 func (drv *Driver) onClusterClause() string {
 	clusterClause := ""
 	if drv.clusterParameters.OnCluster {
-		escapedClusterMacro := drv.escapeString(drv.clusterParameters.ClusterMacro)
-		clusterClause = fmt.Sprintf(" ON CLUSTER '%s'", escapedClusterMacro)
+		clusterClause = " ON CLUSTER " + drv.QuoteLiteral(drv.clusterParameters.ClusterMacro)
 	}
 	return clusterClause
 }
@@ -116,9 +183,17 @@ func (drv *Driver) databaseName() string {
 	return name
 }
 
+// DatabaseLocation returns the host and database of the URL, which defaults to the
+// default database if it is not specified
+func (drv *Driver) DatabaseLocation() (string, string) {
+	return drv.databaseURL.Host, drv.databaseName()
+}
+
 var clickhouseValidIdentifier = regexp.MustCompile(`^[a-zA-Z_][0-9a-zA-Z_]*$`)
 
-func (drv *Driver) quoteIdentifier(str string) string {
+// QuoteIdentifier quotes a name with double quotes, unless it is a valid identifier
+// without them
+func (drv *Driver) QuoteIdentifier(str string) string {
 	if clickhouseValidIdentifier.MatchString(str) {
 		return str
 	}
@@ -128,13 +203,12 @@ func (drv *Driver) quoteIdentifier(str string) string {
 	return fmt.Sprintf(`"%s"`, str)
 }
 
-func (drv *Driver) escapeString(str string) string {
-	quoteEscaper := strings.NewReplacer(`'`, `\'`, `\`, `\\`)
-	str = quoteEscaper.Replace(str)
-	return str
+// QuoteLiteral quotes a string literal, escaping quotes and backslashes with a backslash
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteBackslashEscapedLiteral(value)
 }
 
-// CreateDatabase creates the specified database
+// CreateDatabase creates the specified database (if it does not already exist)
 func (drv *Driver) CreateDatabase() error {
 	name := drv.databaseName()
 	fmt.Fprintf(drv.log, "Creating: %s\n", name)
@@ -145,7 +219,8 @@ func (drv *Driver) CreateDatabase() error {
 	}
 	defer dbutil.MustClose(db)
 
-	q := fmt.Sprintf("CREATE DATABASE %s%s", drv.quoteIdentifier(name), drv.onClusterClause())
+	// another process may have created the database concurrently
+	q := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s%s", drv.QuoteIdentifier(name), drv.onClusterClause())
 
 	_, err = db.Exec(q)
 
@@ -163,29 +238,111 @@ func (drv *Driver) DropDatabase() error {
 	}
 	defer dbutil.MustClose(db)
 
-	q := fmt.Sprintf("DROP DATABASE IF EXISTS %s%s", drv.quoteIdentifier(name), drv.onClusterClause())
+	q := fmt.Sprintf("DROP DATABASE IF EXISTS %s%s", drv.QuoteIdentifier(name), drv.onClusterClause())
 
 	_, err = db.Exec(q)
 
 	return err
 }
 
+// dumpObject is a table, view, or dictionary in the schema dump
+type dumpObject struct {
+	name   string
+	engine string
+}
+
+// dumpRank orders objects in the schema dump so that each is created after the objects
+// it depends on: tables, then dictionaries (which may read from tables), then views and
+// materialized views (which read from, and write to, tables and dictionaries)
+func dumpRank(engine string) int {
+	switch engine {
+	case "Dictionary":
+		return 1
+	case "View":
+		return 2
+	case "MaterializedView":
+		return 3
+	default:
+		return 0
+	}
+}
+
+// sortDumpObjects sorts objects by rank, then name
+func sortDumpObjects(objects []dumpObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		ri, rj := dumpRank(objects[i].engine), dumpRank(objects[j].engine)
+		if ri != rj {
+			return ri < rj
+		}
+		return objects[i].name < objects[j].name
+	})
+}
+
+// createDatabaseStatement returns the statement which creates the database, including
+// its engine and settings from the output of show create database
+func (drv *Driver) createDatabaseStatement(showCreate, databaseName string) string {
+	stmt := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s%s", drv.QuoteIdentifier(databaseName), drv.onClusterClause())
+	if i := strings.Index(showCreate, "\n"); i >= 0 {
+		stmt += showCreate[i:]
+	}
+
+	return stmt
+}
+
+// createFunctionRegexp matches the start of a user defined function definition
+var createFunctionRegexp = regexp.MustCompile(`(?i)^CREATE\s+FUNCTION\s+`)
+
 func (drv *Driver) schemaDump(db *sql.DB, buf *bytes.Buffer, databaseName string) error {
+	showCreate, err := dbutil.QueryValue(db, "show create database "+drv.QuoteIdentifier(databaseName))
+	if err != nil {
+		return err
+	}
 	buf.WriteString("\n--\n-- Database schema\n--\n\n")
-	buf.WriteString(fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s%s;\n\n", drv.quoteIdentifier(databaseName), drv.onClusterClause()))
+	buf.WriteString(drv.createDatabaseStatement(showCreate, databaseName) + ";\n\n")
 
-	tables, err := dbutil.QueryColumn(db, "show tables")
+	// user defined functions are not part of a database, but tables and views may use them
+	functions, err := dbutil.QueryColumn(db, "select create_query from system.functions "+
+		"where origin = 'SQLUserDefined' order by name")
 	if err != nil {
 		return err
 	}
-	sort.Strings(tables)
+	for _, function := range functions {
+		buf.WriteString(createFunctionRegexp.ReplaceAllString(function, "CREATE FUNCTION IF NOT EXISTS ") + ";\n\n")
+	}
 
-	for _, table := range tables {
-		var clause string
-		err = db.QueryRow("show create table " + drv.quoteIdentifier(table)).Scan(&clause)
-		if err != nil {
+	// inner tables of materialized views are created along with the view
+	rows, err := db.Query("select name, engine from system.tables " +
+		"where database = currentDatabase() and not is_temporary and not startsWith(name, '.inner')")
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(rows)
+
+	objects := []dumpObject{}
+	for rows.Next() {
+		var object dumpObject
+		if err := rows.Scan(&object.name, &object.engine); err != nil {
 			return err
 		}
+		objects = append(objects, object)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	sortDumpObjects(objects)
+
+	// each object is introspected separately, so the queries are run concurrently
+	clauses, err := dbutil.MapParallel(objects, dbutil.DumpWorkers, func(object dumpObject) (string, error) {
+		if object.engine == "Dictionary" {
+			return dbutil.QueryValue(db, "show create dictionary "+drv.QuoteIdentifier(object.name))
+		}
+		return dbutil.QueryValue(db, "show create table "+drv.QuoteIdentifier(object.name))
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, clause := range clauses {
 		buf.WriteString(clause + ";\n\n")
 	}
 	return nil
@@ -195,17 +352,13 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
 	migrationsTable := drv.quotedMigrationsTableName()
 
 	// load applied migrations
-	migrations, err := dbutil.QueryColumn(db,
-		fmt.Sprintf("select version from %s final ", migrationsTable)+
-			"where applied order by version asc",
-	)
+	migrations, err := dbutil.QueryColumn(db, drv.appliedMigrationsQuery("asc"))
 	if err != nil {
 		return err
 	}
 
-	quoter := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
 	for i := range migrations {
-		migrations[i] = "'" + quoter.Replace(migrations[i]) + "'"
+		migrations[i] = drv.QuoteLiteral(migrations[i])
 	}
 
 	// build schema migrations table data
@@ -273,22 +426,65 @@ func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
 
 // CreateMigrationsTable creates the schema migrations table
 func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	tableClauses, err := drv.tableParameters.tableClauses()
+	if err != nil {
+		return err
+	}
+
 	engineClause := "ReplacingMergeTree(ts)"
-	if drv.clusterParameters.OnCluster {
-		escapedZooPath := drv.escapeString(drv.clusterParameters.ZooPath)
-		escapedReplicaMacro := drv.escapeString(drv.clusterParameters.ReplicaMacro)
-		engineClause = fmt.Sprintf("ReplicatedReplacingMergeTree('%s', '%s', ts)", escapedZooPath, escapedReplicaMacro)
+	if drv.tableParameters.Engine != "" {
+		engineClause = drv.tableParameters.Engine
+	} else if drv.clusterParameters.OnCluster {
+		engineClause = fmt.Sprintf("ReplicatedReplacingMergeTree(%s, %s, ts)",
+			drv.QuoteLiteral(drv.clusterParameters.ZooPath), drv.QuoteLiteral(drv.clusterParameters.ReplicaMacro))
 	}
 
-	_, err := db.Exec(fmt.Sprintf(`
+	_, err = db.Exec(fmt.Sprintf(`
 		create table if not exists %s%s (
 			version String,
 			ts DateTime default now(),
 			applied UInt8 default 1
 		) engine = %s
-		primary key version
-		order by version
-	`, drv.quotedMigrationsTableName(), drv.onClusterClause(), engineClause))
+		%s
+	`, drv.quotedMigrationsTableName(), drv.onClusterClause(), engineClause, tableClauses))
+
+	return err
+}
+
+// appliedMigrationsQuery returns a query which selects the versions of applied
+// migrations in the specified order. Rolling back a migration inserts a row with
+// applied = 0, so only the latest row of each version is considered.
+func (drv *Driver) appliedMigrationsQuery(order string) string {
+	if drv.tableParameters.Replacing() {
+		return fmt.Sprintf("select version from %s final where applied order by version %s",
+			drv.quotedMigrationsTableName(), order)
+	}
+
+	return fmt.Sprintf("select version from %s group by version having argMax(applied, ts) "+
+		"order by version %s", drv.quotedMigrationsTableName(), order)
+}
+
+// insertMigrationRow records whether a migration is applied. Unless rows are
+// deduplicated by the ReplacingMergeTree engine, the row is given a later timestamp
+// than any existing row for the version, so that it is selected as the latest row even
+// if the migration was applied and rolled back within the same second.
+func (drv *Driver) insertMigrationRow(db dbutil.Transaction, version string, applied bool) error {
+	if drv.tableParameters.Replacing() {
+		_, err := db.Exec(
+			fmt.Sprintf("insert into %s (version, applied) values (?, ?)",
+				drv.quotedMigrationsTableName()),
+			version, applied,
+		)
+
+		return err
+	}
+
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, ts, applied) "+
+			"select ?, greatest(now(), (select max(ts) from %s where version = ?) + 1), ?",
+			drv.quotedMigrationsTableName(), drv.quotedMigrationsTableName()),
+		version, version, applied,
+	)
 
 	return err
 }
@@ -296,8 +492,7 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
-	query := fmt.Sprintf("select version from %s final where applied order by version desc",
-		drv.quotedMigrationsTableName())
+	query := drv.appliedMigrationsQuery("desc")
 
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
@@ -328,24 +523,89 @@ func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, err
 
 // InsertMigration adds a new migration record
 func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
-	_, err := db.Exec(
-		fmt.Sprintf("insert into %s (version) values (?)", drv.quotedMigrationsTableName()),
-		version)
-
-	return err
+	return drv.insertMigrationRow(db, version, true)
 }
 
 // DeleteMigration removes a migration record
 func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	return drv.insertMigrationRow(db, version, false)
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	engineClause := "MergeTree"
+	if drv.clusterParameters.OnCluster {
+		// each replicated table requires its own zookeeper path
+		zooPath := drv.clusterParameters.ZooPath
+		if !strings.Contains(zooPath, "{table}") {
+			zooPath += dbmate.AuditTableSuffix
+		}
+		engineClause = fmt.Sprintf("ReplicatedMergeTree(%s, %s)",
+			drv.QuoteLiteral(zooPath), drv.QuoteLiteral(drv.clusterParameters.ReplicaMacro))
+	}
+
+	_, err := db.Exec(fmt.Sprintf(`
+		create table if not exists %s%s (
+			version String,
+			direction String,
+			checksum String,
+			started_at DateTime64(3),
+			finished_at DateTime64(3),
+			duration_ms UInt64,
+			hostname String,
+			os_user String,
+			dbmate_version String
+		) engine = %s
+		order by (started_at, version)
+	`, drv.quotedAuditTableName(), drv.onClusterClause(), engineClause))
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
 	_, err := db.Exec(
-		fmt.Sprintf("insert into %s (version, applied) values (?, ?)",
-			drv.quotedMigrationsTableName()),
-		version, false,
-	)
+		fmt.Sprintf("insert into %s (version, direction, checksum, started_at, finished_at, "+
+			"duration_ms, hostname, os_user, dbmate_version) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			drv.quotedAuditTableName()),
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		uint64(record.Duration().Milliseconds()), record.Hostname, record.User, record.DbmateVersion)
 
 	return err
 }
 
+// ServerVersion returns the ClickHouse server version
+func (drv *Driver) ServerVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("select concat('ClickHouse ', version())").Scan(&version)
+
+	return version, err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	name := drv.databaseName()
+
+	// views have no data of their own, and materialized views store data in inner tables
+	tables, err := dbutil.QueryColumn(db, "select name from system.tables "+
+		"where database = ? and name not in (?, ?) and not is_temporary "+
+		"and engine not in ('View', 'MaterializedView', 'LiveView', 'WindowView', 'Dictionary') "+
+		"order by name", name, drv.migrationsTableName, drv.migrationsTableName+dbmate.AuditTableSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		_, err = db.Exec(fmt.Sprintf("truncate table %s.%s%s",
+			drv.QuoteIdentifier(name), drv.QuoteIdentifier(table), drv.onClusterClause()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Ping verifies a connection to the database server. It does not verify whether the
 // specified database exists.
 func (drv *Driver) Ping() error {
@@ -369,11 +629,54 @@ func (drv *Driver) Ping() error {
 	return err
 }
 
+// BackslashEscapes returns true, since quoted strings use backslash as an escape character
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
+// TransactionalDDL returns false, since clickhouse does not support transactions
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// IsTransientError returns true for server errors which may succeed if the migration
+// is retried: network errors, and replicas which have lost their keeper session
+func (drv *Driver) IsTransientError(err error) bool {
+	var exception *clickhouse.Exception
+	if !errors.As(err, &exception) {
+		return false
+	}
+
+	switch exception.Code {
+	case 209, // SOCKET_TIMEOUT
+		210, // NETWORK_ERROR
+		242, // TABLE_IS_READ_ONLY
+		999: // KEEPER_EXCEPTION
+		return true
+	}
+
+	return false
+}
+
+// syntaxErrorRegexp matches the byte offset reported by syntax errors, e.g.
+// Syntax error: failed at position 1 ('not_valid_sql')
+var syntaxErrorRegexp = regexp.MustCompile(`failed at position (\d+)`)
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
-	return &dbmate.QueryError{Err: err, Query: query}
+	position := 0
+	if match := syntaxErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		offset, _ := strconv.Atoi(match[1])
+		position = dbmate.BytePosition(query, offset)
+	}
+
+	return &dbmate.QueryError{Err: err, Query: query, Position: position}
 }
 
 func (drv *Driver) quotedMigrationsTableName() string {
-	return drv.quoteIdentifier(drv.migrationsTableName)
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
 }
@@ -0,0 +1,88 @@
+package clickhouse
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	MigrationsEngineQueryParam  = "migrations_engine"
+	MigrationsOrderByQueryParam = "migrations_order_by"
+	MigrationsTTLQueryParam     = "migrations_ttl"
+)
+
+// ErrMigrationsTableEngine is returned if the migrations table options are not
+// supported by its engine
+var ErrMigrationsTableEngine = errors.New("unsupported migrations table option")
+
+// MigrationsTableParameters configure the schema migrations table
+type MigrationsTableParameters struct {
+	// Engine replaces the default ReplacingMergeTree engine, e.g. MergeTree, TinyLog,
+	// or KeeperMap('/dbmate')
+	Engine string
+	// OrderBy replaces the default sorting key of MergeTree engines
+	OrderBy string
+	// TTL is the table TTL expression of MergeTree engines
+	TTL string
+}
+
+func ClearMigrationsTableParametersFromURL(u *url.URL) *url.URL {
+	q := u.Query()
+	q.Del(MigrationsEngineQueryParam)
+	q.Del(MigrationsOrderByQueryParam)
+	q.Del(MigrationsTTLQueryParam)
+	u.RawQuery = q.Encode()
+
+	return u
+}
+
+func ExtractMigrationsTableParametersFromURL(u *url.URL) *MigrationsTableParameters {
+	v := u.Query()
+
+	return &MigrationsTableParameters{
+		Engine:  v.Get(MigrationsEngineQueryParam),
+		OrderBy: v.Get(MigrationsOrderByQueryParam),
+		TTL:     v.Get(MigrationsTTLQueryParam),
+	}
+}
+
+// Replacing returns true if the default engine and sorting key are used, so that rows
+// are deduplicated by version and the latest row of each version can be selected using
+// FINAL. Otherwise, the latest row is selected by timestamp.
+func (p *MigrationsTableParameters) Replacing() bool {
+	return p.Engine == "" && p.OrderBy == ""
+}
+
+// tableClauses returns the clauses which follow the engine in the create table
+// statement, since only MergeTree engines support ORDER BY and TTL, and KeeperMap
+// requires a primary key
+func (p *MigrationsTableParameters) tableClauses() (string, error) {
+	engineName := strings.TrimSpace(p.Engine)
+	if i := strings.Index(engineName, "("); i >= 0 {
+		engineName = engineName[:i]
+	}
+
+	switch {
+	case engineName == "" || strings.HasSuffix(engineName, "MergeTree"):
+		clauses := "primary key version order by version"
+		if p.OrderBy != "" {
+			clauses = "order by " + p.OrderBy
+		}
+		if p.TTL != "" {
+			clauses += " ttl " + p.TTL
+		}
+		return clauses, nil
+	case p.OrderBy != "":
+		return "", fmt.Errorf("%w: %s requires a MergeTree engine", ErrMigrationsTableEngine,
+			MigrationsOrderByQueryParam)
+	case p.TTL != "":
+		return "", fmt.Errorf("%w: %s requires a MergeTree engine", ErrMigrationsTableEngine,
+			MigrationsTTLQueryParam)
+	case engineName == "KeeperMap":
+		return "primary key version", nil
+	default:
+		return "", nil
+	}
+}
@@ -0,0 +1,68 @@
+package clickhouse
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func TestMigrationsTableParameters(t *testing.T) {
+	u := dbutil.MustParseURL("clickhouse://myhost:9000?migrations_engine=MergeTree" +
+		"&migrations_order_by=(version,ts)&migrations_ttl=ts%2BINTERVAL%201%20YEAR&dial_timeout=200ms")
+
+	params := ExtractMigrationsTableParametersFromURL(u)
+	require.Equal(t, &MigrationsTableParameters{
+		Engine:  "MergeTree",
+		OrderBy: "(version,ts)",
+		TTL:     "ts+INTERVAL 1 YEAR",
+	}, params)
+	require.False(t, params.Replacing())
+
+	require.Equal(t, "dial_timeout=200ms", ClearMigrationsTableParametersFromURL(u).RawQuery)
+	require.True(t, ExtractMigrationsTableParametersFromURL(u).Replacing())
+}
+
+func TestMigrationsTableClauses(t *testing.T) {
+	cases := []struct {
+		params   MigrationsTableParameters
+		expected string
+	}{
+		// default engine
+		{MigrationsTableParameters{}, "primary key version order by version"},
+		{MigrationsTableParameters{OrderBy: "(version, ts)"}, "order by (version, ts)"},
+		{MigrationsTableParameters{TTL: "ts + INTERVAL 1 YEAR"}, "primary key version order by version ttl ts + INTERVAL 1 YEAR"},
+		// other MergeTree engines
+		{MigrationsTableParameters{Engine: "MergeTree"}, "primary key version order by version"},
+		{MigrationsTableParameters{Engine: "ReplicatedMergeTree('/zk/{table}', '{replica}')", OrderBy: "version"}, "order by version"},
+		// engines without a sorting key
+		{MigrationsTableParameters{Engine: "TinyLog"}, ""},
+		{MigrationsTableParameters{Engine: "KeeperMap('/dbmate')"}, "primary key version"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expected, func(t *testing.T) {
+			actual, err := c.params.tableClauses()
+			require.NoError(t, err)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+
+	_, err := (&MigrationsTableParameters{Engine: "TinyLog", OrderBy: "version"}).tableClauses()
+	require.ErrorIs(t, err, ErrMigrationsTableEngine)
+	require.EqualError(t, err, "unsupported migrations table option: migrations_order_by requires a MergeTree engine")
+
+	_, err = (&MigrationsTableParameters{Engine: "KeeperMap('/dbmate')", TTL: "ts"}).tableClauses()
+	require.ErrorIs(t, err, ErrMigrationsTableEngine)
+}
+
+func TestAppliedMigrationsQuery(t *testing.T) {
+	drv := testClickHouseDriverURL(t, "clickhouse://myhost:9000/mydb")
+	require.Equal(t, "select version from schema_migrations final where applied order by version desc",
+		drv.appliedMigrationsQuery("desc"))
+
+	drv = testClickHouseDriverURL(t, "clickhouse://myhost:9000/mydb?migrations_engine=TinyLog")
+	require.Equal(t, "select version from schema_migrations group by version "+
+		"having argMax(applied, ts) order by version asc", drv.appliedMigrationsQuery("asc"))
+}
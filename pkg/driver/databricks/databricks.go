@@ -0,0 +1,428 @@
+package databricks
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	dbsql "github.com/databricks/databricks-sql-go"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "databricks")
+	dbutil.RegisterQuoter(&Driver{}, "databricks")
+}
+
+// ErrInvalidURL is returned for URLs which are not in the form
+// databricks://token:<access token>@<host>/<http path>?catalog=<catalog>&schema=<schema>
+var ErrInvalidURL = errors.New("invalid databricks url, expected " +
+	"databricks://token:<access token>@<host>/<http path>?catalog=<catalog>&schema=<schema>")
+
+// defaultPort is the port of the SQL warehouse endpoint, which is always served over https
+const defaultPort = 443
+
+// Driver provides top level database functions
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	dialContext         dbmate.DialContextFunc
+	log                 io.Writer
+	statementTimeout    time.Duration
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		dialContext:         config.DialContext,
+		log:                 config.Log,
+		statementTimeout:    config.StatementTimeout,
+	}
+}
+
+// warehouse holds the connection settings of a databricks URL. The schema in Unity
+// Catalog is managed as the database.
+type warehouse struct {
+	host     string
+	port     int
+	httpPath string
+	token    string
+	catalog  string
+	schema   string
+}
+
+// parseURL returns the connection settings of a URL in the form
+// databricks://token:<access token>@<host>/<http path>?catalog=<catalog>&schema=<schema>
+func parseURL(u *url.URL) (warehouse, error) {
+	w := warehouse{
+		host:     u.Hostname(),
+		port:     defaultPort,
+		httpPath: u.Path,
+		catalog:  u.Query().Get("catalog"),
+		schema:   u.Query().Get("schema"),
+	}
+	if u.User != nil {
+		w.token, _ = u.User.Password()
+	}
+	if port := u.Port(); port != "" {
+		var err error
+		if w.port, err = strconv.Atoi(port); err != nil {
+			return warehouse{}, ErrInvalidURL
+		}
+	}
+
+	if w.host == "" || strings.Trim(w.httpPath, "/") == "" || w.token == "" || w.catalog == "" || w.schema == "" {
+		return warehouse{}, ErrInvalidURL
+	}
+
+	return w, nil
+}
+
+// open connects to the warehouse, using schema as the default schema (if not empty)
+func (drv *Driver) open(w warehouse, schema string) (*sql.DB, error) {
+	options := []dbsql.ConnOption{
+		dbsql.WithServerHostname(w.host),
+		dbsql.WithPort(w.port),
+		dbsql.WithHTTPPath(w.httpPath),
+		dbsql.WithAccessToken(w.token),
+		dbsql.WithInitialNamespace(w.catalog, schema),
+		dbsql.WithUserAgentEntry("dbmate"),
+		dbsql.WithTimeout(drv.statementTimeout),
+	}
+	if drv.dialContext != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = drv.dialContext
+		options = append(options, dbsql.WithTransport(transport))
+	}
+
+	c, err := dbsql.NewConnector(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(c), nil
+}
+
+// Open creates a new database connection, using the schema as the default schema
+func (drv *Driver) Open() (*sql.DB, error) {
+	w, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return drv.open(w, w.schema)
+}
+
+// openCatalog creates a new connection to the catalog, which does not require the schema
+// to exist
+func (drv *Driver) openCatalog() (*sql.DB, warehouse, error) {
+	w, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return nil, w, err
+	}
+
+	db, err := drv.open(w, "")
+	return db, w, err
+}
+
+// CreateDatabase creates the schema
+func (drv *Driver) CreateDatabase() error {
+	db, w, err := drv.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	fmt.Fprintf(drv.log, "Creating: %s.%s\n", w.catalog, w.schema)
+
+	_, err = db.Exec("create schema " + drv.quotedSchemaName(w))
+
+	return err
+}
+
+// DropDatabase drops the schema, and every table within it
+func (drv *Driver) DropDatabase() error {
+	db, w, err := drv.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	fmt.Fprintf(drv.log, "Dropping: %s.%s\n", w.catalog, w.schema)
+
+	_, err = db.Exec("drop schema if exists " + drv.quotedSchemaName(w) + " cascade")
+
+	return err
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
+	migrationsTable := drv.quotedMigrationsTableName()
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		fmt.Sprintf("select version from %s order by version asc", migrationsTable))
+	if err != nil {
+		return err
+	}
+
+	// build migrations table data
+	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n")
+
+	if len(migrations) > 0 {
+		for i, version := range migrations {
+			migrations[i] = drv.QuoteLiteral(version)
+		}
+		buf.WriteString("INSERT INTO " + migrationsTable + " (version) VALUES\n    (" +
+			strings.Join(migrations, "),\n    (") +
+			");\n")
+	}
+
+	return nil
+}
+
+// DumpSchema returns the definition of each table and view in the schema, as reported by
+// SHOW CREATE TABLE. Names are not qualified by the catalog and schema, so that dumps of
+// different environments can be compared.
+func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
+	w, err := parseURL(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	tables, err := dbutil.QueryColumn(db, "select table_name from information_schema.tables "+
+		"where table_schema = current_schema() order by table_name")
+	if err != nil {
+		return nil, err
+	}
+
+	unqualify := strings.NewReplacer(
+		w.catalog+"."+w.schema+".", "",
+		drv.QuoteIdentifier(w.catalog)+"."+drv.QuoteIdentifier(w.schema)+".", "",
+	)
+
+	var buf bytes.Buffer
+	for _, table := range tables {
+		var stmt string
+		if err := db.QueryRow("show create table " + drv.QuoteIdentifier(table)).Scan(&stmt); err != nil {
+			return nil, err
+		}
+		buf.WriteString(strings.TrimRight(unqualify.Replace(stmt), "\n;") + ";\n\n")
+	}
+
+	if err := drv.schemaMigrationsDump(db, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DatabaseExists determines whether the schema exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	db, w, err := drv.openCatalog()
+	if err != nil {
+		return false, err
+	}
+	defer dbutil.MustClose(db)
+
+	count := 0
+	err = db.QueryRow("select count(*) from information_schema.schemata where schema_name = " +
+		drv.QuoteLiteral(w.schema)).Scan(&count)
+
+	return count > 0, err
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	count := 0
+	err := db.QueryRow("select count(*) from information_schema.tables " +
+		"where table_schema = current_schema() and table_name = " +
+		drv.QuoteLiteral(drv.migrationsTableName)).Scan(&count)
+
+	return count > 0, err
+}
+
+// CreateMigrationsTable creates the schema_migrations table
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"create table if not exists %s (version string not null) using delta",
+		drv.quotedMigrationsTableName()))
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+
+	versions, err := dbutil.QueryColumn(db, query)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := map[string]bool{}
+	for _, version := range versions {
+		migrations[version] = true
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version) values (%s)",
+		drv.quotedMigrationsTableName(), drv.QuoteLiteral(version)))
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(fmt.Sprintf("delete from %s where version = %s",
+		drv.quotedMigrationsTableName(), drv.QuoteLiteral(version)))
+
+	return err
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s ("+
+		"version string not null, "+
+		"direction string not null, "+
+		"checksum string not null, "+
+		"started_at timestamp not null, "+
+		"finished_at timestamp not null, "+
+		"duration_ms bigint not null, "+
+		"hostname string not null, "+
+		"os_user string not null, "+
+		"dbmate_version string not null"+
+		") using delta",
+		drv.quotedAuditTableName()))
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	_, err := db.Exec(fmt.Sprintf("insert into %s (version, direction, checksum, started_at, "+
+		"finished_at, duration_ms, hostname, os_user, dbmate_version) "+
+		"values (%s, %s, %s, %s, %s, %d, %s, %s, %s)",
+		drv.quotedAuditTableName(),
+		drv.QuoteLiteral(record.Version), drv.QuoteLiteral(record.Direction), drv.QuoteLiteral(record.Checksum),
+		drv.timestampLiteral(record.StartedAt), drv.timestampLiteral(record.FinishedAt),
+		record.Duration().Milliseconds(), drv.QuoteLiteral(record.Hostname), drv.QuoteLiteral(record.User),
+		drv.QuoteLiteral(record.DbmateVersion)))
+
+	return err
+}
+
+// timestampLiteral returns a UTC timestamp literal with microsecond precision
+func (drv *Driver) timestampLiteral(t time.Time) string {
+	return "timestamp" + drv.QuoteLiteral(t.UTC().Format("2006-01-02 15:04:05.000000")+"Z")
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	tables, err := dbutil.QueryColumn(db, "select table_name from information_schema.tables "+
+		"where table_schema = current_schema() and table_type in ('MANAGED', 'EXTERNAL') "+
+		"and table_name not in ("+drv.QuoteLiteral(drv.migrationsTableName)+", "+
+		drv.QuoteLiteral(drv.migrationsTableName+dbmate.AuditTableSuffix)+")")
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := db.Exec("truncate table " + drv.QuoteIdentifier(table)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping verifies a connection to the SQL warehouse. It does not verify whether the
+// specified schema exists.
+func (drv *Driver) Ping() error {
+	db, _, err := drv.openCatalog()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	return db.Ping()
+}
+
+// SingleStatements returns true, since databricks executes one statement per request
+func (drv *Driver) SingleStatements() bool {
+	return true
+}
+
+// BackslashEscapes returns true, since quoted strings use backslash as an escape character
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
+// Transactions returns false, since databricks does not support transactions, so each
+// statement is committed as soon as it is executed
+func (drv *Driver) Transactions() bool {
+	return false
+}
+
+// TransactionalDDL returns false, since databricks does not support transactions
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// errorLocationRegexp matches the location reported by syntax errors, e.g.
+// [PARSE_SYNTAX_ERROR] Syntax error at or near 'not_valid_sql'.(line 2, pos 0)
+var errorLocationRegexp = regexp.MustCompile(`\(line (\d+), pos (\d+)\)`)
+
+// Return a normalized version of the driver-specific error type.
+func (drv *Driver) QueryError(query string, err error) error {
+	queryErr := &dbmate.QueryError{Err: err, Query: query}
+	if match := errorLocationRegexp.FindStringSubmatch(err.Error()); match != nil {
+		queryErr.Line, _ = strconv.Atoi(match[1])
+		// pos is zero based
+		pos, _ := strconv.Atoi(match[2])
+		queryErr.Column = pos + 1
+	}
+
+	return queryErr
+}
+
+func (drv *Driver) quotedMigrationsTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
+}
+
+func (drv *Driver) quotedSchemaName(w warehouse) string {
+	return drv.QuoteIdentifier(w.catalog) + "." + drv.QuoteIdentifier(w.schema)
+}
+
+// QuoteIdentifier quotes a name with backticks
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteBacktickIdentifier(name)
+}
+
+// QuoteLiteral quotes a string literal, escaping quotes and backslashes with a backslash
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteBackslashEscapedLiteral(value)
+}
@@ -0,0 +1,525 @@
+// Package libsql provides a driver for remote libSQL databases, such as those hosted by
+// Turso, which are accessed over HTTP rather than as local files
+package libsql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/tursodatabase/libsql-client-go/libsql"
+	"github.com/tursodatabase/libsql-client-go/sqliteparserutils"
+)
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "libsql")
+	dbutil.RegisterQuoter(&Driver{}, "libsql")
+}
+
+// URL query parameters used by the driver, which are not passed to the server
+const (
+	// authTokenParam is the database auth token (e.g. from `turso db tokens create`)
+	authTokenParam = "authToken"
+	// tlsParam may be set to false to connect without TLS (e.g. to a local sqld server)
+	tlsParam = "tls"
+)
+
+// Driver provides top level database functions
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	log                 io.Writer
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		log:                 config.Log,
+	}
+}
+
+// connectionString returns the database URL without the auth token and TLS
+// parameters, which are passed to the client as options
+func connectionString(u *url.URL) string {
+	out := *u
+	query := out.Query()
+	query.Del(authTokenParam)
+	query.Del(tlsParam)
+	out.RawQuery = query.Encode()
+
+	return out.String()
+}
+
+// connector returns a connector configured by the URL query parameters
+func (drv *Driver) connector() (driver.Connector, error) {
+	query := drv.databaseURL.Query()
+
+	opts := []libsql.Option{}
+	if token := query.Get(authTokenParam); token != "" {
+		opts = append(opts, libsql.WithAuthToken(token))
+	}
+	if value := query.Get(tlsParam); value != "" {
+		tls, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter: %s", tlsParam, value)
+		}
+		opts = append(opts, libsql.WithTls(tls))
+	}
+
+	c, err := libsql.NewConnector(connectionString(drv.databaseURL), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return connector{c}, nil
+}
+
+// hranaConn is the set of interfaces implemented by libsql client connections
+type hranaConn interface {
+	driver.Conn
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.ConnBeginTx
+	driver.SessionResetter
+}
+
+// connector wraps the libsql client connector, in order to wrap its connections
+type connector struct {
+	driver.Connector
+}
+
+// Connect returns a new connection
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dc, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if hc, ok := dc.(hranaConn); ok {
+		return conn{hc}, nil
+	}
+
+	return dc, nil
+}
+
+// conn executes queries containing several statements one statement at a time. The libsql
+// client would otherwise send them as a batch which issues its own rollback on failure,
+// ending the migration transaction and hiding the original error.
+type conn struct {
+	hranaConn
+}
+
+// ExecContext executes a query
+func (c conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return c.hranaConn.ExecContext(ctx, query, args)
+	}
+
+	stmts, _ := sqliteparserutils.SplitStatement(query)
+	if len(stmts) == 1 {
+		return c.hranaConn.ExecContext(ctx, stmts[0], nil)
+	}
+
+	var rowsAffected int64
+	for _, stmt := range stmts {
+		result, err := c.hranaConn.ExecContext(ctx, stmt, nil)
+		if err != nil {
+			return nil, err
+		}
+		if rows, err := result.RowsAffected(); err == nil {
+			rowsAffected += rows
+		}
+	}
+
+	return driver.RowsAffected(rowsAffected), nil
+}
+
+// Open creates a new database connection
+func (drv *Driver) Open() (*sql.DB, error) {
+	connector, err := drv.connector()
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+// CreateDatabase verifies that the database exists. Remote databases must be created
+// using the Turso CLI or API, since they cannot be created over a database connection.
+func (drv *Driver) CreateDatabase() error {
+	fmt.Fprintf(drv.log, "Creating: %s\n", drv.databaseURL.Host)
+
+	if err := drv.Ping(); err != nil {
+		return fmt.Errorf("unable to connect to database (libsql databases must be created "+
+			"using the Turso CLI or API): %w", err)
+	}
+
+	return nil
+}
+
+// DropDatabase drops every table, view, and trigger in the database. Remote databases
+// cannot be deleted over a database connection, so the database itself is left empty.
+func (drv *Driver) DropDatabase() error {
+	fmt.Fprintf(drv.log, "Dropping: %s\n", drv.databaseURL.Host)
+
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	// drop triggers and views before tables, and tables in the reverse of the order
+	// they were created, so that tables are dropped before those they reference
+	rows, err := db.Query("select type, name from sqlite_master " +
+		"where type in ('table', 'view', 'trigger') " +
+		"and name not like 'sqlite\\_%' escape '\\' and name not like 'libsql\\_%' escape '\\' " +
+		"order by case type when 'trigger' then 0 when 'view' then 1 else 2 end, rowid desc")
+	if err != nil {
+		return err
+	}
+
+	objects := [][2]string{}
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			dbutil.MustClose(rows)
+			return err
+		}
+		objects = append(objects, [2]string{kind, name})
+	}
+	dbutil.MustClose(rows)
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		if _, err := db.Exec(fmt.Sprintf("drop %s if exists %s", object[0], drv.QuoteIdentifier(object[1]))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
+	migrationsTable := drv.quotedMigrationsTableName()
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		fmt.Sprintf("select quote(version) from %s order by version asc", migrationsTable))
+	if err != nil {
+		return nil, err
+	}
+
+	// build schema migrations table data
+	var buf bytes.Buffer
+	buf.WriteString("-- Dbmate schema migrations\n")
+
+	if len(migrations) > 0 {
+		buf.WriteString(
+			fmt.Sprintf("INSERT INTO %s (version) VALUES\n  (", migrationsTable) +
+				strings.Join(migrations, "),\n  (") +
+				");\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DumpSchema returns the current database schema. Since the sqlite3 command cannot
+// connect to remote databases, the schema is read from the sqlite_master table in the
+// order objects were created, which matches the output of `sqlite3 .schema --nosys`.
+func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
+	stmts, err := dbutil.QueryColumn(db, "select sql from sqlite_master "+
+		"where sql is not null "+
+		"and name not like 'sqlite\\_%' escape '\\' and name not like 'libsql\\_%' escape '\\' "+
+		"order by rowid")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, stmt := range stmts {
+		buf.WriteString(stmt)
+		buf.WriteString(";\n")
+	}
+
+	migrations, err := drv.schemaMigrationsDump(db)
+	if err != nil {
+		return nil, err
+	}
+
+	buf.Write(migrations)
+	return buf.Bytes(), nil
+}
+
+// DatabaseExists determines whether the database exists. Since remote databases cannot
+// be created over a database connection, this returns true if the database is reachable.
+func (drv *Driver) DatabaseExists() (bool, error) {
+	if err := drv.Ping(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	exists := false
+	err := db.QueryRow("SELECT 1 FROM sqlite_master "+
+		"WHERE type='table' AND name=?",
+		drv.migrationsTableName).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+// CreateMigrationsTable creates the schema migrations table
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(
+		"create table if not exists %s (version varchar(128) primary key)",
+		drv.quotedMigrationsTableName()))
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbutil.MustClose(rows)
+
+	migrations := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		migrations[version] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version) values (?)", drv.quotedMigrationsTableName()),
+		version)
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("delete from %s where version = ?", drv.quotedMigrationsTableName()),
+		version)
+
+	return err
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s ("+
+		"id integer primary key autoincrement, "+
+		"version varchar(128) not null, "+
+		"direction varchar(4) not null, "+
+		"checksum varchar(64) not null, "+
+		"started_at datetime not null, "+
+		"finished_at datetime not null, "+
+		"duration_ms integer not null, "+
+		"hostname varchar(255) not null, "+
+		"os_user varchar(255) not null, "+
+		"dbmate_version varchar(32) not null)",
+		drv.quotedAuditTableName()))
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, direction, checksum, started_at, finished_at, "+
+			"duration_ms, hostname, os_user, dbmate_version) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			drv.quotedAuditTableName()),
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		record.Duration().Milliseconds(), record.Hostname, record.User, record.DbmateVersion)
+
+	return err
+}
+
+// TableColumns returns the columns of each table
+func (drv *Driver) TableColumns(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query("select m.name, p.name from sqlite_master m "+
+		"join pragma_table_info(m.name) p "+
+		"where m.type = 'table' and m.name not like 'sqlite\\_%' escape '\\' "+
+		"and m.name not like 'libsql\\_%' escape '\\' and m.name <> ? "+
+		"order by m.name, p.cid", drv.migrationsTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	tables := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		tables[table] = append(tables[table], column)
+	}
+
+	return tables, rows.Err()
+}
+
+// ServerVersion returns the SQLite version of the libSQL server
+func (drv *Driver) ServerVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("select 'SQLite ' || sqlite_version()").Scan(&version)
+
+	return version, err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	tables, err := dbutil.QueryColumn(db, "select name from sqlite_master "+
+		"where type = 'table' and name not like 'sqlite\\_%' escape '\\' "+
+		"and name not like 'libsql\\_%' escape '\\' and name not in (?, ?) "+
+		"order by name", drv.migrationsTableName, drv.migrationsTableName+dbmate.AuditTableSuffix)
+	if err != nil {
+		return err
+	}
+
+	// the foreign_keys pragma is per connection, so we must delete using the same
+	// connection (which the client maintains as a stream on the server)
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(conn)
+
+	foreignKeys := 0
+	if err := conn.QueryRowContext(ctx, "pragma foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "pragma foreign_keys = off"); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, "delete from "+drv.QuoteIdentifier(table)); err != nil {
+			return err
+		}
+	}
+
+	// reset autoincrement counters
+	sequenceExists := false
+	err = conn.QueryRowContext(ctx, "select 1 from sqlite_master where type = 'table' and name = 'sqlite_sequence'").
+		Scan(&sequenceExists)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if sequenceExists {
+		if _, err := conn.ExecContext(ctx, "delete from sqlite_sequence"); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("pragma foreign_keys = %d", foreignKeys))
+
+	return err
+}
+
+// Ping verifies a connection to the database server
+func (drv *Driver) Ping() error {
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	// connections are made over HTTP when executing a query, so we must execute one to
+	// verify that the server is reachable and accepts our auth token
+	_, err = db.Exec("select 1")
+
+	return err
+}
+
+// TransactionalDDL returns true, since DDL statements can be rolled back
+func (drv *Driver) TransactionalDDL() bool {
+	return true
+}
+
+// IsTransientError returns true if the database was locked by another connection, or
+// the connection to the server was lost (e.g. because the stream expired)
+func (drv *Driver) IsTransientError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "SQLITE_BUSY") || strings.Contains(msg, "database is locked") ||
+		strings.Contains(msg, "STREAM_EXPIRED")
+}
+
+// syntaxErrorRegexp matches the text quoted by syntax errors, e.g.
+// near "not_valid_sql": syntax error
+var syntaxErrorRegexp = regexp.MustCompile(`near "(.*?)": syntax error`)
+
+// Return a normalized version of the driver-specific error type.
+func (drv *Driver) QueryError(query string, err error) error {
+	position := 0
+	if match := syntaxErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		position = dbmate.TextPosition(query, match[1])
+	}
+
+	return &dbmate.QueryError{Err: err, Query: query, Position: position}
+}
+
+func (drv *Driver) quotedMigrationsTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
+}
+
+// QuoteIdentifier quotes a name with double quotes
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteDoubleQuotedIdentifier(name)
+}
+
+// QuoteLiteral quotes a string literal, doubling any single quotes
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteSingleQuotedLiteral(value)
+}
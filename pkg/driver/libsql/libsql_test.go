@@ -0,0 +1,418 @@
+package libsql
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testLibSQLDriver(t *testing.T) *Driver {
+	u := dbutil.MustParseURL(os.Getenv("LIBSQL_TEST_URL"))
+	drv, err := dbmate.New(u).Driver()
+	require.NoError(t, err)
+
+	return drv.(*Driver)
+}
+
+func prepTestLibSQLDB(t *testing.T) *sql.DB {
+	drv := testLibSQLDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// connect database
+	db, err := drv.Open()
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestGetDriver(t *testing.T) {
+	db := dbmate.New(dbutil.MustParseURL("libsql://db-org.turso.io"))
+	drvInterface, err := db.Driver()
+	require.NoError(t, err)
+
+	// driver should have URL and default migrations table set
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.Equal(t, db.DatabaseURL.String(), drv.databaseURL.String())
+	require.Equal(t, "schema_migrations", drv.migrationsTableName)
+}
+
+func TestConnectionString(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		u := dbutil.MustParseURL("libsql://db-org.turso.io")
+		require.Equal(t, "libsql://db-org.turso.io", connectionString(u))
+	})
+
+	t.Run("auth token and tls", func(t *testing.T) {
+		u := dbutil.MustParseURL("libsql://localhost:8080?authToken=secret&tls=0")
+		require.Equal(t, "libsql://localhost:8080", connectionString(u))
+	})
+}
+
+func TestConnector(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		drv := &Driver{databaseURL: dbutil.MustParseURL("libsql://localhost:8080?authToken=secret&tls=false")}
+		_, err := drv.connector()
+		require.NoError(t, err)
+	})
+
+	t.Run("invalid tls", func(t *testing.T) {
+		drv := &Driver{databaseURL: dbutil.MustParseURL("libsql://localhost:8080?tls=maybe")}
+		_, err := drv.connector()
+		require.EqualError(t, err, "invalid tls parameter: maybe")
+	})
+}
+
+func TestLibSQLCreateDropDatabase(t *testing.T) {
+	drv := testLibSQLDriver(t)
+
+	// drop any existing database
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// creating an existing database succeeds
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	db, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec("create table parents (id integer primary key); " +
+		"create table children (id integer, parent_id integer references parents (id)); " +
+		"create view child_ids as select id from children; " +
+		"create trigger parents_delete after delete on parents begin delete from children; end")
+	require.NoError(t, err)
+
+	// drop the database
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+
+	// check that the database is empty (except for internal tables)
+	count := 0
+	err = db.QueryRow("select count(*) from sqlite_master where name not like 'sqlite_%'").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func TestLibSQLDumpSchema(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	// prepare database
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// insert migration
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc2")
+	require.NoError(t, err)
+
+	// create a table that will trigger `sqlite_sequence` system table
+	_, err = db.Exec("CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT)")
+	require.NoError(t, err)
+
+	// DumpSchema should return schema
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Equal(t, "CREATE TABLE \"test_migrations\" (version varchar(128) primary key);\n"+
+		"CREATE TABLE t (id INTEGER PRIMARY KEY AUTOINCREMENT);\n"+
+		"-- Dbmate schema migrations\n"+
+		"INSERT INTO \"test_migrations\" (version) VALUES\n"+
+		"  ('abc1'),\n"+
+		"  ('abc2');\n", string(schema))
+}
+
+func TestLibSQLDatabaseExists(t *testing.T) {
+	drv := testLibSQLDriver(t)
+
+	// DatabaseExists should return true
+	exists, err := drv.DatabaseExists()
+	require.NoError(t, err)
+	require.Equal(t, true, exists)
+
+	// DatabaseExists should return an error if the server cannot be reached
+	drv.databaseURL = dbutil.MustParseURL("libsql://localhost:1?tls=0")
+	exists, err = drv.DatabaseExists()
+	require.Error(t, err)
+	require.Equal(t, false, exists)
+}
+
+func TestLibSQLCreateMigrationsTable(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	// migrations table should not exist
+	exists, err := drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.Equal(t, false, exists)
+
+	// create table
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// migrations table should exist
+	exists, err = drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.Equal(t, true, exists)
+
+	// create table should be idempotent
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+}
+
+func TestLibSQLSelectMigrations(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`insert into test_migrations (version)
+		values ('abc2'), ('abc1'), ('abc3')`)
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, true, migrations["abc1"])
+	require.Equal(t, true, migrations["abc2"])
+	require.Equal(t, true, migrations["abc3"])
+
+	// test limit param
+	migrations, err = drv.SelectMigrations(db, 1)
+	require.NoError(t, err)
+	require.Equal(t, true, migrations["abc3"])
+	require.Equal(t, false, migrations["abc1"])
+	require.Equal(t, false, migrations["abc2"])
+}
+
+func TestLibSQLInsertDeleteMigration(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	// insert migrations
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc2")
+	require.NoError(t, err)
+
+	// delete migration
+	err = drv.DeleteMigration(db, "abc2")
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+}
+
+func TestLibSQLTruncateTables(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`insert into test_migrations (version) values ('abc1')`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`create table parents (id integer primary key autoincrement)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table children (id integer primary key, parent_id integer references parents (id))`)
+	require.NoError(t, err)
+	_, err = db.Exec(`insert into parents (id) values (1)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`insert into children (id, parent_id) values (1, 1)`)
+	require.NoError(t, err)
+
+	err = drv.TruncateTables(db)
+	require.NoError(t, err)
+
+	count := 0
+	err = db.QueryRow("select count(*) from parents").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+	err = db.QueryRow("select count(*) from children").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// migrations are preserved
+	err = db.QueryRow("select count(*) from test_migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestLibSQLExec(t *testing.T) {
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	// queries without statements are not executed
+	_, err := db.Exec("-- nothing to do\n")
+	require.NoError(t, err)
+
+	// statements are executed in order, and a failure rolls back the whole transaction
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("create table a (id integer); not_valid_sql;")
+	require.EqualError(t, err, "failed to execute SQL:\nnear \"not_valid_sql\": syntax error")
+	require.NoError(t, tx.Rollback())
+
+	exists := false
+	err = db.QueryRow("select 1 from sqlite_master where name = 'a'").Scan(&exists)
+	require.ErrorIs(t, err, sql.ErrNoRows)
+
+	// trigger bodies are executed as a single statement
+	_, err = db.Exec("create table a (id integer);\n" +
+		"create trigger a_insert after insert on a begin\n" +
+		"  delete from a where id < 0;\n" +
+		"end;\n" +
+		"insert into a (id) values (1), (-1);")
+	require.NoError(t, err)
+
+	ids, err := dbutil.QueryColumn(db, "select id from a")
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, ids)
+}
+
+func TestLibSQLPing(t *testing.T) {
+	drv := testLibSQLDriver(t)
+
+	// ping database
+	err := drv.Ping()
+	require.NoError(t, err)
+
+	// ping invalid server should fail
+	drv.databaseURL = dbutil.MustParseURL("libsql://localhost:1?tls=0")
+	err = drv.Ping()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "connect: connection refused")
+}
+
+func TestLibSQLQuotedMigrationsTableName(t *testing.T) {
+	drv := &Driver{migrationsTableName: "fooMigrations"}
+
+	name := drv.quotedMigrationsTableName()
+	require.Equal(t, `"fooMigrations"`, name)
+}
+
+func TestLibSQLIsTransientError(t *testing.T) {
+	drv := &Driver{}
+
+	require.True(t, drv.IsTransientError(driver.ErrBadConn))
+	require.True(t, drv.IsTransientError(errors.New("SQLITE_BUSY: database is locked")))
+	require.True(t, drv.IsTransientError(&dbmate.QueryError{Err: errors.New("failed to execute SQL:\nSTREAM_EXPIRED")}))
+	require.False(t, drv.IsTransientError(errors.New("UNIQUE constraint failed: users.id")))
+	require.False(t, drv.IsTransientError(errors.New("other error")))
+}
+
+func TestLibSQLTableColumns(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`create table users (id integer primary key autoincrement, name text, email text)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table posts (id integer, user_id integer)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`create view user_names as select name from users`)
+	require.NoError(t, err)
+
+	tables, err := drv.TableColumns(db)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"posts": {"id", "user_id"},
+		"users": {"id", "name", "email"},
+	}, tables)
+}
+
+func TestLibSQLInsertAudit(t *testing.T) {
+	drv := testLibSQLDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestLibSQLDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateAuditTable(db)
+	require.NoError(t, err)
+
+	// creating the table again is a no-op
+	err = drv.CreateAuditTable(db)
+	require.NoError(t, err)
+
+	startedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = drv.InsertAudit(db, dbmate.AuditRecord{
+		Version:       "abc1",
+		Direction:     dbmate.AuditUp,
+		Checksum:      "abcdef",
+		StartedAt:     startedAt,
+		FinishedAt:    startedAt.Add(1500 * time.Millisecond),
+		Hostname:      "localhost",
+		User:          "dbmate",
+		DbmateVersion: "2.0.0",
+	})
+	require.NoError(t, err)
+
+	var version, direction, user string
+	var duration int64
+	err = db.QueryRow("select version, direction, os_user, duration_ms from test_migrations_audit").
+		Scan(&version, &direction, &user, &duration)
+	require.NoError(t, err)
+	require.Equal(t, "abc1", version)
+	require.Equal(t, "up", direction)
+	require.Equal(t, "dbmate", user)
+	require.Equal(t, int64(1500), duration)
+}
+
+func TestLibSQLQueryError(t *testing.T) {
+	drv := &Driver{}
+
+	query := "create table a (id integer);\n/* สวัสดี */ not_valid_sql;"
+	err := errors.New("failed to execute SQL:\nnear \"not_valid_sql\": syntax error")
+
+	queryErr := drv.QueryError(query, err).(*dbmate.QueryError)
+	line, column := queryErr.Location()
+	require.Equal(t, 2, line)
+	require.Equal(t, 14, column)
+	require.Contains(t, queryErr.Error(), "> 2 | /* สวัสดี */ not_valid_sql;\n    |              ^")
+}
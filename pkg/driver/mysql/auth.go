@@ -0,0 +1,173 @@
+package mysql
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/amacneil/dbmate/v2/pkg/driver/tokenauth"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// authPluginParam is a URL query parameter which allows the mysql_clear_password client
+// authentication plugin, for managed services which authenticate with short lived tokens.
+// The server selects the plugin of each user, which go-sql-driver/mysql can't override,
+// so other plugins are rejected rather than silently ignored. It is not passed to the
+// database connection.
+const authPluginParam = "auth_plugin"
+
+// authPluginCleartext is the only plugin which may be given in the auth_plugin parameter
+const authPluginCleartext = "mysql_clear_password"
+
+// serverPublicKeyParam is a URL query parameter with the path of the server's RSA public
+// key (PEM), which caching_sha2_password and sha256_password use to encrypt the password
+// on connections without TLS. Without it, the key is requested from the server.
+const serverPublicKeyParam = "server_public_key"
+
+// authParamAliases maps URL query parameters to the go-sql-driver/mysql DSN parameters
+// they are passed as, so they can be written like the other dbmate parameters
+var authParamAliases = map[string]string{
+	"allow_cleartext_passwords": "allowCleartextPasswords",
+	"allow_native_passwords":    "allowNativePasswords",
+}
+
+// authQuery replaces the dbmate authentication parameters in query with the DSN
+// parameters which go-sql-driver/mysql understands
+func authQuery(query url.Values) {
+	for alias, name := range authParamAliases {
+		if query.Has(alias) && !query.Has(name) {
+			query.Set(name, query.Get(alias))
+		}
+		query.Del(alias)
+	}
+
+	// go-sql-driver/mysql only uses the cleartext plugin if the server requests it, and
+	// it is allowed
+	if query.Get(authPluginParam) == authPluginCleartext && !query.Has("allowCleartextPasswords") {
+		query.Set("allowCleartextPasswords", "true")
+	}
+	// Azure AD tokens are sent with the cleartext plugin
+	if query.Get(tokenauth.Param) == tokenauth.AzureAD && !query.Has("allowCleartextPasswords") {
+		query.Set("allowCleartextPasswords", "true")
+	}
+	query.Del(authPluginParam)
+	query.Del(serverPublicKeyParam)
+	query.Del(tokenauth.Param)
+}
+
+// authPlugin returns the value of the auth_plugin parameter, or an error if it is not
+// mysql_clear_password, or the plugin is disallowed by allow_cleartext_passwords
+func (drv *Driver) authPlugin() (string, error) {
+	if drv.databaseURL == nil {
+		return "", nil
+	}
+
+	switch plugin := drv.databaseURL.Query().Get(authPluginParam); plugin {
+	case "":
+		return "", nil
+	case authPluginCleartext:
+		allowed, err := drv.allowParam("allow_cleartext_passwords")
+		if err != nil {
+			return "", err
+		}
+		if !allowed {
+			return "", fmt.Errorf("%s parameter %s is disabled by allow_cleartext_passwords", authPluginParam, plugin)
+		}
+		return plugin, nil
+	default:
+		return "", fmt.Errorf("invalid %s parameter: %s (only %s is supported, since the server selects "+
+			"the plugin of each user)", authPluginParam, plugin, authPluginCleartext)
+	}
+}
+
+// allowParam returns the value of an allow_* parameter, or its DSN alias, which are
+// enabled if not set
+func (drv *Driver) allowParam(alias string) (bool, error) {
+	query := drv.databaseURL.Query()
+	name := authParamAliases[alias]
+	if !query.Has(name) {
+		name = alias
+	}
+
+	return boolParam(query, name, true)
+}
+
+// cleartextAllowed returns true if passwords may be sent in cleartext, which mysqldump
+// must be told separately
+func (drv *Driver) cleartextAllowed() bool {
+	query := drv.databaseURL.Query()
+	for _, name := range []string{"allowCleartextPasswords", "allow_cleartext_passwords"} {
+		if query.Has(name) {
+			allowed, _ := strconv.ParseBool(query.Get(name))
+			return allowed
+		}
+	}
+
+	return query.Get(authPluginParam) == authPluginCleartext || query.Get(tokenauth.Param) == tokenauth.AzureAD
+}
+
+// readServerPublicKey reads an RSA public key from a PEM file
+func readServerPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", serverPublicKeyParam, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid %s: no PEM data found in %s", serverPublicKeyParam, path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", serverPublicKeyParam, err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s: %s is not an RSA public key", serverPublicKeyParam, path)
+	}
+
+	return rsaKey, nil
+}
+
+// authURL validates the authentication parameters, and registers the server public key
+// if one is specified
+func (drv *Driver) authURL(u *url.URL) (*url.URL, error) {
+	if _, err := drv.authPlugin(); err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	clone := *u
+	if path := query.Get(serverPublicKeyParam); path != "" {
+		key, err := readServerPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+
+		// go-sql-driver/mysql looks up server public keys by name
+		name := fmt.Sprintf("dbmate-%p", drv)
+		mysql.RegisterServerPubKey(name, key)
+		query.Set("serverPubKey", name)
+		clone.RawQuery = query.Encode()
+	}
+
+	return &clone, nil
+}
+
+// tokenConnector returns a connector which uses a new token from the token source as the
+// password of each connection
+func tokenConnector(cfg *mysql.Config, tokens tokenauth.Source) driver.Connector {
+	return tokenauth.NewConnector(tokens, &mysql.MySQLDriver{}, func(password string) (driver.Connector, error) {
+		cfg := cfg.Clone()
+		cfg.Passwd = password
+		return mysql.NewConnector(cfg)
+	})
+}
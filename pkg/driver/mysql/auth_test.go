@@ -0,0 +1,142 @@
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/amacneil/dbmate/v2/pkg/driver/tokenauth"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthConnectionString(t *testing.T) {
+	cases := map[string]string{
+		"mysql://host/foo?auth_plugin=mysql_clear_password": "tcp(host:3306)/foo?allowCleartextPasswords=true&multiStatements=true",
+		"mysql://host/foo?allow_cleartext_passwords=true&allow_native_passwords=false": "tcp(host:3306)/foo?" +
+			"allowCleartextPasswords=true&allowNativePasswords=false&multiStatements=true",
+		// the go-sql-driver/mysql names take precedence
+		"mysql://host/foo?allow_cleartext_passwords=true&allowCleartextPasswords=false": "tcp(host:3306)/foo?" +
+			"allowCleartextPasswords=false&multiStatements=true",
+		"mysql://host/foo?server_public_key=/key.pem": "tcp(host:3306)/foo?multiStatements=true",
+	}
+	for input, expected := range cases {
+		require.Equal(t, expected, connectionString(dbutil.MustParseURL(input)), input)
+	}
+}
+
+func TestAuthPlugin(t *testing.T) {
+	drv := &Driver{databaseURL: dbutil.MustParseURL("mysql://host/foo")}
+	plugin, err := drv.authPlugin()
+	require.NoError(t, err)
+	require.Equal(t, "", plugin)
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://host/foo?auth_plugin=mysql_clear_password")
+	plugin, err = drv.authPlugin()
+	require.NoError(t, err)
+	require.Equal(t, authPluginCleartext, plugin)
+
+	// the server selects the plugin, so other plugins can't be requested
+	for _, plugin := range []string{"kerberos", "mysql_native_password", "caching_sha2_password", "sha256_password"} {
+		drv.databaseURL = dbutil.MustParseURL("mysql://host/foo?auth_plugin=" + plugin)
+		_, err = drv.authPlugin()
+		require.ErrorContains(t, err, "invalid auth_plugin parameter: "+plugin)
+	}
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://host/foo?auth_plugin=mysql_clear_password" +
+		"&allowCleartextPasswords=false")
+	_, err = drv.authPlugin()
+	require.EqualError(t, err, "auth_plugin parameter mysql_clear_password is disabled by allow_cleartext_passwords")
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://host/foo?auth_plugin=mysql_clear_password" +
+		"&allow_cleartext_passwords=maybe")
+	_, err = drv.authPlugin()
+	require.EqualError(t, err, "invalid allow_cleartext_passwords parameter: maybe")
+
+	// the plugin is validated before connecting
+	_, err = drv.Open()
+	require.EqualError(t, err, "invalid allow_cleartext_passwords parameter: maybe")
+}
+
+func TestAuthServerPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "server.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	drv := &Driver{}
+	u, err := drv.authURL(dbutil.MustParseURL("mysql://host/foo?server_public_key=" + path))
+	require.NoError(t, err)
+
+	cfg, err := mysql.ParseDSN(connectionString(u))
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("dbmate-%p", drv), cfg.ServerPubKey)
+
+	_, err = drv.authURL(dbutil.MustParseURL("mysql://host/foo?server_public_key=" + path + ".missing"))
+	require.ErrorContains(t, err, "unable to read server_public_key")
+
+	require.NoError(t, os.WriteFile(path, []byte("not a key"), 0o600))
+	_, err = drv.authURL(dbutil.MustParseURL("mysql://host/foo?server_public_key=" + path))
+	require.ErrorContains(t, err, "no PEM data found")
+}
+
+type testTokens struct {
+	requests int
+}
+
+func (s *testTokens) Token(context.Context) (string, error) {
+	s.requests++
+	return "token", nil
+}
+
+func TestAuthAzureAD(t *testing.T) {
+	// tokens are sent with the cleartext plugin
+	require.Equal(t, "bob@tcp(myhost:3306)/foo?allowCleartextPasswords=true&multiStatements=true",
+		connectionString(dbutil.MustParseURL("mysql://bob@myhost/foo?auth=azure-ad")))
+
+	drv := &Driver{
+		databaseURL: dbutil.MustParseURL("mysql://bob@myhost/foo?auth=azure-ad"),
+		tokens:      tokenauth.Tokens{Source: &testTokens{}},
+	}
+	args, err := drv.mysqldumpArgs()
+	require.NoError(t, err)
+	require.Contains(t, args, "--enable-cleartext-plugin")
+	require.Contains(t, args, "--password=token")
+
+	drv.databaseURL = dbutil.MustParseURL("mysql://bob@myhost/foo?auth=kerberos")
+	drv.tokens = tokenauth.Tokens{}
+	_, err = drv.Open()
+	require.ErrorIs(t, err, tokenauth.ErrInvalidAuth)
+}
+
+func TestAuthTokens(t *testing.T) {
+	dialed := []string{}
+	db := dbmate.New(dbutil.MustParseURL("mysql://bob@myhost:1234/foo?auth=azure-ad"))
+	db.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		return nil, errors.New("custom dialer")
+	}
+	drv, err := db.Driver()
+	require.NoError(t, err)
+	tokens := &testTokens{}
+	drv.(*Driver).tokens.Source = tokens
+
+	// a token is obtained before connecting
+	err = drv.Ping()
+	require.ErrorContains(t, err, "custom dialer")
+	require.Contains(t, dialed, "tcp myhost:1234")
+	require.Positive(t, tokens.requests)
+}
@@ -0,0 +1,160 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// mariadbVersion is a MariaDB server version
+type mariadbVersion struct {
+	major int
+	minor int
+}
+
+// atLeast returns true if the version is greater than or equal to major.minor
+func (v mariadbVersion) atLeast(major, minor int) bool {
+	return v.major > major || (v.major == major && v.minor >= minor)
+}
+
+// supportsSequences returns true if the server supports CREATE SEQUENCE (MariaDB 10.3+)
+func (v mariadbVersion) supportsSequences() bool {
+	return v.atLeast(10, 3)
+}
+
+// supportsReturning returns true if the server supports a RETURNING clause on the given
+// statement: DELETE ... RETURNING (MariaDB 10.0+), and INSERT ... RETURNING or
+// REPLACE ... RETURNING (MariaDB 10.5+)
+func (v mariadbVersion) supportsReturning(statement string) bool {
+	if strings.EqualFold(statement, "delete") {
+		return v.atLeast(10, 0)
+	}
+
+	return v.atLeast(10, 5)
+}
+
+var mariadbVersionPattern = regexp.MustCompile(`^(\d+)\.(\d+)\.\d+-MariaDB`)
+
+// parseMariaDBVersion parses the result of select version(), e.g. 10.6.12-MariaDB-1:10.6.12+maria~ubu2004
+func parseMariaDBVersion(s string) (mariadbVersion, bool) {
+	// some proxies report a 5.5.5- prefix for compatibility with old mysql clients
+	s = strings.TrimPrefix(s, "5.5.5-")
+
+	matches := mariadbVersionPattern.FindStringSubmatch(s)
+	if matches == nil {
+		return mariadbVersion{}, false
+	}
+
+	major, _ := strconv.Atoi(matches[1])
+	minor, _ := strconv.Atoi(matches[2])
+
+	return mariadbVersion{major: major, minor: minor}, true
+}
+
+// mariadbServerVersion queries the MariaDB server version, which is remembered so that
+// errors can be explained (see returningError)
+func (drv *Driver) mariadbServerVersion(db *sql.DB) (mariadbVersion, bool, error) {
+	value, err := dbutil.QueryValue(db, "select version()")
+	if err != nil {
+		return mariadbVersion{}, false, err
+	}
+
+	version, ok := parseMariaDBVersion(value)
+	if ok {
+		drv.serverVersion = &version
+	}
+	return version, ok, nil
+}
+
+// returningPattern matches a statement with a RETURNING clause
+var returningPattern = regexp.MustCompile(`(?is)\b(insert|replace|delete)\b[^;]*\breturning\b`)
+
+// returningError explains a syntax error caused by a RETURNING clause which the server
+// does not support, returning nil if the query does not use one. MySQL does not support
+// RETURNING at all, and MariaDB supports it depending on the version detected when the
+// migrations table was created.
+func (drv *Driver) returningError(query string) error {
+	match := returningPattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil
+	}
+	statement := strings.ToUpper(match[1])
+
+	if !drv.mariadb {
+		return fmt.Errorf("%s ... RETURNING is not supported by MySQL "+
+			"(use the mariadb protocol if the server is MariaDB)", statement)
+	}
+	if drv.serverVersion == nil || drv.serverVersion.supportsReturning(statement) {
+		return nil
+	}
+
+	required := "10.5"
+	if statement == "DELETE" {
+		required = "10.0"
+	}
+	return fmt.Errorf("%s ... RETURNING requires MariaDB %s or later (the server is %d.%d)",
+		statement, required, drv.serverVersion.major, drv.serverVersion.minor)
+}
+
+// dumpCommand returns the schema dump binary. For MariaDB we prefer mariadb-dump,
+// since recent MariaDB releases deprecate (and some distributions omit) mysqldump.
+func (drv *Driver) dumpCommand() string {
+	if drv.mariadb {
+		if _, err := exec.LookPath("mariadb-dump"); err == nil {
+			return "mariadb-dump"
+		}
+	}
+
+	return "mysqldump"
+}
+
+// older releases report the client version followed by the server version,
+// e.g. "Ver 10.13 Distrib 5.7.44", while mysqldump 8.0+ reports only "Ver 8.0.35"
+var mysqldumpVersionPattern = regexp.MustCompile(`Ver (?:[\d.]+ Distrib )?(\d+)\.`)
+
+// dumpVersionArgs returns extra arguments required by the dump binary, which is run with
+// --version, in a container if the dump_tool parameter selects one
+func (drv *Driver) dumpVersionArgs(cmd dbutil.DumpCommand) []string {
+	if (!drv.mariadb && !drv.tidb) || cmd.Name != "mysqldump" {
+		return nil
+	}
+
+	cmd.Args = []string{"--version"}
+	output, err := cmd.Run()
+	if err != nil {
+		return nil
+	}
+
+	return mysqldumpVersionArgs(string(output))
+}
+
+// mysqldumpVersionArgs returns the arguments needed to dump a MariaDB or TiDB database
+// using the mysqldump binary with the given --version output
+func mysqldumpVersionArgs(version string) []string {
+	if strings.Contains(version, "MariaDB") {
+		return nil
+	}
+
+	// mysqldump 8.0+ queries information_schema.column_statistics, which MariaDB and TiDB lack
+	matches := mysqldumpVersionPattern.FindStringSubmatch(version)
+	if matches == nil {
+		return nil
+	}
+	if major, _ := strconv.Atoi(matches[1]); major >= 8 {
+		return []string{"--column-statistics=0"}
+	}
+
+	return nil
+}
+
+// trimSequenceValues removes the current values of MariaDB sequences from schema dumps,
+// which otherwise change every time a sequence is used
+func trimSequenceValues(data []byte) []byte {
+	setvalPattern := regexp.MustCompile("(?m)^(?:DO|SELECT) SETVAL\\(.*\\);\n")
+	return setvalPattern.ReplaceAll(data, []byte(""))
+}
@@ -0,0 +1,108 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMariaDBDriver(t *testing.T) {
+	db := dbmate.New(dbutil.MustParseURL("mariadb://host/foo"))
+	drvInterface, err := db.Driver()
+	require.NoError(t, err)
+
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.True(t, drv.mariadb)
+
+	// mariadb urls use the same connection string as mysql
+	require.Equal(t, "tcp(host:3306)/foo?multiStatements=true", connectionString(drv.databaseURL))
+
+	// mysql urls are not treated as mariadb
+	db = dbmate.New(dbutil.MustParseURL("mysql://host/foo"))
+	drvInterface, err = db.Driver()
+	require.NoError(t, err)
+	require.False(t, drvInterface.(*Driver).mariadb)
+}
+
+func TestParseMariaDBVersion(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected mariadbVersion
+		ok       bool
+	}{
+		{"10.6.12-MariaDB-1:10.6.12+maria~ubu2004", mariadbVersion{10, 6}, true},
+		{"5.5.5-10.2.44-MariaDB", mariadbVersion{10, 2}, true},
+		{"11.2.2-MariaDB", mariadbVersion{11, 2}, true},
+		{"8.0.35", mariadbVersion{}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			version, ok := parseMariaDBVersion(c.input)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.expected, version)
+		})
+	}
+
+	require.False(t, mariadbVersion{10, 2}.supportsSequences())
+	require.True(t, mariadbVersion{10, 3}.supportsSequences())
+	require.True(t, mariadbVersion{11, 0}.supportsSequences())
+
+	require.True(t, mariadbVersion{10, 0}.supportsReturning("DELETE"))
+	require.False(t, mariadbVersion{10, 4}.supportsReturning("INSERT"))
+	require.True(t, mariadbVersion{10, 5}.supportsReturning("REPLACE"))
+}
+
+func TestReturningError(t *testing.T) {
+	syntaxErr := &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax; " +
+		"check the manual for the right syntax to use near 'returning id' at line 1"}
+	query := "insert into users (name) values ('a')\nreturning id;"
+
+	// mysql does not support returning
+	drv := &Driver{}
+	err := drv.QueryError(query, syntaxErr)
+	require.ErrorIs(t, err, syntaxErr)
+	require.Contains(t, err.Error(), "INSERT ... RETURNING is not supported by MySQL "+
+		"(use the mariadb protocol if the server is MariaDB)")
+
+	// mariadb depends on the detected version
+	drv = &Driver{mariadb: true, serverVersion: &mariadbVersion{10, 4}}
+	err = drv.QueryError(query, syntaxErr)
+	require.Contains(t, err.Error(), "INSERT ... RETURNING requires MariaDB 10.5 or later (the server is 10.4)")
+	require.NotContains(t, drv.QueryError("delete from users returning id", syntaxErr).Error(), "RETURNING")
+
+	drv.serverVersion = &mariadbVersion{10, 5}
+	require.NotContains(t, drv.QueryError(query, syntaxErr).Error(), "RETURNING")
+
+	// other syntax errors are unchanged
+	drv.serverVersion = &mariadbVersion{10, 4}
+	require.NotContains(t, drv.QueryError("select returning", syntaxErr).Error(), "RETURNING")
+}
+
+func TestMySQLDumpVersionArgs(t *testing.T) {
+	require.Equal(t, []string{"--column-statistics=0"},
+		mysqldumpVersionArgs("mysqldump  Ver 8.0.35 for Linux on x86_64 (MySQL Community Server - GPL)"))
+	require.Nil(t, mysqldumpVersionArgs("mysqldump  Ver 10.19 Distrib 10.6.12-MariaDB, for debian-linux-gnu (x86_64)"))
+	require.Nil(t, mysqldumpVersionArgs("mysqldump  Ver 10.13 Distrib 5.7.44, for Linux (x86_64)"))
+
+	// only applies to mariadb urls
+	drv := &Driver{mariadb: false}
+	require.Nil(t, drv.dumpVersionArgs(dbutil.DumpCommand{Name: "mysqldump"}))
+}
+
+func TestTrimSequenceValues(t *testing.T) {
+	input := "CREATE SEQUENCE `s` start with 1 minvalue 1 maxvalue 9223372036854775806 " +
+		"increment by 1 cache 1000 nocycle ENGINE=InnoDB;\n" +
+		"SELECT SETVAL(`s`, 1001, 0);\n" +
+		"DO SETVAL(`s`, 2001, 0);\n" +
+		"CREATE TABLE `t` (`id` int);\n"
+
+	require.Equal(t, "CREATE SEQUENCE `s` start with 1 minvalue 1 maxvalue 9223372036854775806 "+
+		"increment by 1 cache 1000 nocycle ENGINE=InnoDB;\n"+
+		"CREATE TABLE `t` (`id` int);\n", string(trimSequenceValues([]byte(input))))
+}
@@ -2,28 +2,51 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/amacneil/dbmate/v2/pkg/driver/tokenauth"
 
-	_ "github.com/go-sql-driver/mysql" // database/sql driver
+	"github.com/go-sql-driver/mysql"
 )
 
 func init() {
 	dbmate.RegisterDriver(NewDriver, "mysql")
+	dbmate.RegisterDriver(NewDriver, "mariadb")
+	dbmate.RegisterDriver(NewDriver, "tidb")
+	dbutil.RegisterQuoter(&Driver{}, "mysql")
+	dbutil.RegisterQuoter(&Driver{}, "mariadb")
+	dbutil.RegisterQuoter(&Driver{}, "tidb")
 }
 
 // Driver provides top level database functions
 type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
+	dialContext         dbmate.DialContextFunc
 	log                 io.Writer
+	lockTimeout         time.Duration
+	sessionSetup        []string
+	statementTimeout    time.Duration
+	mariadb             bool
+	tidb                bool
+	tokens              tokenauth.Tokens
+	waitOperation       dbmate.WaitOperationFunc
+	// serverVersion is the MariaDB server version, once it has been queried
+	serverVersion *mariadbVersion
 }
 
 // NewDriver initializes the driver
@@ -31,13 +54,69 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 	return &Driver{
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
+		dialContext:         config.DialContext,
 		log:                 config.Log,
+		lockTimeout:         config.LockTimeout,
+		sessionSetup:        config.SessionSetup,
+		statementTimeout:    config.StatementTimeout,
+		mariadb:             config.DatabaseURL != nil && config.DatabaseURL.Scheme == "mariadb",
+		tidb:                config.DatabaseURL != nil && config.DatabaseURL.Scheme == "tidb",
+		waitOperation:       config.WaitOperation,
 	}
 }
 
+// URL query parameters which configure the schema dump, and are not passed to the
+// database connection
+const (
+	dumpRoutinesParam      = "dump_routines"
+	dumpTriggersParam      = "dump_triggers"
+	dumpEventsParam        = "dump_events"
+	dumpExcludeTablesParam = "dump_exclude_tables"
+)
+
+var dumpParams = []string{dumpRoutinesParam, dumpTriggersParam, dumpEventsParam, dumpExcludeTablesParam,
+	dbutil.DumpToolParam, dbutil.DumpExtraArgsParam}
+
+// skipBinlogParam is a URL query parameter which disables binary logging (sql_log_bin=0)
+// for dbmate's bookkeeping tables, or for all statements, and is not passed to the
+// database connection
+const skipBinlogParam = "skip_binlog"
+
+// skip_binlog parameter values
+const (
+	// skipBinlogBookkeeping writes the migrations and audit tables without binary logging
+	skipBinlogBookkeeping = "bookkeeping"
+	// skipBinlogAll disables binary logging for every statement, so migrations are not
+	// replicated, and a read-only server (e.g. a replica) may be migrated on its own
+	skipBinlogAll = "all"
+)
+
+// sqlModeParam is a URL query parameter which sets the sql_mode of each session, e.g.
+// sql_mode=ANSI_QUOTES,STRICT_TRANS_TABLES, so migrations do not depend on the server
+// default
+const sqlModeParam = "sql_mode"
+
+// sqlModeRegexp matches a single sql_mode, e.g. ANSI_QUOTES
+var sqlModeRegexp = regexp.MustCompile(`^[A-Z0-9_]+$`)
+
+// ErrReadOnly is returned when migrating a read-only server, which is usually a replica
+var ErrReadOnly = errors.New("database server is read-only")
+
+// ErrSessionSetupDump is returned when dumping the schema with session setup statements,
+// which mysqldump can't execute
+var ErrSessionSetupDump = errors.New("session setup statements can't be applied to mysqldump (use --no-dump-schema)")
+
 func connectionString(u *url.URL) string {
 	query := u.Query()
 	query.Set("multiStatements", "true")
+	for _, param := range dumpParams {
+		query.Del(param)
+	}
+	query.Del(skipBinlogParam)
+	for _, param := range dbutil.TLSParams {
+		query.Del(param)
+	}
+	authQuery(query)
 
 	host := u.Host
 	protocol := "tcp"
@@ -48,7 +127,7 @@ func connectionString(u *url.URL) string {
 		query.Del("socket")
 	} else if u.Port() == "" {
 		// set default port
-		host = fmt.Sprintf("%s:3306", host)
+		host = fmt.Sprintf("%s:%s", host, defaultPort(u))
 	}
 
 	// Get decoded user:pass
@@ -69,9 +148,182 @@ func connectionString(u *url.URL) string {
 	return normalizedString
 }
 
+// sessionParams returns the session system variables for the configured timeouts.
+// go-sql-driver/mysql sets unknown DSN parameters as system variables on each connection.
+func (drv *Driver) sessionParams() map[string]string {
+	params := map[string]string{}
+
+	// mysql and tidb only apply max_execution_time to SELECT statements, so their
+	// statements are killed by a timeoutConnector instead
+	if drv.statementTimeout > 0 && drv.mariadb {
+		params["max_statement_time"] = strconv.FormatFloat(drv.statementTimeout.Seconds(), 'f', -1, 64)
+	}
+
+	if drv.lockTimeout > 0 {
+		// lock timeouts are specified in whole seconds, with a minimum of 1
+		seconds := strconv.FormatInt(int64(math.Max(1, math.Ceil(drv.lockTimeout.Seconds()))), 10)
+		params["lock_wait_timeout"] = seconds
+		params["innodb_lock_wait_timeout"] = seconds
+	}
+
+	if mode, _ := drv.skipBinlog(); mode == skipBinlogAll {
+		params["sql_log_bin"] = "0"
+	}
+
+	if modes, _ := drv.sqlModes(); modes != nil {
+		params[sqlModeParam] = "'" + strings.Join(modes, ",") + "'"
+	}
+
+	return params
+}
+
+// sqlModes returns the upper case modes of the sql_mode parameter, or nil if it is not
+// set. An empty parameter returns an empty slice, which clears the server default.
+func (drv *Driver) sqlModes() ([]string, error) {
+	if drv.databaseURL == nil {
+		return nil, nil
+	}
+
+	query := drv.databaseURL.Query()
+	if !query.Has(sqlModeParam) {
+		return nil, nil
+	}
+
+	modes := []string{}
+	for _, mode := range strings.Split(strings.Trim(query.Get(sqlModeParam), `'"`), ",") {
+		mode = strings.ToUpper(strings.TrimSpace(mode))
+		if mode == "" {
+			continue
+		}
+		if !sqlModeRegexp.MatchString(mode) {
+			return nil, fmt.Errorf("invalid %s parameter: %s", sqlModeParam, query.Get(sqlModeParam))
+		}
+		modes = append(modes, mode)
+	}
+
+	return modes, nil
+}
+
+// ansiQuotes returns true if the sql_mode parameter treats double quotes as identifier
+// quotes
+func (drv *Driver) ansiQuotes() bool {
+	modes, _ := drv.sqlModes()
+	for _, mode := range modes {
+		if mode == "ANSI" || mode == "ANSI_QUOTES" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipBinlog returns the value of the skip_binlog parameter
+func (drv *Driver) skipBinlog() (string, error) {
+	if drv.databaseURL == nil {
+		return "", nil
+	}
+
+	switch value := drv.databaseURL.Query().Get(skipBinlogParam); value {
+	case "", skipBinlogBookkeeping, skipBinlogAll:
+		return value, nil
+	default:
+		return "", fmt.Errorf("invalid %s parameter: %s (expected %s or %s)", skipBinlogParam, value,
+			skipBinlogBookkeeping, skipBinlogAll)
+	}
+}
+
+// openDB opens a connection to the URL, using the custom dialer if one was supplied, and
+// executing the session setup statements on each connection
+func (drv *Driver) openDB(u *url.URL, sessionSetup []string) (*sql.DB, error) {
+	if _, err := drv.skipBinlog(); err != nil {
+		return nil, err
+	}
+	if _, err := drv.sqlModes(); err != nil {
+		return nil, err
+	}
+	u, err := drv.authURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// add session variables, unless already specified in the URL
+	params := drv.sessionParams()
+	if len(params) > 0 {
+		query := u.Query()
+		for name, value := range params {
+			// sql_mode is replaced by its quoted form
+			if !query.Has(name) || name == sqlModeParam {
+				query.Set(name, value)
+			}
+		}
+		clone := *u
+		clone.RawQuery = query.Encode()
+		u = &clone
+	}
+
+	tlsConfig, err := dbutil.TLSConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		// go-sql-driver/mysql looks up custom TLS configs by name
+		name := fmt.Sprintf("dbmate-%p", drv)
+		if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return nil, err
+		}
+
+		query := u.Query()
+		query.Set("tls", name)
+		clone := *u
+		clone.RawQuery = query.Encode()
+		u = &clone
+	}
+
+	tokens, err := drv.tokens.Get(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := mysql.ParseDSN(connectionString(u))
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Net == "tcp" && drv.dialContext != nil {
+		// go-sql-driver/mysql looks up custom dialers by network name
+		cfg.Net = fmt.Sprintf("dbmate-%p", drv)
+		mysql.RegisterDialContext(cfg.Net, func(ctx context.Context, addr string) (net.Conn, error) {
+			return drv.dialContext(ctx, "tcp", addr)
+		})
+	}
+
+	var connector driver.Connector
+	if tokens != nil {
+		connector = tokenConnector(cfg, tokens)
+	} else if connector, err = mysql.NewConnector(cfg); err != nil {
+		return nil, err
+	}
+
+	if killTimeout := drv.killTimeout(); killTimeout > 0 {
+		connector = &timeoutConnector{Connector: connector, timeout: killTimeout}
+	}
+
+	return dbutil.OpenDB(connector, sessionSetup), nil
+}
+
+// killTimeout returns the statement timeout enforced by killing statements, which is
+// used unless the server applies it to all statements itself (MariaDB)
+func (drv *Driver) killTimeout() time.Duration {
+	if drv.mariadb {
+		return 0
+	}
+
+	return drv.statementTimeout
+}
+
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("mysql", connectionString(drv.databaseURL))
+	return drv.openDB(drv.databaseURL, drv.sessionSetup)
 }
 
 func (drv *Driver) openRootDB() (*sql.DB, error) {
@@ -84,16 +336,21 @@ func (drv *Driver) openRootDB() (*sql.DB, error) {
 	// connect to no particular database
 	rootURL.Path = "/"
 
-	return sql.Open("mysql", connectionString(rootURL))
+	// the session setup statements are only executed on connections to the database itself
+	return drv.openDB(rootURL, nil)
 }
 
-func (drv *Driver) quoteIdentifier(str string) string {
-	str = strings.Replace(str, "`", "\\`", -1)
+// QuoteIdentifier quotes a name with backticks
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteBacktickIdentifier(name)
+}
 
-	return fmt.Sprintf("`%s`", str)
+// QuoteLiteral quotes a string literal, escaping quotes and backslashes with a backslash
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteBackslashEscapedLiteral(value)
 }
 
-// CreateDatabase creates the specified database
+// CreateDatabase creates the specified database (if it does not already exist)
 func (drv *Driver) CreateDatabase() error {
 	name := dbutil.DatabaseName(drv.databaseURL)
 	fmt.Fprintf(drv.log, "Creating: %s\n", name)
@@ -104,8 +361,9 @@ func (drv *Driver) CreateDatabase() error {
 	}
 	defer dbutil.MustClose(db)
 
-	_, err = db.Exec(fmt.Sprintf("create database %s",
-		drv.quoteIdentifier(name)))
+	// another process may have created the database concurrently
+	_, err = db.Exec(fmt.Sprintf("create database if not exists %s",
+		drv.QuoteIdentifier(name)))
 
 	return err
 }
@@ -122,17 +380,68 @@ func (drv *Driver) DropDatabase() error {
 	defer dbutil.MustClose(db)
 
 	_, err = db.Exec(fmt.Sprintf("drop database if exists %s",
-		drv.quoteIdentifier(name)))
+		drv.QuoteIdentifier(name)))
 
 	return err
 }
 
-func (drv *Driver) mysqldumpArgs() []string {
+// mysqldumpArgs returns the arguments of mysqldump, including the password
+func (drv *Driver) mysqldumpArgs() ([]string, error) {
+	return drv.dumpArgs(true)
+}
+
+// dumpArgs returns the arguments of mysqldump, which include the password if
+// inlinePassword is set. Otherwise, it must be passed using dumpPassword.
+func (drv *Driver) dumpArgs(inlinePassword bool) ([]string, error) {
+	query := drv.databaseURL.Query()
+	// TiDB has no stored routines or triggers
+	routines, err := boolParam(query, dumpRoutinesParam, !drv.tidb)
+	if err != nil {
+		return nil, err
+	}
+	triggers, err := boolParam(query, dumpTriggersParam, !drv.tidb)
+	if err != nil {
+		return nil, err
+	}
+	events, err := boolParam(query, dumpEventsParam, false)
+	if err != nil {
+		return nil, err
+	}
+	if drv.tidb {
+		if err := checkTiDBDumpParams(routines, triggers, events); err != nil {
+			return nil, err
+		}
+	}
+
 	// generate CLI arguments
-	args := []string{"--opt", "--routines", "--no-data",
-		"--skip-dump-date", "--skip-add-drop-table"}
+	args := []string{"--opt"}
+	if routines {
+		args = append(args, "--routines")
+	}
+	args = append(args, "--no-data", "--skip-dump-date", "--skip-add-drop-table")
+	if !triggers {
+		args = append(args, "--skip-triggers")
+	}
+	if events {
+		args = append(args, "--events")
+	}
+	if drv.tidb {
+		// TiDB has no tablespaces, and mysqldump fails to list them
+		args = append(args, "--no-tablespaces")
+	}
+	// mysqldump sets its own sql_mode, so quote identifiers the same way as migrations
+	if drv.ansiQuotes() {
+		args = append(args, "--compatible=ansi")
+	}
 
-	socket := drv.databaseURL.Query().Get("socket")
+	name := dbutil.DatabaseName(drv.databaseURL)
+	for _, table := range strings.Split(query.Get(dumpExcludeTablesParam), ",") {
+		if table = strings.TrimSpace(table); table != "" {
+			args = append(args, fmt.Sprintf("--ignore-table=%s.%s", name, table))
+		}
+	}
+
+	socket := query.Get("socket")
 	if socket != "" {
 		args = append(args, "--socket="+socket)
 	} else {
@@ -141,28 +450,99 @@ func (drv *Driver) mysqldumpArgs() []string {
 		}
 		if port := drv.databaseURL.Port(); port != "" {
 			args = append(args, "--port="+port)
+		} else if drv.tidb {
+			args = append(args, "--port="+tidbDefaultPort)
 		}
 	}
 
+	// mysqldump cannot override the server name
+	if caFile := dbutil.TLSCAFile(query); caFile != "" {
+		args = append(args, "--ssl-ca="+caFile)
+	}
+
+	plugin, err := drv.authPlugin()
+	if err != nil {
+		return nil, err
+	}
+	if plugin != "" {
+		args = append(args, "--default-auth="+plugin)
+	}
+	if drv.cleartextAllowed() {
+		args = append(args, "--enable-cleartext-plugin")
+	}
+	if path := query.Get(serverPublicKeyParam); path != "" {
+		args = append(args, "--server-public-key-path="+path)
+	}
+
 	if username := drv.databaseURL.User.Username(); username != "" {
 		args = append(args, "--user="+username)
 	}
-	if password, set := drv.databaseURL.User.Password(); set {
-		args = append(args, "--password="+password)
+	if inlinePassword {
+		password, set, err := drv.dumpPassword()
+		if err != nil {
+			return nil, err
+		}
+		if set {
+			args = append(args, "--password="+password)
+		}
+	}
+
+	extraArgs, err := dbutil.DumpExtraArgs(query)
+	if err != nil {
+		return nil, err
 	}
+	args = append(args, extraArgs...)
 
 	// add database name
-	args = append(args, dbutil.DatabaseName(drv.databaseURL))
+	args = append(args, name)
 
-	return args
+	return args, nil
+}
+
+// dumpPassword returns the password mysqldump authenticates with, which is a token valid
+// for the duration of the dump if a token provider is configured
+func (drv *Driver) dumpPassword() (string, bool, error) {
+	tokens, err := drv.tokens.Get(drv.databaseURL)
+	if err != nil {
+		return "", false, err
+	}
+	if tokens != nil {
+		token, err := tokens.Token(context.Background())
+		if err != nil {
+			return "", false, err
+		}
+		return token, true, nil
+	}
+
+	password, set := drv.databaseURL.User.Password()
+	return password, set, nil
+}
+
+// boolParam returns the value of a boolean URL query parameter, or def if it is not set
+func boolParam(query url.Values, name string, def bool) (bool, error) {
+	value := query.Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s parameter: %s", name, value)
+	}
+
+	return b, nil
 }
 
 func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	migrationsTable := drv.quotedMigrationsTableName()
 
 	// load applied migrations
+	applied, err := dbutil.AppliedCondition(db, migrationsTable)
+	if err != nil {
+		return nil, err
+	}
 	migrations, err := dbutil.QueryColumn(db,
-		fmt.Sprintf("select quote(version) from %s order by version asc", migrationsTable))
+		fmt.Sprintf("select quote(version) from %s%s order by version asc", migrationsTable, applied))
 	if err != nil {
 		return nil, err
 	}
@@ -184,9 +564,71 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DumpTools returns the executable run to dump the schema
+func (drv *Driver) DumpTools() ([]string, error) {
+	name, _, err := dbutil.DumpCommand{
+		Tool: drv.databaseURL.Query().Get(dbutil.DumpToolParam),
+		Name: drv.dumpCommand(),
+	}.Command()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{name}, nil
+}
+
+// dumpMounts returns the socket and the files used to connect, which must be mounted when
+// running mysqldump in a container
+func (drv *Driver) dumpMounts() []string {
+	query := drv.databaseURL.Query()
+	mounts := []string{}
+	for _, path := range []string{query.Get("socket"), dbutil.TLSCAFile(query), query.Get(serverPublicKeyParam)} {
+		if path != "" {
+			mounts = append(mounts, path)
+		}
+	}
+
+	return mounts
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
-	schema, err := dbutil.RunCommand("mysqldump", drv.mysqldumpArgs()...)
+	if len(drv.sessionSetup) > 0 {
+		return nil, ErrSessionSetupDump
+	}
+
+	cmd := dbutil.DumpCommand{
+		Tool:      drv.databaseURL.Query().Get(dbutil.DumpToolParam),
+		EnvPrefix: "MYSQL_",
+	}
+	dumpArgs, err := drv.dumpArgs(cmd.Tool == "")
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Tool == "" {
+		cmd.Name = drv.dumpCommand()
+	} else {
+		// rely on the image matching the server, which provides mariadb-dump for MariaDB
+		cmd.Name = "mysqldump"
+		if drv.mariadb {
+			cmd.Name = "mariadb-dump"
+		}
+		cmd.Mounts = drv.dumpMounts()
+
+		// the password is passed to the container in the environment, since the docker
+		// command line is visible to other users
+		password, set, err := drv.dumpPassword()
+		if err != nil {
+			return nil, err
+		}
+		if set {
+			cmd.Env = []string{"MYSQL_PWD=" + password}
+		}
+	}
+	cmd.Args = append(drv.dumpVersionArgs(cmd), dumpArgs...)
+
+	schema, err := cmd.Run()
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +643,22 @@ func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return trimAutoincrementValues(schema), nil
+	schema = trimAutoincrementValues(schema)
+	if drv.tidb {
+		schema = trimTiDBDump(schema)
+	}
+
+	if drv.mariadb {
+		version, ok, err := drv.mariadbServerVersion(db)
+		if err != nil {
+			return nil, err
+		}
+		if ok && version.supportsSequences() {
+			schema = trimSequenceValues(schema)
+		}
+	}
+
+	return schema, nil
 }
 
 // trimAutoincrementValues removes AUTO_INCREMENT values from MySQL schema dumps
@@ -233,8 +690,8 @@ func (drv *Driver) DatabaseExists() (bool, error) {
 // MigrationsTableExists checks if the schema_migrations table exists
 func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
 	match := ""
-	err := db.QueryRow(fmt.Sprintf("show tables like '%s'",
-		drv.migrationsTableName)).
+	err := db.QueryRow(fmt.Sprintf("show tables like %s",
+		drv.QuoteLiteral(drv.migrationsTableName))).
 		Scan(&match)
 	if err == sql.ErrNoRows {
 		return false, nil
@@ -245,17 +702,112 @@ func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
 
 // CreateMigrationsTable creates the schema_migrations table
 func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
-	_, err := db.Exec(fmt.Sprintf(
+	// the version is detected before migrating, to explain unsupported syntax
+	if drv.mariadb && drv.serverVersion == nil {
+		if _, _, err := drv.mariadbServerVersion(db); err != nil {
+			return err
+		}
+	}
+
+	return drv.execBookkeeping(db, fmt.Sprintf(
 		"create table if not exists %s (version varchar(128) primary key)",
 		drv.quotedMigrationsTableName()))
+}
+
+// CheckWritable returns ErrReadOnly if the server is read-only, unless binary logging is
+// disabled for all statements (in which case a replica is being migrated on purpose, by
+// a user with privileges to bypass read_only). super_read_only can't be bypassed. It is
+// called before migrating, but not before read-only commands such as dump.
+func (drv *Driver) CheckWritable(db *sql.DB) error {
+	query := "select @@global.read_only, @@global.super_read_only"
+	if drv.mariadb {
+		// mariadb does not have super_read_only
+		query = "select @@global.read_only, 0"
+	}
+
+	var readOnly, superReadOnly bool
+	if err := db.QueryRow(query).Scan(&readOnly, &superReadOnly); err != nil {
+		return err
+	}
+
+	if superReadOnly {
+		return fmt.Errorf("%w (super_read_only is enabled), migrations must be run against the primary",
+			ErrReadOnly)
+	}
+	if mode, _ := drv.skipBinlog(); readOnly && mode != skipBinlogAll {
+		return fmt.Errorf("%w (read_only is enabled), migrations must be run against the primary, "+
+			"or with %s=%s to migrate this server without replicating the changes", ErrReadOnly,
+			skipBinlogParam, skipBinlogAll)
+	}
+
+	return nil
+}
+
+// execBookkeeping executes a statement which writes to the migrations or audit tables. If
+// skip_binlog=bookkeeping, binary logging is disabled for the statement on the same
+// connection (the migration transaction, if any), and then restored.
+func (drv *Driver) execBookkeeping(db dbutil.Transaction, query string, args ...interface{}) error {
+	if mode, _ := drv.skipBinlog(); mode != skipBinlogBookkeeping {
+		_, err := db.Exec(query, args...)
+		return err
+	}
+
+	// sql_log_bin is a session variable, so every statement must use one connection
+	if sqlDB, ok := db.(*sql.DB); ok {
+		conn, err := sqlDB.Conn(context.Background())
+		if err != nil {
+			return err
+		}
+		defer dbutil.MustClose(conn)
+		db = connTransaction{conn}
+	}
+
+	if _, err := db.Exec("set @dbmate_sql_log_bin = @@session.sql_log_bin, session sql_log_bin = 0"); err != nil {
+		return fmt.Errorf("unable to disable binary logging for %s=%s (sql_log_bin can't be "+
+			"changed inside a transaction, so migrations which don't end it with a DDL statement "+
+			"must use transaction:false): %w", skipBinlogParam, skipBinlogBookkeeping, err)
+	}
+
+	_, err := db.Exec(query, args...)
+	if _, restoreErr := db.Exec("set session sql_log_bin = @dbmate_sql_log_bin"); err == nil {
+		err = restoreErr
+	}
 
 	return err
 }
 
+// connTransaction adapts a single connection to the dbutil.Transaction interface
+type connTransaction struct {
+	conn *sql.Conn
+}
+
+func (c connTransaction) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c connTransaction) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c connTransaction) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
+
+// MigrationsTableColumns returns the lower case names of the columns of the migrations
+// table
+func (drv *Driver) MigrationsTableColumns(db *sql.DB) ([]string, error) {
+	return dbutil.TableColumns(db, drv.quotedMigrationsTableName())
+}
+
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
-	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	applied, err := dbutil.AppliedCondition(db, drv.quotedMigrationsTableName())
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("select version from %s%s order by version desc", drv.quotedMigrationsTableName(), applied)
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
 	}
@@ -283,20 +835,184 @@ func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, err
 	return migrations, nil
 }
 
-// InsertMigration adds a new migration record
+// InsertMigration adds a new migration record. For TiDB, the record is added once the
+// DDL jobs queued by the migration have finished.
 func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
-	_, err := db.Exec(
+	if drv.tidb {
+		if err := drv.waitDDLJobs(db); err != nil {
+			return err
+		}
+	}
+
+	return drv.execBookkeeping(db,
 		fmt.Sprintf("insert into %s (version) values (?)", drv.quotedMigrationsTableName()),
 		version)
+}
 
-	return err
+// SelectSkippedMigrations returns the versions of the migrations recorded as skipped
+func (drv *Driver) SelectSkippedMigrations(db *sql.DB) (map[string]bool, error) {
+	return dbutil.SelectSkippedVersions(db, drv.quotedMigrationsTableName())
+}
+
+// InsertSkippedMigration records a migration as skipped
+func (drv *Driver) InsertSkippedMigration(db *sql.DB, version string) error {
+	if err := dbutil.AddSkippedColumn(db, drv.quotedMigrationsTableName()); err != nil {
+		return err
+	}
+
+	return drv.execBookkeeping(db,
+		fmt.Sprintf("insert into %s (version, %s) values (?, true)",
+			drv.quotedMigrationsTableName(), dbutil.SkippedColumn),
+		version)
 }
 
-// DeleteMigration removes a migration record
+// DeleteMigration removes a migration record. For TiDB, the record is removed once the
+// DDL jobs queued by the rollback have finished.
 func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
-	_, err := db.Exec(
+	if drv.tidb {
+		if err := drv.waitDDLJobs(db); err != nil {
+			return err
+		}
+	}
+
+	return drv.execBookkeeping(db,
 		fmt.Sprintf("delete from %s where version = ?", drv.quotedMigrationsTableName()),
 		version)
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	return drv.execBookkeeping(db, fmt.Sprintf("create table if not exists %s ("+
+		"id bigint unsigned auto_increment primary key, "+
+		"version varchar(128) not null, "+
+		"direction varchar(4) not null, "+
+		"checksum varchar(64) not null, "+
+		"started_at datetime(6) not null, "+
+		"finished_at datetime(6) not null, "+
+		"duration_ms bigint not null, "+
+		"hostname varchar(255) not null, "+
+		"os_user varchar(255) not null, "+
+		"dbmate_version varchar(32) not null)",
+		drv.quotedAuditTableName()))
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	return drv.execBookkeeping(db,
+		fmt.Sprintf("insert into %s (version, direction, checksum, started_at, finished_at, "+
+			"duration_ms, hostname, os_user, dbmate_version) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			drv.quotedAuditTableName()),
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		record.Duration().Milliseconds(), record.Hostname, record.User, record.DbmateVersion)
+}
+
+// auditTimeFormat formats the audit table times, which are stored in UTC, so they can
+// be read without the parseTime connection parameter
+const auditTimeFormat = "%Y-%m-%dT%H:%i:%s.%fZ"
+
+// SelectAudit returns the records of the audit table, or nil if it does not exist
+func (drv *Driver) SelectAudit(db *sql.DB) ([]dbmate.AuditRecord, error) {
+	exists := false
+	err := db.QueryRow("select 1 from information_schema.tables "+
+		"where table_schema = database() and table_name = ?",
+		drv.migrationsTableName+dbmate.AuditTableSuffix).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf("select version, direction, checksum, "+
+		"date_format(started_at, '%[2]s'), date_format(finished_at, '%[2]s'), "+
+		"hostname, os_user, dbmate_version from %[1]s order by id",
+		drv.quotedAuditTableName(), auditTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	records := []dbmate.AuditRecord{}
+	for rows.Next() {
+		var r dbmate.AuditRecord
+		var startedAt, finishedAt string
+		if err := rows.Scan(&r.Version, &r.Direction, &r.Checksum, &startedAt, &finishedAt,
+			&r.Hostname, &r.User, &r.DbmateVersion); err != nil {
+			return nil, err
+		}
+		if r.StartedAt, err = time.Parse(time.RFC3339Nano, startedAt); err != nil {
+			return nil, err
+		}
+		if r.FinishedAt, err = time.Parse(time.RFC3339Nano, finishedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// TableColumns returns the columns of each table
+func (drv *Driver) TableColumns(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query("select c.table_name, c.column_name "+
+		"from information_schema.columns c "+
+		"join information_schema.tables t "+
+		"on t.table_schema = c.table_schema and t.table_name = c.table_name "+
+		"where t.table_type = 'BASE TABLE' and c.table_schema = database() and c.table_name <> ? "+
+		"order by c.table_name, c.ordinal_position", drv.migrationsTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	tables := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		tables[table] = append(tables[table], column)
+	}
+
+	return tables, rows.Err()
+}
+
+// ServerVersion returns the MySQL or MariaDB server version
+func (drv *Driver) ServerVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("select concat(@@version_comment, ' ', @@version)").Scan(&version)
+
+	return version, err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	tables, err := dbutil.QueryColumn(db, "select table_name from information_schema.tables "+
+		"where table_schema = database() and table_type = 'BASE TABLE' and table_name not in (?, ?) "+
+		"order by table_name", drv.migrationsTableName, drv.migrationsTableName+dbmate.AuditTableSuffix)
+	if err != nil {
+		return err
+	}
+
+	// foreign key checks are a session variable, so we must truncate using the same connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(conn)
+
+	if _, err := conn.ExecContext(ctx, "set foreign_key_checks = 0"); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, "truncate table "+drv.QuoteIdentifier(table)); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, "set foreign_key_checks = 1")
 
 	return err
 }
@@ -313,11 +1029,133 @@ func (drv *Driver) Ping() error {
 	return db.Ping()
 }
 
+// BackslashEscapes returns true, since quoted strings use backslash as an escape character
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
+// TransactionalDDL returns false, since DDL statements cause an implicit commit
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// SessionSetup returns true, since the session setup statements are executed on each
+// connection
+func (drv *Driver) SessionSetup() bool {
+	return true
+}
+
+// IsTransientError returns true for errors which may succeed if the migration is
+// retried: dropped connections, deadlocks, lock wait timeouts, and server shutdowns
+func (drv *Driver) IsTransientError(err error) bool {
+	if errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+
+	switch mysqlErr.Number {
+	case 1040, // ER_CON_COUNT_ERROR
+		1053, // ER_SERVER_SHUTDOWN
+		1205, // ER_LOCK_WAIT_TIMEOUT
+		1213: // ER_LOCK_DEADLOCK
+		return true
+	}
+
+	return false
+}
+
+// IsConnectionError returns true for errors caused by failing to connect to the database:
+// dropped connections, too many connections, access denied, and unknown databases
+func (drv *Driver) IsConnectionError(err error) bool {
+	if errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+
+	switch mysqlErr.Number {
+	case 1040, // ER_CON_COUNT_ERROR
+		1044, // ER_DBACCESS_DENIED_ERROR
+		1045, // ER_ACCESS_DENIED_ERROR
+		1049: // ER_BAD_DB_ERROR
+		return true
+	}
+
+	return false
+}
+
+// WaitFailure returns the kind of connection failure which caused err: rejected
+// credentials, or a server which has no connections left
+func (drv *Driver) WaitFailure(err error) string {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return ""
+	}
+
+	switch mysqlErr.Number {
+	case 1044, // ER_DBACCESS_DENIED_ERROR
+		1045: // ER_ACCESS_DENIED_ERROR
+		return dbmate.WaitFailureAuth
+	case 1040: // ER_CON_COUNT_ERROR
+		return dbmate.WaitFailureNotReady
+	}
+
+	return ""
+}
+
+// IsLockError returns true if a statement timed out waiting for a lock (see
+// innodb_lock_wait_timeout and lock_wait_timeout), or a NOWAIT lock was not available
+func (drv *Driver) IsLockError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+
+	return mysqlErr.Number == 1205 || // ER_LOCK_WAIT_TIMEOUT
+		mysqlErr.Number == 3572 // ER_LOCK_NOWAIT
+}
+
+// IsDeadlock returns true if a transaction was rolled back to resolve a deadlock
+func (drv *Driver) IsDeadlock(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1213 // ER_LOCK_DEADLOCK
+}
+
+// syntaxErrorRegexp matches the text quoted by syntax errors, e.g.
+// near 'not_valid_sql' at line 1
+var syntaxErrorRegexp = regexp.MustCompile(`(?s)near '(.*)' at line (\d+)$`)
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
-	return &dbmate.QueryError{Err: err, Query: query}
+	queryErr := &dbmate.QueryError{Err: err, Query: query}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		if match := syntaxErrorRegexp.FindStringSubmatch(mysqlErr.Message); match != nil {
+			queryErr.Position = dbmate.TextPosition(query, match[1])
+			if queryErr.Position == 0 {
+				queryErr.Line, _ = strconv.Atoi(match[2])
+			}
+			if returningErr := drv.returningError(query); returningErr != nil {
+				queryErr.Err = fmt.Errorf("%w: %s", err, returningErr)
+			}
+		}
+	}
+
+	return queryErr
 }
 
 func (drv *Driver) quotedMigrationsTableName() string {
-	return drv.quoteIdentifier(drv.migrationsTableName)
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
 }
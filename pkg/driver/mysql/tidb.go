@@ -0,0 +1,111 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// tidbDefaultPort is the port TiDB listens on by default
+const tidbDefaultPort = "4000"
+
+// tidbDDLJobPollInterval specifies how often unfinished DDL jobs are checked, if no
+// operation waiter is configured
+const tidbDDLJobPollInterval = time.Second
+
+// defaultPort returns the port to connect to if the URL does not specify one
+func defaultPort(u *url.URL) string {
+	if u.Scheme == "tidb" {
+		return tidbDefaultPort
+	}
+
+	return "3306"
+}
+
+// checkTiDBDumpParams returns an error if the dump parameters enable objects which TiDB
+// does not support
+func checkTiDBDumpParams(routines, triggers, events bool) error {
+	params := []struct {
+		name    string
+		enabled bool
+	}{
+		{dumpRoutinesParam, routines},
+		{dumpTriggersParam, triggers},
+		{dumpEventsParam, events},
+	}
+	for _, param := range params {
+		if param.enabled {
+			return fmt.Errorf("%s parameter is not supported by TiDB", param.name)
+		}
+	}
+
+	return nil
+}
+
+var (
+	// tidbPlacementPattern matches placement policy options, which are written as
+	// TiDB specific comments, e.g. /*T![placement] PLACEMENT POLICY=`p1` */
+	tidbPlacementPattern = regexp.MustCompile(`\s*/\*T!\[placement\][^*]*\*/`)
+	// tidbAutoRandomBasePattern matches the next value of an AUTO_RANDOM column, e.g.
+	// /*T![auto_rand_base] AUTO_RANDOM_BASE=30001 */
+	tidbAutoRandomBasePattern = regexp.MustCompile(`\s*/\*T!\[auto_rand_base\][^*]*\*/`)
+)
+
+// trimTiDBDump removes placement policies and AUTO_RANDOM bases from TiDB schema dumps.
+// Placement policies are defined for the whole cluster and are not part of the dump, so
+// a schema referring to them could not be loaded into another cluster, and AUTO_RANDOM
+// bases change whenever rows are inserted.
+func trimTiDBDump(data []byte) []byte {
+	data = tidbPlacementPattern.ReplaceAll(data, nil)
+	return tidbAutoRandomBasePattern.ReplaceAll(data, nil)
+}
+
+// tidbDDLJobsQuery counts the DDL jobs of the current database which have not finished
+const tidbDDLJobsQuery = "select count(*) from information_schema.ddl_jobs " +
+	"where db_name = database() and state not in ('done', 'synced', 'cancelled', 'rollback done')"
+
+// waitDDLJobs waits for the DDL jobs of the database to finish. TiDB runs schema changes
+// as asynchronous jobs, which continue if the statement which queued them is
+// interrupted, so a migration is only recorded once its schema changes are complete.
+func (drv *Driver) waitDDLJobs(db dbutil.Transaction) error {
+	op := ddlJobsOperation{db: db}
+	ctx := context.Background()
+	if drv.waitOperation != nil {
+		return drv.waitOperation(ctx, op)
+	}
+
+	for {
+		done, err := op.Poll(ctx)
+		if done || err != nil {
+			return err
+		}
+		time.Sleep(tidbDDLJobPollInterval)
+	}
+}
+
+// ddlJobsOperation reports whether the unfinished DDL jobs of a TiDB database are done
+type ddlJobsOperation struct {
+	db dbutil.Transaction
+}
+
+func (o ddlJobsOperation) Name() string {
+	return "TiDB DDL jobs"
+}
+
+func (o ddlJobsOperation) Poll(context.Context) (bool, error) {
+	var pending int
+	if err := o.db.QueryRow(tidbDDLJobsQuery).Scan(&pending); err != nil {
+		return false, err
+	}
+
+	return pending == 0, nil
+}
+
+// Progress returns -1, since TiDB reports the rows processed by a job but not the total
+func (o ddlJobsOperation) Progress() int {
+	return -1
+}
@@ -0,0 +1,62 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTiDBDriver(t *testing.T) {
+	db := dbmate.New(dbutil.MustParseURL("tidb://host/foo"))
+	drvInterface, err := db.Driver()
+	require.NoError(t, err)
+
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.True(t, drv.tidb)
+	require.False(t, drv.mariadb)
+
+	// tidb listens on port 4000 by default
+	require.Equal(t, "tcp(host:4000)/foo?multiStatements=true", connectionString(drv.databaseURL))
+	require.Equal(t, "tcp(host:3306)/foo?multiStatements=true",
+		connectionString(dbutil.MustParseURL("tidb://host:3306/foo")))
+}
+
+func TestTiDBDumpArgs(t *testing.T) {
+	drv := &Driver{databaseURL: dbutil.MustParseURL("tidb://bob/mydb"), tidb: true}
+	args, err := drv.mysqldumpArgs()
+	require.NoError(t, err)
+	require.Equal(t, []string{"--opt",
+		"--no-data",
+		"--skip-dump-date",
+		"--skip-add-drop-table",
+		"--skip-triggers",
+		"--no-tablespaces",
+		"--host=bob",
+		"--port=4000",
+		"mydb"}, args)
+
+	drv.databaseURL = dbutil.MustParseURL("tidb://bob/mydb?dump_routines=true")
+	_, err = drv.mysqldumpArgs()
+	require.EqualError(t, err, "dump_routines parameter is not supported by TiDB")
+
+	drv.databaseURL = dbutil.MustParseURL("tidb://bob/mydb?dump_events=true")
+	_, err = drv.mysqldumpArgs()
+	require.EqualError(t, err, "dump_events parameter is not supported by TiDB")
+}
+
+func TestTrimTiDBDump(t *testing.T) {
+	input := "CREATE TABLE `t` (\n" +
+		"  `id` bigint NOT NULL /*T![auto_rand] AUTO_RANDOM(5) */,\n" +
+		"  PRIMARY KEY (`id`) /*T![clustered_index] CLUSTERED */\n" +
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 /*T![auto_rand_base] AUTO_RANDOM_BASE=30001 */ " +
+		"/*T![placement] PLACEMENT POLICY=`p1` */;\n"
+
+	require.Equal(t, "CREATE TABLE `t` (\n"+
+		"  `id` bigint NOT NULL /*T![auto_rand] AUTO_RANDOM(5) */,\n"+
+		"  PRIMARY KEY (`id`) /*T![clustered_index] CLUSTERED */\n"+
+		") ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;\n", string(trimTiDBDump([]byte(input))))
+}
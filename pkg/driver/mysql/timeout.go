@@ -0,0 +1,205 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// timeoutConnector limits how long each statement may run on its connections. MySQL's
+// max_execution_time only applies to read only SELECT statements, so a statement which
+// runs for longer than timeout is interrupted with KILL QUERY from another connection.
+// This applies to DDL and DML, which can otherwise hold locks for the rest of a deploy.
+type timeoutConnector struct {
+	driver.Connector
+	timeout time.Duration
+}
+
+// Connect opens a connection, and queries its ID so that its statements can be killed
+func (c *timeoutConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := connectionID(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &timeoutConn{Conn: conn, connector: c, id: id}, nil
+}
+
+// connectionID returns the ID of a connection, which KILL QUERY expects
+func connectionID(ctx context.Context, conn driver.Conn) (uint64, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return 0, errors.New("mysql connection does not support queries")
+	}
+
+	rows, err := queryer.QueryContext(ctx, "select connection_id()", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	values := make([]driver.Value, 1)
+	if err := rows.Next(values); err != nil {
+		return 0, err
+	}
+
+	switch id := values[0].(type) {
+	case int64:
+		return uint64(id), nil
+	case uint64:
+		return id, nil
+	case []byte:
+		var n uint64
+		_, err := fmt.Sscan(string(id), &n)
+		return n, err
+	default:
+		return 0, fmt.Errorf("unexpected connection id: %v", id)
+	}
+}
+
+// kill interrupts the statement running on a connection
+func (c *timeoutConnector) kill(id uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	execer, ok := conn.(driver.ExecerContext)
+	if !ok {
+		return errors.New("mysql connection does not support statements")
+	}
+	_, err = execer.ExecContext(ctx, fmt.Sprintf("kill query %d", id), nil)
+
+	return err
+}
+
+// timeoutConn is a connection whose statements are killed after the timeout
+type timeoutConn struct {
+	driver.Conn
+	connector *timeoutConnector
+	id        uint64
+
+	// mu guards running, which counts statements so that a timer firing as a statement
+	// finishes does not kill the next one, and active, which is true while one runs
+	mu      sync.Mutex
+	running uint64
+	active  bool
+}
+
+var (
+	_ driver.ExecerContext      = (*timeoutConn)(nil)
+	_ driver.QueryerContext     = (*timeoutConn)(nil)
+	_ driver.ConnBeginTx        = (*timeoutConn)(nil)
+	_ driver.ConnPrepareContext = (*timeoutConn)(nil)
+	_ driver.NamedValueChecker  = (*timeoutConn)(nil)
+	_ driver.Pinger             = (*timeoutConn)(nil)
+	_ driver.SessionResetter    = (*timeoutConn)(nil)
+	_ driver.Validator          = (*timeoutConn)(nil)
+)
+
+// watch starts the timer of a statement, and returns a function which stops it
+func (conn *timeoutConn) watch() func() {
+	conn.mu.Lock()
+	conn.running++
+	conn.active = true
+	statement := conn.running
+	conn.mu.Unlock()
+
+	timer := time.AfterFunc(conn.connector.timeout, func() {
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		if conn.active && conn.running == statement {
+			// the statement returns an "interrupted" error, so a failure to kill it
+			// can only be ignored
+			_ = conn.connector.kill(conn.id)
+		}
+	})
+
+	return func() {
+		timer.Stop()
+		conn.mu.Lock()
+		conn.active = false
+		conn.mu.Unlock()
+	}
+}
+
+func (conn *timeoutConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	defer conn.watch()()
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (conn *timeoutConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := conn.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	defer conn.watch()()
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (conn *timeoutConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := conn.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+
+	return conn.Conn.Prepare(query)
+}
+
+func (conn *timeoutConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := conn.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return conn.Conn.Begin()
+}
+
+func (conn *timeoutConn) Ping(ctx context.Context) error {
+	if pinger, ok := conn.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (conn *timeoutConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := conn.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (conn *timeoutConn) IsValid() bool {
+	if validator, ok := conn.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (conn *timeoutConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := conn.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+
+	return driver.ErrSkip
+}
@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer hands out connections whose "sleep" statements run until they are killed
+type fakeServer struct {
+	mu     sync.Mutex
+	nextID int64
+	killed map[int64]chan struct{}
+	kills  []string
+}
+
+func (s *fakeServer) Connect(context.Context) (driver.Conn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	if s.killed == nil {
+		s.killed = map[int64]chan struct{}{}
+	}
+	s.killed[s.nextID] = make(chan struct{}, 1)
+
+	return &fakeConn{server: s, id: s.nextID}, nil
+}
+
+func (s *fakeServer) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	server *fakeServer
+	id     int64
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	var id int64
+	if n, _ := fmt.Sscanf(query, "kill query %d", &id); n == 1 {
+		c.server.mu.Lock()
+		c.server.kills = append(c.server.kills, query)
+		c.server.killed[id] <- struct{}{}
+		c.server.mu.Unlock()
+		return driver.RowsAffected(0), nil
+	}
+
+	if query == "sleep" {
+		c.server.mu.Lock()
+		killed := c.server.killed[c.id]
+		c.server.mu.Unlock()
+		<-killed
+		return nil, errors.New("Query execution was interrupted")
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{values: []driver.Value{c.id}}, nil
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+type fakeRows struct {
+	values []driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.values == nil {
+		return io.EOF
+	}
+	copy(dest, r.values)
+	r.values = nil
+	return nil
+}
+
+func TestTimeoutConnector(t *testing.T) {
+	server := &fakeServer{}
+	db := sql.OpenDB(&timeoutConnector{Connector: server, timeout: 20 * time.Millisecond})
+	defer db.Close()
+
+	// statements which finish in time are not killed
+	_, err := db.Exec("insert into users (id) values (1)")
+	require.NoError(t, err)
+	time.Sleep(40 * time.Millisecond)
+	require.Empty(t, server.kills)
+
+	// statements which run past the timeout are killed from another connection
+	start := time.Now()
+	_, err = db.Exec("sleep")
+	require.EqualError(t, err, "Query execution was interrupted")
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	require.Equal(t, []string{"kill query 1"}, server.kills)
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"net/url"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/amacneil/dbmate/v2/pkg/driver/tokenauth"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// tokenConnector returns a connector for a connection string, using a new token from the
+// token source as the password of each connection
+func tokenConnector(connStr string, dial dbmate.DialContextFunc, pgxDriver bool,
+	tokens tokenauth.Source,
+) driver.Connector {
+	var sqlDriver driver.Driver = &pq.Driver{}
+	if pgxDriver {
+		sqlDriver = stdlib.GetDefaultDriver()
+	}
+
+	return tokenauth.NewConnector(tokens, sqlDriver, func(password string) (driver.Connector, error) {
+		connStr := withPassword(connStr, password)
+		if pgxDriver {
+			config, err := pgxConfig(connStr, dial)
+			if err != nil {
+				return nil, err
+			}
+			return stdlib.GetConnector(*config), nil
+		}
+
+		connector, err := pq.NewConnector(connStr)
+		if err != nil {
+			return nil, err
+		}
+		if dial != nil {
+			connector.Dialer(dbutil.PQDialer(dial))
+		}
+		return connector, nil
+	})
+}
+
+// withPassword returns the connection string with the password replaced
+func withPassword(connStr, password string) string {
+	u, err := url.Parse(connStr)
+	if err != nil {
+		// lib/pq and pgx report the invalid connection string
+		return connStr
+	}
+	u.User = url.UserPassword(u.User.Username(), password)
+
+	return u.String()
+}
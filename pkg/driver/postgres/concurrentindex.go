@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// DropInvalidIndexes drops the indexes left invalid by failed CREATE INDEX CONCURRENTLY
+// statements, so that they are built again when the migration is retried. An index is
+// only dropped if it is invalid and exists on the table named in the statement.
+func (drv *Driver) DropInvalidIndexes(db *sql.DB, indexes []dbmate.ConcurrentIndex) ([]string, error) {
+	dropped := []string{}
+	for _, index := range indexes {
+		var name string
+		err := db.QueryRow(`select quote_ident(n.nspname) || '.' || quote_ident(c.relname)
+			from pg_catalog.pg_index i
+			join pg_catalog.pg_class c on c.oid = i.indexrelid
+			join pg_catalog.pg_namespace n on n.oid = c.relnamespace
+			where i.indrelid = to_regclass($1::text) and c.relname = $2 and not i.indisvalid`,
+			index.Table, index.Name).Scan(&name)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return dropped, err
+		}
+
+		if _, err := db.Exec("drop index concurrently if exists " + name); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, name)
+	}
+
+	return dropped, nil
+}
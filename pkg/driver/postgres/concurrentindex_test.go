@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func TestPostgresDropInvalidIndexes(t *testing.T) {
+	drv := testPostgresDriver(t)
+	db := prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec("create table users (email text); insert into users values ('a'), ('a')")
+	require.NoError(t, err)
+	_, err = db.Exec("create index concurrently users_valid on users (email)")
+	require.NoError(t, err)
+
+	// a failed concurrent build leaves an invalid index behind
+	_, err = db.Exec("create unique index concurrently users_email on users (email)")
+	require.Error(t, err)
+
+	dropped, err := drv.DropInvalidIndexes(db, []dbmate.ConcurrentIndex{
+		{Name: "users_email", Table: "users"},
+		{Name: "users_valid", Table: "users"},
+		{Name: "users_email", Table: "missing"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"public.users_email"}, dropped)
+
+	indexes, err := dbutil.QueryColumn(db, "select indexname from pg_indexes where tablename = 'users'")
+	require.NoError(t, err)
+	require.Equal(t, []string{"users_valid"}, indexes)
+}
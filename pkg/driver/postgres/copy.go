@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+var (
+	errCopyOptions = errors.New("COPY ... FROM STDIN options are not supported, " +
+		"data must use the default text format")
+	errCopyPgx = errors.New("COPY ... FROM STDIN requires the pq driver")
+)
+
+// copyOptionsRegExp matches the options following FROM STDIN
+var copyOptionsRegExp = regexp.MustCompile(`(?is)\bfrom\s+stdin\b(.*)$`)
+
+// CopyFrom executes a COPY ... FROM STDIN statement, sending rows of data in the default
+// text format (tab separated columns, with \N for null), as written by pg_dump. Like
+// pq.CopyIn, each row is sent as a list of values, which lib/pq encodes in the text
+// format, so other formats and options are not supported.
+func (drv *Driver) CopyFrom(db dbutil.Transaction, query, data string) (int64, error) {
+	// the url was validated when the driver was created
+	if pgx, _ := usePgx(drv.databaseURL); pgx {
+		return -1, errCopyPgx
+	}
+	if match := copyOptionsRegExp.FindStringSubmatch(query); match == nil ||
+		strings.Trim(match[1], " \t\r\n;") != "" {
+		return -1, errCopyOptions
+	}
+
+	switch db := db.(type) {
+	case *sql.DB:
+		// the statement and its data must be sent on the same connection
+		var rows int64
+		err := doTransaction(db, func(tx *sql.Tx) error {
+			var err error
+			rows, err = copyFrom(tx, query, data)
+			return err
+		})
+		return rows, err
+	case *sql.Tx:
+		return copyFrom(db, query, data)
+	default:
+		return -1, errors.New("COPY ... FROM STDIN requires a transaction")
+	}
+}
+
+// copyFrom sends each row of data to a prepared COPY statement
+func copyFrom(tx *sql.Tx, query, data string) (int64, error) {
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return -1, err
+	}
+	// closing fails with the same error as Exec if the copy failed
+	defer func() { _ = stmt.Close() }()
+
+	rows := []string{}
+	if data != "" {
+		rows = strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	}
+	for _, row := range rows {
+		if _, err := stmt.Exec(decodeCopyRow(strings.TrimSuffix(row, "\r"))...); err != nil {
+			return -1, err
+		}
+	}
+
+	// an Exec without values completes the copy
+	result, err := stmt.Exec()
+	if err != nil {
+		return -1, err
+	}
+
+	return result.RowsAffected()
+}
+
+// doTransaction runs f in a transaction, which is committed if f succeeds
+func doTransaction(db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// decodeCopyRow returns the values of a row in the COPY text format, where columns are
+// separated by tabs, \N is null, and backslash escapes special characters
+func decodeCopyRow(row string) []interface{} {
+	columns := strings.Split(row, "\t")
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		if column == `\N` {
+			values[i] = nil
+		} else {
+			values[i] = decodeCopyValue(column)
+		}
+	}
+
+	return values
+}
+
+// decodeCopyValue removes the backslash escapes of a value in the COPY text format
+func decodeCopyValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch c = value[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			// one or two hex digits
+			end := i + 1
+			for end < len(value) && end < i+3 && isHexDigit(value[end]) {
+				end++
+			}
+			if end == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			n, _ := strconv.ParseUint(value[i+1:end], 16, 8)
+			b.WriteByte(byte(n))
+			i = end - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			// one to three octal digits
+			end := i + 1
+			for end < len(value) && end < i+3 && value[end] >= '0' && value[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(value[i:end], 8, 16)
+			b.WriteByte(byte(n))
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"io"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func TestDecodeCopyRow(t *testing.T) {
+	require.Equal(t, []interface{}{"1", "alice", nil}, decodeCopyRow("1\talice\t\\N"))
+	require.Equal(t, []interface{}{"a\tb\nc\\d", ""}, decodeCopyRow(`a\tb\nc\\d`+"\t"))
+	require.Equal(t, []interface{}{"AB\x07z\\"}, decodeCopyRow(`\x41\102\7\z\`))
+	require.Equal(t, []interface{}{`\x0102`}, decodeCopyRow(`\\x0102`))
+}
+
+func TestCopyFromOptions(t *testing.T) {
+	drv := &Driver{databaseURL: dbutil.MustParseURL("postgres://localhost/test")}
+	_, err := drv.CopyFrom(nil, "copy users from stdin with (format csv)", "1,a\n")
+	require.ErrorIs(t, err, errCopyOptions)
+
+	drv = &Driver{databaseURL: dbutil.MustParseURL("postgres://localhost/test?driver=pgx")}
+	_, err = drv.CopyFrom(nil, "copy users from stdin", "1\ta\n")
+	require.ErrorIs(t, err, errCopyPgx)
+}
+
+func TestPostgresCopyFrom(t *testing.T) {
+	db := dbmate.New(dbutil.MustParseURL(os.Getenv("POSTGRES_TEST_URL")))
+	db.AutoDumpSchema = false
+	db.Log = io.Discard
+	db.FS = fstest.MapFS{
+		"db/migrations/001_users.sql": {Data: []byte("-- migrate:up\n" +
+			"create table users (id integer, name text);\n" +
+			"copy users (id, name) from stdin;\n1\talice\n2\t\\N\n3\tit's; fine\n\\.\n" +
+			"insert into users values (4, 'dave');\n" +
+			"-- migrate:down\ndrop table users;\n")},
+		"db/migrations/002_more.sql": {Data: []byte("-- migrate:up transaction:false\n" +
+			"copy users from stdin;\n5\teve\n\\.\n-- migrate:down\n")},
+	}
+
+	err := db.Drop()
+	require.NoError(t, err)
+	err = db.CreateAndMigrate()
+	require.NoError(t, err)
+
+	sqlDB, err := testPostgresDriver(t).Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(sqlDB)
+
+	names, err := dbutil.QueryColumn(sqlDB, "select coalesce(name, 'null') from users order by id")
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "null", "it's; fine", "dave", "eve"}, names)
+}
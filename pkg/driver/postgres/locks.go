@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// postgres table lock modes, see https://www.postgresql.org/docs/current/explicit-locking.html
+const (
+	lockAccessShare          = "ACCESS SHARE"
+	lockRowShare             = "ROW SHARE"
+	lockRowExclusive         = "ROW EXCLUSIVE"
+	lockShareUpdateExclusive = "SHARE UPDATE EXCLUSIVE"
+	lockShare                = "SHARE"
+	lockShareRowExclusive    = "SHARE ROW EXCLUSIVE"
+	lockExclusive            = "EXCLUSIVE"
+	lockAccessExclusive      = "ACCESS EXCLUSIVE"
+)
+
+const relationPattern = `((?:[\w$]+|"[^"]+")(?:\.(?:[\w$]+|"[^"]+"))?)`
+
+// lockRule maps statements matching a pattern to the lock they acquire. The first
+// capture group of the pattern (if any) is the relation which is locked.
+type lockRule struct {
+	pattern *regexp.Regexp
+	lock    string
+}
+
+func rule(pattern, lock string) lockRule {
+	pattern = strings.ReplaceAll(pattern, "REL", relationPattern)
+	pattern = strings.ReplaceAll(pattern, " ", `\s+`)
+	return lockRule{pattern: regexp.MustCompile(`(?is)^` + pattern), lock: lock}
+}
+
+// rules are checked in order, so more specific patterns must come first
+var lockRules = []lockRule{
+	rule(`create (?:unique )?index concurrently (?:if not exists )?(?:[\w$"]+ )?on (?:only )?REL`, lockShareUpdateExclusive),
+	rule(`create (?:unique )?index (?:if not exists )?(?:[\w$"]+ )?on (?:only )?REL`, lockShare),
+	rule(`drop index concurrently (?:if exists )?REL`, lockShareUpdateExclusive),
+	rule(`drop index (?:if exists )?REL`, lockAccessExclusive),
+	rule(`reindex (?:\(.*?\) )?(?:index|table) concurrently REL`, lockShareUpdateExclusive),
+	rule(`reindex (?:\(.*?\) )?(?:index|table) REL`, lockAccessExclusive),
+	rule(`alter table (?:if exists )?(?:only )?REL (?:validate constraint|set statistics|alter (?:column )?[\w$"]+ set statistics|attach partition|detach partition [\w$".]+ concurrently|cluster on|set without cluster)`, lockShareUpdateExclusive),
+	rule(`alter table (?:if exists )?(?:only )?REL add (?:constraint [\w$"]+ )?foreign key`, lockShareRowExclusive),
+	rule(`alter table (?:if exists )?(?:only )?REL (?:enable|disable) trigger`, lockShareRowExclusive),
+	rule(`alter table (?:if exists )?(?:only )?REL`, lockAccessExclusive),
+	rule(`alter (?:index|sequence|view|materialized view) (?:if exists )?REL`, lockAccessExclusive),
+	rule(`create (?:or replace )?(?:constraint )?trigger [\w$"]+ .*? on REL`, lockShareRowExclusive),
+	rule(`drop trigger (?:if exists )?[\w$"]+ on REL`, lockAccessExclusive),
+	rule(`(?:drop table|truncate(?: table)?) (?:if exists )?(?:only )?REL`, lockAccessExclusive),
+	rule(`(?:drop|create or replace) (?:materialized )?view (?:if exists )?REL`, lockAccessExclusive),
+	rule(`refresh materialized view concurrently REL`, lockExclusive),
+	rule(`refresh materialized view REL`, lockAccessExclusive),
+	rule(`vacuum (?:\(.*?full.*?\)|full)(?: [\w ]+)? REL`, lockAccessExclusive),
+	rule(`cluster (?:verbose )?REL`, lockAccessExclusive),
+	rule(`(?:vacuum|analyze)(?: \(.*?\))?(?: verbose| analyze)* REL`, lockShareUpdateExclusive),
+	rule(`comment on (?:table|column) REL`, lockShareUpdateExclusive),
+	rule(`insert into REL`, lockRowExclusive),
+	rule(`update (?:only )?REL`, lockRowExclusive),
+	rule(`delete from (?:only )?REL`, lockRowExclusive),
+	rule(`merge into REL`, lockRowExclusive),
+	rule(`copy REL from`, lockRowExclusive),
+	rule(`select .* for (?:update|no key update|share|key share)`, lockRowShare),
+	rule(`select `, lockAccessShare),
+}
+
+var (
+	lockTableRegexp   = regexp.MustCompile(`(?is)^lock (?:table )?(?:only )?` + relationPattern + `(?:\s+in\s+([a-z ]+?)\s+mode)?\s*(?:nowait\s*)?$`)
+	referencesRegexp  = regexp.MustCompile(`(?is)\breferences\s+` + relationPattern)
+	createTableRegexp = regexp.MustCompile(`(?is)^create\s+`)
+)
+
+// classifyLock returns the lock a statement acquires and the relations it touches,
+// based on the statement type. An empty lock is returned for unknown statements.
+func classifyLock(stmt string) (string, []string) {
+	stmt = strings.TrimSpace(stripComments(stmt))
+
+	if m := lockTableRegexp.FindStringSubmatch(stmt); m != nil {
+		lock := strings.ToUpper(strings.Join(strings.Fields(m[2]), " "))
+		if lock == "" {
+			lock = lockAccessExclusive
+		}
+		return lock, []string{m[1]}
+	}
+
+	lock := ""
+	relations := []string{}
+	for _, r := range lockRules {
+		if m := r.pattern.FindStringSubmatch(stmt); m != nil {
+			lock = r.lock
+			if len(m) > 1 && m[1] != "" {
+				relations = append(relations, m[1])
+			}
+			break
+		}
+	}
+
+	// foreign keys lock the referenced table
+	for _, m := range referencesRegexp.FindAllStringSubmatch(stmt, -1) {
+		relations = append(relations, m[1])
+		if lock == "" && createTableRegexp.MatchString(stmt) {
+			lock = lockShareRowExclusive
+		}
+	}
+
+	return lock, relations
+}
+
+var commentRegexp = regexp.MustCompile(`(?s)--[^\n]*|/\*.*?\*/`)
+
+func stripComments(stmt string) string {
+	return commentRegexp.ReplaceAllString(stmt, "")
+}
+
+// AnalyzeLocks returns the lock a statement will acquire, and the existing relations
+// it touches. The statement is not executed.
+func (drv *Driver) AnalyzeLocks(db *sql.DB, stmt string) (string, []string, error) {
+	if drv.yugabyte {
+		return "", nil, errYugabyteLocks
+	}
+
+	lock, relations := classifyLock(stmt)
+
+	existing := []string{}
+	for _, relation := range relations {
+		name, err := dbutil.QueryValue(db, "select coalesce(to_regclass($1)::text, '')", relation)
+		if err != nil {
+			return "", nil, err
+		}
+		if name != "" && !contains(existing, name) {
+			existing = append(existing, name)
+		}
+	}
+
+	return lock, existing, nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,67 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyLock(t *testing.T) {
+	cases := []struct {
+		stmt      string
+		lock      string
+		relations []string
+	}{
+		{"create index users_email on users (email)", lockShare, []string{"users"}},
+		{"CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS users_email ON public.users (email)",
+			lockShareUpdateExclusive, []string{"public.users"}},
+		{"drop index users_email", lockAccessExclusive, []string{"users_email"}},
+		{"drop index concurrently users_email", lockShareUpdateExclusive, []string{"users_email"}},
+		{"alter table users add column email text", lockAccessExclusive, []string{"users"}},
+		{"alter table users validate constraint users_email_check", lockShareUpdateExclusive, []string{"users"}},
+		{"alter table posts add constraint posts_user_fk foreign key (user_id) references users (id) not valid",
+			lockShareRowExclusive, []string{"posts", "users"}},
+		{"create table posts (id int, user_id int references \"Users\" (id))",
+			lockShareRowExclusive, []string{"\"Users\""}},
+		{"create table posts (id int)", "", []string{}},
+		{"create trigger t before insert on users for each row execute function f()",
+			lockShareRowExclusive, []string{"users"}},
+		{"-- comment\ninsert into users (id) values (1)", lockRowExclusive, []string{"users"}},
+		{"update users set name = 'x'", lockRowExclusive, []string{"users"}},
+		{"delete from users", lockRowExclusive, []string{"users"}},
+		{"truncate users", lockAccessExclusive, []string{"users"}},
+		{"refresh materialized view concurrently stats", lockExclusive, []string{"stats"}},
+		{"vacuum full users", lockAccessExclusive, []string{"users"}},
+		{"analyze users", lockShareUpdateExclusive, []string{"users"}},
+		{"lock table users in share row exclusive mode", lockShareRowExclusive, []string{"users"}},
+		{"lock users", lockAccessExclusive, []string{"users"}},
+		{"select * from users for update", lockRowShare, []string{}},
+		{"create extension pgcrypto", "", []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.stmt, func(t *testing.T) {
+			lock, relations := classifyLock(c.stmt)
+			require.Equal(t, c.lock, lock)
+			require.Equal(t, c.relations, relations)
+		})
+	}
+}
+
+func TestPostgresAnalyzeLocks(t *testing.T) {
+	drv := testPostgresDriver(t)
+	db := prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec("create table users (id int)")
+	require.NoError(t, err)
+
+	// only existing relations are reported
+	lock, relations, err := drv.AnalyzeLocks(db,
+		"alter table posts add constraint posts_user_fk foreign key (user_id) references users (id)")
+	require.NoError(t, err)
+	require.Equal(t, lockShareRowExclusive, lock)
+	require.Equal(t, []string{"users"}, relations)
+}
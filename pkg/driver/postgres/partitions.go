@@ -0,0 +1,181 @@
+package postgres
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// dumpPartitionsParam is a URL query parameter which sets how partitioned tables are
+// ordered in schema dumps. Unlike other parameters, it is not sent to the server as a
+// setting.
+const dumpPartitionsParam = "dump_partitions"
+
+// dumpPartitionsGrouped places each partition, and the statement attaching it, after the
+// partitioned table
+const dumpPartitionsGrouped = "grouped"
+
+// groupPartitionsEnabled returns true if the URL query parameters group partitions in
+// schema dumps
+func groupPartitionsEnabled(query url.Values) (bool, error) {
+	switch value := query.Get(dumpPartitionsParam); value {
+	case "":
+		return false, nil
+	case dumpPartitionsGrouped:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s parameter: %s (expected %s)", dumpPartitionsParam,
+			value, dumpPartitionsGrouped)
+	}
+}
+
+var (
+	// dumpEntryHeaderRegexp matches the comment which precedes each object in a pg_dump
+	// plain text dump, capturing its name, type, and schema
+	dumpEntryHeaderRegexp = regexp.MustCompile(`^\n--\n-- Name: (.*?); Type: (.*?); Schema: (.*?);`)
+	// attachPartitionRegexp matches the statement of a TABLE ATTACH entry, capturing the
+	// partitioned table and the partition
+	attachPartitionRegexp = regexp.MustCompile(`ALTER TABLE ONLY (\S+) ATTACH PARTITION (\S+) `)
+)
+
+// dumpEntry is an object in a pg_dump plain text dump, including its header comment
+type dumpEntry struct {
+	name       string
+	objectType string
+	schema     string
+	text       []byte
+}
+
+// splitDumpEntries splits a pg_dump plain text dump into the session settings which
+// begin it, the entry of each object, and the comment which ends it
+func splitDumpEntries(schema []byte) ([]byte, []dumpEntry, []byte) {
+	sep := []byte("\n--\n-- Name: ")
+	pos := bytes.Index(schema, sep)
+	if pos < 0 {
+		return schema, nil, nil
+	}
+
+	header := schema[:pos]
+	var footer []byte
+	if end := bytes.LastIndex(schema, []byte("\n--\n-- PostgreSQL database dump complete")); end > pos {
+		schema, footer = schema[:end], schema[end:]
+	}
+	entries := []dumpEntry{}
+	for pos < len(schema) {
+		end := bytes.Index(schema[pos+1:], sep)
+		if end < 0 {
+			end = len(schema)
+		} else {
+			end += pos + 1
+		}
+
+		entry := dumpEntry{text: schema[pos:end]}
+		if matches := dumpEntryHeaderRegexp.FindSubmatch(entry.text); matches != nil {
+			entry.name = string(matches[1])
+			entry.objectType = string(matches[2])
+			entry.schema = string(matches[3])
+		}
+		entries = append(entries, entry)
+		pos = end
+	}
+
+	return header, entries, footer
+}
+
+// groupPartitionsDump reorders a pg_dump plain text dump so that each partitioned table is
+// followed by its partitions, each immediately followed by the statement attaching it.
+// pg_dump sorts tables by name and attaches every partition after all tables have been
+// created, which scatters the partitions of heavily partitioned tables across the dump.
+// Partitions of partitions are grouped in the same way, and partitions keep the order
+// in which pg_dump wrote them (by name), so the result is deterministic.
+func groupPartitionsDump(schema []byte) []byte {
+	header, entries, footer := splitDumpEntries(schema)
+
+	// find the partitions of each table, and the entries which create and attach them
+	partitions := map[string][]string{}
+	tables := map[string]int{}
+	attaches := map[string]int{}
+	for i, entry := range entries {
+		switch entry.objectType {
+		case "TABLE":
+			tables[entry.schema+"."+entry.name] = i
+		case "TABLE ATTACH":
+			matches := attachPartitionRegexp.FindSubmatch(entry.text)
+			if matches == nil {
+				continue
+			}
+			parent, partition := unquotedName(string(matches[1])), unquotedName(string(matches[2]))
+			partitions[parent] = append(partitions[parent], partition)
+			attaches[partition] = i
+		}
+	}
+	if len(attaches) == 0 {
+		return schema
+	}
+
+	// only partitions whose table and attach entries were both found are moved
+	moved := map[int]bool{}
+	for partition, attach := range attaches {
+		if table, ok := tables[partition]; ok {
+			moved[table] = true
+			moved[attach] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	var writePartitions func(parent string)
+	writePartitions = func(parent string) {
+		for _, partition := range partitions[parent] {
+			table, ok := tables[partition]
+			if !ok {
+				continue
+			}
+			buf.Write(entries[table].text)
+			buf.Write(entries[attaches[partition]].text)
+			writePartitions(partition)
+		}
+	}
+	for i, entry := range entries {
+		if moved[i] {
+			continue
+		}
+		buf.Write(entry.text)
+		if entry.objectType == "TABLE" {
+			writePartitions(entry.schema + "." + entry.name)
+		}
+	}
+	buf.Write(footer)
+
+	return buf.Bytes()
+}
+
+// unquotedName returns a schema qualified name, as pg_dump writes it in statements, in the
+// form used by entry header comments (schema.name, without quotes)
+func unquotedName(qualified string) string {
+	parts := []string{}
+	part := []byte{}
+	quoted := false
+	for i := 0; i < len(qualified); i++ {
+		c := qualified[i]
+		switch {
+		case c == '"' && quoted && i+1 < len(qualified) && qualified[i+1] == '"':
+			part = append(part, '"')
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			parts = append(parts, string(part))
+			part = []byte{}
+		default:
+			part = append(part, c)
+		}
+	}
+	parts = append(parts, string(part))
+
+	if len(parts) != 2 {
+		return qualified
+	}
+	return parts[0] + "." + parts[1]
+}
@@ -0,0 +1,135 @@
+package postgres
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupPartitionsEnabled(t *testing.T) {
+	enabled, err := groupPartitionsEnabled(url.Values{})
+	require.NoError(t, err)
+	require.False(t, enabled)
+
+	enabled, err = groupPartitionsEnabled(url.Values{"dump_partitions": {"grouped"}})
+	require.NoError(t, err)
+	require.True(t, enabled)
+
+	_, err = groupPartitionsEnabled(url.Values{"dump_partitions": {"sorted"}})
+	require.EqualError(t, err, "invalid dump_partitions parameter: sorted (expected grouped)")
+}
+
+// partitionedDump is pg_dump output for a table partitioned by year, whose 2024
+// partition is partitioned by month
+const partitionedDump = `SET statement_timeout = 0;
+
+SET default_tablespace = '';
+
+--
+-- Name: events; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.events (
+    id bigint NOT NULL,
+    created_at date NOT NULL
+)
+PARTITION BY RANGE (created_at);
+
+--
+-- Name: events_2023; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.events_2023 (
+    id bigint NOT NULL,
+    created_at date NOT NULL
+);
+
+--
+-- Name: events_2024; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.events_2024 (
+    id bigint NOT NULL,
+    created_at date NOT NULL
+)
+PARTITION BY RANGE (created_at);
+
+--
+-- Name: events_2024_01; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.events_2024_01 (
+    id bigint NOT NULL,
+    created_at date NOT NULL
+);
+
+--
+-- Name: users; Type: TABLE; Schema: public; Owner: -
+--
+
+CREATE TABLE public.users (
+    id bigint NOT NULL
+);
+
+--
+-- Name: events_2023; Type: TABLE ATTACH; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.events ATTACH PARTITION public.events_2023 FOR VALUES FROM ('2023-01-01') TO ('2024-01-01');
+
+--
+-- Name: events_2024; Type: TABLE ATTACH; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.events ATTACH PARTITION public.events_2024 FOR VALUES FROM ('2024-01-01') TO ('2025-01-01');
+
+--
+-- Name: events_2024_01; Type: TABLE ATTACH; Schema: public; Owner: -
+--
+
+ALTER TABLE ONLY public.events_2024 ATTACH PARTITION public.events_2024_01 FOR VALUES FROM ('2024-01-01') TO ('2024-02-01');
+
+--
+-- PostgreSQL database dump complete
+--
+`
+
+func TestGroupPartitionsDump(t *testing.T) {
+	header, entries, footer := splitDumpEntries([]byte(partitionedDump))
+	require.Equal(t, "SET statement_timeout = 0;\n\nSET default_tablespace = '';\n", string(header))
+	require.Equal(t, "\n--\n-- PostgreSQL database dump complete\n--\n", string(footer))
+	require.Len(t, entries, 8)
+	require.Equal(t, "events_2024_01", entries[7].name)
+	require.Equal(t, "TABLE ATTACH", entries[7].objectType)
+	require.Equal(t, "public", entries[7].schema)
+
+	grouped := string(groupPartitionsDump([]byte(partitionedDump)))
+	require.Equal(t, len(partitionedDump), len(grouped))
+
+	_, entries, _ = splitDumpEntries([]byte(grouped))
+	order := []string{}
+	for _, entry := range entries {
+		order = append(order, entry.objectType+" "+entry.name)
+	}
+	require.Equal(t, []string{
+		"TABLE events",
+		"TABLE events_2023",
+		"TABLE ATTACH events_2023",
+		"TABLE events_2024",
+		"TABLE ATTACH events_2024",
+		"TABLE events_2024_01",
+		"TABLE ATTACH events_2024_01",
+		"TABLE users",
+	}, order)
+	require.Contains(t, grouped, "PostgreSQL database dump complete\n--\n")
+
+	// dumps without partitions are unchanged
+	require.Equal(t, "SET statement_timeout = 0;\n", string(groupPartitionsDump([]byte("SET statement_timeout = 0;\n"))))
+}
+
+func TestUnquotedName(t *testing.T) {
+	require.Equal(t, "public.events", unquotedName("public.events"))
+	require.Equal(t, "My Schema.Events.2024", unquotedName(`"My Schema"."Events.2024"`))
+	require.Equal(t, `a"b.c`, unquotedName(`"a""b".c`))
+}
@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// driverParam is a URL query parameter selecting the database/sql driver used to connect:
+// pq (lib/pq, the default) or pgx. Unlike other parameters, it is not sent to the server
+// as a setting.
+const driverParam = "driver"
+
+// usePgx determines whether the URL selects the pgx driver
+func usePgx(u *url.URL) (bool, error) {
+	switch value := u.Query().Get(driverParam); value {
+	case "", "pq":
+		return false, nil
+	case "pgx":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid %s parameter: %s (expected pq or pgx)", driverParam, value)
+	}
+}
+
+// pgxConnector returns a connector for a connection string using the pgx driver, and the
+// custom dialer if one was supplied. Like lib/pq, pgx sends unknown parameters as run-time
+// settings.
+func pgxConnector(connStr string, dial dbmate.DialContextFunc) (driver.Connector, error) {
+	config, err := pgxConfig(connStr, dial)
+	if err != nil {
+		return nil, err
+	}
+
+	return stdlib.GetConnector(*config), nil
+}
+
+// pgxConfig parses a connection string for the pgx driver, using the custom dialer if
+// one was supplied
+func pgxConfig(connStr string, dial dbmate.DialContextFunc) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	if dial != nil {
+		config.DialFunc = pgconn.DialFunc(dial)
+		// pgx resolves the host before dialing, which is left to the dialer instead (e.g.
+		// so that hosts only known to the far side of an ssh tunnel can be reached)
+		config.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+			return []string{host}, nil
+		}
+	}
+
+	return config, nil
+}
+
+// pqError finds the server error in err's chain, returning pgx errors as a *pq.Error so
+// that errors are detected in the same way whichever driver is used
+func pqError(err error) (*pq.Error, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr, true
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil, false
+	}
+
+	return &pq.Error{
+		Severity:         pgErr.Severity,
+		Code:             pq.ErrorCode(pgErr.Code),
+		Message:          pgErr.Message,
+		Detail:           pgErr.Detail,
+		Hint:             pgErr.Hint,
+		Position:         formatErrorField(pgErr.Position),
+		InternalPosition: formatErrorField(pgErr.InternalPosition),
+		InternalQuery:    pgErr.InternalQuery,
+		Where:            pgErr.Where,
+		Schema:           pgErr.SchemaName,
+		Table:            pgErr.TableName,
+		Column:           pgErr.ColumnName,
+		DataTypeName:     pgErr.DataTypeName,
+		Constraint:       pgErr.ConstraintName,
+		File:             pgErr.File,
+		Line:             formatErrorField(pgErr.Line),
+		Routine:          pgErr.Routine,
+	}, true
+}
+
+// formatErrorField formats a pgx error field in the same way as lib/pq, which leaves
+// fields not reported by the server empty
+func formatErrorField(n int32) string {
+	if n == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(int(n))
+}
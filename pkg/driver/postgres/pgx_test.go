@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func testPgxDriver(t *testing.T) *Driver {
+	u := dbutil.MustParseURL(os.Getenv("POSTGRES_TEST_URL"))
+	query := u.Query()
+	query.Set(driverParam, "pgx")
+	u.RawQuery = query.Encode()
+	drv, err := dbmate.New(u).Driver()
+	require.NoError(t, err)
+
+	return drv.(*Driver)
+}
+
+func TestUsePgx(t *testing.T) {
+	pgx, err := usePgx(dbutil.MustParseURL("postgres://myhost/foo"))
+	require.NoError(t, err)
+	require.False(t, pgx)
+
+	pgx, err = usePgx(dbutil.MustParseURL("postgres://myhost/foo?driver=pq"))
+	require.NoError(t, err)
+	require.False(t, pgx)
+
+	pgx, err = usePgx(dbutil.MustParseURL("postgres://myhost/foo?driver=pgx"))
+	require.NoError(t, err)
+	require.True(t, pgx)
+
+	_, err = usePgx(dbutil.MustParseURL("postgres://myhost/foo?driver=pgsql"))
+	require.EqualError(t, err, "invalid driver parameter: pgsql (expected pq or pgx)")
+}
+
+func TestPgxDialContext(t *testing.T) {
+	dialed := []string{}
+	db := dbmate.New(dbutil.MustParseURL("postgres://myhost:1234/foo?driver=pgx&sslmode=disable"))
+	db.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+" "+address)
+		return nil, errors.New("custom dialer")
+	}
+	drv, err := db.Driver()
+	require.NoError(t, err)
+
+	err = drv.Ping()
+	require.ErrorContains(t, err, "custom dialer")
+	require.Contains(t, dialed, "tcp myhost:1234")
+}
+
+func TestPqError(t *testing.T) {
+	pqErr, ok := pqError(&dbmate.QueryError{Err: &pq.Error{Code: "42601", Position: "12"}})
+	require.True(t, ok)
+	require.Equal(t, pq.ErrorCode("42601"), pqErr.Code)
+
+	// pgx errors are converted
+	pqErr, ok = pqError(&pgconn.ConnectError{Config: &pgconn.Config{}})
+	require.False(t, ok)
+	require.Nil(t, pqErr)
+
+	pgErr := &pgconn.PgError{Code: "23505", Message: "duplicate key", Position: 12, ConstraintName: "users_pkey"}
+	pqErr, ok = pqError(&dbmate.QueryError{Err: pgErr})
+	require.True(t, ok)
+	require.Equal(t, pq.ErrorCode("23505"), pqErr.Code)
+	require.Equal(t, "duplicate key", pqErr.Message)
+	require.Equal(t, "12", pqErr.Position)
+	require.Equal(t, "", pqErr.Line)
+	require.Equal(t, "users_pkey", pqErr.Constraint)
+
+	// error detection is the same for both drivers
+	drv := &Driver{}
+	require.True(t, drv.IsTransientError(&pgconn.PgError{Code: "40P01"}))
+	require.True(t, drv.IsConnectionError(&pgconn.PgError{Code: "28P01"}))
+	require.True(t, drv.IsLockError(&pgconn.PgError{Code: "55P03"}))
+	require.True(t, isDuplicateDatabase(&pgconn.PgError{Code: "42P04"}))
+	queryErr := &dbmate.QueryError{}
+	require.ErrorAs(t, drv.QueryError("select\nnot_valid_sql", &pgconn.PgError{Code: "42601", Position: 8}), &queryErr)
+	require.Equal(t, 8, queryErr.Position)
+}
+
+func TestPgxCreateMigrationsTable(t *testing.T) {
+	drv := testPgxDriver(t)
+
+	err := drv.DropDatabase()
+	require.NoError(t, err)
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+	// an existing database is detected from the pgx error
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	db, err := drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+	err = drv.InsertMigration(db, "abc1")
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true}, migrations)
+
+	// multiple statements can be executed at once
+	_, err = db.Exec("create table users (id integer); create table posts (id integer);")
+	require.NoError(t, err)
+
+	_, err = db.Exec("select 1;\nnot_valid_sql")
+	queryErr := &dbmate.QueryError{}
+	require.ErrorAs(t, drv.QueryError("select 1;\nnot_valid_sql", err), &queryErr)
+	require.Equal(t, 11, queryErr.Position)
+}
@@ -2,16 +2,21 @@ package postgres
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/amacneil/dbmate/v2/pkg/driver/tokenauth"
 
 	"github.com/lib/pq"
 )
@@ -19,28 +24,136 @@ import (
 func init() {
 	dbmate.RegisterDriver(NewDriver, "postgres")
 	dbmate.RegisterDriver(NewDriver, "postgresql")
+	dbmate.RegisterDriver(NewDriver, "yugabyte")
+	dbutil.RegisterQuoter(&Driver{}, "postgres")
+	dbutil.RegisterQuoter(&Driver{}, "postgresql")
+	dbutil.RegisterQuoter(&Driver{}, "yugabyte")
 }
 
 // Driver provides top level database functions
 type Driver struct {
+	createMissingSchema bool
 	migrationsTableName string
 	databaseURL         *url.URL
+	dialContext         dbmate.DialContextFunc
 	log                 io.Writer
+	lockTimeout         time.Duration
+	replicationRole     string
+	sessionSetup        []string
+	statementTimeout    time.Duration
+	tokens              tokenauth.Tokens
+	yugabyte            bool
 }
 
 // NewDriver initializes the driver
 func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 	return &Driver{
+		createMissingSchema: config.CreateMissingSchema,
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
+		dialContext:         config.DialContext,
 		log:                 config.Log,
+		lockTimeout:         config.LockTimeout,
+		replicationRole:     config.ReplicationRole,
+		sessionSetup:        config.SessionSetup,
+		statementTimeout:    config.StatementTimeout,
+		yugabyte:            config.DatabaseURL != nil && config.DatabaseURL.Scheme == "yugabyte",
 	}
 }
 
+// extensionsParam is a URL query parameter listing extensions to install when creating
+// the database. Unlike other parameters, it is not sent to the server as a setting.
+const extensionsParam = "extensions"
+
+// URL query parameters which include privileges (GRANT and REVOKE statements) and object
+// ownership (ALTER ... OWNER TO statements) in schema dumps. Unlike other parameters,
+// they are not sent to the server as settings.
+const (
+	dumpPrivilegesParam = "dump_privileges"
+	dumpOwnerParam      = "dump_owner"
+)
+
+// dumpOptionArgs returns the pg_dump arguments which leave privileges and ownership out
+// of the dump, unless the URL query parameters include them
+func dumpOptionArgs(query url.Values) ([]string, error) {
+	args := []string{}
+	for _, option := range []struct{ param, arg string }{
+		{dumpPrivilegesParam, "--no-privileges"},
+		{dumpOwnerParam, "--no-owner"},
+	} {
+		value := query.Get(option.param)
+		include := false
+		if value != "" {
+			var err error
+			include, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s parameter: %s", option.param, value)
+			}
+		}
+		if !include {
+			args = append(args, option.arg)
+		}
+	}
+
+	return args, nil
+}
+
+// URL query parameters which set options of the database created by CreateDatabase.
+// Unlike other parameters, they are not sent to the server as settings.
+var createDatabaseParams = []struct {
+	name    string
+	literal bool
+}{
+	{name: "owner"},
+	{name: "template"},
+	{name: "encoding", literal: true},
+	{name: "lc_collate", literal: true},
+	{name: "lc_ctype", literal: true},
+}
+
+// createDatabaseOptions returns the options of the create database statement, set by
+// the URL query parameters, e.g. " template template_postgis encoding 'UTF8'"
+func createDatabaseOptions(query url.Values) string {
+	options := ""
+	for _, param := range createDatabaseParams {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if param.literal {
+			value = pq.QuoteLiteral(value)
+		} else {
+			value = dbutil.QuoteDoubleQuotedIdentifier(value)
+		}
+		options += fmt.Sprintf(" %s %s", param.name, value)
+	}
+
+	return options
+}
+
 func connectionString(u *url.URL) string {
 	hostname := u.Hostname()
 	port := u.Port()
 	query := u.Query()
+	query.Del(extensionsParam)
+	for _, param := range createDatabaseParams {
+		query.Del(param.name)
+	}
+	query.Del(dbutil.DumpToolParam)
+	query.Del(dbutil.DumpExtraArgsParam)
+	query.Del(dumpPrivilegesParam)
+	query.Del(dumpOwnerParam)
+	query.Del(dumpPartitionsParam)
+	query.Del(driverParam)
+	query.Del(tokenauth.Param)
+
+	// lib/pq and pg_dump read the CA file from sslrootcert, and the server name is
+	// applied when dialing
+	if caFile := dbutil.TLSCAFile(query); caFile != "" {
+		query.Set(dbutil.SSLRootCertParam, caFile)
+	}
+	query.Del(dbutil.TLSCAParam)
+	query.Del(dbutil.ServerNameParam)
 
 	// support socket parameter for consistency with mysql
 	if query.Get("socket") != "" {
@@ -72,12 +185,19 @@ func connectionString(u *url.URL) string {
 	}
 	if port == "" {
 		port = "5432"
+		if u.Scheme == "yugabyte" {
+			port = yugabytePort
+		}
 	}
 
 	// generate output URL
 	out, _ := url.Parse(u.String())
 	out.Host = fmt.Sprintf("%s:%s", hostname, port)
 	out.RawQuery = query.Encode()
+	if out.Scheme == "yugabyte" {
+		// yugabyte speaks the postgres protocol
+		out.Scheme = "postgres"
+	}
 
 	return out.String()
 }
@@ -103,9 +223,102 @@ func connectionArgsForDump(u *url.URL) []string {
 	return out
 }
 
+// dumpFileParams are connection parameters which name local files, such as certificates
+var dumpFileParams = []string{dbutil.SSLRootCertParam, "sslcert", "sslkey", "sslcrl"}
+
+// dumpMounts returns the unix socket directory and the files used to connect, which must
+// be mounted when running pg_dump in a container
+func dumpMounts(u *url.URL) []string {
+	query := dbutil.MustParseURL(connectionString(u)).Query()
+	mounts := []string{}
+	if host := query.Get("host"); strings.HasPrefix(host, "/") {
+		mounts = append(mounts, host)
+	}
+	for _, param := range dumpFileParams {
+		if path := query.Get(param); path != "" {
+			mounts = append(mounts, path)
+		}
+	}
+
+	return mounts
+}
+
+// sessionConnectionString adds the configured timeouts and replication role to a
+// connection string. Unknown parameters are sent by lib/pq as run-time settings, so these
+// apply to every session. Settings specified in the URL take precedence.
+func (drv *Driver) sessionConnectionString(connStr string) string {
+	if drv.statementTimeout == 0 && drv.lockTimeout == 0 && drv.replicationRole == "" {
+		return connStr
+	}
+
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return connStr
+	}
+
+	query := u.Query()
+	if drv.statementTimeout > 0 && !query.Has("statement_timeout") {
+		query.Set("statement_timeout", strconv.FormatInt(drv.statementTimeout.Milliseconds(), 10))
+	}
+	if drv.lockTimeout > 0 && !query.Has("lock_timeout") {
+		query.Set("lock_timeout", strconv.FormatInt(drv.lockTimeout.Milliseconds(), 10))
+	}
+	if drv.replicationRole != "" && !query.Has("session_replication_role") {
+		query.Set("session_replication_role", drv.replicationRole)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// openDB opens a connection string, using the custom dialer if one was supplied, and
+// executing the session setup statements on each connection
+func (drv *Driver) openDB(connStr string, sessionSetup []string) (*sql.DB, error) {
+	connStr = drv.sessionConnectionString(connStr)
+
+	dial := drv.dialContext
+	if serverName := drv.databaseURL.Query().Get(dbutil.ServerNameParam); serverName != "" {
+		var err error
+		connStr, dial, err = dbutil.WithServerName(connStr, serverName, dial)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pgxDriver, err := usePgx(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := drv.tokens.Get(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var connector driver.Connector
+	switch {
+	case tokens != nil:
+		connector = tokenConnector(connStr, dial, pgxDriver, tokens)
+	case pgxDriver:
+		if connector, err = pgxConnector(connStr, dial); err != nil {
+			return nil, err
+		}
+	default:
+		pqConnector, err := pq.NewConnector(connStr)
+		if err != nil {
+			return nil, err
+		}
+		if dial != nil {
+			pqConnector.Dialer(dbutil.PQDialer(dial))
+		}
+		connector = pqConnector
+	}
+
+	return dbutil.OpenDB(connector, sessionSetup), nil
+}
+
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("postgres", connectionString(drv.databaseURL))
+	return drv.openDB(connectionString(drv.databaseURL), drv.sessionSetup)
 }
 
 func (drv *Driver) openPostgresDB() (*sql.DB, error) {
@@ -118,10 +331,11 @@ func (drv *Driver) openPostgresDB() (*sql.DB, error) {
 	// connect to postgres database
 	postgresURL.Path = "postgres"
 
-	return sql.Open("postgres", postgresURL.String())
+	// the session setup statements are only executed on connections to the database itself
+	return drv.openDB(postgresURL.String(), nil)
 }
 
-// CreateDatabase creates the specified database
+// CreateDatabase creates the specified database (if it does not already exist)
 func (drv *Driver) CreateDatabase() error {
 	name := dbutil.DatabaseName(drv.databaseURL)
 	fmt.Fprintf(drv.log, "Creating: %s\n", name)
@@ -132,10 +346,57 @@ func (drv *Driver) CreateDatabase() error {
 	}
 	defer dbutil.MustClose(db)
 
-	_, err = db.Exec(fmt.Sprintf("create database %s",
-		pq.QuoteIdentifier(name)))
+	_, err = db.Exec(fmt.Sprintf("create database %s%s",
+		drv.QuoteIdentifier(name), createDatabaseOptions(drv.databaseURL.Query())))
+	if isDuplicateDatabase(err) {
+		fmt.Fprintf(drv.log, "Database already exists: %s\n", name)
+	} else if err != nil {
+		return err
+	}
 
-	return err
+	return drv.createExtensions()
+}
+
+// createExtensions installs the extensions listed in the extensions URL parameter
+func (drv *Driver) createExtensions() error {
+	extensions := []string{}
+	for _, extension := range strings.Split(drv.databaseURL.Query().Get(extensionsParam), ",") {
+		if extension = strings.TrimSpace(extension); extension != "" {
+			extensions = append(extensions, extension)
+		}
+	}
+	if len(extensions) == 0 {
+		return nil
+	}
+
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	for _, extension := range extensions {
+		fmt.Fprintf(drv.log, "Creating extension: %s\n", extension)
+		_, err := db.Exec("create extension if not exists " + drv.QuoteIdentifier(extension))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isDuplicateDatabase determines whether an error was caused by creating a database
+// which already exists. Concurrent creates may instead fail with a unique violation
+// on the pg_database catalog.
+func isDuplicateDatabase(err error) bool {
+	pqErr, ok := pqError(err)
+	if !ok {
+		return false
+	}
+
+	return pqErr.Code == "42P04" || // duplicate_database
+		(pqErr.Code == "23505" && pqErr.Constraint == "pg_database_datname_index")
 }
 
 // DropDatabase drops the specified database (if it exists)
@@ -150,7 +411,7 @@ func (drv *Driver) DropDatabase() error {
 	defer dbutil.MustClose(db)
 
 	_, err = db.Exec(fmt.Sprintf("drop database if exists %s",
-		pq.QuoteIdentifier(name)))
+		drv.QuoteIdentifier(name)))
 
 	return err
 }
@@ -162,8 +423,12 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	}
 
 	// load applied migrations
+	applied, err := dbutil.AppliedCondition(db, migrationsTable)
+	if err != nil {
+		return nil, err
+	}
 	migrations, err := dbutil.QueryColumn(db,
-		"select quote_literal(version) from "+migrationsTable+" order by version asc")
+		"select quote_literal(version) from "+migrationsTable+applied+" order by version asc")
 	if err != nil {
 		return nil, err
 	}
@@ -181,15 +446,118 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DumpTools returns the executable run to dump the schema
+func (drv *Driver) DumpTools() ([]string, error) {
+	command := "pg_dump"
+	if drv.yugabyte {
+		command = "ysql_dump"
+	}
+	name, _, err := dbutil.DumpCommand{
+		Tool: drv.databaseURL.Query().Get(dbutil.DumpToolParam),
+		Name: command,
+	}.Command()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{name}, nil
+}
+
+// dumpCommand returns the pg_dump invocation, which connects to the database after the
+// given options and extra arguments
+func (drv *Driver) dumpCommand(name string, args, extraArgs []string) (dbutil.DumpCommand, error) {
+	cmd := dbutil.DumpCommand{
+		Tool:      drv.databaseURL.Query().Get(dbutil.DumpToolParam),
+		Name:      name,
+		EnvPrefix: "PG",
+		Mounts:    dumpMounts(drv.databaseURL),
+	}
+
+	sessionArgs, dumpURL, err := dumpSessionSetup(drv.databaseURL, drv.sessionSetup)
+	if err != nil {
+		return cmd, err
+	}
+	tokens, err := drv.tokens.Get(drv.databaseURL)
+	if err != nil {
+		return cmd, err
+	}
+	if tokens != nil {
+		// pg_dump is passed a token which is valid for the duration of the dump
+		token, err := tokens.Token(context.Background())
+		if err != nil {
+			return cmd, err
+		}
+		dumpURL = dbutil.MustParseURL(withPassword(dumpURL.String(), token))
+	}
+	if cmd.Tool != "" {
+		// the password is passed to the container in the environment, since the docker
+		// command line is visible to other users
+		dumpURL = dbutil.MustParseURL(dumpURL.String())
+		query := dumpURL.Query()
+		password, ok := dumpURL.User.Password()
+		if query.Has("password") {
+			password, ok = query.Get("password"), true
+			query.Del("password")
+			dumpURL.RawQuery = query.Encode()
+		}
+		if ok {
+			cmd.Env = []string{"PGPASSWORD=" + password}
+		}
+		username := dumpURL.User.Username()
+		dumpURL.User = nil
+		if username != "" {
+			dumpURL.User = url.User(username)
+		}
+	}
+
+	cmd.Args = append(args, sessionArgs...)
+	cmd.Args = append(cmd.Args, extraArgs...)
+	cmd.Args = append(cmd.Args, connectionArgsForDump(dumpURL)...)
+
+	return cmd, nil
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
+	optionArgs, err := dumpOptionArgs(drv.databaseURL.Query())
+	if err != nil {
+		return nil, err
+	}
+	groupPartitions, err := groupPartitionsEnabled(drv.databaseURL.Query())
+	if err != nil {
+		return nil, err
+	}
+	extraArgs, err := dbutil.DumpExtraArgs(drv.databaseURL.Query())
+	if err != nil {
+		return nil, err
+	}
+
 	// load schema
-	args := append([]string{"--format=plain", "--encoding=UTF8", "--schema-only",
-		"--no-privileges", "--no-owner"}, connectionArgsForDump(drv.databaseURL)...)
-	schema, err := dbutil.RunCommand("pg_dump", args...)
+	args := append([]string{"--format=plain", "--encoding=UTF8", "--schema-only"}, optionArgs...)
+	command := "pg_dump"
+	if drv.yugabyte {
+		command = "ysql_dump"
+		args = append(args, yugabyteDumpArgs...)
+	}
+	cmd, err := drv.dumpCommand(command, args, extraArgs)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	if groupPartitions {
+		schema = groupPartitionsDump(schema)
+	}
+
+	// pg_dump omits extensions when dumping specific schemas
+	extensions, err := drv.installedExtensions(db)
 	if err != nil {
 		return nil, err
 	}
+	schema = addMissingExtensions(schema, extensions)
 
 	migrations, err := drv.schemaMigrationsDump(db)
 	if err != nil {
@@ -200,6 +568,40 @@ func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
 	return dbutil.TrimLeadingSQLComments(schema)
 }
 
+// installedExtensions returns a create extension statement for each installed extension,
+// other than the built in plpgsql extension
+func (drv *Driver) installedExtensions(db *sql.DB) ([]string, error) {
+	return dbutil.QueryColumn(db, "select format('CREATE EXTENSION IF NOT EXISTS %s WITH SCHEMA %s;', "+
+		"quote_ident(e.extname), quote_ident(n.nspname)) "+
+		"from pg_extension e join pg_namespace n on n.oid = e.extnamespace "+
+		"where e.extname <> 'plpgsql' order by e.extname")
+}
+
+// addMissingExtensions inserts the create extension statements which are not already in
+// the schema before the first object in the schema, so they precede dependent objects
+func addMissingExtensions(schema []byte, extensions []string) []byte {
+	var buf bytes.Buffer
+	for _, extension := range extensions {
+		if !bytes.Contains(schema, []byte(extension)) {
+			buf.WriteString("\n" + extension + "\n")
+		}
+	}
+	if buf.Len() == 0 {
+		return schema
+	}
+
+	// pg_dump begins with session settings, followed by a comment preceding each object
+	pos := bytes.Index(schema, []byte("\n--\n-- Name: "))
+	if pos < 0 {
+		pos = len(schema)
+	}
+
+	out := append([]byte{}, schema[:pos]...)
+	out = append(out, buf.Bytes()...)
+
+	return append(out, schema[pos:]...)
+}
+
 // DatabaseExists determines whether the database exists
 func (drv *Driver) DatabaseExists() (bool, error) {
 	name := dbutil.DatabaseName(drv.databaseURL)
@@ -220,6 +622,18 @@ func (drv *Driver) DatabaseExists() (bool, error) {
 	return exists, err
 }
 
+// NativeConnectionParams returns connect_timeout, which lib/pq, pgx, and pg_dump
+// understand, and which must be a number of seconds
+func (drv *Driver) NativeConnectionParams() []string {
+	return []string{dbmate.ConnectTimeoutParam}
+}
+
+// CreatesMissingSchema returns true, since the migrations table may be in a schema,
+// which is created if it does not exist unless create_missing_schema is false
+func (drv *Driver) CreatesMissingSchema() bool {
+	return true
+}
+
 // MigrationsTableExists checks if the schema_migrations table exists
 func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
 	schema, migrationsTableNameParts, err := drv.migrationsTableNameParts(db)
@@ -259,12 +673,16 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 	}
 
 	// catch 'schema does not exist' error
-	pqErr, ok := err.(*pq.Error)
+	pqErr, ok := pqError(err)
 	if !ok || pqErr.Code != "3F000" {
 		// unknown error
 		return err
 	}
 
+	if !drv.createMissingSchema {
+		return fmt.Errorf("%w: %s", dbmate.ErrSchemaNotFound, schema)
+	}
+
 	// in theory we could attempt to create the schema every time, but we avoid that
 	// in case the user doesn't have permissions to create schemas
 	fmt.Fprintf(drv.log, "Creating schema: %s\n", schema)
@@ -278,6 +696,17 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 	return err
 }
 
+// MigrationsTableColumns returns the lower case names of the columns of the migrations
+// table
+func (drv *Driver) MigrationsTableColumns(db *sql.DB) ([]string, error) {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbutil.TableColumns(db, migrationsTable)
+}
+
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
@@ -286,7 +715,12 @@ func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, err
 		return nil, err
 	}
 
-	query := "select version from " + migrationsTable + " order by version desc"
+	applied, err := dbutil.AppliedCondition(db, migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "select version from " + migrationsTable + applied + " order by version desc"
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
 	}
@@ -326,6 +760,32 @@ func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// SelectSkippedMigrations returns the versions of the migrations recorded as skipped
+func (drv *Driver) SelectSkippedMigrations(db *sql.DB) (map[string]bool, error) {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return dbutil.SelectSkippedVersions(db, migrationsTable)
+}
+
+// InsertSkippedMigration records a migration as skipped
+func (drv *Driver) InsertSkippedMigration(db *sql.DB, version string) error {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	if err := dbutil.AddSkippedColumn(db, migrationsTable); err != nil {
+		return err
+	}
+
+	_, err = db.Exec("insert into "+migrationsTable+" (version, "+dbutil.SkippedColumn+") values ($1, true)", version)
+
+	return err
+}
+
 // DeleteMigration removes a migration record
 func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
 	migrationsTable, err := drv.quotedMigrationsTableName(db)
@@ -338,6 +798,163 @@ func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	auditTable, err := drv.quotedAuditTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("create table if not exists " + auditTable + " (" +
+		"id bigserial primary key, " +
+		"version varchar(128) not null, " +
+		"direction varchar(4) not null, " +
+		"checksum varchar(64) not null, " +
+		"started_at timestamptz not null, " +
+		"finished_at timestamptz not null, " +
+		"duration_ms bigint not null, " +
+		"hostname varchar(255) not null, " +
+		"os_user varchar(255) not null, " +
+		"dbmate_version varchar(32) not null)")
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	auditTable, err := drv.quotedAuditTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("insert into "+auditTable+" (version, direction, checksum, started_at, "+
+		"finished_at, duration_ms, hostname, os_user, dbmate_version) "+
+		"values ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		record.Duration().Milliseconds(), record.Hostname, record.User, record.DbmateVersion)
+
+	return err
+}
+
+// SelectAudit returns the records of the audit table, or nil if it does not exist
+func (drv *Driver) SelectAudit(db *sql.DB) ([]dbmate.AuditRecord, error) {
+	auditTable, err := drv.quotedAuditTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	if err := db.QueryRow("select to_regclass($1::text) is not null", auditTable).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := db.Query("select version, direction, checksum, started_at, finished_at, " +
+		"hostname, os_user, dbmate_version from " + auditTable + " order by id")
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	records := []dbmate.AuditRecord{}
+	for rows.Next() {
+		var r dbmate.AuditRecord
+		if err := rows.Scan(&r.Version, &r.Direction, &r.Checksum, &r.StartedAt, &r.FinishedAt,
+			&r.Hostname, &r.User, &r.DbmateVersion); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// TableColumns returns the columns of each table in the migrations table schema
+func (drv *Driver) TableColumns(db *sql.DB) (map[string][]string, error) {
+	schema, migrationsTable, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("select c.table_name, c.column_name "+
+		"from information_schema.columns c "+
+		"join information_schema.tables t using (table_schema, table_name) "+
+		"where t.table_type = 'BASE TABLE' and c.table_schema = $1 and c.table_name <> $2 "+
+		"order by c.table_name, c.ordinal_position",
+		schema, strings.Join(migrationsTable, "."))
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	tables := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		tables[table] = append(tables[table], column)
+	}
+
+	return tables, rows.Err()
+}
+
+// DatabaseFingerprint returns the system identifier of the server, which is shared by
+// its physical replicas, and the name of the current database
+func (drv *Driver) DatabaseFingerprint(db *sql.DB) (string, error) {
+	var fingerprint string
+	err := db.QueryRow("select (select system_identifier from pg_control_system())::text " +
+		"|| '/' || current_database()").Scan(&fingerprint)
+
+	return fingerprint, err
+}
+
+// ServerVersion returns the PostgreSQL server version
+func (drv *Driver) ServerVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("select 'PostgreSQL ' || current_setting('server_version')").Scan(&version)
+
+	return version, err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	schema, migrationsTable, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+
+	// limit to schemas in the search path, if specified
+	schemas := []string{}
+	for _, s := range strings.Split(drv.databaseURL.Query().Get("search_path"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			schemas = append(schemas, s)
+		}
+	}
+
+	tables, err := dbutil.QueryColumn(db, "select format('%I.%I', schemaname, tablename) "+
+		"from pg_tables "+
+		"where schemaname !~ '^pg_' and schemaname <> 'information_schema' "+
+		"and (cardinality($1::text[]) = 0 or schemaname = any($1::text[])) "+
+		"and not (schemaname = $2 and tablename in ($3, $4)) "+
+		"order by schemaname, tablename",
+		pq.Array(schemas), schema, strings.Join(migrationsTable, "."),
+		strings.Join(migrationsTable, ".")+dbmate.AuditTableSuffix)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	// truncate all tables in one statement, so foreign keys between them are satisfied
+	_, err = db.Exec("truncate table " + strings.Join(tables, ", ") + " restart identity cascade")
+
+	return err
+}
+
 // Ping verifies a connection to the database server. It does not verify whether the
 // specified database exists.
 func (drv *Driver) Ping() error {
@@ -356,7 +973,7 @@ func (drv *Driver) Ping() error {
 	}
 
 	// ignore 'database does not exist' error
-	pqErr, ok := err.(*pq.Error)
+	pqErr, ok := pqError(err)
 	if ok && pqErr.Code == "3D000" {
 		return nil
 	}
@@ -364,11 +981,102 @@ func (drv *Driver) Ping() error {
 	return err
 }
 
+// TransactionalDDL returns true, since DDL statements can be rolled back (except by
+// YugabyteDB, which commits DDL separately from the surrounding transaction)
+func (drv *Driver) TransactionalDDL() bool {
+	return !drv.yugabyte
+}
+
+// SessionSetup returns true, since the session setup statements are executed on each
+// connection
+func (drv *Driver) SessionSetup() bool {
+	return true
+}
+
+// Savepoints returns true, since a failed statement can be rolled back to a savepoint
+// without aborting the transaction
+func (drv *Driver) Savepoints() bool {
+	return true
+}
+
+// IsTransientError returns true for errors which may succeed if the migration is
+// retried: connection failures, serialization failures, deadlocks, lock timeouts,
+// and server shutdowns
+func (drv *Driver) IsTransientError(err error) bool {
+	pqErr, ok := pqError(err)
+	if !ok {
+		return false
+	}
+
+	switch pqErr.Code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"55P03", // lock_not_available
+		"57P01", // admin_shutdown
+		"57P03": // cannot_connect_now
+		return true
+	}
+
+	// connection_exception
+	return pqErr.Code.Class() == "08"
+}
+
+// IsConnectionError returns true for errors caused by failing to connect to the database:
+// connection exceptions, authentication failures, missing databases, and servers which
+// are starting up
+func (drv *Driver) IsConnectionError(err error) bool {
+	pqErr, ok := pqError(err)
+	if !ok {
+		return false
+	}
+
+	switch pqErr.Code.Class() {
+	case "08", // connection_exception
+		"28": // invalid_authorization_specification
+		return true
+	}
+
+	return pqErr.Code == "3D000" || // invalid_catalog_name
+		pqErr.Code == "57P03" // cannot_connect_now
+}
+
+// WaitFailure returns the kind of connection failure which caused err: rejected
+// credentials, a server without SSL support, or a server which is starting up
+func (drv *Driver) WaitFailure(err error) string {
+	if errors.Is(err, pq.ErrSSLNotSupported) {
+		return dbmate.WaitFailureTLS
+	}
+
+	pqErr, ok := pqError(err)
+	switch {
+	case !ok:
+		return ""
+	case pqErr.Code.Class() == "28": // invalid_authorization_specification
+		return dbmate.WaitFailureAuth
+	case pqErr.Code == "57P03": // cannot_connect_now
+		return dbmate.WaitFailureNotReady
+	}
+
+	return ""
+}
+
+// IsLockError returns true if a statement timed out waiting for a lock (see lock_timeout)
+func (drv *Driver) IsLockError(err error) bool {
+	pqErr, ok := pqError(err)
+	return ok && pqErr.Code == "55P03" // lock_not_available
+}
+
+// IsDeadlock returns true if a statement was canceled to resolve a deadlock
+func (drv *Driver) IsDeadlock(err error) bool {
+	pqErr, ok := pqError(err)
+	return ok && pqErr.Code == "40P01" // deadlock_detected
+}
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
 	position := 0
 
-	if pqErr, ok := err.(*pq.Error); ok {
+	if pqErr, ok := pqError(err); ok {
 		if pos, err := strconv.Atoi(pqErr.Position); err == nil {
 			position = pos
 		}
@@ -425,6 +1133,27 @@ func (drv *Driver) quotedMigrationsTableNameParts(db dbutil.Transaction) (string
 		return "", "", err
 	}
 
+	return quoteTableNameParts(db, schema, tableNameParts)
+}
+
+// quotedAuditTableName returns the quoted audit table name, which is the migrations
+// table name with dbmate.AuditTableSuffix appended
+func (drv *Driver) quotedAuditTableName(db dbutil.Transaction) (string, error) {
+	schema, tableNameParts, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return "", err
+	}
+
+	tableNameParts[len(tableNameParts)-1] += dbmate.AuditTableSuffix
+	schema, name, err := quoteTableNameParts(db, schema, tableNameParts)
+	if err != nil {
+		return "", err
+	}
+
+	return schema + "." + name, nil
+}
+
+func quoteTableNameParts(db dbutil.Transaction, schema string, tableNameParts []string) (string, string, error) {
 	// quote all parts
 	// use server rather than client to do this to avoid unnecessary quotes
 	// (which would change schema.sql diff)
@@ -437,3 +1166,15 @@ func (drv *Driver) quotedMigrationsTableNameParts(db dbutil.Transaction) (string
 	// if more than one part, we already have a schema
 	return quotedNameParts[0], strings.Join(quotedNameParts[1:], "."), nil
 }
+
+// QuoteIdentifier quotes a name with double quotes
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteDoubleQuotedIdentifier(name)
+}
+
+// QuoteLiteral quotes a string literal, using the escape string syntax (E'...') if it
+// contains backslashes, so that it is parsed the same whatever standard_conforming_strings
+// is set to
+func (drv *Driver) QuoteLiteral(value string) string {
+	return pq.QuoteLiteral(value)
+}
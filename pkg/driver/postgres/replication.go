@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// errYugabyteReplication is returned by ReplicationIssues, since YugabyteDB streams
+// changes through its own CDC service rather than postgres replication slots
+var errYugabyteReplication = fmt.Errorf("%w: yugabyte", dbmate.ErrReplicationUnsupported)
+
+// ReplicationIssues returns a description of each logical replication slot in the
+// current database which is inactive or lags by more than maxLag bytes (0 for no limit),
+// and each enabled subscription whose apply worker is not running
+func (drv *Driver) ReplicationIssues(db *sql.DB, maxLag int64) ([]string, error) {
+	if drv.yugabyte {
+		return nil, errYugabyteReplication
+	}
+
+	issues := []string{}
+
+	rows, err := db.Query(`select slot_name, active,
+			coalesce(pg_wal_lsn_diff(pg_current_wal_lsn(), confirmed_flush_lsn), 0)::bigint
+		from pg_catalog.pg_replication_slots
+		where slot_type = 'logical' and database = current_database()
+		order by slot_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	for rows.Next() {
+		var name string
+		var active bool
+		var lag int64
+		if err := rows.Scan(&name, &active, &lag); err != nil {
+			return nil, err
+		}
+
+		if !active {
+			issues = append(issues, fmt.Sprintf("replication slot %s is inactive", name))
+		} else if maxLag > 0 && lag > maxLag {
+			issues = append(issues, fmt.Sprintf("replication slot %s lags by %d bytes", name, lag))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// subscriptions have one apply worker, which has no relid
+	subscriptions, err := dbutil.QueryColumn(db, `select s.subname
+		from pg_catalog.pg_subscription s
+		join pg_catalog.pg_database d on d.oid = s.subdbid
+		where s.subenabled and d.datname = current_database()
+		and not exists (
+			select 1 from pg_catalog.pg_stat_subscription st
+			where st.subid = s.oid and st.relid is null and st.pid is not null
+		)
+		order by s.subname`)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range subscriptions {
+		issues = append(issues, fmt.Sprintf("subscription %s is not running", name))
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,20 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresReplicationIssues(t *testing.T) {
+	drv := testPostgresDriver(t)
+	db := prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+
+	// the test database has no replication slots or subscriptions
+	issues, err := drv.ReplicationIssues(db, 1)
+	require.NoError(t, err)
+	require.Empty(t, issues)
+}
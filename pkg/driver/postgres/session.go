@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// setRoleRegexp matches a SET ROLE statement, capturing the role
+	setRoleRegexp = regexp.MustCompile(`(?is)^\s*SET\s+(?:SESSION\s+)?ROLE\s+(.+?)\s*;?\s*$`)
+	// setRegexp matches a SET statement, capturing the setting and its value
+	setRegexp = regexp.MustCompile(`(?is)^\s*SET\s+(?:SESSION\s+)?([a-z_][a-z0-9_.]*)\s*(?:=|\s+TO\s+)\s*(.+?)\s*;?\s*$`)
+)
+
+// dumpSessionSetup applies the session setup statements to pg_dump, which can't run
+// arbitrary statements: SET ROLE becomes the --role argument, and other SET statements
+// are added to the options connection parameter of the returned URL. Other statements
+// return an error.
+func dumpSessionSetup(u *url.URL, statements []string) ([]string, *url.URL, error) {
+	if len(statements) == 0 {
+		return nil, u, nil
+	}
+
+	args := []string{}
+	options := []string{}
+	for _, statement := range statements {
+		if matches := setRoleRegexp.FindStringSubmatch(statement); matches != nil {
+			args = append(args, "--role="+unquoteSetValue(matches[1]))
+			continue
+		}
+		matches := setRegexp.FindStringSubmatch(statement)
+		if matches == nil {
+			return nil, nil, fmt.Errorf("session setup statement can't be applied to pg_dump, "+
+				"only SET statements can: %s", statement)
+		}
+		// spaces in option values are escaped with a backslash
+		value := strings.ReplaceAll(unquoteSetValue(matches[2]), " ", `\ `)
+		options = append(options, "-c "+strings.ToLower(matches[1])+"="+value)
+	}
+	if len(options) == 0 {
+		return args, u, nil
+	}
+
+	clone := *u
+	query := clone.Query()
+	if existing := query.Get("options"); existing != "" {
+		options = append([]string{existing}, options...)
+	}
+	query.Set("options", strings.Join(options, " "))
+	clone.RawQuery = query.Encode()
+
+	return args, &clone, nil
+}
+
+// unquoteSetValue removes the quotes around a single value of a SET statement
+func unquoteSetValue(value string) string {
+	if len(value) >= 2 && !strings.Contains(value, ",") {
+		for _, quote := range []string{"'", `"`} {
+			if strings.HasPrefix(value, quote) && strings.HasSuffix(value, quote) {
+				return strings.ReplaceAll(value[1:len(value)-1], quote+quote, quote)
+			}
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpSessionSetup(t *testing.T) {
+	u, err := url.Parse("postgres://host/db?sslmode=disable")
+	require.NoError(t, err)
+
+	// no statements leaves the url unchanged
+	args, dumpURL, err := dumpSessionSetup(u, nil)
+	require.NoError(t, err)
+	require.Empty(t, args)
+	require.Same(t, u, dumpURL)
+
+	args, dumpURL, err = dumpSessionSetup(u, []string{
+		"SET ROLE deployer;",
+		"set search_path to app, public",
+		"SET SESSION lock_timeout = '5s'",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"--role=deployer"}, args)
+	require.Equal(t, `-c search_path=app,\ public -c lock_timeout=5s`, dumpURL.Query().Get("options"))
+	require.Equal(t, "disable", dumpURL.Query().Get("sslmode"))
+	// the original url is not modified
+	require.Equal(t, "postgres://host/db?sslmode=disable", u.String())
+
+	// existing options are kept
+	u, err = url.Parse("postgres://host/db?options=-c%20work_mem%3D64MB")
+	require.NoError(t, err)
+	_, dumpURL, err = dumpSessionSetup(u, []string{"SET statement_timeout TO 0"})
+	require.NoError(t, err)
+	require.Equal(t, "-c work_mem=64MB -c statement_timeout=0", dumpURL.Query().Get("options"))
+
+	_, _, err = dumpSessionSetup(u, []string{"SELECT set_config('role', 'deployer', false)"})
+	require.EqualError(t, err, "session setup statement can't be applied to pg_dump, "+
+		"only SET statements can: SELECT set_config('role', 'deployer', false)")
+}
+
+func TestUnquoteSetValue(t *testing.T) {
+	require.Equal(t, "deployer", unquoteSetValue("deployer"))
+	require.Equal(t, "5s", unquoteSetValue("'5s'"))
+	require.Equal(t, "it's", unquoteSetValue("'it''s'"))
+	require.Equal(t, "My Role", unquoteSetValue(`"My Role"`))
+	// lists of values are left as they are
+	require.Equal(t, `"a", "b"`, unquoteSetValue(`"a", "b"`))
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// maxIdentifierLength is the length postgres truncates identifiers to
+const maxIdentifierLength = 63
+
+// snapshotDatabaseName returns the name of the database which holds a snapshot
+func snapshotDatabaseName(database, snapshot string) (string, error) {
+	name := database + "_snapshot_" + snapshot
+	if len(name) > maxIdentifierLength {
+		return "", fmt.Errorf("%w: %s is longer than %d characters", dbmate.ErrInvalidSnapshotName,
+			name, maxIdentifierLength)
+	}
+
+	return name, nil
+}
+
+// SaveSnapshot copies the database into a snapshot database, using the database as a
+// template. Other sessions connected to the database are terminated, since postgres
+// can't copy a database while it is in use.
+func (drv *Driver) SaveSnapshot(name string) error {
+	return drv.copySnapshot(name, false)
+}
+
+// RestoreSnapshot replaces the database with a copy of a snapshot database. Other
+// sessions connected to the database are terminated, since postgres can't drop a
+// database while it is in use.
+func (drv *Driver) RestoreSnapshot(name string) error {
+	return drv.copySnapshot(name, true)
+}
+
+// copySnapshot replaces the snapshot database with a copy of the database, or the
+// database with a copy of the snapshot database if restore is true
+func (drv *Driver) copySnapshot(name string, restore bool) error {
+	if drv.yugabyte {
+		return dbmate.ErrSnapshotUnsupported
+	}
+
+	database := dbutil.DatabaseName(drv.databaseURL)
+	snapshot, err := snapshotDatabaseName(database, name)
+	if err != nil {
+		return err
+	}
+	source, target := database, snapshot
+	if restore {
+		source, target = snapshot, database
+	}
+
+	db, err := drv.openPostgresDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	exists := false
+	err = db.QueryRow("select true from pg_database where datname = $1", source).Scan(&exists)
+	if err == sql.ErrNoRows && restore {
+		return fmt.Errorf("%w: %s", dbmate.ErrSnapshotNotFound, name)
+	} else if err == sql.ErrNoRows {
+		return fmt.Errorf("database does not exist: %s", source)
+	} else if err != nil {
+		return err
+	}
+
+	for _, datname := range []string{source, target} {
+		if _, err := db.Exec("select pg_terminate_backend(pid) from pg_stat_activity "+
+			"where datname = $1 and pid <> pg_backend_pid()", datname); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.Exec("drop database if exists " + drv.QuoteIdentifier(target)); err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("create database %s template %s",
+		drv.QuoteIdentifier(target), drv.QuoteIdentifier(source)))
+
+	return err
+}
@@ -0,0 +1,54 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotDatabaseName(t *testing.T) {
+	name, err := snapshotDatabaseName("myapp_test", "seeded")
+	require.NoError(t, err)
+	require.Equal(t, "myapp_test_snapshot_seeded", name)
+
+	_, err = snapshotDatabaseName("myapp_test", strings.Repeat("x", 50))
+	require.ErrorIs(t, err, dbmate.ErrInvalidSnapshotName)
+}
+
+func TestPostgresSnapshot(t *testing.T) {
+	drv := testPostgresDriver(t)
+	db := prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec("create table users (id integer); insert into users (id) values (1)")
+	require.NoError(t, err)
+
+	// the open connection is terminated
+	err = drv.SaveSnapshot("seeded")
+	require.NoError(t, err)
+
+	db = prepTestPostgresDB(t)
+	defer dbutil.MustClose(db)
+	_, err = db.Exec("create table posts (id integer)")
+	require.NoError(t, err)
+
+	err = drv.RestoreSnapshot("seeded")
+	require.NoError(t, err)
+
+	db, err = drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+	count, err := dbutil.QueryValue(db, "select count(*) from users")
+	require.NoError(t, err)
+	require.Equal(t, "1", count)
+	exists, err := dbutil.QueryValue(db, "select to_regclass('posts') is not null")
+	require.NoError(t, err)
+	require.Equal(t, "false", exists)
+
+	err = drv.RestoreSnapshot("missing")
+	require.ErrorIs(t, err, dbmate.ErrSnapshotNotFound)
+}
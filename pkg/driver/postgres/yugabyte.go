@@ -0,0 +1,20 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// yugabytePort is the default port of the postgres compatible YSQL API of YugabyteDB
+const yugabytePort = "5433"
+
+// yugabyteDumpArgs are passed to ysql_dump. Tablespaces control how YugabyteDB places
+// data across zones and regions, which is configured per cluster, so they are left out
+// of the schema file.
+var yugabyteDumpArgs = []string{"--no-tablespaces"}
+
+// errYugabyteLocks is returned by AnalyzeLocks, since YugabyteDB does not acquire the
+// postgres table locks which lock analysis reports (schema changes are applied online,
+// and conflicting transactions are aborted rather than blocked)
+var errYugabyteLocks = fmt.Errorf("%w: yugabyte", dbmate.ErrLocksUnsupported)
@@ -0,0 +1,734 @@
+package redshift
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "redshift")
+	dbutil.RegisterQuoter(&Driver{}, "redshift")
+}
+
+// Driver provides top level database functions
+type Driver struct {
+	createMissingSchema bool
+	migrationsTableName string
+	databaseURL         *url.URL
+	dialContext         dbmate.DialContextFunc
+	log                 io.Writer
+	sessionSetup        []string
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		createMissingSchema: config.CreateMissingSchema,
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		dialContext:         config.DialContext,
+		log:                 config.Log,
+		sessionSetup:        config.SessionSetup,
+	}
+}
+
+func connectionString(u *url.URL) string {
+	// redshift speaks the postgres wire protocol
+	out, _ := url.Parse(u.String())
+	out.Scheme = "postgres"
+
+	// always specify a port
+	port := u.Port()
+	if port == "" {
+		port = "5439"
+	}
+	out.Host = fmt.Sprintf("%s:%s", u.Hostname(), port)
+
+	// lib/pq reads the CA file from sslrootcert, and the server name is applied when
+	// dialing
+	query := out.Query()
+	if caFile := dbutil.TLSCAFile(query); caFile != "" {
+		query.Set(dbutil.SSLRootCertParam, caFile)
+	}
+	query.Del(dbutil.TLSCAParam)
+	query.Del(dbutil.ServerNameParam)
+	out.RawQuery = query.Encode()
+
+	return out.String()
+}
+
+// openDB opens a connection string, using the custom dialer if one was supplied, and
+// executing the session setup statements on each connection
+func (drv *Driver) openDB(connStr string, sessionSetup []string) (*sql.DB, error) {
+	dial := drv.dialContext
+	if serverName := drv.databaseURL.Query().Get(dbutil.ServerNameParam); serverName != "" {
+		var err error
+		connStr, dial, err = dbutil.WithServerName(connStr, serverName, dial)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	if dial != nil {
+		connector.Dialer(dbutil.PQDialer(dial))
+	}
+
+	return dbutil.OpenDB(connector, sessionSetup), nil
+}
+
+// Open creates a new database connection
+func (drv *Driver) Open() (*sql.DB, error) {
+	return drv.openDB(connectionString(drv.databaseURL), drv.sessionSetup)
+}
+
+func (drv *Driver) openRedshiftDB() (*sql.DB, error) {
+	// clone databaseURL
+	redshiftURL, err := url.Parse(connectionString(drv.databaseURL))
+	if err != nil {
+		return nil, err
+	}
+
+	// connect to the default database, redshift clusters have no "postgres" database
+	redshiftURL.Path = "dev"
+
+	// the session setup statements are only executed on connections to the database itself
+	return drv.openDB(redshiftURL.String(), nil)
+}
+
+// CreateDatabase creates the specified database (if it does not already exist)
+func (drv *Driver) CreateDatabase() error {
+	name := dbutil.DatabaseName(drv.databaseURL)
+	fmt.Fprintf(drv.log, "Creating: %s\n", name)
+
+	db, err := drv.openRedshiftDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	// redshift does not support any create database options supported by postgres
+	_, err = db.Exec(fmt.Sprintf("create database %s", drv.QuoteIdentifier(name)))
+
+	// another process may have created the database concurrently
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "42P04" {
+		fmt.Fprintf(drv.log, "Database already exists: %s\n", name)
+		return nil
+	}
+
+	return err
+}
+
+// DropDatabase drops the specified database (if it exists)
+func (drv *Driver) DropDatabase() error {
+	name := dbutil.DatabaseName(drv.databaseURL)
+	fmt.Fprintf(drv.log, "Dropping: %s\n", name)
+
+	// redshift does not support "drop database if exists"
+	exists, err := drv.DatabaseExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	db, err := drv.openRedshiftDB()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec(fmt.Sprintf("drop database %s", drv.QuoteIdentifier(name)))
+
+	return err
+}
+
+// dumpSchemas returns the schemas included in schema dumps
+func (drv *Driver) dumpSchemas(db *sql.DB) ([]string, error) {
+	schemas := []string{}
+	for _, schema := range strings.Split(drv.databaseURL.Query().Get("search_path"), ",") {
+		schema = strings.TrimSpace(schema)
+		if schema != "" {
+			schemas = append(schemas, schema)
+		}
+	}
+	if len(schemas) > 0 {
+		return schemas, nil
+	}
+
+	return dbutil.QueryColumn(db, "select nspname from pg_namespace "+
+		"where nspname not like 'pg\\_%' and nspname <> 'information_schema' "+
+		"order by nspname")
+}
+
+// dumpObject is a table or view included in schema dumps
+type dumpObject struct {
+	objectType string
+	schema     string
+	name       string
+}
+
+// schemaDump writes the tables of each schema, followed by the views of every schema in
+// dependency order. The DDL is generated by SHOW TABLE and SHOW VIEW, which are built
+// into Redshift, rather than the v_generate_tbl_ddl admin view behind generate_table_ddl,
+// which is only available where amazon-redshift-utils has been installed.
+func (drv *Driver) schemaDump(db *sql.DB, buf *bytes.Buffer) error {
+	schemas, err := drv.dumpSchemas(db)
+	if err != nil {
+		return err
+	}
+
+	// generating DDL requires a query per object, so objects are introspected
+	// concurrently and written in the order they were listed
+	tablesBySchema, err := dbutil.MapParallel(schemas, dbutil.DumpWorkers, func(schema string) ([]dumpObject, error) {
+		return drv.dumpTables(db, schema)
+	})
+	if err != nil {
+		return err
+	}
+	views, err := drv.dumpViews(db, schemas)
+	if err != nil {
+		return err
+	}
+
+	objects := []dumpObject{}
+	for _, tables := range tablesBySchema {
+		objects = append(objects, tables...)
+	}
+	objects = append(objects, views...)
+	ddl, err := dbutil.MapParallel(objects, dbutil.DumpWorkers, func(obj dumpObject) (string, error) {
+		return drv.showCreate(db, obj)
+	})
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for s, schema := range schemas {
+		if schema != "public" {
+			buf.WriteString(fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;\n\n", drv.QuoteIdentifier(schema)))
+		}
+
+		for range tablesBySchema[s] {
+			buf.WriteString(ddl[i])
+			i++
+		}
+	}
+	for ; i < len(ddl); i++ {
+		buf.WriteString(ddl[i])
+	}
+
+	return nil
+}
+
+// dumpTables returns the tables of a schema, in name order
+func (drv *Driver) dumpTables(db *sql.DB, schema string) ([]dumpObject, error) {
+	tables, err := dbutil.QueryColumn(db,
+		"select tablename from pg_tables where schemaname = $1 order by tablename", schema)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []dumpObject{}
+	for _, table := range tables {
+		objects = append(objects, dumpObject{objectType: "table", schema: schema, name: table})
+	}
+
+	return objects, nil
+}
+
+// dumpViews returns the views of the schemas, ordered so that each view follows the views
+// it selects from
+func (drv *Driver) dumpViews(db *sql.DB, schemas []string) ([]dumpObject, error) {
+	views := []dumpObject{}
+	for _, schema := range schemas {
+		names, err := dbutil.QueryColumn(db,
+			"select viewname from pg_views where schemaname = $1 order by viewname", schema)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			views = append(views, dumpObject{objectType: "view", schema: schema, name: name})
+		}
+	}
+
+	// a view depends on the relations referenced by its rewrite rule. Late binding views
+	// have no dependencies, since they are only resolved when they are queried.
+	rows, err := db.Query(`select distinct view_ns.nspname, view_class.relname, ref_ns.nspname, ref_class.relname
+		from pg_depend
+		join pg_rewrite on pg_rewrite.oid = pg_depend.objid
+		join pg_class view_class on view_class.oid = pg_rewrite.ev_class
+		join pg_namespace view_ns on view_ns.oid = view_class.relnamespace
+		join pg_class ref_class on ref_class.oid = pg_depend.refobjid
+		join pg_namespace ref_ns on ref_ns.oid = ref_class.relnamespace
+		where view_class.relkind = 'v' and ref_class.relkind = 'v' and view_class.oid <> ref_class.oid`)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	deps := map[dumpObject][]dumpObject{}
+	for rows.Next() {
+		view := dumpObject{objectType: "view"}
+		ref := dumpObject{objectType: "view"}
+		if err := rows.Scan(&view.schema, &view.name, &ref.schema, &ref.name); err != nil {
+			return nil, err
+		}
+		deps[view] = append(deps[view], ref)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orderViews(views, deps), nil
+}
+
+// orderViews returns the views with each one after the views it depends on, and otherwise
+// in their original order. Dependencies on views which are not dumped are ignored.
+func orderViews(views []dumpObject, deps map[dumpObject][]dumpObject) []dumpObject {
+	dumped := map[dumpObject]bool{}
+	for _, view := range views {
+		dumped[view] = true
+	}
+
+	ordered := []dumpObject{}
+	visited := map[dumpObject]bool{}
+	var visit func(view dumpObject)
+	visit = func(view dumpObject) {
+		if visited[view] || !dumped[view] {
+			return
+		}
+		visited[view] = true
+		for _, dep := range deps[view] {
+			visit(dep)
+		}
+		ordered = append(ordered, view)
+	}
+	for _, view := range views {
+		visit(view)
+	}
+
+	return ordered
+}
+
+// showCreate returns the DDL for a table or view, as generated by redshift
+func (drv *Driver) showCreate(db *sql.DB, obj dumpObject) (string, error) {
+	ddl, err := dbutil.QueryValue(db, fmt.Sprintf("show %s %s.%s",
+		obj.objectType, drv.QuoteIdentifier(obj.schema), drv.QuoteIdentifier(obj.name)))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimSpace(ddl), ";") + ";\n\n", nil
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		"select quote_literal(version) from "+migrationsTable+" order by version asc")
+	if err != nil {
+		return err
+	}
+
+	// build migrations table data
+	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n")
+
+	if len(migrations) > 0 {
+		buf.WriteString("INSERT INTO " + migrationsTable + " (version) VALUES\n    (" +
+			strings.Join(migrations, "),\n    (") +
+			");\n")
+	}
+
+	return nil
+}
+
+// DumpSchema returns the current database schema
+func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
+	// pg_dump is not compatible with redshift, so we generate the schema natively
+	var buf bytes.Buffer
+
+	if err := drv.schemaDump(db, &buf); err != nil {
+		return nil, err
+	}
+
+	if err := drv.schemaMigrationsDump(db, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DatabaseExists determines whether the database exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	name := dbutil.DatabaseName(drv.databaseURL)
+
+	db, err := drv.openRedshiftDB()
+	if err != nil {
+		return false, err
+	}
+	defer dbutil.MustClose(db)
+
+	exists := false
+	err = db.QueryRow("select true from pg_database where datname = $1", name).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+// NativeConnectionParams returns connect_timeout, which lib/pq understands,
+// and which must be a number of seconds
+func (drv *Driver) NativeConnectionParams() []string {
+	return []string{dbmate.ConnectTimeoutParam}
+}
+
+// CreatesMissingSchema returns true, since the migrations table may be in a schema,
+// which is created if it does not exist unless create_missing_schema is false
+func (drv *Driver) CreatesMissingSchema() bool {
+	return true
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	schema, table, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return false, err
+	}
+
+	exists := false
+	err = db.QueryRow("select true from pg_tables "+
+		"where schemaname = $1 and tablename = $2", schema, table).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	return exists, err
+}
+
+// CreateMigrationsTable creates the schema_migrations table
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	schema, _, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	// redshift accepts but does not enforce primary keys
+	createTableStmt := fmt.Sprintf(
+		"create table if not exists %s (version varchar(128) primary key)", migrationsTable)
+	_, err = db.Exec(createTableStmt)
+	if err == nil {
+		return nil
+	}
+
+	// catch 'schema does not exist' error
+	pqErr, ok := err.(*pq.Error)
+	if !ok || pqErr.Code != "3F000" {
+		return err
+	}
+
+	if !drv.createMissingSchema {
+		return fmt.Errorf("%w: %s", dbmate.ErrSchemaNotFound, schema)
+	}
+
+	fmt.Fprintf(drv.log, "Creating schema: %s\n", schema)
+	_, err = db.Exec(fmt.Sprintf("create schema if not exists %s", drv.QuoteIdentifier(schema)))
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(createTableStmt)
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "select version from " + migrationsTable + " order by version desc"
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dbutil.MustClose(rows)
+
+	migrations := map[string]bool{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		migrations[version] = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("insert into "+migrationsTable+" (version) values ($1)", version)
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	migrationsTable, err := drv.quotedMigrationsTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("delete from "+migrationsTable+" where version = $1", version)
+
+	return err
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	auditTable, err := drv.quotedAuditTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("create table if not exists " + auditTable + " (" +
+		"version varchar(128) not null, " +
+		"direction varchar(4) not null, " +
+		"checksum varchar(64) not null, " +
+		"started_at timestamptz not null, " +
+		"finished_at timestamptz not null, " +
+		"duration_ms bigint not null, " +
+		"hostname varchar(255) not null, " +
+		"os_user varchar(255) not null, " +
+		"dbmate_version varchar(32) not null)")
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	auditTable, err := drv.quotedAuditTableName(db)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("insert into "+auditTable+" (version, direction, checksum, started_at, "+
+		"finished_at, duration_ms, hostname, os_user, dbmate_version) "+
+		"values ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		record.Duration().Milliseconds(), record.Hostname, record.User, record.DbmateVersion)
+
+	return err
+}
+
+// ServerVersion returns the Redshift server version
+func (drv *Driver) ServerVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("select version()").Scan(&version)
+
+	return version, err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	migrationsSchema, migrationsTable, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return err
+	}
+
+	schemas, err := drv.dumpSchemas(db)
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		tables, err := dbutil.QueryColumn(db,
+			"select tablename from pg_tables where schemaname = $1 order by tablename", schema)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			if schema == migrationsSchema &&
+				(table == migrationsTable || table == migrationsTable+dbmate.AuditTableSuffix) {
+				continue
+			}
+
+			// redshift does not enforce foreign keys, so tables can be truncated in any order
+			_, err = db.Exec(fmt.Sprintf("truncate table %s.%s",
+				drv.QuoteIdentifier(schema), drv.QuoteIdentifier(table)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Ping verifies a connection to the database server. It does not verify whether the
+// specified database exists.
+func (drv *Driver) Ping() error {
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	err = db.Ping()
+	if err == nil {
+		return nil
+	}
+
+	// ignore 'database does not exist' error
+	pqErr, ok := err.(*pq.Error)
+	if ok && pqErr.Code == "3D000" {
+		return nil
+	}
+
+	return err
+}
+
+// TransactionalDDL returns true, since DDL statements can be rolled back
+func (drv *Driver) TransactionalDDL() bool {
+	return true
+}
+
+// SessionSetup returns true, since the session setup statements are executed on each
+// connection
+func (drv *Driver) SessionSetup() bool {
+	return true
+}
+
+// IsTransientError returns true for errors which may succeed if the migration is
+// retried: connection failures and serializable isolation violations
+func (drv *Driver) IsTransientError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	// redshift reports serializable isolation violations (error 1023) as internal errors
+	return pqErr.Code == "40001" || pqErr.Code.Class() == "08" ||
+		strings.Contains(pqErr.Message, "Serializable isolation violation")
+}
+
+// Return a normalized version of the driver-specific error type.
+func (drv *Driver) QueryError(query string, err error) error {
+	position := 0
+
+	if pqErr, ok := err.(*pq.Error); ok {
+		if pos, err := strconv.Atoi(pqErr.Position); err == nil {
+			position = pos
+		}
+	}
+
+	return &dbmate.QueryError{Err: err, Query: query, Position: position}
+}
+
+func (drv *Driver) migrationsTableNameParts(db dbutil.Transaction) (string, string, error) {
+	schema := ""
+	table := drv.migrationsTableName
+	if parts := strings.SplitN(table, ".", 2); len(parts) > 1 {
+		// schema specified as part of table name
+		schema, table = parts[0], parts[1]
+	}
+
+	if schema == "" {
+		// no schema specified with table name, try URL search path if available
+		searchPath := strings.Split(drv.databaseURL.Query().Get("search_path"), ",")
+		schema = strings.TrimSpace(searchPath[0])
+	}
+
+	var err error
+	if schema == "" {
+		schema, err = dbutil.QueryValue(db, "select current_schema()")
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	// fall back to public schema as last resort
+	if schema == "" {
+		schema = "public"
+	}
+
+	return schema, table, nil
+}
+
+// quotedMigrationsTableName returns the quoted migrations table name
+// redshift does not support arrays, so unlike postgres we quote identifiers client side
+func (drv *Driver) quotedMigrationsTableName(db dbutil.Transaction) (string, error) {
+	schema, table, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return "", err
+	}
+
+	return drv.QuoteIdentifier(schema) + "." + drv.QuoteIdentifier(table), nil
+}
+
+// quotedAuditTableName returns the quoted audit table name
+func (drv *Driver) quotedAuditTableName(db dbutil.Transaction) (string, error) {
+	schema, table, err := drv.migrationsTableNameParts(db)
+	if err != nil {
+		return "", err
+	}
+
+	return drv.QuoteIdentifier(schema) + "." + drv.QuoteIdentifier(table+dbmate.AuditTableSuffix), nil
+}
+
+// QuoteIdentifier quotes a name with double quotes
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteDoubleQuotedIdentifier(name)
+}
+
+// QuoteLiteral quotes a string literal, escaping quotes and backslashes with a
+// backslash, since redshift treats backslash as an escape character
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteBackslashEscapedLiteral(value)
+}
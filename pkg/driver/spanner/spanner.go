@@ -0,0 +1,486 @@
+package spanner
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	database "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	spannerdriver "github.com/googleapis/go-sql-spanner"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+func init() {
+	dbmate.RegisterDriver(NewDriver, "spanner")
+	dbutil.RegisterQuoter(&Driver{}, "spanner")
+}
+
+// ErrInvalidURL is returned for URLs which are not in the form spanner://project/instance/database
+var ErrInvalidURL = errors.New("invalid spanner url, expected spanner://project/instance/database")
+
+// Driver provides top level database functions
+type Driver struct {
+	migrationsTableName string
+	databaseURL         *url.URL
+	log                 io.Writer
+	waitOperation       dbmate.WaitOperationFunc
+}
+
+// NewDriver initializes the driver
+func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
+	return &Driver{
+		migrationsTableName: config.MigrationsTableName,
+		databaseURL:         config.DatabaseURL,
+		log:                 config.Log,
+		waitOperation:       config.WaitOperation,
+	}
+}
+
+// databasePath returns the instance and database resource names for a URL in the
+// form spanner://project/instance/database
+func databasePath(u *url.URL) (instance string, db string, err error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", ErrInvalidURL
+	}
+
+	instance = fmt.Sprintf("projects/%s/instances/%s", u.Host, parts[0])
+	return instance, instance + "/databases/" + parts[1], nil
+}
+
+// Open creates a new database connection
+func (drv *Driver) Open() (*sql.DB, error) {
+	_, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// dbmate only needs a handful of sessions
+	connector, err := (&spannerdriver.Driver{}).OpenConnector(name + ";minsessions=1")
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(&ddlConnector{Connector: connector, drv: drv}), nil
+}
+
+// adminClient opens a connection to the database admin API
+func (drv *Driver) adminClient(ctx context.Context) (*database.DatabaseAdminClient, error) {
+	return database.NewDatabaseAdminClient(ctx)
+}
+
+// updateDDL applies schema changes, and waits for the long running operation to complete
+func (drv *Driver) updateDDL(ctx context.Context, statements []string) error {
+	_, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return err
+	}
+
+	admin, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(admin)
+
+	for i, stmt := range statements {
+		// spanner does not accept trailing semicolons
+		statements[i] = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+	}
+
+	op, err := admin.UpdateDatabaseDdl(ctx, &databasepb.UpdateDatabaseDdlRequest{
+		Database:   name,
+		Statements: statements,
+	})
+	if err != nil {
+		return err
+	}
+	if drv.waitOperation == nil {
+		return op.Wait(ctx)
+	}
+
+	return drv.waitOperation(ctx, ddlOperation{op: op})
+}
+
+// ddlOperation reports the progress of a schema update, which can take minutes when
+// existing data must be validated or backfilled
+type ddlOperation struct {
+	op *database.UpdateDatabaseDdlOperation
+}
+
+func (o ddlOperation) Name() string {
+	return "schema update " + o.op.Name()[strings.LastIndex(o.op.Name(), "/")+1:]
+}
+
+func (o ddlOperation) Poll(ctx context.Context) (bool, error) {
+	err := o.op.Poll(ctx)
+	return o.op.Done(), err
+}
+
+// Progress returns the average progress of the statements in the update
+func (o ddlOperation) Progress() int {
+	metadata, err := o.op.Metadata()
+	if err != nil || metadata == nil || len(metadata.GetStatements()) == 0 {
+		return -1
+	}
+
+	total := 0
+	for _, progress := range metadata.GetProgress() {
+		total += int(progress.GetProgressPercent())
+	}
+
+	return total / len(metadata.GetStatements())
+}
+
+// CreateDatabase creates the specified database (if it does not already exist)
+func (drv *Driver) CreateDatabase() error {
+	instance, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(drv.log, "Creating: %s\n", name)
+
+	ctx := context.Background()
+	admin, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(admin)
+
+	op, err := admin.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          instance,
+		CreateStatement: fmt.Sprintf("CREATE DATABASE %s", drv.QuoteIdentifier(name[strings.LastIndex(name, "/")+1:])),
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		// another process may have created the database concurrently
+		fmt.Fprintf(drv.log, "Database already exists: %s\n", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = op.Wait(ctx)
+	return err
+}
+
+// DropDatabase drops the specified database (if it exists)
+func (drv *Driver) DropDatabase() error {
+	_, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(drv.log, "Dropping: %s\n", name)
+
+	ctx := context.Background()
+	admin, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(admin)
+
+	err = admin.DropDatabase(ctx, &databasepb.DropDatabaseRequest{Database: name})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+
+	return err
+}
+
+func (drv *Driver) schemaMigrationsDump(db *sql.DB, buf *bytes.Buffer) error {
+	migrationsTable := drv.quotedMigrationsTableName()
+
+	// load applied migrations
+	migrations, err := dbutil.QueryColumn(db,
+		fmt.Sprintf("select version from %s order by version asc", migrationsTable))
+	if err != nil {
+		return err
+	}
+
+	// build migrations table data
+	buf.WriteString("\n--\n-- Dbmate schema migrations\n--\n\n")
+
+	if len(migrations) > 0 {
+		for i, version := range migrations {
+			migrations[i] = drv.QuoteLiteral(version)
+		}
+		buf.WriteString("INSERT INTO " + migrationsTable + " (version) VALUES\n    (" +
+			strings.Join(migrations, "),\n    (") +
+			");\n")
+	}
+
+	return nil
+}
+
+// DumpSchema returns the current database schema, as reported by the admin API
+func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
+	_, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	admin, err := drv.adminClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(admin)
+
+	resp, err := admin.GetDatabaseDdl(ctx, &databasepb.GetDatabaseDdlRequest{Database: name})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, stmt := range resp.Statements {
+		buf.WriteString(stmt + ";\n\n")
+	}
+
+	if err := drv.schemaMigrationsDump(db, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DatabaseExists determines whether the database exists
+func (drv *Driver) DatabaseExists() (bool, error) {
+	_, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return false, err
+	}
+
+	ctx := context.Background()
+	admin, err := drv.adminClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer dbutil.MustClose(admin)
+
+	_, err = admin.GetDatabase(ctx, &databasepb.GetDatabaseRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// MigrationsTableExists checks if the schema_migrations table exists
+func (drv *Driver) MigrationsTableExists(db *sql.DB) (bool, error) {
+	return drv.tableExists(db, drv.migrationsTableName)
+}
+
+func (drv *Driver) tableExists(db *sql.DB, table string) (bool, error) {
+	count := 0
+	err := db.QueryRow("select count(*) from information_schema.tables "+
+		"where table_schema = '' and table_name = @p1", table).
+		Scan(&count)
+
+	return count > 0, err
+}
+
+// CreateMigrationsTable creates the schema_migrations table
+func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
+	// older spanner (and emulator) versions do not support "create table if not exists"
+	exists, err := drv.MigrationsTableExists(db)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(
+		"create table %s (version string(max) not null) primary key (version)",
+		drv.quotedMigrationsTableName()))
+
+	return err
+}
+
+// SelectMigrations returns a list of applied migrations
+// with an optional limit (in descending order)
+func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
+	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	if limit >= 0 {
+		query = fmt.Sprintf("%s limit %d", query, limit)
+	}
+
+	versions, err := dbutil.QueryColumn(db, query)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := map[string]bool{}
+	for _, version := range versions {
+		migrations[version] = true
+	}
+
+	return migrations, nil
+}
+
+// InsertMigration adds a new migration record
+func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version) values (@p1)", drv.quotedMigrationsTableName()),
+		version)
+
+	return err
+}
+
+// DeleteMigration removes a migration record
+func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
+	_, err := db.Exec(
+		fmt.Sprintf("delete from %s where version = @p1", drv.quotedMigrationsTableName()),
+		version)
+
+	return err
+}
+
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	exists, err := drv.tableExists(db, drv.migrationsTableName+dbmate.AuditTableSuffix)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("create table %s ("+
+		"version string(max) not null, "+
+		"direction string(4) not null, "+
+		"checksum string(64) not null, "+
+		"started_at timestamp not null, "+
+		"finished_at timestamp not null, "+
+		"duration_ms int64 not null, "+
+		"hostname string(max) not null, "+
+		"os_user string(max) not null, "+
+		"dbmate_version string(max) not null"+
+		") primary key (version, started_at)",
+		drv.quotedAuditTableName()))
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, direction, checksum, started_at, finished_at, "+
+			"duration_ms, hostname, os_user, dbmate_version) "+
+			"values (@p1, @p2, @p3, @p4, @p5, @p6, @p7, @p8, @p9)",
+			drv.quotedAuditTableName()),
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		record.Duration().Milliseconds(), record.Hostname, record.User, record.DbmateVersion)
+
+	return err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	// delete interleaved child tables before their parents
+	tables, err := dbutil.QueryColumn(db, "select table_name from information_schema.tables "+
+		"where table_schema = '' and table_type = 'BASE TABLE' and table_name not in (@p1, @p2) "+
+		"order by parent_table_name is null, table_name",
+		drv.migrationsTableName, drv.migrationsTableName+dbmate.AuditTableSuffix)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := tx.Exec("delete from " + drv.QuoteIdentifier(table) + " where true"); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Ping verifies a connection to the spanner instance. It does not verify whether the
+// specified database exists.
+func (drv *Driver) Ping() error {
+	_, name, err := databasePath(drv.databaseURL)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	admin, err := drv.adminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(admin)
+
+	_, err = admin.GetDatabase(ctx, &databasepb.GetDatabaseRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+
+	return err
+}
+
+// SingleStatements returns true, since spanner executes one statement per request
+func (drv *Driver) SingleStatements() bool {
+	return true
+}
+
+// BackslashEscapes returns true, since quoted strings use backslash as an escape character
+func (drv *Driver) BackslashEscapes() bool {
+	return true
+}
+
+// TransactionalDDL returns false, since schema changes are applied outside of transactions
+func (drv *Driver) TransactionalDDL() bool {
+	return false
+}
+
+// IsTransientError returns true for aborted transactions and unavailable servers, which
+// spanner expects clients to retry
+func (drv *Driver) IsTransientError(err error) bool {
+	code := spanner.ErrCode(err)
+	return code == codes.Aborted || code == codes.Unavailable
+}
+
+// errorLocationRegexp matches the location reported by syntax errors, e.g.
+// Syntax error: Unexpected identifier "not_valid_sql" [at 1:1]
+var errorLocationRegexp = regexp.MustCompile(`\[at (\d+):(\d+)\]`)
+
+// Return a normalized version of the driver-specific error type.
+func (drv *Driver) QueryError(query string, err error) error {
+	queryErr := &dbmate.QueryError{Err: err, Query: query}
+	if match := errorLocationRegexp.FindStringSubmatch(err.Error()); match != nil {
+		queryErr.Line, _ = strconv.Atoi(match[1])
+		queryErr.Column, _ = strconv.Atoi(match[2])
+	}
+
+	return queryErr
+}
+
+func (drv *Driver) quotedMigrationsTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
+}
+
+// QuoteIdentifier quotes a name with backticks, which are escaped with a backslash
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// QuoteLiteral quotes a string literal, escaping quotes and backslashes with a backslash
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteBackslashEscapedLiteral(value)
+}
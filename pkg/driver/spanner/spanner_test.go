@@ -0,0 +1,174 @@
+package spanner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"os"
+	"testing"
+
+	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testSpannerDriver(t *testing.T) *Driver {
+	u := dbutil.MustParseURL(os.Getenv("SPANNER_TEST_URL"))
+	drv, err := dbmate.New(u).Driver()
+	require.NoError(t, err)
+
+	return drv.(*Driver)
+}
+
+// prepTestSpannerDB creates a fresh database, and the instance if necessary (the
+// emulator starts without any instances)
+func prepTestSpannerDB(t *testing.T) (*Driver, *sql.DB) {
+	drv := testSpannerDriver(t)
+
+	instanceName, _, err := databasePath(drv.databaseURL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	admin, err := instance.NewInstanceAdminClient(ctx)
+	require.NoError(t, err)
+	defer dbutil.MustClose(admin)
+
+	_, err = admin.GetInstance(ctx, &instancepb.GetInstanceRequest{Name: instanceName})
+	if status.Code(err) == codes.NotFound {
+		op, err := admin.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+			Parent:     "projects/" + drv.databaseURL.Host,
+			InstanceId: instanceName[len("projects/"+drv.databaseURL.Host+"/instances/"):],
+			Instance: &instancepb.Instance{
+				Config:      "projects/" + drv.databaseURL.Host + "/instanceConfigs/emulator-config",
+				DisplayName: "dbmate test",
+				NodeCount:   1,
+			},
+		})
+		require.NoError(t, err)
+		_, err = op.Wait(ctx)
+		require.NoError(t, err)
+	} else {
+		require.NoError(t, err)
+	}
+
+	// drop any existing database
+	err = drv.DropDatabase()
+	require.NoError(t, err)
+
+	// create database
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
+	// connect database
+	db, err := drv.Open()
+	require.NoError(t, err)
+
+	return drv, db
+}
+
+func TestGetDriver(t *testing.T) {
+	db := dbmate.New(dbutil.MustParseURL("spanner://myproject/myinstance/mydb"))
+	drvInterface, err := db.Driver()
+	require.NoError(t, err)
+
+	// driver should have URL and default migrations table set
+	drv, ok := drvInterface.(*Driver)
+	require.True(t, ok)
+	require.Equal(t, db.DatabaseURL.String(), drv.databaseURL.String())
+	require.Equal(t, "schema_migrations", drv.migrationsTableName)
+}
+
+func TestDatabasePath(t *testing.T) {
+	u, err := url.Parse("spanner://myproject/myinstance/mydb")
+	require.NoError(t, err)
+
+	instance, db, err := databasePath(u)
+	require.NoError(t, err)
+	require.Equal(t, "projects/myproject/instances/myinstance", instance)
+	require.Equal(t, "projects/myproject/instances/myinstance/databases/mydb", db)
+
+	for _, invalid := range []string{"spanner://myproject/myinstance", "spanner:///myinstance/mydb",
+		"spanner://myproject/myinstance/mydb/extra"} {
+		u, err := url.Parse(invalid)
+		require.NoError(t, err)
+		_, _, err = databasePath(u)
+		require.ErrorIs(t, err, ErrInvalidURL, invalid)
+	}
+}
+
+func TestIsDDL(t *testing.T) {
+	require.True(t, isDDL("create table users (id int64) primary key (id)"))
+	require.True(t, isDDL("  -- comment\nALTER TABLE users ADD COLUMN name STRING(MAX)"))
+	require.True(t, isDDL("drop index users_name"))
+	require.False(t, isDDL("insert into users (id) values (1)"))
+	require.False(t, isDDL("delete from created_items where true"))
+}
+
+func TestQuotedMigrationsTableName(t *testing.T) {
+	drv := &Driver{migrationsTableName: "schema_migrations"}
+	require.Equal(t, "`schema_migrations`", drv.quotedMigrationsTableName())
+	require.Equal(t, `'it\'s'`, drv.QuoteLiteral("it's"))
+}
+
+func TestQueryError(t *testing.T) {
+	drv := &Driver{}
+
+	err := drv.QueryError("select 1;\nnot_valid_sql", errors.New(
+		`spanner: code = "InvalidArgument", desc = "Syntax error: Unexpected identifier \"not_valid_sql\" [at 2:1]"`))
+	line, column := err.(*dbmate.QueryError).Location()
+	require.Equal(t, 2, line)
+	require.Equal(t, 1, column)
+}
+
+func TestSpannerMigrations(t *testing.T) {
+	drv, db := prepTestSpannerDB(t)
+	defer dbutil.MustClose(db)
+
+	exists, err := drv.DatabaseExists()
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	err = drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	exists, err = drv.MigrationsTableExists(db)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// insert migrations inside a transaction
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	err = drv.InsertMigration(tx, "abc1")
+	require.NoError(t, err)
+	err = drv.InsertMigration(tx, "abc2")
+	require.NoError(t, err)
+	err = tx.Commit()
+	require.NoError(t, err)
+
+	migrations, err := drv.SelectMigrations(db, -1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc1": true, "abc2": true}, migrations)
+
+	migrations, err = drv.SelectMigrations(db, 1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"abc2": true}, migrations)
+
+	err = drv.DeleteMigration(db, "abc2")
+	require.NoError(t, err)
+
+	schema, err := drv.DumpSchema(db)
+	require.NoError(t, err)
+	require.Contains(t, string(schema), "CREATE TABLE schema_migrations")
+	require.Contains(t, string(schema), "INSERT INTO `schema_migrations` (version) VALUES\n    ('abc1');\n")
+}
@@ -0,0 +1,129 @@
+package spanner
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+)
+
+// Statements are executed by the go-sql-spanner driver. Its connections reject DDL
+// inside a transaction, and apply DDL outside of one without reporting progress, so
+// ddlConnector wraps them to send DDL to the database admin API itself. Spanner never
+// applies schema changes as part of a transaction: DDL is committed immediately, even if
+// a transaction is in progress on the connection, and is not undone if it rolls back.
+
+// ddlRegexp matches statements which must be executed using the database admin API
+var ddlRegexp = regexp.MustCompile(`(?is)^\s*(?:--[^\n]*\n\s*)*(create|alter|drop|grant|revoke|analyze|rename)\b`)
+
+func isDDL(query string) bool {
+	return ddlRegexp.MatchString(query)
+}
+
+// ddlConnector opens go-sql-spanner connections which apply DDL using drv.updateDDL
+type ddlConnector struct {
+	driver.Connector
+	drv *Driver
+}
+
+func (c *ddlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ddlConn{Conn: conn, drv: c.drv}, nil
+}
+
+// ddlConn passes everything except DDL statements through to a go-sql-spanner connection
+type ddlConn struct {
+	driver.Conn
+	drv *Driver
+}
+
+var (
+	_ driver.ExecerContext      = (*ddlConn)(nil)
+	_ driver.QueryerContext     = (*ddlConn)(nil)
+	_ driver.ConnBeginTx        = (*ddlConn)(nil)
+	_ driver.ConnPrepareContext = (*ddlConn)(nil)
+	_ driver.NamedValueChecker  = (*ddlConn)(nil)
+	_ driver.Pinger             = (*ddlConn)(nil)
+	_ driver.SessionResetter    = (*ddlConn)(nil)
+	_ driver.Validator          = (*ddlConn)(nil)
+)
+
+func (conn *ddlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if isDDL(query) {
+		if len(args) > 0 {
+			return nil, errors.New("spanner ddl statements do not accept parameters")
+		}
+		if err := conn.drv.updateDDL(ctx, []string{query}); err != nil {
+			return nil, err
+		}
+		return driver.RowsAffected(0), nil
+	}
+
+	execer, ok := conn.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (conn *ddlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := conn.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (conn *ddlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := conn.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+
+	return conn.Conn.Prepare(query)
+}
+
+func (conn *ddlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := conn.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return conn.Conn.Begin()
+}
+
+func (conn *ddlConn) Ping(ctx context.Context) error {
+	if pinger, ok := conn.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (conn *ddlConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := conn.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (conn *ddlConn) IsValid() bool {
+	if validator, ok := conn.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+func (conn *ddlConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := conn.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+
+	return driver.ErrSkip
+}
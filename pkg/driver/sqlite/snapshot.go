@@ -0,0 +1,85 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// snapshotPath returns the path of the file which holds a snapshot of the database
+func (drv *Driver) snapshotPath(name string) string {
+	return ConnectionString(drv.databaseURL) + ".snapshot-" + name
+}
+
+// SaveSnapshot writes a copy of the database file next to it, using VACUUM INTO so that
+// the copy is consistent even if other connections are writing to the database
+func (drv *Driver) SaveSnapshot(name string) error {
+	exists, err := drv.DatabaseExists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("database does not exist: %s", ConnectionString(drv.databaseURL))
+	}
+
+	path := drv.snapshotPath(name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	db, err := drv.Open()
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(db)
+
+	_, err = db.Exec("vacuum into " + drv.QuoteLiteral(path))
+
+	return err
+}
+
+// RestoreSnapshot replaces the database file with a copy of the snapshot file. The copy
+// is renamed into place, and the write-ahead log and shared memory files of the previous
+// database are removed, so that they are not applied to the snapshot.
+func (drv *Driver) RestoreSnapshot(name string) error {
+	snapshot, err := os.Open(drv.snapshotPath(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", dbmate.ErrSnapshotNotFound, name)
+	} else if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(snapshot)
+
+	path := ConnectionString(drv.databaseURL)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the temporary file no longer exists once it has been renamed
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := io.Copy(tmp, snapshot); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
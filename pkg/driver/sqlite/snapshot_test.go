@@ -0,0 +1,55 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"os"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteSnapshot(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	_, err := db.Exec("create table users (id integer)")
+	require.NoError(t, err)
+	_, err = db.Exec("insert into users (id) values (1)")
+	require.NoError(t, err)
+
+	err = drv.SaveSnapshot("seeded")
+	require.NoError(t, err)
+	require.FileExists(t, drv.snapshotPath("seeded"))
+
+	_, err = db.Exec("insert into users (id) values (2)")
+	require.NoError(t, err)
+	dbutil.MustClose(db)
+
+	// a stale write-ahead log must not be applied to the restored database
+	path := ConnectionString(drv.databaseURL)
+	err = os.WriteFile(path+"-wal", []byte("stale"), 0o644)
+	require.NoError(t, err)
+
+	err = drv.RestoreSnapshot("seeded")
+	require.NoError(t, err)
+	require.NoFileExists(t, path+"-wal")
+
+	db, err = drv.Open()
+	require.NoError(t, err)
+	defer dbutil.MustClose(db)
+	count, err := dbutil.QueryValue(db, "select count(*) from users")
+	require.NoError(t, err)
+	require.Equal(t, "1", count)
+
+	err = drv.RestoreSnapshot("missing")
+	require.ErrorIs(t, err, dbmate.ErrSnapshotNotFound)
+
+	err = os.Remove(drv.snapshotPath("seeded"))
+	require.NoError(t, err)
+}
@@ -5,7 +5,9 @@ package sqlite
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -16,13 +18,14 @@ import (
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 
-	"github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3" // database/sql driver
+	"github.com/mattn/go-sqlite3"
 )
 
 func init() {
 	dbmate.RegisterDriver(NewDriver, "sqlite")
 	dbmate.RegisterDriver(NewDriver, "sqlite3")
+	dbutil.RegisterQuoter(&Driver{}, "sqlite")
+	dbutil.RegisterQuoter(&Driver{}, "sqlite3")
 }
 
 // Driver provides top level database functions
@@ -30,6 +33,7 @@ type Driver struct {
 	migrationsTableName string
 	databaseURL         *url.URL
 	log                 io.Writer
+	sessionSetup        []string
 }
 
 // NewDriver initializes the driver
@@ -38,6 +42,7 @@ func NewDriver(config dbmate.DriverConfig) dbmate.Driver {
 		migrationsTableName: config.MigrationsTableName,
 		databaseURL:         config.DatabaseURL,
 		log:                 config.Log,
+		sessionSetup:        config.SessionSetup,
 	}
 }
 
@@ -72,10 +77,15 @@ func ConnectionString(u *url.URL) string {
 
 // Open creates a new database connection
 func (drv *Driver) Open() (*sql.DB, error) {
-	return sql.Open("sqlite3", ConnectionString(drv.databaseURL))
+	connector, err := dbutil.NewDSNConnector(&sqlite3.SQLiteDriver{}, ConnectionString(drv.databaseURL))
+	if err != nil {
+		return nil, err
+	}
+
+	return dbutil.OpenDB(connector, drv.sessionSetup), nil
 }
 
-// CreateDatabase creates the specified database
+// CreateDatabase creates the specified database (if it does not already exist)
 func (drv *Driver) CreateDatabase() error {
 	fmt.Fprintf(drv.log, "Creating: %s\n", ConnectionString(drv.databaseURL))
 
@@ -108,8 +118,12 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	migrationsTable := drv.quotedMigrationsTableName()
 
 	// load applied migrations
+	applied, err := dbutil.AppliedCondition(db, migrationsTable)
+	if err != nil {
+		return nil, err
+	}
 	migrations, err := dbutil.QueryColumn(db,
-		fmt.Sprintf("select quote(version) from %s order by version asc", migrationsTable))
+		fmt.Sprintf("select quote(version) from %s%s order by version asc", migrationsTable, applied))
 	if err != nil {
 		return nil, err
 	}
@@ -128,6 +142,17 @@ func (drv *Driver) schemaMigrationsDump(db *sql.DB) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// DatabaseLocation returns the path of the database file
+func (drv *Driver) DatabaseLocation() (string, string) {
+	path, _, _ := strings.Cut(ConnectionString(drv.databaseURL), "?")
+	return "", path
+}
+
+// DumpTools returns the executable run to dump the schema
+func (drv *Driver) DumpTools() ([]string, error) {
+	return []string{"sqlite3"}, nil
+}
+
 // DumpSchema returns the current database schema
 func (drv *Driver) DumpSchema(db *sql.DB) ([]byte, error) {
 	path := ConnectionString(drv.databaseURL)
@@ -181,10 +206,21 @@ func (drv *Driver) CreateMigrationsTable(db *sql.DB) error {
 	return err
 }
 
+// MigrationsTableColumns returns the lower case names of the columns of the migrations
+// table
+func (drv *Driver) MigrationsTableColumns(db *sql.DB) ([]string, error) {
+	return dbutil.TableColumns(db, drv.quotedMigrationsTableName())
+}
+
 // SelectMigrations returns a list of applied migrations
 // with an optional limit (in descending order)
 func (drv *Driver) SelectMigrations(db *sql.DB, limit int) (map[string]bool, error) {
-	query := fmt.Sprintf("select version from %s order by version desc", drv.quotedMigrationsTableName())
+	applied, err := dbutil.AppliedCondition(db, drv.quotedMigrationsTableName())
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf("select version from %s%s order by version desc", drv.quotedMigrationsTableName(), applied)
 	if limit >= 0 {
 		query = fmt.Sprintf("%s limit %d", query, limit)
 	}
@@ -221,6 +257,25 @@ func (drv *Driver) InsertMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// SelectSkippedMigrations returns the versions of the migrations recorded as skipped
+func (drv *Driver) SelectSkippedMigrations(db *sql.DB) (map[string]bool, error) {
+	return dbutil.SelectSkippedVersions(db, drv.quotedMigrationsTableName())
+}
+
+// InsertSkippedMigration records a migration as skipped
+func (drv *Driver) InsertSkippedMigration(db *sql.DB, version string) error {
+	if err := dbutil.AddSkippedColumn(db, drv.quotedMigrationsTableName()); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, %s) values (?, true)",
+			drv.quotedMigrationsTableName(), dbutil.SkippedColumn),
+		version)
+
+	return err
+}
+
 // DeleteMigration removes a migration record
 func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error {
 	_, err := db.Exec(
@@ -230,6 +285,149 @@ func (drv *Driver) DeleteMigration(db dbutil.Transaction, version string) error
 	return err
 }
 
+// CreateAuditTable creates the schema_migrations_audit table
+func (drv *Driver) CreateAuditTable(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf("create table if not exists %s ("+
+		"id integer primary key autoincrement, "+
+		"version varchar(128) not null, "+
+		"direction varchar(4) not null, "+
+		"checksum varchar(64) not null, "+
+		"started_at datetime not null, "+
+		"finished_at datetime not null, "+
+		"duration_ms integer not null, "+
+		"hostname varchar(255) not null, "+
+		"os_user varchar(255) not null, "+
+		"dbmate_version varchar(32) not null)",
+		drv.quotedAuditTableName()))
+
+	return err
+}
+
+// InsertAudit records a migration run in the audit table
+func (drv *Driver) InsertAudit(db dbutil.Transaction, record dbmate.AuditRecord) error {
+	_, err := db.Exec(
+		fmt.Sprintf("insert into %s (version, direction, checksum, started_at, finished_at, "+
+			"duration_ms, hostname, os_user, dbmate_version) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			drv.quotedAuditTableName()),
+		record.Version, record.Direction, record.Checksum, record.StartedAt, record.FinishedAt,
+		record.Duration().Milliseconds(), record.Hostname, record.User, record.DbmateVersion)
+
+	return err
+}
+
+// SelectAudit returns the records of the audit table, or nil if it does not exist
+func (drv *Driver) SelectAudit(db *sql.DB) ([]dbmate.AuditRecord, error) {
+	exists := false
+	err := db.QueryRow("select 1 from sqlite_master where type = 'table' and name = ?",
+		drv.migrationsTableName+dbmate.AuditTableSuffix).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(fmt.Sprintf("select version, direction, checksum, started_at, "+
+		"finished_at, hostname, os_user, dbmate_version from %s order by id",
+		drv.quotedAuditTableName()))
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	records := []dbmate.AuditRecord{}
+	for rows.Next() {
+		var r dbmate.AuditRecord
+		if err := rows.Scan(&r.Version, &r.Direction, &r.Checksum, &r.StartedAt, &r.FinishedAt,
+			&r.Hostname, &r.User, &r.DbmateVersion); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// TableColumns returns the columns of each table
+func (drv *Driver) TableColumns(db *sql.DB) (map[string][]string, error) {
+	rows, err := db.Query("select m.name, p.name from sqlite_master m "+
+		"join pragma_table_info(m.name) p "+
+		"where m.type = 'table' and m.name not like 'sqlite\\_%' escape '\\' and m.name <> ? "+
+		"order by m.name, p.cid", drv.migrationsTableName)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(rows)
+
+	tables := map[string][]string{}
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		tables[table] = append(tables[table], column)
+	}
+
+	return tables, rows.Err()
+}
+
+// ServerVersion returns the SQLite library version
+func (drv *Driver) ServerVersion(db *sql.DB) (string, error) {
+	var version string
+	err := db.QueryRow("select 'SQLite ' || sqlite_version()").Scan(&version)
+
+	return version, err
+}
+
+// TruncateTables deletes all rows from every table except the migrations and audit tables
+func (drv *Driver) TruncateTables(db *sql.DB) error {
+	tables, err := dbutil.QueryColumn(db, "select name from sqlite_master "+
+		"where type = 'table' and name not like 'sqlite\\_%' escape '\\' and name not in (?, ?) "+
+		"order by name", drv.migrationsTableName, drv.migrationsTableName+dbmate.AuditTableSuffix)
+	if err != nil {
+		return err
+	}
+
+	// the foreign_keys pragma is per connection, so we must delete using the same connection
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbutil.MustClose(conn)
+
+	foreignKeys := 0
+	if err := conn.QueryRowContext(ctx, "pragma foreign_keys").Scan(&foreignKeys); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "pragma foreign_keys = off"); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if _, err := conn.ExecContext(ctx, "delete from "+drv.QuoteIdentifier(table)); err != nil {
+			return err
+		}
+	}
+
+	// reset autoincrement counters
+	sequenceExists := false
+	err = conn.QueryRowContext(ctx, "select 1 from sqlite_master where type = 'table' and name = 'sqlite_sequence'").
+		Scan(&sequenceExists)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if sequenceExists {
+		if _, err := conn.ExecContext(ctx, "delete from sqlite_sequence"); err != nil {
+			return err
+		}
+	}
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("pragma foreign_keys = %d", foreignKeys))
+
+	return err
+}
+
 // Ping verifies a connection to the database. Due to the way SQLite works, by
 // testing whether the database is valid, it will automatically create the database
 // if it does not already exist.
@@ -243,18 +441,66 @@ func (drv *Driver) Ping() error {
 	return db.Ping()
 }
 
+// TransactionalDDL returns true, since DDL statements can be rolled back
+func (drv *Driver) TransactionalDDL() bool {
+	return true
+}
+
+// SessionSetup returns true, since the session setup statements are executed on each
+// connection
+func (drv *Driver) SessionSetup() bool {
+	return true
+}
+
+// IsTransientError returns true if the database file was locked by another connection
+func (drv *Driver) IsTransientError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// IsConnectionError returns true if the database file could not be opened
+func (drv *Driver) IsConnectionError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrCantOpen
+}
+
+// IsLockError returns true if the database file was locked by another connection
+func (drv *Driver) IsLockError(err error) bool {
+	return drv.IsTransientError(err)
+}
+
+// syntaxErrorRegexp matches the text quoted by syntax errors, e.g.
+// near "not_valid_sql": syntax error
+var syntaxErrorRegexp = regexp.MustCompile(`near "(.*?)": syntax error`)
+
 // Return a normalized version of the driver-specific error type.
 func (drv *Driver) QueryError(query string, err error) error {
-	return &dbmate.QueryError{Err: err, Query: query}
+	position := 0
+	if match := syntaxErrorRegexp.FindStringSubmatch(err.Error()); match != nil {
+		position = dbmate.TextPosition(query, match[1])
+	}
+
+	return &dbmate.QueryError{Err: err, Query: query, Position: position}
 }
 
 func (drv *Driver) quotedMigrationsTableName() string {
-	return drv.quoteIdentifier(drv.migrationsTableName)
+	return drv.QuoteIdentifier(drv.migrationsTableName)
+}
+
+func (drv *Driver) quotedAuditTableName() string {
+	return drv.QuoteIdentifier(drv.migrationsTableName + dbmate.AuditTableSuffix)
+}
+
+// QuoteIdentifier quotes a name with double quotes
+func (drv *Driver) QuoteIdentifier(name string) string {
+	return dbutil.QuoteDoubleQuotedIdentifier(name)
 }
 
-// quoteIdentifier quotes a table or column name
-// we fall back to lib/pq implementation since both use ansi standard (double quotes)
-// and mattn/go-sqlite3 doesn't provide a sqlite-specific equivalent
-func (drv *Driver) quoteIdentifier(s string) string {
-	return pq.QuoteIdentifier(s)
+// QuoteLiteral quotes a string literal, doubling any single quotes
+func (drv *Driver) QuoteLiteral(value string) string {
+	return dbutil.QuoteSingleQuotedLiteral(value)
 }
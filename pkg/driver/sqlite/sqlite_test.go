@@ -5,12 +5,15 @@ package sqlite
 
 import (
 	"database/sql"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/amacneil/dbmate/v2/pkg/dbmate"
 	"github.com/amacneil/dbmate/v2/pkg/dbutil"
 
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -150,6 +153,10 @@ func TestSQLiteCreateDropDatabase(t *testing.T) {
 	err = drv.CreateDatabase()
 	require.NoError(t, err)
 
+	// creating an existing database succeeds
+	err = drv.CreateDatabase()
+	require.NoError(t, err)
+
 	// check that database exists
 	_, err = os.Stat(path)
 	require.NoError(t, err)
@@ -355,6 +362,45 @@ func TestSQLiteDeleteMigration(t *testing.T) {
 	require.Equal(t, 1, count)
 }
 
+func TestSQLiteTruncateTables(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`insert into test_migrations (version) values ('abc1')`)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`create table parents (id integer primary key autoincrement)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table children (id integer primary key, parent_id integer references parents (id))`)
+	require.NoError(t, err)
+	_, err = db.Exec(`insert into parents (id) values (1)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`insert into children (id, parent_id) values (1, 1)`)
+	require.NoError(t, err)
+
+	err = drv.TruncateTables(db)
+	require.NoError(t, err)
+
+	count := 0
+	err = db.QueryRow("select count(*) from parents").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+	err = db.QueryRow("select count(*) from children").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	// migrations are preserved
+	err = db.QueryRow("select count(*) from test_migrations").Scan(&count)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
 func TestSQLitePing(t *testing.T) {
 	drv := testSQLiteDriver(t)
 	path := ConnectionString(drv.databaseURL)
@@ -403,3 +449,128 @@ func TestSQLiteQuotedMigrationsTableName(t *testing.T) {
 		require.Equal(t, `"fooMigrations"`, name)
 	})
 }
+
+func TestSQLiteIsTransientError(t *testing.T) {
+	drv := &Driver{}
+
+	require.True(t, drv.IsTransientError(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	require.True(t, drv.IsTransientError(&dbmate.QueryError{Err: sqlite3.Error{Code: sqlite3.ErrLocked}}))
+	require.False(t, drv.IsTransientError(sqlite3.Error{Code: sqlite3.ErrConstraint}))
+	require.False(t, drv.IsTransientError(errors.New("other error")))
+}
+
+func TestSQLiteIsConnectionError(t *testing.T) {
+	drv := &Driver{}
+
+	require.True(t, drv.IsConnectionError(sqlite3.Error{Code: sqlite3.ErrCantOpen}))
+	require.False(t, drv.IsConnectionError(sqlite3.Error{Code: sqlite3.ErrBusy}))
+	require.False(t, drv.IsConnectionError(errors.New("other error")))
+}
+
+func TestSQLiteIsLockError(t *testing.T) {
+	drv := &Driver{}
+
+	require.True(t, drv.IsLockError(&dbmate.QueryError{Err: sqlite3.Error{Code: sqlite3.ErrBusy}}))
+	require.False(t, drv.IsLockError(sqlite3.Error{Code: sqlite3.ErrConstraint}))
+}
+
+func TestSQLiteMigrationsTableColumns(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	columns, err := drv.MigrationsTableColumns(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"version"}, columns)
+
+	drv.migrationsTableName = "golang_migrations"
+	_, err = db.Exec("create table golang_migrations (Version bigint not null primary key, dirty boolean not null)")
+	require.NoError(t, err)
+	columns, err = drv.MigrationsTableColumns(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"version", "dirty"}, columns)
+}
+
+func TestSQLiteTableColumns(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateMigrationsTable(db)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`create table users (id integer primary key autoincrement, name text, email text)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`create table posts (id integer, user_id integer)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`create view user_names as select name from users`)
+	require.NoError(t, err)
+
+	tables, err := drv.TableColumns(db)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"posts": {"id", "user_id"},
+		"users": {"id", "name", "email"},
+	}, tables)
+}
+
+func TestSQLiteInsertAudit(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	drv.migrationsTableName = "test_migrations"
+
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	err := drv.CreateAuditTable(db)
+	require.NoError(t, err)
+
+	// creating the table again is a no-op
+	err = drv.CreateAuditTable(db)
+	require.NoError(t, err)
+
+	startedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = drv.InsertAudit(db, dbmate.AuditRecord{
+		Version:       "abc1",
+		Direction:     dbmate.AuditUp,
+		Checksum:      "abcdef",
+		StartedAt:     startedAt,
+		FinishedAt:    startedAt.Add(1500 * time.Millisecond),
+		Hostname:      "localhost",
+		User:          "dbmate",
+		DbmateVersion: "2.0.0",
+	})
+	require.NoError(t, err)
+
+	var version, direction, user string
+	var duration int64
+	err = db.QueryRow("select version, direction, os_user, duration_ms from test_migrations_audit").
+		Scan(&version, &direction, &user, &duration)
+	require.NoError(t, err)
+	require.Equal(t, "abc1", version)
+	require.Equal(t, "up", direction)
+	require.Equal(t, "dbmate", user)
+	require.Equal(t, int64(1500), duration)
+}
+
+func TestSQLiteQueryError(t *testing.T) {
+	drv := testSQLiteDriver(t)
+	db := prepTestSQLiteDB(t)
+	defer dbutil.MustClose(db)
+
+	query := "create table a (id integer);\n/* สวัสดี */ not_valid_sql;"
+	_, err := db.Exec(query)
+	require.Error(t, err)
+
+	queryErr := drv.QueryError(query, err).(*dbmate.QueryError)
+	line, column := queryErr.Location()
+	require.Equal(t, 2, line)
+	require.Equal(t, 14, column)
+	require.Contains(t, queryErr.Error(), "> 2 | /* สวัสดี */ not_valid_sql;\n    |              ^")
+}
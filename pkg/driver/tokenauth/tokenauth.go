@@ -0,0 +1,153 @@
+// Package tokenauth obtains short lived database passwords, such as Microsoft Entra ID
+// access tokens, for the postgres and mysql drivers. It is kept out of dbutil so that
+// other drivers do not depend on the Azure SDK.
+package tokenauth
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Param is a URL query parameter which selects how the postgres and mysql drivers
+// obtain the database password. It is not passed to the database connection.
+const Param = "auth"
+
+// AzureAD authenticates with Microsoft Entra ID (formerly Azure AD) access tokens,
+// which are obtained with DefaultAzureCredential and sent as the password, so managed
+// identities can connect to Azure Database for PostgreSQL or MySQL without a secret
+const AzureAD = "azure-ad"
+
+// AzureDatabaseScope is the scope of access tokens for Azure Database for PostgreSQL and
+// MySQL
+const AzureDatabaseScope = "https://ossrdbms-aad.database.windows.net/.default"
+
+// azureTokenRefreshMargin is how long before a token expires a new one is obtained, so
+// that a token is not about to expire while a connection is being opened
+const azureTokenRefreshMargin = 5 * time.Minute
+
+// ErrInvalidAuth is returned if the auth URL parameter is not recognized, or can't be
+// used with the URL
+var ErrInvalidAuth = errors.New("invalid auth parameter")
+
+// Source returns a password which is only valid for a limited time, such as an
+// access token
+type Source interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// NewSource returns the token source selected by the auth parameter of the URL, or
+// nil if the password in the URL is used
+func NewSource(u *url.URL) (Source, error) {
+	switch mode := u.Query().Get(Param); mode {
+	case "":
+		return nil, nil
+	case AzureAD:
+		if _, set := u.User.Password(); set {
+			return nil, fmt.Errorf("%w: %s can't be used with a password in the URL", ErrInvalidAuth, mode)
+		}
+
+		credential, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewAzureSource(credential), nil
+	default:
+		return nil, fmt.Errorf("%w: %s (expected %s)", ErrInvalidAuth, mode, AzureAD)
+	}
+}
+
+// Tokens creates the token source selected by a URL the first time it is needed, and
+// keeps it, so that each connection reuses the token until it expires. Source may be set
+// to use a different token source.
+type Tokens struct {
+	Source Source
+}
+
+// Get returns the token source selected by the auth parameter of the URL, or nil if the
+// password in the URL is used
+func (t *Tokens) Get(u *url.URL) (Source, error) {
+	if t.Source == nil {
+		source, err := NewSource(u)
+		if err != nil {
+			return nil, err
+		}
+		t.Source = source
+	}
+
+	return t.Source, nil
+}
+
+// AzureSource obtains access tokens for Azure Database for PostgreSQL and MySQL
+// from an Azure credential. A token is reused until shortly before it expires.
+type AzureSource struct {
+	credential azcore.TokenCredential
+	mu         sync.Mutex
+	token      azcore.AccessToken
+}
+
+// NewAzureSource returns a token source which obtains tokens from the credential
+func NewAzureSource(credential azcore.TokenCredential) *AzureSource {
+	return &AzureSource{credential: credential}
+}
+
+// Token returns an access token, obtaining a new one if the previous token expires soon
+func (s *AzureSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token.Token != "" && time.Until(s.token.ExpiresOn) > azureTokenRefreshMargin {
+		return s.token.Token, nil
+	}
+
+	token, err := s.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{AzureDatabaseScope}})
+	if err != nil {
+		return "", fmt.Errorf("unable to obtain Azure AD token: %w", err)
+	}
+	s.token = token
+
+	return token.Token, nil
+}
+
+// tokenConnector opens each connection with a password obtained from a token source
+type tokenConnector struct {
+	tokens    Source
+	driver    driver.Driver
+	connector func(password string) (driver.Connector, error)
+}
+
+// NewConnector returns a connector which obtains a token before each connection is
+// opened, and opens it using the connector returned for the token as the password.
+// Connections opened after a token expires therefore use a new token.
+func NewConnector(tokens Source, drv driver.Driver,
+	connector func(password string) (driver.Connector, error),
+) driver.Connector {
+	return &tokenConnector{tokens: tokens, driver: drv, connector: connector}
+}
+
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := c.connector(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.Connect(ctx)
+}
+
+func (c *tokenConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,197 @@
+// Package envfile loads environment variables from .env files
+package envfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultFiles are loaded when no files are specified. Variables in .env.local override
+// those in .env, so that shared defaults can be committed while local settings are not.
+var DefaultFiles = []string{".env", ".env.local"}
+
+// Error codes
+var (
+	ErrInvalidLine       = errors.New("invalid line, expected KEY=value")
+	ErrUnterminatedQuote = errors.New("unterminated quoted value")
+)
+
+var keyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// ${VAR}, $VAR, or an escaped \$
+var expandRegexp = regexp.MustCompile(`\\\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Parse reads KEY=value lines from r. Blank lines, comments, and an `export` prefix are
+// ignored. Single quoted values are literal. Double quoted values may span several lines
+// and support \n, \t, \", and \\ escapes. References to ${VAR} or $VAR in unquoted and
+// double quoted values are replaced with variables defined earlier in the file, or
+// otherwise by lookup (unset variables expand to an empty string).
+func Parse(r io.Reader, lookup func(string) (string, bool)) (map[string]string, error) {
+	vars := map[string]string{}
+	expand := func(s string) string {
+		return expandRegexp.ReplaceAllStringFunc(s, func(match string) string {
+			if match == `\$` {
+				return "$"
+			}
+			name := strings.Trim(match, "${}")
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			if lookup != nil {
+				value, _ := lookup(name)
+				return value
+			}
+			return ""
+		})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !found || !keyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("%w: line %d", ErrInvalidLine, lineNo)
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: line %d", ErrUnterminatedQuote, lineNo)
+			}
+			vars[key] = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			start := lineNo
+			value = value[1:]
+			for closingQuote(value) < 0 {
+				if !scanner.Scan() {
+					return nil, fmt.Errorf("%w: line %d", ErrUnterminatedQuote, start)
+				}
+				lineNo++
+				value += "\n" + scanner.Text()
+			}
+			vars[key] = expand(unescape(value[:closingQuote(value)]))
+		default:
+			// inline comments must be preceded by whitespace, e.g. KEY=value # comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			vars[key] = expand(value)
+		}
+	}
+
+	return vars, scanner.Err()
+}
+
+// closingQuote returns the index of the first unescaped double quote in s, or -1
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return -1
+}
+
+// unescape replaces escape sequences in a double quoted value. Escaped dollar signs are
+// left for expansion to handle.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '$':
+			b.WriteString(`\$`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// Read parses each file in order, with variables in later files overriding earlier
+// ones. References are expanded using the environment, then variables from earlier
+// files, since environment variables take precedence when the files are loaded.
+func Read(files ...string) (map[string]string, error) {
+	merged := map[string]string{}
+	lookup := func(name string) (string, bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, true
+		}
+		value, ok := merged[name]
+		return value, ok
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		vars, err := Parse(f, lookup)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		for key, value := range vars {
+			merged[key] = value
+		}
+	}
+
+	return merged, nil
+}
+
+// Load reads the files, and sets each variable which is not already set in the
+// environment. If no files are specified, the DefaultFiles which exist are loaded.
+func Load(files ...string) error {
+	if len(files) == 0 {
+		for _, file := range DefaultFiles {
+			if _, err := os.Stat(file); err == nil {
+				files = append(files, file)
+			}
+		}
+	}
+
+	vars, err := Read(files...)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range vars {
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package envfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/envfile"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "HOST" {
+			return "db.example.com", true
+		}
+		return "", false
+	}
+
+	vars, err := envfile.Parse(strings.NewReader(`
+# a comment
+export USER=postgres
+PASSWORD='pa$$word # not a comment'
+DATABASE_URL="postgres://${USER}:${PASSWORD}@$HOST/app?sslmode=require"
+GREETING="hello\n\"world\"" # a comment
+PRICE=\$5 # a comment
+EMPTY=
+MISSING=${UNDEFINED}
+MULTILINE="one
+two"
+`), lookup)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"USER":         "postgres",
+		"PASSWORD":     "pa$$word # not a comment",
+		"DATABASE_URL": "postgres://postgres:pa$$word # not a comment@db.example.com/app?sslmode=require",
+		"GREETING":     "hello\n\"world\"",
+		"PRICE":        "$5",
+		"EMPTY":        "",
+		"MISSING":      "",
+		"MULTILINE":    "one\ntwo",
+	}, vars)
+
+	_, err = envfile.Parse(strings.NewReader("FOO=bar\nnot a variable\n"), nil)
+	require.ErrorIs(t, err, envfile.ErrInvalidLine)
+	require.EqualError(t, err, "invalid line, expected KEY=value: line 2")
+
+	_, err = envfile.Parse(strings.NewReader("FOO=\"bar\nBAZ=qux\n"), nil)
+	require.ErrorIs(t, err, envfile.ErrUnterminatedQuote)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	env := filepath.Join(dir, ".env")
+	local := filepath.Join(dir, ".env.local")
+	require.NoError(t, os.WriteFile(env, []byte("ENVFILE_HOST=localhost\nENVFILE_NAME=app\nENVFILE_USER=shared\n"), 0o600))
+	require.NoError(t, os.WriteFile(local, []byte("ENVFILE_HOST=127.0.0.1\nENVFILE_URL=postgres://${ENVFILE_USER}@${ENVFILE_HOST}/${ENVFILE_NAME}\n"), 0o600))
+
+	// environment variables take precedence over files
+	t.Setenv("ENVFILE_USER", "me")
+	for _, name := range []string{"ENVFILE_HOST", "ENVFILE_NAME", "ENVFILE_URL"} {
+		t.Setenv(name, "")
+		require.NoError(t, os.Unsetenv(name))
+	}
+
+	require.NoError(t, envfile.Load(env, local))
+	require.Equal(t, "127.0.0.1", os.Getenv("ENVFILE_HOST"))
+	require.Equal(t, "app", os.Getenv("ENVFILE_NAME"))
+	require.Equal(t, "me", os.Getenv("ENVFILE_USER"))
+	require.Equal(t, "postgres://me@127.0.0.1/app", os.Getenv("ENVFILE_URL"))
+
+	// missing files are an error unless they are default files
+	require.ErrorIs(t, envfile.Load(filepath.Join(dir, "missing")), os.ErrNotExist)
+}
@@ -0,0 +1,183 @@
+package lock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// dynamoDBConditionFailed is the error type returned when a condition expression is false
+const dynamoDBConditionFailed = "ConditionalCheckFailedException"
+
+// dynamoDBStore holds a lock in an item of a DynamoDB table, or a DynamoDB compatible
+// service if AWS_ENDPOINT_URL_DYNAMODB or AWS_ENDPOINT_URL is set. The table must have a
+// string partition key named LockID (like the Terraform S3 backend's lock table). Items
+// record their holder in Owner, and their expiry in Expires (in seconds since the Unix
+// epoch), which may be enabled as the table's TTL attribute to delete expired items.
+type dynamoDBStore struct {
+	client      *http.Client
+	credentials dbutil.AWSCredentials
+	region      string
+	endpoint    string
+	table       string
+	key         string
+	now         func() time.Time
+}
+
+func newDynamoDBStore(u *url.URL, key string) (*dynamoDBStore, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, u.Redacted())
+	}
+
+	region := dbutil.AWSRegion(u.Query().Get("region"))
+	credentials, err := dbutil.LoadAWSCredentials(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	endpoint := dbutil.AWSEndpoint("dynamodb")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://dynamodb.%s.amazonaws.com", region)
+	}
+
+	return &dynamoDBStore{
+		client:      &http.Client{Timeout: requestTimeout},
+		credentials: credentials,
+		region:      region,
+		endpoint:    endpoint + "/",
+		table:       u.Host,
+		key:         key,
+		now:         time.Now,
+	}, nil
+}
+
+func (st *dynamoDBStore) acquire(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	// an expired item is replaced, since TTL deletion may take days. Attribute names are
+	// given as placeholders, since they may be reserved words.
+	now := st.now()
+	err := st.call(ctx, "PutItem", map[string]interface{}{
+		"TableName": st.table,
+		"Item": map[string]interface{}{
+			"LockID":  dynamoDBString(st.key),
+			"Owner":   dynamoDBString(token),
+			"Expires": dynamoDBNumber(now.Add(ttl)),
+		},
+		"ConditionExpression":      "attribute_not_exists(#id) OR #expires < :now",
+		"ExpressionAttributeNames": map[string]string{"#id": "LockID", "#expires": "Expires"},
+		"ExpressionAttributeValues": map[string]interface{}{
+			":now": dynamoDBNumber(now),
+		},
+	})
+
+	return st.conditionResult(err)
+}
+
+func (st *dynamoDBStore) refresh(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	err := st.call(ctx, "UpdateItem", map[string]interface{}{
+		"TableName":                st.table,
+		"Key":                      map[string]interface{}{"LockID": dynamoDBString(st.key)},
+		"UpdateExpression":         "SET #expires = :expires",
+		"ConditionExpression":      "#owner = :owner",
+		"ExpressionAttributeNames": map[string]string{"#owner": "Owner", "#expires": "Expires"},
+		"ExpressionAttributeValues": map[string]interface{}{
+			":owner":   dynamoDBString(token),
+			":expires": dynamoDBNumber(st.now().Add(ttl)),
+		},
+	})
+
+	return st.conditionResult(err)
+}
+
+func (st *dynamoDBStore) release(ctx context.Context, token string) (bool, error) {
+	err := st.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName":                 st.table,
+		"Key":                       map[string]interface{}{"LockID": dynamoDBString(st.key)},
+		"ConditionExpression":       "#owner = :owner",
+		"ExpressionAttributeNames":  map[string]string{"#owner": "Owner"},
+		"ExpressionAttributeValues": map[string]interface{}{":owner": dynamoDBString(token)},
+	})
+
+	return st.conditionResult(err)
+}
+
+// close does nothing, since each request is made over HTTP
+func (st *dynamoDBStore) close() error {
+	return nil
+}
+
+// dynamoDBError is an error returned by the DynamoDB API
+type dynamoDBError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (e *dynamoDBError) Error() string {
+	return fmt.Sprintf("dynamodb: %s: %s", e.Type, e.Message)
+}
+
+// conditionResult returns false if err is a failed condition, which means the lock is
+// held by another token
+func (st *dynamoDBStore) conditionResult(err error) (bool, error) {
+	var apiErr *dynamoDBError
+	if errors.As(err, &apiErr) && strings.HasSuffix(apiErr.Type, "#"+dynamoDBConditionFailed) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// call sends a signed request to an API action
+func (st *dynamoDBStore) call(ctx context.Context, action string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, st.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+action)
+	st.credentials.Sign(req, body, st.region, "dynamodb", st.now())
+
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &dynamoDBError{}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Type == "" {
+			return fmt.Errorf("dynamodb %s: %s: %s", action, resp.Status, bytes.TrimSpace(data))
+		}
+		return apiErr
+	}
+
+	return nil
+}
+
+// dynamoDBString returns a string attribute value
+func dynamoDBString(s string) map[string]string {
+	return map[string]string{"S": s}
+}
+
+// dynamoDBNumber returns a number attribute value with a time in seconds since the Unix
+// epoch
+func dynamoDBNumber(t time.Time) map[string]string {
+	return map[string]string{"N": strconv.FormatInt(t.Unix(), 10)}
+}
@@ -0,0 +1,151 @@
+package lock
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// etcdDefaultPort is used if the URL does not specify a port
+const etcdDefaultPort = "2379"
+
+// etcdStore holds a lock in an etcd key attached to a lease. The key is deleted by etcd
+// when the lease expires.
+type etcdStore struct {
+	config clientv3.Config
+	client *clientv3.Client
+	key    string
+	// lease is the ID of the lease attached to the key while it is held
+	lease clientv3.LeaseID
+}
+
+func newEtcdStore(u *url.URL, key string) (*etcdStore, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, u.Redacted())
+	}
+
+	endpoint := u.Host
+	if u.Port() == "" {
+		endpoint = net.JoinHostPort(u.Hostname(), etcdDefaultPort)
+	}
+
+	config := clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: requestTimeout,
+		// the client logs to stderr by default
+		Logger: zap.NewNop(),
+	}
+	if u.Scheme == "etcds" {
+		config.TLS = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	if u.User != nil {
+		config.Username = u.User.Username()
+		config.Password, _ = u.User.Password()
+	}
+
+	return &etcdStore{config: config, key: key}, nil
+}
+
+// conn returns the client, which connects when it is first used
+func (st *etcdStore) conn() (*clientv3.Client, error) {
+	if st.client == nil {
+		client, err := clientv3.New(st.config)
+		if err != nil {
+			return nil, err
+		}
+		st.client = client
+	}
+
+	return st.client, nil
+}
+
+func (st *etcdStore) acquire(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	client, err := st.conn()
+	if err != nil {
+		return false, err
+	}
+
+	lease, err := client.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		return false, err
+	}
+
+	// the key is only put if it does not exist
+	resp, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(st.key), "=", 0)).
+		Then(clientv3.OpPut(st.key, token, clientv3.WithLease(lease.ID))).
+		Commit()
+	if err != nil || !resp.Succeeded {
+		_, _ = client.Revoke(ctx, lease.ID)
+		return false, err
+	}
+
+	st.lease = lease.ID
+	return true, nil
+}
+
+func (st *etcdStore) refresh(ctx context.Context, token string, _ time.Duration) (bool, error) {
+	client, err := st.conn()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := client.KeepAliveOnce(ctx, st.lease); err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// the key may have been deleted and put by another run since it was acquired
+	resp, err := client.Txn(ctx).If(st.compareValue(token)).Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return resp.Succeeded, nil
+}
+
+func (st *etcdStore) release(ctx context.Context, token string) (bool, error) {
+	client, err := st.conn()
+	if err != nil {
+		return false, err
+	}
+
+	// the key is only deleted if it still holds the token
+	resp, err := client.Txn(ctx).If(st.compareValue(token)).Then(clientv3.OpDelete(st.key)).Commit()
+	if err != nil || !resp.Succeeded {
+		return false, err
+	}
+
+	// the lease is no longer needed
+	_, _ = client.Revoke(ctx, st.lease)
+	st.lease = 0
+
+	return true, nil
+}
+
+func (st *etcdStore) close() error {
+	if st.client == nil {
+		return nil
+	}
+
+	err := st.client.Close()
+	st.client = nil
+
+	return err
+}
+
+// compareValue returns a transaction comparison which is true if the key holds token
+func (st *etcdStore) compareValue(token string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.Value(st.key), "=", token)
+}
@@ -0,0 +1,261 @@
+// Package lock holds dbmate's migration lock in an external store (Redis, etcd, or a
+// DynamoDB table), so that concurrent runs coordinate even against databases which have
+// no locks of their own, such as ClickHouse and BigQuery
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// Error codes
+var (
+	ErrInvalidURL = errors.New("invalid lock url, expected redis://host/db, etcd://host, or dynamodb://table")
+	ErrLockLost   = errors.New("lock expired or was taken by another run while it was held")
+)
+
+// DefaultKey is the name of the lock if the URL does not have a key parameter
+const DefaultKey = "dbmate"
+
+// DefaultTTL is how long the lock is held without being refreshed if the URL does not
+// have a ttl parameter. It is refreshed three times per TTL while dbmate runs, so that
+// the lock is only released by expiry if dbmate is killed.
+const DefaultTTL = 30 * time.Second
+
+// requestTimeout is how long each request to the store may take, so that an unreachable
+// store fails the run instead of blocking it
+const requestTimeout = 10 * time.Second
+
+// store sets a key to a token, which expires unless it is refreshed, only if the key is
+// not already set to another token
+type store interface {
+	// acquire sets the key to token, and returns false if another token holds it
+	acquire(ctx context.Context, token string, ttl time.Duration) (bool, error)
+	// refresh extends the expiry of the key, and returns false if token no longer holds it
+	refresh(ctx context.Context, token string, ttl time.Duration) (bool, error)
+	// release deletes the key, and returns false if token no longer holds it
+	release(ctx context.Context, token string) (bool, error)
+	// close closes the connection to the store, which is opened again if it is used
+	close() error
+}
+
+// Open returns a lock in the store selected by the scheme of u: redis (or rediss for
+// TLS), etcd (or etcds for TLS), or dynamodb. Every store accepts the key parameter (the
+// name of the lock), ttl (how long the lock survives a killed run), and timeout (how long
+// to wait for another run to release the lock, 0 to wait indefinitely).
+func Open(u *url.URL) (dbmate.Locker, error) {
+	query := u.Query()
+	key := query.Get("key")
+	if key == "" {
+		key = DefaultKey
+	}
+	ttl, err := durationParam(query, "ttl", DefaultTTL)
+	if err != nil {
+		return nil, err
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("%w: ttl must be at least 1s", ErrInvalidURL)
+	}
+	timeout, err := durationParam(query, "timeout", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var st store
+	switch u.Scheme {
+	case "redis", "rediss":
+		st, err = newRedisStore(u, key)
+	case "etcd", "etcds":
+		st, err = newEtcdStore(u, key)
+	case "dynamodb":
+		st, err = newDynamoDBStore(u, key)
+	default:
+		err = fmt.Errorf("%w: %s", ErrInvalidURL, u.Redacted())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaseLock{
+		store:        st,
+		key:          key,
+		ttl:          ttl,
+		timeout:      timeout,
+		pollInterval: time.Second,
+	}, nil
+}
+
+// durationParam returns the value of a duration URL parameter, or def if it is not set
+func durationParam(query url.Values, name string, def time.Duration) (time.Duration, error) {
+	if !query.Has(name) {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(query.Get(name))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%w: invalid %s parameter: %s", ErrInvalidURL, name, query.Get(name))
+	}
+
+	return d, nil
+}
+
+// leaseLock holds a key in a store, which expires unless it is refreshed. A random token
+// identifies the holder, so that a run which lost the lock (e.g. after a long pause)
+// can't refresh or release the lock of the run which acquired it next.
+type leaseLock struct {
+	store        store
+	key          string
+	ttl          time.Duration
+	timeout      time.Duration
+	pollInterval time.Duration
+
+	token string
+	stop  chan struct{}
+	done  chan struct{}
+	mu    sync.Mutex
+	lost  bool
+}
+
+// Lock polls the store until the key is acquired, then refreshes it in the background
+// until Unlock is called
+func (l *leaseLock) Lock(ctx context.Context) (err error) {
+	if l.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.timeout)
+		defer cancel()
+	}
+
+	// the connection to the store is only kept open while the lock is held
+	defer func() {
+		if err != nil {
+			_ = l.store.close()
+		}
+	}()
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		acquired, err := l.store.acquire(reqCtx, token, l.ttl)
+		cancel()
+		if err != nil {
+			return l.waitError(ctx, err)
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return l.waitError(ctx, ctx.Err())
+		case <-time.After(l.pollInterval):
+		}
+	}
+
+	l.token = token
+	l.lost = false
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.refresh(token, l.stop, l.done)
+
+	return nil
+}
+
+// waitError returns ErrLockHeld if the lock timeout expired, otherwise err
+func (l *leaseLock) waitError(ctx context.Context, err error) error {
+	if l.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: timed out after %s waiting for lock %s", dbmate.ErrLockHeld, l.timeout, l.key)
+	}
+
+	return err
+}
+
+// refresh extends the expiry of the key three times per TTL, until stop is closed or
+// the lock is lost. Failed refreshes are retried, and the lock is only considered lost
+// once none succeeded for a whole TTL, since the key may have expired by then.
+func (l *leaseLock) refresh(token string, stop, done chan struct{}) {
+	defer close(done)
+
+	interval := l.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	refreshed := time.Now()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			held, err := l.store.refresh(ctx, token, l.ttl)
+			cancel()
+			if err == nil && held {
+				refreshed = time.Now()
+				continue
+			}
+			if err == nil || time.Since(refreshed) >= l.ttl {
+				l.mu.Lock()
+				l.lost = true
+				l.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// Check returns ErrLockLost if the key expired or was taken by another run since it was
+// acquired, so that dbmate stops before the next migration
+func (l *leaseLock) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost {
+		return fmt.Errorf("%w: %s", ErrLockLost, l.key)
+	}
+
+	return nil
+}
+
+// Unlock stops refreshing the key and deletes it, and returns ErrLockLost if the key
+// expired or was taken by another run while it was held
+func (l *leaseLock) Unlock(ctx context.Context) error {
+	if l.stop == nil {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+	defer func() { _ = l.store.close() }()
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	released, err := l.store.release(ctx, l.token)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return fmt.Errorf("%w: %s", ErrLockLost, l.key)
+	}
+
+	return l.Check()
+}
+
+// newToken returns a random token identifying the holder of a lock
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,358 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// memStore is a store in memory, which can be made to lose the lock
+type memStore struct {
+	mu    sync.Mutex
+	owner string
+	lose  bool
+	fail  bool
+}
+
+func (st *memStore) acquire(_ context.Context, token string, _ time.Duration) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.owner != "" {
+		return false, nil
+	}
+	st.owner = token
+	return true, nil
+}
+
+func (st *memStore) refresh(_ context.Context, token string, _ time.Duration) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.fail {
+		return false, errors.New("store unreachable")
+	}
+	if st.lose {
+		st.owner = "another run"
+	}
+	return st.owner == token, nil
+}
+
+func (st *memStore) release(_ context.Context, token string) (bool, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.owner != token {
+		return false, nil
+	}
+	st.owner = ""
+	return true, nil
+}
+
+func (st *memStore) close() error {
+	return nil
+}
+
+// testStore checks that a store only lets one token hold the key at a time
+func testStore(t *testing.T, st store) {
+	ctx := context.Background()
+
+	acquired, err := st.acquire(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	held, err := st.refresh(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, held)
+
+	acquired, err = st.acquire(ctx, "b", time.Minute)
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	released, err := st.release(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, released)
+
+	acquired, err = st.acquire(ctx, "b", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	// a token which no longer holds the key can't refresh or release it
+	held, err = st.refresh(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.False(t, held)
+	released, err = st.release(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, released)
+
+	released, err = st.release(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, released)
+}
+
+func TestOpen(t *testing.T) {
+	l, err := Open(dbutil.MustParseURL("redis://localhost/2?key=app&ttl=1m&timeout=5m"))
+	require.NoError(t, err)
+	lease := l.(*leaseLock)
+	require.Equal(t, "app", lease.key)
+	require.Equal(t, time.Minute, lease.ttl)
+	require.Equal(t, 5*time.Minute, lease.timeout)
+	require.Equal(t, "localhost:6379", lease.store.(*redisStore).options.Addr)
+	require.Equal(t, 2, lease.store.(*redisStore).options.DB)
+
+	l, err = Open(dbutil.MustParseURL("etcds://etcd.example.com"))
+	require.NoError(t, err)
+	lease = l.(*leaseLock)
+	require.Equal(t, DefaultKey, lease.key)
+	require.Equal(t, DefaultTTL, lease.ttl)
+	require.Equal(t, time.Duration(0), lease.timeout)
+	require.Equal(t, []string{"etcd.example.com:2379"}, lease.store.(*etcdStore).config.Endpoints)
+	require.NotNil(t, lease.store.(*etcdStore).config.TLS)
+
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_DYNAMODB", "")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	l, err = Open(dbutil.MustParseURL("dynamodb://locks?region=eu-west-1"))
+	require.NoError(t, err)
+	dynamo := l.(*leaseLock).store.(*dynamoDBStore)
+	require.Equal(t, "locks", dynamo.table)
+	require.Equal(t, "https://dynamodb.eu-west-1.amazonaws.com/", dynamo.endpoint)
+
+	for _, value := range []string{
+		"postgres://localhost/db",
+		"redis://localhost?ttl=100ms",
+		"redis://localhost?timeout=soon",
+		"redis://localhost/db",
+		"etcd:///",
+		"dynamodb:///",
+	} {
+		_, err = Open(dbutil.MustParseURL(value))
+		require.ErrorIs(t, err, ErrInvalidURL, value)
+	}
+}
+
+func TestLeaseLock(t *testing.T) {
+	st := &memStore{}
+	first := &leaseLock{store: st, key: "test", ttl: time.Minute, pollInterval: time.Millisecond}
+	second := &leaseLock{store: st, key: "test", ttl: time.Minute, timeout: 20 * time.Millisecond,
+		pollInterval: time.Millisecond}
+
+	err := first.Lock(context.Background())
+	require.NoError(t, err)
+
+	// the second run gives up waiting after the timeout
+	err = second.Lock(context.Background())
+	require.ErrorIs(t, err, dbmate.ErrLockHeld)
+	require.EqualError(t, err, "a lock is held by another session: timed out after 20ms waiting for lock test")
+
+	// the second run acquires the lock once the first releases it
+	acquired := make(chan error)
+	second.timeout = 0
+	go func() {
+		acquired <- second.Lock(context.Background())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err = first.Unlock(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, <-acquired)
+	require.NoError(t, second.Unlock(context.Background()))
+
+	// unlocking a lock which is not held does nothing
+	require.NoError(t, second.Unlock(context.Background()))
+}
+
+func TestLeaseLockLost(t *testing.T) {
+	st := &memStore{lose: true}
+	l := &leaseLock{store: st, key: "test", ttl: 30 * time.Millisecond, pollInterval: time.Millisecond}
+
+	err := l.Lock(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, l.Check())
+	time.Sleep(50 * time.Millisecond)
+
+	require.ErrorIs(t, l.Check(), ErrLockLost)
+	err = l.Unlock(context.Background())
+	require.ErrorIs(t, err, ErrLockLost)
+
+	// the lock is also lost if it can't be refreshed for a whole TTL
+	st = &memStore{fail: true}
+	l = &leaseLock{store: st, key: "test", ttl: 30 * time.Millisecond, pollInterval: time.Millisecond}
+	require.NoError(t, l.Lock(context.Background()))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, l.Check())
+	time.Sleep(40 * time.Millisecond)
+	require.ErrorIs(t, l.Check(), ErrLockLost)
+}
+
+func TestRedis(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireAuth("secret")
+
+	st, err := newRedisStore(dbutil.MustParseURL("redis://:secret@"+srv.Addr()+"/1"), "test")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, st.close()) }()
+	testStore(t, st)
+
+	// the key is lost if it expires
+	ctx := context.Background()
+	acquired, err := st.acquire(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	srv.FastForward(time.Minute)
+	held, err := st.refresh(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.False(t, held)
+
+	st, err = newRedisStore(dbutil.MustParseURL("redis://:wrong@"+srv.Addr()), "test")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, st.close()) }()
+	_, err = st.acquire(ctx, "a", time.Minute)
+	require.ErrorContains(t, err, "WRONGPASS")
+}
+
+func TestEtcd(t *testing.T) {
+	value := os.Getenv("ETCD_TEST_URL")
+	if value == "" {
+		t.Skip("ETCD_TEST_URL is not set")
+	}
+
+	st, err := newEtcdStore(dbutil.MustParseURL(value), "dbmate_test")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, st.close()) }()
+	testStore(t, st)
+
+	// the key is lost if its lease expires
+	ctx := context.Background()
+	acquired, err := st.acquire(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	_, err = st.client.Revoke(ctx, st.lease)
+	require.NoError(t, err)
+	held, err := st.refresh(ctx, "a", time.Minute)
+	require.NoError(t, err)
+	require.False(t, held)
+}
+
+// startDynamoDBServer serves the PutItem, UpdateItem, and DeleteItem actions for the
+// conditions used by dynamoDBStore
+func startDynamoDBServer(t *testing.T) *httptest.Server {
+	mu := sync.Mutex{}
+	items := map[string]map[string]string{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/"))
+		require.Equal(t, "application/x-amz-json-1.0", r.Header.Get("Content-Type"))
+
+		var req struct {
+			TableName                 string
+			Item                      map[string]map[string]string
+			Key                       map[string]map[string]string
+			ExpressionAttributeValues map[string]map[string]string
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "locks", req.TableName)
+		values := req.ExpressionAttributeValues
+
+		failed := false
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.PutItem":
+			id := req.Item["LockID"]["S"]
+			if item, ok := items[id]; ok && item["Expires"] >= values[":now"]["N"] {
+				failed = true
+				break
+			}
+			items[id] = map[string]string{"Owner": req.Item["Owner"]["S"], "Expires": req.Item["Expires"]["N"]}
+		case "DynamoDB_20120810.UpdateItem":
+			item, ok := items[req.Key["LockID"]["S"]]
+			if !ok || item["Owner"] != values[":owner"]["S"] {
+				failed = true
+				break
+			}
+			item["Expires"] = values[":expires"]["N"]
+		case "DynamoDB_20120810.DeleteItem":
+			id := req.Key["LockID"]["S"]
+			if item, ok := items[id]; !ok || item["Owner"] != values[":owner"]["S"] {
+				failed = true
+				break
+			}
+			delete(items, id)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"com.amazon.coral.service#UnknownOperationException"}`))
+			return
+		}
+
+		if failed {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",` +
+				`"message":"The conditional request failed"}`))
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestDynamoDB(t *testing.T) {
+	srv := startDynamoDBServer(t)
+	t.Setenv("AWS_ENDPOINT_URL_DYNAMODB", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	st, err := newDynamoDBStore(dbutil.MustParseURL("dynamodb://locks"), "test")
+	require.NoError(t, err)
+	testStore(t, st)
+
+	// an expired item is replaced
+	ctx := context.Background()
+	acquired, err := st.acquire(ctx, "a", time.Second)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	st.now = func() time.Time { return time.Now().Add(time.Minute) }
+	acquired, err = st.acquire(ctx, "b", time.Second)
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
+
+func TestDynamoDBError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"__type":"com.amazonaws.dynamodb.v20120810#ResourceNotFoundException",` +
+			`"message":"Requested resource not found"}`))
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_DYNAMODB", srv.URL)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	st, err := newDynamoDBStore(dbutil.MustParseURL("dynamodb://locks"), "test")
+	require.NoError(t, err)
+	_, err = st.acquire(context.Background(), "a", time.Second)
+	require.EqualError(t, err, "dynamodb: com.amazonaws.dynamodb.v20120810#ResourceNotFoundException: "+
+		"Requested resource not found")
+}
+
+func TestLockURL(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireAuth("secret")
+
+	l, err := Open(&url.URL{Scheme: "redis", User: url.UserPassword("", "secret"), Host: srv.Addr()})
+	require.NoError(t, err)
+	require.NoError(t, l.Lock(context.Background()))
+	require.NoError(t, l.Unlock(context.Background()))
+}
@@ -0,0 +1,85 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDefaultPort is used if the URL does not specify a port
+const redisDefaultPort = "6379"
+
+// redisRefreshScript extends the expiry of a key if it is still set to the token
+var redisRefreshScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then ` +
+	`return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+
+// redisReleaseScript deletes a key if it is still set to the token
+var redisReleaseScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then ` +
+	`return redis.call("del", KEYS[1]) else return 0 end`)
+
+// redisStore holds a lock in a Redis key, which is set with NX and an expiry
+type redisStore struct {
+	options *redis.Options
+	client  *redis.Client
+	key     string
+}
+
+func newRedisStore(u *url.URL, key string) (*redisStore, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, u.Redacted())
+	}
+
+	// the parameters of the lock are not options of the client
+	clientURL := *u
+	clientURL.RawQuery = ""
+	if u.Port() == "" {
+		clientURL.Host = net.JoinHostPort(u.Hostname(), redisDefaultPort)
+	}
+	options, err := redis.ParseURL(clientURL.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidURL, err)
+	}
+	options.DialTimeout = requestTimeout
+
+	return &redisStore{options: options, key: key}, nil
+}
+
+// conn returns the client, which is created when it is first used
+func (st *redisStore) conn() *redis.Client {
+	if st.client == nil {
+		st.client = redis.NewClient(st.options)
+	}
+
+	return st.client
+}
+
+func (st *redisStore) acquire(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	return st.conn().SetNX(ctx, st.key, token, ttl).Result()
+}
+
+func (st *redisStore) refresh(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	reply, err := redisRefreshScript.Run(ctx, st.conn(), []string{st.key}, token, ttl.Milliseconds()).Int64()
+
+	return reply == 1, err
+}
+
+func (st *redisStore) release(ctx context.Context, token string) (bool, error) {
+	reply, err := redisReleaseScript.Run(ctx, st.conn(), []string{st.key}, token).Int64()
+
+	return reply == 1, err
+}
+
+func (st *redisStore) close() error {
+	if st.client == nil {
+		return nil
+	}
+
+	err := st.client.Close()
+	st.client = nil
+
+	return err
+}
@@ -0,0 +1,110 @@
+package remotefs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// gcsReadOnlyScope allows objects to be listed and downloaded
+const gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsStore reads objects from a Google Cloud Storage bucket using the JSON API, or from
+// an emulator if STORAGE_EMULATOR_HOST is set
+type gcsStore struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+}
+
+func newGCSStore(ctx context.Context, u *url.URL) (*gcsStore, error) {
+	st := &gcsStore{
+		client:   http.DefaultClient,
+		endpoint: "https://storage.googleapis.com",
+		bucket:   u.Host,
+		prefix:   objectPrefix(u.Path),
+	}
+
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		st.endpoint = strings.TrimSuffix(host, "/")
+		if !strings.Contains(st.endpoint, "://") {
+			st.endpoint = "http://" + st.endpoint
+		}
+		return st, nil
+	}
+
+	// public buckets can be read without credentials
+	if creds, err := google.FindDefaultCredentials(ctx, gcsReadOnlyScope); err == nil {
+		st.client = oauth2.NewClient(ctx, creds.TokenSource)
+	}
+
+	return st, nil
+}
+
+// gcsListResult is the response of the objects list API
+type gcsListResult struct {
+	Items []struct {
+		Name    string `json:"name"`
+		MD5Hash string `json:"md5Hash"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (st *gcsStore) list(ctx context.Context) ([]object, error) {
+	objects := []object{}
+	token := ""
+	for {
+		query := url.Values{"prefix": {st.prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+
+		data, err := st.request(ctx, fmt.Sprintf("/storage/v1/b/%s/o?%s",
+			url.PathEscape(st.bucket), query.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		var result gcsListResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			obj := object{name: strings.TrimPrefix(item.Name, st.prefix)}
+			// composite objects have no MD5 checksum
+			if sum, err := base64.StdEncoding.DecodeString(item.MD5Hash); err == nil && len(sum) > 0 {
+				obj.md5 = hex.EncodeToString(sum)
+			}
+			objects = append(objects, obj)
+		}
+
+		if result.NextPageToken == "" {
+			return objects, nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+func (st *gcsStore) get(ctx context.Context, name string) ([]byte, error) {
+	return st.request(ctx, fmt.Sprintf("/storage/v1/b/%s/o/%s?alt=media",
+		url.PathEscape(st.bucket), url.PathEscape(st.prefix+name)))
+}
+
+func (st *gcsStore) request(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, st.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return fetch(st.client, req)
+}
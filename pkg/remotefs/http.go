@@ -0,0 +1,57 @@
+package remotefs
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// httpStore reads files from an HTTP server. Since directories can't be listed, the
+// files are listed by the SHA256SUMS manifest.
+type httpStore struct {
+	client  *http.Client
+	baseURL *url.URL
+}
+
+func newHTTPStore(u *url.URL) *httpStore {
+	baseURL := *u
+	if !strings.HasSuffix(baseURL.Path, "/") {
+		baseURL.Path += "/"
+		baseURL.RawPath = ""
+	}
+
+	return &httpStore{client: http.DefaultClient, baseURL: &baseURL}
+}
+
+func (st *httpStore) list(ctx context.Context) ([]object, error) {
+	data, err := st.get(ctx, ManifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []object{}
+	for name, sum := range checksums {
+		objects = append(objects, object{name: name, sha256: sum})
+	}
+
+	return objects, nil
+}
+
+func (st *httpStore) get(ctx context.Context, name string) ([]byte, error) {
+	// the query string is kept, since it may contain an access token
+	u := st.baseURL.JoinPath(name)
+	u.RawQuery = st.baseURL.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return fetch(st.client, req)
+}
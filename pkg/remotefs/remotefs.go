@@ -0,0 +1,275 @@
+// Package remotefs reads migration files from Amazon S3, Google Cloud Storage, or an
+// HTTP server, so that migrations published to an artifact store can be applied without
+// a copy of the repository
+package remotefs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Error codes
+var (
+	ErrInvalidURL      = errors.New("invalid migrations url, expected s3://bucket/prefix, gs://bucket/prefix, or https://host/path")
+	ErrChecksum        = errors.New("checksum mismatch")
+	ErrInvalidManifest = errors.New("invalid checksum manifest")
+)
+
+// ManifestFile lists the SHA-256 checksums of the migration files, in the format written
+// by sha256sum. It is required for HTTP locations, which can't be listed, and optional
+// for S3 and GCS.
+const ManifestFile = "SHA256SUMS"
+
+// object is a migration file in the remote location
+type object struct {
+	// name is the file name, relative to the location
+	name string
+	// md5 is the hex encoded MD5 checksum reported by the storage service, if known
+	md5 string
+	// sha256 is the hex encoded SHA-256 checksum listed in the manifest, if any
+	sha256 string
+}
+
+// verify returns an error if data does not match the object's checksum. Data is
+// accepted if the checksum is unknown.
+func (obj object) verify(data []byte) error {
+	switch {
+	case obj.sha256 != "":
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != obj.sha256 {
+			return fmt.Errorf("%w: %s (expected sha256 %s)", ErrChecksum, obj.name, obj.sha256)
+		}
+	case obj.md5 != "":
+		sum := md5.Sum(data)
+		if hex.EncodeToString(sum[:]) != obj.md5 {
+			return fmt.Errorf("%w: %s (expected md5 %s)", ErrChecksum, obj.name, obj.md5)
+		}
+	}
+
+	return nil
+}
+
+// store lists and downloads the files in a remote location
+type store interface {
+	// list returns the files directly within the location
+	list(ctx context.Context) ([]object, error)
+	// get downloads a file
+	get(ctx context.Context, name string) ([]byte, error)
+}
+
+func newStore(ctx context.Context, u *url.URL) (store, error) {
+	if u == nil || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Store(ctx, u)
+	case "gs":
+		return newGCSStore(ctx, u)
+	case "http", "https":
+		return newHTTPStore(u), nil
+	default:
+		return nil, ErrInvalidURL
+	}
+}
+
+// DefaultCacheDir returns the directory where downloaded migrations are cached by
+// default
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "dbmate", "migrations")
+}
+
+// Open downloads the .sql files in the remote location described by the URL, and
+// returns a filesystem containing them. Files are cached in a subdirectory of cacheDir
+// specific to the URL, and only downloaded again if their checksum changes. Files are
+// verified against the SHA256SUMS manifest if there is one, or otherwise against the MD5
+// checksum reported by S3 or GCS.
+func Open(ctx context.Context, u *url.URL, cacheDir string) (fs.FS, error) {
+	st, err := newStore(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := st.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objects, err = applyManifest(ctx, st, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	key := sha256.Sum256([]byte(u.String()))
+	dir := filepath.Join(cacheDir, hex.EncodeToString(key[:8]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, obj := range objects {
+		names[obj.name] = true
+		if err := download(ctx, st, obj, filepath.Join(dir, obj.name)); err != nil {
+			return nil, err
+		}
+	}
+
+	// remove files which have been deleted from the remote location
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !names[entry.Name()] {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return os.DirFS(dir), nil
+}
+
+// applyManifest returns the .sql files in objects, with their SHA-256 checksums if the
+// manifest is among the objects. Every file must then be listed in the manifest.
+func applyManifest(ctx context.Context, st store, objects []object) ([]object, error) {
+	var checksums map[string]string
+	for _, obj := range objects {
+		if obj.name != ManifestFile {
+			continue
+		}
+
+		data, err := st.get(ctx, ManifestFile)
+		if err != nil {
+			return nil, err
+		}
+		if checksums, err = parseManifest(data); err != nil {
+			return nil, err
+		}
+	}
+
+	files := []object{}
+	for _, obj := range objects {
+		if !isMigrationFile(obj.name) {
+			continue
+		}
+		if checksums != nil {
+			sum, ok := checksums[obj.name]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s is not listed in %s", ErrChecksum, obj.name, ManifestFile)
+			}
+			obj.sha256 = sum
+		}
+		files = append(files, obj)
+	}
+
+	return files, nil
+}
+
+// download writes the object to path, unless path already contains a copy matching its
+// checksum
+func download(ctx context.Context, st store, obj object, path string) error {
+	if obj.sha256 != "" || obj.md5 != "" {
+		if cached, err := os.ReadFile(path); err == nil && obj.verify(cached) == nil {
+			return nil
+		}
+	}
+
+	data, err := st.get(ctx, obj.name)
+	if err != nil {
+		return err
+	}
+	if err := obj.verify(data); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so an interrupted download is never cached
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// parseManifest parses sha256sum output into checksums by file name
+func parseManifest(data []byte) (map[string]string, error) {
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(line, " ")
+		// binary mode is marked with an asterisk
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if _, err := hex.DecodeString(sum); !ok || err != nil || len(sum) != sha256.Size*2 || name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidManifest, line)
+		}
+		checksums[name] = strings.ToLower(sum)
+	}
+
+	return checksums, scanner.Err()
+}
+
+// isMigrationFile returns true for .sql files which are not in a subdirectory
+func isMigrationFile(name string) bool {
+	return strings.HasSuffix(name, ".sql") && !strings.ContainsAny(name, `/\`)
+}
+
+// fetch performs a request and returns the response body, or an error if the response
+// status is not 200 OK
+func fetch(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer dbutil.MustClose(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch %s: %s", redactURL(req.URL), resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// redactURL returns the URL without its query string, which may contain a signature or
+// access token
+func redactURL(u *url.URL) string {
+	clone := *u
+	clone.RawQuery = ""
+	clone.User = nil
+
+	return clone.String()
+}
@@ -0,0 +1,128 @@
+package remotefs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// s3Store reads objects from an S3 bucket, or an S3 compatible service if
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL is set
+type s3Store struct {
+	client      *http.Client
+	credentials dbutil.AWSCredentials
+	region      string
+	// baseURL is the URL of the bucket, to which object keys are appended
+	baseURL *url.URL
+	prefix  string
+	now     func() time.Time
+}
+
+func newS3Store(ctx context.Context, u *url.URL) (*s3Store, error) {
+	region := dbutil.AWSRegion(u.Query().Get("region"))
+	credentials, err := dbutil.LoadAWSCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// custom endpoints use path style URLs, since they may not support a subdomain per
+	// bucket
+	baseURL := &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", u.Host, region), Path: "/"}
+	if endpoint := dbutil.AWSEndpoint("s3"); endpoint != "" {
+		if baseURL, err = url.Parse(endpoint + "/" + u.Host + "/"); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3Store{
+		client:      http.DefaultClient,
+		credentials: credentials,
+		region:      region,
+		baseURL:     baseURL,
+		prefix:      objectPrefix(u.Path),
+		now:         time.Now,
+	}, nil
+}
+
+// objectPrefix returns the key prefix of objects in a location path, which is empty or
+// ends with a slash
+func objectPrefix(path string) string {
+	prefix := strings.Trim(path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return prefix
+}
+
+// s3ListResult is the response of the ListObjectsV2 API
+type s3ListResult struct {
+	Contents []struct {
+		Key  string
+		ETag string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (st *s3Store) list(ctx context.Context) ([]object, error) {
+	objects := []object{}
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {st.prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		data, err := st.request(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		for _, content := range result.Contents {
+			obj := object{name: strings.TrimPrefix(content.Key, st.prefix)}
+			// the ETag is the MD5 checksum, unless the object was uploaded in parts or is
+			// encrypted with a KMS key
+			if etag := strings.Trim(content.ETag, `"`); len(etag) == 32 {
+				obj.md5 = etag
+			}
+			objects = append(objects, obj)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (st *s3Store) get(ctx context.Context, name string) ([]byte, error) {
+	return st.request(ctx, st.prefix+name, nil)
+}
+
+// request sends a signed GET request for an object key (or the bucket, if key is empty)
+func (st *s3Store) request(ctx context.Context, key string, query url.Values) ([]byte, error) {
+	u := *st.baseURL
+	u.Path += key
+	// send the path in its canonical form, so it matches the signature
+	u.RawPath = dbutil.AWSURIEscape(u.Path, false)
+	u.RawQuery = dbutil.AWSQueryEscape(query)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	st.credentials.Sign(req, nil, st.region, "s3", st.now())
+
+	return fetch(st.client, req)
+}
@@ -0,0 +1,549 @@
+// Package schema parses schema files written by dbmate (e.g. db/schema.sql) into
+// tables, columns, indexes, and foreign keys, along with the versions of the applied
+// migrations, for tools such as ER diagram generators or drift checks.
+//
+// Schema dumps written by the PostgreSQL, MySQL (and MariaDB), and SQLite drivers are
+// supported. Statements which do not define tables, indexes, or the applied migrations
+// (such as views, functions, and triggers) are ignored.
+package schema
+
+import (
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// Schema is a parsed schema file
+type Schema struct {
+	// Tables lists the tables in the order they are created, including the schema
+	// migrations table
+	Tables []*Table
+	// Versions lists the applied migration versions recorded in the dbmate schema
+	// migrations section, which is empty if the section is written to a separate file
+	Versions []string
+}
+
+// Table is a table and the constraints and indexes defined on it
+type Table struct {
+	// Schema is the schema (or database) qualifying the table name, e.g. public, or
+	// empty if the name is not qualified
+	Schema      string
+	Name        string
+	Columns     []*Column
+	PrimaryKey  []string
+	Indexes     []*Index
+	ForeignKeys []*ForeignKey
+}
+
+// Column is a table column
+type Column struct {
+	Name string
+	// Type is the data type as written in the schema file, e.g. character varying(255)
+	Type     string
+	Nullable bool
+	// Default is the default value expression, or empty if the column has none
+	Default string
+}
+
+// Index is an index or unique constraint
+type Index struct {
+	// Name is empty for unnamed unique constraints
+	Name string
+	// Columns lists the indexed column names, or the text of indexed expressions
+	Columns []string
+	Unique  bool
+}
+
+// ForeignKey is a foreign key constraint
+type ForeignKey struct {
+	// Name is empty for unnamed constraints
+	Name              string
+	Columns           []string
+	ReferencedSchema  string
+	ReferencedTable   string
+	ReferencedColumns []string
+}
+
+// ParseFile reads and parses a schema file
+func ParseFile(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(data)
+}
+
+// Parse parses the contents of a schema file. MySQL dumps are recognized by their
+// executable comments or table options, and parsed with backslash escapes in strings.
+func Parse(data []byte) (*Schema, error) {
+	text := string(data)
+	p := &parser{
+		schema: &Schema{Tables: []*Table{}, Versions: []string{}},
+		mysql:  mysqlDumpRegexp.MatchString(text),
+	}
+
+	objects, migrations, _ := strings.Cut(removeDelimiterBlocks(text), schemaMigrationsMarker)
+
+	stmts, err := dbmate.SplitSchema(objects, p.mysql)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range stmts {
+		p.statement(trimLeadingComments(stmt))
+	}
+
+	stmts, err = dbmate.SplitSchema(migrations, p.mysql)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range stmts {
+		p.versions(trimLeadingComments(stmt))
+	}
+
+	return p.schema, nil
+}
+
+// Table returns the table with the name, which may be qualified by its schema, or nil
+// if there is no such table
+func (s *Schema) Table(name string) *Table {
+	for _, t := range s.Tables {
+		if t.Name == name || (t.Schema != "" && t.Schema+"."+t.Name == name) {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Column returns the column with the name, or nil if there is no such column
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// schemaMigrationsMarker is the comment written by every driver before the dbmate
+// schema migrations section of the dump
+const schemaMigrationsMarker = "-- Dbmate schema migrations"
+
+// an optionally quoted identifier, e.g. users, "users", `users`, or [users]
+const identifier = "(?:\"(?:[^\"]|\"\")+\"|`[^`]+`|\\[[^\\]]+\\]|[\\w$]+)"
+const qualifiedName = "(" + identifier + "(?:\\s*\\.\\s*" + identifier + ")*)"
+
+var (
+	mysqlDumpRegexp   = regexp.MustCompile("/\\*!\\d{5}|\\)\\s*ENGINE=")
+	delimiterRegexp   = regexp.MustCompile(`(?i)^DELIMITER\s+(\S+)\s*$`)
+	identifierRegexp  = regexp.MustCompile(identifier)
+	columnNameRegexp  = regexp.MustCompile(`^` + identifier)
+	createTableRegexp = regexp.MustCompile(`(?is)^create\s+(?:(?:global\s+|local\s+)?temp(?:orary)?\s+|unlogged\s+)?table\s+(?:if\s+not\s+exists\s+)?` + qualifiedName + `\s*\(`)
+	createIndexRegexp = regexp.MustCompile(`(?is)^create\s+(unique\s+)?index\s+(?:concurrently\s+)?(?:if\s+not\s+exists\s+)?(` + identifier + `)\s+on\s+(?:only\s+)?` + qualifiedName + `\s*(?:using\s+\w+\s*)?\(`)
+	alterTableRegexp  = regexp.MustCompile(`(?is)^alter\s+table\s+(?:if\s+exists\s+)?(?:only\s+)?` + qualifiedName + `\s+(.*)$`)
+	insertRegexp      = regexp.MustCompile(`(?is)^insert\s+into\s`)
+	valuesRegexp      = regexp.MustCompile(`(?i)\bvalues\b`)
+	stringRegexp      = regexp.MustCompile(`'((?:[^'\\]|''|\\.)*)'`)
+
+	constraintRegexp  = regexp.MustCompile(`(?is)^constraint\s+(` + identifier + `)\s+(.*)$`)
+	primaryKeyRegexp  = regexp.MustCompile(`(?is)^primary\s+key\b`)
+	uniqueRegexp      = regexp.MustCompile(`(?is)^unique(?:\s+(?:key|index))?(?:\s+(` + identifier + `))?\s*\(`)
+	mysqlIndexRegexp  = regexp.MustCompile(`(?is)^(?:(?:fulltext|spatial)\s+)?(?:key|index)(?:\s+(` + identifier + `))?\s*\(`)
+	foreignKeyRegexp  = regexp.MustCompile(`(?is)^foreign\s+key\b`)
+	referencesRegexp  = regexp.MustCompile(`(?is)^references\s+` + qualifiedName)
+	ignoredItemRegexp = regexp.MustCompile(`(?is)^(?:check|exclude)\b`)
+	addRegexp         = regexp.MustCompile(`(?is)^add\s+(?:column\s+)?(?:if\s+not\s+exists\s+)?(.*)$`)
+	setDefaultRegexp  = regexp.MustCompile(`(?is)^alter\s+(?:column\s+)?(` + identifier + `)\s+set\s+default\s+(.*)$`)
+	setNotNullRegexp  = regexp.MustCompile(`(?is)^alter\s+(?:column\s+)?(` + identifier + `)\s+set\s+not\s+null$`)
+	indexColumnRegexp = regexp.MustCompile(`(?is)^(` + identifier + `)(?:\s*\(\d+\))?(?:\s+(?:asc|desc|nulls\s+first|nulls\s+last|collate\s+\S+|\w+_ops))*$`)
+)
+
+// columnStopWords end the data type of a column definition, or its default value
+var columnStopWords = map[string]bool{
+	"AS": true, "AUTOINCREMENT": true, "AUTO_INCREMENT": true, "CHECK": true,
+	"COLLATE": true, "COMMENT": true, "CONSTRAINT": true, "DEFAULT": true,
+	"GENERATED": true, "INVISIBLE": true, "NOT": true, "NULL": true, "ON": true,
+	"PRIMARY": true, "REFERENCES": true, "UNIQUE": true, "VISIBLE": true,
+}
+
+// parser builds a schema from the statements of a schema file
+type parser struct {
+	schema *Schema
+	// mysql enables backslash escapes in strings, and KEY and INDEX table items
+	mysql bool
+}
+
+// statement adds the table defined by a create table statement, or the index or
+// constraint defined by a create index or alter table statement
+func (p *parser) statement(stmt string) {
+	if match := createTableRegexp.FindStringSubmatchIndex(stmt); match != nil {
+		t := &Table{Columns: []*Column{}, PrimaryKey: []string{}, Indexes: []*Index{},
+			ForeignKeys: []*ForeignKey{}}
+		t.Schema, t.Name = splitName(stmt[match[2]:match[3]])
+		body, _, _ := p.parens(stmt[match[1]-1:])
+		for _, item := range p.split(body, ',') {
+			p.tableItem(t, item)
+		}
+		p.schema.Tables = append(p.schema.Tables, t)
+		return
+	}
+
+	if match := createIndexRegexp.FindStringSubmatchIndex(stmt); match != nil {
+		t := p.table(stmt[match[6]:match[7]])
+		if t == nil {
+			return
+		}
+		columns, _, _ := p.parens(stmt[match[1]-1:])
+		t.Indexes = append(t.Indexes, &Index{
+			Name:    unquote(stmt[match[4]:match[5]]),
+			Columns: p.columns(columns),
+			Unique:  match[2] >= 0,
+		})
+		return
+	}
+
+	if match := alterTableRegexp.FindStringSubmatch(stmt); match != nil {
+		t := p.table(match[1])
+		if t == nil {
+			return
+		}
+		for _, action := range p.split(match[2], ',') {
+			p.alterAction(t, action)
+		}
+	}
+}
+
+// alterAction applies an action of an alter table statement which adds a column or
+// constraint, or sets the default value or nullability of a column
+func (p *parser) alterAction(t *Table, action string) {
+	if match := addRegexp.FindStringSubmatch(action); match != nil {
+		p.tableItem(t, match[1])
+	} else if match := setDefaultRegexp.FindStringSubmatch(action); match != nil {
+		if c := t.Column(unquote(match[1])); c != nil {
+			c.Default = strings.TrimSpace(match[2])
+		}
+	} else if match := setNotNullRegexp.FindStringSubmatch(action); match != nil {
+		if c := t.Column(unquote(match[1])); c != nil {
+			c.Nullable = false
+		}
+	}
+}
+
+// tableItem adds a column or constraint definition to the table
+func (p *parser) tableItem(t *Table, item string) {
+	name := ""
+	if match := constraintRegexp.FindStringSubmatch(item); match != nil {
+		name, item = unquote(match[1]), match[2]
+	}
+
+	switch {
+	case primaryKeyRegexp.MatchString(item):
+		columns, _, _ := p.parens(item)
+		t.PrimaryKey = p.columns(columns)
+	case uniqueRegexp.MatchString(item):
+		if match := uniqueRegexp.FindStringSubmatch(item); name == "" && match[1] != "" {
+			name = unquote(match[1])
+		}
+		columns, _, _ := p.parens(item)
+		t.Indexes = append(t.Indexes, &Index{Name: name, Columns: p.columns(columns), Unique: true})
+	case p.mysql && mysqlIndexRegexp.MatchString(item):
+		if match := mysqlIndexRegexp.FindStringSubmatch(item); match[1] != "" {
+			name = unquote(match[1])
+		}
+		columns, _, _ := p.parens(item)
+		t.Indexes = append(t.Indexes, &Index{Name: name, Columns: p.columns(columns)})
+	case foreignKeyRegexp.MatchString(item):
+		columns, rest, _ := p.parens(item)
+		if fk := p.references(strings.TrimSpace(rest)); fk != nil {
+			fk.Name = name
+			fk.Columns = p.columns(columns)
+			t.ForeignKeys = append(t.ForeignKeys, fk)
+		}
+	case ignoredItemRegexp.MatchString(item):
+	case name == "":
+		p.column(t, item)
+	}
+}
+
+// column adds a column definition, along with any primary key, unique, or foreign key
+// constraints declared with it
+func (p *parser) column(t *Table, def string) {
+	name := columnNameRegexp.FindString(def)
+	if name == "" {
+		return
+	}
+	c := &Column{Name: unquote(name), Nullable: true}
+	t.Columns = append(t.Columns, c)
+
+	words := p.fields(def[len(name):])
+	i := 0
+	for ; i < len(words) && !isStopWord(words, i); i++ {
+		c.Type = strings.TrimSpace(c.Type + " " + words[i])
+	}
+
+	for i < len(words) {
+		word := strings.ToUpper(words[i])
+		switch {
+		case word == "NOT" && i+1 < len(words) && strings.EqualFold(words[i+1], "NULL"):
+			c.Nullable = false
+			i += 2
+		case word == "DEFAULT":
+			values := []string{}
+			for i++; i < len(words) && !isStopWord(words, i); i++ {
+				values = append(values, words[i])
+			}
+			c.Default = strings.Join(values, " ")
+		case word == "PRIMARY":
+			t.PrimaryKey = []string{c.Name}
+			c.Nullable = false
+			i++
+		case word == "UNIQUE":
+			t.Indexes = append(t.Indexes, &Index{Columns: []string{c.Name}, Unique: true})
+			i++
+		case word == "REFERENCES":
+			if fk := p.references(strings.Join(words[i:], " ")); fk != nil {
+				fk.Columns = []string{c.Name}
+				t.ForeignKeys = append(t.ForeignKeys, fk)
+			}
+			return
+		default:
+			i++
+		}
+	}
+}
+
+// references parses the table and columns referenced by a foreign key, or returns nil
+// if text does not start with a references clause
+func (p *parser) references(text string) *ForeignKey {
+	match := referencesRegexp.FindStringSubmatchIndex(text)
+	if match == nil {
+		return nil
+	}
+
+	fk := &ForeignKey{ReferencedColumns: []string{}}
+	fk.ReferencedSchema, fk.ReferencedTable = splitName(text[match[2]:match[3]])
+	if rest := strings.TrimSpace(text[match[1]:]); strings.HasPrefix(rest, "(") {
+		columns, _, _ := p.parens(rest)
+		fk.ReferencedColumns = p.columns(columns)
+	}
+
+	return fk
+}
+
+// versions adds the versions inserted by a statement of the schema migrations section
+func (p *parser) versions(stmt string) {
+	if !insertRegexp.MatchString(stmt) {
+		return
+	}
+
+	values := valuesRegexp.FindStringIndex(stmt)
+	if values == nil {
+		return
+	}
+	for _, match := range stringRegexp.FindAllStringSubmatch(stmt[values[1]:], -1) {
+		version := strings.ReplaceAll(match[1], "''", "'")
+		if p.mysql {
+			version = strings.ReplaceAll(version, `\'`, "'")
+		}
+		p.schema.Versions = append(p.schema.Versions, version)
+	}
+}
+
+// table returns the table with the (possibly quoted and qualified) name, or nil if it
+// has not been created
+func (p *parser) table(name string) *Table {
+	schema, table := splitName(name)
+	for _, t := range p.schema.Tables {
+		if t.Name == table && t.Schema == schema {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// columns returns the unquoted column names of a column list, or the text of each item
+// which is an expression
+func (p *parser) columns(list string) []string {
+	columns := []string{}
+	for _, item := range p.split(list, ',') {
+		if match := indexColumnRegexp.FindStringSubmatch(item); match != nil {
+			columns = append(columns, unquote(match[1]))
+		} else if item != "" {
+			columns = append(columns, item)
+		}
+	}
+
+	return columns
+}
+
+// scan calls fn with the index and parenthesis depth of each character of s which is
+// outside of quoted strings and identifiers (including opening quotes), until fn
+// returns false
+func (p *parser) scan(s string, fn func(i, depth int) bool) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' && quote == '\'' && p.mysql {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+		case '[':
+			quote = ']'
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if !fn(i, depth) {
+			return
+		}
+	}
+}
+
+// parens returns the text inside the first parenthesized group of s, and the text
+// following it
+func (p *parser) parens(s string) (inside, rest string, found bool) {
+	start := -1
+	p.scan(s, func(i, depth int) bool {
+		switch {
+		case start < 0 && s[i] == '(':
+			start = i
+		case start >= 0 && depth == 0:
+			inside, rest, found = s[start+1:i], s[i+1:], true
+			return false
+		}
+		return true
+	})
+
+	return inside, rest, found
+}
+
+// split splits s at each sep which is not inside parentheses or quotes, and trims
+// the parts
+func (p *parser) split(s string, sep byte) []string {
+	parts := []string{}
+	start := 0
+	p.scan(s, func(i, depth int) bool {
+		if depth == 0 && s[i] == sep {
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+		return true
+	})
+	if last := strings.TrimSpace(s[start:]); last != "" || len(parts) > 0 {
+		parts = append(parts, last)
+	}
+
+	return parts
+}
+
+// fields splits s at whitespace which is not inside parentheses or quotes
+func (p *parser) fields(s string) []string {
+	words := []string{}
+	start := -1
+	// a trailing space ends the last word
+	s += " "
+	p.scan(s, func(i, depth int) bool {
+		space := depth == 0 && strings.ContainsRune(" \t\r\n", rune(s[i]))
+		switch {
+		case space && start >= 0:
+			words = append(words, s[start:i])
+			start = -1
+		case !space && start < 0:
+			start = i
+		}
+		return true
+	})
+
+	return words
+}
+
+// isStopWord returns true if words[i] ends the type or default value of a column
+func isStopWord(words []string, i int) bool {
+	word := strings.ToUpper(words[i])
+	if word == "CHARACTER" {
+		// mysql character set, e.g. varchar(255) CHARACTER SET utf8mb4
+		return i+1 < len(words) && strings.EqualFold(words[i+1], "SET")
+	}
+
+	return columnStopWords[word]
+}
+
+// splitName returns the schema and name of a (possibly quoted and qualified) name
+func splitName(name string) (schema, table string) {
+	parts := identifierRegexp.FindAllString(name, -1)
+	for i := range parts {
+		parts[i] = unquote(parts[i])
+	}
+	if len(parts) == 0 {
+		return "", ""
+	}
+
+	return strings.Join(parts[:len(parts)-1], "."), parts[len(parts)-1]
+}
+
+// unquote removes the quotes around an identifier
+func unquote(name string) string {
+	switch {
+	case len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"':
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	case len(name) >= 2 && name[0] == '`' && name[len(name)-1] == '`',
+		len(name) >= 2 && name[0] == '[' && name[len(name)-1] == ']':
+		return name[1 : len(name)-1]
+	}
+
+	return name
+}
+
+// removeDelimiterBlocks removes the text between DELIMITER commands, which mysqldump
+// writes around the definitions of routines and triggers
+func removeDelimiterBlocks(text string) string {
+	var b strings.Builder
+	custom := false
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if match := delimiterRegexp.FindStringSubmatch(strings.TrimRight(line, "\r\n")); match != nil {
+			custom = match[1] != ";"
+			continue
+		}
+		if !custom {
+			b.WriteString(line)
+		}
+	}
+
+	return b.String()
+}
+
+// trimLeadingComments removes comments and whitespace from the beginning of a
+// statement. A mysql executable comment is removed along with the statement it
+// contains, such as a SET statement or a view definition.
+func trimLeadingComments(s string) string {
+	for {
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			_, s, _ = strings.Cut(s, "\n")
+		case strings.HasPrefix(s, "/*"):
+			_, rest, found := strings.Cut(s, "*/")
+			if !found {
+				return ""
+			}
+			s = rest
+		default:
+			return s
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package schema_test
+
+import (
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/schema"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tableNames(s *schema.Schema) []string {
+	names := []string{}
+	for _, t := range s.Tables {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+func TestParsePostgres(t *testing.T) {
+	s, err := schema.ParseFile("testdata/postgres.sql")
+	require.NoError(t, err)
+	require.Equal(t, []string{"posts", "schema_migrations", "users"}, tableNames(s))
+	require.Equal(t, []string{"20200101000000", "20200102000000"}, s.Versions)
+
+	posts := s.Table("public.posts")
+	require.NotNil(t, posts)
+	require.Same(t, posts, s.Table("posts"))
+	require.Equal(t, "public", posts.Schema)
+	require.Equal(t, []*schema.Column{
+		{Name: "id", Type: "bigint", Default: "nextval('public.posts_id_seq'::regclass)"},
+		{Name: "user_id", Type: "bigint"},
+		{Name: "title", Type: "character varying(255)", Default: "'untitled'::character varying"},
+		{Name: "tags", Type: "text[]", Nullable: true},
+		{Name: "created_at", Type: "timestamp without time zone", Nullable: true, Default: "now()"},
+	}, posts.Columns)
+	require.Equal(t, []string{"id"}, posts.PrimaryKey)
+	require.Equal(t, []*schema.Index{
+		{Name: "posts_user_id_created_at", Columns: []string{"user_id", "created_at"}},
+	}, posts.Indexes)
+	require.Equal(t, []*schema.ForeignKey{{
+		Name:              "posts_user_id_fkey",
+		Columns:           []string{"user_id"},
+		ReferencedSchema:  "public",
+		ReferencedTable:   "users",
+		ReferencedColumns: []string{"id"},
+	}}, posts.ForeignKeys)
+
+	users := s.Table("users")
+	require.Equal(t, []string{"id", "email", "Display Name"}, []string{
+		users.Columns[0].Name, users.Columns[1].Name, users.Columns[2].Name})
+	require.Len(t, users.Columns, 3)
+	require.Equal(t, []*schema.Index{
+		{Name: "users_email_key", Columns: []string{"email"}, Unique: true},
+		{Name: "users_lower_email", Columns: []string{"lower(email)"}, Unique: true},
+	}, users.Indexes)
+	require.Nil(t, s.Table("user_emails"))
+}
+
+func TestParseMySQL(t *testing.T) {
+	s, err := schema.ParseFile("testdata/mysql.sql")
+	require.NoError(t, err)
+	require.Equal(t, []string{"posts", "schema_migrations", "users"}, tableNames(s))
+	require.Equal(t, []string{"20200101000000", "20200102000000"}, s.Versions)
+
+	posts := s.Table("posts")
+	require.Equal(t, "", posts.Schema)
+	require.Equal(t, []*schema.Column{
+		{Name: "id", Type: "bigint"},
+		{Name: "user_id", Type: "int"},
+		{Name: "title", Type: "varchar(255)", Default: `'it\'s, (new)'`},
+		{Name: "body", Type: "text", Nullable: true},
+		{Name: "updated_at", Type: "timestamp", Nullable: true, Default: "CURRENT_TIMESTAMP"},
+	}, posts.Columns)
+	require.Equal(t, []string{"id"}, posts.PrimaryKey)
+	require.Equal(t, []*schema.Index{
+		{Name: "posts_title", Columns: []string{"title"}, Unique: true},
+		{Name: "posts_user_id", Columns: []string{"user_id", "updated_at"}},
+		{Name: "posts_body", Columns: []string{"body"}},
+	}, posts.Indexes)
+	require.Equal(t, []*schema.ForeignKey{{
+		Name:              "posts_user_id_fk",
+		Columns:           []string{"user_id"},
+		ReferencedTable:   "users",
+		ReferencedColumns: []string{"id"},
+	}}, posts.ForeignKeys)
+
+	require.Equal(t, "int unsigned", s.Table("users").Column("id").Type)
+	require.Equal(t, "", s.Table("users").Column("email").Default)
+}
+
+func TestParseSQLite(t *testing.T) {
+	s, err := schema.ParseFile("testdata/sqlite.sql")
+	require.NoError(t, err)
+	require.Equal(t, []string{"schema_migrations", "users", "sqlite_sequence", "posts"}, tableNames(s))
+	require.Equal(t, []string{"20200101000000", "20200102000000"}, s.Versions)
+	require.Equal(t, []string{"version"}, s.Table("schema_migrations").PrimaryKey)
+
+	users := s.Table("users")
+	require.Equal(t, []*schema.Column{
+		{Name: "id", Type: "integer"},
+		{Name: "email", Type: "varchar(255)"},
+		{Name: "role", Type: "text", Nullable: true, Default: "'member'"},
+	}, users.Columns)
+	require.Equal(t, []string{"id"}, users.PrimaryKey)
+	require.Equal(t, []*schema.Index{{Columns: []string{"email"}, Unique: true}}, users.Indexes)
+
+	require.Equal(t, []*schema.Column{{Name: "name", Nullable: true}, {Name: "seq", Nullable: true}},
+		s.Table("sqlite_sequence").Columns)
+
+	posts := s.Table("posts")
+	require.Equal(t, []string{"id"}, posts.PrimaryKey)
+	require.Equal(t, []*schema.Index{
+		{Columns: []string{"user_id", "title"}, Unique: true},
+		{Name: "posts_title", Columns: []string{"title"}},
+	}, posts.Indexes)
+	require.Equal(t, []*schema.ForeignKey{{
+		Columns:           []string{"user_id"},
+		ReferencedTable:   "users",
+		ReferencedColumns: []string{"id"},
+	}}, posts.ForeignKeys)
+}
+
+func TestParseSeparateMigrations(t *testing.T) {
+	s, err := schema.Parse([]byte("-- Dbmate schema migrations\n" +
+		"INSERT INTO schema_migrations (version) VALUES ('001'), ('002');\n"))
+	require.NoError(t, err)
+	require.Empty(t, s.Tables)
+	require.Equal(t, []string{"001", "002"}, s.Versions)
+
+	s, err = schema.Parse([]byte("CREATE TABLE users (id integer);\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"users"}, tableNames(s))
+	require.Empty(t, s.Versions)
+}
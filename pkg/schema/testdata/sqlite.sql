@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS "schema_migrations" (version varchar(128) primary key);
+CREATE TABLE users (
+  id integer primary key autoincrement,
+  email varchar(255) not null unique,
+  [role] text default 'member'
+);
+CREATE TABLE sqlite_sequence(name,seq);
+CREATE TABLE posts (
+  id integer not null,
+  user_id integer references users(id) on delete cascade,
+  "title" text,
+  primary key (id),
+  unique (user_id, "title")
+);
+CREATE INDEX posts_title on posts (title collate nocase);
+-- Dbmate schema migrations
+INSERT INTO "schema_migrations" (version) VALUES
+  ('20200101000000'),
+  ('20200102000000');
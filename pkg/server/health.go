@@ -0,0 +1,97 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// Health is an http.Handler reporting the progress of a dbmate command, so that when
+// dbmate runs as an init or sidecar container, Kubernetes probes can delay starting the
+// application until migrations have been applied. Requests are not authenticated, and
+// only report whether the database is available, and whether the command completed:
+//
+//	GET /healthz  always responds with status code 200, for liveness probes
+//	GET /readyz   responds with status code 200 once the command has completed, and 503
+//	              until then, for readiness and startup probes
+//
+// Both endpoints respond with a HealthResult.
+type Health struct {
+	mu     sync.Mutex
+	result HealthResult
+	mux    *http.ServeMux
+}
+
+// HealthResult is the response to a health request
+type HealthResult struct {
+	// Connected is true once the database is available
+	Connected bool `json:"connected"`
+	// Migrated is true once the command has completed successfully
+	Migrated bool `json:"migrated"`
+	// Error is set if the command failed
+	Error string `json:"error,omitempty"`
+}
+
+// NewHealth returns a Health handler for a command which has not yet connected
+func NewHealth() *Health {
+	h := &Health{mux: http.NewServeMux()}
+	h.mux.HandleFunc("/healthz", h.health(false))
+	h.mux.HandleFunc("/readyz", h.health(true))
+
+	return h
+}
+
+// ServeHTTP routes the request to the requested endpoint
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// Event records the progress of the command, and is intended to be used as (or called
+// from) DB.EventHandler
+func (h *Health) Event(e dbmate.Event) {
+	switch e.(type) {
+	case dbmate.Connected, dbmate.MigrationStarted:
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.result.Connected = true
+	}
+}
+
+// Done records the result of the command
+func (h *Health) Done(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.result.Error = dbutil.RedactPasswords(err.Error())
+		return
+	}
+
+	h.result.Connected = true
+	h.result.Migrated = true
+	h.result.Error = ""
+}
+
+// Result returns the current health of the command
+func (h *Health) Result() HealthResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.result
+}
+
+// health responds with the current health of the command. If ready is true, the
+// status code is 503 until the command has completed.
+func (h *Health) health(ready bool) http.HandlerFunc {
+	return requireMethod(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		result := h.Result()
+		status := http.StatusOK
+		if ready && !result.Migrated {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, status, result)
+	})
+}
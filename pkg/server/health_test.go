@@ -0,0 +1,48 @@
+package server_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	"github.com/amacneil/dbmate/v2/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	health := server.NewHealth()
+	ts := httptest.NewServer(health)
+	t.Cleanup(ts.Close)
+
+	var result server.HealthResult
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodGet, "/healthz", "", &result))
+	require.Equal(t, server.HealthResult{}, result)
+	require.Equal(t, http.StatusServiceUnavailable, request(t, ts, http.MethodGet, "/readyz", "", &result))
+	require.Equal(t, http.StatusMethodNotAllowed, request(t, ts, http.MethodPost, "/readyz", "", nil))
+
+	// waiting for the database reports it as connected
+	db := dbmate.New(dbutil.MustParseURL("sqlite:" + filepath.Join(t.TempDir(), "test.sqlite3")))
+	db.Log = io.Discard
+	db.EventHandler = health.Event
+	require.NoError(t, db.Wait())
+	result = server.HealthResult{}
+	require.Equal(t, http.StatusServiceUnavailable, request(t, ts, http.MethodGet, "/readyz", "", &result))
+	require.Equal(t, server.HealthResult{Connected: true}, result)
+
+	health.Done(errors.New("migration failed: postgres://user:secret@db/app"))
+	result = server.HealthResult{}
+	require.Equal(t, http.StatusServiceUnavailable, request(t, ts, http.MethodGet, "/readyz", "", &result))
+	require.False(t, result.Migrated)
+	require.NotContains(t, result.Error, "secret")
+
+	health.Done(nil)
+	result = server.HealthResult{}
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodGet, "/readyz", "", &result))
+	require.Equal(t, server.HealthResult{Connected: true, Migrated: true}, result)
+}
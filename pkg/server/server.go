@@ -0,0 +1,210 @@
+// Package server exposes dbmate commands over an authenticated HTTP API, so that a
+// central migration runner can be triggered by deployment tooling without giving
+// every pipeline direct database credentials
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+)
+
+// ErrNoToken is returned if the server is created without an API token
+var ErrNoToken = errors.New("an API token is required to serve the dbmate API")
+
+// Server is an http.Handler which runs dbmate commands against a database. Every
+// request must include the API token as a bearer token:
+//
+//	GET  /status    list applied, skipped, and pending migrations
+//	POST /up        create the database (if necessary) and apply pending migrations
+//	POST /rollback  roll back the most recent migration
+//	POST /dump      write the schema file
+//
+// Commands are run one at a time.
+type Server struct {
+	db    *dbmate.DB
+	token string
+	mux   *http.ServeMux
+	mu    sync.Mutex
+}
+
+// Result is the response to a command. Output contains the messages logged while the
+// command ran, and Error is set if the command failed.
+type Result struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StatusResult is the response to a status request
+type StatusResult struct {
+	Environment string            `json:"environment,omitempty"`
+	Migrations  []MigrationStatus `json:"migrations"`
+	Pending     int               `json:"pending"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// MigrationStatus describes a migration file and whether it has been applied
+type MigrationStatus struct {
+	Version  string `json:"version"`
+	FileName string `json:"file_name"`
+	Applied  bool   `json:"applied"`
+	Skipped  bool   `json:"skipped"`
+	// Environments lists the environments a pending migration is restricted to
+	Environments []string `json:"environments,omitempty"`
+	// Phase is the phase of the migration (expand or contract)
+	Phase string `json:"phase"`
+}
+
+// New returns a Server which runs commands using db, authenticating requests with token
+func New(db *dbmate.DB, token string) (*Server, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	s := &Server{db: db, token: token, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/status", requireMethod(http.MethodGet, s.status))
+	s.mux.HandleFunc("/up", requireMethod(http.MethodPost, s.command((*dbmate.DB).CreateAndMigrate)))
+	s.mux.HandleFunc("/rollback", requireMethod(http.MethodPost, s.command((*dbmate.DB).Rollback)))
+	s.mux.HandleFunc("/dump", requireMethod(http.MethodPost, s.command((*dbmate.DB).DumpSchema)))
+
+	return s, nil
+}
+
+// ServeHTTP authenticates the request, then routes it to the requested command
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dbmate"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized returns true if the request has the correct bearer token
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// requireMethod rejects requests which do not use the given HTTP method
+func requireMethod(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// command returns a handler which runs f, and responds with its output
+func (s *Server) command(f func(*dbmate.DB) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// capture output for the response, while still logging it on the server
+		var output bytes.Buffer
+		db := *s.db
+		db.Logger = teeLogger{dbmate.NewWriterLogger(&output, db.Verbose || db.LogLevel == dbmate.LogLevelDebug), serverLogger(s.db)}
+
+		result := Result{}
+		status := http.StatusOK
+		if err := f(&db); err != nil {
+			result.Error = dbutil.RedactPasswords(err.Error())
+			status = http.StatusInternalServerError
+		}
+		result.Output = output.String()
+
+		writeJSON(w, status, result)
+	}
+}
+
+// status responds with the status of each migration
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migrations, err := s.db.FindMigrations()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, StatusResult{
+			Environment: s.db.Environment,
+			Migrations:  []MigrationStatus{},
+			Error:       dbutil.RedactPasswords(err.Error()),
+		})
+		return
+	}
+
+	result := StatusResult{Environment: s.db.Environment, Migrations: []MigrationStatus{}}
+	for _, migration := range migrations {
+		result.Migrations = append(result.Migrations, MigrationStatus{
+			Version:      migration.Version,
+			FileName:     migration.FileName,
+			Applied:      migration.Applied,
+			Skipped:      migration.Skipped,
+			Environments: migration.Environments,
+			Phase:        migration.Phase,
+		})
+		if !migration.Applied && !migration.Skipped {
+			result.Pending++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// serverLogger returns the logger the server's own output is written to
+func serverLogger(db *dbmate.DB) dbmate.Logger {
+	if db.Logger != nil {
+		return db.Logger
+	}
+
+	return dbmate.NewWriterLogger(db.Log, db.Verbose || db.LogLevel == dbmate.LogLevelDebug)
+}
+
+// teeLogger passes each message to several loggers
+type teeLogger []dbmate.Logger
+
+func (l teeLogger) Debugf(format string, args ...interface{}) {
+	for _, logger := range l {
+		logger.Debugf(format, args...)
+	}
+}
+
+func (l teeLogger) Infof(format string, args ...interface{}) {
+	for _, logger := range l {
+		logger.Infof(format, args...)
+	}
+}
+
+func (l teeLogger) Warnf(format string, args ...interface{}) {
+	for _, logger := range l {
+		logger.Warnf(format, args...)
+	}
+}
+
+func (l teeLogger) Errorf(format string, args ...interface{}) {
+	for _, logger := range l {
+		logger.Errorf(format, args...)
+	}
+}
@@ -0,0 +1,118 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
+	"github.com/amacneil/dbmate/v2/pkg/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "secret"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	migrationsDir := filepath.Join(dir, "migrations")
+	require.NoError(t, os.Mkdir(migrationsDir, 0o755))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(migrationsDir, "20200101000000_create_users.sql"),
+		[]byte("-- migrate:up\ncreate table users (id integer);\n\n-- migrate:down\ndrop table users;\n"),
+		0o644,
+	))
+
+	db := dbmate.New(dbutil.MustParseURL("sqlite:" + filepath.Join(dir, "test.sqlite3")))
+	db.Log = io.Discard
+	db.MigrationsDir = []string{migrationsDir}
+	db.SchemaFile = filepath.Join(dir, "schema.sql")
+
+	s, err := server.New(db, testToken)
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func request(t *testing.T, ts *httptest.Server, method, path, token string, v interface{}) int {
+	req, err := http.NewRequest(method, ts.URL+path, nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer dbutil.MustClose(res.Body)
+
+	if v != nil {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(v))
+	}
+
+	return res.StatusCode
+}
+
+func TestNew(t *testing.T) {
+	_, err := server.New(dbmate.New(nil), "")
+	require.ErrorIs(t, err, server.ErrNoToken)
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t)
+
+	require.Equal(t, http.StatusUnauthorized, request(t, ts, http.MethodGet, "/status", "", nil))
+	require.Equal(t, http.StatusUnauthorized, request(t, ts, http.MethodGet, "/status", "wrong", nil))
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodGet, "/status", testToken, nil))
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	ts := newTestServer(t)
+
+	require.Equal(t, http.StatusMethodNotAllowed, request(t, ts, http.MethodGet, "/up", testToken, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, request(t, ts, http.MethodPost, "/status", testToken, nil))
+	require.Equal(t, http.StatusNotFound, request(t, ts, http.MethodPost, "/drop", testToken, nil))
+}
+
+func TestCommands(t *testing.T) {
+	ts := newTestServer(t)
+
+	var status server.StatusResult
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodGet, "/status", testToken, &status))
+	require.Equal(t, 1, status.Pending)
+	require.Len(t, status.Migrations, 1)
+	require.Equal(t, "20200101000000", status.Migrations[0].Version)
+	require.False(t, status.Migrations[0].Applied)
+
+	var result server.Result
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodPost, "/up", testToken, &result))
+	require.Empty(t, result.Error)
+	require.Contains(t, result.Output, "Applying: 20200101000000_create_users.sql")
+
+	status = server.StatusResult{}
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodGet, "/status", testToken, &status))
+	require.Equal(t, 0, status.Pending)
+	require.True(t, status.Migrations[0].Applied)
+
+	result = server.Result{}
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodPost, "/dump", testToken, &result))
+	require.Empty(t, result.Error)
+	require.Contains(t, result.Output, "Writing: ")
+
+	result = server.Result{}
+	require.Equal(t, http.StatusOK, request(t, ts, http.MethodPost, "/rollback", testToken, &result))
+	require.Contains(t, result.Output, "Rolling back: 20200101000000_create_users.sql")
+
+	// nothing left to roll back
+	result = server.Result{}
+	require.Equal(t, http.StatusInternalServerError, request(t, ts, http.MethodPost, "/rollback", testToken, &result))
+	require.Equal(t, "can't rollback: no migrations have been applied", result.Error)
+}
@@ -0,0 +1,161 @@
+// Package testutil creates databases migrated by dbmate for Go integration tests.
+//
+// Each call to NewDatabase creates a uniquely named database, so tests using it may run
+// in parallel:
+//
+//	func TestUsers(t *testing.T) {
+//		t.Parallel()
+//
+//		db := testutil.NewDatabase(t, dbmate.New(dbutil.MustParseURL(os.Getenv("TEST_DATABASE_URL"))))
+//		_, err := db.Exec("insert into users (name) values ('alice')")
+//		require.NoError(t, err)
+//	}
+package testutil
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// defaultDatabaseName is used as the prefix of database names if the URL does not
+// contain a database name
+const defaultDatabaseName = "dbmate_test"
+
+// NewDatabase creates a new database, applies all migrations to it, and returns a
+// connection to it. The database is configured by db (including the migrations
+// directory, FS, and migrations table), but its name has a unique suffix appended, or
+// for SQLite, it is created in a temporary directory. The connection is closed and the
+// database is dropped when the test and all its subtests complete.
+//
+// The schema file is not written, and dbmate's output is written to the test log (with
+// each statement executed if db.Verbose is set).
+func NewDatabase(t testing.TB, db *dbmate.DB) *sql.DB {
+	t.Helper()
+
+	u, err := uniqueURL(t, db.DatabaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test := *db
+	test.DatabaseURL = u
+	test.Connection = nil
+	test.AutoDumpSchema = false
+	test.Logger = testLogger{t: t, debug: db.Verbose}
+
+	drv, err := test.Driver()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the database even if migrations fail, but only once it has been created
+	if err := test.Create(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := drv.DropDatabase(); err != nil {
+			t.Errorf("dropping test database: %s", err)
+		}
+	})
+
+	if err := test.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, err := drv.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cleanup functions run in reverse order, so the connection is closed first
+	t.Cleanup(func() {
+		if err := sqlDB.Close(); err != nil {
+			t.Errorf("closing test database: %s", err)
+		}
+	})
+
+	return sqlDB
+}
+
+// uniqueURL returns the database URL with a unique database name
+func uniqueURL(t testing.TB, u *url.URL) (*url.URL, error) {
+	if u == nil {
+		return nil, dbmate.ErrInvalidURL
+	}
+
+	suffix, err := randomSuffix()
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *u
+	switch u.Scheme {
+	case "sqlite", "sqlite3":
+		clone.Host = ""
+		clone.Path = ""
+		clone.Opaque = filepath.Join(t.TempDir(), "test_"+suffix+".sqlite3")
+	default:
+		query := clone.Query()
+		if name := query.Get("database"); name != "" {
+			// clickhouse accepts the database name as a query parameter
+			query.Set("database", name+"_"+suffix)
+			clone.RawQuery = query.Encode()
+			break
+		}
+
+		// spanner URLs contain the instance before the database name
+		dir, name := path.Split(clone.Path)
+		if dir == "" {
+			dir = "/"
+		}
+		if name == "" {
+			name = defaultDatabaseName
+		}
+		clone.Path = dir + name + "_" + suffix
+		clone.RawPath = ""
+	}
+
+	return &clone, nil
+}
+
+// randomSuffix returns 8 random hex characters
+func randomSuffix() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating database name: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// testLogger writes dbmate's output to the test log, including debug messages if debug
+// is true
+type testLogger struct {
+	t     testing.TB
+	debug bool
+}
+
+func (l testLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		l.t.Logf(format, args...)
+	}
+}
+
+func (l testLogger) Infof(format string, args ...interface{}) {
+	l.t.Logf(format, args...)
+}
+
+func (l testLogger) Warnf(format string, args ...interface{}) {
+	l.t.Logf(format, args...)
+}
+
+func (l testLogger) Errorf(format string, args ...interface{}) {
+	l.t.Logf(format, args...)
+}
@@ -0,0 +1,561 @@
+// Package tui is an interactive terminal interface to dbmate, listing applied and pending
+// migrations, and applying or rolling back the migrations the operator selects
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+)
+
+// ErrNotTerminal is returned if the interface is not run in a terminal
+var ErrNotTerminal = errors.New("dbmate tui must be run in a terminal")
+
+// Views of the interface
+const (
+	viewList   = "list"
+	viewSQL    = "sql"
+	viewOutput = "output"
+)
+
+// outputPaneLines is the number of lines of output shown below the migrations
+const outputPaneLines = 5
+
+// helpText lists the keys of each view
+var helpText = map[string]string{
+	viewList:   "↑/↓ move  space select  a apply  r rollback  enter SQL  o output  R reload  q quit",
+	viewSQL:    "↑/↓ scroll  esc back",
+	viewOutput: "↑/↓ scroll  esc back",
+}
+
+// UI is the state of the interface. Run displays it in a terminal, while key presses and
+// output are passed to HandleKey and Output, which makes it possible to test.
+type UI struct {
+	db         *dbmate.DB
+	migrations []dbmate.Migration
+	// errors are the errors of failed migrations, by version
+	errors   map[string]string
+	selected map[string]bool
+	cursor   int
+	offset   int
+	view     string
+	// title and lines are the name and contents of the SQL being viewed
+	title   string
+	lines   []string
+	scroll  int
+	output  []string
+	status  string
+	running bool
+	// quitting is set if the operator quits while an operation is running, so that the
+	// interface exits once it finishes
+	quitting bool
+}
+
+// New returns the interface for a database
+func New(db *dbmate.DB) *UI {
+	return &UI{
+		db:       db,
+		errors:   map[string]string{},
+		selected: map[string]bool{},
+		view:     viewList,
+	}
+}
+
+// Load reads the migrations and whether they have been applied
+func (ui *UI) Load() error {
+	migrations, err := ui.db.FindMigrations()
+	if err != nil {
+		return err
+	}
+
+	ui.migrations = migrations
+	if ui.cursor >= len(migrations) {
+		ui.cursor = maxInt(len(migrations)-1, 0)
+	}
+
+	return nil
+}
+
+// Key is a key press
+type Key int
+
+// Keys which the interface responds to
+const (
+	KeyNone Key = iota
+	KeyUp
+	KeyDown
+	KeyEnter
+	KeyEscape
+	KeySpace
+	KeyApply
+	KeyRollback
+	KeyOutput
+	KeyReload
+	KeyQuit
+)
+
+// ParseKey returns the key read from a terminal in raw mode
+func ParseKey(b []byte) Key {
+	switch string(b) {
+	case "\x1b[A", "\x1bOA", "k":
+		return KeyUp
+	case "\x1b[B", "\x1bOB", "j":
+		return KeyDown
+	case "\r", "\n":
+		return KeyEnter
+	case "\x1b":
+		return KeyEscape
+	case " ":
+		return KeySpace
+	case "a":
+		return KeyApply
+	case "r":
+		return KeyRollback
+	case "o":
+		return KeyOutput
+	case "R":
+		return KeyReload
+	case "q", "\x03":
+		return KeyQuit
+	}
+
+	return KeyNone
+}
+
+// HandleKey updates the interface after a key press. It returns true if the interface
+// should exit, and the operation to run (applying or rolling back migrations), if any.
+// Other keys are ignored while an operation is running, and quitting is deferred until it
+// finishes, since a migration interrupted part way may leave the database inconsistent.
+func (ui *UI) HandleKey(k Key) (bool, func() error) {
+	if ui.running {
+		if k == KeyQuit {
+			ui.quitting = true
+		}
+		return false, nil
+	}
+	if k == KeyQuit && ui.view == viewList {
+		return true, nil
+	}
+
+	switch ui.view {
+	case viewSQL, viewOutput:
+		switch k {
+		case KeyUp:
+			ui.scroll = maxInt(ui.scroll-1, 0)
+		case KeyDown:
+			ui.scroll++
+		case KeyEscape, KeyEnter, KeyQuit:
+			ui.view = viewList
+		}
+		return false, nil
+	}
+
+	switch k {
+	case KeyUp:
+		ui.cursor = maxInt(ui.cursor-1, 0)
+	case KeyDown:
+		ui.cursor = minInt(ui.cursor+1, len(ui.migrations)-1)
+	case KeySpace:
+		if migration := ui.current(); migration != nil {
+			ui.selected[migration.Version] = !ui.selected[migration.Version]
+		}
+	case KeyEnter:
+		ui.showSQL()
+	case KeyOutput:
+		ui.view = viewOutput
+		ui.scroll = maxInt(len(ui.output)-1, 0)
+	case KeyReload:
+		if err := ui.Load(); err != nil {
+			ui.status = "Error: " + err.Error()
+		}
+	case KeyApply:
+		return false, ui.operation(false)
+	case KeyRollback:
+		return false, ui.operation(true)
+	}
+
+	return false, nil
+}
+
+// current returns the migration under the cursor
+func (ui *UI) current() *dbmate.Migration {
+	if ui.cursor < 0 || ui.cursor >= len(ui.migrations) {
+		return nil
+	}
+
+	return &ui.migrations[ui.cursor]
+}
+
+// showSQL switches to the SQL of the migration under the cursor
+func (ui *UI) showSQL() {
+	migration := ui.current()
+	if migration == nil {
+		return
+	}
+
+	parsed, err := migration.Parse()
+	if err != nil {
+		ui.status = "Error: " + err.Error()
+		return
+	}
+
+	ui.title = migration.FileName
+	ui.lines = strings.Split(strings.TrimRight("-- migrate:up\n"+parsed.Up+"\n-- migrate:down\n"+parsed.Down, "\n"), "\n")
+	ui.scroll = 0
+	ui.view = viewSQL
+}
+
+// operation returns a function applying (or rolling back) the selected migrations, or
+// the migration under the cursor if none are selected. Migrations are applied in order,
+// and rolled back in reverse order, stopping at the first which fails.
+func (ui *UI) operation(rollback bool) func() error {
+	targets := []dbmate.Migration{}
+	for i, migration := range ui.migrations {
+		if ui.selected[migration.Version] || len(ui.selected) == 0 && i == ui.cursor {
+			if migration.Applied == rollback && !migration.Skipped {
+				targets = append(targets, migration)
+			}
+		}
+	}
+	if len(targets) == 0 {
+		if rollback {
+			ui.status = "No applied migrations selected"
+		} else {
+			ui.status = "No pending migrations selected"
+		}
+		return nil
+	}
+
+	if rollback {
+		for i, j := 0, len(targets)-1; i < j; i, j = i+1, j-1 {
+			targets[i], targets[j] = targets[j], targets[i]
+		}
+	}
+
+	ui.running = true
+	ui.status = ""
+	for _, migration := range targets {
+		delete(ui.errors, migration.Version)
+	}
+
+	return func() error {
+		for _, migration := range targets {
+			var err error
+			if rollback {
+				err = ui.db.RollbackVersion(migration.Version)
+			} else {
+				err = ui.db.MigrateVersion(migration.Version)
+			}
+			if err != nil {
+				return &migrationError{version: migration.Version, err: err}
+			}
+		}
+		return nil
+	}
+}
+
+// migrationError is returned by an operation when a migration fails
+type migrationError struct {
+	version string
+	err     error
+}
+
+func (e *migrationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *migrationError) Unwrap() error {
+	return e.err
+}
+
+// Finished records the result of an operation, and reloads the migrations. It returns
+// true if the operator quit while the operation was running, in which case the interface
+// should exit.
+func (ui *UI) Finished(err error) bool {
+	ui.running = false
+	ui.selected = map[string]bool{}
+
+	var migrationErr *migrationError
+	switch {
+	case errors.As(err, &migrationErr):
+		ui.errors[migrationErr.version] = migrationErr.err.Error()
+		ui.status = "Error: " + migrationErr.err.Error()
+	case err != nil:
+		ui.status = "Error: " + err.Error()
+	default:
+		ui.status = "Done"
+	}
+
+	if err := ui.Load(); err != nil {
+		ui.status = "Error: " + err.Error()
+	}
+
+	return ui.quitting
+}
+
+// Output adds a line of output from an operation
+func (ui *UI) Output(line string) {
+	ui.output = append(ui.output, line)
+}
+
+// Render returns the interface drawn for a terminal of the given size, with lines
+// separated by \r\n as required in raw mode
+func (ui *UI) Render(width, height int) string {
+	height = maxInt(height, 2)
+	lines := []string{}
+	switch ui.view {
+	case viewSQL:
+		lines = append(lines, bold(ui.title), "")
+		lines = append(lines, ui.page(ui.lines, height-3)...)
+	case viewOutput:
+		lines = append(lines, bold("Output"), "")
+		lines = append(lines, ui.page(ui.output, height-3)...)
+	default:
+		lines = ui.renderList(height)
+	}
+
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	footer := helpText[ui.view]
+	if ui.running && ui.quitting {
+		footer = "Quitting once the running migrations finish..."
+	} else if ui.running {
+		footer = "Running... (q to quit once finished)"
+	} else if ui.status != "" && ui.view == viewList {
+		footer = ui.status
+	}
+	lines = append(lines[:height-1], inverse(footer))
+
+	for i, line := range lines {
+		lines[i] = truncate(line, width)
+	}
+
+	return strings.Join(lines, "\r\n")
+}
+
+// renderList draws the migrations, followed by the last lines of output
+func (ui *UI) renderList(height int) []string {
+	applied, pending := 0, 0
+	for _, migration := range ui.migrations {
+		if migration.Applied {
+			applied++
+		} else {
+			pending++
+		}
+	}
+
+	name := ""
+	if ui.db.DatabaseURL != nil {
+		name = ui.db.DatabaseURL.Redacted()
+	}
+	lines := []string{bold("dbmate " + name), fmt.Sprintf("Applied: %d  Pending: %d", applied, pending), ""}
+
+	// keep the cursor visible
+	listHeight := maxInt(height-len(lines)-outputPaneLines-2, 1)
+	if ui.cursor < ui.offset {
+		ui.offset = ui.cursor
+	}
+	if ui.cursor >= ui.offset+listHeight {
+		ui.offset = ui.cursor - listHeight + 1
+	}
+
+	rows := []string{}
+	for i, migration := range ui.migrations {
+		if i < ui.offset {
+			continue
+		}
+
+		cursor, selected := " ", " "
+		if i == ui.cursor {
+			cursor = ">"
+		}
+		if ui.selected[migration.Version] {
+			selected = "*"
+		}
+		state := "[ ]"
+		switch {
+		case migration.Applied:
+			state = "[X]"
+		case migration.Skipped:
+			state = "[-]"
+		}
+
+		row := cursor + selected + state + " " + migration.FileName
+		if i == ui.cursor {
+			row = inverse(row)
+		}
+		rows = append(rows, row)
+		if err, ok := ui.errors[migration.Version]; ok {
+			rows = append(rows, red("      "+err))
+		}
+		if len(rows) >= listHeight {
+			break
+		}
+	}
+	for len(rows) < listHeight {
+		rows = append(rows, "")
+	}
+	lines = append(lines, rows[:listHeight]...)
+
+	lines = append(lines, bold("Output"))
+	output := ui.output[maxInt(len(ui.output)-outputPaneLines, 0):]
+	return append(lines, output...)
+}
+
+// page returns the lines visible from the scroll position, which is limited so that the
+// last page is full
+func (ui *UI) page(lines []string, height int) []string {
+	ui.scroll = maxInt(minInt(ui.scroll, len(lines)-height), 0)
+	end := minInt(ui.scroll+height, len(lines))
+
+	return lines[ui.scroll:end]
+}
+
+// truncate shortens a line to a number of characters, keeping escape sequences
+func truncate(line string, width int) string {
+	var b strings.Builder
+	n := 0
+	escape := false
+	for _, r := range line {
+		switch {
+		case r == '\x1b':
+			escape = true
+		case escape:
+			escape = r != 'm'
+		case n >= width:
+			continue
+		default:
+			n++
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func bold(s string) string {
+	return "\x1b[1m" + s + "\x1b[0m"
+}
+
+func inverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
+
+func red(s string) string {
+	return "\x1b[31m" + s + "\x1b[0m"
+}
+
+// Run displays the interface in a terminal until the operator quits. While it runs, the
+// output of dbmate is shown in the interface instead of being written to db.Log, and
+// db.EventHandler is not called.
+func Run(db *dbmate.DB, in, out *os.File) error {
+	if !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return ErrNotTerminal
+	}
+
+	ui := New(db)
+	if err := ui.Load(); err != nil {
+		return err
+	}
+
+	// output is buffered, so that logging rarely waits for the interface to be redrawn
+	lines := make(chan string, 256)
+	logger, eventHandler := db.Logger, db.EventHandler
+	db.Logger = dbmate.NewWriterLogger(lineWriter(lines), db.Verbose || db.LogLevel == dbmate.LogLevelDebug)
+	db.EventHandler = nil
+	defer func() { db.Logger, db.EventHandler = logger, eventHandler }()
+
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = term.Restore(int(in.Fd()), state) }()
+
+	// use the alternate screen, so the terminal is restored on exit
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan Key)
+	go readKeys(in, keys)
+
+	draw := func() {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		fmt.Fprint(out, "\x1b[H\x1b[2J"+ui.Render(width, height))
+	}
+
+	// HandleKey only quits while no operation is running, so the loop never returns
+	// before a running operation has sent its result on done
+	done := make(chan error, 1)
+	for {
+		draw()
+		select {
+		case k := <-keys:
+			quit, op := ui.HandleKey(k)
+			if quit {
+				return nil
+			}
+			if op != nil {
+				go func() { done <- op() }()
+			}
+		case line := <-lines:
+			ui.Output(line)
+		case err := <-done:
+			// show the output logged before the operation returned
+			for len(lines) > 0 {
+				ui.Output(<-lines)
+			}
+			if ui.Finished(err) {
+				return err
+			}
+		}
+	}
+}
+
+// readKeys sends each key pressed to keys
+func readKeys(in io.Reader, keys chan<- Key) {
+	buf := make([]byte, 16)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		if k := ParseKey(buf[:n]); k != KeyNone {
+			keys <- k
+		}
+	}
+}
+
+// lineWriter sends each line written to it to a channel
+type lineWriter chan<- string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w <- line
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,147 @@
+package tui_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amacneil/dbmate/v2/pkg/dbmate"
+	"github.com/amacneil/dbmate/v2/pkg/dbutil"
+	_ "github.com/amacneil/dbmate/v2/pkg/driver/sqlite"
+	"github.com/amacneil/dbmate/v2/pkg/tui"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUI(t *testing.T) *tui.UI {
+	dir := t.TempDir()
+	migrationsDir := filepath.Join(dir, "migrations")
+	require.NoError(t, os.Mkdir(migrationsDir, 0o755))
+	for name, contents := range map[string]string{
+		"20200101000000_create_users.sql": "-- migrate:up\ncreate table users (id integer);\n\n-- migrate:down\ndrop table users;\n",
+		"20200102000000_create_posts.sql": "-- migrate:up\ncreate table posts (id integer);\n\n-- migrate:down\ndrop table posts;\n",
+		"20200103000000_broken.sql":       "-- migrate:up\ncreate tabel broken (id integer);\n\n-- migrate:down\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(migrationsDir, name), []byte(contents), 0o644))
+	}
+
+	db := dbmate.New(dbutil.MustParseURL("sqlite:" + filepath.Join(dir, "test.sqlite3")))
+	db.Log = io.Discard
+	db.MigrationsDir = []string{migrationsDir}
+	db.AutoDumpSchema = false
+	require.NoError(t, db.Create())
+
+	ui := tui.New(db)
+	require.NoError(t, ui.Load())
+
+	return ui
+}
+
+// run handles the keys, and runs the operation started by the last key, if any
+func run(ui *tui.UI, keys ...tui.Key) {
+	for _, k := range keys {
+		if _, op := ui.HandleKey(k); op != nil {
+			ui.Finished(op())
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	require.Equal(t, tui.KeyUp, tui.ParseKey([]byte("\x1b[A")))
+	require.Equal(t, tui.KeyDown, tui.ParseKey([]byte("j")))
+	require.Equal(t, tui.KeyEnter, tui.ParseKey([]byte("\r")))
+	require.Equal(t, tui.KeyEscape, tui.ParseKey([]byte("\x1b")))
+	require.Equal(t, tui.KeyQuit, tui.ParseKey([]byte("\x03")))
+	require.Equal(t, tui.KeyNone, tui.ParseKey([]byte("x")))
+}
+
+func TestApplyAndRollback(t *testing.T) {
+	ui := newTestUI(t)
+	screen := ui.Render(80, 24)
+	require.Contains(t, screen, "Applied: 0  Pending: 3")
+	require.Contains(t, screen, "[ ] 20200101000000_create_users.sql")
+
+	// select the first two migrations and apply them
+	run(ui, tui.KeySpace, tui.KeyDown, tui.KeySpace, tui.KeyApply)
+	screen = ui.Render(80, 24)
+	require.Contains(t, screen, "Applied: 2  Pending: 1")
+	require.Contains(t, screen, "[X] 20200101000000_create_users.sql")
+	require.Contains(t, screen, "[X] 20200102000000_create_posts.sql")
+	require.Contains(t, screen, "Done")
+
+	// a failing migration shows its error inline
+	run(ui, tui.KeyDown, tui.KeyApply)
+	screen = ui.Render(80, 24)
+	require.Contains(t, screen, "[ ] 20200103000000_broken.sql")
+	require.Contains(t, screen, "syntax error")
+
+	// the migration under the cursor is rolled back if none are selected
+	run(ui, tui.KeyUp, tui.KeyUp, tui.KeyRollback)
+	screen = ui.Render(80, 24)
+	require.Contains(t, screen, "[ ] 20200101000000_create_users.sql")
+	require.Contains(t, screen, "[X] 20200102000000_create_posts.sql")
+
+	// pending migrations can't be rolled back
+	run(ui, tui.KeyRollback)
+	require.Contains(t, ui.Render(80, 24), "No applied migrations selected")
+}
+
+func TestQuitWhileRunning(t *testing.T) {
+	ui := newTestUI(t)
+
+	quit, op := ui.HandleKey(tui.KeyApply)
+	require.False(t, quit)
+	require.NotNil(t, op)
+
+	// quitting waits for the operation to finish
+	quit, _ = ui.HandleKey(tui.KeyQuit)
+	require.False(t, quit)
+	require.Contains(t, ui.Render(80, 24), "Quitting once the running migrations finish")
+	require.True(t, ui.Finished(op()))
+	require.Contains(t, ui.Render(80, 24), "Applied: 1  Pending: 2")
+}
+
+func TestViewSQL(t *testing.T) {
+	ui := newTestUI(t)
+
+	run(ui, tui.KeyEnter)
+	screen := ui.Render(80, 24)
+	require.Contains(t, screen, "20200101000000_create_users.sql")
+	require.Contains(t, screen, "create table users (id integer);")
+	require.Contains(t, screen, "-- migrate:down\r\ndrop table users;")
+
+	run(ui, tui.KeyEscape)
+	require.Contains(t, ui.Render(80, 24), "Applied: 0")
+}
+
+func TestOutput(t *testing.T) {
+	ui := newTestUI(t)
+	for i := 0; i < 10; i++ {
+		ui.Output("line " + strings.Repeat("x", i))
+	}
+
+	// the last lines are shown below the migrations
+	screen := ui.Render(80, 24)
+	require.NotContains(t, screen, "line xxxx\r\n")
+	require.Contains(t, screen, "line xxxxxxxxx")
+
+	run(ui, tui.KeyOutput)
+	screen = ui.Render(80, 24)
+	require.Contains(t, screen, "line \r\n")
+	require.Contains(t, screen, "line xxxxxxxxx")
+}
+
+func TestRenderSize(t *testing.T) {
+	ui := newTestUI(t)
+
+	for _, height := range []int{0, 5, 24} {
+		lines := strings.Split(ui.Render(20, height), "\r\n")
+		if height < 2 {
+			height = 2
+		}
+		require.Len(t, lines, height)
+	}
+	require.NotContains(t, ui.Render(20, 24), "create_users.sql")
+}